@@ -0,0 +1,117 @@
+package bandwidth
+
+import (
+	"sync"
+	"time"
+)
+
+// Level is a step of the adaptive quality ladder
+type Level struct {
+	Quality int     // JPEG quality, hardware encoders map it to a bitrate
+	Scale   float64 // Resolution relative to the monitor's
+	Divisor int     // Only every Divisor-th captured frame is sent
+}
+
+// Ladder are the levels a client's video steps through as its connection
+// gets worse: quality drops first, then resolution, then frame rate, the
+// order in which viewers notice the loss least
+var Ladder = []Level{
+	{Quality: MaxQuality, Scale: 1, Divisor: 1},
+	{Quality: 75, Scale: 1, Divisor: 1},
+	{Quality: 60, Scale: 1, Divisor: 1},
+	{Quality: 45, Scale: 1, Divisor: 1},
+	{Quality: 45, Scale: 0.75, Divisor: 1},
+	{Quality: 40, Scale: 0.5, Divisor: 1},
+	{Quality: 40, Scale: 0.5, Divisor: 2},
+	{Quality: 40, Scale: 0.5, Divisor: 4},
+}
+
+const (
+	// stepDownInterval is the shortest time between two steps down the
+	// ladder, so a single congestion episode isn't answered several times
+	// before the first step takes effect
+	stepDownInterval = 500 * time.Millisecond
+
+	// stepUpInterval is how long a connection must stay clear before the
+	// next step up, probing slowly so the level doesn't oscillate
+	stepUpInterval = 3 * time.Second
+
+	// fullWindow is the share of the congestion window above which the
+	// send queue counts as backed up
+	fullWindow = 0.9
+)
+
+// Signals are what an Adapter learns about a client's connection
+type Signals struct {
+	RTT, MinRTT time.Duration
+	Inflight    int     // Unacknowledged video bytes
+	Window      float64 // Unacknowledged video bytes the controller allows
+	Skipped     uint64  // Frames skipped for a full window so far
+}
+
+// Adapter moves a client's video along the Ladder: down when the
+// connection shows congestion, queueing delay, a backed up send queue or
+// frames skipped or lost, and back up once it has been clear for a while
+type Adapter struct {
+	mutex     sync.Mutex
+	level     int
+	changed   time.Time // Last step in either direction
+	congested time.Time // Last update that showed congestion
+	skipped   uint64
+	dropped   uint64 // Frames the client reported lost
+	reported  bool   // Whether dropped is set
+}
+
+// NewAdapter creates an adapter starting at the top of the ladder
+func NewAdapter() *Adapter {
+	return &Adapter{}
+}
+
+// Update takes the latest signals and steps the level if needed
+func (a *Adapter) Update(s Signals) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+
+	congested := s.MinRTT > 0 && s.RTT-s.MinRTT > targetDelay ||
+		s.Window > 0 && float64(s.Inflight) > s.Window*fullWindow ||
+		s.Skipped > a.skipped
+	a.skipped = s.Skipped
+
+	switch {
+	case congested:
+		a.congest(now)
+	case a.level > 0 && now.Sub(a.congested) >= stepUpInterval && now.Sub(a.changed) >= stepUpInterval:
+		a.level--
+		a.changed = now
+	}
+}
+
+// Report takes the client's count of frames lost in transit. The first
+// count is the baseline, it includes the losses of earlier connections.
+func (a *Adapter) Report(dropped uint64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	lost := a.reported && dropped > a.dropped
+	a.dropped, a.reported = dropped, true
+	if lost {
+		a.congest(time.Now())
+	}
+}
+
+// congest steps down after congestion, at most once per stepDownInterval
+func (a *Adapter) congest(now time.Time) {
+	a.congested = now
+	if a.level < len(Ladder)-1 && now.Sub(a.changed) >= stepDownInterval {
+		a.level++
+		a.changed = now
+	}
+}
+
+// Level returns the index of the current level on the Ladder
+func (a *Adapter) Level() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.level
+}
@@ -0,0 +1,221 @@
+// Package bandwidth shares a session's bandwidth between interactive
+// traffic (video and input) and bulk traffic such as file transfers, so
+// bulk traffic never pushes the latency of the video past its target, and
+// paces the video itself to the bandwidth the receiver acknowledges
+package bandwidth
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBulkShare is the share of the estimated capacity bulk
+	// transfers may use together
+	DefaultBulkShare = 0.3
+
+	// minCapacity is the capacity assumed before anything was measured,
+	// in bytes per second (1 Mbit/s)
+	minCapacity = 125000
+
+	// congestedWrite is the duration above which a write is considered
+	// limited by the network rather than by the socket buffer
+	congestedWrite = 2 * time.Millisecond
+
+	// estimateWeight is the weight of a new measurement in the estimate
+	estimateWeight = 0.2
+
+	// bulkBurst is how much of the bulk budget may be sent at once
+	bulkBurst = 100 * time.Millisecond
+
+	// bulkChunk is the size bulk writes are split into, so a large write
+	// never occupies the link for long
+	bulkChunk = 16 * 1024
+)
+
+// Scheduler estimates the capacity of a connection from the writes made
+// to it and throttles bulk transfers to a share of that capacity
+type Scheduler struct {
+	mutex     sync.Mutex
+	bulkShare float64
+	capacity  float64 // Estimated capacity in bytes per second
+
+	tokens     float64   // Bulk bytes that may be sent right away
+	lastRefill time.Time // When tokens were last topped up
+	throttled  time.Time // Last time a bulk write had to wait
+	acked      bool      // Capacity comes from acknowledgements, see SetCapacity
+
+	transfers map[*Transfer]struct{}
+}
+
+// State is what the scheduler is currently doing, for the stats output
+type State struct {
+	CapacityKbps  float64 `json:"capacity_kbps"`
+	BulkLimitKbps float64 `json:"bulk_limit_kbps"`
+	Transfers     int     `json:"transfers"`
+	Throttled     bool    `json:"throttled"`          // A transfer waited for budget in the last second
+	Progress      float64 `json:"progress,omitempty"` // Fraction of the active transfers' bytes sent
+
+	Congestion *CongestionState `json:"congestion,omitempty"` // Video congestion control, if any
+}
+
+// Transfer is a bulk transfer throttled to the scheduler's bulk budget
+type Transfer struct {
+	scheduler *Scheduler
+	writer    io.Writer
+	total     int64 // Expected size, 0 if unknown
+	sent      int64
+}
+
+// NewScheduler creates a scheduler letting bulk transfers use bulkShare
+// (0-1) of the estimated capacity
+func NewScheduler(bulkShare float64) *Scheduler {
+	if bulkShare <= 0 || bulkShare > 1 {
+		bulkShare = DefaultBulkShare
+	}
+	return &Scheduler{
+		bulkShare:  bulkShare,
+		capacity:   minCapacity,
+		lastRefill: time.Now(),
+		transfers:  make(map[*Transfer]struct{}),
+	}
+}
+
+// ObserveWrite feeds a write of n bytes that took d into the capacity
+// estimate. Writes that return quickly went into the socket buffer and say
+// nothing about the link, so only slow writes are measured.
+func (s *Scheduler) ObserveWrite(n int, d time.Duration) {
+	if d < congestedWrite || n <= 0 {
+		return
+	}
+	rate := float64(n) / d.Seconds()
+
+	s.mutex.Lock()
+	if s.acked {
+		s.mutex.Unlock()
+		return
+	}
+	s.capacity += estimateWeight * (rate - s.capacity)
+	if s.capacity < minCapacity {
+		s.capacity = minCapacity
+	}
+	s.mutex.Unlock()
+}
+
+// SetCapacity sets the capacity from a better source than write timings,
+// such as the congestion controller's acknowledged delivery rate. Writes
+// are no longer observed afterwards.
+func (s *Scheduler) SetCapacity(bytesPerSecond float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.acked = true
+	s.capacity = bytesPerSecond
+	if s.capacity < minCapacity {
+		s.capacity = minCapacity
+	}
+}
+
+// Capacity returns the estimated capacity in bytes per second
+func (s *Scheduler) Capacity() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.capacity
+}
+
+// StartTransfer returns a writer that sends to w within the bulk budget.
+// total is the expected size for progress reporting, 0 if unknown. The
+// transfer counts as active until it is closed.
+func (s *Scheduler) StartTransfer(w io.Writer, total int64) *Transfer {
+	t := &Transfer{scheduler: s, writer: w, total: total}
+	s.mutex.Lock()
+	s.transfers[t] = struct{}{}
+	s.mutex.Unlock()
+	return t
+}
+
+// State returns the current estimate, budget and transfer progress
+func (s *Scheduler) State() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state := State{
+		CapacityKbps:  s.capacity * 8 / 1000,
+		BulkLimitKbps: s.capacity * s.bulkShare * 8 / 1000,
+		Transfers:     len(s.transfers),
+		Throttled:     time.Since(s.throttled) < time.Second,
+	}
+
+	var sent, total int64
+	for t := range s.transfers {
+		if t.total > 0 {
+			sent += t.sent
+			total += t.total
+		}
+	}
+	if total > 0 {
+		state.Progress = float64(sent) / float64(total)
+	}
+	return state
+}
+
+// reserve takes n bytes from the bulk budget and returns how long the
+// caller must wait before sending them
+func (s *Scheduler) reserve(n int) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rate := s.capacity * s.bulkShare
+	now := time.Now()
+	s.tokens += now.Sub(s.lastRefill).Seconds() * rate
+	if burst := rate * bulkBurst.Seconds(); s.tokens > burst {
+		s.tokens = burst
+	}
+	s.lastRefill = now
+
+	// Tokens may go negative, later writers queue behind the debt
+	s.tokens -= float64(n)
+	if s.tokens >= 0 {
+		return 0
+	}
+	s.throttled = now
+	return time.Duration(-s.tokens / rate * float64(time.Second))
+}
+
+// Write sends p in chunks, waiting for bulk budget before each chunk
+func (t *Transfer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > bulkChunk {
+			chunk = chunk[:bulkChunk]
+		}
+
+		if wait := t.scheduler.reserve(len(chunk)); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		start := time.Now()
+		n, err := t.writer.Write(chunk)
+		t.scheduler.ObserveWrite(n, time.Since(start))
+
+		written += n
+		t.scheduler.mutex.Lock()
+		t.sent += int64(n)
+		t.scheduler.mutex.Unlock()
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close ends the transfer, the underlying writer is left open
+func (t *Transfer) Close() error {
+	t.scheduler.mutex.Lock()
+	delete(t.scheduler.transfers, t)
+	t.scheduler.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,166 @@
+package bandwidth
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTransferThrottledToBulkShare(t *testing.T) {
+	s := NewScheduler(0.5)
+	s.capacity = 1 << 20 // 1 MiB/s, so the bulk budget is 512 KiB/s
+
+	var buf bytes.Buffer
+	transfer := s.StartTransfer(&buf, 256*1024)
+	start := time.Now()
+	if _, err := transfer.Write(make([]byte, 256*1024)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	// 256 KiB at 512 KiB/s minus the initial burst takes around 400ms
+	if elapsed < 300*time.Millisecond {
+		t.Fatalf("transfer took %v, it was not throttled", elapsed)
+	}
+	state := s.State()
+	if !state.Throttled || state.Transfers != 1 || state.Progress != 1 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	transfer.Close()
+	if state := s.State(); state.Transfers != 0 {
+		t.Fatalf("transfer still active after Close: %+v", state)
+	}
+}
+
+func TestObserveWriteIgnoresBufferedWrites(t *testing.T) {
+	s := NewScheduler(DefaultBulkShare)
+	s.ObserveWrite(1<<20, time.Microsecond)
+	if s.Capacity() != minCapacity {
+		t.Fatalf("buffered write changed the estimate to %v", s.Capacity())
+	}
+
+	s.ObserveWrite(1<<20, 100*time.Millisecond)
+	if s.Capacity() <= minCapacity {
+		t.Fatalf("slow write did not raise the estimate: %v", s.Capacity())
+	}
+}
+
+func TestControllerSkipsFramesWhileWindowFull(t *testing.T) {
+	c := NewController()
+	c.OnSent(512 * 1024) // Far beyond the initial window
+	if c.CanSend() {
+		t.Fatal("frame allowed with a full window")
+	}
+
+	c.OnAck(512 * 1024)
+	if !c.CanSend() {
+		t.Fatal("frame skipped after everything was acknowledged")
+	}
+	if state := c.State(); state.Skipped != 1 || state.InflightKB != 0 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+}
+
+func TestControllerBacksOffOnQueueingDelay(t *testing.T) {
+	c := NewController()
+	c.OnSent(1000)
+	c.OnAck(1000) // Establishes the minimum RTT
+
+	c.OnSent(1000)
+	time.Sleep(targetDelay + 20*time.Millisecond)
+	c.OnAck(2000)
+
+	if bw := c.Bandwidth(); bw >= initialRate {
+		t.Fatalf("estimate %v not reduced after queueing delay", bw)
+	}
+}
+
+func TestLimiterLowersQualityThenFrameRate(t *testing.T) {
+	l := NewLimiter(100 * time.Millisecond)
+	l.SetLimit(50000) // 5 frames of 20000 bytes per second at most
+
+	for i := 0; i < 50; i++ {
+		l.Observe(20000)
+	}
+	if l.Quality() != MinQuality {
+		t.Fatalf("quality %d, want %d", l.Quality(), MinQuality)
+	}
+	if interval := l.Interval(); interval < 400*time.Millisecond {
+		t.Fatalf("interval %v allows more than the limit", interval)
+	}
+
+	l.SetLimit(0)
+	if l.Quality() != MaxQuality || l.Interval() != 100*time.Millisecond {
+		t.Fatalf("removing the limit left quality %d, interval %v", l.Quality(), l.Interval())
+	}
+
+	l.SetMinInterval(16 * time.Millisecond)
+	if l.Interval() != 16*time.Millisecond {
+		t.Fatalf("interval %v after raising the frame rate, want 16ms", l.Interval())
+	}
+}
+
+func TestAdapterStepsDownAndRecovers(t *testing.T) {
+	a := NewAdapter()
+	a.Update(Signals{Skipped: 1})
+	if a.Level() != 1 {
+		t.Fatalf("level %d after congestion, want 1", a.Level())
+	}
+
+	// Further congestion right away doesn't step again
+	a.Update(Signals{Skipped: 2})
+	if a.Level() != 1 {
+		t.Fatalf("level %d, stepped down twice within %v", a.Level(), stepDownInterval)
+	}
+
+	// Queueing delay is congestion too
+	a.changed = a.changed.Add(-stepDownInterval)
+	a.Update(Signals{Skipped: 2, RTT: 200 * time.Millisecond, MinRTT: 20 * time.Millisecond})
+	if a.Level() != 2 {
+		t.Fatalf("level %d after queueing delay, want 2", a.Level())
+	}
+
+	// A clear connection steps up only after a while
+	a.Update(Signals{Skipped: 2, RTT: 20 * time.Millisecond, MinRTT: 20 * time.Millisecond})
+	if a.Level() != 2 {
+		t.Fatalf("level %d, stepped up right after congestion", a.Level())
+	}
+	a.changed = a.changed.Add(-stepUpInterval)
+	a.congested = a.congested.Add(-stepUpInterval)
+	a.Update(Signals{Skipped: 2, RTT: 20 * time.Millisecond, MinRTT: 20 * time.Millisecond})
+	if a.Level() != 1 {
+		t.Fatalf("level %d after a clear period, want 1", a.Level())
+	}
+
+	// Losses count from the first report on
+	a.changed = a.changed.Add(-stepDownInterval)
+	a.Report(10)
+	if a.Level() != 1 {
+		t.Fatalf("level %d, the first report counted as losses", a.Level())
+	}
+	a.Report(11)
+	if a.Level() != 2 {
+		t.Fatalf("level %d after a reported loss, want 2", a.Level())
+	}
+}
+
+func TestPacedWritesObservedWithoutPacing(t *testing.T) {
+	c := NewController()
+	var observed time.Duration
+	c.ObserveWrites(func(n int, d time.Duration) { observed += d })
+
+	// About 150 ms at the initial pacing rate, spent waiting rather than
+	// writing to a writer that never blocks
+	start := time.Now()
+	if _, err := c.Pace(io.Discard).Write(make([]byte, 500*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("write took %v, not paced", elapsed)
+	}
+	if observed >= congestedWrite {
+		t.Fatalf("observed %v of writing, the pacing waits were counted", observed)
+	}
+}
@@ -0,0 +1,341 @@
+package bandwidth
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// initialRate is the bandwidth assumed before the first delivery
+	// sample, in bytes per second (20 Mbit/s)
+	initialRate = 2500000
+
+	// pacingGain lets video go out slightly faster than the estimate, so
+	// the estimate can grow when the link has spare capacity
+	pacingGain = 1.25
+
+	// targetDelay is the queueing delay above which the link is considered
+	// congested
+	targetDelay = 50 * time.Millisecond
+
+	// backoffFactor is applied to the estimate on congestion or loss
+	backoffFactor = 0.85
+
+	// sampleInterval is the minimum period a delivery sample is taken over
+	sampleInterval = 100 * time.Millisecond
+
+	// minRTTWindow is how long the minimum RTT is kept before it expires,
+	// so route changes are picked up
+	minRTTWindow = 10 * time.Second
+
+	// minWindowRTT is the smallest RTT the send window is sized for
+	minWindowRTT = 20 * time.Millisecond
+
+	// minWindow is the smallest amount of unacknowledged video allowed
+	minWindow = 64 * 1024
+
+	// lossTimeout is how long a frame may stay unacknowledged before it is
+	// considered lost
+	lossTimeout = time.Second
+
+	// paceBurst is how much of the pacing budget may be sent at once
+	paceBurst = 5 * time.Millisecond
+)
+
+// Controller is the congestion control of a video connection. The
+// receiver acknowledges the video bytes it got, the controller derives the
+// delivery rate and queueing delay from the acknowledgements and paces
+// frames to the estimated bandwidth. Frames are skipped rather than
+// queued while too much video is unacknowledged, so a slow link shows
+// fewer but current frames.
+type Controller struct {
+	mutex sync.Mutex
+
+	sent        uint64      // Video bytes sent
+	acked       uint64      // Video bytes the receiver acknowledged
+	lost        uint64      // Video bytes given up on
+	outstanding []sentFrame // Unacknowledged frames, oldest first
+	inflight    int         // Bytes of the outstanding frames
+	skipped     uint64      // Frames skipped because the window was full
+
+	bandwidth    float64   // Estimated bottleneck rate in bytes per second
+	sampleStart  time.Time // Start of the current delivery sample
+	sampleAcked  uint64    // Acknowledged bytes at the start of the sample
+	rtt          time.Duration
+	minRTT       time.Duration
+	minRTTAt     time.Time
+	lastBackoff  time.Time
+	paceTokens   float64
+	paceRefilled time.Time
+
+	observeWrite func(n int, d time.Duration) // See ObserveWrites
+}
+
+// sentFrame is a frame waiting for acknowledgement
+type sentFrame struct {
+	at   time.Time // When the last byte was written
+	end  uint64    // Sent bytes including this frame
+	size int
+}
+
+// CongestionState is what the controller currently estimates, for the
+// stats output
+type CongestionState struct {
+	BandwidthKbps float64 `json:"bandwidth_kbps"`
+	PacingKbps    float64 `json:"pacing_kbps"`
+	RTTMs         float64 `json:"rtt_ms"`
+	MinRTTMs      float64 `json:"min_rtt_ms"`
+	InflightKB    float64 `json:"inflight_kb"`
+	Skipped       uint64  `json:"skipped_frames"`
+}
+
+// NewController creates a controller starting from a conservative
+// bandwidth estimate
+func NewController() *Controller {
+	now := time.Now()
+	return &Controller{
+		bandwidth:    initialRate,
+		paceRefilled: now,
+	}
+}
+
+// CanSend reports whether a new frame may be sent. It returns false, and
+// counts the frame as skipped, while the unacknowledged video exceeds
+// twice the bandwidth-delay product.
+func (c *Controller) CanSend() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.expire(time.Now())
+	if c.inflight == 0 || float64(c.inflight) <= c.window() {
+		return true
+	}
+	c.skipped++
+	return false
+}
+
+// OnSent records a frame of n bytes that was just written completely
+func (c *Controller) OnSent(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sent += uint64(n)
+	c.inflight += n
+	c.outstanding = append(c.outstanding, sentFrame{at: time.Now(), end: c.sent, size: n})
+}
+
+// OnAck processes an acknowledgement of received video bytes in total
+func (c *Controller) OnAck(received uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if received > c.acked {
+		c.acked = received
+	}
+
+	// Bytes given up on never get acknowledged, so they shift what the
+	// receiver's count corresponds to
+	var newest *sentFrame
+	for len(c.outstanding) > 0 && c.outstanding[0].end <= c.acked+c.lost {
+		newest = &c.outstanding[0]
+		c.inflight -= newest.size
+		c.outstanding = c.outstanding[1:]
+	}
+	c.expire(now)
+
+	if newest != nil {
+		c.rtt = now.Sub(newest.at)
+		if c.minRTT == 0 || c.rtt < c.minRTT || now.Sub(c.minRTTAt) > minRTTWindow {
+			c.minRTT = c.rtt
+			c.minRTTAt = now
+		}
+	}
+
+	// Delivery rate over the last sample interval. Samples only raise the
+	// estimate: a low rate usually means there was little to send, a
+	// congested link shows up as growing delay instead.
+	if c.sampleStart.IsZero() {
+		c.sampleStart = now
+		c.sampleAcked = c.acked
+	} else if elapsed := now.Sub(c.sampleStart); elapsed >= sampleInterval {
+		rate := float64(c.acked-c.sampleAcked) / elapsed.Seconds()
+		if rate > c.bandwidth {
+			c.bandwidth = rate
+		}
+		c.sampleStart = now
+		c.sampleAcked = c.acked
+	}
+
+	if newest != nil && c.rtt-c.minRTT > targetDelay {
+		c.backoff(now)
+	}
+}
+
+// OnLoss reduces the estimate after the receiver reported lost frames
+func (c *Controller) OnLoss() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.backoff(time.Now())
+}
+
+// Bandwidth returns the estimated bandwidth in bytes per second
+func (c *Controller) Bandwidth() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bandwidth
+}
+
+// Wait blocks until n bytes may be sent at the pacing rate
+func (c *Controller) Wait(n int) {
+	c.mutex.Lock()
+	rate := c.bandwidth * pacingGain
+	now := time.Now()
+	c.paceTokens += now.Sub(c.paceRefilled).Seconds() * rate
+	burst := rate * paceBurst.Seconds()
+	if burst < bulkChunk {
+		burst = bulkChunk
+	}
+	if c.paceTokens > burst {
+		c.paceTokens = burst
+	}
+	c.paceRefilled = now
+
+	// Tokens may go negative, later writers queue behind the debt
+	c.paceTokens -= float64(n)
+	wait := time.Duration(0)
+	if c.paceTokens < 0 {
+		wait = time.Duration(-c.paceTokens / rate * float64(time.Second))
+	}
+	c.mutex.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// ObserveWrites has the paced writes reported to observe, with the time
+// they spent writing but not waiting for the pacing rate, which would only
+// measure the controller's own estimate
+func (c *Controller) ObserveWrites(observe func(n int, d time.Duration)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.observeWrite = observe
+}
+
+// Pace returns a writer that writes to w at the pacing rate instead of in
+// one burst
+func (c *Controller) Pace(w io.Writer) io.Writer {
+	return &pacedWriter{controller: c, writer: w}
+}
+
+// State returns the current estimates
+func (c *Controller) State() CongestionState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return CongestionState{
+		BandwidthKbps: c.bandwidth * 8 / 1000,
+		PacingKbps:    c.bandwidth * pacingGain * 8 / 1000,
+		RTTMs:         float64(c.rtt) / float64(time.Millisecond),
+		MinRTTMs:      float64(c.minRTT) / float64(time.Millisecond),
+		InflightKB:    float64(c.inflight) / 1024,
+		Skipped:       c.skipped,
+	}
+}
+
+// Signals returns what the controller knows of the connection for an
+// Adapter
+func (c *Controller) Signals() Signals {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return Signals{
+		RTT:      c.rtt,
+		MinRTT:   c.minRTT,
+		Inflight: c.inflight,
+		Window:   c.window(),
+		Skipped:  c.skipped,
+	}
+}
+
+// window returns how many unacknowledged bytes are allowed
+func (c *Controller) window() float64 {
+	rtt := c.minRTT
+	if rtt < minWindowRTT {
+		rtt = minWindowRTT
+	}
+	window := 2 * c.bandwidth * rtt.Seconds()
+	if window < minWindow {
+		window = minWindow
+	}
+	return window
+}
+
+// expire gives up on frames that stayed unacknowledged too long, they were
+// lost or are stuck in a queue that long, both mean congestion
+func (c *Controller) expire(now time.Time) {
+	expired := false
+	for len(c.outstanding) > 0 && now.Sub(c.outstanding[0].at) > lossTimeout {
+		c.lost += uint64(c.outstanding[0].size)
+		c.inflight -= c.outstanding[0].size
+		c.outstanding = c.outstanding[1:]
+		expired = true
+	}
+	if expired {
+		c.backoff(now)
+	}
+}
+
+// backoff reduces the estimate, at most once per round trip so a single
+// congestion event isn't counted several times
+func (c *Controller) backoff(now time.Time) {
+	interval := c.minRTT
+	if interval < sampleInterval {
+		interval = sampleInterval
+	}
+	if now.Sub(c.lastBackoff) < interval {
+		return
+	}
+
+	c.bandwidth *= backoffFactor
+	if c.bandwidth < minCapacity {
+		c.bandwidth = minCapacity
+	}
+	c.lastBackoff = now
+}
+
+// pacedWriter splits writes into chunks sent at the pacing rate
+type pacedWriter struct {
+	controller *Controller
+	writer     io.Writer
+}
+
+func (p *pacedWriter) Write(data []byte) (int, error) {
+	written, writing := 0, time.Duration(0)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > bulkChunk {
+			chunk = chunk[:bulkChunk]
+		}
+
+		p.controller.Wait(len(chunk))
+		start := time.Now()
+		n, err := p.writer.Write(chunk)
+		writing += time.Since(start)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		data = data[len(chunk):]
+	}
+
+	p.controller.mutex.Lock()
+	observe := p.controller.observeWrite
+	p.controller.mutex.Unlock()
+	if observe != nil {
+		observe(written, writing)
+	}
+	return written, nil
+}
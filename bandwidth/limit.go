@@ -0,0 +1,138 @@
+package bandwidth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// MaxQuality is the JPEG quality used when bandwidth is not limited
+	MaxQuality = 90
+
+	// MinQuality is the lowest JPEG quality a limit may force, below it
+	// the frame rate is reduced instead
+	MinQuality = 30
+
+	// qualityStep is how much the quality changes per frame
+	qualityStep = 5
+
+	// maxInterval is the longest time between frames a limit may force
+	maxInterval = 2 * time.Second
+
+	// sizeWeight is the weight of a new frame in the average frame size
+	sizeWeight = 0.3
+
+	// headroom is the share of the limit below which quality and frame
+	// rate are raised again, so they don't oscillate around the limit
+	headroom = 0.8
+)
+
+// Limiter keeps a video stream under a byte rate by lowering the JPEG
+// quality first and the frame rate second, and raises them again in the
+// reverse order once the stream fits
+type Limiter struct {
+	mutex       sync.Mutex
+	limit       float64       // Bytes per second, 0 for unlimited
+	minInterval time.Duration // Time between frames at full frame rate
+	quality     int
+	interval    time.Duration
+	frameSize   float64 // Average encoded frame size in bytes
+}
+
+// NewLimiter creates a limiter for a stream sending a frame every
+// minInterval at full rate, unlimited until SetLimit is called
+func NewLimiter(minInterval time.Duration) *Limiter {
+	return &Limiter{
+		minInterval: minInterval,
+		quality:     MaxQuality,
+		interval:    minInterval,
+	}
+}
+
+// SetLimit sets the byte rate the stream must stay under, 0 removes the
+// limit
+func (l *Limiter) SetLimit(bytesPerSecond float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.limit = bytesPerSecond
+	if l.limit <= 0 {
+		l.quality = MaxQuality
+		l.interval = l.minInterval
+	}
+}
+
+// SetMinInterval sets the time between frames at full frame rate, e.g.
+// after the target frame rate changed
+func (l *Limiter) SetMinInterval(interval time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.minInterval = interval
+	if l.limit <= 0 || l.interval < interval {
+		l.interval = interval
+	}
+}
+
+// Quality returns the JPEG quality to encode the next frame with
+func (l *Limiter) Quality() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.quality
+}
+
+// Interval returns how long to wait before capturing the next frame
+func (l *Limiter) Interval() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.interval
+}
+
+// Observe adjusts quality and frame rate after a frame of size bytes was
+// encoded
+func (l *Limiter) Observe(size int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.frameSize == 0 {
+		l.frameSize = float64(size)
+	} else {
+		l.frameSize += sizeWeight * (float64(size) - l.frameSize)
+	}
+	if l.limit <= 0 {
+		return
+	}
+
+	rate := l.frameSize / l.interval.Seconds()
+	switch {
+	case rate > l.limit && l.quality > MinQuality:
+		l.quality -= qualityStep
+		if l.quality < MinQuality {
+			l.quality = MinQuality
+		}
+
+	case rate > l.limit:
+		// Quality is at its floor, send frames only as often as they fit
+		l.interval = l.clampInterval(time.Duration(l.frameSize / l.limit * float64(time.Second)))
+
+	case rate < l.limit*headroom && l.interval > l.minInterval:
+		l.interval = l.clampInterval(time.Duration(l.frameSize / (l.limit * headroom) * float64(time.Second)))
+
+	case rate < l.limit*headroom && l.quality < MaxQuality:
+		l.quality += qualityStep
+		if l.quality > MaxQuality {
+			l.quality = MaxQuality
+		}
+	}
+}
+
+// clampInterval keeps an interval between full frame rate and maxInterval
+func (l *Limiter) clampInterval(interval time.Duration) time.Duration {
+	if interval < l.minInterval {
+		return l.minInterval
+	}
+	if interval > maxInterval {
+		return maxInterval
+	}
+	return interval
+}
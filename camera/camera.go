@@ -0,0 +1,189 @@
+// Package camera captures webcams and feeds virtual cameras through ffmpeg.
+// Frames are exchanged as JPEG images, which ffmpeg reads and writes
+// without transcoding on most webcams.
+package camera
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// MaxFrameSize is the largest JPEG frame read from ffmpeg
+const MaxFrameSize = 4 << 20
+
+// Frames are scaled down to at most this height, which is plenty for a
+// call and keeps frames small next to the screens
+const maxHeight = 720
+
+// frameRate is the rate webcams are captured at
+const frameRate = 30
+
+// ErrFrameTooLarge is returned when ffmpeg writes a frame larger than
+// MaxFrameSize
+var ErrFrameTooLarge = errors.New("camera frame too large")
+
+// Capture is a running webcam capture
+type Capture struct {
+	cmd    *exec.Cmd
+	output io.ReadCloser
+	reader *bufio.Reader
+	once   sync.Once
+}
+
+// Open starts capturing a webcam. device is the camera as ffmpeg names it:
+// a /dev/video path on Linux, an index on macOS and a name on Windows. An
+// empty device selects the first camera, except on Windows where cameras
+// have no default.
+func Open(device string) (*Capture, error) {
+	input, err := inputArgs(device)
+	if err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("cameras need ffmpeg: %w", err)
+	}
+
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, input...)
+	args = append(args,
+		"-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", maxHeight),
+		"-r", fmt.Sprint(frameRate),
+		"-f", "mjpeg", "-q:v", "5", "-")
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	return &Capture{cmd: cmd, output: output, reader: bufio.NewReaderSize(output, 64<<10)}, nil
+}
+
+// inputArgs returns the ffmpeg arguments reading a webcam on this OS
+func inputArgs(device string) ([]string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		if device == "" {
+			device = "/dev/video0"
+		}
+		return []string{"-f", "v4l2", "-i", device}, nil
+	case "darwin":
+		if device == "" {
+			device = "0"
+		}
+		return []string{"-f", "avfoundation", "-framerate", fmt.Sprint(frameRate), "-i", device}, nil
+	case "windows":
+		if device == "" {
+			return nil, errors.New("name the camera to use, \"ffmpeg -list_devices true -f dshow -i dummy\" lists them")
+		}
+		return []string{"-f", "dshow", "-i", "video=" + device}, nil
+	default:
+		return nil, fmt.Errorf("cameras aren't supported on %s", runtime.GOOS)
+	}
+}
+
+// Next returns the next frame, a JPEG image. It blocks until the camera
+// delivers one and fails once the capture ended.
+func (c *Capture) Next() ([]byte, error) {
+	return readJPEG(c.reader)
+}
+
+// Close stops the capture, unblocking Next
+func (c *Capture) Close() error {
+	c.once.Do(func() {
+		c.output.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+// readJPEG reads the next JPEG image of a stream of concatenated images.
+// Bytes before the start of image marker are skipped. The end of image
+// marker can't occur inside the image, entropy coded 0xFF bytes are
+// stuffed.
+func readJPEG(r *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	var prev byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && frame != nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if frame == nil {
+			if prev == 0xFF && b == 0xD8 {
+				frame = []byte{0xFF, 0xD8}
+				b = 0
+			}
+			prev = b
+			continue
+		}
+
+		frame = append(frame, b)
+		if prev == 0xFF && b == 0xD9 {
+			return frame, nil
+		}
+		if len(frame) > MaxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+		prev = b
+	}
+}
+
+// Virtual is a virtual camera other applications see as a webcam, fed with
+// JPEG frames
+type Virtual struct {
+	cmd   *exec.Cmd
+	input io.WriteCloser
+}
+
+// OpenVirtual starts feeding a virtual camera. Only Linux has them, device
+// is a v4l2loopback device such as /dev/video10.
+func OpenVirtual(device string) (*Virtual, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("virtual cameras aren't supported on %s, only on Linux with v4l2loopback", runtime.GOOS)
+	}
+	if device == "" {
+		return nil, errors.New("no virtual camera device given")
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("virtual cameras need ffmpeg: %w", err)
+	}
+
+	cmd := exec.Command(path, "-hide_banner", "-loglevel", "error",
+		"-f", "mjpeg", "-i", "-",
+		"-f", "v4l2", "-pix_fmt", "yuv420p", device)
+	cmd.Stderr = os.Stderr
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	return &Virtual{cmd: cmd, input: input}, nil
+}
+
+// Write shows a JPEG frame on the virtual camera
+func (v *Virtual) Write(frame []byte) error {
+	_, err := v.input.Write(frame)
+	return err
+}
+
+// Close stops feeding the virtual camera, it goes away until reopened
+func (v *Virtual) Close() error {
+	v.input.Close()
+	return v.cmd.Wait()
+}
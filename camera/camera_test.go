@@ -0,0 +1,36 @@
+package camera
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadJPEG(t *testing.T) {
+	first := []byte{0xFF, 0xD8, 0xFF, 0xDB, 0x12, 0xFF, 0x00, 0x34, 0xFF, 0xFF, 0xD9}
+	second := []byte{0xFF, 0xD8, 0x56, 0xFF, 0xD0, 0x78, 0xFF, 0xD9}
+
+	var stream []byte
+	stream = append(stream, 0x00, 0xFF, 0x12) // Garbage before the first image
+	stream = append(stream, first...)
+	stream = append(stream, second...)
+	stream = append(stream, second[:4]...) // Truncated image
+	r := bufio.NewReader(bytes.NewReader(stream))
+
+	for i, want := range [][]byte{first, second} {
+		frame, err := readJPEG(r)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(frame, want) {
+			t.Errorf("frame %d = %x, want %x", i, frame, want)
+		}
+	}
+	if _, err := readJPEG(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame: got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := readJPEG(r); err != io.EOF {
+		t.Errorf("end of stream: got error %v, want %v", err, io.EOF)
+	}
+}
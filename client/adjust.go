@@ -0,0 +1,128 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColorAdjust changes how frames look in a window, for remote screens
+// captured darker than they look or calibrated differently than the local
+// display. Channels from 0 to 1 are adjusted in the order of the fields.
+type ColorAdjust struct {
+	Brightness float64 // Added to every channel, from -1 to 1
+	Contrast   float64 // Scales channels around mid gray, from 0 to 4
+	Gamma      float64 // Raises channels to 1/Gamma, from 0.1 to 10, above 1 brightens midtones
+}
+
+// noColorAdjust leaves frames as they are
+var noColorAdjust = ColorAdjust{Brightness: 0, Contrast: 1, Gamma: 1}
+
+// Steps the control bar moves an adjustment by
+const (
+	brightnessStep = 0.05
+	contrastStep   = 0.1
+	gammaStep      = 0.1
+)
+
+// ParseColorAdjustments parses comma separated lists of brightness,
+// contrast and gamma values by window for WithColorAdjustments, e.g.
+// "0.1,0" for the brightness of two windows. Each list's last value
+// applies to the rest of the windows, and an empty list leaves its
+// setting alone.
+func ParseColorAdjustments(brightness, contrast, gamma string) ([]ColorAdjust, error) {
+	brightnesses, err := parseAdjustList("brightness", brightness, -1, 1)
+	if err != nil {
+		return nil, err
+	}
+	contrasts, err := parseAdjustList("contrast", contrast, 0, 4)
+	if err != nil {
+		return nil, err
+	}
+	gammas, err := parseAdjustList("gamma", gamma, 0.1, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	count := max(len(brightnesses), len(contrasts), len(gammas))
+	adjustments := make([]ColorAdjust, count)
+	for i := range adjustments {
+		adjustments[i] = noColorAdjust
+		if len(brightnesses) > 0 {
+			adjustments[i].Brightness = brightnesses[min(i, len(brightnesses)-1)]
+		}
+		if len(contrasts) > 0 {
+			adjustments[i].Contrast = contrasts[min(i, len(contrasts)-1)]
+		}
+		if len(gammas) > 0 {
+			adjustments[i].Gamma = gammas[min(i, len(gammas)-1)]
+		}
+	}
+	return adjustments, nil
+}
+
+// parseAdjustList parses a comma separated list of values from low to high
+func parseAdjustList(name, list string, low, high float64) ([]float64, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	var values []float64
+	for _, entry := range strings.Split(list, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(entry), 64)
+		if err != nil || value < low || value > high {
+			return nil, fmt.Errorf("invalid %s %q, must be %g to %g", name, entry, low, high)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// WithColorAdjustments sets the brightness, contrast and gamma of frames
+// by window, the last one applying to the rest. They can be changed at
+// runtime from the control bar.
+func WithColorAdjustments(adjustments ...ColorAdjust) Option {
+	return func(c *Client) {
+		c.colorAdjustments = adjustments
+	}
+}
+
+// initialColorAdjust returns the adjustment a monitor window starts with
+func (c *Client) initialColorAdjust(windowIndex int) ColorAdjust {
+	if len(c.colorAdjustments) == 0 {
+		return noColorAdjust
+	}
+	return c.colorAdjustments[min(windowIndex, len(c.colorAdjustments)-1)]
+}
+
+// identity reports whether the adjustment leaves frames as they are
+func (a ColorAdjust) identity() bool {
+	return a == noColorAdjust
+}
+
+// apply adjusts a channel from 0 to 1
+func (a ColorAdjust) apply(value float64) float64 {
+	value = (value-0.5)*a.Contrast + 0.5 + a.Brightness
+	return math.Pow(min(max(value, 0), 1), 1/a.Gamma)
+}
+
+// table returns the adjusted value of every 8 bit channel value, for
+// renderers adjusting frames without a shader
+func (a ColorAdjust) table() [256]uint8 {
+	var table [256]uint8
+	for i := range table {
+		table[i] = uint8(math.Round(a.apply(float64(i)/255) * 255))
+	}
+	return table
+}
+
+// shaderParams returns the brightness, contrast and exponent shaders
+// adjust channels with, padded to a vector of four
+func (a ColorAdjust) shaderParams() [4]float32 {
+	return [4]float32{float32(a.Brightness), float32(a.Contrast), float32(1 / a.Gamma), 0}
+}
+
+// String describes the adjustment for the control bar
+func (a ColorAdjust) String() string {
+	return fmt.Sprintf("brightness %+.2f, contrast %.1f, gamma %.1f", a.Brightness, a.Contrast, a.Gamma)
+}
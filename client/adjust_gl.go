@@ -0,0 +1,84 @@
+//go:build cgo
+
+package client
+
+import (
+	"log"
+	"strings"
+
+	"github.com/go-gl/gl/v2.1/gl"
+)
+
+// colorAdjustShader adjusts the texture's channels as ColorAdjust.apply
+// does, with the fixed function pipeline placing the quad
+const colorAdjustShader = `#version 120
+uniform sampler2D frame;
+uniform vec3 adjust;
+void main() {
+	vec4 color = texture2D(frame, gl_TexCoord[0].st);
+	vec3 rgb = clamp((color.rgb - 0.5) * adjust.y + 0.5 + adjust.x, 0.0, 1.0);
+	gl_FragColor = vec4(pow(rgb, vec3(adjust.z)), color.a) * gl_Color;
+}
+` + "\x00"
+
+// colorAdjustProgram is the shader program of a window's OpenGL context
+// adjusting its frames, created on first use
+type colorAdjustProgram struct {
+	id     uint32
+	adjust int32 // Location of the adjust uniform
+	failed bool  // Whether the shader didn't compile, frames are shown as they are
+}
+
+// use draws the following quads with adjust, or without the program when
+// adjust leaves frames as they are. It returns whether the program is in
+// use, stop with gl.UseProgram(0).
+func (p *colorAdjustProgram) use(adjust ColorAdjust) bool {
+	if adjust.identity() || p.failed {
+		return false
+	}
+	if p.id == 0 && !p.create() {
+		p.failed = true
+		return false
+	}
+	params := adjust.shaderParams()
+	gl.UseProgram(p.id)
+	gl.Uniform3f(p.adjust, params[0], params[1], params[2])
+	return true
+}
+
+// create compiles the program in the current context
+func (p *colorAdjustProgram) create() bool {
+	shader := gl.CreateShader(gl.FRAGMENT_SHADER)
+	source, free := gl.Strs(colorAdjustShader)
+	gl.ShaderSource(shader, 1, source, nil)
+	free()
+	gl.CompileShader(shader)
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var length int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &length)
+		message := strings.Repeat("\x00", int(length+1))
+		gl.GetShaderInfoLog(shader, length, nil, gl.Str(message))
+		log.Printf("Color adjustment shader failed to compile, showing frames as they are: %s", message)
+		gl.DeleteShader(shader)
+		return false
+	}
+
+	p.id = gl.CreateProgram()
+	gl.AttachShader(p.id, shader)
+	gl.LinkProgram(p.id)
+	gl.DeleteShader(shader) // Freed with the program
+	gl.GetProgramiv(p.id, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		log.Println("Color adjustment shader failed to link, showing frames as they are")
+		gl.DeleteProgram(p.id)
+		p.id = 0
+		return false
+	}
+	p.adjust = gl.GetUniformLocation(p.id, gl.Str("adjust\x00"))
+	gl.UseProgram(p.id)
+	gl.Uniform1i(gl.GetUniformLocation(p.id, gl.Str("frame\x00")), 0)
+	gl.UseProgram(0)
+	return true
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// reportInterval is how often the server is told how video arrives
+const reportInterval = time.Second
+
+// WithMaxBandwidth asks the server to keep the video under kbps kbit/s,
+// for metered or shared links. The server lowers JPEG quality and then
+// frame rate to stay under the cap.
+func WithMaxBandwidth(kbps int) Option {
+	return func(c *Client) {
+		c.maxBandwidth = kbps
+	}
+}
+
+// sendBandwidthLimit tells the server the video bandwidth cap
+func (c *Client) sendBandwidthLimit() error {
+	payload := protocol.Uint32ToBytes(uint32(c.maxBandwidth))
+	return c.send(protocol.NewPacket(protocol.PacketTypeBandwidthLimit, payload))
+}
+
+// reportLoop sends the server the frames received and lost, for its
+// adaptive quality controller, until the session ends
+func (c *Client) reportLoop(sessionDone <-chan struct{}) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case <-sessionDone:
+			return
+		case <-ticker.C:
+			report := protocol.ClientReport{
+				FramesReceived: uint32(c.framesReceived.Load()),
+				FramesDropped:  uint32(c.droppedFrames.Load()),
+			}
+			packet := protocol.NewPacket(protocol.PacketTypeClientReport, protocol.EncodeClientReport(report))
+			if err := c.sendInput(packet); err != nil && !c.stopped {
+				log.Printf("Error sending client report: %v", err)
+			}
+		}
+	}
+}
+
+// handleBulkChunk adds a chunk of bulk data to the packet it belongs to and
+// handles the packet once all of it has arrived. Chunks come in order on the
+// connection, so one packet is reassembled at a time.
+func (c *Client) handleBulkChunk(payload []byte) {
+	chunk, err := protocol.DecodeBulkChunk(payload)
+	if err != nil {
+		log.Printf("Invalid bulk chunk packet: %v", err)
+		return
+	}
+	if chunk.Offset == 0 {
+		c.bulkChunks = make([]byte, 0, chunk.Total)
+	}
+	if int(chunk.Offset) != len(c.bulkChunks) || int(chunk.Total) != cap(c.bulkChunks) {
+		log.Printf("Dropping bulk chunk at %d of %d out of sequence", chunk.Offset, chunk.Total)
+		c.bulkChunks = nil
+		return
+	}
+
+	c.bulkChunks = append(c.bulkChunks, chunk.Data...)
+	if len(c.bulkChunks) == int(chunk.Total) {
+		data := c.bulkChunks
+		c.bulkChunks = nil
+		c.handlePacket(protocol.NewPacket(chunk.Type, data))
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log"
+
+	"github.com/moderniselife/ultrardp/camera"
+	"github.com/moderniselife/ultrardp/protocol"
+	"github.com/moderniselife/ultrardp/transport"
+)
+
+// WithServerCamera shows the server's webcam in a window of its own, if the
+// server shares one
+func WithServerCamera(enabled bool) Option {
+	return func(c *Client) {
+		c.serverCamera = enabled
+	}
+}
+
+// WithCamera sends a webcam of the client to the server, which shows it on
+// a virtual camera for apps in calls. device is the camera as ffmpeg names
+// it, empty for the default one.
+func WithCamera(device string) Option {
+	return func(c *Client) {
+		c.cameraEnabled = true
+		c.cameraDevice = device
+	}
+}
+
+// startCameras asks for the server's webcam and starts sending ours, as
+// configured
+func (c *Client) startCameras() error {
+	if c.serverCamera {
+		if err := c.send(protocol.NewPacket(protocol.PacketTypeCameraRequest, []byte{protocol.CameraOn})); err != nil {
+			return err
+		}
+	}
+	if c.cameraEnabled {
+		c.cameraRefused.Store(false)
+		go c.sendCamera(c.streams, c.sessionDone)
+	}
+	return nil
+}
+
+// sendCamera sends the frames of the client's webcam until the session
+// ends or the server refuses them. Frames go on the camera stream if the
+// transport supports streams.
+func (c *Client) sendCamera(streams transport.MultiStreamConn, done <-chan struct{}) {
+	capture, err := camera.Open(c.cameraDevice)
+	if err != nil {
+		log.Printf("Failed to open camera: %v", err)
+		return
+	}
+	defer capture.Close()
+
+	// Closing the capture unblocks Next
+	go func() {
+		select {
+		case <-done:
+		case <-c.stopChan:
+		}
+		capture.Close()
+	}()
+
+	var stream io.WriteCloser
+	if streams != nil {
+		if stream, err = streams.OpenStream(protocol.StreamCamera); err != nil {
+			log.Printf("Failed to open camera stream, sending the camera on the control connection: %v", err)
+		} else {
+			defer stream.Close()
+		}
+	}
+
+	log.Println("Sending camera to the server")
+	for {
+		frame, err := capture.Next()
+		if err != nil {
+			if !c.stopped {
+				log.Printf("Camera capture ended: %v", err)
+			}
+			return
+		}
+		if c.cameraRefused.Load() {
+			return
+		}
+
+		packet := protocol.NewPacket(protocol.PacketTypeCameraFrame, frame)
+		if stream != nil {
+			c.stats.PacketSent(protocol.HeaderSize + len(frame))
+			err = protocol.EncodePacket(stream, packet)
+		} else {
+			err = c.send(packet)
+		}
+		if err != nil {
+			if !c.stopped {
+				log.Printf("Error sending camera frame: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// handleCameraFrame decodes a frame of the server's webcam for its window
+func (c *Client) handleCameraFrame(payload []byte) {
+	img, err := jpeg.Decode(bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Invalid camera frame: %v", err)
+		return
+	}
+	rgba := image.NewRGBA(image.Rectangle{Max: img.Bounds().Size()})
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	c.cameraImage.Store(rgba)
+}
@@ -0,0 +1,81 @@
+//go:build cgo
+
+package client
+
+import (
+	"log"
+
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// drawCameraWindow shows the server's webcam in a window of its own,
+// opened when the first frame arrives. Closing the window stops the
+// server's webcam stream.
+func (c *Client) drawCameraWindow() {
+	img := c.cameraImage.Load()
+	if img == nil || c.cameraClosed {
+		return
+	}
+
+	size := img.Bounds().Size()
+	if c.cameraWindow == nil {
+		glfw.DefaultWindowHints()
+		glfw.WindowHint(glfw.Resizable, glfw.False)
+		c.setRendererHints()
+		window, err := glfw.CreateWindow(size.X, size.Y, "UltraRDP - Server camera", nil, nil)
+		if err != nil {
+			log.Printf("Failed to create camera window: %v", err)
+			c.cameraClosed = true
+			return
+		}
+		// The camera window mustn't hold up the monitor windows' swaps
+		p, err := c.newPresenter(window, SwapOff)
+		if err != nil {
+			log.Printf("Failed to set up %s for the camera window: %v", c.renderer, err)
+			window.Destroy()
+			c.cameraClosed = true
+			return
+		}
+		if p == nil {
+			window.MakeContextCurrent()
+			setSwapInterval(SwapOff)
+		}
+		c.excludeOwnWindow(window)
+		window.SetKeyCallback(c.handleKey)
+		c.cameraWindow, c.cameraPresenter = window, p
+	}
+
+	window := c.cameraWindow
+	if window.ShouldClose() {
+		if c.cameraPresenter != nil {
+			c.cameraPresenter.close()
+			c.cameraPresenter = nil
+		}
+		window.Destroy()
+		c.cameraWindow, c.cameraClosed = nil, true
+		c.cameraTexture = streamTexture{} // Went with the window's context
+		c.serverCamera = false
+		if err := c.send(protocol.NewPacket(protocol.PacketTypeCameraRequest, []byte{protocol.CameraOff})); err != nil {
+			log.Printf("Error stopping the server's camera: %v", err)
+		}
+		return
+	}
+	if width, height := window.GetSize(); width != size.X || height != size.Y {
+		window.SetSize(size.X, size.Y)
+	}
+
+	if c.cameraPresenter != nil {
+		c.cameraPresenter.present(img, stretchLayout, noColorAdjust, nil, frameMarkNone)
+		return
+	}
+
+	window.MakeContextCurrent()
+	width, height := window.GetFramebufferSize()
+	gl.Viewport(0, 0, int32(width), int32(height))
+	c.cameraTexture.upload(img)
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+	renderSimpleFullscreenTexture(c.cameraTexture.id, stretchLayout)
+	window.SwapBuffers()
+}
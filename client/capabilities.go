@@ -0,0 +1,13 @@
+package client
+
+import "github.com/moderniselife/ultrardp/protocol"
+
+// displayCapabilities is what the client's windows can show. Frames are
+// drawn as 8-bit sRGB textures, so the server tone maps HDR screens.
+const displayCapabilities protocol.Capability = 0
+
+// sendCapabilities describes the client's display to the server
+func (c *Client) sendCapabilities() error {
+	payload := protocol.EncodeCapabilities(displayCapabilities)
+	return c.send(protocol.NewPacket(protocol.PacketTypeCapabilities, payload))
+}
@@ -2,17 +2,28 @@
 package client
 
 import (
+	"crypto/tls"
 	"fmt"
+	"image"
+	"io"
 	"time"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"runtime"
-	"os"
 	
-	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/kbinani/screenshot"
+	"github.com/moderniselife/ultrardp/clipboard"
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/diagnostics"
+	"github.com/moderniselife/ultrardp/icc"
 	"github.com/moderniselife/ultrardp/protocol"
+	"github.com/moderniselife/ultrardp/recording"
+	"github.com/moderniselife/ultrardp/relay"
+	"github.com/moderniselife/ultrardp/stats"
+	"github.com/moderniselife/ultrardp/transport"
+	"golang.org/x/crypto/ssh"
 )
 
 // Client represents an UltraRDP client instance
@@ -22,74 +33,304 @@ type Client struct {
 	localMonitors  *protocol.MonitorConfig
 	monitorMap     map[uint32]uint32 // Maps server monitor IDs to local monitor IDs
 	qualityLevel   int               // 0-100, where 100 is highest quality
+	qualitySent    bool              // Whether qualityLevel was sent to the server
 	stopped        bool
 	stopChan       chan struct{}
 	frameMutex     sync.Mutex
-	frameBuffers   map[uint32][]byte // Buffers for each monitor
 	frameCount     map[uint32]int    // Frame counter for each monitor
-	windows        []*glfw.Window    // Windows for displaying frames
+	displayState                     // Windows for displaying frames, see display.go
+
+	address         string
+	frameCacheDir   string               // Directory for cached frames, empty if disabled
+	frameCacheTimes map[uint32]time.Time // Last time each server monitor's frame was cached
+	psk             []byte               // Pre-shared key for protocol encryption, nil if disabled
+	password        string               // Password for the server's auth challenge
+	serverLocked    atomic.Bool          // Whether the server reported its screen as locked
+	bulkState       atomic.Pointer[protocol.BulkState] // How the server last reported our bulk transfers
+	bulkChunks      []byte               // Payload of the bulk packet arriving in chunks, see handleBulkChunk
+	sendMutex       sync.Mutex           // Serializes packets written to the server
+	udpEnabled      bool                 // Whether to request video over UDP
+
+	clipboard            *clipboard.Sync // Clipboard state, nil if sync is disabled
+	clipboardPickerIndex int
+	clipboardPickerTime  time.Time
+	clipboardPickerTitle string
+
+	tlsEnabled bool
+	tlsCA      string // CA file to verify the server with, system roots if empty
+	tlsCert    string // Client certificate for mutual TLS, empty if none
+	tlsKey     string
+
+	transport string                    // Transport used to reach the server
+	streams   transport.MultiStreamConn // Set if the transport supports per-monitor streams
+
+	headless bool // Run without windows even if a display is available
+	renderer Renderer // Graphics API frames are drawn with, see WithRenderer
+	fullscreen bool // Monitor windows cover their monitors, see WithFullscreen
+	scaleModes []ScaleMode // How frames are scaled to windows, see WithScaleModes
+	colorAdjustments []ColorAdjust // Brightness, contrast and gamma by window, see WithColorAdjustments
+	resizeScaling bool // Ask for monitors at their windows' size, see WithResizeScaling
+	rotations map[uint32]Rotation // Server monitors turned in their windows, see WithRotations
+	swapModes []SwapMode // How windows sync with their displays, see WithSwapModes
+	interpolation bool // Blend between frames arriving slower than the display refreshes, see WithFrameInterpolation
+	grid bool // Show every server monitor in one window, see WithGrid
+	touch bool // Touch gestures drive the windows, see WithTouch
+
+	inputStream io.WriteCloser // Stream for input packets, nil to use the control connection
+	inputMutex  sync.Mutex
+
+	parallelEnabled bool       // Whether to request one connection per monitor
+	parallelConns   []net.Conn // Open per-monitor connections
+	parallelMutex   sync.Mutex
+
+	recordPath string            // Recording file, empty if disabled
+	recorder   *recording.Writer // Open recording, nil if disabled or recording video
+	videos     map[uint32]*videoRecording // Videos by local monitor ID, nil unless recording video
+	videoMutex sync.Mutex
+
+	screenshotDir string // Where Ctrl+Alt+P saves screenshots, see WithScreenshotDir
+
+	debugFramesLimit int64                  // Size of the debug frames kept, 0 if disabled, see WithDebugFrames
+	frameDump        *diagnostics.FrameDump // nil unless debug frames are saved
+
+	stats         *stats.Collector
+	statsPath     string        // Destination for JSON stats, empty if disabled
+	statsInterval time.Duration // Interval between JSON stats snapshots
+
+	relayAddress string // Relay to connect through, empty to dial directly
+	relayCode    string // Session code of the server on the relay
+
+	sshTarget  string      // SSH server to tunnel through as user@host[:port], empty to dial directly
+	sshKeyFile string      // Private key for the SSH server, empty for the defaults
+	sshClient  *ssh.Client // Open SSH connection, shared by all tunneled connections
+	sshMutex   sync.Mutex
+
+	proxyURL string // Proxy to connect through, empty for the environment's
+	proxied  bool   // Whether the connection went through a proxy
+
+	maxBandwidth  int               // Video bandwidth cap to ask the server for in kbit/s, 0 for none
+	frameRate     int               // Frames per second to ask the server for, 0 for its default
+	rateControl   codec.RateControl // Rate control to ask the server for, see WithRateControl
+	captureScales map[uint32]int    // Capture scales in percent to ask the server for, see WithCaptureScales
+
+	serverCamera  bool        // Whether to show the server's webcam, see WithServerCamera
+	cameraEnabled bool        // Whether to send our webcam to the server, see WithCamera
+	cameraDevice  string      // Webcam to send, empty for the default one
+	cameraRefused atomic.Bool // Whether the server can't show our webcam
+	cameraImage   atomic.Pointer[image.RGBA] // Last frame of the server's webcam
+
+	codecs        []codec.ID               // Codecs offered to the server, most preferred first
+	codecsMutex   sync.Mutex               // Guards codecs, which change at runtime, see toggleLossless
+	decoders      map[uint32]codec.Decoder // Decoders by server monitor ID, see handleCodecSelect
+	frames        frameSlots               // Latest decoded frame by local monitor ID
+	decodePool    decodePool               // Decodes JPEG and PNG frames off the connection and display loop
+	keyframeAsked map[uint32]time.Time     // Last keyframe request by server monitor ID, see requestKeyframe
+
+	colorCorrection bool                      // Convert frames to sRGB with the server's color profiles
+	colorTransforms map[uint32]*icc.Transform // By local monitor ID, none for monitors shown as they are
+
+	reconnectTimeout time.Duration // How long to try reconnecting after the connection fails, 0 to give up
+	reconnecting     atomic.Bool   // Whether the connection failed and is being replaced
+	sessionDone      chan struct{} // Closed when the current connection fails
+
+	droppedFrames     atomic.Int64  // Frames lost in transit, see frameDropped
+	framesReceived    atomic.Int64  // Frames received, reported to the server with droppedFrames
+	videoReceived     atomic.Uint64 // Video bytes received, acknowledged to the server
+	frameMarksEnabled atomic.Bool
+	frameMarks        map[uint32]*frameMarkState // By server monitor ID
+	frameMarksMutex   sync.Mutex
+	minFrameDelay     time.Duration // Fastest delivery seen, see markFrame
+	minFrameDelaySet  bool
+
+	cursorShapes map[uint32]*protocol.CursorShape // Forwarded cursor shapes by ID
+	cursor       protocol.CursorPosition          // Where the forwarded cursor is
+	cursorMotion cursorMotion                     // How the forwarded cursor glides to where it is
+	cursorMonitor image.Point                     // Size of the server monitor the cursor is on, see cursorOn
+	cursorMutex  sync.Mutex
+
+	detached      map[uint32]bool // Server monitors whose windows were closed, see detachMonitor
+	detachedMutex sync.Mutex
+
+	menu          controlMenu       // The control bar, used on the display thread only
+	statsShown    bool              // Whether windows show their monitor's stats, see toggleStats
+	statsLines    map[uint32]string // Stats shown by server monitor ID, see updateStatsLines
+	statsPrevious stats.Snapshot    // Snapshot the shown rates are relative to
+	statsPinging  bool              // Whether the server is pinged for the shown RTT
+
+	// Input state, used on the display thread only
+	heldKeys      map[protocol.KeyCode]bool     // Keys pressed on the server
+	swallowedKeys map[protocol.KeyCode]bool     // Held keys whose release a hotkey consumed, see handleKey
+	pointer       serverPosition                // Where the pointer was last forwarded to
+	buttonsDown   map[protocol.MouseButton]bool // Buttons pressed on the server, see sendButton
+}
+
+// Option configures optional client behaviour
+type Option func(*Client)
+
+// WithPreSharedKey enables protocol encryption using a key shared with the server
+func WithPreSharedKey(psk []byte) Option {
+	return func(c *Client) {
+		c.psk = psk
+	}
+}
+
+// WithPassword sets the password used to answer the server's auth challenge
+func WithPassword(password string) Option {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// WithTLS connects over TLS, verifying the server certificate against
+// caFile. For a server with a generated self-signed certificate, caFile is
+// that certificate.
+func WithTLS(caFile string) Option {
+	return func(c *Client) {
+		c.tlsEnabled = true
+		c.tlsCA = caFile
+	}
+}
+
+// WithClientCertificate presents a client certificate to servers that
+// require mutual TLS, e.g. one issued with "ultrardp enroll". It has no
+// effect unless TLS is enabled with WithTLS.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *Client) {
+		c.tlsCert = certFile
+		c.tlsKey = keyFile
+	}
 }
 
 // NewClient creates a new UltraRDP client
-func NewClient(address string) (*Client, error) {
+func NewClient(address string, opts ...Option) (*Client, error) {
+	c := &Client{
+		monitorMap:     make(map[uint32]uint32),
+		qualityLevel:   80, // Default quality level
+		stopped:        false,
+		stopChan:       make(chan struct{}),
+		sessionDone:    make(chan struct{}),
+		frameCount:     make(map[uint32]int),
+		colorTransforms: make(map[uint32]*icc.Transform),
+		decoders:       make(map[uint32]codec.Decoder),
+		keyframeAsked:  make(map[uint32]time.Time),
+
+		address:         address,
+		renderer:        RendererGL,
+		frameCacheTimes: make(map[uint32]time.Time),
+
+		stats: stats.New("client"),
+	}
+	
+	for _, opt := range opts {
+		opt(c)
+	}
+	
 	// Detect local monitors
-	localMonitors, err := detectMonitors()
-	if err != nil {
-		return nil, fmt.Errorf("failed to detect local monitors: %w", err)
+	if c.localMonitors == nil {
+		localMonitors, err := detectMonitors()
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect local monitors: %w", err)
+		}
+		c.localMonitors = localMonitors
 	}
 	
 	// Connect to server
-	conn, err := net.Dial("tcp", address)
+	conn, err := c.dial()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to server: %w", err)
 	}
+	c.useConn(conn)
 	
-	return &Client{
-		conn:           conn,
-		localMonitors:  localMonitors,
-		monitorMap:     make(map[uint32]uint32),
-		qualityLevel:   80, // Default quality level
-		stopped:        false,
-		stopChan:       make(chan struct{}),
-		frameBuffers:   make(map[uint32][]byte),
-		frameCount:     make(map[uint32]int),
-	}, nil
+	return c, nil
+}
+
+// useConn makes conn the connection to the server
+func (c *Client) useConn(conn net.Conn) {
+	c.conn = conn
+	// Streams bypass the pre-shared key encryption, see the server
+	c.streams, _ = conn.(transport.MultiStreamConn)
+	if c.psk != nil && !transport.EncryptsStreams(c.transport, c.tlsEnabled) {
+		c.streams = nil
+	}
+}
+
+// dial connects to the server with the configured transport
+func (c *Client) dial() (net.Conn, error) {
+	var tlsConfig *tls.Config
+	if c.tlsEnabled {
+		config, err := transport.ClientTLS(c.tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS CA: %w", err)
+		}
+		if c.tlsCert != "" {
+			if err := transport.LoadClientCertificate(config, c.tlsCert, c.tlsKey); err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+		}
+		tlsConfig = config
+	}
+	if c.relayAddress != "" {
+		if c.transport != "" && c.transport != transport.TCP {
+			return nil, fmt.Errorf("the %s transport can't be relayed, use tcp", c.transport)
+		}
+		return relay.Dial(c.relayAddress, c.relayCode, tlsConfig)
+	}
+	if c.sshTarget != "" {
+		return transport.DialVia(c.sshDial, c.transport, c.address, tlsConfig)
+	}
+
+	dialer, err := c.proxyDialer()
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		return transport.DialVia(dialer, c.transport, c.address, tlsConfig)
+	}
+	return transport.Dial(c.transport, c.address, tlsConfig)
 }
 
 // Start begins the client session
 func (c *Client) Start() error {
 	log.Println("Client started, detected", c.localMonitors.MonitorCount, "local monitors")
 	
+	if err := c.startRecording(); err != nil {
+		return fmt.Errorf("failed to start recording: %w", err)
+	}
+	c.openFrameDump()
+	
+	// Emit stats snapshots for external consumers
+	if c.statsPath != "" {
+		if err := c.startStats(); err != nil {
+			return fmt.Errorf("failed to open stats output: %w", err)
+		}
+	}
+	
 	// Handle initial handshake
 	log.Println("Performing handshake with server...")
 	if err := c.handleHandshake(); err != nil {
 		return fmt.Errorf("handshake failed: %w", err)
 	}
+	if err := c.startSession(); err != nil {
+		return err
+	}
 	
-	// Start input capture in a goroutine
-	go c.startInputCapture()
+	// Keep clipboards in sync
+	if c.clipboard != nil {
+		go c.pollClipboard()
+	}
 	
 	// Allow a brief moment for server connection to establish
 	time.Sleep(200 * time.Millisecond)
 	
 	// Start packet receiving loop in a goroutine
 	log.Println("Starting packet receiving loop...")
+	go c.receiveLoop()
 	
-	go func() {
-		for !c.stopped {
-			// Skip if connection closed
-			if c.conn == nil { break }
-			
-			packet, err := protocol.DecodePacket(c.conn)
-			if err != nil {
-				if !c.stopped {
-					log.Printf("Error receiving packet: %v", err)
-				}
-				break
-			}
-			c.handlePacket(packet)
-		}
-	}()
+	// Without windows the client only receives, records and counts frames
+	if c.headless {
+		c.runHeadless()
+		return nil
+	}
 	
 	// Display must run on the main thread because of GLFW requirements
 	runtime.LockOSThread()
@@ -104,6 +345,82 @@ func (c *Client) Start() error {
 	return nil
 }
 
+// startSession requests the streams and settings of a freshly handshaked
+// connection
+func (c *Client) startSession() error {
+	// Video arrives on per-monitor streams if the transport supports them
+	if c.streams != nil {
+		go c.acceptStreams(c.streams)
+		if err := c.openInputStream(); err != nil {
+			log.Printf("Failed to open input stream, sending input on the control connection: %v", err)
+		}
+	}
+	
+	// Ask for a connection per monitor, streams make them unnecessary
+	if c.parallelEnabled && c.streams == nil {
+		if err := c.requestParallelConnections(); err != nil {
+			return fmt.Errorf("failed to request parallel connections: %w", err)
+		}
+	}
+	
+	// Negotiate the video codec
+	c.codecsMutex.Lock()
+	negotiate := len(c.codecs) > 0
+	c.codecsMutex.Unlock()
+	if negotiate {
+		if err := c.sendCodecs(); err != nil {
+			return fmt.Errorf("failed to send codecs: %w", err)
+		}
+	}
+	
+	// Frames of HDR screens must be tone mapped for our display
+	if err := c.sendCapabilities(); err != nil {
+		return fmt.Errorf("failed to send capabilities: %w", err)
+	}
+	
+	// Webcams in either direction, for calls on the server
+	if err := c.startCameras(); err != nil {
+		return fmt.Errorf("failed to start cameras: %w", err)
+	}
+	
+	// Cap the video bandwidth and set the frame rate before the first
+	// frames arrive
+	if c.maxBandwidth > 0 {
+		if err := c.sendBandwidthLimit(); err != nil {
+			return fmt.Errorf("failed to send bandwidth limit: %w", err)
+		}
+	}
+	
+	if c.frameRate > 0 {
+		if err := c.SendFrameRate(0, c.frameRate); err != nil {
+			return fmt.Errorf("failed to send frame rate: %w", err)
+		}
+	}
+	
+	if c.rateControl != (codec.RateControl{}) || len(c.captureScales) > 0 {
+		if err := c.sendQualityControl(); err != nil {
+			return fmt.Errorf("failed to send rate control: %w", err)
+		}
+	}
+	
+	// Monitors whose windows were closed stay detached after reconnecting
+	if err := c.sendDetached(); err != nil {
+		return fmt.Errorf("failed to detach monitors: %w", err)
+	}
+	
+	// Report how video arrives, the server adapts quality to it
+	go c.reportLoop(c.sessionDone)
+	
+	// Ask for video over UDP, frames keep coming over TCP until it is set
+	// up. A relay or tunnel only forwards the TCP connection.
+	if c.udpEnabled && c.relayAddress == "" && c.sshTarget == "" && !c.proxied {
+		if err := c.requestUDP(); err != nil {
+			return fmt.Errorf("failed to request UDP transport: %w", err)
+		}
+	}
+	return nil
+}
+
 // Stop shuts down the client
 func (c *Client) Stop() {
 	c.stopped = true
@@ -111,22 +428,19 @@ func (c *Client) Stop() {
 	if c.conn != nil {
 		c.conn.Close()
 	}
+	c.closeParallelConnections()
+	c.closeSSH()
+	c.closeDecoders()
+	if c.recorder != nil {
+		c.recorder.Close()
+	}
+	c.stopVideos()
 }
 
 // handleHandshake processes the initial handshake with the server
 func (c *Client) handleHandshake() error {
 	// Receive server's monitor configuration
-	packet, err := protocol.DecodePacket(c.conn)
-	if err != nil {
-		return err
-	}
-	
-	if packet.Type != protocol.PacketTypeHandshake {
-		return fmt.Errorf("expected handshake packet, got %d", packet.Type)
-	}
-	
-	// Decode server monitor configuration
-	serverMonitors, err := protocol.DecodeMonitorConfig(packet.Payload)
+	serverMonitors, err := c.receiveServerMonitors()
 	if err != nil {
 		return err
 	}
@@ -134,6 +448,11 @@ func (c *Client) handleHandshake() error {
 	c.serverMonitors = serverMonitors
 	log.Printf("Server has %d monitors", serverMonitors.MonitorCount)
 	
+	// A grid client asks for every server monitor
+	if c.grid {
+		c.localMonitors = gridMonitors(serverMonitors)
+	}
+	
 	// Send our monitor configuration to the server
 	monitorData := protocol.EncodeMonitorConfig(c.localMonitors)
 	responsePacket := protocol.NewPacket(protocol.PacketTypeMonitorConfig, monitorData)
@@ -145,9 +464,75 @@ func (c *Client) handleHandshake() error {
 	// Create monitor mapping
 	c.createMonitorMapping()
 	
+	// Show the last known screen until live frames arrive
+	c.loadCachedFrames()
+	
 	return nil
 }
 
+// receiveServerMonitors performs the server-initiated part of the handshake
+// (including encryption if required) and returns the server's monitors
+func (c *Client) receiveServerMonitors() (*protocol.MonitorConfig, error) {
+	conn, monitors, err := c.serverHandshake(c.conn)
+	c.conn = conn
+	return monitors, err
+}
+
+// serverHandshake runs the server-initiated part of the handshake on a
+// connection. It returns the connection to use from then on, which is
+// wrapped if encryption was negotiated.
+func (c *Client) serverHandshake(conn net.Conn) (net.Conn, *protocol.MonitorConfig, error) {
+	packet, err := protocol.DecodePacket(conn)
+	if err != nil {
+		return conn, nil, err
+	}
+	
+	// The server starts with a key exchange if it requires encryption
+	if packet.Type == protocol.PacketTypeKeyExchange {
+		if c.psk == nil {
+			return conn, nil, protocol.ErrEncryptionRequired
+		}
+		
+		secureConn, err := protocol.ClientKeyExchange(conn, c.psk, packet)
+		if err != nil {
+			return conn, nil, fmt.Errorf("key exchange failed: %w", err)
+		}
+		conn = secureConn
+		log.Println("Protocol encryption enabled")
+		
+		if packet, err = protocol.DecodePacket(conn); err != nil {
+			return conn, nil, err
+		}
+	} else if c.psk != nil {
+		return conn, nil, protocol.ErrEncryptionUnsupported
+	}
+	
+	// Answer the auth challenge if the server requires a password
+	if packet.Type == protocol.PacketTypeAuthChallenge {
+		if c.password == "" {
+			return conn, nil, protocol.ErrAuthRequired
+		}
+		
+		response := protocol.ComputeAuthResponse(c.password, packet.Payload)
+		if err := protocol.EncodePacket(conn, protocol.NewPacket(protocol.PacketTypeAuthResponse, response)); err != nil {
+			return conn, nil, fmt.Errorf("failed to send auth response: %w", err)
+		}
+		
+		// The server closes the connection if the password is wrong
+		if packet, err = protocol.DecodePacket(conn); err != nil {
+			return conn, nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	
+	if packet.Type != protocol.PacketTypeHandshake {
+		return conn, nil, fmt.Errorf("expected handshake packet, got %d", packet.Type)
+	}
+	
+	// Decode server monitor configuration
+	monitors, err := protocol.DecodeMonitorConfig(packet.Payload)
+	return conn, monitors, err
+}
+
 // createMonitorMapping maps server monitors to local monitors
 func (c *Client) createMonitorMapping() {
 	// Clear existing mapping
@@ -164,9 +549,10 @@ func (c *Client) createMonitorMapping() {
 		log.Printf("Mapped server monitor %d to local monitor %d", 
 			serverMonitor.ID, localMonitor.ID)
 		
-		// Initialize frame buffer for this monitor with a reasonable initial size
-		c.frameBuffers[localMonitor.ID] = make([]byte, 0, 1024*1024) // 1MB initial capacity
-		c.frameCount[localMonitor.ID] = 0 // Initialize frame counter
+		// Keep the frame count across reconnects
+		if _, ok := c.frameCount[localMonitor.ID]; !ok {
+			c.frameCount[localMonitor.ID] = 0 // Initialize frame counter
+		}
 	}
 	log.Printf("Created %d monitor mappings", len(c.monitorMap))
 	
@@ -182,16 +568,13 @@ func (c *Client) createMonitorMapping() {
 		log.Printf("  ID: %d, Size: %dx%d, Position: (%d,%d), Primary: %v", 
 			m.ID, m.Width, m.Height, m.PositionX, m.PositionY, m.Primary)
 	}
-	
-	// Create the debug directory for frames
-	debugDir := "debug_frames"
-	if err := os.MkdirAll(debugDir, 0755); err != nil {
-		log.Printf("Failed to create debug directory: %v", err)
-	}
 }
 
 // handlePacket processes an incoming packet from the server
 func (c *Client) handlePacket(packet *protocol.Packet) {
+    c.recordPacket(packet)
+    c.stats.PacketReceived(protocol.HeaderSize + len(packet.Payload))
+    
     switch packet.Type {
     case protocol.PacketTypeVideoFrame:
         // Process video frame
@@ -200,12 +583,30 @@ func (c *Client) handlePacket(packet *protocol.Packet) {
             return
         }
         
+        c.ackFrame(len(packet.Payload))
+        c.framesReceived.Add(1)
+        
         // First 4 bytes contain the monitor ID
         serverMonitorID := protocol.BytesToUint32(packet.Payload[0:4])
         frameData := packet.Payload[4:]
         
-        // Update frame buffer for this monitor
-        c.updateFrameBuffer(serverMonitorID, frameData)
+        c.markFrame(serverMonitorID, packet.Timestamp, frameData)
+        c.decodeFrame(serverMonitorID, frameData)
+        
+    case protocol.PacketTypeFrameUnchanged:
+        // The server doesn't resend idle screens, the last frame stays
+        // current
+        if len(packet.Payload) < 4 {
+            log.Println("Invalid frame unchanged packet")
+            return
+        }
+        
+    case protocol.PacketTypeCodecSelect:
+        // Server chose the codec of a monitor's following frames
+        c.handleCodecSelect(packet.Payload)
+        
+    case protocol.PacketTypeColorProfile:
+        c.handleColorProfile(packet.Payload)
         
     case protocol.PacketTypeAudioFrame:
         // Process audio frame
@@ -213,8 +614,74 @@ func (c *Client) handlePacket(packet *protocol.Packet) {
         return
         
     case protocol.PacketTypePong:
-        // Process pong response (for latency measurement)
-        // TODO: Calculate and display latency
+        // The server echoes our ping timestamp
+        c.stats.SetRTT(time.Since(time.Unix(0, packet.Timestamp)))
+        
+    case protocol.PacketTypeClipboard:
+        // Server clipboard changed
+        c.handleClipboard(packet.Payload)
+        
+    case protocol.PacketTypeBulkState:
+        // Server is sending bulk data such as a clipboard update
+        state, err := protocol.DecodeBulkState(packet.Payload)
+        if err != nil {
+            log.Printf("Invalid bulk state packet: %v", err)
+            return
+        }
+        c.bulkState.Store(&state)
+        
+    case protocol.PacketTypeBulkChunk:
+        // Part of bulk data split up so other packets can go between
+        c.handleBulkChunk(packet.Payload)
+        
+    case protocol.PacketTypeUDPSetup:
+        // Server accepted our request to receive video over UDP
+        if err := c.startUDP(packet.Payload); err != nil {
+            log.Printf("Failed to set up UDP transport, staying on TCP: %v", err)
+        }
+        
+    case protocol.PacketTypeStreamSetup:
+        // Server accepted our request for per-monitor connections
+        if err := c.startParallelConnections(packet.Payload); err != nil {
+            log.Printf("Failed to open per-monitor connections: %v", err)
+        }
+        
+    case protocol.PacketTypeLockState:
+        // Server screen was locked or unlocked, video is paused while locked
+        if len(packet.Payload) < 1 {
+            log.Println("Invalid lock state packet")
+            return
+        }
+        locked := packet.Payload[0] == 1
+        c.serverLocked.Store(locked)
+        if locked {
+            log.Println("Server screen is locked, video paused")
+        } else {
+            log.Println("Server screen unlocked, video resumed")
+        }
+        
+    case protocol.PacketTypeServerError:
+        // Server can't stream, e.g. without the permission to record its screen
+        serverErr, err := protocol.DecodeServerError(packet.Payload)
+        if err != nil {
+            log.Printf("Invalid server error packet: %v", err)
+            return
+        }
+        log.Printf("Server error: %s", serverErr.Message)
+        if serverErr.Code == protocol.ErrorVirtualCameraUnavailable {
+            c.cameraRefused.Store(true)
+        }
+        
+    case protocol.PacketTypeCameraFrame:
+        // A frame of the server's webcam, shown in its own window
+        c.handleCameraFrame(packet.Payload)
+        
+    case protocol.PacketTypeCursorShape:
+        // Server forwards the cursor rather than drawing it into frames
+        c.handleCursorShape(packet.Payload)
+        
+    case protocol.PacketTypeCursorPosition:
+        c.handleCursorPosition(packet.Payload)
         
     case protocol.PacketTypeMonitorConfig:
         // Server is sending an updated monitor configuration
@@ -225,77 +692,14 @@ func (c *Client) handlePacket(packet *protocol.Packet) {
             return
         }
         
+        // Frames are decoded and shown through the mapping
+        c.frameMutex.Lock()
         c.serverMonitors = serverMonitors
         c.createMonitorMapping()
+        c.frameMutex.Unlock()
     }
 }
 
-// updateFrameBuffer updates the frame buffer for a specific monitor
-func (c *Client) updateFrameBuffer(serverMonitorID uint32, frameData []byte) {
-    c.frameMutex.Lock()
-    defer c.frameMutex.Unlock()
-    
-    // Map server monitor ID to local monitor ID
-    localMonitorID, ok := c.monitorMap[serverMonitorID]
-    if !ok {
-        // Only log this occasionally to avoid log spam
-        if c.frameCount[0] % 30 == 0 {
-            log.Printf("No mapping found for server monitor ID %d", serverMonitorID)
-        }
-        c.frameCount[0]++
-        return
-    }
-    
-    // Validate JPEG header (SOI marker: FF D8)
-    if len(frameData) < 2 || frameData[0] != 0xFF || frameData[1] != 0xD8 {
-        log.Printf("Invalid JPEG data received for monitor %d: missing SOI marker", localMonitorID)
-        return
-    }
-    
-    // Store the raw JPEG data for rendering later
-    // Use a fresh slice with the exact capacity needed to avoid memory issues
-    newBuffer := make([]byte, len(frameData))
-    copy(newBuffer, frameData)
-    c.frameBuffers[localMonitorID] = newBuffer
-    
-    // Increment frame counter
-    c.frameCount[localMonitorID]++
-    
-    // Only log occasionally to avoid flooding
-    if c.frameCount[localMonitorID] % 30 == 0 {
-        log.Printf("Updated frame buffer for monitor %d (server ID: %d) with %d bytes of JPEG data (frame #%d)", 
-            localMonitorID, serverMonitorID, len(frameData), c.frameCount[localMonitorID])
-    }
-}
-
-
-
-// startInputCapture begins capturing user input
-func (c *Client) startInputCapture() {
-	// TODO: Implement platform-specific input capture
-	// This would use libraries like:
-	// - Windows: Raw Input API
-	// - macOS: Quartz Event Services
-	// - Linux: X11 or Wayland input APIs
-	
-	log.Println("Input capture started")
-	
-	// Placeholder for input capture loop
-	for !c.stopped {
-		// 1. Capture mouse/keyboard events
-		// 2. Create appropriate packets
-		// 3. Send to server
-		
-		// Check if we should stop
-		select {
-		case <-c.stopChan:
-			return
-		default:
-			// Continue capturing
-		}
-	}
-}
-
 // SendQualityControl sends a quality control packet to the server
 func (c *Client) SendQualityControl(quality int) error {
 	if quality < 0 {
@@ -305,12 +709,10 @@ func (c *Client) SendQualityControl(quality int) error {
 	}
 	
 	c.qualityLevel = quality
+	c.qualitySent = true
 	
-	// Create quality control packet
-	payload := []byte{byte(quality)}
-	packet := protocol.NewPacket(protocol.PacketTypeQualityControl, payload)
-	
-	return protocol.EncodePacket(c.conn, packet)
+	// The rate control asked for goes along, see sendQualityControl
+	return c.sendQualityControl()
 }
 
 // SendPing sends a ping packet to measure latency
@@ -318,6 +720,27 @@ func (c *Client) SendPing() error {
 	// Create ping packet with current timestamp
 	packet := protocol.NewPacket(protocol.PacketTypePing, nil)
 	
+	return c.send(packet)
+}
+
+// ackFrame acknowledges a received video frame with the total number of
+// video bytes received, the server paces video to the rate and delay of
+// the acknowledgements
+func (c *Client) ackFrame(size int) {
+	received := c.videoReceived.Add(uint64(size))
+	packet := protocol.NewPacket(protocol.PacketTypeFrameAck, protocol.Uint64ToBytes(received))
+	if err := c.sendInput(packet); err != nil && !c.stopped {
+		log.Printf("Error acknowledging frame: %v", err)
+	}
+}
+
+// send writes a packet to the server. Packets are sent from several
+// goroutines, so writes are serialized.
+func (c *Client) send(packet *protocol.Packet) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	
+	c.stats.PacketSent(protocol.HeaderSize + len(packet.Payload))
 	return protocol.EncodePacket(c.conn, packet)
 }
 
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/moderniselife/ultrardp/clipboard"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+const (
+	// clipboardPollInterval is how often the local clipboard is checked
+	clipboardPollInterval = 500 * time.Millisecond
+
+	// clipboardPickerTimeout is how long the picker stays in the window title
+	clipboardPickerTimeout = 3 * time.Second
+)
+
+// WithClipboardSync enables text clipboard synchronization with the server
+func WithClipboardSync() Option {
+	return func(c *Client) {
+		c.clipboard = clipboard.NewSync(clipboard.OwnerClient, clipboard.DefaultHistorySize)
+	}
+}
+
+// pollClipboard sends local clipboard changes to the server
+func (c *Client) pollClipboard() {
+	ticker := time.NewTicker(clipboardPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		text, err := clipboard.Read()
+		if err != nil {
+			log.Printf("Clipboard unavailable, disabling clipboard sync: %v", err)
+			return
+		}
+
+		if update, changed := c.clipboard.LocalChange(text); changed {
+			c.sendClipboardUpdate(update)
+		}
+	}
+}
+
+// sendClipboardUpdate sends a clipboard update to the server
+func (c *Client) sendClipboardUpdate(update clipboard.Update) {
+	packet := protocol.NewPacket(protocol.PacketTypeClipboard, clipboard.EncodeUpdate(update))
+	if err := c.send(packet); err != nil {
+		log.Printf("Error sending clipboard update: %v", err)
+	}
+}
+
+// handleClipboard applies a clipboard update from the server
+func (c *Client) handleClipboard(payload []byte) {
+	if c.clipboard == nil {
+		return
+	}
+
+	update, err := clipboard.DecodeUpdate(payload)
+	if err != nil {
+		log.Printf("Invalid clipboard packet: %v", err)
+		return
+	}
+
+	if c.clipboard.RemoteUpdate(update) {
+		if err := clipboard.Write(update.Text); err != nil {
+			log.Printf("Failed to write clipboard: %v", err)
+		}
+	}
+}
+
+// cycleClipboardHistory is the clipboard picker: each call selects the next
+// older history item, puts it on the clipboard of both sides and shows a
+// preview in the window title
+func (c *Client) cycleClipboardHistory() {
+	if c.clipboard == nil {
+		return
+	}
+
+	history := c.clipboard.History()
+	if len(history) == 0 {
+		return
+	}
+
+	// Start from the newest item again once the picker has timed out
+	if time.Since(c.clipboardPickerTime) > clipboardPickerTimeout {
+		c.clipboardPickerIndex = 0
+	}
+	c.clipboardPickerIndex = (c.clipboardPickerIndex + 1) % len(history)
+	c.clipboardPickerTime = time.Now()
+
+	item := history[c.clipboardPickerIndex]
+	preview := strings.Join(strings.Fields(item.Text), " ")
+	if len(preview) > 40 {
+		preview = preview[:40] + "..."
+	}
+	c.clipboardPickerTitle = fmt.Sprintf("Clipboard %d/%d: %s", c.clipboardPickerIndex+1, len(history), preview)
+
+	// Writing the clipboard runs an external tool, keep it off the main thread
+	go func() {
+		if err := clipboard.Write(item.Text); err != nil {
+			log.Printf("Failed to write clipboard: %v", err)
+			return
+		}
+		if update, changed := c.clipboard.LocalChange(item.Text); changed {
+			c.sendClipboardUpdate(update)
+		}
+	}()
+}
+
+// clipboardPickerStatus returns the picker preview while it is active
+func (c *Client) clipboardPickerStatus() string {
+	if time.Since(c.clipboardPickerTime) > clipboardPickerTimeout {
+		return ""
+	}
+	return c.clipboardPickerTitle
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"log"
+	"slices"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithCodecs offers the server the given codecs for video, most preferred
+// first. They must be decodable here, see codec.Decoders. Without this
+// option, or if the server supports none of them, video arrives as JPEG.
+func WithCodecs(codecs []codec.ID) Option {
+	return func(c *Client) {
+		c.codecs = codecs
+	}
+}
+
+// sendCodecs tells the server which codecs we can decode
+func (c *Client) sendCodecs() error {
+	c.codecsMutex.Lock()
+	payload := make([]byte, len(c.codecs))
+	for i, id := range c.codecs {
+		payload[i] = byte(id)
+	}
+	c.codecsMutex.Unlock()
+	return c.send(protocol.NewPacket(protocol.PacketTypeCodecs, payload))
+}
+
+// toggleLossless switches between lossless video and the codecs offered
+// before, e.g. while working on a design that compression artifacts would
+// spoil. The server selects the monitors' codecs again.
+func (c *Client) toggleLossless() {
+	if !codec.CanDecode(codec.Lossless) {
+		log.Printf("Lossless video isn't available, build with -tags zstd")
+		return
+	}
+
+	c.codecsMutex.Lock()
+	if len(c.codecs) > 0 && c.codecs[0] == codec.Lossless {
+		c.codecs = c.codecs[1:]
+		log.Println("Lossless video off")
+	} else {
+		c.codecs = append([]codec.ID{codec.Lossless}, slices.DeleteFunc(slices.Clone(c.codecs), func(id codec.ID) bool {
+			return id == codec.Lossless
+		})...)
+		log.Println("Lossless video on")
+	}
+	c.codecsMutex.Unlock()
+
+	if err := c.sendCodecs(); err != nil && !c.stopped {
+		log.Printf("Error sending codecs: %v", err)
+	}
+}
+
+// handleCodecSelect prepares a decoder for the codec the server chose for
+// a monitor
+func (c *Client) handleCodecSelect(payload []byte) {
+	serverMonitorID, id, err := protocol.DecodeCodecSelect(payload)
+	if err != nil {
+		log.Printf("Invalid codec select packet: %v", err)
+		return
+	}
+	selected := codec.ID(id)
+
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+
+	if decoder, ok := c.decoders[serverMonitorID]; ok {
+		if decoder.Codec() == selected {
+			return
+		}
+		decoder.Close()
+		delete(c.decoders, serverMonitorID)
+	}
+	log.Printf("Receiving monitor %d as %s", serverMonitorID, selected)
+
+	decoder, err := codec.NewDecoder(selected)
+	if err != nil {
+		log.Printf("Failed to create %s decoder for monitor %d: %v", selected, serverMonitorID, err)
+		return
+	}
+	c.decoders[serverMonitorID] = decoder
+}
+
+// decodeFrame decodes a frame with the monitor's decoder and stores the
+// picture for the display loop. Frames that are pictures on their own are
+// decoded by the decode workers instead, see decodePool.
+func (c *Client) decodeFrame(serverMonitorID uint32, frameData []byte) {
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+
+	localMonitorID, ok := c.monitorMap[serverMonitorID]
+	if !ok {
+		return
+	}
+
+	// Frames may overtake the codec selection when they arrive over UDP.
+	// JPEG frames may arrive whatever codec was selected, and PNG frames of
+	// text too; they need no earlier frames.
+	if id, still := stillCodec(frameData); still {
+		c.stats.Frame(serverMonitorID, len(frameData))
+		c.stats.Keyframe(serverMonitorID)
+		if id == codec.JPEG {
+			c.cacheFrame(serverMonitorID, frameData)
+		}
+		c.submitDecode(decodeJob{
+			serverMonitorID: serverMonitorID,
+			localMonitorID:  localMonitorID,
+			codec:           id,
+			data:            frameData,
+		})
+		return
+	}
+	decoder, ok := c.decoders[serverMonitorID]
+	if !ok {
+		c.frameDropped(serverMonitorID)
+		return
+	}
+
+	img, err := decoder.Decode(frameData)
+	if err != nil {
+		log.Printf("Error decoding %s frame for monitor %d: %v", decoder.Codec(), serverMonitorID, err)
+		if decoder.Codec().Predictive() {
+			c.requestKeyframe(serverMonitorID)
+		}
+		return
+	}
+	c.stats.Frame(serverMonitorID, len(frameData))
+	if decoder.Codec() == codec.H264 && codec.IsKeyframe(frameData) {
+		c.stats.Keyframe(serverMonitorID)
+	}
+
+	// Frames before the first keyframe produce no picture
+	if img == nil {
+		return
+	}
+	frame := displayFrame(img, c.colorTransforms[localMonitorID])
+	c.publishFrame(localMonitorID, c.nextFrameSequence(localMonitorID), frame)
+}
+
+// stillCodec returns the codec of a frame that is a picture on its own,
+// JPEG or PNG
+func stillCodec(frameData []byte) (codec.ID, bool) {
+	switch {
+	case codec.IsJPEG(frameData):
+		return codec.JPEG, true
+	case codec.IsPNG(frameData):
+		return codec.PNG, true
+	}
+	return 0, false
+}
+
+// closeDecoders releases the decoders, the next connection selects codecs
+// again
+func (c *Client) closeDecoders() {
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+
+	for serverMonitorID, decoder := range c.decoders {
+		decoder.Close()
+		delete(c.decoders, serverMonitorID)
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/icc"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithColorCorrection converts frames of server monitors with a color
+// profile to sRGB, so colors of a wide-gamut server display don't look
+// oversaturated or washed out
+func WithColorCorrection() Option {
+	return func(c *Client) {
+		c.colorCorrection = true
+	}
+}
+
+// handleColorProfile prepares the conversion of a server monitor's frames
+// from its color profile
+func (c *Client) handleColorProfile(payload []byte) {
+	if !c.colorCorrection {
+		return
+	}
+	serverMonitorID, data, err := protocol.DecodeColorProfile(payload)
+	if err != nil {
+		log.Printf("Invalid color profile packet: %v", err)
+		return
+	}
+	profile, err := icc.Parse(data)
+	if err != nil {
+		log.Printf("Can't use the color profile of monitor %d: %v", serverMonitorID, err)
+		return
+	}
+
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+	localMonitorID, ok := c.monitorMap[serverMonitorID]
+	if !ok {
+		return
+	}
+	transform := profile.ToSRGB()
+	if transform == nil {
+		delete(c.colorTransforms, localMonitorID)
+		return
+	}
+	c.colorTransforms[localMonitorID] = transform
+	log.Printf("Converting the colors of monitor %d to sRGB", serverMonitorID)
+}
+
+// colorTransform returns the conversion of a local monitor's frames to
+// sRGB, nil if they are shown as they are
+func (c *Client) colorTransform(localMonitorID uint32) *icc.Transform {
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+	return c.colorTransforms[localMonitorID]
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"image"
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// maxCursorShapes is how many cursor shapes are kept, the server only
+// resends shapes it didn't send yet
+const maxCursorShapes = 64
+
+// handleCursorShape keeps a cursor shape the server forwarded
+func (c *Client) handleCursorShape(payload []byte) {
+	shape, err := protocol.DecodeCursorShape(payload)
+	if err != nil {
+		log.Printf("Invalid cursor shape packet: %v", err)
+		return
+	}
+
+	c.cursorMutex.Lock()
+	defer c.cursorMutex.Unlock()
+	if c.cursorShapes == nil || len(c.cursorShapes) >= maxCursorShapes {
+		c.cursorShapes = make(map[uint32]*protocol.CursorShape)
+	}
+	c.cursorShapes[shape.ID] = &shape
+}
+
+// handleCursorPosition moves the cursor the server forwarded. Moves on a
+// monitor glide from where the cursor is drawn to the new position.
+func (c *Client) handleCursorPosition(payload []byte) {
+	position, err := protocol.DecodeCursorPosition(payload)
+	if err != nil {
+		log.Printf("Invalid cursor position packet: %v", err)
+		return
+	}
+	monitor := c.serverMonitorSize(position.MonitorID)
+
+	c.cursorMutex.Lock()
+	defer c.cursorMutex.Unlock()
+	now := time.Now()
+	motion := cursorMotion{fromX: float64(position.X), fromY: float64(position.Y), arrived: now}
+	if c.cursor.Visible && c.cursor.MonitorID == position.MonitorID {
+		if since := now.Sub(c.cursorMotion.arrived); since < cursorGlide {
+			motion.fromX, motion.fromY = c.cursorMotion.at(c.cursor, now)
+			motion.duration = since
+		}
+	}
+	c.cursor, c.cursorMotion, c.cursorMonitor = position, motion, monitor
+}
+
+// serverMonitorSize returns the size of a server monitor in the pixels
+// cursor positions are given in, zero if it's unknown
+func (c *Client) serverMonitorSize(serverMonitorID uint32) image.Point {
+	if c.serverMonitors == nil {
+		return image.Point{}
+	}
+	for _, m := range c.serverMonitors.Monitors {
+		if m.ID == serverMonitorID {
+			return image.Pt(int(m.Width), int(m.Height))
+		}
+	}
+	return image.Point{}
+}
+
+// cursorOn returns where the forwarded cursor is drawn over a frame of the
+// given size of a server monitor, if it is shown on it
+func (c *Client) cursorOn(serverMonitorID uint32, frame image.Point) (*cursorSprite, bool) {
+	c.cursorMutex.Lock()
+	defer c.cursorMutex.Unlock()
+	if !c.cursor.Visible || c.cursor.MonitorID != serverMonitorID {
+		return nil, false
+	}
+	shape, ok := c.cursorShapes[c.cursor.ShapeID]
+	if !ok || shape.Width <= 0 || shape.Height <= 0 || len(shape.Pixels) < shape.Width*shape.Height*4 {
+		return nil, false
+	}
+
+	// Frames may be captured at another size than the monitor's
+	scaleX, scaleY := 1.0, 1.0
+	if c.cursorMonitor.X > 0 && c.cursorMonitor.Y > 0 {
+		scaleX = float64(frame.X) / float64(c.cursorMonitor.X)
+		scaleY = float64(frame.Y) / float64(c.cursorMonitor.Y)
+	}
+	x, y := c.cursorMotion.at(c.cursor, time.Now())
+	return &cursorSprite{
+		shape:  shape,
+		x:      float32((x - float64(shape.HotspotX)) * scaleX),
+		y:      float32((y - float64(shape.HotspotY)) * scaleY),
+		width:  float32(float64(shape.Width) * scaleX),
+		height: float32(float64(shape.Height) * scaleY),
+	}, true
+}
+
+// cursorGlide is the longest the forwarded cursor takes to move to a new
+// position: updates closer together are glided between over the time
+// between them, so the cursor moves smoothly whatever the frame rate,
+// while updates further apart jump
+const cursorGlide = 50 * time.Millisecond
+
+// cursorMotion is the forwarded cursor's move to its latest position
+type cursorMotion struct {
+	fromX, fromY float64       // Where the cursor was drawn when the position arrived
+	arrived      time.Time     // When the position did
+	duration     time.Duration // How long the move takes, 0 to jump
+}
+
+// at returns where the cursor's hotspot is drawn at a time on its way to
+// position, in monitor pixels
+func (m cursorMotion) at(position protocol.CursorPosition, now time.Time) (float64, float64) {
+	toX, toY := float64(position.X), float64(position.Y)
+	elapsed := now.Sub(m.arrived)
+	if m.duration <= 0 || elapsed >= m.duration {
+		return toX, toY
+	}
+	t := float64(elapsed) / float64(m.duration)
+	return m.fromX + (toX-m.fromX)*t, m.fromY + (toY-m.fromY)*t
+}
+
+// cursorSprite is where the forwarded cursor's shape is drawn over a
+// frame, in frame pixels
+type cursorSprite struct {
+	shape               *protocol.CursorShape
+	x, y, width, height float32 // The shape's top left corner and size
+}
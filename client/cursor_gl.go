@@ -0,0 +1,55 @@
+//go:build cgo
+
+package client
+
+import (
+	"image"
+
+	"github.com/go-gl/gl/v2.1/gl"
+)
+
+// drawCursor draws a forwarded cursor over the rendered frame of the given
+// size placed by layout. It expects the projection set up by
+// renderSimpleFullscreenTexture.
+func drawCursor(cursor *cursorSprite, frame image.Point, layout frameLayout) {
+	if frame.X <= 0 || frame.Y <= 0 {
+		return
+	}
+	shape := cursor.shape
+
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(shape.Width), int32(shape.Height), 0,
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(shape.Pixels))
+
+	// Frame pixels map to the window the way the frame's texture does,
+	// turned with it
+	rect := layout.rect(cursor.x, cursor.y, cursor.width, cursor.height, frame)
+	x0, y0, x1, y1 := rect[0], rect[1], rect[2], rect[3]
+	uv := layout.rotation.corners([4]float32{0, 0, 1, 1})
+
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	gl.Enable(gl.TEXTURE_2D)
+	gl.Color4f(1.0, 1.0, 1.0, 1.0)
+	gl.Begin(gl.QUADS)
+	gl.TexCoord2f(uv[0][0], uv[0][1])
+	gl.Vertex2f(x0, y0)
+	gl.TexCoord2f(uv[1][0], uv[1][1])
+	gl.Vertex2f(x1, y0)
+	gl.TexCoord2f(uv[3][0], uv[3][1])
+	gl.Vertex2f(x1, y1)
+	gl.TexCoord2f(uv[2][0], uv[2][1])
+	gl.Vertex2f(x0, y1)
+	gl.End()
+	gl.Disable(gl.TEXTURE_2D)
+	gl.Disable(gl.BLEND)
+
+	gl.DeleteTextures(1, &texture)
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/moderniselife/ultrardp/diagnostics"
+)
+
+// debugFrameDir is where decoded frames are dumped
+const debugFrameDir = "debug_frames"
+
+// WithDebugFrames saves every 30th frame drawn in each window, as decoded,
+// to debug_frames, keeping at most limit bytes of them
+func WithDebugFrames(limit int64) Option {
+	return func(c *Client) {
+		c.debugFramesLimit = limit
+	}
+}
+
+// openFrameDump opens the debug frame dump if enabled. Without it frames
+// aren't dumped.
+func (c *Client) openFrameDump() {
+	if c.debugFramesLimit <= 0 {
+		return
+	}
+	dump, err := diagnostics.OpenFrameDump(debugFrameDir, c.debugFramesLimit)
+	if err != nil {
+		log.Printf("Could not open debug frame directory, frames aren't saved: %v", err)
+		return
+	}
+	log.Printf("Saving debug frames to %s, up to %d MB", debugFrameDir, c.debugFramesLimit>>20)
+	c.frameDump = dump
+}
+
+// dumpFrame saves a local monitor's decoded frame in the background, as
+// frames aren't modified once decoded
+func (c *Client) dumpFrame(localMonitorID uint32, frameNumber int, frame *image.RGBA) {
+	name := fmt.Sprintf("decoded_mon%d_%d.png", localMonitorID, frameNumber)
+	go c.frameDump.WriteImage(name, frame)
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"image"
+	"image/draw"
+	"log"
+	"runtime"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/icc"
+)
+
+// maxDecodeWorkers caps the decode workers, a few keep up with several
+// 4K monitors
+const maxDecodeWorkers = 4
+
+// decodeJob is a frame that is a picture on its own, waiting for a decode
+// worker
+type decodeJob struct {
+	serverMonitorID uint32
+	localMonitorID  uint32
+	sequence        uint64 // Order of the frame among its monitor's frames
+	codec           codec.ID
+	data            []byte
+}
+
+// decodePool decodes JPEG and PNG frames on worker goroutines, with
+// libjpeg-turbo in builds with -tags turbojpeg, so neither the connection
+// nor the display loop waits for them. Only the latest frame of a monitor
+// is kept waiting, a newer one replaces it.
+type decodePool struct {
+	start   sync.Once
+	mutex   sync.Mutex
+	pending map[uint32]decodeJob // By local monitor ID
+	ready   chan uint32          // Local monitors with a pending frame
+
+	sequences map[uint32]uint64 // Last frame sequence by local monitor ID, guarded by frameMutex
+	shown     map[uint32]uint64 // Sequence of the frame in frames, guarded by frameMutex
+}
+
+// submitDecode queues a still frame for the decode workers, starting them
+// on first use. Must be called with frameMutex held.
+func (c *Client) submitDecode(job decodeJob) {
+	p := &c.decodePool
+	p.start.Do(func() {
+		p.pending = make(map[uint32]decodeJob)
+		p.ready = make(chan uint32, 64)
+		workers := min(runtime.NumCPU(), maxDecodeWorkers)
+		for i := 0; i < workers; i++ {
+			go c.decodeWorker()
+		}
+	})
+	job.sequence = c.nextFrameSequence(job.localMonitorID)
+
+	p.mutex.Lock()
+	_, queued := p.pending[job.localMonitorID]
+	p.pending[job.localMonitorID] = job
+	p.mutex.Unlock()
+
+	// A monitor is in ready at most once, so this doesn't block with
+	// fewer monitors than its capacity
+	if !queued {
+		p.ready <- job.localMonitorID
+	}
+}
+
+// workerDecoder identifies a decode worker's decoder of a monitor's frames
+type workerDecoder struct {
+	localMonitorID uint32
+	codec          codec.ID
+}
+
+// decodeWorker decodes pending frames until the client stops, with a
+// decoder per monitor and codec created on first use
+func (c *Client) decodeWorker() {
+	p := &c.decodePool
+	decoders := make(map[workerDecoder]codec.Decoder)
+	defer func() {
+		for _, decoder := range decoders {
+			decoder.Close()
+		}
+	}()
+
+	for {
+		var localMonitorID uint32
+		select {
+		case localMonitorID = <-p.ready:
+		case <-c.stopChan:
+			return
+		}
+
+		p.mutex.Lock()
+		job, ok := p.pending[localMonitorID]
+		delete(p.pending, localMonitorID)
+		p.mutex.Unlock()
+		if !ok {
+			continue
+		}
+
+		key := workerDecoder{job.localMonitorID, job.codec}
+		decoder, ok := decoders[key]
+		if !ok {
+			var err error
+			if decoder, err = codec.NewDecoder(job.codec); err != nil {
+				log.Printf("Failed to create %s decoder: %v", job.codec, err)
+				continue
+			}
+			decoders[key] = decoder
+		}
+		img, err := decoder.Decode(job.data)
+		if err != nil {
+			log.Printf("Error decoding %s frame for monitor %d: %v", job.codec, job.serverMonitorID, err)
+			// Start over with a fresh decoder
+			decoder.Close()
+			delete(decoders, key)
+			continue
+		}
+		frame := displayFrame(img, c.colorTransform(job.localMonitorID))
+
+		c.frameMutex.Lock()
+		c.publishFrame(job.localMonitorID, job.sequence, frame)
+		c.frameMutex.Unlock()
+	}
+}
+
+// nextFrameSequence numbers the next frame of a local monitor. Must be
+// called with frameMutex held.
+func (c *Client) nextFrameSequence(localMonitorID uint32) uint64 {
+	p := &c.decodePool
+	if p.sequences == nil {
+		p.sequences = make(map[uint32]uint64)
+		p.shown = make(map[uint32]uint64)
+	}
+	p.sequences[localMonitorID]++
+	return p.sequences[localMonitorID]
+}
+
+// publishFrame hands a decoded frame to the display loop, unless a newer
+// frame of the monitor was already, e.g. by another worker. Must be called
+// with frameMutex held.
+func (c *Client) publishFrame(localMonitorID uint32, sequence uint64, frame *image.RGBA) {
+	p := &c.decodePool
+	if sequence <= p.shown[localMonitorID] {
+		return
+	}
+	p.shown[localMonitorID] = sequence
+	c.frames.store(localMonitorID, frame)
+	c.frameCount[localMonitorID]++
+	c.recordFrame(localMonitorID, frame)
+}
+
+// displayFrame converts a decoded frame to the RGBA pixels textures are
+// uploaded from, in sRGB if transform is set. Frames already in RGBA that
+// need no conversion are used as they are.
+func displayFrame(img image.Image, transform *icc.Transform) *image.RGBA {
+	rgba, ok := img.(*image.RGBA)
+	if ok && transform == nil {
+		return rgba
+	}
+	rgba = image.NewRGBA(image.Rectangle{Max: img.Bounds().Size()})
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	if transform != nil {
+		transform.Apply(rgba)
+	}
+	return rgba
+}
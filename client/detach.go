@@ -0,0 +1,38 @@
+package client
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// detachMonitor asks the server to stop sending a monitor whose window was
+// closed. It stays detached for the rest of the session, also after
+// reconnecting.
+func (c *Client) detachMonitor(serverMonitorID uint32) {
+	c.detachedMutex.Lock()
+	if c.detached == nil {
+		c.detached = make(map[uint32]bool)
+	}
+	c.detached[serverMonitorID] = true
+	c.detachedMutex.Unlock()
+
+	packet := protocol.NewPacket(protocol.PacketTypeMonitorDetach, protocol.Uint32ToBytes(serverMonitorID))
+	if err := c.send(packet); err != nil && !c.stopped {
+		log.Printf("Error detaching monitor %d: %v", serverMonitorID, err)
+	}
+}
+
+// sendDetached detaches the monitors whose windows were closed on a new
+// connection
+func (c *Client) sendDetached() error {
+	c.detachedMutex.Lock()
+	defer c.detachedMutex.Unlock()
+	for serverMonitorID := range c.detached {
+		packet := protocol.NewPacket(protocol.PacketTypeMonitorDetach, protocol.Uint32ToBytes(serverMonitorID))
+		if err := c.send(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,54 +1,45 @@
+//go:build cgo
+
 package client
 
 import (
 	"fmt"
 	"time"
 	"os"
-	"path/filepath"
-	"bytes"
 	"image"
-	"image/jpeg"
-	"image/png"
 	_ "image/png"
 	_ "image/jpeg"
-	"image/draw"
 
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
 )
 
-// Create a debug directory for saving frames
-func createDebugDir(dir string) {
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		fmt.Fprintf(os.Stdout, "Failed to create debug directory: %v\n", err)
-	}
-}
+// displayState holds the GLFW windows, one per local monitor, and the
+// window of the server's webcam
+type displayState struct {
+	windows      []*glfw.Window
+	presenters   []presenter  // By window index, nil when rendering with OpenGL
+	scaling      []ScaleMode   // By window index, see WithScaleModes
+	layouts      []frameLayout // Where the last frame was drawn, by window index
+	resized      []time.Time   // When windows were resized, zero once handled, see scaleResizedWindows
+	textures     []streamTexture // Frame textures by window index, with OpenGL
+	overlays     []overlayFrame  // Frames with the control bar or stats over them, by window index
+	interpolators []frameInterpolator // Blends between frames by window index, see WithFrameInterpolation
+	gridView gridView // Monitors shown in a grid client's window, see WithGrid
+	views        []viewport      // Zoomed in or panned part of the frame by window index, see zoomKey
+	touches      []touchGesture  // Touch gestures by window index, see WithTouch
+	adjustments  []ColorAdjust   // By window index, see WithColorAdjustments
+	adjustPrograms []colorAdjustProgram // Shaders applying adjustments by window index, with OpenGL
+	cameraWindow *glfw.Window // nil until the server's webcam sends a frame
+	cameraClosed bool         // Whether the camera window was closed
 
-// Save image to file for debugging
-func saveImageToFile(img image.Image, monitorID uint32, frameNum int, format string) string {
-	debugDir := "debug_frames"
-	createDebugDir(debugDir)
-	
-	filename := filepath.Join(debugDir, fmt.Sprintf("decoded_mon%d_%d.%s", monitorID, frameNum, format))
-	f, err := os.Create(filename)
-	if err != nil {
-		fmt.Fprintf(os.Stdout, "Error creating debug file: %v\n", err)
-		return ""
-	}
-	defer f.Close()
-	
-	if format == "jpg" {
-		jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
-	} else if format == "png" {
-		png.Encode(f, img)
-	}
-	
-	fmt.Fprintf(os.Stdout, "Saved decoded image to %s\n", filename)
-	return filename
+	cameraPresenter presenter // Presenter of the camera window, nil with OpenGL
+	cameraTexture   streamTexture // Frame texture of the camera window, with OpenGL
 }
 
-// renderSimpleFullscreenTexture renders a texture using the simplest possible approach
-func renderSimpleFullscreenTexture(textureID uint32) {
+// renderSimpleFullscreenTexture renders a texture using the simplest possible approach,
+// the part layout.src of it over the part layout.dst of the window
+func renderSimpleFullscreenTexture(textureID uint32, layout frameLayout) {
 	// Reset OpenGL state completely
 	gl.GetError() // Clear any previous errors
 	
@@ -58,10 +49,11 @@ func renderSimpleFullscreenTexture(textureID uint32) {
 	gl.Disable(gl.BLEND)
 	gl.Disable(gl.LIGHTING)
 	
-	// Set up a simple orthographic projection
+	// Set up a simple orthographic projection, with the origin at the top
+	// left like frames and layouts
 	gl.MatrixMode(gl.PROJECTION)
 	gl.LoadIdentity()
-	gl.Ortho(0, 1, 0, 1, -1, 1)
+	gl.Ortho(0, 1, 1, 0, -1, 1)
 	
 	gl.MatrixMode(gl.MODELVIEW)
 	gl.LoadIdentity()
@@ -79,12 +71,13 @@ func renderSimpleFullscreenTexture(textureID uint32) {
 	// Set color to pure white (1,1,1,1) to show texture as-is
 	gl.Color4f(1.0, 1.0, 1.0, 1.0)
 	
-	// Draw a fullscreen quad with the texture - with standard orientation
+	// Draw a quad with the texture, turned by the layout's rotation
+	dst, uv := layout.dst, layout.texCoords()
 	gl.Begin(gl.QUADS)
-	gl.TexCoord2f(0.0, 0.0); gl.Vertex2f(0.0, 0.0) // Bottom-left
-	gl.TexCoord2f(1.0, 0.0); gl.Vertex2f(1.0, 0.0) // Bottom-right
-	gl.TexCoord2f(1.0, 1.0); gl.Vertex2f(1.0, 1.0) // Top-right
-	gl.TexCoord2f(0.0, 1.0); gl.Vertex2f(0.0, 1.0) // Top-left
+	gl.TexCoord2f(uv[0][0], uv[0][1]); gl.Vertex2f(dst[0], dst[1]) // Top-left
+	gl.TexCoord2f(uv[1][0], uv[1][1]); gl.Vertex2f(dst[2], dst[1]) // Top-right
+	gl.TexCoord2f(uv[3][0], uv[3][1]); gl.Vertex2f(dst[2], dst[3]) // Bottom-right
+	gl.TexCoord2f(uv[2][0], uv[2][1]); gl.Vertex2f(dst[0], dst[3]) // Bottom-left
 	gl.End()
 	
 	// Disable texturing when done
@@ -113,13 +106,29 @@ func (c *Client) createWindows() error {
 		}
 	}
 	
+	// A grid client has one window on the primary monitor
+	if c.grid {
+		monitors = monitors[:1]
+	}
+	
 	// Initialize windows slice - use GLFW monitor count
 	monitorCount := len(monitors)
 	fmt.Printf("Creating %d windows\n", monitorCount)
 	c.windows = make([]*glfw.Window, monitorCount)
-	
-	// Create textures - this will be populated later
-	textures := make(map[int]uint32)
+	c.scaling = make([]ScaleMode, monitorCount)
+	c.layouts = make([]frameLayout, monitorCount)
+	c.resized = make([]time.Time, monitorCount)
+	c.overlays = make([]overlayFrame, monitorCount)
+	c.interpolators = make([]frameInterpolator, monitorCount)
+	c.views = make([]viewport, monitorCount)
+	c.touches = make([]touchGesture, monitorCount)
+	c.adjustments = make([]ColorAdjust, monitorCount)
+	c.adjustPrograms = make([]colorAdjustProgram, monitorCount)
+	for i := range c.scaling {
+		c.scaling[i] = c.initialScaleMode(i)
+		c.adjustments[i] = c.initialColorAdjust(i)
+		c.layouts[i] = stretchLayout
+	}
 	
 	// Create a window for each monitor (following the working example's approach)
 	for i, monitor := range monitors {
@@ -129,31 +138,46 @@ func (c *Client) createWindows() error {
 		glfw.DefaultWindowHints()
 		glfw.WindowHint(glfw.Visible, glfw.True)
 		glfw.WindowHint(glfw.Decorated, glfw.True)
-		glfw.WindowHint(glfw.Resizable, glfw.False)
-		glfw.WindowHint(glfw.ContextVersionMajor, 2)
-		glfw.WindowHint(glfw.ContextVersionMinor, 1)
-		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLAnyProfile)
+		glfw.WindowHint(glfw.Resizable, glfw.True)
+		// Full screen windows stay up while another one has focus
+		glfw.WindowHint(glfw.AutoIconify, glfw.False)
+		c.setRendererHints()
 		
 		// Get monitor dimensions
 		mode := monitor.GetVideoMode()
 		x, y := monitor.GetPos()
 		
-		// Fixed window size for debugging
-		width, height := 800, 600
+		// Windows cover part of their monitor, or all of it in full screen
+		width, height := windowedSize(mode)
+		fullscreen := c.fullscreen
+		
+		// A shared application window is shown at its own size
+		if shared, ok := c.sharedWindow(uint32(i + 1)); ok {
+			width, height = min(int(shared.Width), mode.Width), min(int(shared.Height), mode.Height)
+			fullscreen = false
+		}
+		var fullscreenMonitor *glfw.Monitor
+		if fullscreen {
+			setFullscreenHints(mode)
+			width, height = mode.Width, mode.Height
+			fullscreenMonitor = monitor
+		}
 		
 		// Create window - using exact same approach as the working example
 		window, err := glfw.CreateWindow(
 			width, height,
 			fmt.Sprintf("UltraRDP - Monitor %d", i),
-			nil, nil)
+			fullscreenMonitor, nil)
 		
 		if err != nil {
 			fmt.Printf("Failed to create window for monitor %d: %v\n", i, err)
 			continue
 		}
 		
-		// Position window on monitor
-		if x >= -10000 && x <= 10000 && y >= -10000 && y <= 10000 {
+		// Position window on monitor, full screen windows already cover it
+		if fullscreen {
+			fmt.Printf("Window %d is full screen at %dx%d@%dHz\n", i, mode.Width, mode.Height, mode.RefreshRate)
+		} else if x >= -10000 && x <= 10000 && y >= -10000 && y <= 10000 {
 			centerX := x + (mode.Width - width) / 2
 			centerY := y + (mode.Height - height) / 2
 			fmt.Printf("Window %d position: %d,%d\n", i, centerX, centerY)
@@ -173,6 +197,20 @@ func (c *Client) createWindows() error {
 			}
 		}
 		
+		// A server on this machine mustn't stream the window inside itself
+		c.excludeOwnWindow(window)
+		
+		// Local hotkeys
+		window.SetKeyCallback(c.handleKey)
+		window.SetCursorPosCallback(c.cursorMoved(i))
+		window.SetScrollCallback(c.windowScrolled(i))
+		if c.touch {
+			c.enableTouch(i, window)
+		}
+		window.SetMouseButtonCallback(c.mouseButton(i))
+		window.SetFramebufferSizeCallback(c.windowResized(i))
+		c.resized[i] = time.Now() // The server captures at the window's size
+		
 		// Store the window
 		c.windows[i] = window
 		
@@ -187,8 +225,13 @@ func (c *Client) createWindows() error {
 		time.Sleep(100 * time.Millisecond)
 	}
 	
-	// Make first window's context current for OpenGL initialization
-	if len(c.windows) > 0 && c.windows[0] != nil {
+	// Presenters draw without OpenGL
+	if c.renderer != RendererGL {
+		if err := c.createPresenters(); err != nil {
+			return err
+		}
+	} else if len(c.windows) > 0 && c.windows[0] != nil {
+		// Make first window's context current for OpenGL initialization
 		c.windows[0].MakeContextCurrent()
 		
 		// Initialize OpenGL
@@ -199,17 +242,17 @@ func (c *Client) createWindows() error {
 		
 		fmt.Printf("OpenGL initialized: %s\n", gl.GoStr(gl.GetString(gl.VERSION)))
 		
-		// Create a texture for each window
+		// Create a texture for each window, frames are uploaded to it
+		c.textures = make([]streamTexture, len(c.windows))
 		for i, window := range c.windows {
 			if window == nil {
 				continue
 			}
 			
 			window.MakeContextCurrent()
-			var texture uint32
-			gl.GenTextures(1, &texture)
-			textures[i] = texture
-			fmt.Printf("Created texture %d for window %d\n", texture, i)
+			setSwapInterval(c.swapMode(i))
+			c.textures[i].create()
+			fmt.Printf("Created texture %d for window %d\n", c.textures[i].id, i)
 		}
 	} else {
 		return fmt.Errorf("no valid windows created")
@@ -232,80 +275,41 @@ func (c *Client) createWindows() error {
 	return nil
 }
 
-// displayFrame displays a JPEG frame in the given window
-func (c *Client) displayFrame(windowIndex int, frameData []byte, frameNumber int) error {
-	// Ensure we have the correct window context
+// displayImage displays a decoded frame in the given window
+func (c *Client) displayImage(windowIndex int, img image.Image, frameNumber int) error {
 	window := c.windows[windowIndex]
 	if window == nil || window.ShouldClose() {
 		return fmt.Errorf("window %d is nil or should close", windowIndex)
 	}
-	
-	// Make window current
 	window.MakeContextCurrent()
 	
-	// Try to decode the JPEG frame
-	img, err := jpeg.Decode(bytes.NewReader(frameData))
-	if err != nil {
-		fmt.Printf("Error decoding JPEG for window %d: %v\n", windowIndex, err)
-		
-		// Save the raw JPEG data for analysis
-		rawFrameFile := filepath.Join("debug_frames", fmt.Sprintf("raw_frame_win%d.jpg", windowIndex))
-		if err := os.WriteFile(rawFrameFile, frameData, 0644); err != nil {
-			fmt.Printf("Error saving raw frame data: %v\n", err)
-		} else {
-			fmt.Printf("Saved raw JPEG data to %s\n", rawFrameFile)
-		}
-		
-		return err
-	}
-	
-	// Get local monitor ID and find the corresponding server monitor ID
-	localMonID := uint32(windowIndex + 1)
+	// The window changes size when full screen is toggled
+	width, height := window.GetFramebufferSize()
+	gl.Viewport(0, 0, int32(width), int32(height))
 	
-	// Save decoded image for debugging
-	saveImageToFile(img, localMonID, frameNumber, "jpg")
-	
-	// Convert to RGBA
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	draw.Draw(rgba, bounds, img, bounds.Min, draw.Over)
-	
-	// Create or get texture
-	var texture uint32
-	gl.GenTextures(1, &texture)
+	// Frames were converted to RGBA in sRGB when decoded, see displayFrame
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = displayFrame(img, nil)
+	}
+	bounds := rgba.Bounds()
 	
-	// Bind the texture
-	gl.BindTexture(gl.TEXTURE_2D, texture)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	layout := c.layoutWindow(windowIndex, bounds.Size())
 	
-	// Force 1-byte alignment for any image
-	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
-	
-	// Upload texture
-	gl.TexImage2D(
-		gl.TEXTURE_2D,
-		0,
-		gl.RGBA,
-		int32(bounds.Dx()),
-		int32(bounds.Dy()),
-		0,
-		gl.RGBA,
-		gl.UNSIGNED_BYTE,
-		gl.Ptr(rgba.Pix),
-	)
+	// Upload to the window's texture, allocated once at the frame size
+	texture := &c.textures[windowIndex]
+	texture.upload(rgba)
 	
 	// Clear the background
 	gl.ClearColor(0.2, 0.2, 0.2, 1.0)
 	gl.Clear(gl.COLOR_BUFFER_BIT)
 	
-	// Render the texture
-	renderSimpleFullscreenTexture(texture)
-	
-	// Cleanup
-	gl.DeleteTextures(1, &texture)
+	// Render the texture, with the window's color adjustment
+	adjusted := c.adjustPrograms[windowIndex].use(c.adjustments[windowIndex])
+	renderSimpleFullscreenTexture(texture.id, layout)
+	if adjusted {
+		gl.UseProgram(0)
+	}
 	
 	return nil
 }
@@ -324,16 +328,16 @@ func (c *Client) updateDisplayLoop() {
 
 	// Create windows for each monitor
 	fmt.Fprintln(os.Stdout, "About to create windows...")
+	c.checkRenderer()
 	if err := c.createWindows(); err != nil {
 		fmt.Fprintf(os.Stdout, "ERROR: %v\n", err)
 		return
 	}
-	
-	// Create debug directory
-	createDebugDir("debug_frames")
+	defer c.closePresenters()
 	
 	// Variables for monitoring
 	frameCount := 0
+	titleStatus := ""
 	lastFPSTime := time.Now()
 	framesRendered := 0
 	
@@ -360,87 +364,187 @@ func (c *Client) updateDisplayLoop() {
 			break
 		}
 		
-		// Render each window
+		// Reflect the server's lock state and the clipboard picker in the window titles
+		status := ""
+		if c.reconnecting.Load() {
+			status += " (reconnecting)"
+		} else if c.serverLocked.Load() {
+			status += " (server locked)"
+		}
+		if picker := c.clipboardPickerStatus(); picker != "" {
+			status += " - " + picker
+		}
+		if c.frameMarksEnabled.Load() {
+			status += " [frame marks]"
+		}
+		if status != titleStatus {
+			titleStatus = status
+			for i, window := range c.windows {
+				if window == nil {
+					continue
+				}
+				window.SetTitle(fmt.Sprintf("UltraRDP - Monitor %d%s", i, status))
+			}
+		}
+		
+		// Render each window, counting the ones whose swaps wait for the display
+		swapsWaited := 0
 		for windowIndex, window := range c.windows {
 			if window == nil {
 				continue
 			}
 			
-			// Skip if window should close
+			// Closing a window detaches its monitor, the others go on
 			if window.ShouldClose() {
+				c.closeWindow(windowIndex)
 				continue
 			}
 			
 			// Get the server monitor ID for this window
-			localMonID := uint32(windowIndex + 1)
-			serverMonID := uint32(0)
-			
-			// Find the server monitor ID mapped to this local monitor
-			for srvID, locID := range c.monitorMap {
-				if locID == localMonID {
-					serverMonID = srvID
-					break
-				}
-			}
+			serverMonID := c.serverMonitorOf(windowIndex)
 			
-			if serverMonID == 0 {
+			if serverMonID == 0 && !c.gridShown() {
 				// Only log this occasionally to avoid spam
 				if frameCount % 30 == 0 {
 					fmt.Printf("Window %d not mapped to any server monitor\n", windowIndex)
 				}
 				continue
 			}
-			
-			// Check if we have frame data for this monitor
-			c.frameMutex.Lock()
-			frameData, exists := c.frameBuffers[localMonID]
-			
-			if !exists || len(frameData) == 0 {
-				// Only log this occasionally
-				if frameCount % 30 == 0 {
-					fmt.Printf("Window %d mapped to server monitor %d, frame exists: %v\n", 
-						windowIndex, serverMonID, exists && len(frameData) > 0)
-					fmt.Printf("No frame data for window %d (server monitor %d)\n", 
-						windowIndex, serverMonID)
-				}
-				c.frameMutex.Unlock()
-				
-				// Make the window current and draw a blue background
-				window.MakeContextCurrent()
-				gl.ClearColor(0.0, 0.0, 0.2, 1.0) // Dark blue 
-				gl.Clear(gl.COLOR_BUFFER_BIT)
-				window.SwapBuffers()
-				
-				continue
+			if c.swapWaits(windowIndex, window) {
+				swapsWaited++
 			}
 			
-			// Make a copy of the frame data
-			frameDataCopy := make([]byte, len(frameData))
-			copy(frameDataCopy, frameData)
-			c.frameMutex.Unlock()
-			
-			// Display the frame
-			err := c.displayFrame(windowIndex, frameDataCopy, frameCount)
-			if err != nil {
-				fmt.Printf("Error rendering frame: %v\n", err)
+			if c.drawWindow(windowIndex, window, serverMonID, frameCount) {
+				framesRendered++
 			}
-			
-			// Swap buffers
-			window.SwapBuffers()
-			framesRendered++
 		}
 		
+		// The server's webcam, if asked for
+		c.drawCameraWindow()
+		
+		// Resized windows may ask for another capture scale
+		c.scaleResizedWindows()
+		
 		// Calculate and display FPS occasionally
 		if time.Since(lastFPSTime) >= time.Second {
 			fps := float64(framesRendered) / time.Since(lastFPSTime).Seconds()
 			fmt.Printf("FPS: %.2f\n", fps)
 			framesRendered = 0
 			lastFPSTime = time.Now()
+			c.updateStatsLines()
 		}
 		
-		// Small sleep to prevent high CPU usage
-		time.Sleep(33 * time.Millisecond) // ~30fps
+		// Swaps waiting for the display pace the loop, without any it
+		// sleeps for a refresh to prevent high CPU usage
+		if swapsWaited == 0 {
+			time.Sleep(refreshPeriod())
+		}
 	}
 	
 	fmt.Fprintln(os.Stdout, "Display loop terminated")
-}
\ No newline at end of file
+}
+
+// serverMonitorOf returns the server monitor shown in a window, 0 for none
+func (c *Client) serverMonitorOf(windowIndex int) uint32 {
+	localMonID := uint32(windowIndex + 1)
+	if c.grid {
+		localMonID = c.gridView.zoomed // None while the grid is shown
+	}
+	for srvID, locID := range c.monitorMap {
+		if locID == localMonID {
+			return srvID
+		}
+	}
+	return 0
+}
+
+// closeWindow destroys a monitor window that was closed while others are
+// open and asks the server to stop sending its monitor
+func (c *Client) closeWindow(windowIndex int) {
+	if c.presenters != nil && c.presenters[windowIndex] != nil {
+		c.presenters[windowIndex].close()
+		c.presenters[windowIndex] = nil
+	}
+	c.windows[windowIndex].Destroy()
+	c.windows[windowIndex] = nil
+	if c.textures != nil {
+		c.textures[windowIndex] = streamTexture{} // Went with the window's context
+	}
+	c.adjustPrograms[windowIndex] = colorAdjustProgram{}
+	c.interpolators[windowIndex] = frameInterpolator{}
+	
+	serverMonID := c.serverMonitorOf(windowIndex)
+	fmt.Printf("Window %d closed, detaching server monitor %d\n", windowIndex, serverMonID)
+	if serverMonID != 0 {
+		c.detachMonitor(serverMonID)
+	}
+}
+
+// drawWindow draws the latest frame of a server monitor in its window, or
+// the background until there is one. It returns whether a frame was drawn.
+func (c *Client) drawWindow(windowIndex int, window *glfw.Window, serverMonID uint32, frameCount int) bool {
+	localMonID := uint32(windowIndex + 1)
+	if c.grid {
+		localMonID = c.gridView.zoomed
+	}
+	
+	// Check if we have a frame for this monitor, decoders replace it
+	// without waiting for the loop
+	frameImage := c.windowFrame(windowIndex)
+	
+	if frameImage == nil {
+		// Only log this occasionally
+		if frameCount % 30 == 0 {
+			fmt.Printf("No frame data for window %d (server monitor %d)\n", 
+				windowIndex, serverMonID)
+		}
+	
+		if c.presenters != nil {
+			c.presenters[windowIndex].clear()
+			return false
+		}
+	
+		// Make the window current and draw a blue background
+		window.MakeContextCurrent()
+		gl.ClearColor(0.0, 0.0, 0.2, 1.0) // Dark blue 
+		gl.Clear(gl.COLOR_BUFFER_BIT)
+		c.swapBuffers(windowIndex, window)
+	
+		return false
+	}
+	
+	// The grid is drawn again into the same images, only monitors' frames
+	// may be kept
+	if c.frameDump.Enabled() && localMonID != 0 && frameCount % 30 == 0 {
+		c.dumpFrame(localMonID, frameCount, frameImage)
+	}
+	
+	if c.interpolation && localMonID != 0 {
+		frameImage = c.interpolators[windowIndex].frame(frameImage, time.Now(), refreshPeriod())
+	}
+	
+	// The control bar and stats are drawn into the frame, the same with
+	// every renderer
+	frameImage = c.withOverlay(windowIndex, serverMonID, frameImage)
+	
+	if c.presenters != nil {
+		c.presentFrame(windowIndex, serverMonID, frameImage)
+		return true
+	}
+	if err := c.displayImage(windowIndex, frameImage, frameCount); err != nil {
+		fmt.Printf("Error rendering frame: %v\n", err)
+	} else {
+		layout := c.windowLayout(windowIndex)
+		size := frameImage.Bounds().Size()
+		if cursor, ok := c.cursorOn(serverMonID, size); ok {
+			drawCursor(cursor, size, layout)
+		}
+		if c.frameMarksEnabled.Load() {
+			drawFrameMark(c.frameMark(serverMonID), layout)
+		}
+	}
+	
+	// Swap buffers
+	c.swapBuffers(windowIndex, window)
+	return true
+}
@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package client
+
+import "log"
+
+// displayState is empty without cgo, there are no windows to manage
+type displayState struct{}
+
+// updateDisplayLoop runs the client without a display. Builds without cgo
+// have no GLFW/OpenGL, so frames are received, cached and recorded but not
+// shown. It returns when the client stops.
+func (c *Client) updateDisplayLoop() {
+	log.Println("Built without cgo, running headless: frames are received but not displayed")
+	c.runHeadless()
+}
@@ -0,0 +1,54 @@
+//go:build cgo
+
+package client
+
+import (
+	"log"
+	"net"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// excludeFromCapture keeps a window out of screen capture. Set by platforms
+// supporting it.
+var excludeFromCapture func(window *glfw.Window) error
+
+// excludeOwnWindow keeps a window out of the capture of a server on this
+// machine, which would otherwise stream it inside itself. Windows of remote
+// servers stay capturable, e.g. by screen sharing apps.
+func (c *Client) excludeOwnWindow(window *glfw.Window) {
+	if excludeFromCapture == nil || !c.serverIsLocal() {
+		return
+	}
+	if err := excludeFromCapture(window); err != nil {
+		log.Printf("Failed to exclude window from screen capture: %v", err)
+	}
+}
+
+// serverIsLocal reports whether the server runs on this machine, dialed
+// directly at a loopback or local interface address
+func (c *Client) serverIsLocal() bool {
+	if c.relayAddress != "" || c.sshTarget != "" || c.proxied {
+		return false
+	}
+	host, _, err := net.SplitHostPort(c.address)
+	if err != nil {
+		host = c.address
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			return true
+		}
+		for _, addr := range addrs {
+			if network, ok := addr.(*net.IPNet); ok && network.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+//go:build darwin && cgo
+
+package client
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework AppKit
+#import <AppKit/AppKit.h>
+
+static void exclude_window(void *window) {
+	((__bridge NSWindow *)window).sharingType = NSWindowSharingNone;
+}
+*/
+import "C"
+
+import "github.com/go-gl/glfw/v3.3/glfw"
+
+func init() {
+	excludeFromCapture = excludeCocoaWindow
+}
+
+// excludeCocoaWindow keeps a window out of CGDisplayStream and screenshots.
+// ScreenCaptureKit ignores it on newer macOS, the server leaves out the
+// windows of its own executable there.
+func excludeCocoaWindow(window *glfw.Window) error {
+	C.exclude_window(window.GetCocoaWindow())
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build windows && cgo
+
+package client
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+var procSetWindowDisplayAffinity = syscall.NewLazyDLL("user32.dll").NewProc("SetWindowDisplayAffinity")
+
+const (
+	wdaMonitor            = 0x01
+	wdaExcludeFromCapture = 0x11
+)
+
+func init() {
+	excludeFromCapture = excludeWin32Window
+}
+
+// excludeWin32Window leaves a window out of every capture API. Before
+// Windows 10 2004 it can only be captured black, which still breaks the
+// loop.
+func excludeWin32Window(window *glfw.Window) error {
+	hwnd := uintptr(unsafe.Pointer(window.GetWin32Window()))
+	if r, _, _ := procSetWindowDisplayAffinity.Call(hwnd, wdaExcludeFromCapture); r != 0 {
+		return nil
+	}
+	if r, _, err := procSetWindowDisplayAffinity.Call(hwnd, wdaMonitor); r == 0 {
+		return fmt.Errorf("SetWindowDisplayAffinity failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moderniselife/ultrardp/codec"
+)
+
+// frameCacheInterval limits how often a monitor's cached frame is rewritten
+const frameCacheInterval = 2 * time.Second
+
+// WithFrameCache enables the on-disk cache of the last decoded frame per
+// monitor. When reconnecting to the same server the cached frames are shown
+// immediately instead of a blank window until live frames arrive.
+func WithFrameCache(dir string) Option {
+	return func(c *Client) {
+		c.frameCacheDir = dir
+	}
+}
+
+// frameCachePath returns the cache file for a server monitor
+func (c *Client) frameCachePath(serverMonitorID uint32) string {
+	// Keep one directory per server so different sessions don't mix
+	server := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(c.address)
+	return filepath.Join(c.frameCacheDir, server, fmt.Sprintf("mon%d.jpg", serverMonitorID))
+}
+
+// loadCachedFrames shows the cached frames of every mapped monitor until
+// live ones arrive. It must be called after the monitor mapping is created.
+func (c *Client) loadCachedFrames() {
+	if c.frameCacheDir == "" {
+		return
+	}
+
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+
+	for serverMonitorID, localMonitorID := range c.monitorMap {
+		frameData, err := os.ReadFile(c.frameCachePath(serverMonitorID))
+		if err != nil {
+			continue
+		}
+
+		img, err := codec.DecodeJPEG(frameData)
+		if err != nil {
+			continue
+		}
+
+		c.frames.store(localMonitorID, displayFrame(img, c.colorTransforms[localMonitorID]))
+		log.Printf("Loaded cached frame for monitor %d (%d bytes)", localMonitorID, len(frameData))
+	}
+}
+
+// cacheFrame writes the latest frame of a server monitor to disk, at most
+// once per frameCacheInterval. Must be called with frameMutex held.
+func (c *Client) cacheFrame(serverMonitorID uint32, frameData []byte) {
+	if c.frameCacheDir == "" {
+		return
+	}
+
+	if time.Since(c.frameCacheTimes[serverMonitorID]) < frameCacheInterval {
+		return
+	}
+	c.frameCacheTimes[serverMonitorID] = time.Now()
+
+	path := c.frameCachePath(serverMonitorID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("Failed to create frame cache directory: %v", err)
+		return
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn frame
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, frameData, 0644); err != nil {
+		log.Printf("Failed to write cached frame: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		log.Printf("Failed to store cached frame: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"bytes"
+	"log"
+	"time"
+)
+
+// Frame marks shown by the frame-drop visualization
+const (
+	frameMarkNone      = iota
+	frameMarkLate      // Arrived later than usual relative to its capture time
+	frameMarkDuplicate // Identical to the previous frame of the monitor
+	frameMarkAfterDrop // First frame after frames were lost in transit
+)
+
+// frameMarkBorder is the width of a frame mark's border in pixels, for
+// presenters, like the line drawn with OpenGL
+const frameMarkBorder = 8
+
+// frameMarkColor returns the RGBA color of a frame mark's border: yellow
+// for late frames, blue for duplicates and red for frames following a
+// drop; false for frames without a mark
+func frameMarkColor(mark int) ([4]float32, bool) {
+	switch mark {
+	case frameMarkLate:
+		return [4]float32{1.0, 0.8, 0.0, 1.0}, true
+	case frameMarkDuplicate:
+		return [4]float32{0.2, 0.4, 1.0, 1.0}, true
+	case frameMarkAfterDrop:
+		return [4]float32{1.0, 0.1, 0.1, 1.0}, true
+	}
+	return [4]float32{}, false
+}
+
+// lateFrameThreshold is how much longer than the fastest observed delivery
+// a frame may take before it is marked late
+const lateFrameThreshold = 100 * time.Millisecond
+
+// frameMarkState tracks the frames of one server monitor for the
+// visualization
+type frameMarkState struct {
+	mark     int
+	previous []byte
+	lost     bool // Frames were dropped since the previous frame
+}
+
+// WithFrameMarks starts the client with the frame-drop visualization
+// enabled. It can be toggled at runtime with Ctrl+Alt+D.
+func WithFrameMarks() Option {
+	return func(c *Client) {
+		c.frameMarksEnabled.Store(true)
+	}
+}
+
+// toggleFrameMarks switches the frame-drop visualization on or off
+func (c *Client) toggleFrameMarks() {
+	enabled := !c.frameMarksEnabled.Load()
+	c.frameMarksEnabled.Store(enabled)
+	if enabled {
+		log.Println("Frame-drop visualization enabled")
+	} else {
+		log.Println("Frame-drop visualization disabled")
+	}
+}
+
+// markFrame classifies a received frame. The capture timestamp is compared
+// against the fastest delivery seen so far, which absorbs the clock offset
+// between client and server.
+func (c *Client) markFrame(serverMonitorID uint32, timestamp int64, frameData []byte) {
+	if !c.frameMarksEnabled.Load() {
+		return
+	}
+	delay := time.Since(time.Unix(0, timestamp))
+
+	c.frameMarksMutex.Lock()
+	defer c.frameMarksMutex.Unlock()
+
+	if c.frameMarks == nil {
+		c.frameMarks = make(map[uint32]*frameMarkState)
+	}
+	if !c.minFrameDelaySet || delay < c.minFrameDelay {
+		c.minFrameDelay = delay
+		c.minFrameDelaySet = true
+	}
+
+	state, ok := c.frameMarks[serverMonitorID]
+	if !ok {
+		state = &frameMarkState{}
+		c.frameMarks[serverMonitorID] = state
+	}
+
+	switch {
+	case state.lost:
+		state.mark = frameMarkAfterDrop
+	case bytes.Equal(frameData, state.previous):
+		state.mark = frameMarkDuplicate
+	case delay-c.minFrameDelay > lateFrameThreshold:
+		state.mark = frameMarkLate
+	default:
+		state.mark = frameMarkNone
+	}
+	state.previous = frameData
+	state.lost = false
+}
+
+// frameDropped counts a frame of a server monitor that was lost in transit
+// or couldn't be decoded, marking the monitor's next frame
+func (c *Client) frameDropped(serverMonitorID uint32) {
+	c.stats.FramesDropped(1)
+	c.droppedFrames.Add(1)
+	if !c.frameMarksEnabled.Load() {
+		return
+	}
+
+	c.frameMarksMutex.Lock()
+	defer c.frameMarksMutex.Unlock()
+	if state, ok := c.frameMarks[serverMonitorID]; ok {
+		state.lost = true
+	}
+}
+
+// frameMark returns the mark of the latest frame of a server monitor
+func (c *Client) frameMark(serverMonitorID uint32) int {
+	c.frameMarksMutex.Lock()
+	defer c.frameMarksMutex.Unlock()
+
+	if state, ok := c.frameMarks[serverMonitorID]; ok {
+		return state.mark
+	}
+	return frameMarkNone
+}
@@ -0,0 +1,29 @@
+//go:build cgo
+
+package client
+
+import (
+	"github.com/go-gl/gl/v2.1/gl"
+)
+
+// drawFrameMark draws the colored border of a frame mark around the
+// rendered frame, see frameMarkColor. It expects the projection set up by
+// renderSimpleFullscreenTexture.
+func drawFrameMark(mark int, layout frameLayout) {
+	color, ok := frameMarkColor(mark)
+	if !ok {
+		return
+	}
+	gl.Color4f(color[0], color[1], color[2], color[3])
+
+	gl.LineWidth(8.0)
+	dst := layout.dst
+	gl.Begin(gl.LINE_LOOP)
+	gl.Vertex2f(dst[0], dst[1])
+	gl.Vertex2f(dst[2], dst[1])
+	gl.Vertex2f(dst[2], dst[3])
+	gl.Vertex2f(dst[0], dst[3])
+	gl.End()
+
+	gl.Color4f(1.0, 1.0, 1.0, 1.0)
+}
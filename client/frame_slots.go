@@ -0,0 +1,37 @@
+package client
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+)
+
+// frameSlots holds the latest decoded frame of each local monitor, as RGBA
+// in sRGB. Decoders replace a monitor's frame and the display loop takes
+// it without a lock, so neither waits for the other. Frames are never
+// modified once stored.
+type frameSlots struct {
+	slots sync.Map // *atomic.Pointer[image.RGBA] by local monitor ID
+}
+
+// slot returns the frame pointer of a local monitor, adding it on first use
+func (s *frameSlots) slot(localMonitorID uint32) *atomic.Pointer[image.RGBA] {
+	if slot, ok := s.slots.Load(localMonitorID); ok {
+		return slot.(*atomic.Pointer[image.RGBA])
+	}
+	slot, _ := s.slots.LoadOrStore(localMonitorID, new(atomic.Pointer[image.RGBA]))
+	return slot.(*atomic.Pointer[image.RGBA])
+}
+
+// store replaces the frame of a local monitor
+func (s *frameSlots) store(localMonitorID uint32, frame *image.RGBA) {
+	s.slot(localMonitorID).Store(frame)
+}
+
+// load returns the latest frame of a local monitor, nil before the first
+func (s *frameSlots) load(localMonitorID uint32) *image.RGBA {
+	if slot, ok := s.slots.Load(localMonitorID); ok {
+		return slot.(*atomic.Pointer[image.RGBA]).Load()
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package client
+
+import "github.com/moderniselife/ultrardp/protocol"
+
+// WithFrameRate asks the server for fps frames per second on every
+// monitor, e.g. 60 on a fast link or 10 on a slow one. Frames are captured
+// once for all clients, the highest rate asked for wins.
+func WithFrameRate(fps int) Option {
+	return func(c *Client) {
+		c.frameRate = fps
+	}
+}
+
+// SendFrameRate asks the server for fps frames per second on a server
+// monitor, all monitors if monitorID is 0. An fps of 0 returns to the
+// server's default.
+func (c *Client) SendFrameRate(monitorID uint32, fps int) error {
+	payload := protocol.EncodeFrameRate(monitorID, max(fps, 0))
+	return c.send(protocol.NewPacket(protocol.PacketTypeFrameRate, payload))
+}
@@ -0,0 +1,10 @@
+package client
+
+// WithFullscreen starts the client with each monitor window covering its
+// local monitor, borderless and above other windows. It can be toggled at
+// runtime with Ctrl+Alt+F.
+func WithFullscreen() Option {
+	return func(c *Client) {
+		c.fullscreen = true
+	}
+}
@@ -0,0 +1,58 @@
+//go:build cgo
+
+package client
+
+import (
+	"log"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// windowedSize is the size of a monitor window outside full screen, three
+// quarters of its monitor's
+func windowedSize(mode *glfw.VidMode) (width, height int) {
+	return mode.Width * 3 / 4, mode.Height * 3 / 4
+}
+
+// setFullscreenHints makes the next window created on a monitor take its
+// current video mode, so it covers the monitor without a mode switch
+func setFullscreenHints(mode *glfw.VidMode) {
+	glfw.WindowHint(glfw.RedBits, mode.RedBits)
+	glfw.WindowHint(glfw.GreenBits, mode.GreenBits)
+	glfw.WindowHint(glfw.BlueBits, mode.BlueBits)
+	glfw.WindowHint(glfw.RefreshRate, mode.RefreshRate)
+}
+
+// setFullscreen puts a window over the whole of monitor in its current
+// video mode, undecorated and on top, or back to a centered window
+func setFullscreen(window *glfw.Window, monitor *glfw.Monitor, fullscreen bool) {
+	mode := monitor.GetVideoMode()
+	if fullscreen {
+		window.SetMonitor(monitor, 0, 0, mode.Width, mode.Height, mode.RefreshRate)
+		return
+	}
+	x, y := monitor.GetPos()
+	width, height := windowedSize(mode)
+	window.SetMonitor(nil, x+(mode.Width-width)/2, y+(mode.Height-height)/2, width, height, glfw.DontCare)
+}
+
+// toggleFullscreen switches the monitor windows between full screen and
+// windowed. Windows of shared applications keep their own size.
+func (c *Client) toggleFullscreen() {
+	c.fullscreen = !c.fullscreen
+	monitors := glfw.GetMonitors()
+	for i, window := range c.windows {
+		if window == nil || i >= len(monitors) {
+			continue
+		}
+		if _, ok := c.sharedWindow(uint32(i + 1)); ok {
+			continue
+		}
+		setFullscreen(window, monitors[i], c.fullscreen)
+	}
+	if c.fullscreen {
+		log.Println("Full screen enabled")
+	} else {
+		log.Println("Full screen disabled")
+	}
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"image"
+	"math"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// gridGap is the space in window pixels between the tiles of the grid
+const gridGap = 4
+
+// gridBackground is the color around the tiles, the windows' background
+var gridBackground = [4]uint8{0, 0, 51, 255}
+
+// WithGrid shows every server monitor tiled in a single window on the
+// primary local monitor, for clients with fewer monitors than the server.
+// Clicking a monitor shows it alone in the window, Ctrl+Alt+G goes back to
+// the grid.
+func WithGrid() Option {
+	return func(c *Client) {
+		c.grid = true
+	}
+}
+
+// gridMonitors returns the monitors a grid client reports to the server:
+// one per server monitor at its size, so the server maps and sends all of
+// them. Monitor n is shown in tile n-1.
+func gridMonitors(server *protocol.MonitorConfig) *protocol.MonitorConfig {
+	config := &protocol.MonitorConfig{MonitorCount: server.MonitorCount}
+	for i, monitor := range server.Monitors {
+		config.Monitors = append(config.Monitors, protocol.MonitorInfo{
+			ID:      uint32(i + 1),
+			Width:   monitor.Width,
+			Height:  monitor.Height,
+			Primary: i == 0,
+		})
+	}
+	return config
+}
+
+// gridShape returns the columns and rows of a grid of count tiles, as
+// square as possible and wider than tall
+func gridShape(count int) (columns, rows int) {
+	columns = int(math.Ceil(math.Sqrt(float64(count))))
+	if columns == 0 {
+		return 0, 0
+	}
+	return columns, (count + columns - 1) / columns
+}
+
+// gridTile returns the part of an image of size the tile index of a grid
+// of count tiles covers, inside the gaps around it
+func gridTile(index, count int, size image.Point) image.Rectangle {
+	columns, rows := gridShape(count)
+	column, row := index%columns, index/columns
+	tile := image.Rect(column*size.X/columns, row*size.Y/rows, (column+1)*size.X/columns, (row+1)*size.Y/rows)
+	return tile.Inset(gridGap / 2)
+}
+
+// fitPicture returns the largest part of tile a frame of size fits in
+// without distortion, centered
+func fitPicture(tile image.Rectangle, frame image.Point) image.Rectangle {
+	if frame.X <= 0 || frame.Y <= 0 || tile.Empty() {
+		return image.Rectangle{}
+	}
+	width, height := tile.Dx(), tile.Dx()*frame.Y/frame.X
+	if height > tile.Dy() {
+		width, height = tile.Dy()*frame.X/frame.Y, tile.Dy()
+	}
+	origin := tile.Min.Add(image.Pt((tile.Dx()-width)/2, (tile.Dy()-height)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(width, height))}
+}
+
+// gridView is the grid of a grid client's window. Tiles are drawn on the
+// CPU, so the grid is shown the same with every renderer.
+type gridView struct {
+	zoomed  uint32 // Local monitor shown alone, 0 for the grid
+	clicked bool   // The left button is down since it zoomed in on a monitor
+
+	// The grid is drawn into two images in turn, each update being a new
+	// image to the code caching what it drew
+	images   [2]*image.RGBA
+	current  int
+	tiles    []*image.RGBA     // Frames drawn in the current image, by tile
+	pictures []image.Rectangle // Where they were drawn
+}
+
+// compose returns the grid of frames by tile drawn into an image of size,
+// redrawing only the tiles whose frames changed. Tiles without a frame
+// show the background.
+func (g *gridView) compose(frames []*image.RGBA, size image.Point) *image.RGBA {
+	current := g.images[g.current]
+	if current == nil || current.Rect.Size() != size || len(g.tiles) != len(frames) {
+		current = image.NewRGBA(image.Rectangle{Max: size})
+		fillRect(current, current.Rect, gridBackground)
+		g.images = [2]*image.RGBA{current}
+		g.current = 0
+		g.tiles = make([]*image.RGBA, len(frames))
+		g.pictures = make([]image.Rectangle, len(frames))
+	}
+
+	var next *image.RGBA
+	for i, frame := range frames {
+		if frame == nil || frame == g.tiles[i] {
+			continue
+		}
+		if next == nil {
+			next = g.images[1-g.current]
+			if next == nil {
+				next = image.NewRGBA(current.Rect)
+				g.images[1-g.current] = next
+			}
+			copy(next.Pix, current.Pix)
+		}
+		tile := gridTile(i, len(frames), size)
+		fillRect(next, tile, gridBackground)
+		g.pictures[i] = fitPicture(tile, frame.Rect.Size())
+		scaleInto(next, g.pictures[i], frame)
+		g.tiles[i] = frame
+	}
+	if next == nil {
+		return current
+	}
+	g.current = 1 - g.current
+	return next
+}
+
+// monitorAt returns the local monitor whose picture is at x, y in the
+// grid's pixels and where it is in the picture from 0 to 1, or false
+// between pictures
+func (g *gridView) monitorAt(x, y float64) (localMonitorID uint32, fx, fy float64, ok bool) {
+	for i, picture := range g.pictures {
+		if picture.Empty() || x < float64(picture.Min.X) || y < float64(picture.Min.Y) ||
+			x >= float64(picture.Max.X) || y >= float64(picture.Max.Y) {
+			continue
+		}
+		fx = (x - float64(picture.Min.X)) / float64(picture.Dx())
+		fy = (y - float64(picture.Min.Y)) / float64(picture.Dy())
+		return uint32(i + 1), fx, fy, true
+	}
+	return 0, 0, 0, false
+}
+
+// size returns the size of the grid as last drawn
+func (g *gridView) size() image.Point {
+	if current := g.images[g.current]; current != nil {
+		return current.Rect.Size()
+	}
+	return image.Point{}
+}
+
+// fillRect fills part of an image with a color
+func fillRect(img *image.RGBA, rect image.Rectangle, color [4]uint8) {
+	rect = rect.Intersect(img.Rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(rect.Min.X, y):img.PixOffset(rect.Max.X, y)]
+		for x := 0; x < len(row); x += 4 {
+			copy(row[x:x+4], color[:])
+		}
+	}
+}
+
+// scaleInto draws a frame scaled to the part rect of dst, taking the
+// nearest pixel, which is quick enough to redraw tiles at frame rate
+func scaleInto(dst *image.RGBA, rect image.Rectangle, frame *image.RGBA) {
+	rect = rect.Intersect(dst.Rect)
+	source := frame.Rect.Size()
+	if rect.Empty() || source.X <= 0 || source.Y <= 0 {
+		return
+	}
+	columns := make([]int, rect.Dx())
+	for x := range columns {
+		columns[x] = x * source.X / rect.Dx() * 4
+	}
+	for y := 0; y < rect.Dy(); y++ {
+		row := frame.Pix[y*source.Y/rect.Dy()*frame.Stride:]
+		out := dst.Pix[dst.PixOffset(rect.Min.X, rect.Min.Y+y):]
+		for x, sx := range columns {
+			copy(out[x*4:x*4+4], row[sx:sx+4])
+		}
+	}
+}
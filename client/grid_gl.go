@@ -0,0 +1,89 @@
+//go:build cgo
+
+package client
+
+import (
+	"image"
+	"log"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// gridShown reports whether a grid client shows the grid rather than a
+// zoomed monitor
+func (c *Client) gridShown() bool {
+	return c.grid && c.gridView.zoomed == 0
+}
+
+// gridPoint maps a point of the frame in a grid client's window, from 0
+// to 1, to the local monitor there and the point in its frame. It returns
+// false between the monitors of the grid.
+func (c *Client) gridPoint(fx, fy float64) (localMonitorID uint32, x, y float64, ok bool) {
+	if !c.gridShown() {
+		return c.gridView.zoomed, fx, fy, true
+	}
+	size := c.gridView.size()
+	return c.gridView.monitorAt(fx*float64(size.X), fy*float64(size.Y))
+}
+
+// windowFrame returns the frame a window shows: the latest frame of its
+// monitor, or in a grid client's window that of the zoomed monitor or the
+// grid of all of them at the window's size. It is nil before there is one
+// to show.
+func (c *Client) windowFrame(windowIndex int) *image.RGBA {
+	if !c.grid {
+		return c.frames.load(uint32(windowIndex + 1))
+	}
+	if c.gridView.zoomed != 0 {
+		return c.frames.load(c.gridView.zoomed)
+	}
+	width, height := c.windows[windowIndex].GetFramebufferSize()
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	frames := make([]*image.RGBA, len(c.localMonitors.Monitors))
+	for i := range frames {
+		frames[i] = c.frames.load(uint32(i + 1))
+	}
+	return c.gridView.compose(frames, image.Pt(width, height))
+}
+
+// gridClicked shows the monitor clicked in the grid of a grid client's
+// window alone. It reports whether the button event was that click or its
+// release, which aren't forwarded to the server.
+func (c *Client) gridClicked(window *glfw.Window, button glfw.MouseButton, action glfw.Action) bool {
+	if button != glfw.MouseButtonLeft || !c.grid {
+		return false
+	}
+	if action == glfw.Release && c.gridView.clicked {
+		c.gridView.clicked = false
+		return true
+	}
+	if action != glfw.Press || !c.gridShown() {
+		return false
+	}
+	width, height := window.GetSize()
+	if width <= 0 || height <= 0 {
+		return false
+	}
+	x, y := window.GetCursorPos()
+	fx, fy := c.windowLayout(0).toFrame(x/float64(width), y/float64(height))
+	localMonitorID, _, _, ok := c.gridPoint(fx, fy)
+	if !ok {
+		return false
+	}
+	c.gridView.zoomed = localMonitorID
+	c.gridView.clicked = true
+	c.resized[0] = time.Now() // The capture scale may follow the window
+	log.Printf("Showing server monitor %d, Ctrl+Alt+G goes back to the grid", c.serverMonitorOf(0))
+	return true
+}
+
+// showGrid goes back to the grid from a zoomed monitor
+func (c *Client) showGrid() {
+	if c.gridView.zoomed != 0 {
+		c.gridView.zoomed = 0
+		log.Println("Showing all server monitors")
+	}
+}
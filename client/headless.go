@@ -0,0 +1,54 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+	"github.com/moderniselife/ultrardp/stats"
+)
+
+// headlessStatusInterval is how often the headless client logs frame counts
+const headlessStatusInterval = 10 * time.Second
+
+// WithHeadless runs the client without windows: frames are received,
+// recorded and counted but not shown. Builds without cgo are always
+// headless.
+func WithHeadless() Option {
+	return func(c *Client) {
+		c.headless = true
+	}
+}
+
+// WithLocalMonitors uses the given monitor layout instead of detecting the
+// local monitors, e.g. for headless clients
+func WithLocalMonitors(monitors *protocol.MonitorConfig) Option {
+	return func(c *Client) {
+		c.localMonitors = monitors
+	}
+}
+
+// Stats returns the client's current statistics
+func (c *Client) Stats() stats.Snapshot {
+	return c.stats.Snapshot()
+}
+
+// runHeadless logs frame counts periodically until the client stops
+func (c *Client) runHeadless() {
+	ticker := time.NewTicker(headlessStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			log.Println("Display loop terminated")
+			return
+		case <-ticker.C:
+			c.frameMutex.Lock()
+			for monitorID, count := range c.frameCount {
+				log.Printf("Monitor %d: %d frames received", monitorID, count)
+			}
+			c.frameMutex.Unlock()
+		}
+	}
+}
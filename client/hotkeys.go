@@ -0,0 +1,131 @@
+//go:build cgo
+
+package client
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// hotkeyMods is the modifier combination that marks a local client hotkey
+const hotkeyMods = glfw.ModControl | glfw.ModAlt
+
+// hotkeyModifiers are the keys of hotkeyMods, released on the server while
+// a hotkey runs
+var hotkeyModifiers = []protocol.KeyCode{
+	protocol.KeyLeftControl, protocol.KeyRightControl, protocol.KeyLeftAlt, protocol.KeyRightAlt,
+}
+
+// handleKey processes keyboard events from the client windows. It runs on
+// the main thread from glfw.PollEvents. Keys that aren't local hotkeys are
+// forwarded to the server.
+func (c *Client) handleKey(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	code, ok := keyCode(key, scancode)
+	if action == glfw.Press && mods&hotkeyMods == hotkeyMods && c.handleHotkey(window, key) {
+		c.swallowHotkey(code, ok)
+		return
+	}
+
+	// A hotkey's own release and repeats never reach the server, nor do
+	// the releases of the modifiers it released there already
+	if ok && c.swallowedKeys[code] && action != glfw.Press {
+		if action == glfw.Release {
+			delete(c.swallowedKeys, code)
+		}
+		return
+	}
+
+	// The control bar takes keys while open, releases still go to the
+	// server for keys pressed before it opened
+	if c.menu.open && action != glfw.Release {
+		c.handleMenuKey(window, key)
+		return
+	}
+
+	if !ok {
+		return
+	}
+	if action == glfw.Release {
+		delete(c.heldKeys, code)
+	} else {
+		if c.heldKeys == nil {
+			c.heldKeys = make(map[protocol.KeyCode]bool)
+		}
+		c.restoreModifiers()
+		c.heldKeys[code] = true
+	}
+	c.sendKey(code, action != glfw.Release)
+}
+
+// swallowHotkey keeps a hotkey to the client: the Ctrl and Alt presses the
+// server already got are released there, and the local releases of those
+// keys and of the hotkey are dropped
+func (c *Client) swallowHotkey(code protocol.KeyCode, ok bool) {
+	if c.swallowedKeys == nil {
+		c.swallowedKeys = make(map[protocol.KeyCode]bool)
+	}
+	if ok {
+		c.swallowedKeys[code] = true
+	}
+	for _, modifier := range hotkeyModifiers {
+		if c.heldKeys[modifier] {
+			c.sendKey(modifier, false)
+			delete(c.heldKeys, modifier)
+			c.swallowedKeys[modifier] = true
+		}
+	}
+}
+
+// restoreModifiers presses the modifiers a hotkey released on the server
+// again, for keys typed while they are still held after it
+func (c *Client) restoreModifiers() {
+	for _, modifier := range hotkeyModifiers {
+		if c.swallowedKeys[modifier] {
+			delete(c.swallowedKeys, modifier)
+			c.heldKeys[modifier] = true
+			c.sendKey(modifier, true)
+		}
+	}
+}
+
+// handleHotkey runs the local hotkey for Ctrl+Alt+key pressed in window, it
+// returns false if the combination isn't a hotkey
+func (c *Client) handleHotkey(window *glfw.Window, key glfw.Key) bool {
+	switch key {
+	case glfw.KeyV:
+		// Ctrl+Alt+V: pick an older clipboard item
+		c.cycleClipboardHistory()
+	case glfw.KeyD:
+		// Ctrl+Alt+D: toggle the frame-drop visualization
+		c.toggleFrameMarks()
+	case glfw.KeyL:
+		// Ctrl+Alt+L: toggle lossless video
+		c.toggleLossless()
+	case glfw.KeyF:
+		// Ctrl+Alt+F: toggle full screen
+		c.toggleFullscreen()
+	case glfw.KeyS:
+		// Ctrl+Alt+S: next scale mode of the window
+		c.cycleScaleMode(window)
+	case glfw.KeyM:
+		// Ctrl+Alt+M: open or close the control bar
+		c.toggleMenu()
+	case glfw.KeyP:
+		// Ctrl+Alt+P: save a screenshot of the window's monitor
+		c.takeScreenshot(window)
+	case glfw.KeyEqual, glfw.KeyKPAdd, glfw.KeyMinus, glfw.KeyKPSubtract, glfw.Key0, glfw.KeyKP0,
+		glfw.Key1, glfw.KeyKP1, glfw.KeyLeft, glfw.KeyRight, glfw.KeyUp, glfw.KeyDown:
+		// Ctrl+Alt+plus, minus, 0, 1 and arrows: zoom and pan the window
+		c.zoomKey(window, key)
+	case glfw.KeyG:
+		// Ctrl+Alt+G: go back to the grid from a zoomed monitor
+		if !c.grid {
+			return false
+		}
+		c.showGrid()
+	default:
+		return false
+	}
+	return true
+}
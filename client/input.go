@@ -0,0 +1,48 @@
+package client
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// sendKey forwards a key press or release to the server
+func (c *Client) sendKey(code protocol.KeyCode, down bool) {
+	payload := protocol.EncodeKeyEvent(protocol.KeyEvent{Code: code, Down: down})
+	if err := c.sendInput(protocol.NewPacket(protocol.PacketTypeKeyboard, payload)); err != nil {
+		log.Printf("Error sending key %v: %v", code, err)
+	}
+}
+
+// serverPosition is a position in the pixels of a server monitor
+type serverPosition struct {
+	monitorID uint32
+	x, y      int
+}
+
+// sendMouseMove forwards the pointer position on a server monitor to the
+// server
+func (c *Client) sendMouseMove(serverMonitorID uint32, x, y int) {
+	payload := protocol.EncodeMouseMove(serverMonitorID, x, y)
+	if err := c.sendInput(protocol.NewPacket(protocol.PacketTypeMouseMove, payload)); err != nil {
+		log.Printf("Error sending mouse move: %v", err)
+	}
+}
+
+// sendMouseButton forwards a mouse button press or release at a position
+// on a server monitor to the server
+func (c *Client) sendMouseButton(serverMonitorID uint32, x, y int, button protocol.MouseButton, down bool) {
+	payload := protocol.EncodeMouseButton(protocol.MouseButtonEvent{MonitorID: serverMonitorID, X: x, Y: y, Button: button, Down: down})
+	if err := c.sendInput(protocol.NewPacket(protocol.PacketTypeMouseButton, payload)); err != nil {
+		log.Printf("Error sending mouse button: %v", err)
+	}
+}
+
+// sendMouseWheel forwards a scroll at a position on a server monitor to
+// the server, in 120ths of a wheel notch
+func (c *Client) sendMouseWheel(serverMonitorID uint32, x, y, dx, dy int) {
+	payload := protocol.EncodeMouseWheel(protocol.MouseWheelEvent{MonitorID: serverMonitorID, X: x, Y: y, DX: dx, DY: dy})
+	if err := c.sendInput(protocol.NewPacket(protocol.PacketTypeMouseWheel, payload)); err != nil {
+		log.Printf("Error sending mouse wheel: %v", err)
+	}
+}
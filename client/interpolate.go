@@ -0,0 +1,88 @@
+package client
+
+import (
+	"image"
+	"time"
+)
+
+const (
+	// interpolationSteps is how many frames, counting the new one, a
+	// window shows while moving from one frame to the next
+	interpolationSteps = 4
+
+	// maxInterpolationInterval is the longest time between frames that is
+	// blended over. A change after the screen stood still shows at once.
+	maxInterpolationInterval = 250 * time.Millisecond
+)
+
+// WithFrameInterpolation blends from each frame to the next over the time
+// between them when frames arrive slower than the display refreshes,
+// smoothing scrolling at low frame rates. Frames show up to one frame
+// interval later. Experimental.
+func WithFrameInterpolation() Option {
+	return func(c *Client) {
+		c.interpolation = true
+	}
+}
+
+// frameInterpolator blends between the frames of a window. Blends are
+// drawn into two buffers in turn, so each blend shown is a new image to
+// the code caching what it drew.
+type frameInterpolator struct {
+	previous, current *image.RGBA
+	arrived           time.Time     // When current was first shown
+	interval          time.Duration // Between previous and current
+
+	buffers [2]*image.RGBA
+	next    int // Buffer the next blend goes to
+	step    int // Step of the last blend, 0 for none
+}
+
+// frame returns what a window shows at now with latest the newest frame:
+// a blend moving from the frame before it to latest over the time between
+// them, or latest itself once the time has passed or when frames arrive
+// at least every refresh
+func (f *frameInterpolator) frame(latest *image.RGBA, now time.Time, refresh time.Duration) *image.RGBA {
+	if latest != f.current {
+		if f.current != nil {
+			f.interval = now.Sub(f.arrived)
+		}
+		f.previous, f.current, f.arrived = f.current, latest, now
+		f.step = 0
+	}
+	if f.previous == nil || f.previous.Rect != f.current.Rect ||
+		f.interval < refresh*3/2 || f.interval > maxInterpolationInterval {
+		return f.current
+	}
+
+	step := int(now.Sub(f.arrived)*interpolationSteps/f.interval) + 1
+	if step >= interpolationSteps {
+		return f.current
+	}
+	if step != f.step {
+		buffer := f.buffers[f.next]
+		if buffer == nil || buffer.Rect != f.current.Rect {
+			buffer = image.NewRGBA(f.current.Rect)
+			f.buffers[f.next] = buffer
+		}
+		blendFrames(buffer, f.previous, f.current, step*256/interpolationSteps)
+		f.next = (f.next + 1) % len(f.buffers)
+		f.step = step
+	}
+	return f.buffers[(f.next+len(f.buffers)-1)%len(f.buffers)]
+}
+
+// blendFrames draws from and to mixed into dst, weight/256 of to. All
+// three have the same bounds.
+func blendFrames(dst, from, to *image.RGBA, weight int) {
+	w := uint32(weight)
+	size := dst.Rect.Size()
+	for y := 0; y < size.Y; y++ {
+		d := dst.Pix[y*dst.Stride : y*dst.Stride+size.X*4]
+		a := from.Pix[y*from.Stride:]
+		b := to.Pix[y*to.Stride:]
+		for i := range d {
+			d[i] = uint8((uint32(a[i])*(256-w) + uint32(b[i])*w) >> 8)
+		}
+	}
+}
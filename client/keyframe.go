@@ -0,0 +1,41 @@
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// keyframeRequestInterval is the shortest time between two keyframe
+// requests for a monitor. The keyframe takes a round trip to arrive, the
+// frames until then are as damaged as the one that caused the request.
+const keyframeRequestInterval = 500 * time.Millisecond
+
+// requestKeyframe asks the server for a keyframe of a monitor whose
+// picture is damaged. Must be called with frameMutex held.
+func (c *Client) requestKeyframe(serverMonitorID uint32) {
+	if time.Since(c.keyframeAsked[serverMonitorID]) < keyframeRequestInterval {
+		return
+	}
+	c.keyframeAsked[serverMonitorID] = time.Now()
+
+	packet := protocol.NewPacket(protocol.PacketTypeKeyframeRequest, protocol.Uint32ToBytes(serverMonitorID))
+	if err := c.send(packet); err != nil && !c.stopped {
+		log.Printf("Error requesting keyframe for monitor %d: %v", serverMonitorID, err)
+	}
+}
+
+// recoverFromLoss requests keyframes for the monitors received in a codec
+// whose frames depend on earlier ones after frames were lost over UDP. The
+// lost frames' monitors aren't known, they never completed.
+func (c *Client) recoverFromLoss() {
+	c.frameMutex.Lock()
+	defer c.frameMutex.Unlock()
+
+	for serverMonitorID, decoder := range c.decoders {
+		if decoder.Codec().Predictive() {
+			c.requestKeyframe(serverMonitorID)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+//go:build cgo
+
+package client
+
+import (
+	"runtime"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// glfwKeys maps GLFW keys to protocol key codes
+var glfwKeys = map[glfw.Key]protocol.KeyCode{
+	glfw.KeyA: protocol.KeyA, glfw.KeyB: protocol.KeyB, glfw.KeyC: protocol.KeyC,
+	glfw.KeyD: protocol.KeyD, glfw.KeyE: protocol.KeyE, glfw.KeyF: protocol.KeyF,
+	glfw.KeyG: protocol.KeyG, glfw.KeyH: protocol.KeyH, glfw.KeyI: protocol.KeyI,
+	glfw.KeyJ: protocol.KeyJ, glfw.KeyK: protocol.KeyK, glfw.KeyL: protocol.KeyL,
+	glfw.KeyM: protocol.KeyM, glfw.KeyN: protocol.KeyN, glfw.KeyO: protocol.KeyO,
+	glfw.KeyP: protocol.KeyP, glfw.KeyQ: protocol.KeyQ, glfw.KeyR: protocol.KeyR,
+	glfw.KeyS: protocol.KeyS, glfw.KeyT: protocol.KeyT, glfw.KeyU: protocol.KeyU,
+	glfw.KeyV: protocol.KeyV, glfw.KeyW: protocol.KeyW, glfw.KeyX: protocol.KeyX,
+	glfw.KeyY: protocol.KeyY, glfw.KeyZ: protocol.KeyZ,
+
+	glfw.Key0: protocol.Key0, glfw.Key1: protocol.Key1, glfw.Key2: protocol.Key2,
+	glfw.Key3: protocol.Key3, glfw.Key4: protocol.Key4, glfw.Key5: protocol.Key5,
+	glfw.Key6: protocol.Key6, glfw.Key7: protocol.Key7, glfw.Key8: protocol.Key8,
+	glfw.Key9: protocol.Key9,
+
+	glfw.KeyEnter: protocol.KeyEnter, glfw.KeyEscape: protocol.KeyEscape,
+	glfw.KeyBackspace: protocol.KeyBackspace, glfw.KeyTab: protocol.KeyTab,
+	glfw.KeySpace: protocol.KeySpace, glfw.KeyMinus: protocol.KeyMinus,
+	glfw.KeyEqual: protocol.KeyEqual, glfw.KeyLeftBracket: protocol.KeyLeftBracket,
+	glfw.KeyRightBracket: protocol.KeyRightBracket, glfw.KeyBackslash: protocol.KeyBackslash,
+	glfw.KeySemicolon: protocol.KeySemicolon, glfw.KeyApostrophe: protocol.KeyApostrophe,
+	glfw.KeyGraveAccent: protocol.KeyGraveAccent, glfw.KeyComma: protocol.KeyComma,
+	glfw.KeyPeriod: protocol.KeyPeriod, glfw.KeySlash: protocol.KeySlash,
+	glfw.KeyCapsLock: protocol.KeyCapsLock, glfw.KeyWorld2: protocol.KeyNonUSBackslash,
+
+	glfw.KeyF1: protocol.KeyF1, glfw.KeyF2: protocol.KeyF2, glfw.KeyF3: protocol.KeyF3,
+	glfw.KeyF4: protocol.KeyF4, glfw.KeyF5: protocol.KeyF5, glfw.KeyF6: protocol.KeyF6,
+	glfw.KeyF7: protocol.KeyF7, glfw.KeyF8: protocol.KeyF8, glfw.KeyF9: protocol.KeyF9,
+	glfw.KeyF10: protocol.KeyF10, glfw.KeyF11: protocol.KeyF11, glfw.KeyF12: protocol.KeyF12,
+	glfw.KeyF13: protocol.KeyF13, glfw.KeyF14: protocol.KeyF14, glfw.KeyF15: protocol.KeyF15,
+	glfw.KeyF16: protocol.KeyF16, glfw.KeyF17: protocol.KeyF17, glfw.KeyF18: protocol.KeyF18,
+	glfw.KeyF19: protocol.KeyF19, glfw.KeyF20: protocol.KeyF20, glfw.KeyF21: protocol.KeyF21,
+	glfw.KeyF22: protocol.KeyF22, glfw.KeyF23: protocol.KeyF23, glfw.KeyF24: protocol.KeyF24,
+
+	glfw.KeyPrintScreen: protocol.KeyPrintScreen, glfw.KeyScrollLock: protocol.KeyScrollLock,
+	glfw.KeyPause: protocol.KeyPause, glfw.KeyInsert: protocol.KeyInsert,
+	glfw.KeyHome: protocol.KeyHome, glfw.KeyPageUp: protocol.KeyPageUp,
+	glfw.KeyDelete: protocol.KeyDelete, glfw.KeyEnd: protocol.KeyEnd,
+	glfw.KeyPageDown: protocol.KeyPageDown, glfw.KeyRight: protocol.KeyRight,
+	glfw.KeyLeft: protocol.KeyLeft, glfw.KeyDown: protocol.KeyDown,
+	glfw.KeyUp: protocol.KeyUp, glfw.KeyMenu: protocol.KeyMenu,
+
+	glfw.KeyNumLock: protocol.KeyNumLock, glfw.KeyKPDivide: protocol.KeyKPDivide,
+	glfw.KeyKPMultiply: protocol.KeyKPMultiply, glfw.KeyKPSubtract: protocol.KeyKPSubtract,
+	glfw.KeyKPAdd: protocol.KeyKPAdd, glfw.KeyKPEnter: protocol.KeyKPEnter,
+	glfw.KeyKPDecimal: protocol.KeyKPDecimal, glfw.KeyKP0: protocol.KeyKP0,
+	glfw.KeyKP1: protocol.KeyKP1, glfw.KeyKP2: protocol.KeyKP2, glfw.KeyKP3: protocol.KeyKP3,
+	glfw.KeyKP4: protocol.KeyKP4, glfw.KeyKP5: protocol.KeyKP5, glfw.KeyKP6: protocol.KeyKP6,
+	glfw.KeyKP7: protocol.KeyKP7, glfw.KeyKP8: protocol.KeyKP8, glfw.KeyKP9: protocol.KeyKP9,
+
+	glfw.KeyLeftControl: protocol.KeyLeftControl, glfw.KeyLeftShift: protocol.KeyLeftShift,
+	glfw.KeyLeftAlt: protocol.KeyLeftAlt, glfw.KeyLeftSuper: protocol.KeyLeftSuper,
+	glfw.KeyRightControl: protocol.KeyRightControl, glfw.KeyRightShift: protocol.KeyRightShift,
+	glfw.KeyRightAlt: protocol.KeyRightAlt, glfw.KeyRightSuper: protocol.KeyRightSuper,
+}
+
+// keyCode returns the protocol key code of a GLFW key event. GLFW has no
+// names for media keys, on Windows they are recognized by their scancode.
+func keyCode(key glfw.Key, scancode int) (protocol.KeyCode, bool) {
+	if code, ok := glfwKeys[key]; ok {
+		return code, true
+	}
+	if runtime.GOOS == "windows" {
+		return protocol.KeyFromWindowsScancode(uint16(scancode))
+	}
+	return 0, false
+}
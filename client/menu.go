@@ -0,0 +1,175 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// Items of the control bar, in the order shown
+type menuItem int
+
+const (
+	menuDisconnect menuItem = iota
+	menuQuality
+	menuBrightness
+	menuContrast
+	menuGamma
+	menuFullscreen
+	menuStats
+	menuItemCount
+)
+
+const (
+	// qualityStep is how much the control bar's quality slider moves per key
+	qualityStep = 10
+
+	// minQuality is the lowest quality the slider goes to
+	minQuality = 10
+)
+
+// controlMenu is the control bar opened with Ctrl+Alt+M, drawn at the top
+// of every window. Keys go to it instead of the server while it is open.
+type controlMenu struct {
+	open     bool
+	selected menuItem
+}
+
+// menuLabel returns the label of a control bar item in a window with the
+// color adjustment adjust
+func (c *Client) menuLabel(item menuItem, adjust ColorAdjust) string {
+	switch item {
+	case menuDisconnect:
+		return "Disconnect"
+	case menuQuality:
+		steps := c.qualityLevel / qualityStep
+		return fmt.Sprintf("Quality %s%s %d%%", strings.Repeat("=", steps), strings.Repeat("-", 100/qualityStep-steps), c.qualityLevel)
+	case menuBrightness:
+		return fmt.Sprintf("Brightness %+.2f", adjust.Brightness)
+	case menuContrast:
+		return fmt.Sprintf("Contrast %.1f", adjust.Contrast)
+	case menuGamma:
+		return fmt.Sprintf("Gamma %.1f", adjust.Gamma)
+	case menuFullscreen:
+		if c.fullscreen {
+			return "Full screen on"
+		}
+		return "Full screen off"
+	case menuStats:
+		if c.statsShown {
+			return "Stats on"
+		}
+		return "Stats off"
+	}
+	return ""
+}
+
+// overlayLines returns the lines drawn over a server monitor's window with
+// the color adjustment adjust: the control bar while it is open, and the
+// monitor's stats and the server's bulk transfers while stats are shown
+func (c *Client) overlayLines(serverMonitorID uint32, adjust ColorAdjust) []string {
+	var lines []string
+	if c.menu.open {
+		items := make([]string, menuItemCount)
+		for item := range menuItemCount {
+			items[item] = " " + c.menuLabel(item, adjust) + " "
+			if item == c.menu.selected {
+				items[item] = "[" + c.menuLabel(item, adjust) + "]"
+			}
+		}
+		lines = append(lines, strings.Join(items, "  "),
+			"Left/Right: select  Enter: apply  Up/Down: change  Esc: close")
+	}
+	if c.statsShown {
+		if line, ok := c.statsLines[serverMonitorID]; ok {
+			lines = append(lines, line)
+		}
+		if line := c.bulkLine(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// bulkLine describes the bulk transfers the server is sending, empty if
+// there are none
+func (c *Client) bulkLine() string {
+	state := c.bulkState.Load()
+	if state == nil || state.Transfers == 0 {
+		return ""
+	}
+	line := fmt.Sprintf("Transfers: %d at %d%%", state.Transfers, state.Progress)
+	if state.Throttled {
+		line += fmt.Sprintf(", throttled to %.1f of %.1f Mbit/s",
+			float64(state.LimitKbps)/1000, float64(state.CapacityKbps)/1000)
+	}
+	return line
+}
+
+// changeMenuItem moves the slider of a control bar item up or down a step,
+// the color adjustment items change adjust
+func (c *Client) changeMenuItem(item menuItem, up bool, adjust *ColorAdjust) {
+	sign := 1.0
+	if !up {
+		sign = -1
+	}
+	switch item {
+	case menuQuality:
+		c.adjustQuality(int(sign) * qualityStep)
+	case menuBrightness:
+		adjust.Brightness = min(max(math.Round((adjust.Brightness+sign*brightnessStep)*100)/100, -1), 1)
+	case menuContrast:
+		adjust.Contrast = min(max(math.Round((adjust.Contrast+sign*contrastStep)*10)/10, 0), 4)
+	case menuGamma:
+		adjust.Gamma = min(max(math.Round((adjust.Gamma+sign*gammaStep)*10)/10, 0.1), 10)
+	}
+}
+
+// adjustQuality moves the quality slider by delta and asks the server for
+// the new quality
+func (c *Client) adjustQuality(delta int) {
+	quality := min(max(c.qualityLevel+delta, minQuality), 100)
+	if quality == c.qualityLevel && c.qualitySent {
+		return
+	}
+	if err := c.SendQualityControl(quality); err != nil && !c.stopped {
+		log.Printf("Error sending quality: %v", err)
+	}
+}
+
+// toggleStats shows or hides the stats of every window's monitor. The
+// server is pinged for the round trip time if stats aren't already being
+// written, see WithStatsJSON.
+func (c *Client) toggleStats() {
+	c.statsShown = !c.statsShown
+	if !c.statsShown {
+		return
+	}
+	if !c.statsPinging && c.statsPath == "" {
+		c.statsPinging = true
+		go c.pingLoop(time.Second)
+	}
+	// Rates show from the next update on
+	c.statsPrevious = c.stats.Snapshot()
+	c.statsLines = nil
+}
+
+// updateStatsLines refreshes the stats shown in the windows from the rates
+// since the previous call, about once a second
+func (c *Client) updateStatsLines() {
+	if !c.statsShown {
+		return
+	}
+	snapshot := c.stats.Snapshot()
+	snapshot.SetRates(&c.statsPrevious)
+	c.statsPrevious = snapshot
+
+	lines := make(map[uint32]string, len(snapshot.Monitors))
+	for _, monitor := range snapshot.Monitors {
+		lines[monitor.ID] = fmt.Sprintf("Monitor %d: %.0f fps, %.1f Mbit/s, RTT %.0f ms, %d dropped",
+			monitor.ID, monitor.FPS, monitor.BitrateKbps/1000, snapshot.RTTMillis, snapshot.FramesDropped)
+	}
+	c.statsLines = lines
+}
@@ -0,0 +1,71 @@
+//go:build cgo
+
+package client
+
+import (
+	"image"
+	"log"
+	"slices"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// toggleMenu opens or closes the control bar
+func (c *Client) toggleMenu() {
+	c.menu.open = !c.menu.open
+	if c.menu.open {
+		log.Println("Control bar opened")
+	}
+}
+
+// handleMenuKey runs a key pressed in a window while the control bar is
+// open, color adjustments change in that window
+func (c *Client) handleMenuKey(window *glfw.Window, key glfw.Key) {
+	switch key {
+	case glfw.KeyEscape:
+		c.menu.open = false
+	case glfw.KeyLeft:
+		c.menu.selected = (c.menu.selected + menuItemCount - 1) % menuItemCount
+	case glfw.KeyRight, glfw.KeyTab:
+		c.menu.selected = (c.menu.selected + 1) % menuItemCount
+	case glfw.KeyUp, glfw.KeyEqual, glfw.KeyKPAdd, glfw.KeyDown, glfw.KeyMinus, glfw.KeyKPSubtract:
+		up := key == glfw.KeyUp || key == glfw.KeyEqual || key == glfw.KeyKPAdd
+		i := slices.Index(c.windows, window)
+		if i < 0 {
+			return
+		}
+		before := c.adjustments[i]
+		c.changeMenuItem(c.menu.selected, up, &c.adjustments[i])
+		if c.adjustments[i] != before {
+			log.Printf("Window %d: %s", i, c.adjustments[i])
+		}
+	case glfw.KeyEnter, glfw.KeyKPEnter, glfw.KeySpace:
+		c.applyMenuItem(c.menu.selected)
+	}
+}
+
+// applyMenuItem runs the selected control bar item
+func (c *Client) applyMenuItem(item menuItem) {
+	switch item {
+	case menuDisconnect:
+		log.Println("Disconnecting")
+		c.menu.open = false
+		c.Stop()
+	case menuFullscreen:
+		c.toggleFullscreen()
+	case menuStats:
+		c.toggleStats()
+	}
+}
+
+// withOverlay returns a window's frame with the control bar and stats
+// drawn over it when they are shown
+func (c *Client) withOverlay(windowIndex int, serverMonitorID uint32, frame *image.RGBA) *image.RGBA {
+	lines := c.overlayLines(serverMonitorID, c.adjustments[windowIndex])
+	if len(lines) == 0 {
+		return frame
+	}
+	width, _ := c.windows[windowIndex].GetFramebufferSize()
+	layout := c.layoutWindow(windowIndex, frame.Rect.Size())
+	return c.overlays[windowIndex].compose(frame, layout, width, lines)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// DetectMonitors returns the monitors attached to this machine
+func DetectMonitors() (*protocol.MonitorConfig, error) {
+	return detectMonitors()
+}
+
+// QueryServerMonitors connects to a server, performs the handshake far enough
+// to learn the server's monitor layout and disconnects again. It is used to
+// debug monitor mapping problems without starting a full session.
+func QueryServerMonitors(address string, opts ...Option) (*protocol.MonitorConfig, error) {
+	c := &Client{address: address}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	c.conn = conn
+	defer c.conn.Close()
+
+	return c.receiveServerMonitors()
+}
+
+// sharedWindow returns the server monitor shown on a local monitor if it
+// is an application window the server shares
+func (c *Client) sharedWindow(localMonitorID uint32) (protocol.MonitorInfo, bool) {
+	if c.serverMonitors == nil {
+		return protocol.MonitorInfo{}, false
+	}
+	for _, monitor := range c.serverMonitors.Monitors {
+		if monitor.Window && c.monitorMap[monitor.ID] == localMonitorID {
+			return monitor, true
+		}
+	}
+	return protocol.MonitorInfo{}, false
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"image"
+	"strings"
+)
+
+const (
+	overlayGlyphWidth  = 5 // Font pixels, see overlayFont
+	overlayGlyphHeight = 7
+	overlayPadding     = 3 // Font pixels around the text and between lines
+
+	// overlayWindowScale is how many window pixels a font pixel covers
+	// when the frame's pixels allow it
+	overlayWindowScale = 2
+)
+
+// overlayFont is a 5 by 7 pixel font for the overlay, upper case only.
+// Each row holds its pixels in the low 5 bits, the leftmost in the highest.
+var overlayFont = map[rune][overlayGlyphHeight]uint8{
+	'A': {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B': {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C': {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D': {0b11110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b11110},
+	'E': {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F': {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G': {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H': {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I': {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J': {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K': {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L': {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M': {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N': {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O': {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P': {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q': {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R': {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S': {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T': {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U': {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V': {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W': {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X': {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y': {0b10001, 0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100},
+	'Z': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'0': {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1': {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2': {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3': {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4': {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5': {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6': {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8': {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9': {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	' ': {},
+	'.': {0, 0, 0, 0, 0, 0b01100, 0b01100},
+	',': {0, 0, 0, 0, 0b01100, 0b00100, 0b01000},
+	':': {0, 0b01100, 0b01100, 0, 0b01100, 0b01100, 0},
+	'%': {0b11000, 0b11001, 0b00010, 0b00100, 0b01000, 0b10011, 0b00011},
+	'/': {0, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0},
+	'-': {0, 0, 0, 0b11111, 0, 0, 0},
+	'+': {0, 0b00100, 0b00100, 0b11111, 0b00100, 0b00100, 0},
+	'=': {0, 0, 0b11111, 0, 0b11111, 0, 0},
+	'<': {0b00010, 0b00100, 0b01000, 0b10000, 0b01000, 0b00100, 0b00010},
+	'>': {0b01000, 0b00100, 0b00010, 0b00001, 0b00010, 0b00100, 0b01000},
+	'[': {0b01110, 0b01000, 0b01000, 0b01000, 0b01000, 0b01000, 0b01110},
+	']': {0b01110, 0b00010, 0b00010, 0b00010, 0b00010, 0b00010, 0b01110},
+	'|': {0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+}
+
+// renderOverlay draws lines of text in white on a translucent dark box,
+// each font pixel scale by scale pixels. Characters missing from
+// overlayFont are left blank.
+func renderOverlay(lines []string, scale int) *image.RGBA {
+	columns := 0
+	for _, line := range lines {
+		columns = max(columns, len([]rune(line)))
+	}
+	advance := overlayGlyphWidth + 1
+	width := (2*overlayPadding + columns*advance - 1) * scale
+	height := (overlayPadding + len(lines)*(overlayGlyphHeight+overlayPadding)) * scale
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 180
+	}
+
+	for row, line := range lines {
+		top := (overlayPadding + row*(overlayGlyphHeight+overlayPadding)) * scale
+		for column, char := range []rune(strings.ToUpper(line)) {
+			left := (overlayPadding + column*advance) * scale
+			glyph := overlayFont[char]
+			for y, bits := range glyph {
+				for x := 0; x < overlayGlyphWidth; x++ {
+					if bits&(1<<(overlayGlyphWidth-1-x)) == 0 {
+						continue
+					}
+					for dy := 0; dy < scale; dy++ {
+						offset := img.PixOffset(left+x*scale, top+y*scale+dy)
+						for dx := 0; dx < scale; dx++ {
+							copy(img.Pix[offset+dx*4:], []byte{255, 255, 255, 255})
+						}
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+// overlayFrame is a frame with an overlay drawn over it, kept until the
+// frame or the overlay changes so a window drawn again without a new
+// frame doesn't copy it again
+type overlayFrame struct {
+	source *image.RGBA // Frame the overlay was drawn over
+	text   string      // Lines drawn, joined
+	layout frameLayout
+	frame  *image.RGBA // Reused while the frame size stays the same
+}
+
+// compose returns a copy of frame with lines drawn upright at the top of
+// the part of it that shows in a window of windowWidth pixels with layout,
+// scaled to be as large in any window
+func (o *overlayFrame) compose(frame *image.RGBA, layout frameLayout, windowWidth int, lines []string) *image.RGBA {
+	text := strings.Join(lines, "\n")
+	if o.source == frame && o.text == text && o.layout == layout {
+		return o.frame
+	}
+
+	size := frame.Rect.Size()
+	turned := size
+	if layout.rotation.quarter() {
+		turned = image.Pt(size.Y, size.X)
+	}
+	visible := image.Rect(int(layout.src[0]*float32(turned.X)), int(layout.src[1]*float32(turned.Y)),
+		int(layout.src[2]*float32(turned.X)), int(layout.src[3]*float32(turned.Y)))
+	if visible.Empty() || windowWidth <= 0 {
+		return frame
+	}
+
+	// Frame pixels per window pixel, the overlay is sized in window pixels
+	shown := float64(layout.dst[2]-layout.dst[0]) * float64(windowWidth)
+	scale := max(int(float64(visible.Dx())/shown*overlayWindowScale+0.5), 1)
+	overlay := renderOverlay(lines, scale)
+	for scale > 1 && overlay.Rect.Dx() > visible.Dx() {
+		scale--
+		overlay = renderOverlay(lines, scale)
+	}
+
+	if o.frame == nil || o.frame.Rect != frame.Rect {
+		o.frame = image.NewRGBA(frame.Rect)
+	}
+	out := o.frame
+	for y := 0; y < size.Y; y++ {
+		copy(out.Pix[y*out.Stride:y*out.Stride+size.X*4], frame.Pix[frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y+y):])
+	}
+
+	// Overlay pixels are placed in the turned frame, then blended into the
+	// frame pixel that turns into them
+	left := visible.Min.X + (visible.Dx()-overlay.Rect.Dx())/2
+	top := visible.Min.Y + overlayPadding*scale
+	for y := 0; y < overlay.Rect.Dy(); y++ {
+		for x := 0; x < overlay.Rect.Dx(); x++ {
+			tx, ty := left+x, top+y
+			if tx < 0 || ty < 0 || tx >= turned.X || ty >= turned.Y {
+				continue
+			}
+			fx, fy := layout.rotation.framePixel(tx, ty, size.X, size.Y)
+			s := overlay.Pix[overlay.PixOffset(x, y):]
+			d := out.Pix[fy*out.Stride+fx*4:]
+			a := uint32(s[3])
+			for c := 0; c < 3; c++ {
+				d[c] = uint8((uint32(s[c])*a + uint32(d[c])*(255-a) + 127) / 255)
+			}
+		}
+	}
+
+	o.source, o.text, o.layout = frame, text, layout
+	return out
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithParallelConnections asks the server for one extra connection per
+// mapped monitor, so a large frame of one monitor never delays the frames
+// of another. Frames keep arriving on the control connection until the
+// extra connections are up, and whenever one of them fails.
+func WithParallelConnections() Option {
+	return func(c *Client) {
+		c.parallelEnabled = true
+	}
+}
+
+// requestParallelConnections asks the server for per-monitor connections
+func (c *Client) requestParallelConnections() error {
+	return c.send(protocol.NewPacket(protocol.PacketTypeStreamRequest, nil))
+}
+
+// startParallelConnections opens the connections described by the
+// server's stream setup packet
+func (c *Client) startParallelConnections(payload []byte) error {
+	token, monitorIDs, err := protocol.DecodeStreamSetup(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, monitorID := range monitorIDs {
+		go func(monitorID uint32) {
+			if err := c.attachMonitor(token, monitorID); err != nil && !c.stopped {
+				log.Printf("Connection for monitor %d failed: %v", monitorID, err)
+			}
+		}(monitorID)
+	}
+	return nil
+}
+
+// attachMonitor opens a connection, authenticates it like the control
+// connection and receives the monitor's video on it until it closes
+func (c *Client) attachMonitor(token []byte, monitorID uint32) error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	conn, _, err = c.serverHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	if err := protocol.EncodePacket(conn, protocol.NewPacket(protocol.PacketTypeStreamAttach, protocol.EncodeStreamAttach(token, monitorID))); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.parallelMutex.Lock()
+	c.parallelConns = append(c.parallelConns, conn)
+	c.parallelMutex.Unlock()
+
+	log.Printf("Receiving monitor %d on its own connection", monitorID)
+	c.receiveStream(conn)
+	return nil
+}
+
+// closeParallelConnections closes the per-monitor connections
+func (c *Client) closeParallelConnections() {
+	c.parallelMutex.Lock()
+	defer c.parallelMutex.Unlock()
+
+	for _, conn := range c.parallelConns {
+		conn.Close()
+	}
+	c.parallelConns = nil
+}
@@ -0,0 +1,111 @@
+//go:build cgo
+
+package client
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// mouseButtons maps the GLFW mouse buttons forwarded to the server to the
+// protocol's
+var mouseButtons = map[glfw.MouseButton]protocol.MouseButton{
+	glfw.MouseButtonLeft:   protocol.MouseLeft,
+	glfw.MouseButtonRight:  protocol.MouseRight,
+	glfw.MouseButtonMiddle: protocol.MouseMiddle,
+	glfw.MouseButton4:      protocol.MouseBack,
+	glfw.MouseButton5:      protocol.MouseForward,
+}
+
+// cursorMoved returns the callback of a window's cursor moves, which
+// forwards them to the server in the pixels of the server monitor the
+// window shows, or the one under the cursor in a grid. It runs on the main
+// thread from glfw.PollEvents.
+func (c *Client) cursorMoved(windowIndex int) glfw.CursorPosCallback {
+	return func(window *glfw.Window, x, y float64) {
+		if serverMonitorID, mx, my, ok := c.serverPoint(windowIndex, window, x, y); ok {
+			c.movePointer(serverMonitorID, mx, my)
+		}
+	}
+}
+
+// mouseButton returns the mouse button callback of a window, which
+// forwards presses and releases to the server where the pointer is, like
+// cursorMoved. A click on a monitor of a grid shows it alone instead. It
+// runs on the main thread from glfw.PollEvents.
+func (c *Client) mouseButton(windowIndex int) glfw.MouseButtonCallback {
+	return func(window *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+		if c.gridClicked(window, button, action) {
+			return
+		}
+		code, ok := mouseButtons[button]
+		if !ok {
+			return
+		}
+		x, y := window.GetCursorPos()
+		serverMonitorID, mx, my, ok := c.serverPoint(windowIndex, window, x, y)
+		c.sendButton(serverMonitorID, mx, my, ok, code, action == glfw.Press)
+	}
+}
+
+// movePointer forwards the pointer position on a server monitor
+func (c *Client) movePointer(serverMonitorID uint32, x, y int) {
+	c.pointer = serverPosition{serverMonitorID, x, y}
+	c.sendMouseMove(serverMonitorID, x, y)
+}
+
+// sendButton forwards a press or release of a mouse button at a position on
+// a server monitor, ok is false if the pointer isn't over one. Releases of
+// pressed buttons are forwarded anyway, at the last position the pointer
+// was forwarded to, so no button stays down on the server.
+func (c *Client) sendButton(serverMonitorID uint32, x, y int, ok bool, button protocol.MouseButton, down bool) {
+	switch {
+	case ok:
+		c.pointer = serverPosition{serverMonitorID, x, y}
+	case !down && c.buttonsDown[button]:
+		serverMonitorID, x, y = c.pointer.monitorID, c.pointer.x, c.pointer.y
+	default:
+		return
+	}
+
+	if down {
+		if c.buttonsDown == nil {
+			c.buttonsDown = make(map[protocol.MouseButton]bool)
+		}
+		c.buttonsDown[button] = true
+	} else {
+		delete(c.buttonsDown, button)
+	}
+	c.sendMouseButton(serverMonitorID, x, y, button, down)
+}
+
+// serverPoint maps a position in a window, in its screen coordinates, to
+// the server monitor shown there and the position in its pixels
+func (c *Client) serverPoint(windowIndex int, window *glfw.Window, x, y float64) (serverMonitorID uint32, mx, my int, ok bool) {
+	if c.serverMonitors == nil {
+		return 0, 0, 0, false
+	}
+	width, height := window.GetSize()
+	if width <= 0 || height <= 0 {
+		return 0, 0, 0, false
+	}
+	fx, fy := c.windowLayout(windowIndex).toFrame(x/float64(width), y/float64(height))
+	target := uint32(windowIndex + 1)
+	if c.grid {
+		if target, fx, fy, ok = c.gridPoint(fx, fy); !ok {
+			return 0, 0, 0, false
+		}
+	}
+	for _, monitor := range c.serverMonitors.Monitors {
+		if c.monitorMap[monitor.ID] == target {
+			return monitor.ID, toPixel(fx, monitor.Width), toPixel(fy, monitor.Height), true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// toPixel maps a position from 0 to 1 across size pixels to the pixel
+// there, the last one at 1
+func toPixel(f float64, size uint32) int {
+	return min(max(int(f*float64(size)), 0), int(size)-1)
+}
@@ -0,0 +1,142 @@
+//go:build cgo
+
+package client
+
+import (
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// presenter draws the frames of a window with a graphics API other than
+// OpenGL, see WithRenderer
+type presenter interface {
+	// present shows a frame placed in the window by layout with its
+	// colors adjusted by adjust, with the cursor over it if cursor is set
+	// and the border of a frame mark around it
+	present(frame *image.RGBA, layout frameLayout, adjust ColorAdjust, cursor *cursorSprite, mark int)
+
+	// clear shows the background of a window without a frame yet
+	clear()
+
+	// close releases the presenter, before its window is destroyed
+	close()
+}
+
+// newMetalPresenter draws a window with Metal. Set by platforms supporting
+// it.
+var newMetalPresenter func(window *glfw.Window, swap SwapMode) (presenter, error)
+
+// newVulkanPresenter draws a window with Vulkan. Set in builds with
+// -tags vulkan on Linux and Windows.
+var newVulkanPresenter func(window *glfw.Window, swap SwapMode) (presenter, error)
+
+// newD3D11Presenter draws a window with Direct3D 11. Set on Windows.
+var newD3D11Presenter func(window *glfw.Window, swap SwapMode) (presenter, error)
+
+// newGLESPresenter draws a window with OpenGL ES. Set in builds with
+// -tags gles on Linux and Windows.
+var newGLESPresenter func(window *glfw.Window, swap SwapMode) (presenter, error)
+
+// checkRenderer falls back to OpenGL where the renderer asked for isn't
+// available
+func (c *Client) checkRenderer() {
+	switch {
+	case c.renderer == RendererMetal && newMetalPresenter == nil:
+		log.Println("Metal is only available on macOS, rendering with OpenGL")
+		c.renderer = RendererGL
+	case c.renderer == RendererVulkan && newVulkanPresenter == nil:
+		log.Println("Vulkan needs a Linux or Windows client built with -tags vulkan, rendering with OpenGL")
+		c.renderer = RendererGL
+	case c.renderer == RendererD3D11 && newD3D11Presenter == nil:
+		log.Println("Direct3D 11 is only available on Windows, rendering with OpenGL")
+		c.renderer = RendererGL
+	case c.renderer == RendererGLES && newGLESPresenter == nil:
+		log.Println("OpenGL ES needs a Linux or Windows client built with -tags gles, rendering with OpenGL")
+		c.renderer = RendererGL
+	}
+}
+
+// setRendererHints sets the window hints of the renderer for the next
+// window created: an OpenGL 2.1 context, an OpenGL ES 2.0 one created
+// through EGL, which ANGLE provides on Windows, or none for the other
+// presenters
+func (c *Client) setRendererHints() {
+	switch c.renderer {
+	case RendererGL:
+		glfw.WindowHint(glfw.ContextVersionMajor, 2)
+		glfw.WindowHint(glfw.ContextVersionMinor, 1)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLAnyProfile)
+	case RendererGLES:
+		glfw.WindowHint(glfw.ClientAPI, glfw.OpenGLESAPI)
+		glfw.WindowHint(glfw.ContextCreationAPI, glfw.EGLContextAPI)
+		glfw.WindowHint(glfw.ContextVersionMajor, 2)
+		glfw.WindowHint(glfw.ContextVersionMinor, 0)
+	default:
+		glfw.WindowHint(glfw.ClientAPI, glfw.NoAPI)
+	}
+}
+
+// newPresenter creates the presenter of a window synchronized with its
+// display by swap, nil when rendering with OpenGL
+func (c *Client) newPresenter(window *glfw.Window, swap SwapMode) (presenter, error) {
+	switch c.renderer {
+	case RendererMetal:
+		return newMetalPresenter(window, swap)
+	case RendererVulkan:
+		return newVulkanPresenter(window, swap)
+	case RendererD3D11:
+		return newD3D11Presenter(window, swap)
+	case RendererGLES:
+		return newGLESPresenter(window, swap)
+	}
+	return nil, nil
+}
+
+// createPresenters creates the presenters of the monitor windows
+func (c *Client) createPresenters() error {
+	c.presenters = make([]presenter, len(c.windows))
+	for i, window := range c.windows {
+		if window == nil {
+			continue
+		}
+		p, err := c.newPresenter(window, c.swapMode(i))
+		if err != nil {
+			return fmt.Errorf("failed to set up %s for window %d: %w", c.renderer, i, err)
+		}
+		c.presenters[i] = p
+	}
+	return nil
+}
+
+// closePresenters releases the presenters of all windows
+func (c *Client) closePresenters() {
+	for _, p := range c.presenters {
+		if p != nil {
+			p.close()
+		}
+	}
+	c.presenters = nil
+	if c.cameraPresenter != nil {
+		c.cameraPresenter.close()
+		c.cameraPresenter = nil
+	}
+}
+
+// presentFrame shows a monitor's frame in its window with the window's
+// presenter, with the cursor and the frame mark
+func (c *Client) presentFrame(windowIndex int, serverMonitorID uint32, img image.Image) {
+	frame, ok := img.(*image.RGBA)
+	if !ok {
+		frame = displayFrame(img, nil)
+	}
+	cursor, _ := c.cursorOn(serverMonitorID, frame.Rect.Size())
+	mark := frameMarkNone
+	if c.frameMarksEnabled.Load() {
+		mark = c.frameMark(serverMonitorID)
+	}
+	layout := c.layoutWindow(windowIndex, frame.Rect.Size())
+	c.presenters[windowIndex].present(frame, layout, c.adjustments[windowIndex], cursor, mark)
+}
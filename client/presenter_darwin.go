@@ -0,0 +1,321 @@
+//go:build darwin && cgo
+
+package client
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework AppKit -framework Metal -framework QuartzCore
+#include <stdint.h>
+#include <stdio.h>
+#include <string.h>
+#import <AppKit/AppKit.h>
+#import <Metal/Metal.h>
+#import <QuartzCore/CAMetalLayer.h>
+
+// Every quad is drawn as a triangle strip stretched over rect, given as
+// x0, y0, x1, y1 in window coordinates from 0 to 1 with the origin at the
+// top left, either textured with the texture coordinates uv at its top
+// left, top right, bottom left and bottom right corners and its channels
+// adjusted by brightness, contrast and exponent in adjust as
+// ColorAdjust.apply does, or filled with color
+static NSString *const mtl_shaders =
+	@"#include <metal_stdlib>\n"
+	"using namespace metal;\n"
+	"struct Quad { float4 rect; float4 color; float4 uv[2]; float4 adjust; };\n"
+	"struct Vertex { float4 position [[position]]; float2 uv; };\n"
+	"vertex Vertex quad_vertex(uint id [[vertex_id]], constant Quad &quad [[buffer(0)]]) {\n"
+	"	float2 corner = float2(id & 1, id >> 1);\n"
+	"	float2 p = mix(quad.rect.xy, quad.rect.zw, corner);\n"
+	"	Vertex out;\n"
+	"	out.position = float4(p.x * 2.0 - 1.0, 1.0 - p.y * 2.0, 0.0, 1.0);\n"
+	"	float4 uv = quad.uv[id >> 1];\n"
+	"	out.uv = (id & 1) ? uv.zw : uv.xy;\n"
+	"	return out;\n"
+	"}\n"
+	"fragment float4 quad_texture(Vertex in [[stage_in]], texture2d<float> tex [[texture(0)]],\n"
+	"		constant Quad &quad [[buffer(0)]]) {\n"
+	"	constexpr sampler s(filter::linear);\n"
+	"	float4 color = tex.sample(s, in.uv);\n"
+	"	float3 rgb = clamp((color.rgb - 0.5) * quad.adjust.y + 0.5 + quad.adjust.x, 0.0, 1.0);\n"
+	"	return float4(pow(rgb, float3(quad.adjust.z)), color.a);\n"
+	"}\n"
+	"fragment float4 quad_color(Vertex in [[stage_in]], constant Quad &quad [[buffer(0)]]) {\n"
+	"	return quad.color;\n"
+	"}\n";
+
+typedef struct {
+	float rect[4];
+	float color[4];
+	float uv[8];
+	float adjust[4];
+} mtl_quad;
+
+// The device and pipelines are shared by the windows
+static id<MTLDevice> mtl_device;
+static id<MTLCommandQueue> mtl_queue;
+static id<MTLRenderPipelineState> mtl_texture_pipeline;
+static id<MTLRenderPipelineState> mtl_color_pipeline;
+
+// URDPPresenter draws the frames of a window into its CAMetalLayer
+@interface URDPPresenter : NSObject {
+@public
+	NSWindow *window;
+	CAMetalLayer *layer;
+	id<MTLTexture> frame;
+	id<MTLCommandBuffer> last; // Reads frame until completed
+}
+@end
+
+@implementation URDPPresenter
+@end
+
+static id<MTLRenderPipelineState> mtl_pipeline(id<MTLLibrary> library, NSString *fragment, NSError **error) {
+	MTLRenderPipelineDescriptor *desc = [[MTLRenderPipelineDescriptor alloc] init];
+	desc.vertexFunction = [library newFunctionWithName:@"quad_vertex"];
+	desc.fragmentFunction = [library newFunctionWithName:fragment];
+	MTLRenderPipelineColorAttachmentDescriptor *color = desc.colorAttachments[0];
+	color.pixelFormat = MTLPixelFormatBGRA8Unorm;
+	color.blendingEnabled = YES;
+	color.sourceRGBBlendFactor = MTLBlendFactorSourceAlpha;
+	color.destinationRGBBlendFactor = MTLBlendFactorOneMinusSourceAlpha;
+	color.sourceAlphaBlendFactor = MTLBlendFactorOne;
+	color.destinationAlphaBlendFactor = MTLBlendFactorOneMinusSourceAlpha;
+	return [mtl_device newRenderPipelineStateWithDescriptor:desc error:error];
+}
+
+// mtl_init creates the shared device and pipelines on first use. It
+// returns 0 on success and copies the error message to err otherwise.
+static int mtl_init(char *err, size_t err_len) {
+	if (mtl_device != nil) {
+		return 0;
+	}
+	id<MTLDevice> device = MTLCreateSystemDefaultDevice();
+	if (device == nil) {
+		snprintf(err, err_len, "no Metal device");
+		return -1;
+	}
+	NSError *error = nil;
+	id<MTLLibrary> library = [device newLibraryWithSource:mtl_shaders options:nil error:&error];
+	if (library == nil) {
+		snprintf(err, err_len, "%s", error.localizedDescription.UTF8String);
+		return -1;
+	}
+	mtl_device = device;
+	mtl_texture_pipeline = mtl_pipeline(library, @"quad_texture", &error);
+	if (mtl_texture_pipeline != nil) {
+		mtl_color_pipeline = mtl_pipeline(library, @"quad_color", &error);
+	}
+	if (mtl_color_pipeline == nil) {
+		snprintf(err, err_len, "%s", error.localizedDescription.UTF8String);
+		mtl_device = nil;
+		return -1;
+	}
+	mtl_queue = [device newCommandQueue];
+	return 0;
+}
+
+// mtl_open backs a window's content view with a CAMetalLayer, presenting
+// on vertical blanks if display_sync is set with at most drawables frames
+// in flight. It returns the presenter or NULL and copies the error message
+// to err.
+static void *mtl_open(void *nswindow, int display_sync, int drawables, char *err, size_t err_len) {
+	if (mtl_init(err, err_len) != 0) {
+		return NULL;
+	}
+	NSWindow *window = (__bridge NSWindow *)nswindow;
+	URDPPresenter *p = [[URDPPresenter alloc] init];
+	p->window = window;
+	p->layer = [CAMetalLayer layer];
+	p->layer.device = mtl_device;
+	p->layer.pixelFormat = MTLPixelFormatBGRA8Unorm;
+	p->layer.framebufferOnly = YES;
+	p->layer.contentsScale = window.backingScaleFactor;
+	if (@available(macOS 10.13.2, *)) {
+		p->layer.displaySyncEnabled = display_sync != 0;
+		p->layer.maximumDrawableCount = (NSUInteger)drawables;
+	}
+	window.contentView.wantsLayer = YES;
+	window.contentView.layer = p->layer;
+	return (__bridge_retained void *)p;
+}
+
+static void mtl_close(void *presenter) {
+	URDPPresenter *p = (__bridge_transfer URDPPresenter *)presenter;
+	[p->last waitUntilCompleted];
+	p->window.contentView.layer = nil;
+}
+
+// mtl_upload copies RGBA pixels to a texture, reusing it if the size
+// matches
+static id<MTLTexture> mtl_upload(id<MTLTexture> texture, const uint8_t *pix, int width, int height, int stride) {
+	if (texture == nil || texture.width != (NSUInteger)width || texture.height != (NSUInteger)height) {
+		MTLTextureDescriptor *desc = [MTLTextureDescriptor texture2DDescriptorWithPixelFormat:MTLPixelFormatRGBA8Unorm
+			width:width height:height mipmapped:NO];
+		desc.usage = MTLTextureUsageShaderRead;
+		texture = [mtl_device newTextureWithDescriptor:desc];
+	}
+	[texture replaceRegion:MTLRegionMake2D(0, 0, width, height) mipmapLevel:0 withBytes:pix bytesPerRow:stride];
+	return texture;
+}
+
+static void mtl_draw(id<MTLRenderCommandEncoder> encoder, id<MTLTexture> texture, mtl_quad quad) {
+	if (texture != nil) {
+		[encoder setRenderPipelineState:mtl_texture_pipeline];
+		[encoder setFragmentTexture:texture atIndex:0];
+	} else {
+		[encoder setRenderPipelineState:mtl_color_pipeline];
+	}
+	[encoder setFragmentBytes:&quad length:sizeof(quad) atIndex:0];
+	[encoder setVertexBytes:&quad length:sizeof(quad) atIndex:0];
+	[encoder drawPrimitives:MTLPrimitiveTypeTriangleStrip vertexStart:0 vertexCount:4];
+}
+
+// mtl_present draws a frame over the part dst of the window with the
+// texture coordinates uv at its corners and its colors adjusted by adjust,
+// then the cursor in rect
+// cursor_rect with cursor_uv if cursor_pix is set and a border of border
+// pixels in mark_color around dst if its alpha isn't 0.
+// Without frame_pix only the background is drawn.
+static void mtl_present(void *presenter,
+		const uint8_t *frame_pix, int width, int height, int stride, const float *dst, const float *uv, const float *adjust,
+		const uint8_t *cursor_pix, int cursor_width, int cursor_height, const float *cursor_rect, const float *cursor_uv,
+		const float *mark_color, float border) {
+	@autoreleasepool {
+		URDPPresenter *p = (__bridge URDPPresenter *)presenter;
+
+		// The frame texture is rewritten, wait for the GPU to be done with it
+		[p->last waitUntilCompleted];
+		p->last = nil;
+
+		NSSize size = p->window.contentView.bounds.size;
+		CGFloat scale = p->window.backingScaleFactor;
+		p->layer.contentsScale = scale;
+		p->layer.drawableSize = CGSizeMake(size.width * scale, size.height * scale);
+		id<CAMetalDrawable> drawable = [p->layer nextDrawable];
+		if (drawable == nil) {
+			return;
+		}
+
+		MTLRenderPassDescriptor *pass = [MTLRenderPassDescriptor renderPassDescriptor];
+		pass.colorAttachments[0].texture = drawable.texture;
+		pass.colorAttachments[0].loadAction = MTLLoadActionClear;
+		pass.colorAttachments[0].storeAction = MTLStoreActionStore;
+		pass.colorAttachments[0].clearColor = MTLClearColorMake(0.0, 0.0, 0.2, 1.0);
+
+		id<MTLCommandBuffer> buffer = [mtl_queue commandBuffer];
+		id<MTLRenderCommandEncoder> encoder = [buffer renderCommandEncoderWithDescriptor:pass];
+		if (frame_pix != NULL) {
+			p->frame = mtl_upload(p->frame, frame_pix, width, height, stride);
+			mtl_quad quad = {{dst[0], dst[1], dst[2], dst[3]}};
+			memcpy(quad.uv, uv, sizeof(quad.uv));
+			memcpy(quad.adjust, adjust, sizeof(quad.adjust));
+			mtl_draw(encoder, p->frame, quad);
+		}
+		if (frame_pix != NULL && cursor_pix != NULL) {
+			id<MTLTexture> cursor = mtl_upload(nil, cursor_pix, cursor_width, cursor_height, cursor_width * 4);
+			mtl_quad quad = {{cursor_rect[0], cursor_rect[1], cursor_rect[2], cursor_rect[3]}};
+			memcpy(quad.uv, cursor_uv, sizeof(quad.uv));
+			quad.adjust[1] = quad.adjust[2] = 1; // As it is
+			mtl_draw(encoder, cursor, quad);
+		}
+		if (frame_pix != NULL && mark_color[3] > 0) {
+			float bx = border / drawable.texture.width, by = border / drawable.texture.height;
+			float rects[4][4] = {{dst[0], dst[1], dst[2], dst[1] + by}, {dst[0], dst[3] - by, dst[2], dst[3]},
+				{dst[0], dst[1], dst[0] + bx, dst[3]}, {dst[2] - bx, dst[1], dst[2], dst[3]}};
+			for (int i = 0; i < 4; i++) {
+				mtl_quad quad = {{rects[i][0], rects[i][1], rects[i][2], rects[i][3]},
+					{mark_color[0], mark_color[1], mark_color[2], mark_color[3]}};
+				mtl_draw(encoder, nil, quad);
+			}
+		}
+		[encoder endEncoding];
+		[buffer presentDrawable:drawable];
+		[buffer commit];
+		p->last = buffer;
+	}
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// mtlErrorSize is the size of the buffer Metal's error messages are copied
+// to
+const mtlErrorSize = 256
+
+func init() {
+	newMetalPresenter = newCocoaMetalPresenter
+}
+
+// cocoaMetalPresenter draws a window with Metal, through a CAMetalLayer
+// backing its content view
+type cocoaMetalPresenter struct {
+	ref unsafe.Pointer
+}
+
+// newCocoaMetalPresenter sets up Metal drawing for a window created
+// without a client API
+func newCocoaMetalPresenter(window *glfw.Window, swap SwapMode) (presenter, error) {
+	// Low latency keeps two drawables instead of three
+	sync, drawables := C.int(1), C.int(2)
+	switch swap {
+	case SwapVSync:
+		drawables = 3
+	case SwapOff:
+		sync = 0
+	}
+	var message [mtlErrorSize]C.char
+	ref := C.mtl_open(window.GetCocoaWindow(), sync, drawables, &message[0], mtlErrorSize)
+	if ref == nil {
+		return nil, errors.New(C.GoString(&message[0]))
+	}
+	return &cocoaMetalPresenter{ref: ref}, nil
+}
+
+func (p *cocoaMetalPresenter) present(frame *image.RGBA, layout frameLayout, adjust ColorAdjust, cursor *cursorSprite, mark int) {
+	size := frame.Rect.Size()
+	if size.X <= 0 || size.Y <= 0 {
+		p.clear()
+		return
+	}
+	pix := (*C.uint8_t)(unsafe.Pointer(&frame.Pix[frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y)]))
+
+	// Frame pixels map to the window the way the frame does, turned with it
+	var cursorPix *C.uint8_t
+	var cursorRect [4]float32
+	var cursorWidth, cursorHeight C.int
+	cursorUV := layout.rotation.corners([4]float32{0, 0, 1, 1})
+	if cursor != nil {
+		cursorPix = (*C.uint8_t)(unsafe.Pointer(&cursor.shape.Pixels[0]))
+		cursorWidth, cursorHeight = C.int(cursor.shape.Width), C.int(cursor.shape.Height)
+		cursorRect = layout.rect(cursor.x, cursor.y, cursor.width, cursor.height, size)
+	}
+	uv := layout.texCoords()
+	params := adjust.shaderParams()
+	var markColor [4]C.float
+	if color, ok := frameMarkColor(mark); ok {
+		markColor = [4]C.float{C.float(color[0]), C.float(color[1]), C.float(color[2]), C.float(color[3])}
+	}
+
+	C.mtl_present(p.ref, pix, C.int(size.X), C.int(size.Y), C.int(frame.Stride),
+		(*C.float)(&layout.dst[0]), (*C.float)(&uv[0][0]), (*C.float)(&params[0]),
+		cursorPix, cursorWidth, cursorHeight, (*C.float)(&cursorRect[0]), (*C.float)(&cursorUV[0][0]),
+		&markColor[0], frameMarkBorder)
+}
+
+func (p *cocoaMetalPresenter) clear() {
+	var rect, color [4]C.float
+	var uv [8]C.float
+	C.mtl_present(p.ref, nil, 0, 0, 0, &rect[0], &uv[0], &color[0], nil, 0, 0, &rect[0], &uv[0], &color[0], 0)
+}
+
+func (p *cocoaMetalPresenter) close() {
+	C.mtl_close(p.ref)
+	p.ref = nil
+}
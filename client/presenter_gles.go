@@ -0,0 +1,358 @@
+//go:build cgo && gles && (linux || windows)
+
+package client
+
+/*
+#cgo linux LDFLAGS: -lGLESv2
+#cgo windows LDFLAGS: -lGLESv2
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <GLES2/gl2.h>
+
+// Every quad is drawn as a triangle strip stretched over rect, given as
+// x0, y0, x1, y1 in window coordinates from 0 to 1 with the origin at the
+// top left, either textured with the texture coordinates uv at its top
+// left, top right, bottom left and bottom right corners and its channels
+// adjusted by adjust, or filled with color, like with Direct3D. GLSL ES
+// 1.00 runs on OpenGL ES 2.0 and every later version.
+static const char gles_vertex_source[] =
+	"attribute vec2 corner;\n"
+	"uniform vec4 rect;\n"
+	"uniform vec4 uv[2];\n"
+	"varying vec2 v_uv;\n"
+	"void main() {\n"
+	"	vec2 p = mix(rect.xy, rect.zw, corner);\n"
+	"	gl_Position = vec4(p.x * 2.0 - 1.0, 1.0 - p.y * 2.0, 0.0, 1.0);\n"
+	"	vec4 pair = corner.y < 0.5 ? uv[0] : uv[1];\n"
+	"	v_uv = corner.x < 0.5 ? pair.xy : pair.zw;\n"
+	"}\n";
+
+static const char gles_texture_source[] =
+	"precision mediump float;\n"
+	"uniform sampler2D tex;\n"
+	"uniform vec4 adjust;\n"
+	"varying vec2 v_uv;\n"
+	"void main() {\n"
+	"	vec4 c = texture2D(tex, v_uv);\n"
+	"	vec3 rgb = clamp((c.rgb - 0.5) * adjust.y + 0.5 + adjust.x, 0.0, 1.0);\n"
+	"	gl_FragColor = vec4(pow(rgb, vec3(adjust.z)), c.a);\n"
+	"}\n";
+
+static const char gles_color_source[] =
+	"precision mediump float;\n"
+	"uniform vec4 color;\n"
+	"void main() {\n"
+	"	gl_FragColor = color;\n"
+	"}\n";
+
+// The corners of a quad in triangle strip order
+static const GLfloat gles_corners[8] = {0, 0, 1, 0, 0, 1, 1, 1};
+
+// gles_program is a linked program and the locations of its inputs
+typedef struct {
+	GLuint id;
+	GLint rect, uv, adjust, color;
+} gles_program;
+
+typedef struct {
+	GLuint id;
+	int width, height;
+} gles_texture;
+
+// gles_presenter draws a window's frames in its OpenGL ES context. Objects
+// aren't shared between contexts, so every window has its own.
+typedef struct {
+	gles_program textured, filled;
+	GLuint corners; // Vertex buffer of gles_corners
+	gles_texture frame, cursor;
+} gles_presenter;
+
+static GLuint gles_shader(GLenum type, const char *source, char *err, size_t err_len) {
+	GLuint shader = glCreateShader(type);
+	glShaderSource(shader, 1, &source, NULL);
+	glCompileShader(shader);
+	GLint status = GL_FALSE;
+	glGetShaderiv(shader, GL_COMPILE_STATUS, &status);
+	if (status == GL_FALSE) {
+		char log[256] = {0};
+		glGetShaderInfoLog(shader, sizeof(log), NULL, log);
+		snprintf(err, err_len, "shader failed to compile: %s", log);
+		glDeleteShader(shader);
+		return 0;
+	}
+	return shader;
+}
+
+// gles_link links the vertex shader with the fragment shader from source.
+// Both programs read the corners at attribute 0.
+static int gles_link(gles_program *program, GLuint vertex, const char *source, char *err, size_t err_len) {
+	GLuint fragment = gles_shader(GL_FRAGMENT_SHADER, source, err, err_len);
+	if (fragment == 0) {
+		return -1;
+	}
+	program->id = glCreateProgram();
+	glAttachShader(program->id, vertex);
+	glAttachShader(program->id, fragment);
+	glBindAttribLocation(program->id, 0, "corner");
+	glLinkProgram(program->id);
+	glDeleteShader(fragment); // Freed with the program
+	GLint status = GL_FALSE;
+	glGetProgramiv(program->id, GL_LINK_STATUS, &status);
+	if (status == GL_FALSE) {
+		char log[256] = {0};
+		glGetProgramInfoLog(program->id, sizeof(log), NULL, log);
+		snprintf(err, err_len, "program failed to link: %s", log);
+		return -1;
+	}
+	program->rect = glGetUniformLocation(program->id, "rect");
+	program->uv = glGetUniformLocation(program->id, "uv");
+	program->adjust = glGetUniformLocation(program->id, "adjust");
+	program->color = glGetUniformLocation(program->id, "color");
+	glUseProgram(program->id);
+	glUniform1i(glGetUniformLocation(program->id, "tex"), 0);
+	return 0;
+}
+
+static void gles_close(void *presenter) {
+	gles_presenter *p = presenter;
+	GLuint textures[2] = {p->frame.id, p->cursor.id};
+	glDeleteTextures(2, textures);
+	glDeleteBuffers(1, &p->corners);
+	glDeleteProgram(p->textured.id);
+	glDeleteProgram(p->filled.id);
+	free(p);
+}
+
+// gles_open compiles the shaders of a window in its current context. It
+// returns the presenter or NULL and copies the error message to err.
+static void *gles_open(char *err, size_t err_len) {
+	gles_presenter *p = calloc(1, sizeof(gles_presenter));
+	if (p == NULL) {
+		snprintf(err, err_len, "out of memory");
+		return NULL;
+	}
+	GLuint vertex = gles_shader(GL_VERTEX_SHADER, gles_vertex_source, err, err_len);
+	if (vertex == 0) {
+		free(p);
+		return NULL;
+	}
+	int failed = gles_link(&p->textured, vertex, gles_texture_source, err, err_len) != 0 ||
+		gles_link(&p->filled, vertex, gles_color_source, err, err_len) != 0;
+	glDeleteShader(vertex);
+	if (failed) {
+		gles_close(p);
+		return NULL;
+	}
+
+	glGenBuffers(1, &p->corners);
+	glBindBuffer(GL_ARRAY_BUFFER, p->corners);
+	glBufferData(GL_ARRAY_BUFFER, sizeof(gles_corners), gles_corners, GL_STATIC_DRAW);
+	glVertexAttribPointer(0, 2, GL_FLOAT, GL_FALSE, 0, NULL);
+	glEnableVertexAttribArray(0);
+	glBlendFunc(GL_SRC_ALPHA, GL_ONE_MINUS_SRC_ALPHA);
+	return p;
+}
+
+// gles_upload copies RGBA pixels to a texture, reallocating it if the size
+// changed. OpenGL ES 2.0 can't skip the padding of rows, so padded rows
+// are copied one at a time. Textures that aren't powers of two are only
+// allowed without mipmaps and repeating.
+static void gles_upload(gles_texture *texture, const uint8_t *pix, int width, int height, int stride) {
+	if (texture->id == 0) {
+		glGenTextures(1, &texture->id);
+		glBindTexture(GL_TEXTURE_2D, texture->id);
+		glTexParameteri(GL_TEXTURE_2D, GL_TEXTURE_MIN_FILTER, GL_LINEAR);
+		glTexParameteri(GL_TEXTURE_2D, GL_TEXTURE_MAG_FILTER, GL_LINEAR);
+		glTexParameteri(GL_TEXTURE_2D, GL_TEXTURE_WRAP_S, GL_CLAMP_TO_EDGE);
+		glTexParameteri(GL_TEXTURE_2D, GL_TEXTURE_WRAP_T, GL_CLAMP_TO_EDGE);
+	} else {
+		glBindTexture(GL_TEXTURE_2D, texture->id);
+	}
+	glPixelStorei(GL_UNPACK_ALIGNMENT, 4);
+	if (texture->width != width || texture->height != height) {
+		glTexImage2D(GL_TEXTURE_2D, 0, GL_RGBA, width, height, 0, GL_RGBA, GL_UNSIGNED_BYTE,
+			stride == width * 4 ? pix : NULL);
+		texture->width = width;
+		texture->height = height;
+		if (stride == width * 4) {
+			return;
+		}
+	}
+	if (stride == width * 4) {
+		glTexSubImage2D(GL_TEXTURE_2D, 0, 0, 0, width, height, GL_RGBA, GL_UNSIGNED_BYTE, pix);
+		return;
+	}
+	for (int y = 0; y < height; y++) {
+		glTexSubImage2D(GL_TEXTURE_2D, 0, 0, y, width, 1, GL_RGBA, GL_UNSIGNED_BYTE, pix + y * stride);
+	}
+}
+
+static void gles_draw_texture(gles_presenter *p, const float *rect, const float *uv, const float *adjust) {
+	glUseProgram(p->textured.id);
+	glUniform4fv(p->textured.rect, 1, rect);
+	glUniform4fv(p->textured.uv, 2, uv);
+	glUniform4fv(p->textured.adjust, 1, adjust);
+	glDrawArrays(GL_TRIANGLE_STRIP, 0, 4);
+}
+
+static void gles_draw_color(gles_presenter *p, const float *rect, const float *color) {
+	glUseProgram(p->filled.id);
+	glUniform4fv(p->filled.rect, 1, rect);
+	glUniform4fv(p->filled.color, 1, color);
+	glDrawArrays(GL_TRIANGLE_STRIP, 0, 4);
+}
+
+// gles_present draws a frame into a window of window_width by
+// window_height pixels over the part dst of it with the texture
+// coordinates uv at its corners and its colors adjusted by adjust, then
+// the cursor in cursor_rect with cursor_uv if cursor_pix is set and a
+// border of border pixels in mark_color around dst if its alpha isn't 0.
+// Without frame_pix only the background is drawn. The caller swaps the
+// window's buffers. It returns the first OpenGL ES error, if any.
+static GLenum gles_present(void *presenter, int window_width, int window_height,
+		const uint8_t *frame_pix, int width, int height, int stride, const float *dst, const float *uv, const float *adjust,
+		const uint8_t *cursor_pix, int cursor_width, int cursor_height, const float *cursor_rect, const float *cursor_uv,
+		const float *mark_color, float border) {
+	gles_presenter *p = presenter;
+	glViewport(0, 0, window_width, window_height);
+	glClearColor(0.0f, 0.0f, 0.2f, 1.0f);
+	glClear(GL_COLOR_BUFFER_BIT);
+	glBindBuffer(GL_ARRAY_BUFFER, p->corners);
+	glActiveTexture(GL_TEXTURE0);
+
+	if (frame_pix != NULL) {
+		glDisable(GL_BLEND);
+		gles_upload(&p->frame, frame_pix, width, height, stride);
+		gles_draw_texture(p, dst, uv, adjust);
+	}
+	glEnable(GL_BLEND);
+	if (frame_pix != NULL && cursor_pix != NULL) {
+		static const float as_it_is[4] = {0, 1, 1, 0};
+		gles_upload(&p->cursor, cursor_pix, cursor_width, cursor_height, cursor_width * 4);
+		gles_draw_texture(p, cursor_rect, cursor_uv, as_it_is);
+	}
+	if (frame_pix != NULL && mark_color[3] > 0) {
+		float bx = border / window_width, by = border / window_height;
+		float rects[4][4] = {{dst[0], dst[1], dst[2], dst[1] + by}, {dst[0], dst[3] - by, dst[2], dst[3]},
+			{dst[0], dst[1], dst[0] + bx, dst[3]}, {dst[2] - bx, dst[1], dst[2], dst[3]}};
+		for (int i = 0; i < 4; i++) {
+			gles_draw_color(p, rects[i], mark_color);
+		}
+	}
+	return glGetError();
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"log"
+	"unsafe"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// glesErrorSize is the size of the buffer OpenGL ES setup errors are
+// copied to
+const glesErrorSize = 512
+
+func init() {
+	newGLESPresenter = newESPresenter
+}
+
+// esPresenter draws a window with OpenGL ES in the window's own context
+type esPresenter struct {
+	window     *glfw.Window
+	ref        unsafe.Pointer
+	lowLatency bool // Wait for every swap to finish, see swapBuffers
+	failed     bool // Presenting failed, logged until it works again
+}
+
+// newESPresenter sets up OpenGL ES drawing for a window created with an
+// OpenGL ES context
+func newESPresenter(window *glfw.Window, swap SwapMode) (presenter, error) {
+	window.MakeContextCurrent()
+	// EGL has no adaptive sync, low latency syncs and waits for swaps
+	if swap == SwapOff {
+		glfw.SwapInterval(0)
+	} else {
+		glfw.SwapInterval(1)
+	}
+	var message [glesErrorSize]C.char
+	ref := C.gles_open(&message[0], glesErrorSize)
+	if ref == nil {
+		return nil, errors.New(C.GoString(&message[0]))
+	}
+	return &esPresenter{window: window, ref: ref, lowLatency: swap == SwapLowLatency}, nil
+}
+
+func (p *esPresenter) present(frame *image.RGBA, layout frameLayout, adjust ColorAdjust, cursor *cursorSprite, mark int) {
+	size := frame.Rect.Size()
+	if size.X <= 0 || size.Y <= 0 {
+		p.clear()
+		return
+	}
+	pix := (*C.uint8_t)(unsafe.Pointer(&frame.Pix[frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y)]))
+
+	// Frame pixels map to the window the way the frame does, turned with it
+	var cursorPix *C.uint8_t
+	var cursorRect [4]float32
+	var cursorWidth, cursorHeight C.int
+	cursorUV := layout.rotation.corners([4]float32{0, 0, 1, 1})
+	if cursor != nil {
+		cursorPix = (*C.uint8_t)(unsafe.Pointer(&cursor.shape.Pixels[0]))
+		cursorWidth, cursorHeight = C.int(cursor.shape.Width), C.int(cursor.shape.Height)
+		cursorRect = layout.rect(cursor.x, cursor.y, cursor.width, cursor.height, size)
+	}
+	uv := layout.texCoords()
+	params := adjust.shaderParams()
+	var markColor [4]C.float
+	if color, ok := frameMarkColor(mark); ok {
+		markColor = [4]C.float{C.float(color[0]), C.float(color[1]), C.float(color[2]), C.float(color[3])}
+	}
+
+	p.draw(func(width, height C.int) C.GLenum {
+		return C.gles_present(p.ref, width, height, pix, C.int(size.X), C.int(size.Y), C.int(frame.Stride),
+			(*C.float)(&layout.dst[0]), (*C.float)(&uv[0][0]), (*C.float)(&params[0]),
+			cursorPix, cursorWidth, cursorHeight, (*C.float)(&cursorRect[0]), (*C.float)(&cursorUV[0][0]),
+			&markColor[0], frameMarkBorder)
+	})
+}
+
+func (p *esPresenter) clear() {
+	var rect, color [4]C.float
+	var uv [8]C.float
+	p.draw(func(width, height C.int) C.GLenum {
+		return C.gles_present(p.ref, width, height, nil, 0, 0, 0, &rect[0], &uv[0], &color[0], nil, 0, 0, &rect[0], &uv[0], &color[0], 0)
+	})
+}
+
+func (p *esPresenter) close() {
+	p.window.MakeContextCurrent()
+	C.gles_close(p.ref)
+	p.ref = nil
+}
+
+// draw runs gles_present through present in the window's context and
+// swaps its buffers, unless the window is minimized
+func (p *esPresenter) draw(present func(width, height C.int) C.GLenum) {
+	width, height := p.window.GetFramebufferSize()
+	if width <= 0 || height <= 0 {
+		return
+	}
+	p.window.MakeContextCurrent()
+	err := present(C.int(width), C.int(height))
+	p.window.SwapBuffers()
+	if p.lowLatency {
+		C.glFinish()
+	}
+
+	// Logs the first of consecutive failures
+	if err != C.GL_NO_ERROR && !p.failed {
+		log.Printf("OpenGL ES presentation failed: error 0x%04x", uint32(err))
+	}
+	p.failed = err != C.GL_NO_ERROR
+}
@@ -0,0 +1,892 @@
+//go:build cgo && vulkan && (linux || windows)
+
+package client
+
+/*
+#cgo linux LDFLAGS: -lvulkan
+#cgo windows LDFLAGS: -lvulkan-1
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <vulkan/vulkan.h>
+
+// GLFW is compiled into the glfw package, whose headers aren't on the
+// include path here
+typedef struct GLFWwindow GLFWwindow;
+extern const char **glfwGetRequiredInstanceExtensions(uint32_t *count);
+extern VkResult glfwCreateWindowSurface(VkInstance instance, GLFWwindow *window,
+	const VkAllocationCallbacks *allocator, VkSurfaceKHR *surface);
+extern void glfwGetFramebufferSize(GLFWwindow *window, int *width, int *height);
+
+#define VK_MAX_IMAGES 8
+
+// The instance, device and queue are shared by the windows: every window's
+// uploads and presentation go through the one queue, from the display loop
+static VkInstance vk_instance;
+static VkPhysicalDevice vk_gpu;
+static VkDevice vk_device;
+static uint32_t vk_family;
+static VkQueue vk_queue;
+static VkCommandPool vk_pool;
+
+typedef struct {
+	VkImage image;
+	VkDeviceMemory memory;
+	int width, height;
+} vk_texture;
+
+// vk_presenter copies a window's frames to the images of its swapchain,
+// scaling them with a blit, so no pipeline or shader is needed
+typedef struct {
+	GLFWwindow *window;
+	VkSurfaceKHR surface;
+	VkSurfaceFormatKHR format;
+	VkFormat source_format; // Of the frame, in the swapchain's encoding
+	VkPresentModeKHR mode;
+	VkSwapchainKHR swapchain;
+	VkExtent2D extent;
+	uint32_t image_count;
+	VkImage images[VK_MAX_IMAGES];
+	VkSemaphore rendered[VK_MAX_IMAGES]; // By image, signaled when it's drawn
+	VkSemaphore acquired;
+	VkFence done; // Signaled when the window's last commands completed
+	VkCommandBuffer commands;
+	int stale; // The swapchain no longer matches the window
+	VkResult failed; // Presentation can't go on after a failed submit
+
+	VkBuffer staging; // Frame pixels on their way to frame
+	VkDeviceMemory staging_memory;
+	VkDeviceSize staging_size;
+	uint8_t *staging_map;
+	vk_texture frame;
+	vk_texture mark; // A pixel in the frame mark's color
+} vk_presenter;
+
+static int vk_fail(char *err, size_t err_len, const char *what, VkResult result) {
+	snprintf(err, err_len, "%s failed: VkResult %d", what, (int)result);
+	return -1;
+}
+
+// vk_init_instance creates the shared instance on first use, with the
+// extensions GLFW needs for window surfaces
+static int vk_init_instance(char *err, size_t err_len) {
+	if (vk_instance != VK_NULL_HANDLE) {
+		return 0;
+	}
+	uint32_t count = 0;
+	const char **extensions = glfwGetRequiredInstanceExtensions(&count);
+	if (extensions == NULL) {
+		snprintf(err, err_len, "no Vulkan loader with window surface support");
+		return -1;
+	}
+	VkApplicationInfo app = {
+		.sType = VK_STRUCTURE_TYPE_APPLICATION_INFO,
+		.pApplicationName = "UltraRDP",
+		.apiVersion = VK_API_VERSION_1_0,
+	};
+	VkInstanceCreateInfo info = {
+		.sType = VK_STRUCTURE_TYPE_INSTANCE_CREATE_INFO,
+		.pApplicationInfo = &app,
+		.enabledExtensionCount = count,
+		.ppEnabledExtensionNames = extensions,
+	};
+	VkInstance instance;
+	VkResult result = vkCreateInstance(&info, NULL, &instance);
+	if (result != VK_SUCCESS) {
+		return vk_fail(err, err_len, "vkCreateInstance", result);
+	}
+	vk_instance = instance;
+	return 0;
+}
+
+static int vk_has_swapchain(VkPhysicalDevice gpu) {
+	uint32_t count = 0;
+	vkEnumerateDeviceExtensionProperties(gpu, NULL, &count, NULL);
+	VkExtensionProperties *extensions = calloc(count, sizeof(VkExtensionProperties));
+	if (extensions == NULL) {
+		return 0;
+	}
+	vkEnumerateDeviceExtensionProperties(gpu, NULL, &count, extensions);
+	int found = 0;
+	for (uint32_t i = 0; i < count && !found; i++) {
+		found = strcmp(extensions[i].extensionName, VK_KHR_SWAPCHAIN_EXTENSION_NAME) == 0;
+	}
+	free(extensions);
+	return found;
+}
+
+// vk_init_device picks a GPU with a queue that can draw and present to
+// the surface on first use, preferring a discrete one, and creates the
+// shared device. Later windows must be presentable from the same queue.
+static int vk_init_device(VkSurfaceKHR surface, char *err, size_t err_len) {
+	if (vk_device != VK_NULL_HANDLE) {
+		VkBool32 supported = VK_FALSE;
+		vkGetPhysicalDeviceSurfaceSupportKHR(vk_gpu, vk_family, surface, &supported);
+		if (!supported) {
+			snprintf(err, err_len, "the GPU in use can't present to this window");
+			return -1;
+		}
+		return 0;
+	}
+
+	VkPhysicalDevice gpus[16];
+	uint32_t count = 16;
+	vkEnumeratePhysicalDevices(vk_instance, &count, gpus);
+	int best = -1, best_discrete = 0;
+	uint32_t best_family = 0;
+	for (uint32_t i = 0; i < count; i++) {
+		if (!vk_has_swapchain(gpus[i])) {
+			continue;
+		}
+		VkPhysicalDeviceProperties properties;
+		vkGetPhysicalDeviceProperties(gpus[i], &properties);
+		int discrete = properties.deviceType == VK_PHYSICAL_DEVICE_TYPE_DISCRETE_GPU;
+		if (best >= 0 && (best_discrete || !discrete)) {
+			continue;
+		}
+		VkQueueFamilyProperties families[16];
+		uint32_t family_count = 16;
+		vkGetPhysicalDeviceQueueFamilyProperties(gpus[i], &family_count, families);
+		for (uint32_t f = 0; f < family_count; f++) {
+			VkBool32 present = VK_FALSE;
+			vkGetPhysicalDeviceSurfaceSupportKHR(gpus[i], f, surface, &present);
+			if ((families[f].queueFlags & VK_QUEUE_GRAPHICS_BIT) && present) {
+				best = (int)i;
+				best_family = f;
+				best_discrete = discrete;
+				break;
+			}
+		}
+	}
+	if (best < 0) {
+		snprintf(err, err_len, "no GPU can present to the window");
+		return -1;
+	}
+
+	float priority = 1.0f;
+	VkDeviceQueueCreateInfo queue = {
+		.sType = VK_STRUCTURE_TYPE_DEVICE_QUEUE_CREATE_INFO,
+		.queueFamilyIndex = best_family,
+		.queueCount = 1,
+		.pQueuePriorities = &priority,
+	};
+	const char *extensions[] = {VK_KHR_SWAPCHAIN_EXTENSION_NAME};
+	VkDeviceCreateInfo info = {
+		.sType = VK_STRUCTURE_TYPE_DEVICE_CREATE_INFO,
+		.queueCreateInfoCount = 1,
+		.pQueueCreateInfos = &queue,
+		.enabledExtensionCount = 1,
+		.ppEnabledExtensionNames = extensions,
+	};
+	VkDevice device;
+	VkResult result = vkCreateDevice(gpus[best], &info, NULL, &device);
+	if (result != VK_SUCCESS) {
+		return vk_fail(err, err_len, "vkCreateDevice", result);
+	}
+	VkCommandPoolCreateInfo pool = {
+		.sType = VK_STRUCTURE_TYPE_COMMAND_POOL_CREATE_INFO,
+		.flags = VK_COMMAND_POOL_CREATE_RESET_COMMAND_BUFFER_BIT,
+		.queueFamilyIndex = best_family,
+	};
+	result = vkCreateCommandPool(device, &pool, NULL, &vk_pool);
+	if (result != VK_SUCCESS) {
+		vkDestroyDevice(device, NULL);
+		return vk_fail(err, err_len, "vkCreateCommandPool", result);
+	}
+	vk_gpu = gpus[best];
+	vk_family = best_family;
+	vk_device = device;
+	vkGetDeviceQueue(device, best_family, 0, &vk_queue);
+	return 0;
+}
+
+static int vk_memory_type(uint32_t bits, VkMemoryPropertyFlags flags) {
+	VkPhysicalDeviceMemoryProperties properties;
+	vkGetPhysicalDeviceMemoryProperties(vk_gpu, &properties);
+	for (uint32_t i = 0; i < properties.memoryTypeCount; i++) {
+		if ((bits & (1u << i)) && (properties.memoryTypes[i].propertyFlags & flags) == flags) {
+			return (int)i;
+		}
+	}
+	return -1;
+}
+
+static VkResult vk_allocate(VkMemoryRequirements requirements, VkMemoryPropertyFlags flags, VkDeviceMemory *memory) {
+	int type = vk_memory_type(requirements.memoryTypeBits, flags);
+	if (type < 0) {
+		return VK_ERROR_OUT_OF_DEVICE_MEMORY;
+	}
+	VkMemoryAllocateInfo info = {
+		.sType = VK_STRUCTURE_TYPE_MEMORY_ALLOCATE_INFO,
+		.allocationSize = requirements.size,
+		.memoryTypeIndex = (uint32_t)type,
+	};
+	return vkAllocateMemory(vk_device, &info, NULL, memory);
+}
+
+static void vk_texture_free(vk_texture *t) {
+	vkDestroyImage(vk_device, t->image, NULL);
+	vkFreeMemory(vk_device, t->memory, NULL);
+	memset(t, 0, sizeof(*t));
+}
+
+// vk_texture_size makes a texture width by height pixels, reusing it if
+// the size matches
+static VkResult vk_texture_size(vk_texture *t, VkFormat format, int width, int height) {
+	if (t->image != VK_NULL_HANDLE && t->width == width && t->height == height) {
+		return VK_SUCCESS;
+	}
+	vk_texture_free(t);
+	VkImageCreateInfo info = {
+		.sType = VK_STRUCTURE_TYPE_IMAGE_CREATE_INFO,
+		.imageType = VK_IMAGE_TYPE_2D,
+		.format = format,
+		.extent = {(uint32_t)width, (uint32_t)height, 1},
+		.mipLevels = 1,
+		.arrayLayers = 1,
+		.samples = VK_SAMPLE_COUNT_1_BIT,
+		.tiling = VK_IMAGE_TILING_OPTIMAL,
+		.usage = VK_IMAGE_USAGE_TRANSFER_SRC_BIT | VK_IMAGE_USAGE_TRANSFER_DST_BIT,
+		.sharingMode = VK_SHARING_MODE_EXCLUSIVE,
+		.initialLayout = VK_IMAGE_LAYOUT_UNDEFINED,
+	};
+	VkResult result = vkCreateImage(vk_device, &info, NULL, &t->image);
+	if (result != VK_SUCCESS) {
+		return result;
+	}
+	VkMemoryRequirements requirements;
+	vkGetImageMemoryRequirements(vk_device, t->image, &requirements);
+	result = vk_allocate(requirements, VK_MEMORY_PROPERTY_DEVICE_LOCAL_BIT, &t->memory);
+	if (result == VK_SUCCESS) {
+		result = vkBindImageMemory(vk_device, t->image, t->memory, 0);
+	}
+	if (result != VK_SUCCESS) {
+		vk_texture_free(t);
+		return result;
+	}
+	t->width = width;
+	t->height = height;
+	return VK_SUCCESS;
+}
+
+static void vk_staging_free(vk_presenter *p) {
+	if (p->staging_map != NULL) {
+		vkUnmapMemory(vk_device, p->staging_memory);
+	}
+	vkDestroyBuffer(vk_device, p->staging, NULL);
+	vkFreeMemory(vk_device, p->staging_memory, NULL);
+	p->staging = VK_NULL_HANDLE;
+	p->staging_memory = VK_NULL_HANDLE;
+	p->staging_map = NULL;
+	p->staging_size = 0;
+}
+
+// vk_staging_size grows the mapped staging buffer to at least size bytes
+static VkResult vk_staging_size(vk_presenter *p, VkDeviceSize size) {
+	if (p->staging_size >= size) {
+		return VK_SUCCESS;
+	}
+	vk_staging_free(p);
+	VkBufferCreateInfo info = {
+		.sType = VK_STRUCTURE_TYPE_BUFFER_CREATE_INFO,
+		.size = size,
+		.usage = VK_BUFFER_USAGE_TRANSFER_SRC_BIT,
+		.sharingMode = VK_SHARING_MODE_EXCLUSIVE,
+	};
+	VkResult result = vkCreateBuffer(vk_device, &info, NULL, &p->staging);
+	if (result != VK_SUCCESS) {
+		return result;
+	}
+	VkMemoryRequirements requirements;
+	vkGetBufferMemoryRequirements(vk_device, p->staging, &requirements);
+	result = vk_allocate(requirements,
+		VK_MEMORY_PROPERTY_HOST_VISIBLE_BIT | VK_MEMORY_PROPERTY_HOST_COHERENT_BIT, &p->staging_memory);
+	if (result == VK_SUCCESS) {
+		result = vkBindBufferMemory(vk_device, p->staging, p->staging_memory, 0);
+	}
+	if (result == VK_SUCCESS) {
+		result = vkMapMemory(vk_device, p->staging_memory, 0, size, 0, (void **)&p->staging_map);
+	}
+	if (result != VK_SUCCESS) {
+		vk_staging_free(p);
+		return result;
+	}
+	p->staging_size = size;
+	return VK_SUCCESS;
+}
+
+// vk_create_swapchain (re)creates the swapchain at the window's size. A
+// minimized window keeps the swapchain it has, marked stale.
+static VkResult vk_create_swapchain(vk_presenter *p) {
+	VkSurfaceCapabilitiesKHR caps;
+	VkResult result = vkGetPhysicalDeviceSurfaceCapabilitiesKHR(vk_gpu, p->surface, &caps);
+	if (result != VK_SUCCESS) {
+		return result;
+	}
+	VkExtent2D extent = caps.currentExtent;
+	if (extent.width == UINT32_MAX) {
+		int width, height;
+		glfwGetFramebufferSize(p->window, &width, &height);
+		extent.width = (uint32_t)width;
+		extent.height = (uint32_t)height;
+		if (extent.width < caps.minImageExtent.width) extent.width = caps.minImageExtent.width;
+		if (extent.width > caps.maxImageExtent.width) extent.width = caps.maxImageExtent.width;
+		if (extent.height < caps.minImageExtent.height) extent.height = caps.minImageExtent.height;
+		if (extent.height > caps.maxImageExtent.height) extent.height = caps.maxImageExtent.height;
+	}
+	if (extent.width == 0 || extent.height == 0) {
+		p->stale = 1;
+		return VK_SUCCESS;
+	}
+
+	uint32_t min_images = caps.minImageCount + 1;
+	if (caps.maxImageCount != 0 && min_images > caps.maxImageCount) {
+		min_images = caps.maxImageCount;
+	}
+	VkSwapchainKHR old = p->swapchain;
+	VkSwapchainCreateInfoKHR info = {
+		.sType = VK_STRUCTURE_TYPE_SWAPCHAIN_CREATE_INFO_KHR,
+		.surface = p->surface,
+		.minImageCount = min_images,
+		.imageFormat = p->format.format,
+		.imageColorSpace = p->format.colorSpace,
+		.imageExtent = extent,
+		.imageArrayLayers = 1,
+		.imageUsage = VK_IMAGE_USAGE_TRANSFER_DST_BIT,
+		.imageSharingMode = VK_SHARING_MODE_EXCLUSIVE,
+		.preTransform = caps.currentTransform,
+		// The lowest bit is opaque where it's supported
+		.compositeAlpha = (VkCompositeAlphaFlagBitsKHR)(caps.supportedCompositeAlpha & (~caps.supportedCompositeAlpha + 1)),
+		.presentMode = p->mode,
+		.clipped = VK_TRUE,
+		.oldSwapchain = old,
+	};
+	VkSwapchainKHR swapchain;
+	vkQueueWaitIdle(vk_queue);
+	result = vkCreateSwapchainKHR(vk_device, &info, NULL, &swapchain);
+	vkDestroySwapchainKHR(vk_device, old, NULL);
+	p->swapchain = VK_NULL_HANDLE;
+	if (result != VK_SUCCESS) {
+		return result;
+	}
+	p->swapchain = swapchain;
+
+	uint32_t count = 0;
+	vkGetSwapchainImagesKHR(vk_device, swapchain, &count, NULL);
+	if (count > VK_MAX_IMAGES) {
+		return VK_ERROR_INITIALIZATION_FAILED;
+	}
+	result = vkGetSwapchainImagesKHR(vk_device, swapchain, &count, p->images);
+	if (result != VK_SUCCESS) {
+		return result;
+	}
+	p->image_count = count;
+	p->extent = extent;
+	p->stale = 0;
+	return VK_SUCCESS;
+}
+
+// vk_setup picks the swapchain's format and the first of the present
+// modes asked for that's supported, FIFO if none is, and creates the
+// window's synchronization and swapchain
+static int vk_setup(vk_presenter *p, const VkPresentModeKHR *wanted, int wanted_count, char *err, size_t err_len) {
+	VkSurfaceFormatKHR formats[64];
+	uint32_t count = 64;
+	vkGetPhysicalDeviceSurfaceFormatsKHR(vk_gpu, p->surface, &count, formats);
+	if (count == 0) {
+		snprintf(err, err_len, "the window has no surface formats");
+		return -1;
+	}
+	p->format = formats[0];
+	for (uint32_t i = 0; i < count; i++) {
+		if (formats[i].format == VK_FORMAT_B8G8R8A8_UNORM || formats[i].format == VK_FORMAT_R8G8B8A8_UNORM) {
+			p->format = formats[i];
+			break;
+		}
+	}
+	if (p->format.format == VK_FORMAT_UNDEFINED) {
+		p->format.format = VK_FORMAT_B8G8R8A8_UNORM;
+	}
+	// Blits between sRGB formats leave the pixels as they are
+	p->source_format = VK_FORMAT_R8G8B8A8_UNORM;
+	if (p->format.format == VK_FORMAT_B8G8R8A8_SRGB || p->format.format == VK_FORMAT_R8G8B8A8_SRGB) {
+		p->source_format = VK_FORMAT_R8G8B8A8_SRGB;
+	}
+
+	VkFormatProperties target, source;
+	vkGetPhysicalDeviceFormatProperties(vk_gpu, p->format.format, &target);
+	vkGetPhysicalDeviceFormatProperties(vk_gpu, p->source_format, &source);
+	VkFormatFeatureFlags needed = VK_FORMAT_FEATURE_BLIT_SRC_BIT | VK_FORMAT_FEATURE_SAMPLED_IMAGE_FILTER_LINEAR_BIT;
+	if (!(target.optimalTilingFeatures & VK_FORMAT_FEATURE_BLIT_DST_BIT) ||
+			(source.optimalTilingFeatures & needed) != needed) {
+		snprintf(err, err_len, "the GPU can't scale frames to the window's format %d", (int)p->format.format);
+		return -1;
+	}
+	VkSurfaceCapabilitiesKHR caps;
+	vkGetPhysicalDeviceSurfaceCapabilitiesKHR(vk_gpu, p->surface, &caps);
+	if (!(caps.supportedUsageFlags & VK_IMAGE_USAGE_TRANSFER_DST_BIT)) {
+		snprintf(err, err_len, "the window's images can't be copied to");
+		return -1;
+	}
+
+	VkPresentModeKHR modes[16];
+	count = 16;
+	vkGetPhysicalDeviceSurfacePresentModesKHR(vk_gpu, p->surface, &count, modes);
+	p->mode = VK_PRESENT_MODE_FIFO_KHR;
+	int found = 0;
+	for (int w = 0; w < wanted_count && !found; w++) {
+		for (uint32_t i = 0; i < count; i++) {
+			if (modes[i] == wanted[w]) {
+				p->mode = wanted[w];
+				found = 1;
+			}
+		}
+	}
+
+	VkSemaphoreCreateInfo semaphore = {.sType = VK_STRUCTURE_TYPE_SEMAPHORE_CREATE_INFO};
+	VkFenceCreateInfo fence = {
+		.sType = VK_STRUCTURE_TYPE_FENCE_CREATE_INFO,
+		.flags = VK_FENCE_CREATE_SIGNALED_BIT,
+	};
+	VkCommandBufferAllocateInfo commands = {
+		.sType = VK_STRUCTURE_TYPE_COMMAND_BUFFER_ALLOCATE_INFO,
+		.commandPool = vk_pool,
+		.level = VK_COMMAND_BUFFER_LEVEL_PRIMARY,
+		.commandBufferCount = 1,
+	};
+	VkResult result = vkCreateSemaphore(vk_device, &semaphore, NULL, &p->acquired);
+	for (int i = 0; i < VK_MAX_IMAGES && result == VK_SUCCESS; i++) {
+		result = vkCreateSemaphore(vk_device, &semaphore, NULL, &p->rendered[i]);
+	}
+	if (result == VK_SUCCESS) {
+		result = vkCreateFence(vk_device, &fence, NULL, &p->done);
+	}
+	if (result == VK_SUCCESS) {
+		result = vkAllocateCommandBuffers(vk_device, &commands, &p->commands);
+	}
+	if (result == VK_SUCCESS) {
+		result = vk_texture_size(&p->mark, p->source_format, 1, 1);
+	}
+	if (result != VK_SUCCESS) {
+		return vk_fail(err, err_len, "creating the window's resources", result);
+	}
+	result = vk_create_swapchain(p);
+	if (result != VK_SUCCESS) {
+		return vk_fail(err, err_len, "vkCreateSwapchainKHR", result);
+	}
+	return 0;
+}
+
+static void vk_close(void *presenter) {
+	vk_presenter *p = presenter;
+	if (vk_device != VK_NULL_HANDLE) {
+		vkQueueWaitIdle(vk_queue);
+		if (p->commands != VK_NULL_HANDLE) {
+			vkFreeCommandBuffers(vk_device, vk_pool, 1, &p->commands);
+		}
+		vkDestroyFence(vk_device, p->done, NULL);
+		vkDestroySemaphore(vk_device, p->acquired, NULL);
+		for (int i = 0; i < VK_MAX_IMAGES; i++) {
+			vkDestroySemaphore(vk_device, p->rendered[i], NULL);
+		}
+		vk_staging_free(p);
+		vk_texture_free(&p->frame);
+		vk_texture_free(&p->mark);
+		vkDestroySwapchainKHR(vk_device, p->swapchain, NULL);
+	}
+	vkDestroySurfaceKHR(vk_instance, p->surface, NULL);
+	free(p);
+}
+
+// vk_open creates a window's surface and swapchain presenting with the
+// first supported of modes, it returns the presenter or NULL and copies
+// the error message to err
+static void *vk_open(void *window, const VkPresentModeKHR *modes, int mode_count, char *err, size_t err_len) {
+	if (vk_init_instance(err, err_len) != 0) {
+		return NULL;
+	}
+	vk_presenter *p = calloc(1, sizeof(vk_presenter));
+	if (p == NULL) {
+		snprintf(err, err_len, "out of memory");
+		return NULL;
+	}
+	p->window = window;
+	VkResult result = glfwCreateWindowSurface(vk_instance, p->window, NULL, &p->surface);
+	if (result != VK_SUCCESS) {
+		vk_fail(err, err_len, "glfwCreateWindowSurface", result);
+		free(p);
+		return NULL;
+	}
+	if (vk_init_device(p->surface, err, err_len) != 0 || vk_setup(p, modes, mode_count, err, err_len) != 0) {
+		vk_close(p);
+		return NULL;
+	}
+	return p;
+}
+
+static void vk_barrier(VkCommandBuffer commands, VkImage image, VkImageLayout from, VkImageLayout to,
+		VkAccessFlags src_access, VkAccessFlags dst_access, VkPipelineStageFlags dst_stage) {
+	VkImageMemoryBarrier barrier = {
+		.sType = VK_STRUCTURE_TYPE_IMAGE_MEMORY_BARRIER,
+		.srcAccessMask = src_access,
+		.dstAccessMask = dst_access,
+		.oldLayout = from,
+		.newLayout = to,
+		.srcQueueFamilyIndex = VK_QUEUE_FAMILY_IGNORED,
+		.dstQueueFamilyIndex = VK_QUEUE_FAMILY_IGNORED,
+		.image = image,
+		.subresourceRange = {VK_IMAGE_ASPECT_COLOR_BIT, 0, 1, 0, 1},
+	};
+	vkCmdPipelineBarrier(commands, VK_PIPELINE_STAGE_TRANSFER_BIT, dst_stage, 0, 0, NULL, 0, NULL, 1, &barrier);
+}
+
+// vk_blit scales the part from of src to the part to of dst, both given as
+// x0, y0, x1, y1 in pixels
+static void vk_blit(VkCommandBuffer commands, vk_texture *src, const int32_t *from, VkImage dst, const int32_t *to) {
+	if (from[2] <= from[0] || from[3] <= from[1] || to[2] <= to[0] || to[3] <= to[1]) {
+		return;
+	}
+	VkImageBlit blit = {
+		.srcSubresource = {VK_IMAGE_ASPECT_COLOR_BIT, 0, 0, 1},
+		.srcOffsets = {{from[0], from[1], 0}, {from[2], from[3], 1}},
+		.dstSubresource = {VK_IMAGE_ASPECT_COLOR_BIT, 0, 0, 1},
+		.dstOffsets = {{to[0], to[1], 0}, {to[2], to[3], 1}},
+	};
+	vkCmdBlitImage(commands, src->image, VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL,
+		dst, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, 1, &blit, VK_FILTER_LINEAR);
+}
+
+// vk_turned returns the offset in the staging buffer of the pixel at x, y
+// of a frame of width by height pixels turned by rotation degrees clockwise
+static size_t vk_turned(int rotation, int width, int height, int x, int y) {
+	switch (rotation) {
+	case 90:
+		return ((size_t)x * height + (height - 1 - y)) * 4;
+	case 180:
+		return ((size_t)(height - 1 - y) * width + (width - 1 - x)) * 4;
+	case 270:
+		return ((size_t)(width - 1 - x) * height + y) * 4;
+	}
+	return ((size_t)y * width + x) * 4;
+}
+
+// vk_stage copies a frame's rows to the staging buffer, turned by rotation
+// degrees clockwise and with each channel looked up in table if set, and
+// draws the cursor over them scaled to the rect of cursor_w by cursor_h
+// frame pixels at x, y, clipped to the frame
+static void vk_stage(vk_presenter *p, const uint8_t *pix, int width, int height, int stride, int rotation,
+		const uint8_t *table, const uint8_t *cursor, int cursor_width, int cursor_height, int x, int y, int cursor_w, int cursor_h) {
+	uint8_t *dst = p->staging_map;
+	for (int row = 0; row < height; row++) {
+		const uint8_t *src = pix + (size_t)row * stride;
+		if (rotation == 0) {
+			memcpy(dst + (size_t)row * width * 4, src, (size_t)width * 4);
+			continue;
+		}
+		for (int col = 0; col < width; col++) {
+			memcpy(dst + vk_turned(rotation, width, height, col, row), src + (size_t)col * 4, 4);
+		}
+	}
+	if (table != NULL) {
+		for (size_t i = 0; i < (size_t)width * height * 4; i += 4) {
+			dst[i] = table[dst[i]];
+			dst[i + 1] = table[dst[i + 1]];
+			dst[i + 2] = table[dst[i + 2]];
+		}
+	}
+	if (cursor == NULL) {
+		return;
+	}
+	for (int row = 0; row < cursor_h; row++) {
+		int fy = y + row;
+		if (fy < 0 || fy >= height) {
+			continue;
+		}
+		int sy = row * cursor_height / cursor_h;
+		for (int col = 0; col < cursor_w; col++) {
+			int fx = x + col;
+			if (fx < 0 || fx >= width) {
+				continue;
+			}
+			int sx = col * cursor_width / cursor_w;
+			const uint8_t *s = cursor + ((size_t)sy * cursor_width + sx) * 4;
+			uint8_t *d = dst + vk_turned(rotation, width, height, fx, fy);
+			unsigned a = s[3];
+			for (int c = 0; c < 3; c++) {
+				d[c] = (uint8_t)((s[c] * a + d[c] * (255 - a) + 127) / 255);
+			}
+		}
+	}
+}
+
+// vk_present draws the part src of a frame turned by rotation degrees
+// clockwise over the part dst of the window, both given as x0, y0, x1, y1
+// from 0 to 1, with its channels looked up in adjust_table if set and the
+// cursor scaled
+// to cursor_rect, x, y, width and height in frame pixels, if cursor_pix is
+// set and a border of border pixels in mark_color around dst if its alpha
+// isn't 0. Without frame_pix only the background is drawn. Frames are
+// skipped while the window is minimized or its swapchain is being
+// replaced.
+static VkResult vk_present(void *presenter,
+		const uint8_t *frame_pix, int width, int height, int stride, int rotation, const float *dst, const float *src,
+		const uint8_t *adjust_table, const uint8_t *cursor_pix, int cursor_width, int cursor_height, const int *cursor_rect,
+		const float *mark_color, int border) {
+	vk_presenter *p = presenter;
+	if (p->failed != VK_SUCCESS) {
+		return p->failed;
+	}
+
+	// The staging buffer and frame texture are rewritten, wait for the
+	// GPU to be done with them
+	VkResult result = vkWaitForFences(vk_device, 1, &p->done, VK_TRUE, UINT64_MAX);
+	if (result != VK_SUCCESS) {
+		return result;
+	}
+	// The frame texture holds the turned frame
+	int turned_width = width, turned_height = height;
+	if (rotation == 90 || rotation == 270) {
+		turned_width = height;
+		turned_height = width;
+	}
+	if (frame_pix != NULL) {
+		result = vk_staging_size(p, (VkDeviceSize)width * height * 4);
+		if (result == VK_SUCCESS) {
+			result = vk_texture_size(&p->frame, p->source_format, turned_width, turned_height);
+		}
+		if (result != VK_SUCCESS) {
+			return result;
+		}
+		vk_stage(p, frame_pix, width, height, stride, rotation, adjust_table, cursor_pix, cursor_width, cursor_height,
+			cursor_rect[0], cursor_rect[1], cursor_rect[2], cursor_rect[3]);
+	}
+
+	int fb_width, fb_height;
+	glfwGetFramebufferSize(p->window, &fb_width, &fb_height);
+	if (fb_width == 0 || fb_height == 0) {
+		return VK_SUCCESS;
+	}
+	if (p->stale || p->swapchain == VK_NULL_HANDLE ||
+			(uint32_t)fb_width != p->extent.width || (uint32_t)fb_height != p->extent.height) {
+		result = vk_create_swapchain(p);
+		if (result != VK_SUCCESS || p->swapchain == VK_NULL_HANDLE || p->stale) {
+			return result;
+		}
+	}
+
+	uint32_t index;
+	result = vkAcquireNextImageKHR(vk_device, p->swapchain, UINT64_MAX, p->acquired, VK_NULL_HANDLE, &index);
+	if (result == VK_ERROR_OUT_OF_DATE_KHR) {
+		p->stale = 1;
+		return VK_SUCCESS;
+	}
+	if (result == VK_SUBOPTIMAL_KHR) {
+		p->stale = 1;
+	} else if (result != VK_SUCCESS) {
+		return result;
+	}
+
+	VkCommandBuffer commands = p->commands;
+	VkImage target = p->images[index];
+	int32_t w = (int32_t)p->extent.width, h = (int32_t)p->extent.height;
+	VkImageSubresourceRange range = {VK_IMAGE_ASPECT_COLOR_BIT, 0, 1, 0, 1};
+	VkCommandBufferBeginInfo begin = {
+		.sType = VK_STRUCTURE_TYPE_COMMAND_BUFFER_BEGIN_INFO,
+		.flags = VK_COMMAND_BUFFER_USAGE_ONE_TIME_SUBMIT_BIT,
+	};
+	vkResetCommandBuffer(commands, 0);
+	vkBeginCommandBuffer(commands, &begin);
+	vk_barrier(commands, target, VK_IMAGE_LAYOUT_UNDEFINED, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+		0, VK_ACCESS_TRANSFER_WRITE_BIT, VK_PIPELINE_STAGE_TRANSFER_BIT);
+	// The background shows around frames that don't cover the window
+	VkClearColorValue background = {.float32 = {0.0f, 0.0f, 0.2f, 1.0f}};
+	vkCmdClearColorImage(commands, target, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, &background, 1, &range);
+	vk_barrier(commands, target, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+		VK_ACCESS_TRANSFER_WRITE_BIT, VK_ACCESS_TRANSFER_WRITE_BIT, VK_PIPELINE_STAGE_TRANSFER_BIT);
+	int32_t to[4] = {
+		(int32_t)(dst[0] * w + 0.5f), (int32_t)(dst[1] * h + 0.5f),
+		(int32_t)(dst[2] * w + 0.5f), (int32_t)(dst[3] * h + 0.5f),
+	};
+	if (frame_pix != NULL) {
+		VkBufferImageCopy copy = {
+			.imageSubresource = {VK_IMAGE_ASPECT_COLOR_BIT, 0, 0, 1},
+			.imageExtent = {(uint32_t)turned_width, (uint32_t)turned_height, 1},
+		};
+		vk_barrier(commands, p->frame.image, VK_IMAGE_LAYOUT_UNDEFINED, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+			0, VK_ACCESS_TRANSFER_WRITE_BIT, VK_PIPELINE_STAGE_TRANSFER_BIT);
+		vkCmdCopyBufferToImage(commands, p->staging, p->frame.image, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, 1, &copy);
+		vk_barrier(commands, p->frame.image, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL,
+			VK_ACCESS_TRANSFER_WRITE_BIT, VK_ACCESS_TRANSFER_READ_BIT, VK_PIPELINE_STAGE_TRANSFER_BIT);
+		int32_t from[4] = {
+			(int32_t)(src[0] * turned_width + 0.5f), (int32_t)(src[1] * turned_height + 0.5f),
+			(int32_t)(src[2] * turned_width + 0.5f), (int32_t)(src[3] * turned_height + 0.5f),
+		};
+		vk_blit(commands, &p->frame, from, target, to);
+	}
+	if (frame_pix != NULL && mark_color[3] > 0) {
+		VkClearColorValue color = {.float32 = {mark_color[0], mark_color[1], mark_color[2], mark_color[3]}};
+		vk_barrier(commands, p->mark.image, VK_IMAGE_LAYOUT_UNDEFINED, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+			0, VK_ACCESS_TRANSFER_WRITE_BIT, VK_PIPELINE_STAGE_TRANSFER_BIT);
+		vkCmdClearColorImage(commands, p->mark.image, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, &color, 1, &range);
+		vk_barrier(commands, p->mark.image, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL,
+			VK_ACCESS_TRANSFER_WRITE_BIT, VK_ACCESS_TRANSFER_READ_BIT, VK_PIPELINE_STAGE_TRANSFER_BIT);
+		// The border goes over the frame's blit
+		vk_barrier(commands, target, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+			VK_ACCESS_TRANSFER_WRITE_BIT, VK_ACCESS_TRANSFER_WRITE_BIT, VK_PIPELINE_STAGE_TRANSFER_BIT);
+		int32_t b = border;
+		if (b > (to[2] - to[0]) / 2) b = (to[2] - to[0]) / 2;
+		if (b > (to[3] - to[1]) / 2) b = (to[3] - to[1]) / 2;
+		int32_t rects[4][4] = {
+			{to[0], to[1], to[2], to[1] + b}, {to[0], to[3] - b, to[2], to[3]},
+			{to[0], to[1] + b, to[0] + b, to[3] - b}, {to[2] - b, to[1] + b, to[2], to[3] - b},
+		};
+		int32_t whole[4] = {0, 0, 1, 1};
+		for (int i = 0; i < 4; i++) {
+			vk_blit(commands, &p->mark, whole, target, rects[i]);
+		}
+	}
+	vk_barrier(commands, target, VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, VK_IMAGE_LAYOUT_PRESENT_SRC_KHR,
+		VK_ACCESS_TRANSFER_WRITE_BIT, 0, VK_PIPELINE_STAGE_BOTTOM_OF_PIPE_BIT);
+	vkEndCommandBuffer(commands);
+
+	VkPipelineStageFlags wait_stage = VK_PIPELINE_STAGE_TRANSFER_BIT;
+	VkSubmitInfo submit = {
+		.sType = VK_STRUCTURE_TYPE_SUBMIT_INFO,
+		.waitSemaphoreCount = 1,
+		.pWaitSemaphores = &p->acquired,
+		.pWaitDstStageMask = &wait_stage,
+		.commandBufferCount = 1,
+		.pCommandBuffers = &commands,
+		.signalSemaphoreCount = 1,
+		.pSignalSemaphores = &p->rendered[index],
+	};
+	vkResetFences(vk_device, 1, &p->done);
+	result = vkQueueSubmit(vk_queue, 1, &submit, p->done);
+	if (result != VK_SUCCESS) {
+		// The fence won't be signaled, waiting for it would hang
+		p->failed = result;
+		return result;
+	}
+
+	VkPresentInfoKHR present = {
+		.sType = VK_STRUCTURE_TYPE_PRESENT_INFO_KHR,
+		.waitSemaphoreCount = 1,
+		.pWaitSemaphores = &p->rendered[index],
+		.swapchainCount = 1,
+		.pSwapchains = &p->swapchain,
+		.pImageIndices = &index,
+	};
+	result = vkQueuePresentKHR(vk_queue, &present);
+	if (result == VK_ERROR_OUT_OF_DATE_KHR || result == VK_SUBOPTIMAL_KHR) {
+		p->stale = 1;
+		return VK_SUCCESS;
+	}
+	return result;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"log"
+	"math"
+	"unsafe"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// vkErrorSize is the size of the buffer Vulkan setup errors are copied to
+const vkErrorSize = 256
+
+func init() {
+	newVulkanPresenter = newSwapchainPresenter
+}
+
+// swapchainPresenter draws a window with Vulkan, blitting frames to the
+// images of the window's swapchain
+type swapchainPresenter struct {
+	ref    unsafe.Pointer
+	failed bool // Presenting failed, logged until it works again
+
+	// Without a shader frames are adjusted through a table, kept for
+	// the adjustment it was made for
+	adjust ColorAdjust
+	table  [256]uint8
+}
+
+// vkPresentModes are the present modes of each swap mode, by preference
+var vkPresentModes = map[SwapMode][]C.VkPresentModeKHR{
+	SwapVSync:      {C.VK_PRESENT_MODE_FIFO_KHR},
+	SwapLowLatency: {C.VK_PRESENT_MODE_MAILBOX_KHR, C.VK_PRESENT_MODE_FIFO_KHR},
+	SwapOff:        {C.VK_PRESENT_MODE_IMMEDIATE_KHR, C.VK_PRESENT_MODE_MAILBOX_KHR, C.VK_PRESENT_MODE_FIFO_KHR},
+}
+
+// newSwapchainPresenter sets up Vulkan drawing for a window created
+// without a client API
+func newSwapchainPresenter(window *glfw.Window, swap SwapMode) (presenter, error) {
+	modes := vkPresentModes[swap]
+	var message [vkErrorSize]C.char
+	ref := C.vk_open(window.Handle(), &modes[0], C.int(len(modes)), &message[0], vkErrorSize)
+	if ref == nil {
+		return nil, errors.New(C.GoString(&message[0]))
+	}
+	return &swapchainPresenter{ref: ref}, nil
+}
+
+func (p *swapchainPresenter) present(frame *image.RGBA, layout frameLayout, adjust ColorAdjust, cursor *cursorSprite, mark int) {
+	size := frame.Rect.Size()
+	if size.X <= 0 || size.Y <= 0 {
+		p.clear()
+		return
+	}
+	pix := (*C.uint8_t)(unsafe.Pointer(&frame.Pix[frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y)]))
+
+	var cursorPix *C.uint8_t
+	var cursorWidth, cursorHeight C.int
+	var cursorRect [4]C.int // x, y, width and height in frame pixels
+	if cursor != nil {
+		cursorPix = (*C.uint8_t)(unsafe.Pointer(&cursor.shape.Pixels[0]))
+		cursorWidth, cursorHeight = C.int(cursor.shape.Width), C.int(cursor.shape.Height)
+		cursorRect = [4]C.int{
+			C.int(math.Round(float64(cursor.x))), C.int(math.Round(float64(cursor.y))),
+			C.int(math.Round(float64(cursor.width))), C.int(math.Round(float64(cursor.height))),
+		}
+	}
+	var markColor [4]C.float
+	if color, ok := frameMarkColor(mark); ok {
+		markColor = [4]C.float{C.float(color[0]), C.float(color[1]), C.float(color[2]), C.float(color[3])}
+	}
+	var table *C.uint8_t
+	if !adjust.identity() {
+		if adjust != p.adjust {
+			p.adjust, p.table = adjust, adjust.table()
+		}
+		table = (*C.uint8_t)(&p.table[0])
+	}
+
+	p.report(C.vk_present(p.ref, pix, C.int(size.X), C.int(size.Y), C.int(frame.Stride), C.int(layout.rotation),
+		(*C.float)(&layout.dst[0]), (*C.float)(&layout.src[0]),
+		table, cursorPix, cursorWidth, cursorHeight, &cursorRect[0],
+		&markColor[0], frameMarkBorder))
+}
+
+func (p *swapchainPresenter) clear() {
+	var color [4]C.float
+	rect := [4]C.float{0, 0, 1, 1}
+	p.report(C.vk_present(p.ref, nil, 0, 0, 0, 0, &rect[0], &rect[0], nil, nil, 0, 0, nil, &color[0], 0))
+}
+
+func (p *swapchainPresenter) close() {
+	C.vk_close(p.ref)
+	p.ref = nil
+}
+
+// report logs the first of consecutive presentation failures
+func (p *swapchainPresenter) report(result C.VkResult) {
+	if result != C.VK_SUCCESS && !p.failed {
+		log.Printf("Vulkan presentation failed: VkResult %d", result)
+	}
+	p.failed = result != C.VK_SUCCESS
+}
@@ -0,0 +1,569 @@
+//go:build windows && cgo
+
+package client
+
+/*
+#cgo LDFLAGS: -ld3d11 -ldxgi -ld3dcompiler_47 -luuid
+#define COBJMACROS
+#include <initguid.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <windows.h>
+#include <d3d11.h>
+#include <d3dcompiler.h>
+#include <dxgi1_5.h>
+
+// Every quad is drawn as a triangle strip stretched over rect, given as
+// x0, y0, x1, y1 in window coordinates from 0 to 1 with the origin at the
+// top left, either textured with the texture coordinates uv at its top
+// left, top right, bottom left and bottom right corners and its channels
+// adjusted by adjust, or filled with color, like with Metal
+static const char d3d_shaders[] =
+	"cbuffer Quad : register(b0) { float4 rect; float4 color; float4 uv[2]; float4 adjust; };\n"
+	"struct Vertex { float4 position : SV_Position; float2 uv : TEXCOORD0; };\n"
+	"Texture2D tex : register(t0);\n"
+	"SamplerState linear_sampler : register(s0);\n"
+	"Vertex quad_vertex(uint id : SV_VertexID) {\n"
+	"	float2 corner = float2(id & 1, id >> 1);\n"
+	"	float2 p = lerp(rect.xy, rect.zw, corner);\n"
+	"	Vertex v;\n"
+	"	v.position = float4(p.x * 2.0 - 1.0, 1.0 - p.y * 2.0, 0.0, 1.0);\n"
+	"	float4 pair = uv[id >> 1];\n"
+	"	v.uv = (id & 1) ? pair.zw : pair.xy;\n"
+	"	return v;\n"
+	"}\n"
+	"float4 quad_texture(Vertex v) : SV_Target {\n"
+	"	float4 c = tex.Sample(linear_sampler, v.uv);\n"
+	"	float3 rgb = saturate((c.rgb - 0.5) * adjust.y + 0.5 + adjust.x);\n"
+	"	return float4(pow(rgb, float3(adjust.z, adjust.z, adjust.z)), c.a);\n"
+	"}\n"
+	"float4 quad_color(Vertex v) : SV_Target { return color; }\n";
+
+typedef struct {
+	float rect[4];
+	float color[4];
+	float uv[8];
+	float adjust[4];
+} d3d_quad;
+
+// The device and pipeline state are shared by the windows
+static ID3D11Device *d3d_device;
+static ID3D11DeviceContext *d3d_context;
+static IDXGIFactory2 *d3d_factory;
+static char d3d_error[512]; // Why the shared state can't be set up, if it can't
+static ID3D11VertexShader *d3d_vertex;
+static ID3D11PixelShader *d3d_texture_shader;
+static ID3D11PixelShader *d3d_color_shader;
+static ID3D11Buffer *d3d_constants;
+static ID3D11SamplerState *d3d_sampler;
+static ID3D11BlendState *d3d_blend;
+static ID3D11RasterizerState *d3d_rasterizer;
+
+// d3d_presenter draws a window's frames into its flip model swapchain,
+// waiting on the swapchain's latency object so few frames are queued
+typedef struct {
+	HWND hwnd;
+	IDXGISwapChain2 *swapchain;
+	HANDLE waitable;
+	UINT flags; // Of the swapchain, needed again to resize it
+	UINT interval, present_flags; // Passed to Present
+	ID3D11RenderTargetView *target;
+	UINT width, height;
+	ID3D11Texture2D *frame;
+	ID3D11ShaderResourceView *frame_view;
+	int frame_width, frame_height;
+} d3d_presenter;
+
+static int d3d_fail(char *err, size_t err_len, const char *what, HRESULT hr) {
+	snprintf(err, err_len, "%s failed: 0x%08lx", what, (unsigned long)hr);
+	return -1;
+}
+
+static HRESULT d3d_compile(const char *entry, const char *target, ID3DBlob **code, char *err, size_t err_len) {
+	ID3DBlob *errors = NULL;
+	HRESULT hr = D3DCompile(d3d_shaders, sizeof(d3d_shaders) - 1, "ultrardp", NULL, NULL,
+		entry, target, D3DCOMPILE_OPTIMIZATION_LEVEL3, 0, code, &errors);
+	if (FAILED(hr) && errors != NULL) {
+		snprintf(err, err_len, "%s: %s", entry, (const char *)ID3D10Blob_GetBufferPointer(errors));
+	} else if (FAILED(hr)) {
+		d3d_fail(err, err_len, "D3DCompile", hr);
+	}
+	if (errors != NULL) {
+		ID3D10Blob_Release(errors);
+	}
+	return hr;
+}
+
+static HRESULT d3d_pixel_shader(const char *entry, ID3D11PixelShader **shader, char *err, size_t err_len) {
+	ID3DBlob *code = NULL;
+	HRESULT hr = d3d_compile(entry, "ps_4_0", &code, err, err_len);
+	if (FAILED(hr)) {
+		return hr;
+	}
+	hr = ID3D11Device_CreatePixelShader(d3d_device, ID3D10Blob_GetBufferPointer(code),
+		ID3D10Blob_GetBufferSize(code), NULL, shader);
+	ID3D10Blob_Release(code);
+	if (FAILED(hr)) {
+		d3d_fail(err, err_len, "CreatePixelShader", hr);
+	}
+	return hr;
+}
+
+// d3d_init_pipeline compiles the shaders and creates the state every quad
+// is drawn with
+static int d3d_init_pipeline(char *err, size_t err_len) {
+	ID3DBlob *code = NULL;
+	HRESULT hr = d3d_compile("quad_vertex", "vs_4_0", &code, err, err_len);
+	if (FAILED(hr)) {
+		return -1;
+	}
+	hr = ID3D11Device_CreateVertexShader(d3d_device, ID3D10Blob_GetBufferPointer(code),
+		ID3D10Blob_GetBufferSize(code), NULL, &d3d_vertex);
+	ID3D10Blob_Release(code);
+	if (FAILED(hr)) {
+		return d3d_fail(err, err_len, "CreateVertexShader", hr);
+	}
+	if (FAILED(d3d_pixel_shader("quad_texture", &d3d_texture_shader, err, err_len)) ||
+			FAILED(d3d_pixel_shader("quad_color", &d3d_color_shader, err, err_len))) {
+		return -1;
+	}
+
+	D3D11_BUFFER_DESC constants = {
+		.ByteWidth = sizeof(d3d_quad),
+		.Usage = D3D11_USAGE_DEFAULT,
+		.BindFlags = D3D11_BIND_CONSTANT_BUFFER,
+	};
+	D3D11_SAMPLER_DESC sampler = {
+		.Filter = D3D11_FILTER_MIN_MAG_MIP_LINEAR,
+		.AddressU = D3D11_TEXTURE_ADDRESS_CLAMP,
+		.AddressV = D3D11_TEXTURE_ADDRESS_CLAMP,
+		.AddressW = D3D11_TEXTURE_ADDRESS_CLAMP,
+		.ComparisonFunc = D3D11_COMPARISON_NEVER,
+		.MaxLOD = D3D11_FLOAT32_MAX,
+	};
+	D3D11_BLEND_DESC blend = {0};
+	blend.RenderTarget[0].BlendEnable = TRUE;
+	blend.RenderTarget[0].SrcBlend = D3D11_BLEND_SRC_ALPHA;
+	blend.RenderTarget[0].DestBlend = D3D11_BLEND_INV_SRC_ALPHA;
+	blend.RenderTarget[0].BlendOp = D3D11_BLEND_OP_ADD;
+	blend.RenderTarget[0].SrcBlendAlpha = D3D11_BLEND_ONE;
+	blend.RenderTarget[0].DestBlendAlpha = D3D11_BLEND_INV_SRC_ALPHA;
+	blend.RenderTarget[0].BlendOpAlpha = D3D11_BLEND_OP_ADD;
+	blend.RenderTarget[0].RenderTargetWriteMask = D3D11_COLOR_WRITE_ENABLE_ALL;
+	D3D11_RASTERIZER_DESC rasterizer = {
+		.FillMode = D3D11_FILL_SOLID,
+		.CullMode = D3D11_CULL_NONE,
+		.DepthClipEnable = TRUE,
+	};
+	if (FAILED(hr = ID3D11Device_CreateBuffer(d3d_device, &constants, NULL, &d3d_constants)) ||
+			FAILED(hr = ID3D11Device_CreateSamplerState(d3d_device, &sampler, &d3d_sampler)) ||
+			FAILED(hr = ID3D11Device_CreateBlendState(d3d_device, &blend, &d3d_blend)) ||
+			FAILED(hr = ID3D11Device_CreateRasterizerState(d3d_device, &rasterizer, &d3d_rasterizer))) {
+		return d3d_fail(err, err_len, "creating the pipeline state", hr);
+	}
+	return 0;
+}
+
+// d3d_init creates the shared device, the factory of its adapter and the
+// pipeline on first use
+static int d3d_init(char *err, size_t err_len) {
+	if (d3d_error[0] != 0) {
+		snprintf(err, err_len, "%s", d3d_error);
+		return -1;
+	}
+	if (d3d_device != NULL) {
+		return 0;
+	}
+	D3D_FEATURE_LEVEL levels[] = {D3D_FEATURE_LEVEL_11_0, D3D_FEATURE_LEVEL_10_1, D3D_FEATURE_LEVEL_10_0};
+	ID3D11Device *device = NULL;
+	ID3D11DeviceContext *context = NULL;
+	HRESULT hr = D3D11CreateDevice(NULL, D3D_DRIVER_TYPE_HARDWARE, NULL, D3D11_CREATE_DEVICE_BGRA_SUPPORT,
+		levels, sizeof(levels) / sizeof(levels[0]), D3D11_SDK_VERSION, &device, NULL, &context);
+	if (FAILED(hr)) {
+		return d3d_fail(err, err_len, "D3D11CreateDevice", hr);
+	}
+
+	IDXGIDevice *dxgi = NULL;
+	IDXGIAdapter *adapter = NULL;
+	IDXGIFactory2 *factory = NULL;
+	hr = ID3D11Device_QueryInterface(device, &IID_IDXGIDevice, (void **)&dxgi);
+	if (SUCCEEDED(hr)) {
+		hr = IDXGIDevice_GetAdapter(dxgi, &adapter);
+		IDXGIDevice_Release(dxgi);
+	}
+	if (SUCCEEDED(hr)) {
+		hr = IDXGIAdapter_GetParent(adapter, &IID_IDXGIFactory2, (void **)&factory);
+		IDXGIAdapter_Release(adapter);
+	}
+	if (FAILED(hr)) {
+		ID3D11DeviceContext_Release(context);
+		ID3D11Device_Release(device);
+		return d3d_fail(err, err_len, "getting the DXGI 1.2 factory", hr);
+	}
+	d3d_device = device;
+	d3d_context = context;
+	d3d_factory = factory;
+	if (d3d_init_pipeline(err, err_len) != 0) {
+		// Shaders that don't compile won't later either, the error is
+		// kept for the next windows
+		snprintf(d3d_error, sizeof(d3d_error), "%s", err);
+		return -1;
+	}
+	return 0;
+}
+
+static void d3d_release_target(d3d_presenter *p) {
+	if (p->target != NULL) {
+		ID3D11RenderTargetView_Release(p->target);
+		p->target = NULL;
+	}
+}
+
+// d3d_resize resizes the swapchain's buffers to the window's client area
+// if it changed, and creates the render target view of the back buffer.
+// It returns S_FALSE while the window is minimized.
+static HRESULT d3d_resize(d3d_presenter *p) {
+	RECT client;
+	GetClientRect(p->hwnd, &client);
+	UINT width = (UINT)(client.right - client.left), height = (UINT)(client.bottom - client.top);
+	if (width == 0 || height == 0) {
+		return S_FALSE;
+	}
+	HRESULT hr;
+	if (width != p->width || height != p->height) {
+		d3d_release_target(p);
+		ID3D11DeviceContext_OMSetRenderTargets(d3d_context, 0, NULL, NULL);
+		hr = IDXGISwapChain2_ResizeBuffers(p->swapchain, 0, width, height, DXGI_FORMAT_UNKNOWN, p->flags);
+		if (FAILED(hr)) {
+			return hr;
+		}
+		p->width = width;
+		p->height = height;
+	}
+	if (p->target == NULL) {
+		ID3D11Texture2D *back = NULL;
+		hr = IDXGISwapChain2_GetBuffer(p->swapchain, 0, &IID_ID3D11Texture2D, (void **)&back);
+		if (FAILED(hr)) {
+			return hr;
+		}
+		hr = ID3D11Device_CreateRenderTargetView(d3d_device, (ID3D11Resource *)back, NULL, &p->target);
+		ID3D11Texture2D_Release(back);
+		if (FAILED(hr)) {
+			return hr;
+		}
+	}
+	return S_OK;
+}
+
+static void d3d_close(void *presenter) {
+	d3d_presenter *p = presenter;
+	d3d_release_target(p);
+	if (p->frame_view != NULL) {
+		ID3D11ShaderResourceView_Release(p->frame_view);
+	}
+	if (p->frame != NULL) {
+		ID3D11Texture2D_Release(p->frame);
+	}
+	if (p->waitable != NULL) {
+		CloseHandle(p->waitable);
+	}
+	if (p->swapchain != NULL) {
+		ID3D11DeviceContext_ClearState(d3d_context);
+		ID3D11DeviceContext_Flush(d3d_context);
+		IDXGISwapChain2_Release(p->swapchain);
+	}
+	free(p);
+}
+
+// d3d_tearing reports whether frames presented without a sync interval
+// can tear, which needs Windows 10
+static BOOL d3d_tearing(void) {
+	IDXGIFactory5 *factory = NULL;
+	BOOL allowed = FALSE;
+	if (SUCCEEDED(IDXGIFactory2_QueryInterface(d3d_factory, &IID_IDXGIFactory5, (void **)&factory))) {
+		if (FAILED(IDXGIFactory5_CheckFeatureSupport(factory, DXGI_FEATURE_PRESENT_ALLOW_TEARING, &allowed, sizeof(allowed)))) {
+			allowed = FALSE;
+		}
+		IDXGIFactory5_Release(factory);
+	}
+	return allowed;
+}
+
+// d3d_open creates a flip model swapchain for a window, discarding on
+// Windows 10 and sequential before, presenting with the sync interval
+// interval and queuing at most latency frames. It returns the presenter
+// or NULL and copies the error message to err.
+static void *d3d_open(void *hwnd, UINT interval, UINT latency, char *err, size_t err_len) {
+	if (d3d_init(err, err_len) != 0) {
+		return NULL;
+	}
+	d3d_presenter *p = calloc(1, sizeof(d3d_presenter));
+	if (p == NULL) {
+		snprintf(err, err_len, "out of memory");
+		return NULL;
+	}
+	p->hwnd = (HWND)hwnd;
+	p->flags = DXGI_SWAP_CHAIN_FLAG_FRAME_LATENCY_WAITABLE_OBJECT;
+	p->interval = interval;
+	if (interval == 0 && d3d_tearing()) {
+		p->flags |= DXGI_SWAP_CHAIN_FLAG_ALLOW_TEARING;
+		p->present_flags = DXGI_PRESENT_ALLOW_TEARING;
+	}
+
+	DXGI_SWAP_CHAIN_DESC1 desc = {
+		.Format = DXGI_FORMAT_B8G8R8A8_UNORM,
+		.SampleDesc = {1, 0},
+		.BufferUsage = DXGI_USAGE_RENDER_TARGET_OUTPUT,
+		.BufferCount = 2,
+		.Scaling = DXGI_SCALING_STRETCH,
+		.SwapEffect = DXGI_SWAP_EFFECT_FLIP_DISCARD,
+		.AlphaMode = DXGI_ALPHA_MODE_UNSPECIFIED,
+		.Flags = p->flags,
+	};
+	IDXGISwapChain1 *swapchain = NULL;
+	HRESULT hr = IDXGIFactory2_CreateSwapChainForHwnd(d3d_factory, (IUnknown *)d3d_device, p->hwnd,
+		&desc, NULL, NULL, &swapchain);
+	if (FAILED(hr)) {
+		desc.SwapEffect = DXGI_SWAP_EFFECT_FLIP_SEQUENTIAL;
+		hr = IDXGIFactory2_CreateSwapChainForHwnd(d3d_factory, (IUnknown *)d3d_device, p->hwnd,
+			&desc, NULL, NULL, &swapchain);
+	}
+	if (FAILED(hr)) {
+		d3d_fail(err, err_len, "CreateSwapChainForHwnd", hr);
+		free(p);
+		return NULL;
+	}
+	hr = IDXGISwapChain1_QueryInterface(swapchain, &IID_IDXGISwapChain2, (void **)&p->swapchain);
+	IDXGISwapChain1_Release(swapchain);
+	if (FAILED(hr)) {
+		// Waitable swapchains need Windows 8.1
+		d3d_fail(err, err_len, "getting IDXGISwapChain2", hr);
+		free(p);
+		return NULL;
+	}
+	// GLFW handles full screen, DXGI mustn't on Alt+Enter
+	IDXGIFactory2_MakeWindowAssociation(d3d_factory, p->hwnd, DXGI_MWA_NO_ALT_ENTER);
+	IDXGISwapChain2_SetMaximumFrameLatency(p->swapchain, latency);
+	p->waitable = IDXGISwapChain2_GetFrameLatencyWaitableObject(p->swapchain);
+	return p;
+}
+
+// d3d_upload copies RGBA pixels to a texture, reusing it if the size
+// matches. Textures created here are released by the caller.
+static HRESULT d3d_upload(ID3D11Texture2D **texture, ID3D11ShaderResourceView **view, int *tex_width, int *tex_height,
+		const uint8_t *pix, int width, int height, int stride) {
+	if (*texture == NULL || *tex_width != width || *tex_height != height) {
+		if (*view != NULL) {
+			ID3D11ShaderResourceView_Release(*view);
+			*view = NULL;
+		}
+		if (*texture != NULL) {
+			ID3D11Texture2D_Release(*texture);
+			*texture = NULL;
+		}
+		D3D11_TEXTURE2D_DESC desc = {
+			.Width = (UINT)width,
+			.Height = (UINT)height,
+			.MipLevels = 1,
+			.ArraySize = 1,
+			.Format = DXGI_FORMAT_R8G8B8A8_UNORM,
+			.SampleDesc = {1, 0},
+			.Usage = D3D11_USAGE_DEFAULT,
+			.BindFlags = D3D11_BIND_SHADER_RESOURCE,
+		};
+		HRESULT hr = ID3D11Device_CreateTexture2D(d3d_device, &desc, NULL, texture);
+		if (FAILED(hr)) {
+			return hr;
+		}
+		hr = ID3D11Device_CreateShaderResourceView(d3d_device, (ID3D11Resource *)*texture, NULL, view);
+		if (FAILED(hr)) {
+			ID3D11Texture2D_Release(*texture);
+			*texture = NULL;
+			return hr;
+		}
+		*tex_width = width;
+		*tex_height = height;
+	}
+	ID3D11DeviceContext_UpdateSubresource(d3d_context, (ID3D11Resource *)*texture, 0, NULL, pix, (UINT)stride, 0);
+	return S_OK;
+}
+
+static void d3d_draw(ID3D11ShaderResourceView *view, d3d_quad quad) {
+	ID3D11DeviceContext_UpdateSubresource(d3d_context, (ID3D11Resource *)d3d_constants, 0, NULL, &quad, 0, 0);
+	if (view != NULL) {
+		ID3D11DeviceContext_PSSetShader(d3d_context, d3d_texture_shader, NULL, 0);
+		ID3D11DeviceContext_PSSetShaderResources(d3d_context, 0, 1, &view);
+	} else {
+		ID3D11DeviceContext_PSSetShader(d3d_context, d3d_color_shader, NULL, 0);
+	}
+	ID3D11DeviceContext_Draw(d3d_context, 4, 0);
+}
+
+// d3d_present draws a frame over the part dst of the window with the
+// texture coordinates uv at its corners and its colors adjusted by adjust,
+// then the cursor in rect
+// cursor_rect with cursor_uv if cursor_pix is set and a border of border
+// pixels in mark_color around dst if its alpha isn't 0.
+// Without frame_pix only the background is drawn. It first waits until
+// the swapchain can take a frame, so the frame drawn is the latest one.
+static HRESULT d3d_present(void *presenter,
+		const uint8_t *frame_pix, int width, int height, int stride, const float *dst, const float *uv, const float *adjust,
+		const uint8_t *cursor_pix, int cursor_width, int cursor_height, const float *cursor_rect, const float *cursor_uv,
+		const float *mark_color, float border) {
+	d3d_presenter *p = presenter;
+	if (p->waitable != NULL) {
+		WaitForSingleObjectEx(p->waitable, 1000, TRUE);
+	}
+	HRESULT hr = d3d_resize(p);
+	if (hr != S_OK) {
+		return SUCCEEDED(hr) ? S_OK : hr;
+	}
+
+	ID3D11DeviceContext *ctx = d3d_context;
+	D3D11_VIEWPORT viewport = {0, 0, (FLOAT)p->width, (FLOAT)p->height, 0, 1};
+	const FLOAT background[4] = {0.0f, 0.0f, 0.2f, 1.0f};
+	// Flip model unbinds the back buffer with every present
+	ID3D11DeviceContext_OMSetRenderTargets(ctx, 1, &p->target, NULL);
+	ID3D11DeviceContext_ClearRenderTargetView(ctx, p->target, background);
+	ID3D11DeviceContext_RSSetViewports(ctx, 1, &viewport);
+	ID3D11DeviceContext_RSSetState(ctx, d3d_rasterizer);
+	ID3D11DeviceContext_OMSetBlendState(ctx, d3d_blend, NULL, 0xFFFFFFFF);
+	ID3D11DeviceContext_IASetInputLayout(ctx, NULL);
+	ID3D11DeviceContext_IASetPrimitiveTopology(ctx, D3D11_PRIMITIVE_TOPOLOGY_TRIANGLESTRIP);
+	ID3D11DeviceContext_VSSetShader(ctx, d3d_vertex, NULL, 0);
+	ID3D11DeviceContext_VSSetConstantBuffers(ctx, 0, 1, &d3d_constants);
+	ID3D11DeviceContext_PSSetConstantBuffers(ctx, 0, 1, &d3d_constants);
+	ID3D11DeviceContext_PSSetSamplers(ctx, 0, 1, &d3d_sampler);
+
+	if (frame_pix != NULL) {
+		hr = d3d_upload(&p->frame, &p->frame_view, &p->frame_width, &p->frame_height, frame_pix, width, height, stride);
+		if (FAILED(hr)) {
+			return hr;
+		}
+		d3d_quad quad = {{dst[0], dst[1], dst[2], dst[3]}};
+		memcpy(quad.uv, uv, sizeof(quad.uv));
+		memcpy(quad.adjust, adjust, sizeof(quad.adjust));
+		d3d_draw(p->frame_view, quad);
+	}
+	if (frame_pix != NULL && cursor_pix != NULL) {
+		ID3D11Texture2D *cursor = NULL;
+		ID3D11ShaderResourceView *cursor_view = NULL;
+		int cw = 0, ch = 0;
+		if (SUCCEEDED(d3d_upload(&cursor, &cursor_view, &cw, &ch, cursor_pix, cursor_width, cursor_height, cursor_width * 4))) {
+			d3d_quad quad = {{cursor_rect[0], cursor_rect[1], cursor_rect[2], cursor_rect[3]}};
+			memcpy(quad.uv, cursor_uv, sizeof(quad.uv));
+			quad.adjust[1] = quad.adjust[2] = 1; // As it is
+			d3d_draw(cursor_view, quad);
+			ID3D11ShaderResourceView_Release(cursor_view);
+			ID3D11Texture2D_Release(cursor);
+		}
+	}
+	if (frame_pix != NULL && mark_color[3] > 0) {
+		float bx = border / p->width, by = border / p->height;
+		float rects[4][4] = {{dst[0], dst[1], dst[2], dst[1] + by}, {dst[0], dst[3] - by, dst[2], dst[3]},
+			{dst[0], dst[1], dst[0] + bx, dst[3]}, {dst[2] - bx, dst[1], dst[2], dst[3]}};
+		for (int i = 0; i < 4; i++) {
+			d3d_quad quad = {{rects[i][0], rects[i][1], rects[i][2], rects[i][3]},
+				{mark_color[0], mark_color[1], mark_color[2], mark_color[3]}};
+			d3d_draw(NULL, quad);
+		}
+	}
+	return IDXGISwapChain2_Present(p->swapchain, p->interval, p->present_flags);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"log"
+	"unsafe"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// d3dErrorSize is the size of the buffer Direct3D setup errors are copied
+// to
+const d3dErrorSize = 512
+
+func init() {
+	newD3D11Presenter = newFlipPresenter
+}
+
+// flipPresenter draws a window with Direct3D 11, through a flip model
+// swapchain of its own
+type flipPresenter struct {
+	ref    unsafe.Pointer
+	failed bool // Presenting failed, logged until it works again
+}
+
+// newFlipPresenter sets up Direct3D drawing for a window created without
+// a client API
+func newFlipPresenter(window *glfw.Window, swap SwapMode) (presenter, error) {
+	// Low latency queues a single frame, vsync the usual two
+	interval, latency := C.UINT(1), C.UINT(1)
+	switch swap {
+	case SwapVSync:
+		latency = 2
+	case SwapOff:
+		interval = 0
+	}
+	var message [d3dErrorSize]C.char
+	ref := C.d3d_open(unsafe.Pointer(window.GetWin32Window()), interval, latency, &message[0], d3dErrorSize)
+	if ref == nil {
+		return nil, errors.New(C.GoString(&message[0]))
+	}
+	return &flipPresenter{ref: ref}, nil
+}
+
+func (p *flipPresenter) present(frame *image.RGBA, layout frameLayout, adjust ColorAdjust, cursor *cursorSprite, mark int) {
+	size := frame.Rect.Size()
+	if size.X <= 0 || size.Y <= 0 {
+		p.clear()
+		return
+	}
+	pix := (*C.uint8_t)(unsafe.Pointer(&frame.Pix[frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y)]))
+
+	// Frame pixels map to the window the way the frame does, turned with it
+	var cursorPix *C.uint8_t
+	var cursorRect [4]float32
+	var cursorWidth, cursorHeight C.int
+	cursorUV := layout.rotation.corners([4]float32{0, 0, 1, 1})
+	if cursor != nil {
+		cursorPix = (*C.uint8_t)(unsafe.Pointer(&cursor.shape.Pixels[0]))
+		cursorWidth, cursorHeight = C.int(cursor.shape.Width), C.int(cursor.shape.Height)
+		cursorRect = layout.rect(cursor.x, cursor.y, cursor.width, cursor.height, size)
+	}
+	uv := layout.texCoords()
+	params := adjust.shaderParams()
+	var markColor [4]C.float
+	if color, ok := frameMarkColor(mark); ok {
+		markColor = [4]C.float{C.float(color[0]), C.float(color[1]), C.float(color[2]), C.float(color[3])}
+	}
+
+	p.report(C.d3d_present(p.ref, pix, C.int(size.X), C.int(size.Y), C.int(frame.Stride),
+		(*C.float)(&layout.dst[0]), (*C.float)(&uv[0][0]), (*C.float)(&params[0]),
+		cursorPix, cursorWidth, cursorHeight, (*C.float)(&cursorRect[0]), (*C.float)(&cursorUV[0][0]),
+		&markColor[0], frameMarkBorder))
+}
+
+func (p *flipPresenter) clear() {
+	var rect, color [4]C.float
+	var uv [8]C.float
+	p.report(C.d3d_present(p.ref, nil, 0, 0, 0, &rect[0], &uv[0], &color[0], nil, 0, 0, &rect[0], &uv[0], &color[0], 0))
+}
+
+func (p *flipPresenter) close() {
+	C.d3d_close(p.ref)
+	p.ref = nil
+}
+
+// report logs the first of consecutive presentation failures
+func (p *flipPresenter) report(hr C.HRESULT) {
+	if hr < 0 && !p.failed {
+		log.Printf("Direct3D presentation failed: 0x%08x", uint32(hr))
+	}
+	p.failed = hr < 0
+}
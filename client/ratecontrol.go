@@ -0,0 +1,40 @@
+package client
+
+import (
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithRateControl asks the server to encode video with a rate control mode
+// and a target bitrate in kbit/s, 0 for one derived from the quality. Video
+// is encoded once for all clients of a monitor, the lowest bitrate asked
+// for wins.
+func WithRateControl(mode codec.RateMode, kbps int) Option {
+	return func(c *Client) {
+		c.rateControl = codec.RateControl{Mode: mode, Bitrate: max(kbps, 0) * 1000}
+	}
+}
+
+// SetRateControl changes the rate control asked for during the session, see
+// WithRateControl
+func (c *Client) SetRateControl(mode codec.RateMode, kbps int) error {
+	c.rateControl = codec.RateControl{Mode: mode, Bitrate: max(kbps, 0) * 1000}
+	return c.sendQualityControl()
+}
+
+// sendQualityControl sends the quality, rate control and capture scales
+// asked for. Without a quality of its own the client asks for the highest,
+// which leaves the server's unchanged.
+func (c *Client) sendQualityControl() error {
+	quality := 100
+	if c.qualitySent {
+		quality = c.qualityLevel
+	}
+	payload := protocol.EncodeQualityControl(protocol.QualityControl{
+		Quality:  quality,
+		RateMode: byte(c.rateControl.Mode),
+		Bitrate:  uint32(c.rateControl.Bitrate / 1000),
+		Scales:   c.captureScaleList(),
+	})
+	return c.send(protocol.NewPacket(protocol.PacketTypeQualityControl, payload))
+}
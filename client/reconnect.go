@@ -0,0 +1,165 @@
+package client
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+const (
+	// reconnectInitialDelay is the wait before the first reconnect attempt,
+	// doubled after every failed attempt up to reconnectMaxDelay
+	reconnectInitialDelay = 500 * time.Millisecond
+	reconnectMaxDelay     = 30 * time.Second
+
+	// reconnectHandshakeTimeout bounds the handshake of a reconnect attempt,
+	// a server that accepts but never answers must not stall the client
+	reconnectHandshakeTimeout = 10 * time.Second
+)
+
+// WithReconnect keeps the session alive across network drops: when the
+// connection to the server fails, the client reconnects with exponential
+// backoff for up to timeout, authenticates again and restores the monitor
+// mapping and the requested streams. The windows stay open and show the
+// last frames meanwhile.
+func WithReconnect(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.reconnectTimeout = timeout
+	}
+}
+
+// receiveLoop handles packets from the server until the client stops or
+// the connection fails and can't be reconnected
+func (c *Client) receiveLoop() {
+	for !c.stopped {
+		// Skip if connection closed
+		if c.conn == nil {
+			return
+		}
+
+		packet, err := protocol.DecodePacket(c.conn)
+		if err != nil {
+			if c.stopped {
+				return
+			}
+			log.Printf("Error receiving packet: %v", err)
+			if c.reconnectTimeout <= 0 || !c.reconnect() {
+				return
+			}
+			continue
+		}
+		c.handlePacket(packet)
+	}
+}
+
+// reconnect replaces a failed connection, retrying until it succeeds, the
+// client stops or the reconnect timeout passes. It reports whether the
+// session was restored.
+func (c *Client) reconnect() bool {
+	c.reconnecting.Store(true)
+	defer c.reconnecting.Store(false)
+	c.endSession()
+
+	deadline := time.Now().Add(c.reconnectTimeout)
+	delay := reconnectInitialDelay
+	for attempt := 1; ; attempt++ {
+		log.Printf("Connection lost, reconnecting in %v (attempt %d)", delay, attempt)
+		select {
+		case <-c.stopChan:
+			return false
+		case <-time.After(delay):
+		}
+
+		err := c.restoreSession()
+		if err == nil {
+			log.Printf("Reconnected to %s", c.address)
+			return true
+		}
+		if c.stopped {
+			return false
+		}
+
+		// Retrying can't fix a configuration the server refuses
+		if errors.Is(err, protocol.ErrAuthRequired) || errors.Is(err, protocol.ErrEncryptionRequired) ||
+			errors.Is(err, protocol.ErrEncryptionUnsupported) {
+			log.Printf("Giving up reconnecting: %v", err)
+			return false
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Giving up reconnecting after %v: %v", c.reconnectTimeout, err)
+			return false
+		}
+		log.Printf("Reconnect failed: %v", err)
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// endSession releases everything tied to the failed connection
+func (c *Client) endSession() {
+	close(c.sessionDone)
+
+	c.sendMutex.Lock()
+	c.conn.Close()
+	c.sendMutex.Unlock()
+
+	c.inputMutex.Lock()
+	if c.inputStream != nil {
+		c.inputStream.Close()
+		c.inputStream = nil
+	}
+	c.inputMutex.Unlock()
+
+	c.streams = nil
+	c.closeParallelConnections()
+	c.closeDecoders()
+
+	// A locked server reports it again to the new connection
+	c.serverLocked.Store(false)
+
+	// The SSH connection most likely failed along with the session, the
+	// next dial opens a new one
+	c.closeSSH()
+}
+
+// restoreSession connects and authenticates again, then sets the session
+// up as Start did
+func (c *Client) restoreSession() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	// Nothing may be sent before the handshake is done, so other goroutines
+	// wait for the send lock until then
+	c.sendMutex.Lock()
+	c.useConn(conn)
+	conn.SetDeadline(time.Now().Add(reconnectHandshakeTimeout))
+	err = c.handleHandshake()
+	conn.SetDeadline(time.Time{})
+	c.sendMutex.Unlock()
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	// The server counts acknowledged video bytes per connection
+	c.videoReceived.Store(0)
+	c.sessionDone = make(chan struct{})
+	err = c.startSession()
+
+	// Quality set during the session is lost with the server's state
+	if err == nil && c.qualitySent {
+		err = c.SendQualityControl(c.qualityLevel)
+	}
+	if err != nil {
+		c.endSession()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moderniselife/ultrardp/protocol"
+	"github.com/moderniselife/ultrardp/recording"
+)
+
+// WithRecording records the session to path. A .urdp file gets every
+// packet received from the server and can later be turned into stills
+// with `ultrardp export`. An .mp4 or .mkv file gets the decoded frames, see
+// recording.CreateVideo, one file per monitor window.
+func WithRecording(path string) Option {
+	return func(c *Client) {
+		c.recordPath = path
+	}
+}
+
+// videoRecording encodes the frames of one monitor window
+type videoRecording struct {
+	video  *recording.Video // nil if the video couldn't be created
+	frames chan *image.RGBA // Latest frame not yet written
+	done   chan struct{}    // Closed once the video is written
+}
+
+// startRecording creates the recording file if recording is enabled.
+// Videos are created when their monitor's first frame arrives.
+func (c *Client) startRecording() error {
+	if c.recordPath == "" {
+		return nil
+	}
+	if recording.IsVideoPath(c.recordPath) {
+		c.videos = make(map[uint32]*videoRecording)
+		log.Printf("Recording session video to %s", c.recordPath)
+		return nil
+	}
+
+	recorder, err := recording.Create(c.recordPath)
+	if err != nil {
+		return err
+	}
+	c.recorder = recorder
+	log.Printf("Recording session to %s", c.recordPath)
+	return nil
+}
+
+// recordPacket appends a received packet to the recording
+func (c *Client) recordPacket(packet *protocol.Packet) {
+	if c.recorder == nil {
+		return
+	}
+	err := c.recorder.WritePacket(packet)
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		log.Printf("Error writing recording, recording stopped: %v", err)
+		c.recorder.Close()
+	}
+}
+
+// recordFrame hands a local monitor's new frame to its video when
+// recording video. Frames arriving while the previous one is still being
+// encoded replace it rather than holding up decoding.
+func (c *Client) recordFrame(localMonitorID uint32, frame *image.RGBA) {
+	if c.videos == nil {
+		return
+	}
+	c.videoMutex.Lock()
+	defer c.videoMutex.Unlock()
+
+	v, ok := c.videos[localMonitorID]
+	if !ok {
+		v = c.startVideo(localMonitorID, frame.Rect.Size())
+		c.videos[localMonitorID] = v
+	}
+	if v.video == nil || v.frames == nil {
+		return
+	}
+	select {
+	case <-v.frames:
+	default:
+	}
+	v.frames <- frame
+}
+
+// startVideo creates the video of a local monitor, sized to its first
+// frame
+func (c *Client) startVideo(localMonitorID uint32, size image.Point) *videoRecording {
+	path := videoPath(c.recordPath, localMonitorID)
+	video, err := recording.CreateVideo(path, size.X, size.Y)
+	if err != nil {
+		log.Printf("Failed to record monitor %d: %v", localMonitorID, err)
+		return &videoRecording{}
+	}
+	log.Printf("Recording monitor %d to %s", localMonitorID, path)
+
+	frames := make(chan *image.RGBA, 1)
+	v := &videoRecording{video: video, frames: frames, done: make(chan struct{})}
+	go func() {
+		defer close(v.done)
+		for frame := range frames {
+			if err := video.WriteFrame(frame); err != nil {
+				log.Printf("Error writing video of monitor %d, recording stopped: %v", localMonitorID, err)
+				break
+			}
+		}
+		if err := video.Close(); err != nil {
+			log.Printf("Error finishing video of monitor %d: %v", localMonitorID, err)
+		}
+	}()
+	return v
+}
+
+// stopVideos finishes every video, waiting for them to be written
+func (c *Client) stopVideos() {
+	c.videoMutex.Lock()
+	defer c.videoMutex.Unlock()
+
+	for _, v := range c.videos {
+		if v.frames != nil {
+			close(v.frames)
+			v.frames = nil
+			<-v.done
+		}
+	}
+}
+
+// videoPath returns the video file of a local monitor: the recording path
+// for the first monitor and the path with the monitor's ID before the
+// extension for the others, e.g. session-2.mp4
+func videoPath(path string, localMonitorID uint32) string {
+	if localMonitorID == 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), localMonitorID, ext)
+}
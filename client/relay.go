@@ -0,0 +1,11 @@
+package client
+
+// WithRelay connects through the relay at relayAddress to the server that
+// registered the session code, instead of dialing the server directly.
+// Only the TCP transport can be relayed and UDP video is not requested.
+func WithRelay(relayAddress, code string) Option {
+	return func(c *Client) {
+		c.relayAddress = relayAddress
+		c.relayCode = code
+	}
+}
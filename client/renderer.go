@@ -0,0 +1,51 @@
+package client
+
+import "fmt"
+
+// Renderer selects the graphics API the client draws frames with
+type Renderer string
+
+const (
+	// RendererGL draws with OpenGL 2.1, on every platform
+	RendererGL Renderer = "gl"
+
+	// RendererMetal draws with Metal on macOS, where OpenGL is deprecated
+	// and windows sharing it sometimes stay black. Other platforms fall
+	// back to OpenGL.
+	RendererMetal Renderer = "metal"
+
+	// RendererVulkan draws with Vulkan on Linux and Windows clients built
+	// with -tags vulkan, copying frames straight to each window's
+	// swapchain without waiting for vertical sync where the driver allows.
+	// Other builds fall back to OpenGL.
+	RendererVulkan Renderer = "vulkan"
+
+	// RendererD3D11 draws with Direct3D 11 on Windows, through flip model
+	// swapchains that are tear-free and hold at most one queued frame,
+	// without OpenGL drivers. Other platforms fall back to OpenGL.
+	RendererD3D11 Renderer = "d3d11"
+
+	// RendererGLES draws with OpenGL ES 2.0 or later through EGL, on ARM
+	// boards such as the Raspberry Pi whose drivers have no desktop
+	// OpenGL, and on Windows through ANGLE. Needs a Linux or Windows
+	// client built with -tags gles, other builds fall back to OpenGL.
+	RendererGLES Renderer = "gles"
+)
+
+// ParseRenderer returns the renderer with the given name
+func ParseRenderer(name string) (Renderer, error) {
+	switch r := Renderer(name); r {
+	case RendererGL, RendererMetal, RendererVulkan, RendererD3D11, RendererGLES:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown renderer %q, must be %s, %s, %s, %s or %s", name,
+		RendererGL, RendererMetal, RendererVulkan, RendererD3D11, RendererGLES)
+}
+
+// WithRenderer selects the graphics API frames are drawn with, OpenGL by
+// default
+func WithRenderer(renderer Renderer) Option {
+	return func(c *Client) {
+		c.renderer = renderer
+	}
+}
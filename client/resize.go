@@ -0,0 +1,40 @@
+package client
+
+import (
+	"image"
+	"math"
+	"time"
+)
+
+// resizeSettle is how long a window keeps its size before the server is
+// asked to capture at it, see WithResizeScaling
+const resizeSettle = 500 * time.Millisecond
+
+// WithResizeScaling asks the server to capture each monitor at the size of
+// its window, e.g. at half the size for a window half as large as the
+// monitor, to save bandwidth and encoding time. The scale is asked for
+// once a window stops being resized, replacing the one set with
+// WithCaptureScales, and the largest any client asks for wins.
+func WithResizeScaling() Option {
+	return func(c *Client) {
+		c.resizeScaling = true
+	}
+}
+
+// windowCaptureScale returns the capture scale in percent that makes the
+// frames of a monitor of the given size as large as a window of width by
+// height pixels shows them in a scale mode, 0 for full size
+func windowCaptureScale(mode ScaleMode, monitor image.Point, width, height int) int {
+	if mode == ScaleNative || monitor.X <= 0 || monitor.Y <= 0 || width <= 0 || height <= 0 {
+		return 0
+	}
+	scale := max(float64(width)/float64(monitor.X), float64(height)/float64(monitor.Y))
+	if mode == ScaleFit {
+		scale = min(float64(width)/float64(monitor.X), float64(height)/float64(monitor.Y))
+	}
+	percent := int(math.Ceil(scale * 100))
+	if percent >= 100 {
+		return 0
+	}
+	return percent
+}
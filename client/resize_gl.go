@@ -0,0 +1,63 @@
+//go:build cgo
+
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// windowResized returns the framebuffer size callback of a monitor window.
+// It draws the window again at its new size, the display loop waits
+// while a window is being resized on some platforms.
+func (c *Client) windowResized(windowIndex int) glfw.FramebufferSizeCallback {
+	return func(window *glfw.Window, width, height int) {
+		c.resized[windowIndex] = time.Now()
+		if width == 0 || height == 0 {
+			return
+		}
+		if serverMonitorID := c.serverMonitorOf(windowIndex); serverMonitorID != 0 || c.gridShown() {
+			c.drawWindow(windowIndex, window, serverMonitorID, 1)
+		}
+	}
+}
+
+// scaleResizedWindows asks the server to capture the monitors of windows
+// that stopped being resized at their windows' size, see
+// WithResizeScaling
+func (c *Client) scaleResizedWindows() {
+	if !c.resizeScaling {
+		return
+	}
+	for i, resized := range c.resized {
+		window := c.windows[i]
+		if resized.IsZero() || time.Since(resized) < resizeSettle || window == nil {
+			continue
+		}
+		serverMonitorID := c.serverMonitorOf(i)
+		if serverMonitorID == 0 {
+			continue
+		}
+		c.resized[i] = time.Time{}
+
+		width, height := window.GetFramebufferSize()
+		if width == 0 || height == 0 {
+			continue // Minimized, keep the scale
+		}
+		monitor := c.serverMonitorSize(serverMonitorID)
+		if c.rotation(serverMonitorID).quarter() {
+			monitor.X, monitor.Y = monitor.Y, monitor.X
+		}
+		percent := windowCaptureScale(c.scaling[i], monitor, width, height)
+		if c.views[i].zoom > 1 {
+			percent = 0 // Zoomed in frames are shown larger than the window
+		}
+		if err := c.SetCaptureScale(serverMonitorID, percent); err != nil {
+			log.Printf("Error asking for monitor %d at the size of window %d: %v", serverMonitorID, i, err)
+		} else if percent > 0 {
+			log.Printf("Asked for monitor %d at %d%% of its size for window %d", serverMonitorID, percent, i)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rotation is how many degrees clockwise a server monitor is turned in
+// its window, e.g. 90 to show a landscape monitor on a portrait display
+type Rotation int
+
+const (
+	Rotate0   Rotation = 0
+	Rotate90  Rotation = 90
+	Rotate180 Rotation = 180
+	Rotate270 Rotation = 270
+)
+
+// ParseRotations parses a comma separated list of monitor rotations in
+// degrees like "1=90,2=270" for WithRotations. A rotation without a
+// monitor applies to every monitor.
+func ParseRotations(list string) (map[uint32]Rotation, error) {
+	rotations := make(map[uint32]Rotation)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		id, degrees, ok := strings.Cut(entry, "=")
+		monitorID := uint64(0)
+		if ok {
+			var err error
+			if monitorID, err = strconv.ParseUint(id, 10, 32); err != nil || monitorID == 0 {
+				return nil, fmt.Errorf("invalid monitor ID %q", id)
+			}
+		} else {
+			degrees = entry
+		}
+		rotation, err := strconv.Atoi(degrees)
+		if err != nil || rotation < 0 || rotation >= 360 || rotation%90 != 0 {
+			return nil, fmt.Errorf("invalid rotation %q, must be 0, 90, 180 or 270", degrees)
+		}
+		rotations[uint32(monitorID)] = Rotation(rotation)
+	}
+	return rotations, nil
+}
+
+// WithRotations turns server monitors clockwise in their windows, by
+// server monitor ID or 0 for every monitor. The pointer is mapped through
+// the rotation.
+func WithRotations(rotations map[uint32]Rotation) Option {
+	return func(c *Client) {
+		c.rotations = rotations
+	}
+}
+
+// rotation returns how a server monitor is turned in its window
+func (c *Client) rotation(serverMonitorID uint32) Rotation {
+	if rotation, ok := c.rotations[serverMonitorID]; ok {
+		return rotation
+	}
+	return c.rotations[0]
+}
+
+// quarter reports whether the rotation swaps a frame's width and height
+func (r Rotation) quarter() bool {
+	return r == Rotate90 || r == Rotate270
+}
+
+// rotate maps a position in a frame to the turned frame, both from 0 to 1
+// with the origin at the top left
+func (r Rotation) rotate(x, y float32) (float32, float32) {
+	switch r {
+	case Rotate90:
+		return 1 - y, x
+	case Rotate180:
+		return 1 - x, 1 - y
+	case Rotate270:
+		return y, 1 - x
+	}
+	return x, y
+}
+
+// unrotate maps a position in the turned frame back to the frame
+func (r Rotation) unrotate(x, y float32) (float32, float32) {
+	switch r {
+	case Rotate90:
+		return y, 1 - x
+	case Rotate180:
+		return 1 - x, 1 - y
+	case Rotate270:
+		return 1 - y, x
+	}
+	return x, y
+}
+
+// framePixel returns the pixel of a frame of width by height pixels that
+// turns into the pixel at x, y of the turned frame
+func (r Rotation) framePixel(x, y, width, height int) (int, int) {
+	switch r {
+	case Rotate90:
+		return y, height - 1 - x
+	case Rotate180:
+		return width - 1 - x, height - 1 - y
+	case Rotate270:
+		return width - 1 - y, x
+	}
+	return x, y
+}
+
+// corners returns the texture coordinates of the top left, top right,
+// bottom left and bottom right corners of the rect x0, y0, x1, y1 of the
+// turned frame
+func (r Rotation) corners(rect [4]float32) [4][2]float32 {
+	var corners [4][2]float32
+	for i, corner := range [4][2]float32{{rect[0], rect[1]}, {rect[2], rect[1]}, {rect[0], rect[3]}, {rect[2], rect[3]}} {
+		corners[i][0], corners[i][1] = r.unrotate(corner[0], corner[1])
+	}
+	return corners
+}
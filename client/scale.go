@@ -0,0 +1,49 @@
+package client
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithCaptureScales asks the server to encode monitors at a percentage of
+// their size, by server monitor ID or 0 for every monitor, e.g. 50 for a
+// 5K display on a slow link. Frames are encoded once for all clients, the
+// largest scale asked for wins.
+func WithCaptureScales(scales map[uint32]int) Option {
+	return func(c *Client) {
+		c.captureScales = maps.Clone(scales)
+	}
+}
+
+// SetCaptureScale changes the scale asked for during the session for a
+// server monitor, all monitors if monitorID is 0. A percent of 0 returns to
+// the server's scale.
+func (c *Client) SetCaptureScale(monitorID uint32, percent int) error {
+	if c.captureScales == nil {
+		c.captureScales = make(map[uint32]int)
+	}
+	if monitorID == 0 {
+		clear(c.captureScales)
+	}
+	if percent > 0 {
+		c.captureScales[monitorID] = min(percent, 100)
+	} else {
+		delete(c.captureScales, monitorID)
+	}
+	return c.sendQualityControl()
+}
+
+// captureScaleList lists the capture scales asked for in the order the
+// server applies them, every monitor's first
+func (c *Client) captureScaleList() []protocol.CaptureScale {
+	var scales []protocol.CaptureScale
+	for id, percent := range c.captureScales {
+		scales = append(scales, protocol.CaptureScale{MonitorID: id, Percent: percent})
+	}
+	slices.SortFunc(scales, func(a, b protocol.CaptureScale) int {
+		return int(a.MonitorID) - int(b.MonitorID)
+	})
+	return scales
+}
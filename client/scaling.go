@@ -0,0 +1,162 @@
+package client
+
+import (
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+)
+
+// ScaleMode is how a frame is scaled to its window when their sizes differ
+type ScaleMode string
+
+const (
+	// ScaleStretch stretches the frame over the whole window
+	ScaleStretch ScaleMode = "stretch"
+
+	// ScaleFit shows the whole frame as large as it fits at its aspect
+	// ratio, with bars where the window is left over
+	ScaleFit ScaleMode = "fit"
+
+	// ScaleFill covers the window with the frame at its aspect ratio,
+	// cropping what doesn't fit
+	ScaleFill ScaleMode = "fill"
+
+	// ScaleNative shows one frame pixel per window pixel, centered, cropped
+	// where the frame is larger than the window
+	ScaleNative ScaleMode = "1:1"
+)
+
+// scaleModes is the order Ctrl+Alt+S cycles through
+var scaleModes = []ScaleMode{ScaleStretch, ScaleFit, ScaleFill, ScaleNative}
+
+// ParseScaleModes parses a comma separated list of scale modes by window
+// index, e.g. "fit" or "fit,1:1", see WithScaleModes
+func ParseScaleModes(list string) ([]ScaleMode, error) {
+	var modes []ScaleMode
+	for _, name := range strings.Split(list, ",") {
+		mode := ScaleMode(strings.TrimSpace(name))
+		if !slices.Contains(scaleModes, mode) {
+			return nil, fmt.Errorf("unknown scale mode %q, must be stretch, fit, fill or 1:1", name)
+		}
+		modes = append(modes, mode)
+	}
+	return modes, nil
+}
+
+// WithScaleModes sets how frames are scaled to the monitor windows, by
+// window index, the last mode applying to the remaining windows. Frames
+// are fitted by default. A window's mode can be switched at runtime
+// with Ctrl+Alt+S.
+func WithScaleModes(modes ...ScaleMode) Option {
+	return func(c *Client) {
+		c.scaleModes = modes
+	}
+}
+
+// initialScaleMode returns the scale mode a window starts with
+func (c *Client) initialScaleMode(windowIndex int) ScaleMode {
+	if len(c.scaleModes) == 0 {
+		return ScaleFit
+	}
+	return c.scaleModes[min(windowIndex, len(c.scaleModes)-1)]
+}
+
+// frameLayout places a frame in a window: the part src of the frame,
+// turned by rotation, is drawn over the part dst of the window, both given
+// as x0, y0, x1, y1 from 0 to 1 with the origin at the top left. src is
+// given in the turned frame.
+type frameLayout struct {
+	dst, src [4]float32
+	rotation Rotation
+}
+
+// stretchLayout draws the whole frame over the whole window
+var stretchLayout = frameLayout{dst: [4]float32{0, 0, 1, 1}, src: [4]float32{0, 0, 1, 1}}
+
+// layoutFrame places a frame of frameWidth by frameHeight pixels, turned
+// by rotation, in a window of windowWidth by windowHeight pixels, centered
+func layoutFrame(mode ScaleMode, rotation Rotation, frameWidth, frameHeight, windowWidth, windowHeight int) frameLayout {
+	return layoutView(mode, rotation, viewport{}, frameWidth, frameHeight, windowWidth, windowHeight)
+}
+
+// layoutView places a frame like layoutFrame, zoomed in and panned to the
+// viewport view. The frame is kept covering the window where it is larger.
+func layoutView(mode ScaleMode, rotation Rotation, view viewport, frameWidth, frameHeight, windowWidth, windowHeight int) frameLayout {
+	layout := frameLayout{dst: stretchLayout.dst, src: stretchLayout.src, rotation: rotation}
+	if frameWidth <= 0 || frameHeight <= 0 || windowWidth <= 0 || windowHeight <= 0 {
+		return layout
+	}
+	if rotation.quarter() {
+		frameWidth, frameHeight = frameHeight, frameWidth
+	}
+	fw, fh := float64(frameWidth), float64(frameHeight)
+	ww, wh := float64(windowWidth), float64(windowHeight)
+
+	// Window pixels per frame pixel
+	var scaleX, scaleY float64
+	switch mode {
+	case ScaleStretch:
+		scaleX, scaleY = ww/fw, wh/fh
+	case ScaleFit:
+		scaleX = min(ww/fw, wh/fh)
+	case ScaleFill:
+		scaleX = max(ww/fw, wh/fh)
+	case ScaleNative:
+		scaleX = 1
+	default:
+		return layout
+	}
+	if mode != ScaleStretch {
+		scaleY = scaleX
+	}
+	scaleX, scaleY = scaleX*view.scale(), scaleY*view.scale()
+
+	// Share of the window the frame covers, and of the frame that shows
+	dw, dh := min(fw*scaleX/ww, 1), min(fh*scaleY/wh, 1)
+	sw, sh := dw*ww/scaleX/fw, dh*wh/scaleY/fh
+	cx, cy := min(max(0.5+view.x, sw/2), 1-sw/2), min(max(0.5+view.y, sh/2), 1-sh/2)
+	layout.dst = [4]float32{float32(0.5 - dw/2), float32(0.5 - dh/2), float32(0.5 + dw/2), float32(0.5 + dh/2)}
+	layout.src = [4]float32{float32(cx - sw/2), float32(cy - sh/2), float32(cx + sw/2), float32(cy + sh/2)}
+	return layout
+}
+
+// texCoords returns the frame's texture coordinates at the top left, top
+// right, bottom left and bottom right corners of dst
+func (l frameLayout) texCoords() [4][2]float32 {
+	return l.rotation.corners(l.src)
+}
+
+// toWindow maps a position in the turned frame to the window, both from 0
+// to 1
+func (l frameLayout) toWindow(x, y float32) (float32, float32) {
+	return l.dst[0] + (x-l.src[0])/(l.src[2]-l.src[0])*(l.dst[2]-l.dst[0]),
+		l.dst[1] + (y-l.src[1])/(l.src[3]-l.src[1])*(l.dst[3]-l.dst[1])
+}
+
+// rect returns where the rectangle of width by height frame pixels at x,
+// y of a frame of the given size goes in the window, as x0, y0, x1, y1
+// from 0 to 1. Its contents are turned like the frame's, see
+// Rotation.corners.
+func (l frameLayout) rect(x, y, width, height float32, frame image.Point) [4]float32 {
+	ax, ay := l.rotation.rotate(x/float32(frame.X), y/float32(frame.Y))
+	bx, by := l.rotation.rotate((x+width)/float32(frame.X), (y+height)/float32(frame.Y))
+	x0, y0 := l.toWindow(min(ax, bx), min(ay, by))
+	x1, y1 := l.toWindow(max(ax, bx), max(ay, by))
+	return [4]float32{x0, y0, x1, y1}
+}
+
+// toFrame maps a position in the window to the frame, both from 0 to 1.
+// Positions on the bars around a fitted frame go to its nearest edge.
+func (l frameLayout) toFrame(x, y float64) (float64, float64) {
+	fx, fy := l.toTurned(x, y)
+	rx, ry := l.rotation.unrotate(float32(min(max(fx, 0), 1)), float32(min(max(fy, 0), 1)))
+	return float64(rx), float64(ry)
+}
+
+// toTurned maps a position in the window to the turned frame, both from 0
+// to 1, beyond them on the bars around a fitted frame
+func (l frameLayout) toTurned(x, y float64) (float64, float64) {
+	return float64(l.src[0]) + (x-float64(l.dst[0]))/float64(l.dst[2]-l.dst[0])*float64(l.src[2]-l.src[0]),
+		float64(l.src[1]) + (y-float64(l.dst[1]))/float64(l.dst[3]-l.dst[1])*float64(l.src[3]-l.src[1])
+}
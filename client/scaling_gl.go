@@ -0,0 +1,44 @@
+//go:build cgo
+
+package client
+
+import (
+	"image"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// layoutWindow places a frame of the given size in a monitor window with
+// the window's scale mode, viewport and its monitor's rotation, in
+// framebuffer pixels so 1:1 is exact on high DPI displays, and keeps the
+// layout for mapping the pointer back
+func (c *Client) layoutWindow(windowIndex int, frame image.Point) frameLayout {
+	width, height := c.windows[windowIndex].GetFramebufferSize()
+	rotation := c.rotation(c.serverMonitorOf(windowIndex))
+	layout := layoutView(c.scaling[windowIndex], rotation, c.views[windowIndex], frame.X, frame.Y, width, height)
+	c.layouts[windowIndex] = layout
+	c.views[windowIndex] = c.views[windowIndex].shown(layout)
+	return layout
+}
+
+// windowLayout returns where the last frame of a monitor window was drawn
+func (c *Client) windowLayout(windowIndex int) frameLayout {
+	if windowIndex >= len(c.layouts) {
+		return stretchLayout
+	}
+	return c.layouts[windowIndex]
+}
+
+// cycleScaleMode switches a monitor window to the next scale mode
+func (c *Client) cycleScaleMode(window *glfw.Window) {
+	i := slices.Index(c.windows, window)
+	if i < 0 {
+		return
+	}
+	c.scaling[i] = scaleModes[(slices.Index(scaleModes, c.scaling[i])+1)%len(scaleModes)]
+	log.Printf("Window %d scale mode: %s", i, c.scaling[i])
+	c.resized[i] = time.Now() // The capture scale may change with it
+}
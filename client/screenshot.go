@@ -0,0 +1,61 @@
+package client
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WithScreenshotDir sets the directory Ctrl+Alt+P saves screenshots to,
+// created if needed. Without it they are saved to the working directory.
+func WithScreenshotDir(dir string) Option {
+	return func(c *Client) {
+		c.screenshotDir = dir
+	}
+}
+
+// saveScreenshot writes a server monitor's frame as a PNG file named after
+// the monitor and the time, without blocking the caller. Monitor 0 is the
+// grid of a grid client.
+func (c *Client) saveScreenshot(frame *image.RGBA, serverMonitorID uint32) {
+	dir := c.screenshotDir
+	if dir == "" {
+		dir = "."
+	}
+	shown := fmt.Sprintf("monitor%d", serverMonitorID)
+	if serverMonitorID == 0 {
+		shown = "grid"
+	}
+	name := fmt.Sprintf("ultrardp-%s-%s.png", shown, time.Now().Format("20060102-150405.000"))
+	path := filepath.Join(dir, name)
+
+	// Frames aren't modified once decoded, see frameSlots
+	go func() {
+		if err := writeScreenshot(path, frame); err != nil {
+			log.Printf("Failed to save screenshot: %v", err)
+			return
+		}
+		log.Printf("Saved screenshot to %s", path)
+	}()
+}
+
+// writeScreenshot encodes a frame to a new PNG file at path
+func writeScreenshot(path string, frame *image.RGBA) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, frame); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
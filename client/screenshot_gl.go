@@ -0,0 +1,31 @@
+//go:build cgo
+
+package client
+
+import (
+	"image"
+	"log"
+	"slices"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// takeScreenshot saves the latest frame of a monitor window, as decoded
+// without the control bar or color adjustments, or the grid a grid client
+// shows
+func (c *Client) takeScreenshot(window *glfw.Window) {
+	i := slices.Index(c.windows, window)
+	if i < 0 {
+		return
+	}
+	frame := c.windowFrame(i)
+	if frame != nil && c.gridShown() {
+		// The grid is drawn again into the same images
+		frame = &image.RGBA{Pix: slices.Clone(frame.Pix), Stride: frame.Stride, Rect: frame.Rect}
+	}
+	if frame == nil {
+		log.Printf("No frame to save in window %d yet", i)
+		return
+	}
+	c.saveScreenshot(frame, c.serverMonitorOf(i))
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moderniselife/ultrardp/transport"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshTimeout bounds connecting and authenticating to the SSH server
+const sshTimeout = 15 * time.Second
+
+// defaultSSHKeys are the key files tried when none is given, as ssh does
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// WithSSH tunnels the session through an SSH server given as
+// user@host[:port], for servers that only expose SSH. The server address
+// is dialed from the SSH host, so "localhost:8000" reaches a server
+// running on it. Authentication uses the SSH agent and keyFile, or the
+// default keys in ~/.ssh if keyFile is empty. The host key must be in
+// ~/.ssh/known_hosts.
+func WithSSH(target, keyFile string) Option {
+	return func(c *Client) {
+		c.sshTarget = target
+		c.sshKeyFile = keyFile
+	}
+}
+
+// sshDial opens a connection through the SSH tunnel, connecting to the
+// SSH server on first use. Every connection of the session (control,
+// per-monitor) shares one SSH connection.
+func (c *Client) sshDial(network, address string) (net.Conn, error) {
+	c.sshMutex.Lock()
+	defer c.sshMutex.Unlock()
+
+	if c.sshClient == nil {
+		username, host, err := parseSSHTarget(c.sshTarget)
+		if err != nil {
+			return nil, err
+		}
+		// The SSH server itself may only be reachable through a proxy
+		dialer, err := transport.ProxyDialer(c.proxyURL, host)
+		if err != nil {
+			return nil, err
+		}
+		client, err := connectSSH(username, host, c.sshKeyFile, dialer)
+		if err != nil {
+			return nil, fmt.Errorf("SSH connection to %s failed: %w", c.sshTarget, err)
+		}
+		log.Printf("Tunneling through SSH server %s", c.sshTarget)
+		c.sshClient = client
+	}
+	return c.sshClient.Dial(network, address)
+}
+
+// closeSSH closes the SSH connection and with it every tunneled connection
+func (c *Client) closeSSH() {
+	c.sshMutex.Lock()
+	defer c.sshMutex.Unlock()
+
+	if c.sshClient != nil {
+		c.sshClient.Close()
+		c.sshClient = nil
+	}
+}
+
+// parseSSHTarget splits user@host[:port], defaulting to the local user
+// name and port 22
+func parseSSHTarget(target string) (username, host string, err error) {
+	username, host, ok := strings.Cut(target, "@")
+	if !ok {
+		host = target
+		current, err := user.Current()
+		if err != nil {
+			return "", "", err
+		}
+		username = current.Username
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	return username, host, nil
+}
+
+// connectSSH connects and authenticates to an SSH server, through dialer
+// if not nil and directly otherwise
+func connectSSH(username, host, keyFile string, dialer transport.Dialer) (*ssh.Client, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("can't verify the host key, connect once with ssh to add it to known_hosts: %w", err)
+	}
+
+	auth, err := sshAuthMethods(home, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         sshTimeout,
+	}
+	if dialer == nil {
+		dialer = transport.DialFastest
+	}
+
+	conn, err := dialer("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	// Timeout only applies to ssh.Dial, bound the handshake ourselves
+	conn.SetDeadline(time.Now().Add(sshTimeout))
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, host, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+// sshAuthMethods returns the agent, if running, and the usable key files
+func sshAuthMethods(home, keyFile string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		} else {
+			log.Printf("SSH agent unavailable: %v", err)
+		}
+	}
+
+	keyFiles := []string{keyFile}
+	if keyFile == "" {
+		keyFiles = nil
+		for _, name := range defaultSSHKeys {
+			keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, file := range keyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if keyFile != "" {
+				return nil, err
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			log.Printf("Skipping passphrase protected key %s, add it to the SSH agent instead", file)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", file, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH agent or usable key file")
+	}
+	return methods, nil
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/stats"
+)
+
+// WithStatsJSON periodically writes the stats snapshot as JSON lines to
+// path, which is either stats.Stdout or a file that is appended to
+func WithStatsJSON(path string, interval time.Duration) Option {
+	return func(c *Client) {
+		c.statsPath = path
+		c.statsInterval = interval
+	}
+}
+
+// startStats opens the stats output and starts emitting snapshots. The
+// server is pinged at the same interval so snapshots include the RTT.
+func (c *Client) startStats() error {
+	if c.statsInterval <= 0 {
+		return fmt.Errorf("invalid stats interval %v", c.statsInterval)
+	}
+
+	output, err := stats.OpenJSON(c.statsPath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Writing stats to %s every %v", c.statsPath, c.statsInterval)
+	go c.stats.EmitJSON(output, c.statsInterval, c.stopChan)
+	go c.pingLoop(c.statsInterval)
+	return nil
+}
+
+// pingLoop pings the server until the client stops
+func (c *Client) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+			if err := c.SendPing(); err != nil && !c.stopped {
+				log.Printf("Error sending ping: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"io"
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+	"github.com/moderniselife/ultrardp/transport"
+)
+
+// WithTransport selects the transport used to reach the server, see the
+// transport package for the names
+func WithTransport(name string) Option {
+	return func(c *Client) {
+		c.transport = name
+	}
+}
+
+// acceptStreams receives the per-monitor video streams and the camera
+// stream opened by the server
+func (c *Client) acceptStreams(conn transport.MultiStreamConn) {
+	for !c.stopped {
+		monitorID, stream, err := conn.AcceptStream()
+		if err != nil {
+			if !c.stopped {
+				log.Printf("Error accepting stream: %v", err)
+			}
+			return
+		}
+
+		switch monitorID {
+		case protocol.StreamCamera:
+			log.Println("Receiving the server's camera on its own stream")
+		case protocol.StreamBulk:
+			log.Println("Receiving bulk data on its own stream")
+		default:
+			log.Printf("Receiving monitor %d on its own stream", monitorID)
+		}
+		go c.receiveStream(stream)
+	}
+}
+
+// openInputStream opens the stream input events are sent on, so they never
+// queue behind other packets on the control stream
+func (c *Client) openInputStream() error {
+	stream, err := c.streams.OpenStream(protocol.StreamInput)
+	if err != nil {
+		return err
+	}
+	c.inputMutex.Lock()
+	c.inputStream = stream
+	c.inputMutex.Unlock()
+	return nil
+}
+
+// sendInput sends an input packet on the input stream, or on the control
+// connection if the transport has no streams
+func (c *Client) sendInput(packet *protocol.Packet) error {
+	c.inputMutex.Lock()
+	stream := c.inputStream
+	if stream == nil {
+		c.inputMutex.Unlock()
+		return c.send(packet)
+	}
+	defer c.inputMutex.Unlock()
+
+	c.stats.PacketSent(protocol.HeaderSize + len(packet.Payload))
+	return protocol.EncodePacket(stream, packet)
+}
+
+// receiveStream handles the packets arriving on a single stream
+func (c *Client) receiveStream(stream io.ReadCloser) {
+	defer stream.Close()
+
+	for !c.stopped {
+		packet, err := protocol.DecodePacket(stream)
+		if err != nil {
+			if err != io.EOF && !c.stopped {
+				log.Printf("Error receiving packet on stream: %v", err)
+			}
+			return
+		}
+		c.handlePacket(packet)
+	}
+}
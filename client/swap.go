@@ -0,0 +1,60 @@
+package client
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SwapMode is how a window's frames are synchronized with its display
+type SwapMode string
+
+const (
+	// SwapVSync shows every frame on a vertical blank, never tearing, with
+	// the graphics API's usual queue of frames
+	SwapVSync SwapMode = "vsync"
+
+	// SwapLowLatency shows frames on a vertical blank with as few of them
+	// queued as the graphics API allows: a later frame replaces a waiting
+	// one with Vulkan, and a late frame tears rather than wait a whole
+	// refresh with OpenGL where the driver supports it
+	SwapLowLatency SwapMode = "low-latency"
+
+	// SwapOff shows frames as soon as they're drawn, tearing where the
+	// display and graphics API allow it
+	SwapOff SwapMode = "off"
+)
+
+// swapModes are the swap modes ParseSwapModes accepts
+var swapModes = []SwapMode{SwapVSync, SwapLowLatency, SwapOff}
+
+// ParseSwapModes parses a comma separated list of swap modes by window
+// index, e.g. "vsync" or "low-latency,off", see WithSwapModes
+func ParseSwapModes(list string) ([]SwapMode, error) {
+	var modes []SwapMode
+	for _, name := range strings.Split(list, ",") {
+		mode := SwapMode(strings.TrimSpace(name))
+		if !slices.Contains(swapModes, mode) {
+			return nil, fmt.Errorf("unknown swap mode %q, must be vsync, low-latency or off", name)
+		}
+		modes = append(modes, mode)
+	}
+	return modes, nil
+}
+
+// WithSwapModes sets how the monitor windows' frames are synchronized with
+// their displays, by window index, the last mode applying to the remaining
+// windows. Windows are low latency by default.
+func WithSwapModes(modes ...SwapMode) Option {
+	return func(c *Client) {
+		c.swapModes = modes
+	}
+}
+
+// swapMode returns the swap mode of a monitor window
+func (c *Client) swapMode(windowIndex int) SwapMode {
+	if len(c.swapModes) == 0 {
+		return SwapLowLatency
+	}
+	return c.swapModes[min(windowIndex, len(c.swapModes)-1)]
+}
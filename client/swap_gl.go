@@ -0,0 +1,59 @@
+//go:build cgo
+
+package client
+
+import (
+	"time"
+
+	"github.com/go-gl/gl/v2.1/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// setSwapInterval sets the swap interval of the current OpenGL context for
+// a swap mode
+func setSwapInterval(mode SwapMode) {
+	switch mode {
+	case SwapVSync:
+		glfw.SwapInterval(1)
+	case SwapOff:
+		glfw.SwapInterval(0)
+	default:
+		// Adaptive sync tears a late frame instead of holding it a refresh
+		if glfw.ExtensionSupported("WGL_EXT_swap_control_tear") || glfw.ExtensionSupported("GLX_EXT_swap_control_tear") {
+			glfw.SwapInterval(-1)
+		} else {
+			glfw.SwapInterval(1)
+		}
+	}
+}
+
+// swapBuffers shows what was drawn in a monitor window with OpenGL. Low
+// latency windows wait for the swap to finish, so the driver doesn't queue
+// frames ahead of the display.
+func (c *Client) swapBuffers(windowIndex int, window *glfw.Window) {
+	window.SwapBuffers()
+	if c.swapMode(windowIndex) == SwapLowLatency {
+		gl.Finish()
+	}
+}
+
+// swapWaits reports whether showing a frame in a monitor window waits for
+// its display, which paces the display loop. Minimized windows don't wait.
+func (c *Client) swapWaits(windowIndex int, window *glfw.Window) bool {
+	return c.swapMode(windowIndex) != SwapOff && window.GetAttrib(glfw.Iconified) == glfw.False
+}
+
+// refreshPeriod returns the time between refreshes of the fastest local
+// monitor
+func refreshPeriod() time.Duration {
+	rate := 0
+	for _, monitor := range glfw.GetMonitors() {
+		if mode := monitor.GetVideoMode(); mode != nil {
+			rate = max(rate, mode.RefreshRate)
+		}
+	}
+	if rate <= 0 {
+		rate = 60
+	}
+	return time.Second / time.Duration(rate)
+}
@@ -0,0 +1,76 @@
+//go:build cgo
+
+package client
+
+import (
+	"image"
+	"unsafe"
+
+	"github.com/go-gl/gl/v2.1/gl"
+)
+
+// streamTexture is a window's frame texture, allocated once at the frame
+// size and updated through two pixel buffer objects used in turn, so a
+// frame is copied to one while the GPU may still read the other. It
+// belongs to the OpenGL context of its window.
+type streamTexture struct {
+	id            uint32
+	buffers       [2]uint32 // Pixel unpack buffers
+	next          int       // Buffer the next frame is copied to
+	width, height int       // Size the texture is allocated at
+}
+
+// create creates the texture and its buffers in the current context
+func (t *streamTexture) create() {
+	gl.GenTextures(1, &t.id)
+	gl.GenBuffers(int32(len(t.buffers)), &t.buffers[0])
+}
+
+// upload copies a frame to the texture, reallocating it only when the
+// frame's size changes
+func (t *streamTexture) upload(frame *image.RGBA) {
+	if t.id == 0 {
+		t.create()
+	}
+	size := frame.Rect.Size()
+	if size.X <= 0 || size.Y <= 0 {
+		return
+	}
+	rowSize := size.X * 4
+	bufferSize := rowSize * size.Y
+
+	gl.BindTexture(gl.TEXTURE_2D, t.id)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	if size.X != t.width || size.Y != t.height {
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, int32(size.X), int32(size.Y), 0,
+			gl.RGBA, gl.UNSIGNED_BYTE, nil)
+		t.width, t.height = size.X, size.Y
+	}
+
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, t.buffers[t.next])
+	t.next = (t.next + 1) % len(t.buffers)
+
+	// Orphaning the buffer's storage keeps mapping it from waiting on a
+	// transfer still reading it
+	gl.BufferData(gl.PIXEL_UNPACK_BUFFER, bufferSize, nil, gl.STREAM_DRAW)
+	if ptr := gl.MapBuffer(gl.PIXEL_UNPACK_BUFFER, gl.WRITE_ONLY); ptr != nil {
+		dst := unsafe.Slice((*byte)(ptr), bufferSize)
+		for y := 0; y < size.Y; y++ {
+			src := frame.Pix[frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y+y):]
+			copy(dst[y*rowSize:(y+1)*rowSize], src[:rowSize])
+		}
+		if gl.UnmapBuffer(gl.PIXEL_UNPACK_BUFFER) {
+			gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, int32(size.X), int32(size.Y),
+				gl.RGBA, gl.UNSIGNED_BYTE, gl.PtrOffset(0))
+			gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+			return
+		}
+	}
+
+	// The buffer couldn't be written, upload from the frame itself
+	gl.BindBuffer(gl.PIXEL_UNPACK_BUFFER, 0)
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(frame.Stride/4))
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, int32(size.X), int32(size.Y),
+		gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&frame.Pix[frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y)]))
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"math"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+const (
+	// touchSlop is how far in window pixels a contact moves before it
+	// drags rather than taps
+	touchSlop = 10
+
+	// touchLongPress is how long a tap is held to right click
+	touchLongPress = 600 * time.Millisecond
+
+	// touchWheelPixels is how far in window pixels two fingers move per
+	// wheel notch
+	touchWheelPixels = 40
+)
+
+// WithTouch makes touch screens drive the client windows with gestures on
+// Windows: a tap clicks, a long press right clicks, dragging a finger
+// drags with the left button, dragging two fingers scrolls and pinching
+// zooms the window, see zoomKey
+func WithTouch() Option {
+	return func(c *Client) {
+		c.touch = true
+	}
+}
+
+// touchActionKind is what a touch gesture does
+type touchActionKind int
+
+const (
+	touchPointer touchActionKind = iota // Move the pointer to x, y
+	touchButton                         // Press or release button at x, y
+	touchScroll                         // Scroll by wheelX, wheelY at x, y
+	touchZoom                           // Zoom the window by factor around x, y and pan it by dx, dy
+)
+
+// touchAction is a step of a touch gesture, positions are in window pixels
+type touchAction struct {
+	kind           touchActionKind
+	x, y           float64
+	button         protocol.MouseButton
+	down           bool
+	wheelX, wheelY int // In 120ths of a notch, positive to the right and up
+	factor         float64
+	dx, dy         float64
+}
+
+// touchGesture recognizes the gestures of the contacts on a window
+type touchGesture struct {
+	contacts map[uint32][2]float64 // Positions by contact ID
+
+	// A single contact taps, or drags once it moved beyond touchSlop
+	start          time.Time
+	startX, startY float64
+	x, y           float64
+	dragging       bool
+
+	// Two contacts pinch or scroll, whichever they move enough for first,
+	// until both lift
+	twoFingers                 bool
+	pinching, scrolling        bool
+	spreadX, spreadY, spread   float64 // Center between the contacts and their distance
+	startSpreadX, startSpreadY float64
+	startSpread                float64
+	wheelX, wheelY             float64 // Scroll in 120ths of a notch not sent yet
+}
+
+// down starts a contact at x, y and returns what it does
+func (g *touchGesture) down(id uint32, x, y float64, now time.Time) []touchAction {
+	if g.contacts == nil {
+		g.contacts = make(map[uint32][2]float64)
+	}
+	g.contacts[id] = [2]float64{x, y}
+	switch {
+	case len(g.contacts) == 1:
+		*g = touchGesture{contacts: g.contacts, start: now, startX: x, startY: y, x: x, y: y}
+		return []touchAction{{kind: touchPointer, x: x, y: y}}
+	case len(g.contacts) == 2 && !g.twoFingers:
+		var actions []touchAction
+		if g.dragging {
+			actions = append(actions, touchAction{kind: touchButton, x: g.x, y: g.y, button: protocol.MouseLeft})
+			g.dragging = false
+		}
+		g.twoFingers = true
+		g.spreadX, g.spreadY, g.spread = g.measure()
+		g.startSpreadX, g.startSpreadY, g.startSpread = g.spreadX, g.spreadY, g.spread
+		return actions
+	}
+	return nil
+}
+
+// move moves a contact to x, y and returns what it does
+func (g *touchGesture) move(id uint32, x, y float64) []touchAction {
+	if _, ok := g.contacts[id]; !ok {
+		return nil
+	}
+	g.contacts[id] = [2]float64{x, y}
+
+	if !g.twoFingers {
+		g.x, g.y = x, y
+		if !g.dragging && math.Hypot(x-g.startX, y-g.startY) > touchSlop {
+			g.dragging = true
+			return []touchAction{
+				{kind: touchButton, x: g.startX, y: g.startY, button: protocol.MouseLeft, down: true},
+				{kind: touchPointer, x: x, y: y},
+			}
+		}
+		if g.dragging {
+			return []touchAction{{kind: touchPointer, x: x, y: y}}
+		}
+		return nil
+	}
+
+	if len(g.contacts) != 2 {
+		return nil
+	}
+	cx, cy, spread := g.measure()
+	if !g.pinching && !g.scrolling {
+		switch {
+		case math.Abs(spread-g.startSpread) > 2*touchSlop:
+			g.pinching = true
+		case math.Hypot(cx-g.startSpreadX, cy-g.startSpreadY) > touchSlop:
+			g.scrolling = true
+		default:
+			return nil
+		}
+	}
+	dx, dy := cx-g.spreadX, cy-g.spreadY
+	factor := 1.0
+	if g.spread > 0 {
+		factor = spread / g.spread
+	}
+	g.spreadX, g.spreadY, g.spread = cx, cy, spread
+
+	if g.pinching {
+		return []touchAction{{kind: touchZoom, x: cx, y: cy, factor: factor, dx: dx, dy: dy}}
+	}
+
+	// The content follows the fingers, like a touch screen scrolls
+	g.wheelX -= dx * 120 / touchWheelPixels
+	g.wheelY += dy * 120 / touchWheelPixels
+	wheelX, wheelY := int(g.wheelX), int(g.wheelY)
+	if wheelX == 0 && wheelY == 0 {
+		return nil
+	}
+	g.wheelX -= float64(wheelX)
+	g.wheelY -= float64(wheelY)
+	return []touchAction{{kind: touchScroll, x: cx, y: cy, wheelX: wheelX, wheelY: wheelY}}
+}
+
+// up lifts a contact at x, y and returns what it does
+func (g *touchGesture) up(id uint32, x, y float64, now time.Time) []touchAction {
+	if _, ok := g.contacts[id]; !ok {
+		return nil
+	}
+	delete(g.contacts, id)
+	if g.twoFingers || len(g.contacts) > 0 {
+		return nil
+	}
+	if g.dragging {
+		g.dragging = false
+		return []touchAction{{kind: touchButton, x: x, y: y, button: protocol.MouseLeft}}
+	}
+	button := protocol.MouseLeft
+	if now.Sub(g.start) >= touchLongPress {
+		button = protocol.MouseRight
+	}
+	return []touchAction{
+		{kind: touchButton, x: g.startX, y: g.startY, button: button, down: true},
+		{kind: touchButton, x: g.startX, y: g.startY, button: button},
+	}
+}
+
+// cancel forgets a contact the platform stopped tracking, releasing the
+// button if it dragged
+func (g *touchGesture) cancel(id uint32) []touchAction {
+	if _, ok := g.contacts[id]; !ok {
+		return nil
+	}
+	delete(g.contacts, id)
+	if g.dragging {
+		g.dragging = false
+		return []touchAction{{kind: touchButton, x: g.x, y: g.y, button: protocol.MouseLeft}}
+	}
+	return nil
+}
+
+// measure returns the center between the first two contacts and their
+// distance
+func (g *touchGesture) measure() (x, y, distance float64) {
+	var points [][2]float64
+	for _, point := range g.contacts {
+		points = append(points, point)
+		if len(points) == 2 {
+			break
+		}
+	}
+	if len(points) < 2 {
+		return 0, 0, 0
+	}
+	a, b := points[0], points[1]
+	return (a[0] + b[0]) / 2, (a[1] + b[1]) / 2, math.Hypot(a[0]-b[0], a[1]-b[1])
+}
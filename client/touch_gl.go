@@ -0,0 +1,99 @@
+//go:build cgo
+
+package client
+
+import (
+	"log"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// touchPhase is the step of a touch contact's life an event reports
+type touchPhase int
+
+const (
+	touchDown touchPhase = iota
+	touchMove
+	touchUp
+	touchCancel // The platform stopped tracking the contact
+)
+
+// touchContact is a touch contact's event, at x, y in the window's screen
+// coordinates
+type touchContact struct {
+	id    uint32
+	phase touchPhase
+	x, y  float64
+}
+
+// hookTouch delivers a window's touch contacts to handle instead of the
+// mouse events the platform makes of them. Set by platforms supporting it.
+var hookTouch func(window *glfw.Window, handle func(contact touchContact)) error
+
+// enableTouch turns a window's touch contacts into gestures, see WithTouch
+func (c *Client) enableTouch(windowIndex int, window *glfw.Window) {
+	if hookTouch == nil {
+		log.Println("Touch gestures are only supported on Windows, touch screens move the pointer like a mouse")
+		return
+	}
+	err := hookTouch(window, func(contact touchContact) {
+		c.handleTouch(windowIndex, window, contact)
+	})
+	if err != nil {
+		log.Printf("Failed to enable touch gestures in window %d: %v", windowIndex, err)
+	}
+}
+
+// handleTouch runs the gesture a touch contact's event makes in a window.
+// It runs on the main thread from glfw.PollEvents.
+func (c *Client) handleTouch(windowIndex int, window *glfw.Window, contact touchContact) {
+	gesture := &c.touches[windowIndex]
+	var actions []touchAction
+	switch contact.phase {
+	case touchDown:
+		actions = gesture.down(contact.id, contact.x, contact.y, time.Now())
+	case touchMove:
+		actions = gesture.move(contact.id, contact.x, contact.y)
+	case touchUp:
+		actions = gesture.up(contact.id, contact.x, contact.y, time.Now())
+	case touchCancel:
+		actions = gesture.cancel(contact.id)
+	}
+	for _, action := range actions {
+		c.runTouchAction(windowIndex, window, action)
+	}
+}
+
+// runTouchAction zooms a window or forwards pointer input to the server
+// for a step of a touch gesture
+func (c *Client) runTouchAction(windowIndex int, window *glfw.Window, action touchAction) {
+	if action.kind == touchZoom {
+		width, height := window.GetSize()
+		layout := c.windowLayout(windowIndex)
+		shownWidth := float64(layout.dst[2]-layout.dst[0]) * float64(width)
+		shownHeight := float64(layout.dst[3]-layout.dst[1]) * float64(height)
+		if shownWidth <= 0 || shownHeight <= 0 {
+			return
+		}
+		// The frame follows the fingers as they pinch
+		c.views[windowIndex] = c.views[windowIndex].pan(-action.dx/shownWidth, -action.dy/shownHeight, layout)
+		c.zoomWindow(windowIndex, action.factor, action.x/float64(width), action.y/float64(height))
+		return
+	}
+
+	serverMonitorID, x, y, ok := c.serverPoint(windowIndex, window, action.x, action.y)
+	if action.kind == touchButton {
+		c.sendButton(serverMonitorID, x, y, ok, action.button, action.down)
+		return
+	}
+	if !ok {
+		return
+	}
+	switch action.kind {
+	case touchPointer:
+		c.movePointer(serverMonitorID, x, y)
+	case touchScroll:
+		c.sendMouseWheel(serverMonitorID, x, y, action.wheelX, action.wheelY)
+	}
+}
@@ -0,0 +1,86 @@
+//go:build windows && cgo
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+var (
+	comctl32              = syscall.NewLazyDLL("comctl32.dll")
+	procSetWindowSubclass = comctl32.NewProc("SetWindowSubclass")
+	procDefSubclassProc   = comctl32.NewProc("DefSubclassProc")
+	procGetPointerType    = syscall.NewLazyDLL("user32.dll").NewProc("GetPointerType")
+	procScreenToClient    = syscall.NewLazyDLL("user32.dll").NewProc("ScreenToClient")
+)
+
+// Pointer messages and types from winuser.h
+const (
+	wmPointerUpdate         = 0x0245
+	wmPointerDown           = 0x0246
+	wmPointerUp             = 0x0247
+	wmPointerCaptureChanged = 0x024C
+	ptTouch                 = 2
+)
+
+var (
+	// touchHandlers are the touch handlers of hooked windows by handle
+	touchHandlers = make(map[uintptr]func(touchContact))
+
+	// touchSubclass is the window procedure passing touch messages to
+	// them, callbacks are never freed so there is one for every window
+	touchSubclass = syscall.NewCallback(touchWindowProc)
+)
+
+func init() {
+	hookTouch = hookWin32Touch
+}
+
+// hookWin32Touch subclasses a GLFW window to receive its touch pointer
+// messages, which needs Windows 8
+func hookWin32Touch(window *glfw.Window, handle func(contact touchContact)) error {
+	if procGetPointerType.Find() != nil {
+		return errors.New("touch input needs Windows 8 or later")
+	}
+	hwnd := uintptr(unsafe.Pointer(window.GetWin32Window()))
+	if r, _, err := procSetWindowSubclass.Call(hwnd, touchSubclass, 1, 0); r == 0 {
+		return fmt.Errorf("SetWindowSubclass failed: %v", err)
+	}
+	touchHandlers[hwnd] = handle
+	return nil
+}
+
+// touchWindowProc passes the touch pointer messages of a hooked window to
+// its handler, handling them keeps Windows from making mouse messages of
+// them. It runs on the main thread from glfw.PollEvents.
+func touchWindowProc(hwnd, msg, wParam, lParam, id, data uintptr) uintptr {
+	handle, ok := touchHandlers[hwnd]
+	pointerID := uint32(wParam & 0xFFFF)
+	switch {
+	case !ok:
+	case msg == wmPointerCaptureChanged:
+		handle(touchContact{id: pointerID, phase: touchCancel})
+	case msg == wmPointerDown || msg == wmPointerUpdate || msg == wmPointerUp:
+		var pointerType uint32
+		if r, _, _ := procGetPointerType.Call(uintptr(pointerID), uintptr(unsafe.Pointer(&pointerType))); r == 0 || pointerType != ptTouch {
+			break
+		}
+		point := struct{ x, y int32 }{int32(int16(lParam)), int32(int16(lParam >> 16))}
+		procScreenToClient.Call(hwnd, uintptr(unsafe.Pointer(&point)))
+		phase := touchMove
+		if msg == wmPointerDown {
+			phase = touchDown
+		} else if msg == wmPointerUp {
+			phase = touchUp
+		}
+		handle(touchContact{id: pointerID, phase: phase, x: float64(point.x), y: float64(point.y)})
+		return 0
+	}
+	r, _, _ := procDefSubclassProc.Call(hwnd, msg, wParam, lParam)
+	return r
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// udpKeepaliveInterval keeps NAT mappings for the UDP channel alive
+const udpKeepaliveInterval = 5 * time.Second
+
+// WithUDP asks the server to send video frames over UDP. Lost frames are
+// dropped instead of stalling the stream as they do with TCP. If the server
+// declines, frames keep arriving over the TCP connection.
+func WithUDP() Option {
+	return func(c *Client) {
+		c.udpEnabled = true
+	}
+}
+
+// requestUDP asks the server for a UDP video channel
+func (c *Client) requestUDP() error {
+	return c.send(protocol.NewPacket(protocol.PacketTypeUDPRequest, nil))
+}
+
+// startUDP opens the UDP channel described by the server's setup packet
+func (c *Client) startUDP(payload []byte) error {
+	port, token, err := protocol.DecodeUDPSetup(payload)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP channel: %w", err)
+	}
+
+	log.Printf("Receiving video over UDP from %s", addr)
+	go c.sendUDPHellos(conn, token, c.sessionDone)
+	go c.receiveDatagrams(conn)
+	return nil
+}
+
+// sendUDPHellos registers our UDP address with the server, repeating the
+// hello in case it is lost and to keep NAT mappings alive. The channel is
+// closed when sessionDone is, the server opens a new one on reconnect.
+func (c *Client) sendUDPHellos(conn *net.UDPConn, token []byte, sessionDone <-chan struct{}) {
+	hello := protocol.EncodeHelloDatagram(token)
+
+	// A few quick hellos first so video starts promptly despite loss
+	for i := 0; i < 3; i++ {
+		conn.Write(hello)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	ticker := time.NewTicker(udpKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopChan:
+			conn.Close()
+			return
+		case <-sessionDone:
+			conn.Close()
+			return
+		case <-ticker.C:
+			conn.Write(hello)
+		}
+	}
+}
+
+// receiveDatagrams reassembles video frames from the UDP channel
+func (c *Client) receiveDatagrams(conn *net.UDPConn) {
+	reassembler := protocol.NewReassembler()
+	reassembler.OnDrop = c.frameDropped
+	reportedDrops := 0
+	lastReport := time.Now()
+
+	buf := make([]byte, 2048)
+	for !c.stopped {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if !c.stopped && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error receiving UDP datagram: %v", err)
+			}
+			return
+		}
+
+		datagram, err := protocol.DecodeDatagram(buf[:n])
+		if err != nil || datagram.Kind != protocol.DatagramFragment {
+			continue
+		}
+
+		dropped := reassembler.Dropped
+		if payload := reassembler.Add(datagram); payload != nil {
+			c.handlePacket(protocol.NewPacket(protocol.PacketTypeVideoFrame, payload))
+		}
+		if reassembler.Dropped != dropped {
+			c.recoverFromLoss()
+		}
+
+		// Tell the server about loss so it shows up in its logs
+		if reassembler.Dropped != reportedDrops && time.Since(lastReport) > udpKeepaliveInterval {
+			reportedDrops = reassembler.Dropped
+			lastReport = time.Now()
+			packet := protocol.NewPacket(protocol.PacketTypeUDPLoss, protocol.Uint32ToBytes(uint32(reportedDrops)))
+			if err := c.send(packet); err != nil {
+				log.Printf("Error sending UDP loss report: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+const (
+	// zoomStep is how much a window zooms in or out per key press or
+	// scroll step
+	zoomStep = 1.25
+
+	// maxZoom is the most a window zooms in on the size of its scale mode
+	maxZoom = 16
+
+	// panStep is the share of the shown part of a frame Ctrl+Alt+arrows
+	// pan by, and scrollPan the share per scroll step
+	panStep   = 0.25
+	scrollPan = 0.1
+)
+
+// viewport is the part of its frame a window shows when zoomed in or
+// panned. The zero viewport shows the frame as the window's scale mode
+// does.
+type viewport struct {
+	zoom float64 // Times the scale mode's size, 0 for 1
+	x, y float64 // Center of the shown part from the turned frame's center, from -0.5 to 0.5
+}
+
+// scale returns how many times the scale mode's size the frame is shown
+func (v viewport) scale() float64 {
+	return max(v.zoom, 1)
+}
+
+// zoomAt returns the viewport zoomed in by factor, or out for a factor
+// below 1, keeping the point x, y of the turned frame, from 0 to 1, where
+// it is in the window
+func (v viewport) zoomAt(factor, x, y float64) viewport {
+	zoom := min(max(v.scale()*factor, 1), maxZoom)
+	factor = zoom / v.scale()
+	x, y = x-0.5, y-0.5
+	return viewport{zoom: zoom, x: x + (v.x-x)/factor, y: y + (v.y-y)/factor}
+}
+
+// pan returns the viewport moved by dx, dy shares of the part of the frame
+// layout shows, as far as the frame goes
+func (v viewport) pan(dx, dy float64, layout frameLayout) viewport {
+	sw, sh := float64(layout.src[2]-layout.src[0]), float64(layout.src[3]-layout.src[1])
+	v.x = min(max(v.x+dx*sw, sw/2-0.5), 0.5-sw/2)
+	v.y = min(max(v.y+dy*sh, sh/2-0.5), 0.5-sh/2)
+	return v
+}
+
+// shown returns the viewport panned to the part of the frame layout shows,
+// where layoutView moved it to keep the frame covering the window
+func (v viewport) shown(layout frameLayout) viewport {
+	v.x = float64(layout.src[0]+layout.src[2])/2 - 0.5
+	v.y = float64(layout.src[1]+layout.src[3])/2 - 0.5
+	return v
+}
@@ -0,0 +1,92 @@
+//go:build cgo
+
+package client
+
+import (
+	"log"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// zoomKey zooms or pans the window for Ctrl+Alt+key: plus and minus zoom
+// in and out, 0 goes back to the scale mode, 1 shows the frame at 1:1
+// around the pointer and the arrows pan
+func (c *Client) zoomKey(window *glfw.Window, key glfw.Key) {
+	i := slices.Index(c.windows, window)
+	if i < 0 {
+		return
+	}
+	switch key {
+	case glfw.KeyEqual, glfw.KeyKPAdd:
+		c.zoomWindow(i, zoomStep, 0.5, 0.5)
+	case glfw.KeyMinus, glfw.KeyKPSubtract:
+		c.zoomWindow(i, 1/zoomStep, 0.5, 0.5)
+	case glfw.Key0, glfw.KeyKP0:
+		c.views[i] = viewport{}
+		c.resized[i] = time.Now() // The capture scale may follow the window again
+		log.Printf("Window %d zoom reset", i)
+	case glfw.Key1, glfw.KeyKP1:
+		c.showNative(i, window)
+	case glfw.KeyLeft:
+		c.views[i] = c.views[i].pan(-panStep, 0, c.windowLayout(i))
+	case glfw.KeyRight:
+		c.views[i] = c.views[i].pan(panStep, 0, c.windowLayout(i))
+	case glfw.KeyUp:
+		c.views[i] = c.views[i].pan(0, -panStep, c.windowLayout(i))
+	case glfw.KeyDown:
+		c.views[i] = c.views[i].pan(0, panStep, c.windowLayout(i))
+	}
+}
+
+// zoomWindow zooms a window in by factor, or out for a factor below 1,
+// keeping the point x, y of the window, from 0 to 1, in place
+func (c *Client) zoomWindow(windowIndex int, factor, x, y float64) {
+	tx, ty := c.windowLayout(windowIndex).toTurned(x, y)
+	c.views[windowIndex] = c.views[windowIndex].zoomAt(factor, tx, ty)
+	c.resized[windowIndex] = time.Now() // Zoomed in frames are asked for at full size
+}
+
+// showNative switches a window to 1:1 centered on the part of the frame
+// under the pointer, to be panned from there
+func (c *Client) showNative(windowIndex int, window *glfw.Window) {
+	view := viewport{}
+	if width, height := window.GetSize(); width > 0 && height > 0 {
+		x, y := window.GetCursorPos()
+		tx, ty := c.windowLayout(windowIndex).toTurned(x/float64(width), y/float64(height))
+		view.x, view.y = min(max(tx, 0), 1)-0.5, min(max(ty, 0), 1)-0.5
+	}
+	c.scaling[windowIndex] = ScaleNative
+	c.views[windowIndex] = view
+	c.resized[windowIndex] = time.Now()
+	log.Printf("Window %d at 1:1, Ctrl+Alt+scroll or Ctrl+Alt+arrows to pan", windowIndex)
+}
+
+// windowScrolled returns the scroll callback of a monitor window: scrolling
+// with Ctrl held zooms around the pointer, with Ctrl and Alt held pans a
+// zoomed in or cropped frame, and otherwise scrolls on the server where the
+// pointer is. It runs on the main thread from glfw.PollEvents.
+func (c *Client) windowScrolled(windowIndex int) glfw.ScrollCallback {
+	return func(window *glfw.Window, xoff, yoff float64) {
+		control := window.GetKey(glfw.KeyLeftControl) == glfw.Press || window.GetKey(glfw.KeyRightControl) == glfw.Press
+		alt := window.GetKey(glfw.KeyLeftAlt) == glfw.Press || window.GetKey(glfw.KeyRightAlt) == glfw.Press
+		x, y := window.GetCursorPos()
+		switch {
+		case control && alt:
+			c.views[windowIndex] = c.views[windowIndex].pan(-xoff*scrollPan, -yoff*scrollPan, c.windowLayout(windowIndex))
+		case control:
+			width, height := window.GetSize()
+			if width <= 0 || height <= 0 || yoff == 0 {
+				return
+			}
+			c.zoomWindow(windowIndex, math.Pow(zoomStep, yoff), x/float64(width), y/float64(height))
+		default:
+			// GLFW scrolls left for positive x offsets, wheel events right
+			if serverMonitorID, mx, my, ok := c.serverPoint(windowIndex, window, x, y); ok {
+				c.sendMouseWheel(serverMonitorID, mx, my, int(-xoff*120), int(yoff*120))
+			}
+		}
+	}
+}
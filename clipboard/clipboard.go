@@ -0,0 +1,70 @@
+// Package clipboard implements text clipboard synchronization between the
+// UltraRDP client and server
+package clipboard
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Read returns the text currently on the system clipboard
+func Read() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("pbpaste")
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw")
+	case "linux":
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			cmd = exec.Command("wl-paste", "--no-newline")
+		} else {
+			cmd = exec.Command("xclip", "-selection", "clipboard", "-o")
+		}
+	default:
+		return "", fmt.Errorf("clipboard not supported on %s", runtime.GOOS)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// An empty clipboard is reported as an error by some tools
+		if strings.Contains(stderr.String(), "No selection") || strings.Contains(stderr.String(), "Nothing is copied") {
+			return "", nil
+		}
+		return "", err
+	}
+
+	text := string(out)
+	if runtime.GOOS == "windows" {
+		text = strings.TrimSuffix(text, "\r\n")
+	}
+	return text, nil
+}
+
+// Write puts text on the system clipboard
+func Write(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", "$input | Set-Clipboard")
+	case "linux":
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			cmd = exec.Command("wl-copy")
+		} else {
+			cmd = exec.Command("xclip", "-selection", "clipboard", "-i")
+		}
+	default:
+		return fmt.Errorf("clipboard not supported on %s", runtime.GOOS)
+	}
+
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
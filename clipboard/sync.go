@@ -0,0 +1,153 @@
+package clipboard
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// Owners of a clipboard update, used to break ties between concurrent copies
+const (
+	OwnerServer = 0x00
+	OwnerClient = 0x01
+)
+
+// DefaultHistorySize is the number of clipboard items kept in the history
+const DefaultHistorySize = 10
+
+// Update is a clipboard change sent between client and server
+type Update struct {
+	Sequence uint64 // Logical clock, increases with every copy on either side
+	Owner    byte   // Side that made the copy
+	Text     string
+}
+
+// Item is an entry in the clipboard history
+type Item struct {
+	Text  string
+	Owner byte
+	Time  time.Time
+}
+
+// Sync tracks clipboard ownership on one side of a session. Both sides keep a
+// logical clock that is advanced on every local copy and merged with every
+// update received, so the most recent copy wins. When both sides copy at the
+// same logical time the server's copy wins, which makes both sides converge
+// instead of overwriting each other back and forth.
+type Sync struct {
+	mutex       sync.Mutex
+	owner       byte   // The side this Sync runs on
+	sequence    uint64 // Logical clock
+	current     Update // Update currently on the clipboard
+	history     []Item
+	historySize int
+}
+
+// NewSync creates the clipboard state for one side of a session
+func NewSync(owner byte, historySize int) *Sync {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Sync{owner: owner, historySize: historySize}
+}
+
+// LocalChange records text found on the local clipboard. It returns the
+// update to send to the peer, or false if the text is already known (for
+// example because it was just applied from the peer).
+func (s *Sync) LocalChange(text string) (Update, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if text == s.current.Text {
+		return Update{}, false
+	}
+
+	s.sequence++
+	s.current = Update{Sequence: s.sequence, Owner: s.owner, Text: text}
+	s.remember(s.current)
+	return s.current, true
+}
+
+// RemoteUpdate merges an update from the peer. It returns true if the update
+// wins and its text should be written to the local clipboard.
+func (s *Sync) RemoteUpdate(u Update) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if u.Sequence > s.sequence {
+		s.sequence = u.Sequence
+	}
+
+	if u.Sequence < s.current.Sequence {
+		return false
+	}
+	if u.Sequence == s.current.Sequence && s.current.Owner == OwnerServer && u.Owner != OwnerServer {
+		return false
+	}
+	if u.Text == s.current.Text {
+		s.current = u
+		return false
+	}
+
+	s.current = u
+	s.remember(u)
+	return true
+}
+
+// Current returns the update currently on the clipboard and whether there is one
+func (s *Sync) Current() (Update, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.current, s.current.Sequence > 0
+}
+
+// History returns the most recent clipboard items, newest first
+func (s *Sync) History() []Item {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	items := make([]Item, len(s.history))
+	for i := range s.history {
+		items[i] = s.history[len(s.history)-1-i]
+	}
+	return items
+}
+
+// remember appends an update to the history. Must be called with mutex held.
+func (s *Sync) remember(u Update) {
+	// Move repeated items to the front instead of duplicating them
+	for i, item := range s.history {
+		if item.Text == u.Text {
+			s.history = append(s.history[:i], s.history[i+1:]...)
+			break
+		}
+	}
+
+	s.history = append(s.history, Item{Text: u.Text, Owner: u.Owner, Time: time.Now()})
+	if len(s.history) > s.historySize {
+		s.history = s.history[len(s.history)-s.historySize:]
+	}
+}
+
+// EncodeUpdate encodes a clipboard update packet payload
+func EncodeUpdate(u Update) []byte {
+	buf := make([]byte, 9+len(u.Text))
+	binary.LittleEndian.PutUint64(buf[0:8], u.Sequence)
+	buf[8] = u.Owner
+	copy(buf[9:], u.Text)
+	return buf
+}
+
+// DecodeUpdate decodes a clipboard update packet payload
+func DecodeUpdate(data []byte) (Update, error) {
+	if len(data) < 9 {
+		return Update{}, io.ErrUnexpectedEOF
+	}
+	return Update{
+		Sequence: binary.LittleEndian.Uint64(data[0:8]),
+		Owner:    data[8],
+		Text:     string(data[9:]),
+	}, nil
+}
@@ -0,0 +1,40 @@
+package clipboard
+
+import "testing"
+
+func TestConcurrentCopiesConverge(t *testing.T) {
+	server := NewSync(OwnerServer, 0)
+	client := NewSync(OwnerClient, 0)
+
+	// Both sides copy before seeing the other's update
+	serverUpdate, _ := server.LocalChange("from server")
+	clientUpdate, _ := client.LocalChange("from client")
+
+	if server.RemoteUpdate(clientUpdate) {
+		t.Fatalf("Server should keep its own copy on a tie")
+	}
+	if !client.RemoteUpdate(serverUpdate) {
+		t.Fatalf("Client should adopt the server's copy on a tie")
+	}
+
+	// Applying the server's text must not be echoed back
+	if _, changed := client.LocalChange("from server"); changed {
+		t.Fatalf("Applied remote text was sent back")
+	}
+}
+
+func TestNewerCopyWins(t *testing.T) {
+	server := NewSync(OwnerServer, 0)
+	client := NewSync(OwnerClient, 0)
+
+	first, _ := server.LocalChange("one")
+	client.RemoteUpdate(first)
+	second, _ := client.LocalChange("two")
+
+	if !server.RemoteUpdate(second) {
+		t.Fatalf("Newer client copy should win")
+	}
+	if history := server.History(); len(history) != 2 || history[0].Text != "two" {
+		t.Fatalf("Unexpected history: %+v", history)
+	}
+}
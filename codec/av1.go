@@ -0,0 +1,23 @@
+package codec
+
+// AV1 temporal units travel in the low overhead bitstream format: OBUs
+// with size fields, the first being a temporal delimiter. Keyframes carry
+// the sequence header.
+const obuTemporalDelimiter = 2
+
+// isTemporalUnit reports whether a frame starts with an AV1 temporal
+// delimiter OBU
+func isTemporalUnit(frame []byte) bool {
+	if len(frame) < 2 {
+		return false
+	}
+	header := frame[0]
+	forbidden, obuType, hasSize := header>>7, header>>3&0xF, header>>1&1
+	return forbidden == 0 && obuType == obuTemporalDelimiter && hasSize == 1 && frame[1] == 0
+}
+
+// IsVideo reports whether a frame belongs to a video codec and depends on
+// earlier frames, as opposed to a JPEG image that can be shown on its own
+func IsVideo(frame []byte) bool {
+	return IsAnnexB(frame) || isTemporalUnit(frame)
+}
@@ -0,0 +1,214 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// probeSize is the frame size backends use to check for a working
+	// encoder
+	probeSize = 256
+
+	// hardwareFrameRate is the frame rate hardware rate control plans for,
+	// the capture loops' full rate
+	hardwareFrameRate = 30
+
+	// minBitsPerPixel and maxBitsPerPixel are the bits per pixel and frame
+	// hardware encoders target at the lowest and highest quality
+	minBitsPerPixel = 0.02
+	maxBitsPerPixel = 0.25
+)
+
+// bitrateFor maps a quality (1-100) to a target bitrate in bit/s for
+// frames of the given size, for encoders with bitrate based rate control
+func bitrateFor(quality, width, height int) int {
+	bitsPerPixel := minBitsPerPixel + (maxBitsPerPixel-minBitsPerPixel)*float64(quality)/100
+	return int(bitsPerPixel * float64(width*height*hardwareFrameRate))
+}
+
+// bitrateChanged reports whether a bitrate differs enough from the current
+// one to reopen an encoder that can't change it in place. Reopening costs
+// a keyframe, so the small steps of the bandwidth limiter are ignored.
+func bitrateChanged(current, bitrate int) bool {
+	return bitrate*4 < current*3 || bitrate*3 > current*4
+}
+
+// probe checks once whether a backend's hardware or library is present
+type probe struct {
+	check       func() bool
+	once        sync.Once
+	isAvailable bool
+}
+
+func (p *probe) available() bool {
+	p.once.Do(func() {
+		p.isAvailable = p.check()
+	})
+	return p.isAvailable
+}
+
+// encoderBackend is one implementation of a codec's encoder, e.g. a GPU
+// vendor's hardware encoder. Platform files register their backends in
+// init; a codec can be encoded if any of its backends is available.
+type encoderBackend struct {
+	probe
+	name     string
+	codec    ID
+	priority int // Backends with a higher priority are tried first
+	create   func(width, height int) (Encoder, error)
+}
+
+// decoderBackend is one implementation of a codec's decoder
+type decoderBackend struct {
+	probe
+	name     string
+	codec    ID
+	priority int
+	create   func() (Decoder, error)
+}
+
+// goBackend is the name of the codecs implemented in Go, JPEG, PNG and
+// Regions. They are registered like any backend, at priority 0, but can't
+// be selected with UseEncoder nor be excluded by it.
+const goBackend = "go"
+
+var (
+	// backends are the registered encoder backends, most preferred first
+	backends []*encoderBackend
+
+	// decoders are the registered decoder backends, most preferred first
+	decoders []*decoderBackend
+
+	// encoderName restricts encoding to one backend, empty for any
+	encoderName string
+)
+
+// RegisterEncoder adds an encoder backend for a codec, tried before those
+// registered with a lower priority. available reports whether the backend's
+// hardware or library is present; it is called once, when the codec is
+// first used. Backends must be registered before, e.g. in init.
+func RegisterEncoder(priority int, name string, id ID, available func() bool, create func(width, height int) (Encoder, error)) {
+	backends = append(backends, &encoderBackend{probe: probe{check: available}, name: name, codec: id, priority: priority, create: create})
+	// Init functions run in file name order, not in order of preference
+	sort.SliceStable(backends, func(i, j int) bool {
+		return backends[i].priority > backends[j].priority
+	})
+}
+
+// RegisterDecoder adds a decoder backend for a codec, see RegisterEncoder
+func RegisterDecoder(priority int, name string, id ID, available func() bool, create func() (Decoder, error)) {
+	decoders = append(decoders, &decoderBackend{probe: probe{check: available}, name: name, codec: id, priority: priority, create: create})
+	sort.SliceStable(decoders, func(i, j int) bool {
+		return decoders[i].priority > decoders[j].priority
+	})
+}
+
+// always is the availability of backends that need nothing from the
+// platform
+func always() bool { return true }
+
+// usable reports whether a backend may encode a codec
+func (b *encoderBackend) usable(id ID) bool {
+	if b.codec != id || encoderName != "" && b.name != encoderName && b.name != goBackend {
+		return false
+	}
+	return b.available()
+}
+
+// UseEncoder restricts video codecs to the named encoder backend, e.g.
+// "nvenc"; "auto" or an empty name allows every available backend. JPEG,
+// PNG and Regions are always encoded in Go.
+func UseEncoder(name string) error {
+	if name == "" || name == "auto" {
+		encoderName = ""
+		return nil
+	}
+	for _, b := range backends {
+		if b.name != goBackend && strings.EqualFold(b.name, name) {
+			encoderName = b.name
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown encoder %q, this build has: %s", name, strings.Join(EncoderBackends(), ", "))
+}
+
+// EncoderBackends returns the names of the encoder backends compiled in,
+// whether or not their hardware is present
+func EncoderBackends() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, b := range backends {
+		if b.name != goBackend && !seen[b.name] {
+			seen[b.name] = true
+			names = append(names, b.name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{"none"}
+	}
+	return names
+}
+
+// NewEncoder creates an encoder for frames of the given size with the first
+// usable backend of a codec that succeeds, see UseEncoder
+func NewEncoder(id ID, width, height int) (Encoder, error) {
+	var errs []error
+	for _, b := range backends {
+		if !b.usable(id) {
+			continue
+		}
+		encoder, err := b.create(width, height)
+		if err == nil {
+			return encoder, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%s: %w", id, ErrUnsupported)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// CanEncode reports whether this platform can encode with a codec
+func CanEncode(id ID) bool {
+	for _, b := range backends {
+		if b.usable(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewDecoder creates a decoder with the first available backend of a codec
+// that succeeds
+func NewDecoder(id ID) (Decoder, error) {
+	var errs []error
+	for _, b := range decoders {
+		if b.codec != id || !b.available() {
+			continue
+		}
+		decoder, err := b.create()
+		if err == nil {
+			return decoder, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%s: %w", id, ErrUnsupported)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// CanDecode reports whether this platform can decode a codec
+func CanDecode(id ID) bool {
+	for _, b := range decoders {
+		if b.codec == id && b.available() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+// Package codec encodes and decodes video frames. JPEG is always
+// available; video codecs such as H.264 use the platform's hardware
+// encoder and decoder and are only offered where those exist. A codec may
+// have several encoder backends, e.g. VideoToolbox on macOS and NVENC on
+// NVIDIA GPUs, registered with RegisterEncoder and RegisterDecoder; the
+// codecs implemented in Go are registered the same way.
+//
+// Servers and clients negotiate the codec: after the handshake the client
+// lists the codecs it can decode, most preferred first, and the server
+// answers with the codec it chose for each monitor. JPEG frames are
+// self-identifying and may arrive whatever codec was selected, e.g. the
+// cached frame a client is shown on connect, and so are PNG frames for
+// clients that offered PNG.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+)
+
+// ID identifies a codec on the wire
+type ID byte
+
+// Codecs
+const (
+	JPEG ID = 0
+	H264 ID = 1
+	HEVC ID = 2
+	AV1  ID = 3
+	VP8  ID = 4
+	VP9  ID = 5
+
+	// Lossless sends the raw pixels compressed with zstd, see lossless.go
+	Lossless ID = 6
+
+	// WebP and WebPLossless encode every frame as a WebP image, like JPEG
+	// but smaller at the same quality
+	WebP         ID = 7
+	WebPLossless ID = 8
+
+	// PNG encodes every frame as a PNG image, for text that JPEG blurs
+	PNG ID = 9
+
+	// Regions sends changed tiles only, video as JPEG and text as PNG,
+	// see regions.go
+	Regions ID = 10
+)
+
+// ErrUnsupported is returned for codecs this platform or build can't
+// encode or decode
+var ErrUnsupported = errors.New("codec not supported on this platform")
+
+// names maps codec IDs to the names used in flags and logs
+var names = map[ID]string{
+	JPEG: "jpeg",
+	H264: "h264",
+	HEVC: "hevc",
+	AV1:  "av1",
+	VP8:  "vp8",
+	VP9:  "vp9",
+
+	Lossless:     "lossless",
+	WebP:         "webp",
+	WebPLossless: "webp-lossless",
+	PNG:          "png",
+	Regions:      "regions",
+}
+
+// String returns the codec's name
+func (id ID) String() string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("codec(%d)", byte(id))
+}
+
+// Predictive reports whether frames of the codec depend on earlier ones,
+// so a lost frame damages the picture until the next keyframe
+func (id ID) Predictive() bool {
+	switch id {
+	case H264, HEVC, AV1, VP8, VP9, Lossless, Regions:
+		return true
+	}
+	return false
+}
+
+// Parse returns the codec with the given name
+func Parse(name string) (ID, error) {
+	for id, n := range names {
+		if strings.EqualFold(n, name) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown codec %q", name)
+}
+
+// ParseList parses a comma separated list of codec names, most preferred
+// first. "auto" selects every codec available for the direction, see
+// Encoders and Decoders.
+func ParseList(list string, decode bool) ([]ID, error) {
+	if list == "" || list == "auto" {
+		if decode {
+			return Decoders(), nil
+		}
+		return Encoders(), nil
+	}
+
+	var ids []ID
+	for _, name := range strings.Split(list, ",") {
+		id, err := Parse(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if decode && !CanDecode(id) || !decode && !CanEncode(id) {
+			return nil, fmt.Errorf("%s: %w", id, ErrUnsupported)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Encoder compresses the frames of one monitor
+type Encoder interface {
+	// Codec returns the codec the encoder produces
+	Codec() ID
+	// Encode compresses a frame. Video codecs return one access unit,
+	// which may depend on earlier ones.
+	Encode(img image.Image) ([]byte, error)
+	// SetQuality sets the quality (1-100) of the following frames
+	SetQuality(quality int)
+	// RequestKeyframe makes the next frame decodable on its own
+	RequestKeyframe()
+	// Close releases the encoder
+	Close() error
+}
+
+// Decoder decompresses the frames of one monitor
+type Decoder interface {
+	// Codec returns the codec the decoder reads
+	Codec() ID
+	// Decode decompresses a frame. It returns a nil image without an
+	// error if the frame produced no picture yet, e.g. while waiting for
+	// a keyframe.
+	Decode(data []byte) (image.Image, error)
+	// Close releases the decoder
+	Close() error
+}
+
+// preference orders the codecs by bandwidth at the same quality, best
+// first, except that AV1 and the VP codecs come after the codecs with
+// widespread hardware support as they're mostly encoded in software.
+// Clients on slow links can put them first with -codec. The opt-in codecs
+// come last but for JPEG, the fallback.
+var preference = []ID{HEVC, H264, AV1, VP9, VP8, WebP, Regions, Lossless, WebPLossless, PNG, JPEG}
+
+// optIn are the codecs clients only get when they ask for them, e.g.
+// Lossless which needs many times the bandwidth of the others. Servers
+// allow them by default.
+var optIn = []ID{Regions, Lossless, WebPLossless, PNG}
+
+// Encoders returns the codecs this platform can encode, best first
+func Encoders() []ID {
+	var ids []ID
+	for _, id := range preference {
+		if CanEncode(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Decoders returns the codecs this platform can decode, best first. Opt-in
+// codecs are left out, except that PNG is offered after JPEG for the frames
+// of text servers may send as PNG, see IsText.
+func Decoders() []ID {
+	var ids []ID
+	for _, id := range preference {
+		if CanDecode(id) && !slices.Contains(optIn, id) {
+			ids = append(ids, id)
+		}
+	}
+	return append(ids, PNG)
+}
+
+// Select picks the codec to send a client: the first of the client's
+// codecs, in its order of preference, that the server allows. JPEG is the
+// fallback every client understands.
+func Select(clientCodecs, serverCodecs []ID) ID {
+	for _, id := range clientCodecs {
+		for _, allowed := range serverCodecs {
+			if id == allowed {
+				return id
+			}
+		}
+	}
+	return JPEG
+}
+
+// IsJPEG reports whether a frame is a JPEG image, which starts with the
+// SOI marker
+func IsJPEG(frame []byte) bool {
+	return len(frame) >= 2 && frame[0] == 0xFF && frame[1] == 0xD8
+}
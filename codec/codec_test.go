@@ -0,0 +1,556 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/moderniselife/ultrardp/codec/internal/yuvrow"
+)
+
+func TestSplitAnnexB(t *testing.T) {
+	sps := []byte{0x67, 1, 2}
+	pps := []byte{0x68, 3}
+	idr := []byte{0x65, 0, 0, 4} // Contains zeros that aren't a start code
+	stream := appendAnnexB(nil, sps, pps)
+	stream = append(stream, 0, 0, 1) // Three byte start code
+	stream = append(stream, idr...)
+
+	nals := splitAnnexB(stream)
+	want := [][]byte{sps, pps, idr}
+	if len(nals) != len(want) {
+		t.Fatalf("got %d NAL units, want %d", len(nals), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(nals[i], want[i]) {
+			t.Errorf("NAL %d = %x, want %x", i, nals[i], want[i])
+		}
+	}
+
+	if !IsAnnexB(stream) || IsAnnexB([]byte{0xFF, 0xD8, 0}) {
+		t.Error("IsAnnexB doesn't tell H.264 from JPEG")
+	}
+	if !IsVideo(stream) || !IsVideo([]byte{0x12, 0, 0x0A, 0x0B}) || IsVideo([]byte{0xFF, 0xD8, 0}) {
+		t.Error("IsVideo doesn't tell H.264 and AV1 from JPEG")
+	}
+	if !IsKeyframe(stream) {
+		t.Error("access unit with an IDR slice is not a keyframe")
+	}
+	if IsKeyframe(appendAnnexB(nil, []byte{0x41, 9})) {
+		t.Error("access unit with a non-IDR slice is a keyframe")
+	}
+
+	avcc := appendAVCC(nil, sps, pps)
+	if want := []byte{0, 0, 0, 3, 0x67, 1, 2, 0, 0, 0, 2, 0x68, 3}; !bytes.Equal(avcc, want) {
+		t.Errorf("AVCC = %x, want %x", avcc, want)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		client, server []ID
+		want           ID
+	}{
+		{[]ID{H264, JPEG}, []ID{H264, JPEG}, H264},
+		{[]ID{HEVC, H264, JPEG}, []ID{H264, JPEG}, H264},
+		{[]ID{HEVC, H264, JPEG}, []ID{HEVC, H264, JPEG}, HEVC},
+		{[]ID{H264, JPEG}, []ID{JPEG}, JPEG},
+		{[]ID{JPEG}, []ID{H264, JPEG}, JPEG},
+		{nil, []ID{H264}, JPEG},
+	}
+	for _, test := range tests {
+		if got := Select(test.client, test.server); got != test.want {
+			t.Errorf("Select(%v, %v) = %v, want %v", test.client, test.server, got, test.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	ids, err := ParseList("jpeg", true)
+	if err != nil || len(ids) != 1 || ids[0] != JPEG {
+		t.Errorf("ParseList(jpeg) = %v, %v", ids, err)
+	}
+	if _, err := ParseList("mpeg2", true); err == nil {
+		t.Error("unknown codec accepted")
+	}
+	if ids, _ := ParseList("auto", false); len(ids) == 0 || ids[len(ids)-1] != JPEG {
+		t.Errorf("auto codecs %v don't end with JPEG", ids)
+	}
+}
+
+func TestRateControl(t *testing.T) {
+	if rc := (RateControl{}).settings(90, 1920, 1080, 51); rc.mode != RateCBR || rc.bitrate != bitrateFor(90, 1920, 1080) {
+		t.Errorf("default rate control = %+v, want CBR from the quality", rc)
+	}
+	if rc := (RateControl{Mode: RateVBR, Bitrate: 8_000_000}).settings(90, 1920, 1080, 51); rc.mode != RateVBR || rc.bitrate != 8_000_000 {
+		t.Errorf("VBR rate control = %+v, want the set bitrate", rc)
+	}
+	low := (RateControl{Mode: RateCQ}).settings(10, 1920, 1080, 51)
+	high := (RateControl{Mode: RateCQ}).settings(100, 1920, 1080, 51)
+	if high.qp != 17 || low.qp <= high.qp || low.qp > 51 {
+		t.Errorf("CQ quantizers %d at quality 10 and %d at 100", low.qp, high.qp)
+	}
+	if m, err := ParseRateMode("VBR"); err != nil || m != RateVBR {
+		t.Errorf("ParseRateMode(VBR) = %v, %v", m, err)
+	}
+	if _, err := ParseRateMode("abr"); err == nil {
+		t.Error("unknown rate control mode accepted")
+	}
+}
+
+func TestJPEGRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+
+	encoder, err := NewEncoder(JPEG, 32, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	frame, err := encoder.Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsJPEG(frame) {
+		t.Fatal("encoded frame doesn't start with a JPEG SOI marker")
+	}
+
+	decoder, err := NewDecoder(JPEG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decoder.Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("decoded bounds %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+}
+
+func TestBGRA(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 2, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+	sub := img.SubImage(image.Rect(1, 2, 3, 4))
+
+	pix := toBGRA(sub)
+	if want := []byte{30, 20, 10, 255}; !bytes.Equal(pix[:4], want) {
+		t.Errorf("first pixel = %v, want %v", pix[:4], want)
+	}
+	back := fromBGRA(pix, 2, 2)
+	if got := back.RGBAAt(0, 0); got != (color.RGBA{R: 10, G: 20, B: 30, A: 255}) {
+		t.Errorf("round trip pixel = %v", got)
+	}
+}
+
+// fakeEncoder is an encoder of a test backend
+type fakeEncoder struct {
+	jpegEncoder
+	backend string
+}
+
+func TestEncoderBackends(t *testing.T) {
+	saved, savedName := backends, encoderName
+	defer func() { backends, encoderName = saved, savedName }()
+	backends = slices.DeleteFunc(slices.Clone(saved), func(b *encoderBackend) bool {
+		return b.name != goBackend
+	})
+
+	register := func(priority int, name string, id ID, available bool, err error) {
+		RegisterEncoder(priority, name, id, func() bool { return available }, func(width, height int) (Encoder, error) {
+			if err != nil {
+				return nil, err
+			}
+			return &fakeEncoder{backend: name}, nil
+		})
+	}
+	register(1, "software", H264, true, nil)
+	register(3, "missing", H264, false, nil)
+	register(2, "broken", H264, true, ErrUnsupported)
+	register(4, "other", HEVC, true, nil)
+
+	if !CanEncode(H264) {
+		t.Fatal("H.264 can't be encoded")
+	}
+	encoder, err := NewEncoder(H264, 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := encoder.(*fakeEncoder).backend; got != "software" {
+		t.Errorf("encoder from backend %q, want the first that works", got)
+	}
+
+	if err := UseEncoder("broken"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncoder(H264, 16, 16); err == nil {
+		t.Error("broken backend created an encoder")
+	}
+	if err := UseEncoder("missing"); err != nil {
+		t.Fatal(err)
+	}
+	if CanEncode(H264) {
+		t.Error("unavailable backend can encode")
+	}
+	if _, err := NewEncoder(JPEG, 16, 16); err != nil {
+		t.Errorf("JPEG restricted to another backend: %v", err)
+	}
+	if err := UseEncoder(goBackend); err == nil {
+		t.Error("Go codecs selectable as encoder backend")
+	}
+	if err := UseEncoder("nonexistent"); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
+
+func TestNV12(t *testing.T) {
+	// The odd column is cropped
+	img := image.NewRGBA(image.Rect(0, 0, 5, 2))
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			img.Set(x, y, color.RGBA{A: 255})
+			img.Set(x+2, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+
+	nv12 := toNV12(img)
+	if len(nv12) != 4*2*3/2 {
+		t.Fatalf("NV12 frame is %d bytes, want %d", len(nv12), 12)
+	}
+	if nv12[0] != 16 || nv12[3] != 235 {
+		t.Errorf("black and white luma = %d and %d, want 16 and 235", nv12[0], nv12[3])
+	}
+	if want := []byte{128, 128, 128, 128}; !bytes.Equal(nv12[8:], want) {
+		t.Errorf("gray chroma = %v, want %v", nv12[8:], want)
+	}
+}
+
+func TestYUV420RoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	want := color.RGBA{R: 200, G: 100, B: 50, A: 255}
+	for i := 0; i < 4; i++ {
+		img.Set(i%2, i/2, want)
+	}
+
+	frame := toYUV420(img)
+	back := fromYUV420(frame.y, frame.cb, frame.cr, 2, 1, 2, 2)
+	got := back.RGBAAt(1, 1)
+	for _, diff := range []int{int(got.R) - int(want.R), int(got.G) - int(want.G), int(got.B) - int(want.B)} {
+		if diff < -3 || diff > 3 {
+			t.Fatalf("round trip color = %v, want about %v", got, want)
+		}
+	}
+}
+
+func TestYUV420MatchesGeneric(t *testing.T) {
+	// Widths that leave pixels to the Go code after the SIMD groups
+	for _, width := range []int{2, 6, 34, 1922} {
+		img := image.NewRGBA(image.Rect(0, 0, width, 1100))
+		for i := range img.Pix {
+			img.Pix[i] = byte(i * 7919 % 251)
+		}
+
+		frame := toYUV420(img)
+		want := yuv420{width: width, height: 1100}
+		want.y = make([]byte, len(frame.y))
+		want.cb = make([]byte, len(frame.cb))
+		want.cr = make([]byte, len(frame.cr))
+		for y := 0; y < want.height; y += 2 {
+			upper, lower := img.Pix[y*img.Stride:], img.Pix[(y+1)*img.Stride:]
+			yuvrow.LumaGeneric(want.y[y*width:(y+1)*width], upper)
+			yuvrow.LumaGeneric(want.y[(y+1)*width:(y+2)*width], lower)
+			c := y / 2 * width / 2
+			yuvrow.ChromaGeneric(want.cb[c:c+width/2], want.cr[c:c+width/2], upper, lower)
+		}
+		if !bytes.Equal(frame.y, want.y) || !bytes.Equal(frame.cb, want.cb) || !bytes.Equal(frame.cr, want.cr) {
+			t.Fatalf("width %d: conversion differs from the generic one", width)
+		}
+	}
+}
+
+func BenchmarkYUV420(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 3840, 2160))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7919 % 251)
+	}
+	b.SetBytes(int64(len(img.Pix)))
+	for i := 0; i < b.N; i++ {
+		toYUV420(img)
+	}
+}
+
+func TestHEVCNALType(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0C}
+	sps := []byte{0x42, 0x01, 0x01}
+	pps := []byte{0x44, 0x01, 0xC1}
+	idr := []byte{0x26, 0x01, 0xAF}
+	want := []byte{hevcNALVPS, hevcNALSPS, hevcNALPPS, 19}
+	for i, nal := range splitAnnexB(appendAnnexB(nil, vps, sps, pps, idr)) {
+		if got := hevcNALType(nal); got != want[i] {
+			t.Errorf("NAL %d has type %d, want %d", i, got, want[i])
+		}
+	}
+}
+
+func TestJPEGStrips(t *testing.T) {
+	// A height that isn't a multiple of the MCU size leaves a short last
+	// strip
+	img := image.NewRGBA(image.Rect(0, 0, 100, 150))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7 % 251)
+	}
+
+	var whole bytes.Buffer
+	if err := jpeg.Encode(&whole, img, &jpeg.Options{Quality: 80}); err != nil {
+		t.Fatal(err)
+	}
+	want, err := jpeg.Decode(&whole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strips []bytes.Buffer
+	for _, n := range []int{2, 3, 10, 20} {
+		var joined bytes.Buffer
+		if err := encodeJPEGStrips(&joined, img, 80, n, &strips); err != nil {
+			t.Fatalf("%d strips: %v", n, err)
+		}
+		got, err := jpeg.Decode(&joined)
+		if err != nil {
+			t.Fatalf("%d strips: decoding: %v", n, err)
+		}
+		if got.Bounds() != want.Bounds() {
+			t.Fatalf("%d strips: bounds %v, want %v", n, got.Bounds(), want.Bounds())
+		}
+		// Restart markers don't change the decoded pixels
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d strips: decoded frame differs from the frame encoded whole", n)
+		}
+	}
+}
+
+// copyCompressor stores data as it is
+type copyCompressor struct{}
+
+func (copyCompressor) Compress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+func (copyCompressor) Decompress(dst, src []byte) error {
+	if len(src) != len(dst) {
+		return errLosslessFrame
+	}
+	copy(dst, src)
+	return nil
+}
+
+func (copyCompressor) Close() error { return nil }
+
+func TestLossless(t *testing.T) {
+	encoder := newLosslessEncoder(copyCompressor{})
+	decoder := newLosslessDecoder(copyCompressor{})
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	key, err := encoder.Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Pix[5] = 99
+	delta, err := encoder.Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key[0] != losslessKeyframe || delta[0] != losslessDelta {
+		t.Fatalf("frame kinds %c, %c, want a keyframe and a delta", key[0], delta[0])
+	}
+	if IsJPEG(delta) || IsVideo(delta) {
+		t.Error("lossless frame mistaken for another codec's")
+	}
+
+	// A delta can't be decoded before a keyframe
+	if got, err := decoder.Decode(delta); got != nil || err != nil {
+		t.Fatalf("delta before keyframe = %v, %v, want no picture", got, err)
+	}
+	if _, err := decoder.Decode(key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decoder.Decode(delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.(*image.RGBA).Pix, img.Pix) {
+		t.Error("decoded pixels differ from the encoded frame")
+	}
+}
+
+func TestPNG(t *testing.T) {
+	// Few colors are written with a palette
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i / 4 % 3 * 100)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	encoder, err := NewEncoder(PNG, 64, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := encoder.Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPNG(frame) || IsJPEG(frame) || IsVideo(frame) {
+		t.Fatal("encoded frame isn't recognized as PNG")
+	}
+
+	decoder, err := NewDecoder(PNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decoder.Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.(*image.Paletted); !ok {
+		t.Errorf("decoded %T, want a paletted image", decoded)
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			if got, want := color.RGBAModel.Convert(decoded.At(x, y)), img.At(x, y); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestIsText(t *testing.T) {
+	// Dark glyph strokes on a white background
+	text := image.NewRGBA(image.Rect(0, 0, 320, 160))
+	noise := image.NewRGBA(text.Rect)
+	for i := range text.Pix {
+		text.Pix[i] = 255
+		noise.Pix[i] = byte(i * 7919 % 251)
+	}
+	for y := 0; y < 160; y += 4 {
+		for x := y % 8; x < 320; x += 6 {
+			text.Set(x, y, color.RGBA{30, 30, 30, 255})
+			text.Set(x, y+1, color.RGBA{120, 120, 120, 255})
+		}
+	}
+
+	if !IsText(text) {
+		t.Error("IsText(text) = false")
+	}
+	if IsText(noise) {
+		t.Error("IsText(noise) = true")
+	}
+}
+
+func TestRegions(t *testing.T) {
+	encoder := newRegionsEncoder()
+	decoder := newRegionsDecoder()
+	img := image.NewRGBA(image.Rect(0, 0, 200, 130))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i / 4 % 5 * 50)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+
+	// The keyframe is all text, sent losslessly
+	key, err := encoder.Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsJPEG(key) || IsPNG(key) || IsVideo(key) {
+		t.Error("regions frame mistaken for another codec's")
+	}
+	got, err := decoder.Decode(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.(*image.RGBA).Pix, img.Pix) {
+		t.Fatal("decoded keyframe differs from the encoded frame")
+	}
+
+	// A tile that changes with every frame becomes video, a text change
+	// waits for the next text frame
+	var frames [][]byte
+	for i := 1; i <= 8; i++ {
+		img.Pix[0] = byte(i)
+		if i == 5 {
+			img.Pix[img.PixOffset(150, 100)] = 1
+		}
+		frame, err := encoder.Encode(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+		if i == 5 && !encoder.Deferred() {
+			t.Error("text change wasn't deferred")
+		}
+	}
+	if !encoder.tiles[0].video || encoder.tiles[len(encoder.tiles)-1].video {
+		t.Fatal("tiles misclassified")
+	}
+	if encoder.Deferred() {
+		t.Error("text change still deferred")
+	}
+
+	for _, frame := range frames {
+		if got, err = decoder.Decode(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got.At(150, 100) != img.At(150, 100) {
+		t.Errorf("text pixel %v, want %v", got.At(150, 100), img.At(150, 100))
+	}
+}
+
+func TestRegionOfInterest(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7919 % 251)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	encoder := newJPEGEncoder()
+	plain, err := encoder.Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder.SetRegionOfInterest(image.Rect(64, 64, 192, 192))
+	focused, err := encoder.Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(focused) >= len(plain) {
+		t.Errorf("frame with a region of interest is %d bytes, without %d", len(focused), len(plain))
+	}
+
+	// Blocks inside the region are encoded as they were
+	want, err := jpeg.Decode(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := jpeg.Decode(bytes.NewReader(focused))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 80; y < 176; y++ {
+		for x := 80; x < 176; x++ {
+			if got.At(x, y) != want.At(x, y) {
+				t.Fatalf("pixel (%d, %d) in the region changed", x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+//go:build cgo && dav1d
+
+package codec
+
+/*
+#cgo pkg-config: dav1d
+#include <errno.h>
+#include <stdlib.h>
+#include <string.h>
+#include <dav1d/dav1d.h>
+
+// dv_decoder is a dav1d context and the last picture it produced
+typedef struct {
+	Dav1dContext *context;
+	Dav1dPicture picture;
+	int have_picture;
+} dv_decoder;
+
+static int dv_open(dv_decoder *d) {
+	Dav1dSettings settings;
+	dav1d_default_settings(&settings);
+	// Output every frame as soon as it's decoded
+	settings.max_frame_delay = 1;
+	return dav1d_open(&d->context, &settings);
+}
+
+static void dv_release_picture(dv_decoder *d) {
+	if (d->have_picture) {
+		dav1d_picture_unref(&d->picture);
+		d->have_picture = 0;
+	}
+}
+
+static void dv_close(dv_decoder *d) {
+	dv_release_picture(d);
+	dav1d_close(&d->context);
+}
+
+// dv_decode decodes a temporal unit, keeping the last picture it produced
+static int dv_decode(dv_decoder *d, const uint8_t *frame, size_t len) {
+	dv_release_picture(d);
+
+	Dav1dData data = {0};
+	uint8_t *buf = dav1d_data_create(&data, len);
+	if (buf == NULL) {
+		return DAV1D_ERR(ENOMEM);
+	}
+	memcpy(buf, frame, len);
+
+	// dav1d takes the data in pieces when its picture queue is full
+	while (data.sz > 0) {
+		int err = dav1d_send_data(d->context, &data);
+		if (err < 0 && err != DAV1D_ERR(EAGAIN)) {
+			dav1d_data_unref(&data);
+			return err;
+		}
+
+		Dav1dPicture picture = {0};
+		err = dav1d_get_picture(d->context, &picture);
+		if (err == 0) {
+			dv_release_picture(d);
+			d->picture = picture;
+			d->have_picture = 1;
+		} else if (err != DAV1D_ERR(EAGAIN)) {
+			dav1d_data_unref(&data);
+			return err;
+		}
+	}
+	return 0;
+}
+
+static int dv_is_i420(dv_decoder *d) {
+	return d->picture.p.layout == DAV1D_PIXEL_LAYOUT_I420 && d->picture.p.bpc == 8;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+func init() {
+	RegisterDecoder(5, "dav1d", AV1, always, func() (Decoder, error) {
+		return newDav1dDecoder()
+	})
+}
+
+// dav1dDecoder decodes AV1 in software with dav1d, which is fast enough
+// for several monitors on any recent CPU
+type dav1dDecoder struct {
+	session *C.dv_decoder
+}
+
+func newDav1dDecoder() (*dav1dDecoder, error) {
+	d := &dav1dDecoder{session: (*C.dv_decoder)(C.calloc(1, C.sizeof_dv_decoder))}
+	if err := C.dv_open(d.session); err < 0 {
+		C.free(unsafe.Pointer(d.session))
+		return nil, fmt.Errorf("failed to open dav1d decoder: error %d", int(err))
+	}
+	return d, nil
+}
+
+func (d *dav1dDecoder) Codec() ID { return AV1 }
+
+func (d *dav1dDecoder) Decode(data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err := C.dv_decode(d.session, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data))); err < 0 {
+		return nil, fmt.Errorf("dav1d decoding failed: error %d", int(err))
+	}
+
+	// Frames before the first keyframe produce no picture
+	if d.session.have_picture == 0 {
+		return nil, nil
+	}
+	if C.dv_is_i420(d.session) == 0 {
+		return nil, errors.New("dav1d picture isn't 8 bit 4:2:0")
+	}
+
+	picture := &d.session.picture
+	width, height := int(picture.p.w), int(picture.p.h)
+	yStride, cStride := int(picture.stride[0]), int(picture.stride[1])
+	y := unsafe.Slice((*byte)(picture.data[0]), yStride*height)
+	cb := unsafe.Slice((*byte)(picture.data[1]), cStride*((height+1)/2))
+	cr := unsafe.Slice((*byte)(picture.data[2]), cStride*((height+1)/2))
+	return fromYUV420(y, cb, cr, yStride, cStride, width, height), nil
+}
+
+func (d *dav1dDecoder) Close() error {
+	if d.session != nil {
+		C.dv_close(d.session)
+		C.free(unsafe.Pointer(d.session))
+		d.session = nil
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// H.264 NAL unit types used when converting between stream formats
+const (
+	nalIDR = 5 // Slice of an instantaneous decoder refresh (key) picture
+	nalSPS = 7 // Sequence parameter set
+	nalPPS = 8 // Picture parameter set
+)
+
+// H.264 access units travel in Annex B format: every NAL unit is preceded
+// by a 00 00 00 01 start code, and keyframes carry their SPS and PPS so a
+// decoder can start from any of them.
+var startCode = []byte{0, 0, 0, 1}
+
+// splitAnnexB splits an Annex B byte stream into NAL units, accepting
+// three and four byte start codes
+func splitAnnexB(data []byte) [][]byte {
+	var nals [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			i++
+			continue
+		}
+		if start >= 0 {
+			end := i
+			// The zero of a four byte start code belongs to it
+			if end > start && data[end-1] == 0 {
+				end--
+			}
+			nals = append(nals, data[start:end])
+		}
+		i += 3
+		start = i
+	}
+	if start >= 0 && start < len(data) {
+		nals = append(nals, data[start:])
+	}
+	return nals
+}
+
+// nalType returns the type of a NAL unit
+func nalType(nal []byte) byte {
+	if len(nal) == 0 {
+		return 0
+	}
+	return nal[0] & 0x1F
+}
+
+// IsKeyframe reports whether an H.264 access unit in Annex B format can be
+// decoded without earlier ones
+func IsKeyframe(au []byte) bool {
+	for _, nal := range splitAnnexB(au) {
+		if nalType(nal) == nalIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAnnexB reports whether a frame is an access unit of a video codec in
+// Annex B format, which starts with a start code
+func IsAnnexB(frame []byte) bool {
+	return bytes.HasPrefix(frame, startCode) || bytes.HasPrefix(frame, startCode[1:])
+}
+
+// appendAnnexB appends NAL units to an Annex B stream
+func appendAnnexB(dst []byte, nals ...[]byte) []byte {
+	for _, nal := range nals {
+		dst = append(dst, startCode...)
+		dst = append(dst, nal...)
+	}
+	return dst
+}
+
+// appendAVCC appends NAL units with the four byte big endian length
+// prefixes the platform decoders expect
+func appendAVCC(dst []byte, nals ...[]byte) []byte {
+	for _, nal := range nals {
+		dst = binary.BigEndian.AppendUint32(dst, uint32(len(nal)))
+		dst = append(dst, nal...)
+	}
+	return dst
+}
+
+// toBGRA returns the pixels of img as tightly packed BGRA rows, the layout
+// hardware encoders take
+func toBGRA(img image.Image) []byte {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pix := make([]byte, width*height*4)
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < height; y++ {
+			offset := rgba.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			src := rgba.Pix[offset : offset+width*4]
+			dst := pix[y*width*4 : (y+1)*width*4]
+			for x := 0; x < len(src); x += 4 {
+				dst[x], dst[x+1], dst[x+2], dst[x+3] = src[x+2], src[x+1], src[x], 255
+			}
+		}
+		return pix
+	}
+
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = byte(b>>8), byte(g>>8), byte(r>>8), 255
+			i += 4
+		}
+	}
+	return pix
+}
+
+// fromBGRA converts tightly packed BGRA rows to an image
+func fromBGRA(pix []byte, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i+3 < len(pix) && i+3 < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = pix[i+2], pix[i+1], pix[i], 255
+	}
+	return img
+}
@@ -0,0 +1,19 @@
+package codec
+
+// HEVC NAL unit types used when converting between stream formats. HEVC
+// access units travel in Annex B format like H.264 ones, with the video
+// parameter set added to the parameter sets of keyframes.
+const (
+	hevcNALVPS = 32 // Video parameter set
+	hevcNALSPS = 33 // Sequence parameter set
+	hevcNALPPS = 34 // Picture parameter set
+)
+
+// hevcNALType returns the type of an HEVC NAL unit, which unlike H.264 has
+// a two byte header with the type in the upper bits
+func hevcNALType(nal []byte) byte {
+	if len(nal) < 2 {
+		return 0
+	}
+	return nal[0] >> 1 & 0x3F
+}
@@ -0,0 +1,25 @@
+// Package yuvrow converts rows of RGBA pixels to BT.601 limited range
+// YCbCr, with SIMD where available. It is separate from package codec as Go
+// assembly can't be part of a package that uses cgo.
+package yuvrow
+
+// LumaGeneric converts a row of RGBA pixels to luma
+func LumaGeneric(dst, src []byte) {
+	for x := range dst {
+		r, g, b := int(src[x*4]), int(src[x*4+1]), int(src[x*4+2])
+		dst[x] = byte((66*r+129*g+25*b+128)>>8 + 16)
+	}
+}
+
+// ChromaGeneric converts two rows of RGBA pixels to Cb and Cr, taken from
+// the average of each 2x2 block
+func ChromaGeneric(cb, cr, upper, lower []byte) {
+	for x := range cb {
+		i := x * 8
+		r := (int(upper[i]) + int(upper[i+4]) + int(lower[i]) + int(lower[i+4])) / 4
+		g := (int(upper[i+1]) + int(upper[i+5]) + int(lower[i+1]) + int(lower[i+5])) / 4
+		b := (int(upper[i+2]) + int(upper[i+6]) + int(lower[i+2]) + int(lower[i+6])) / 4
+		cb[x] = byte((-38*r-74*g+112*b+128)>>8 + 128)
+		cr[x] = byte((112*r-94*g-18*b+128)>>8 + 128)
+	}
+}
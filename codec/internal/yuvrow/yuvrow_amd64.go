@@ -0,0 +1,34 @@
+//go:build !purego
+
+package yuvrow
+
+// lumaSSE2 converts a row of RGBA pixels to luma, 4 pixels at a time. The
+// length of dst must be a multiple of 4.
+//
+//go:noescape
+func lumaSSE2(dst, src []byte)
+
+// chromaSSE2 converts two rows of RGBA pixels to Cb and Cr, 2 samples at
+// a time. The length of cb must be even.
+//
+//go:noescape
+func chromaSSE2(cb, cr, upper, lower []byte)
+
+// Luma converts a row of RGBA pixels to luma. SSE2 is part of every amd64
+// CPU, the pixels past the last group of 4 are converted in Go.
+func Luma(dst, src []byte) {
+	n := len(dst) &^ 3
+	if n > 0 {
+		lumaSSE2(dst[:n], src[:n*4])
+	}
+	LumaGeneric(dst[n:], src[n*4:])
+}
+
+// Chroma converts two rows of RGBA pixels to Cb and Cr
+func Chroma(cb, cr, upper, lower []byte) {
+	n := len(cb) &^ 1
+	if n > 0 {
+		chromaSSE2(cb[:n], cr[:n], upper[:n*8], lower[:n*8])
+	}
+	ChromaGeneric(cb[n:], cr[n:], upper[n*8:], lower[n*8:])
+}
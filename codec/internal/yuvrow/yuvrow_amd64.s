@@ -0,0 +1,144 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// BT.601 limited range coefficients as 16-bit words per RGBA pixel, twice
+DATA lumaCoef<>+0x00(SB)/8, $0x0000001900810042 // 66, 129, 25, 0
+DATA lumaCoef<>+0x08(SB)/8, $0x0000001900810042
+GLOBL lumaCoef<>(SB), RODATA|NOPTR, $16
+
+DATA cbCoef<>+0x00(SB)/8, $0x00000070ffb6ffda // -38, -74, 112, 0
+DATA cbCoef<>+0x08(SB)/8, $0x00000070ffb6ffda
+GLOBL cbCoef<>(SB), RODATA|NOPTR, $16
+
+DATA crCoef<>+0x00(SB)/8, $0x0000ffeeffa20070 // 112, -94, -18, 0
+DATA crCoef<>+0x08(SB)/8, $0x0000ffeeffa20070
+GLOBL crCoef<>(SB), RODATA|NOPTR, $16
+
+// Rounding and the luma offset in one: 128 + 16<<8 per 32-bit lane
+DATA lumaBias<>+0x00(SB)/8, $0x0000108000001080
+DATA lumaBias<>+0x08(SB)/8, $0x0000108000001080
+GLOBL lumaBias<>(SB), RODATA|NOPTR, $16
+
+DATA chromaBias<>+0x00(SB)/8, $0x0000008000000080
+DATA chromaBias<>+0x08(SB)/8, $0x0000008000000080
+GLOBL chromaBias<>(SB), RODATA|NOPTR, $16
+
+// func lumaSSE2(dst, src []byte)
+TEXT ·lumaSSE2(SB), NOSPLIT, $0-48
+	MOVQ  dst_base+0(FP), DI
+	MOVQ  dst_len+8(FP), CX
+	MOVQ  src_base+24(FP), SI
+	PXOR  X7, X7
+	MOVOU lumaCoef<>(SB), X6
+	MOVOU lumaBias<>(SB), X5
+	SHRQ  $2, CX
+	JZ    lumaDone
+
+lumaLoop:
+	// Widen 4 pixels to words and weigh them: two sums per pixel
+	MOVOU     (SI), X0
+	MOVO      X0, X1
+	PUNPCKLBW X7, X0
+	PUNPCKHBW X7, X1
+	PMADDWL   X6, X0
+	PMADDWL   X6, X1
+
+	// Add each pixel's two sums and gather them in X0
+	MOVO       X0, X2
+	PSRLQ      $32, X2
+	PADDL      X2, X0
+	PSHUFD     $0x08, X0, X0
+	MOVO       X1, X3
+	PSRLQ      $32, X3
+	PADDL      X3, X1
+	PSHUFD     $0x08, X1, X1
+	PUNPCKLQDQ X1, X0
+
+	PADDL    X5, X0
+	PSRLL    $8, X0
+	PACKSSLW X0, X0
+	PACKUSWB X0, X0
+	MOVQ     X0, AX
+	MOVL     AX, (DI)
+
+	ADDQ $16, SI
+	ADDQ $4, DI
+	DECQ CX
+	JNZ  lumaLoop
+
+lumaDone:
+	RET
+
+// func chromaSSE2(cb, cr, upper, lower []byte)
+TEXT ·chromaSSE2(SB), NOSPLIT, $0-96
+	MOVQ  cb_base+0(FP), DI
+	MOVQ  cb_len+8(FP), CX
+	MOVQ  cr_base+24(FP), DX
+	MOVQ  upper_base+48(FP), SI
+	MOVQ  lower_base+72(FP), BX
+	PXOR  X7, X7
+	MOVOU cbCoef<>(SB), X6
+	MOVOU crCoef<>(SB), X5
+	MOVOU chromaBias<>(SB), X4
+	SHRQ  $1, CX
+	JZ    chromaDone
+
+chromaLoop:
+	// Add the two rows of 4 pixels as words
+	MOVOU     (SI), X0
+	MOVOU     (BX), X1
+	MOVO      X0, X2
+	PUNPCKLBW X7, X0
+	PUNPCKHBW X7, X2
+	MOVO      X1, X3
+	PUNPCKLBW X7, X1
+	PUNPCKHBW X7, X3
+	PADDW     X1, X0
+	PADDW     X3, X2
+
+	// Add the columns of each 2x2 block and average them
+	MOVO       X0, X1
+	PSRLO      $8, X1
+	PADDW      X1, X0
+	MOVO       X2, X3
+	PSRLO      $8, X3
+	PADDW      X3, X2
+	PUNPCKLQDQ X2, X0
+	PSRLW      $2, X0
+
+	// Weigh the averages for Cb and Cr, two sums per block each
+	MOVO    X0, X1
+	PMADDWL X6, X0
+	PMADDWL X5, X1
+
+	MOVO       X0, X2
+	PSRLQ      $32, X2
+	PADDL      X2, X0
+	PSHUFD     $0x08, X0, X0
+	MOVO       X1, X3
+	PSRLQ      $32, X3
+	PADDL      X3, X1
+	PSHUFD     $0x08, X1, X1
+	PUNPCKLQDQ X1, X0
+
+	// Cb of both blocks in the low bytes, Cr in the next two
+	PADDL    X4, X0
+	PSRAL    $8, X0
+	PADDL    X4, X0
+	PACKSSLW X0, X0
+	PACKUSWB X0, X0
+	MOVQ     X0, AX
+	MOVW     AX, (DI)
+	SHRQ     $16, AX
+	MOVW     AX, (DX)
+
+	ADDQ $16, SI
+	ADDQ $16, BX
+	ADDQ $2, DI
+	ADDQ $2, DX
+	DECQ CX
+	JNZ  chromaLoop
+
+chromaDone:
+	RET
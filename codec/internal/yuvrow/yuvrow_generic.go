@@ -0,0 +1,13 @@
+//go:build !amd64 || purego
+
+package yuvrow
+
+// Luma converts a row of RGBA pixels to luma
+func Luma(dst, src []byte) {
+	LumaGeneric(dst, src)
+}
+
+// Chroma converts two rows of RGBA pixels to Cb and Cr
+func Chroma(cb, cr, upper, lower []byte) {
+	ChromaGeneric(cb, cr, upper, lower)
+}
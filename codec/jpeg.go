@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"runtime"
+)
+
+// defaultJPEGQuality is used until SetQuality is called
+const defaultJPEGQuality = 90
+
+// jpegEncode and jpegDecode are the JPEG implementation, libjpeg-turbo in
+// builds with -tags turbojpeg, see turbojpeg.go, image/jpeg otherwise
+var (
+	jpegEncode = func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	jpegDecode = func(data []byte) (image.Image, error) {
+		return jpeg.Decode(bytes.NewReader(data))
+	}
+)
+
+func init() {
+	RegisterEncoder(0, goBackend, JPEG, always, func(width, height int) (Encoder, error) {
+		return newJPEGEncoder(), nil
+	})
+	RegisterDecoder(0, goBackend, JPEG, always, func() (Decoder, error) {
+		return jpegDecoder{}, nil
+	})
+}
+
+// EncodeJPEG encodes an image as a baseline JPEG with 4:2:0 chroma
+// subsampling, with libjpeg-turbo if the build includes it
+func EncodeJPEG(w io.Writer, img image.Image, quality int) error {
+	return jpegEncode(w, img, quality)
+}
+
+// DecodeJPEG decodes a JPEG image, with libjpeg-turbo if the build
+// includes it
+func DecodeJPEG(data []byte) (image.Image, error) {
+	return jpegDecode(data)
+}
+
+// jpegEncoder encodes every frame as a self-contained JPEG image. Large
+// frames are encoded in strips on every core, see encodeJPEGStrips.
+type jpegEncoder struct {
+	quality int
+	buf     bytes.Buffer
+	strips  []bytes.Buffer
+
+	// A JPEG image has one quality, so the frame outside the region of
+	// interest is smoothed to cost less, leaving the bandwidth limiter
+	// room to raise the quality
+	roi      image.Rectangle
+	smoothed *image.RGBA
+}
+
+func newJPEGEncoder() *jpegEncoder {
+	return &jpegEncoder{quality: defaultJPEGQuality}
+}
+
+func (e *jpegEncoder) Codec() ID { return JPEG }
+
+func (e *jpegEncoder) Encode(img image.Image) ([]byte, error) {
+	e.buf.Reset()
+	if roi := e.roi.Intersect(img.Bounds()); !roi.Empty() && roi != img.Bounds() {
+		e.smoothed = smoothOutside(e.smoothed, img, roi)
+		img = e.smoothed
+	}
+
+	var err error
+	if size := img.Bounds().Size(); size.X*size.Y >= parallelJPEGPixels {
+		err = encodeJPEGStrips(&e.buf, img, e.quality, runtime.GOMAXPROCS(0), &e.strips)
+	} else {
+		err = jpegEncode(&e.buf, img, e.quality)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The buffer is reused, callers keep the frame
+	return bytes.Clone(e.buf.Bytes()), nil
+}
+
+func (e *jpegEncoder) SetQuality(quality int) { e.quality = quality }
+
+func (e *jpegEncoder) SetRegionOfInterest(roi image.Rectangle) { e.roi = roi }
+
+// RequestKeyframe does nothing, every JPEG frame is a keyframe
+func (e *jpegEncoder) RequestKeyframe() {}
+
+func (e *jpegEncoder) Close() error { return nil }
+
+// jpegDecoder decodes JPEG frames
+type jpegDecoder struct{}
+
+func (jpegDecoder) Codec() ID { return JPEG }
+
+func (jpegDecoder) Decode(data []byte) (image.Image, error) {
+	return jpegDecode(data)
+}
+
+func (jpegDecoder) Close() error { return nil }
@@ -0,0 +1,147 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"sync"
+)
+
+const (
+	// parallelJPEGPixels is the frame size from which JPEG frames are
+	// encoded on several cores, smaller frames fit the frame budget on one
+	parallelJPEGPixels = 1920 * 1080
+
+	// jpegMCUSize is the size of the blocks the encoder codes together,
+	// 16x16 pixels as EncodeJPEG subsamples chroma 4:2:0
+	jpegMCUSize = 16
+
+	// jpegMaxRestartInterval is the largest number of MCUs between restart
+	// markers a DRI segment can hold
+	jpegMaxRestartInterval = 0xFFFF
+)
+
+// JPEG markers
+const (
+	jpegSOF0 = 0xC0
+	jpegRST0 = 0xD0
+	jpegEOI  = 0xD9
+	jpegSOS  = 0xDA
+	jpegDRI  = 0xDD
+)
+
+var errJPEGStructure = errors.New("unexpected JPEG structure")
+
+// subImager is implemented by the image types that can be cut into strips
+// without copying
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// encodeJPEGStrips encodes a frame as horizontal strips on several cores
+// and joins them into one baseline JPEG. Restart markers between the strips
+// reset the DC prediction the way each strip's encoder starts, so the
+// joined scan decodes exactly like a frame encoded in one go, by any JPEG
+// decoder. buffers holds the strips' encoded data between calls.
+func encodeJPEGStrips(dst *bytes.Buffer, img image.Image, quality, strips int, buffers *[]bytes.Buffer) error {
+	bounds := img.Bounds()
+	sub, ok := img.(subImager)
+	mcuColumns := (bounds.Dx() + jpegMCUSize - 1) / jpegMCUSize
+	mcuRows := (bounds.Dy() + jpegMCUSize - 1) / jpegMCUSize
+	strips = min(strips, mcuRows)
+	if !ok || strips < 2 {
+		return jpegEncode(dst, img, quality)
+	}
+
+	// Every strip but the last is the restart interval's MCU rows high
+	rowsPerStrip := (mcuRows + strips - 1) / strips
+	strips = (mcuRows + rowsPerStrip - 1) / rowsPerStrip
+	interval := mcuColumns * rowsPerStrip
+	if interval > jpegMaxRestartInterval {
+		return jpegEncode(dst, img, quality)
+	}
+
+	if len(*buffers) < strips {
+		*buffers = make([]bytes.Buffer, strips)
+	}
+	encoded := (*buffers)[:strips]
+	errs := make([]error, strips)
+	var wg sync.WaitGroup
+	for i := range encoded {
+		top := bounds.Min.Y + i*rowsPerStrip*jpegMCUSize
+		bottom := min(top+rowsPerStrip*jpegMCUSize, bounds.Max.Y)
+		strip := sub.SubImage(image.Rect(bounds.Min.X, top, bounds.Max.X, bottom))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			encoded[i].Reset()
+			errs[i] = jpegEncode(&encoded[i], strip, quality)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// The first strip's tables serve the whole frame, with its height
+	// patched and the restart interval added before the scan
+	tables, sos, scan, err := splitJPEG(encoded[0].Bytes())
+	if err != nil {
+		return err
+	}
+	start := dst.Len()
+	dst.Write(tables)
+	if err := setJPEGHeight(dst.Bytes()[start:], bounds.Dy()); err != nil {
+		return err
+	}
+	dst.Write([]byte{0xFF, jpegDRI, 0, 4, byte(interval >> 8), byte(interval)})
+	dst.Write(sos)
+	dst.Write(scan)
+	for i := 1; i < strips; i++ {
+		_, _, scan, err := splitJPEG(encoded[i].Bytes())
+		if err != nil {
+			return err
+		}
+		dst.Write([]byte{0xFF, byte(jpegRST0 + (i-1)%8)})
+		dst.Write(scan)
+	}
+	dst.Write([]byte{0xFF, jpegEOI})
+	return nil
+}
+
+// splitJPEG splits a baseline JPEG into the segments before the scan, the
+// scan's SOS header and its entropy coded data
+func splitJPEG(data []byte) (tables, sos, scan []byte, err error) {
+	if !IsJPEG(data) || !bytes.HasSuffix(data, []byte{0xFF, jpegEOI}) {
+		return nil, nil, nil, errJPEGStructure
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, nil, nil, errJPEGStructure
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data)-2 {
+			return nil, nil, nil, errJPEGStructure
+		}
+		if data[i+1] == jpegSOS {
+			return data[:i], data[i:end], data[end : len(data)-2], nil
+		}
+		i = end
+	}
+	return nil, nil, nil, errJPEGStructure
+}
+
+// setJPEGHeight sets the image height in the SOF0 segment of a JPEG's
+// tables
+func setJPEGHeight(tables []byte, height int) error {
+	for i := 2; i+4 <= len(tables); {
+		length := int(binary.BigEndian.Uint16(tables[i+2:]))
+		if tables[i+1] == jpegSOF0 && length >= 5 && i+7 <= len(tables) {
+			binary.BigEndian.PutUint16(tables[i+5:], uint16(height))
+			return nil
+		}
+		i += 2 + length
+	}
+	return errJPEGStructure
+}
@@ -0,0 +1,164 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Lossless frames are the raw RGBA pixels compressed with zstd, for text
+// and design work where the artifacts of the other codecs show. A frame is
+// a header followed by the compressed pixels:
+//
+//	byte 0     losslessKeyframe or losslessDelta
+//	bytes 1-4  width, little endian
+//	bytes 5-8  height, little endian
+//
+// Delta frames hold the XOR of the pixels with the previous frame's, which
+// is zero wherever nothing changed and compresses to almost nothing.
+const (
+	losslessKeyframe = 'K'
+	losslessDelta    = 'D'
+
+	losslessHeaderSize = 9
+)
+
+var errLosslessFrame = errors.New("invalid lossless frame")
+
+// compressor is the entropy coder of lossless frames
+type compressor interface {
+	// Compress appends the compressed src to dst
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress decompresses src into dst, which has the exact size of
+	// the original data
+	Decompress(dst, src []byte) error
+	Close() error
+}
+
+// losslessEncoder encodes frames without loss, as deltas to the previous
+// frame until a keyframe is requested
+type losslessEncoder struct {
+	compressor compressor
+	previous   []byte // Pixels of the previous frame, nil before the first
+	delta      []byte
+	width      int
+	height     int
+	keyframe   bool // Force the next frame to be a keyframe
+}
+
+func newLosslessEncoder(c compressor) *losslessEncoder {
+	return &losslessEncoder{compressor: c, keyframe: true}
+}
+
+func (e *losslessEncoder) Codec() ID { return Lossless }
+
+func (e *losslessEncoder) Encode(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pix := toRGBA(img)
+
+	kind := byte(losslessDelta)
+	src := pix
+	if e.keyframe || e.previous == nil || width != e.width || height != e.height {
+		kind = losslessKeyframe
+	} else {
+		if len(e.delta) != len(pix) {
+			e.delta = make([]byte, len(pix))
+		}
+		for i := range pix {
+			e.delta[i] = pix[i] ^ e.previous[i]
+		}
+		src = e.delta
+	}
+
+	frame := make([]byte, losslessHeaderSize, losslessHeaderSize+len(pix)/4)
+	frame[0] = kind
+	binary.LittleEndian.PutUint32(frame[1:], uint32(width))
+	binary.LittleEndian.PutUint32(frame[5:], uint32(height))
+	frame, err := e.compressor.Compress(frame, src)
+	if err != nil {
+		return nil, err
+	}
+
+	e.previous, e.width, e.height = pix, width, height
+	e.keyframe = false
+	return frame, nil
+}
+
+// SetQuality does nothing, lossless frames have a single quality
+func (e *losslessEncoder) SetQuality(quality int) {}
+
+func (e *losslessEncoder) RequestKeyframe() { e.keyframe = true }
+
+func (e *losslessEncoder) Close() error { return e.compressor.Close() }
+
+// losslessDecoder decodes lossless frames
+type losslessDecoder struct {
+	compressor compressor
+	previous   *image.RGBA // Last decoded picture, nil before the first keyframe
+}
+
+func newLosslessDecoder(c compressor) *losslessDecoder {
+	return &losslessDecoder{compressor: c}
+}
+
+func (d *losslessDecoder) Codec() ID { return Lossless }
+
+func (d *losslessDecoder) Decode(data []byte) (image.Image, error) {
+	if len(data) < losslessHeaderSize {
+		return nil, errLosslessFrame
+	}
+	kind := data[0]
+	width := int(binary.LittleEndian.Uint32(data[1:]))
+	height := int(binary.LittleEndian.Uint32(data[5:]))
+	if kind != losslessKeyframe && kind != losslessDelta || width <= 0 || height <= 0 || width*height > 1<<28 {
+		return nil, errLosslessFrame
+	}
+
+	// Deltas before the first keyframe have nothing to apply to
+	size := image.Pt(width, height)
+	if kind == losslessDelta && (d.previous == nil || d.previous.Rect.Size() != size) {
+		return nil, nil
+	}
+
+	// Pictures are handed to the display, each frame gets its own
+	img := image.NewRGBA(image.Rectangle{Max: size})
+	if err := d.compressor.Decompress(img.Pix, data[losslessHeaderSize:]); err != nil {
+		return nil, fmt.Errorf("failed to decompress lossless frame: %w", err)
+	}
+	if kind == losslessDelta {
+		for i, p := range d.previous.Pix {
+			img.Pix[i] ^= p
+		}
+	}
+	d.previous = img
+	return img, nil
+}
+
+func (d *losslessDecoder) Close() error { return d.compressor.Close() }
+
+// toRGBA returns the pixels of img as tightly packed RGBA rows
+func toRGBA(img image.Image) []byte {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pix := make([]byte, width*height*4)
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < height; y++ {
+			offset := rgba.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			copy(pix[y*width*4:(y+1)*width*4], rgba.Pix[offset:offset+width*4])
+		}
+		return pix
+	}
+
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			pix[i], pix[i+1], pix[i+2], pix[i+3] = byte(r>>8), byte(g>>8), byte(b>>8), byte(a>>8)
+			i += 4
+		}
+	}
+	return pix
+}
@@ -0,0 +1,463 @@
+//go:build cgo
+
+package codec
+
+/*
+#cgo LDFLAGS: -lmfplat -lmfuuid -lole32 -lpropsys
+#define COBJMACROS
+#include <initguid.h>
+#include <stdlib.h>
+#include <string.h>
+#include <windows.h>
+#include <mfapi.h>
+#include <mfidl.h>
+#include <mferror.h>
+#include <mftransform.h>
+#include <codecapi.h>
+#include <strmif.h>
+
+#define MF_FPS 30
+
+// mf_encoder is a hardware encoder MFT. Hardware MFTs are asynchronous:
+// they ask for input and announce output through events.
+typedef struct {
+	IMFTransform *transform;
+	IMFMediaEventGenerator *events;
+	ICodecAPI *codec_api;
+	int provides_samples;
+	int need_input;
+	uint32_t width;
+	uint32_t height;
+	uint8_t *out;
+	size_t out_len;
+} mf_encoder;
+
+static HRESULT mf_startup(void) {
+	HRESULT hr = CoInitializeEx(NULL, COINIT_MULTITHREADED);
+	if (FAILED(hr) && hr != RPC_E_CHANGED_MODE) {
+		return hr;
+	}
+	return MFStartup(MF_VERSION, MFSTARTUP_NOSOCKET);
+}
+
+static HRESULT mf_set_uint(ICodecAPI *api, const GUID *key, ULONG value) {
+	VARIANT v;
+	VariantInit(&v);
+	v.vt = VT_UI4;
+	v.ulVal = value;
+	return ICodecAPI_SetValue(api, key, &v);
+}
+
+static HRESULT mf_media_type(IMFMediaType **type, const GUID *subtype, uint32_t width, uint32_t height, uint32_t bitrate) {
+	HRESULT hr = MFCreateMediaType(type);
+	if (FAILED(hr)) {
+		return hr;
+	}
+	IMFMediaType_SetGUID(*type, &MF_MT_MAJOR_TYPE, &MFMediaType_Video);
+	IMFMediaType_SetGUID(*type, &MF_MT_SUBTYPE, subtype);
+	IMFMediaType_SetUINT64(*type, &MF_MT_FRAME_SIZE, (UINT64)width << 32 | height);
+	IMFMediaType_SetUINT64(*type, &MF_MT_FRAME_RATE, (UINT64)MF_FPS << 32 | 1);
+	IMFMediaType_SetUINT64(*type, &MF_MT_PIXEL_ASPECT_RATIO, (UINT64)1 << 32 | 1);
+	IMFMediaType_SetUINT32(*type, &MF_MT_INTERLACE_MODE, MFVideoInterlace_Progressive);
+	if (bitrate > 0) {
+		IMFMediaType_SetUINT32(*type, &MF_MT_AVG_BITRATE, bitrate);
+	}
+	return S_OK;
+}
+
+static void mf_encoder_free(mf_encoder *e) {
+	if (e->transform != NULL) {
+		IMFTransform_ProcessMessage(e->transform, MFT_MESSAGE_NOTIFY_END_STREAMING, 0);
+		IMFTransform_Release(e->transform);
+	}
+	if (e->events != NULL) {
+		IMFMediaEventGenerator_Release(e->events);
+	}
+	if (e->codec_api != NULL) {
+		ICodecAPI_Release(e->codec_api);
+	}
+	free(e->out);
+	free(e);
+}
+
+// Rate control modes, numbered like codec.RateMode
+#define MF_RATE_CBR 1
+#define MF_RATE_VBR 2
+#define MF_RATE_CQ 3
+
+// mf_encoder_set_rate changes the bitrate in bit/s, or the quality (0-100)
+// in MF_RATE_CQ mode. Not every encoder can while streaming.
+static HRESULT mf_encoder_set_rate(mf_encoder *e, int mode, uint32_t bitrate, uint32_t quality) {
+	if (e->codec_api == NULL) {
+		return E_NOTIMPL;
+	}
+	if (mode == MF_RATE_CQ) {
+		return mf_set_uint(e->codec_api, &CODECAPI_AVEncCommonQuality, quality);
+	}
+	if (mode == MF_RATE_VBR) {
+		mf_set_uint(e->codec_api, &CODECAPI_AVEncCommonMaxBitRate, bitrate * 2);
+	}
+	return mf_set_uint(e->codec_api, &CODECAPI_AVEncCommonMeanBitRate, bitrate);
+}
+
+static HRESULT mf_encoder_create(mf_encoder *e, int hevc, uint32_t width, uint32_t height, int mode, uint32_t bitrate, uint32_t quality) {
+	HRESULT hr = mf_startup();
+	if (FAILED(hr)) {
+		return hr;
+	}
+	e->width = width;
+	e->height = height;
+
+	// The first hardware encoder from NV12 to the codec, in the order the
+	// system ranks them
+	MFT_REGISTER_TYPE_INFO input = {MFMediaType_Video, MFVideoFormat_NV12};
+	MFT_REGISTER_TYPE_INFO output = {MFMediaType_Video, hevc ? MFVideoFormat_HEVC : MFVideoFormat_H264};
+	IMFActivate **activates = NULL;
+	UINT32 count = 0;
+	hr = MFTEnumEx(MFT_CATEGORY_VIDEO_ENCODER, MFT_ENUM_FLAG_HARDWARE | MFT_ENUM_FLAG_SORTANDFILTER, &input, &output, &activates, &count);
+	if (FAILED(hr)) {
+		return hr;
+	}
+	if (count == 0) {
+		CoTaskMemFree(activates);
+		return MF_E_TOPO_CODEC_NOT_FOUND;
+	}
+	hr = IMFActivate_ActivateObject(activates[0], &IID_IMFTransform, (void **)&e->transform);
+	for (UINT32 i = 0; i < count; i++) {
+		IMFActivate_Release(activates[i]);
+	}
+	CoTaskMemFree(activates);
+	if (FAILED(hr)) {
+		return hr;
+	}
+
+	IMFAttributes *attributes = NULL;
+	if (SUCCEEDED(IMFTransform_GetAttributes(e->transform, &attributes))) {
+		IMFAttributes_SetUINT32(attributes, &MF_TRANSFORM_ASYNC_UNLOCK, TRUE);
+		IMFAttributes_SetUINT32(attributes, &MF_LOW_LATENCY, TRUE);
+		IMFAttributes_Release(attributes);
+	}
+	if (FAILED(hr = IMFTransform_QueryInterface(e->transform, &IID_IMFMediaEventGenerator, (void **)&e->events))) {
+		return hr;
+	}
+
+	// Encoders take the output type first
+	IMFMediaType *type = NULL;
+	if (FAILED(hr = mf_media_type(&type, &output.guidSubtype, width, height, bitrate))) {
+		return hr;
+	}
+	hr = IMFTransform_SetOutputType(e->transform, 0, type, 0);
+	IMFMediaType_Release(type);
+	if (FAILED(hr)) {
+		return hr;
+	}
+	if (FAILED(hr = mf_media_type(&type, &MFVideoFormat_NV12, width, height, 0))) {
+		return hr;
+	}
+	hr = IMFTransform_SetInputType(e->transform, 0, type, 0);
+	IMFMediaType_Release(type);
+	if (FAILED(hr)) {
+		return hr;
+	}
+
+	// Low latency, no B-frames, keyframes on request
+	if (SUCCEEDED(IMFTransform_QueryInterface(e->transform, &IID_ICodecAPI, (void **)&e->codec_api))) {
+		VARIANT v;
+		VariantInit(&v);
+		v.vt = VT_BOOL;
+		v.boolVal = VARIANT_TRUE;
+		ICodecAPI_SetValue(e->codec_api, &CODECAPI_AVLowLatencyMode, &v);
+		switch (mode) {
+		case MF_RATE_CQ:
+			mf_set_uint(e->codec_api, &CODECAPI_AVEncCommonRateControlMode, eAVEncCommonRateControlMode_Quality);
+			break;
+		case MF_RATE_VBR:
+			mf_set_uint(e->codec_api, &CODECAPI_AVEncCommonRateControlMode, eAVEncCommonRateControlMode_PeakConstrainedVBR);
+			break;
+		default:
+			mf_set_uint(e->codec_api, &CODECAPI_AVEncCommonRateControlMode, eAVEncCommonRateControlMode_CBR);
+		}
+		mf_encoder_set_rate(e, mode, bitrate, quality);
+		mf_set_uint(e->codec_api, &CODECAPI_AVEncMPVDefaultBPictureCount, 0);
+		mf_set_uint(e->codec_api, &CODECAPI_AVEncMPVGOPSize, 0);
+	}
+
+	MFT_OUTPUT_STREAM_INFO info = {0};
+	if (FAILED(hr = IMFTransform_GetOutputStreamInfo(e->transform, 0, &info))) {
+		return hr;
+	}
+	e->provides_samples = (info.dwFlags & (MFT_OUTPUT_STREAM_PROVIDES_SAMPLES | MFT_OUTPUT_STREAM_CAN_PROVIDE_SAMPLES)) != 0;
+
+	if (FAILED(hr = IMFTransform_ProcessMessage(e->transform, MFT_MESSAGE_NOTIFY_BEGIN_STREAMING, 0))) {
+		return hr;
+	}
+	return IMFTransform_ProcessMessage(e->transform, MFT_MESSAGE_NOTIFY_START_OF_STREAM, 0);
+}
+
+// mf_collect appends the encoder's pending output to e->out
+static HRESULT mf_collect(mf_encoder *e) {
+	MFT_OUTPUT_DATA_BUFFER output = {0};
+	IMFSample *sample = NULL;
+	IMFMediaBuffer *buffer = NULL;
+	HRESULT hr;
+
+	if (!e->provides_samples) {
+		MFT_OUTPUT_STREAM_INFO info = {0};
+		if (FAILED(hr = IMFTransform_GetOutputStreamInfo(e->transform, 0, &info))) {
+			return hr;
+		}
+		if (FAILED(hr = MFCreateSample(&sample))) {
+			return hr;
+		}
+		if (FAILED(hr = MFCreateMemoryBuffer(info.cbSize, &buffer))) {
+			IMFSample_Release(sample);
+			return hr;
+		}
+		IMFSample_AddBuffer(sample, buffer);
+		IMFMediaBuffer_Release(buffer);
+		output.pSample = sample;
+	}
+
+	DWORD status = 0;
+	hr = IMFTransform_ProcessOutput(e->transform, 0, 1, &output, &status);
+	if (output.pEvents != NULL) {
+		IMFCollection_Release(output.pEvents);
+	}
+	if (FAILED(hr)) {
+		if (output.pSample != NULL) {
+			IMFSample_Release(output.pSample);
+		}
+		return hr;
+	}
+
+	if (SUCCEEDED(hr = IMFSample_ConvertToContiguousBuffer(output.pSample, &buffer))) {
+		BYTE *data;
+		DWORD length;
+		if (SUCCEEDED(hr = IMFMediaBuffer_Lock(buffer, &data, NULL, &length))) {
+			e->out = realloc(e->out, e->out_len + length);
+			memcpy(e->out + e->out_len, data, length);
+			e->out_len += length;
+			IMFMediaBuffer_Unlock(buffer);
+		}
+		IMFMediaBuffer_Release(buffer);
+	}
+	IMFSample_Release(output.pSample);
+	return hr;
+}
+
+// mf_wait handles events until the encoder wants input or, if output is
+// set, until it delivered a frame
+static HRESULT mf_wait(mf_encoder *e, int output) {
+	for (;;) {
+		if (!output && e->need_input > 0) {
+			return S_OK;
+		}
+		IMFMediaEvent *event = NULL;
+		HRESULT hr = IMFMediaEventGenerator_GetEvent(e->events, 0, &event);
+		if (FAILED(hr)) {
+			return hr;
+		}
+		MediaEventType type;
+		IMFMediaEvent_GetType(event, &type);
+		IMFMediaEvent_Release(event);
+
+		if (type == METransformNeedInput) {
+			e->need_input++;
+		} else if (type == METransformHaveOutput) {
+			if (FAILED(hr = mf_collect(e))) {
+				return hr;
+			}
+			if (output) {
+				return S_OK;
+			}
+		}
+	}
+}
+
+// mf_encode encodes a frame of tightly packed NV12
+static HRESULT mf_encode(mf_encoder *e, const uint8_t *nv12, int64_t frame, int keyframe) {
+	HRESULT hr = mf_startup();
+	if (FAILED(hr)) {
+		return hr;
+	}
+	e->out_len = 0;
+	if (FAILED(hr = mf_wait(e, 0))) {
+		return hr;
+	}
+
+	DWORD size = e->width * e->height * 3 / 2;
+	IMFMediaBuffer *buffer = NULL;
+	if (FAILED(hr = MFCreateMemoryBuffer(size, &buffer))) {
+		return hr;
+	}
+	BYTE *data;
+	if (FAILED(hr = IMFMediaBuffer_Lock(buffer, &data, NULL, NULL))) {
+		IMFMediaBuffer_Release(buffer);
+		return hr;
+	}
+	memcpy(data, nv12, size);
+	IMFMediaBuffer_Unlock(buffer);
+	IMFMediaBuffer_SetCurrentLength(buffer, size);
+
+	IMFSample *sample = NULL;
+	if (FAILED(hr = MFCreateSample(&sample))) {
+		IMFMediaBuffer_Release(buffer);
+		return hr;
+	}
+	IMFSample_AddBuffer(sample, buffer);
+	IMFMediaBuffer_Release(buffer);
+	// Times are in units of 100ns
+	IMFSample_SetSampleTime(sample, frame * 10000000 / MF_FPS);
+	IMFSample_SetSampleDuration(sample, 10000000 / MF_FPS);
+
+	if (keyframe && e->codec_api != NULL) {
+		mf_set_uint(e->codec_api, &CODECAPI_AVEncVideoForceKeyFrame, 1);
+	}
+	hr = IMFTransform_ProcessInput(e->transform, 0, sample, 0);
+	IMFSample_Release(sample);
+	if (FAILED(hr)) {
+		return hr;
+	}
+	e->need_input--;
+
+	// Low latency mode delivers every frame before asking for the next
+	return mf_wait(e, 1);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+func init() {
+	RegisterEncoder(15, "mediafoundation", H264, func() bool { return mfAvailable(H264) }, func(width, height int) (Encoder, error) {
+		return newMFEncoder(H264, width, height)
+	})
+	RegisterEncoder(15, "mediafoundation", HEVC, func() bool { return mfAvailable(HEVC) }, func(width, height int) (Encoder, error) {
+		return newMFEncoder(HEVC, width, height)
+	})
+}
+
+// mfAvailable reports whether a hardware encoder MFT for a codec is
+// installed. The software encoders Windows ships aren't used, JPEG costs
+// less CPU.
+func mfAvailable(id ID) bool {
+	e, err := newMFEncoder(id, probeSize, probeSize)
+	if err != nil {
+		return false
+	}
+	e.Close()
+	return true
+}
+
+// mfEncoder encodes H.264 or HEVC with a Media Foundation hardware encoder
+// from the GPU vendor's driver
+type mfEncoder struct {
+	session       *C.mf_encoder
+	codec         ID
+	width, height int
+	frame         int64
+	quality       int
+	rate          RateControl
+	rc            rateSettings // Rate control the encoder runs with, see rateSettings
+	keyframe      bool         // Force the next frame to be a keyframe
+}
+
+func newMFEncoder(id ID, width, height int) (*mfEncoder, error) {
+	// NV12 needs even dimensions, see toNV12
+	width, height = width&^1, height&^1
+	e := &mfEncoder{
+		codec:    id,
+		width:    width,
+		height:   height,
+		quality:  defaultJPEGQuality,
+		keyframe: true,
+	}
+	if err := e.open(e.rateSettings()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// open creates the encoder, again when the rate control mode changes as
+// it is set when streaming starts
+func (e *mfEncoder) open(rc rateSettings) error {
+	e.Close()
+	hevc := C.int(0)
+	if e.codec == HEVC {
+		hevc = 1
+	}
+	e.session = (*C.mf_encoder)(C.calloc(1, C.sizeof_mf_encoder))
+	if hr := C.mf_encoder_create(e.session, hevc, C.uint32_t(e.width), C.uint32_t(e.height), C.int(rc.mode), C.uint32_t(rc.bitrate), C.uint32_t(rc.qp)); hr < 0 {
+		e.Close()
+		return fmt.Errorf("failed to create Media Foundation %s encoder: HRESULT 0x%08X", e.codec, uint32(hr))
+	}
+	e.rc = rc
+	e.keyframe = true
+	return nil
+}
+
+// rateSettings returns the rate control for the next frame. Media
+// Foundation's constant quality mode takes the quality itself, kept in qp.
+func (e *mfEncoder) rateSettings() rateSettings {
+	rc := e.rate.settings(e.quality, e.width, e.height, 0)
+	if rc.mode == RateCQ {
+		rc.qp = e.quality
+	}
+	return rc
+}
+
+func (e *mfEncoder) Codec() ID { return e.codec }
+
+func (e *mfEncoder) Encode(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	if bounds.Dx()&^1 != e.width || bounds.Dy()&^1 != e.height {
+		return nil, fmt.Errorf("frame is %dx%d, the encoder was created for %dx%d", bounds.Dx(), bounds.Dy(), e.width, e.height)
+	}
+	if rc := e.rateSettings(); rc.mode != e.rc.mode {
+		if err := e.open(rc); err != nil {
+			return nil, err
+		}
+	} else if rc != e.rc {
+		// Encoders without dynamic bitrate keep the one they started with
+		if C.mf_encoder_set_rate(e.session, C.int(rc.mode), C.uint32_t(rc.bitrate), C.uint32_t(rc.qp)) >= 0 {
+			e.rc = rc
+		}
+	}
+
+	nv12 := toNV12(img)
+	keyframe := C.int(0)
+	if e.keyframe {
+		keyframe = 1
+	}
+	hr := C.mf_encode(e.session, (*C.uint8_t)(unsafe.Pointer(&nv12[0])), C.int64_t(e.frame), keyframe)
+	e.frame++
+	if hr < 0 {
+		return nil, fmt.Errorf("Media Foundation encoding failed: HRESULT 0x%08X", uint32(hr))
+	}
+	if e.session.out_len == 0 {
+		return nil, errors.New("Media Foundation encoder returned no frame")
+	}
+	e.keyframe = false
+
+	// Hardware MFTs write Annex B with the parameter sets on keyframes
+	return C.GoBytes(unsafe.Pointer(e.session.out), C.int(e.session.out_len)), nil
+}
+
+func (e *mfEncoder) SetQuality(quality int) { e.quality = quality }
+
+func (e *mfEncoder) SetRateControl(rc RateControl) { e.rate = rc }
+
+func (e *mfEncoder) RequestKeyframe() { e.keyframe = true }
+
+func (e *mfEncoder) Close() error {
+	if e.session != nil {
+		C.mf_encoder_free(e.session)
+		e.session = nil
+	}
+	return nil
+}
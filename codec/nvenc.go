@@ -0,0 +1,373 @@
+//go:build (linux || windows) && cgo && nvenc
+
+package codec
+
+/*
+#cgo linux LDFLAGS: -ldl
+#cgo pkg-config: ffnvcodec
+#include <stdlib.h>
+#include <string.h>
+#include <ffnvcodec/dynlink_loader.h>
+
+// The CUDA and NVENC libraries are loaded at runtime so the binary starts
+// on machines without an NVIDIA driver
+static CudaFunctions *nv_cuda;
+static NvencFunctions *nv_enc;
+static NV_ENCODE_API_FUNCTION_LIST nv_api;
+
+static int nv_load(void) {
+	if (cuda_load_functions(&nv_cuda, NULL) < 0) {
+		return -1;
+	}
+	if (nvenc_load_functions(&nv_enc, NULL) < 0) {
+		cuda_free_functions(&nv_cuda);
+		return -1;
+	}
+	if (nv_cuda->cuInit(0) != CUDA_SUCCESS) {
+		return -1;
+	}
+	nv_api.version = NV_ENCODE_API_FUNCTION_LIST_VER;
+	if (nv_enc->NvEncodeAPICreateInstance(&nv_api) != NV_ENC_SUCCESS) {
+		return -1;
+	}
+	return 0;
+}
+
+// nv_encoder is an NVENC session on the first GPU with one input and one
+// output buffer, encoding synchronously
+typedef struct {
+	CUcontext context;
+	void *session;
+	NV_ENC_INITIALIZE_PARAMS init;
+	NV_ENC_CONFIG config;
+	NV_ENC_INPUT_PTR input;
+	NV_ENC_OUTPUT_PTR output;
+	uint8_t *out;
+	uint32_t out_len;
+} nv_encoder;
+
+static void nv_encoder_free(nv_encoder *e) {
+	if (e->session != NULL) {
+		if (e->input != NULL) {
+			nv_api.nvEncDestroyInputBuffer(e->session, e->input);
+		}
+		if (e->output != NULL) {
+			nv_api.nvEncDestroyBitstreamBuffer(e->session, e->output);
+		}
+		nv_api.nvEncDestroyEncoder(e->session);
+	}
+	if (e->context != NULL) {
+		nv_cuda->cuCtxDestroy(e->context);
+	}
+	free(e->out);
+	free(e);
+}
+
+// Codecs, numbered like codec.ID
+#define NV_H264 1
+#define NV_HEVC 2
+#define NV_AV1 3
+
+// Rate control modes, numbered like codec.RateMode
+#define NV_RATE_CBR 1
+#define NV_RATE_VBR 2
+#define NV_RATE_CQ 3
+
+// nv_set_rate sets the rate control of the next initialization or
+// reconfiguration
+static void nv_set_rate(nv_encoder *e, uint32_t fps, int mode, uint32_t bitrate, uint32_t qp) {
+	NV_ENC_RC_PARAMS *rc = &e->config.rcParams;
+	switch (mode) {
+	case NV_RATE_CQ:
+		rc->rateControlMode = NV_ENC_PARAMS_RC_CONSTQP;
+		rc->constQP.qpInterP = qp;
+		rc->constQP.qpInterB = qp;
+		rc->constQP.qpIntra = qp;
+		break;
+	case NV_RATE_VBR:
+		rc->rateControlMode = NV_ENC_PARAMS_RC_VBR;
+		rc->averageBitRate = bitrate;
+		rc->maxBitRate = bitrate * 2;
+		rc->vbvBufferSize = bitrate * 2 / fps;
+		rc->vbvInitialDelay = bitrate * 2 / fps;
+		break;
+	default:
+		rc->rateControlMode = NV_ENC_PARAMS_RC_CBR;
+		rc->averageBitRate = bitrate;
+		rc->maxBitRate = bitrate;
+		// A one frame buffer keeps every frame close to the average size
+		rc->vbvBufferSize = bitrate / fps;
+		rc->vbvInitialDelay = bitrate / fps;
+	}
+}
+
+static int nv_encoder_create(nv_encoder *e, int codec_id, uint32_t width, uint32_t height, uint32_t fps, int mode, uint32_t bitrate, uint32_t qp) {
+	CUdevice device;
+	if (nv_cuda->cuDeviceGet(&device, 0) != CUDA_SUCCESS) {
+		return -1;
+	}
+	if (nv_cuda->cuCtxCreate(&e->context, 0, device) != CUDA_SUCCESS) {
+		return -1;
+	}
+	CUcontext current;
+	nv_cuda->cuCtxPopCurrent(&current);
+
+	NV_ENC_OPEN_ENCODE_SESSION_EX_PARAMS open = {0};
+	open.version = NV_ENC_OPEN_ENCODE_SESSION_EX_PARAMS_VER;
+	open.deviceType = NV_ENC_DEVICE_TYPE_CUDA;
+	open.device = e->context;
+	open.apiVersion = NVENCAPI_VERSION;
+	NVENCSTATUS status = nv_api.nvEncOpenEncodeSessionEx(&open, &e->session);
+	if (status != NV_ENC_SUCCESS) {
+		e->session = NULL;
+		return status;
+	}
+
+	// Fastest preset tuned for latency, P-frames only, keyframes on request
+	GUID codec = NV_ENC_CODEC_H264_GUID;
+	if (codec_id == NV_HEVC) {
+		codec = NV_ENC_CODEC_HEVC_GUID;
+	} else if (codec_id == NV_AV1) {
+		codec = NV_ENC_CODEC_AV1_GUID;
+	}
+	NV_ENC_PRESET_CONFIG preset = {0};
+	preset.version = NV_ENC_PRESET_CONFIG_VER;
+	preset.presetCfg.version = NV_ENC_CONFIG_VER;
+	status = nv_api.nvEncGetEncodePresetConfigEx(e->session, codec, NV_ENC_PRESET_P1_GUID, NV_ENC_TUNING_INFO_ULTRA_LOW_LATENCY, &preset);
+	if (status != NV_ENC_SUCCESS) {
+		return status;
+	}
+	e->config = preset.presetCfg;
+	e->config.gopLength = NVENC_INFINITE_GOPLENGTH;
+	e->config.frameIntervalP = 1;
+	nv_set_rate(e, fps, mode, bitrate, qp);
+	if (codec_id == NV_HEVC) {
+		e->config.encodeCodecConfig.hevcConfig.idrPeriod = NVENC_INFINITE_GOPLENGTH;
+		e->config.encodeCodecConfig.hevcConfig.repeatSPSPPS = 1;
+	} else if (codec_id == NV_AV1) {
+		// AV1 encoding needs an Ada or newer GPU
+		e->config.encodeCodecConfig.av1Config.idrPeriod = NVENC_INFINITE_GOPLENGTH;
+		e->config.encodeCodecConfig.av1Config.repeatSeqHdr = 1;
+	} else {
+		e->config.encodeCodecConfig.h264Config.idrPeriod = NVENC_INFINITE_GOPLENGTH;
+		e->config.encodeCodecConfig.h264Config.repeatSPSPPS = 1;
+	}
+
+	e->init.version = NV_ENC_INITIALIZE_PARAMS_VER;
+	e->init.encodeGUID = codec;
+	e->init.presetGUID = NV_ENC_PRESET_P1_GUID;
+	e->init.tuningInfo = NV_ENC_TUNING_INFO_ULTRA_LOW_LATENCY;
+	e->init.encodeWidth = width;
+	e->init.encodeHeight = height;
+	e->init.darWidth = width;
+	e->init.darHeight = height;
+	e->init.maxEncodeWidth = width;
+	e->init.maxEncodeHeight = height;
+	e->init.frameRateNum = fps;
+	e->init.frameRateDen = 1;
+	e->init.enablePTD = 1;
+	e->init.encodeConfig = &e->config;
+	status = nv_api.nvEncInitializeEncoder(e->session, &e->init);
+	if (status != NV_ENC_SUCCESS) {
+		return status;
+	}
+
+	NV_ENC_CREATE_INPUT_BUFFER input = {0};
+	input.version = NV_ENC_CREATE_INPUT_BUFFER_VER;
+	input.width = width;
+	input.height = height;
+	input.bufferFmt = NV_ENC_BUFFER_FORMAT_ARGB;
+	status = nv_api.nvEncCreateInputBuffer(e->session, &input);
+	if (status != NV_ENC_SUCCESS) {
+		return status;
+	}
+	e->input = input.inputBuffer;
+
+	NV_ENC_CREATE_BITSTREAM_BUFFER output = {0};
+	output.version = NV_ENC_CREATE_BITSTREAM_BUFFER_VER;
+	status = nv_api.nvEncCreateBitstreamBuffer(e->session, &output);
+	if (status != NV_ENC_SUCCESS) {
+		return status;
+	}
+	e->output = output.bitstreamBuffer;
+	return NV_ENC_SUCCESS;
+}
+
+static int nv_encoder_set_rate(nv_encoder *e, uint32_t fps, int mode, uint32_t bitrate, uint32_t qp) {
+	nv_set_rate(e, fps, mode, bitrate, qp);
+
+	NV_ENC_RECONFIGURE_PARAMS params = {0};
+	params.version = NV_ENC_RECONFIGURE_PARAMS_VER;
+	params.reInitEncodeParams = e->init;
+	return nv_api.nvEncReconfigureEncoder(e->session, &params);
+}
+
+// nv_encode encodes a frame of BGRA pixels, which NVENC calls ARGB as it
+// names the bytes of a little endian word
+static int nv_encode(nv_encoder *e, const uint8_t *bgra, uint32_t width, uint32_t height, uint64_t frame, int keyframe) {
+	NV_ENC_LOCK_INPUT_BUFFER lock = {0};
+	lock.version = NV_ENC_LOCK_INPUT_BUFFER_VER;
+	lock.inputBuffer = e->input;
+	NVENCSTATUS status = nv_api.nvEncLockInputBuffer(e->session, &lock);
+	if (status != NV_ENC_SUCCESS) {
+		return status;
+	}
+	for (uint32_t y = 0; y < height; y++) {
+		memcpy((uint8_t *)lock.bufferDataPtr + (size_t)y*lock.pitch, bgra + (size_t)y*width*4, (size_t)width*4);
+	}
+	nv_api.nvEncUnlockInputBuffer(e->session, e->input);
+
+	NV_ENC_PIC_PARAMS picture = {0};
+	picture.version = NV_ENC_PIC_PARAMS_VER;
+	picture.inputWidth = width;
+	picture.inputHeight = height;
+	picture.inputPitch = lock.pitch;
+	picture.inputBuffer = e->input;
+	picture.outputBitstream = e->output;
+	picture.bufferFmt = NV_ENC_BUFFER_FORMAT_ARGB;
+	picture.pictureStruct = NV_ENC_PIC_STRUCT_FRAME;
+	picture.inputTimeStamp = frame;
+	if (keyframe) {
+		picture.encodePicFlags = NV_ENC_PIC_FLAG_FORCEIDR | NV_ENC_PIC_FLAG_OUTPUT_SPSPPS;
+	}
+	status = nv_api.nvEncEncodePicture(e->session, &picture);
+	if (status != NV_ENC_SUCCESS) {
+		return status;
+	}
+
+	NV_ENC_LOCK_BITSTREAM bitstream = {0};
+	bitstream.version = NV_ENC_LOCK_BITSTREAM_VER;
+	bitstream.outputBitstream = e->output;
+	status = nv_api.nvEncLockBitstream(e->session, &bitstream);
+	if (status != NV_ENC_SUCCESS) {
+		return status;
+	}
+	e->out = realloc(e->out, bitstream.bitstreamSizeInBytes);
+	memcpy(e->out, bitstream.bitstreamBufferPtr, bitstream.bitstreamSizeInBytes);
+	e->out_len = bitstream.bitstreamSizeInBytes;
+	return nv_api.nvEncUnlockBitstream(e->session, e->output);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"unsafe"
+)
+
+func init() {
+	for _, id := range []ID{H264, HEVC, AV1} {
+		RegisterEncoder(20, "nvenc", id, func() bool { return nvencAvailable(id) }, func(width, height int) (Encoder, error) {
+			return newNVENCEncoder(id, width, height)
+		})
+	}
+}
+
+var (
+	nvencLoad   sync.Once
+	nvencLoaded bool
+)
+
+// nvencAvailable reports whether the NVIDIA driver and a GPU that can
+// encode a codec with NVENC are present
+func nvencAvailable(id ID) bool {
+	nvencLoad.Do(func() {
+		nvencLoaded = C.nv_load() == 0
+	})
+	if !nvencLoaded {
+		return false
+	}
+	e, err := newNVENCEncoder(id, probeSize, probeSize)
+	if err != nil {
+		return false
+	}
+	e.Close()
+	return true
+}
+
+// nvencEncoder encodes H.264, HEVC or AV1 with NVENC. Every monitor has its own
+// session; consumer GPUs limit how many can be open at once, monitors past
+// the limit fall back to the next backend or JPEG.
+type nvencEncoder struct {
+	session       *C.nv_encoder
+	codec         ID
+	width, height int
+	frame         uint64
+	quality       int
+	rate          RateControl
+	rc            rateSettings // Rate control the session runs with
+	keyframe      bool         // Force the next frame to be a keyframe
+}
+
+func newNVENCEncoder(id ID, width, height int) (*nvencEncoder, error) {
+	e := &nvencEncoder{
+		codec:    id,
+		width:    width,
+		height:   height,
+		quality:  defaultJPEGQuality,
+		keyframe: true,
+	}
+	e.rc = e.rate.settings(e.quality, width, height, nvencMaxQP(id))
+
+	e.session = (*C.nv_encoder)(C.calloc(1, C.sizeof_nv_encoder))
+	if status := C.nv_encoder_create(e.session, C.int(id), C.uint32_t(width), C.uint32_t(height), hardwareFrameRate, C.int(e.rc.mode), C.uint32_t(e.rc.bitrate), C.uint32_t(e.rc.qp)); status != 0 {
+		C.nv_encoder_free(e.session)
+		return nil, fmt.Errorf("failed to open NVENC %s session: status %d", id, int(status))
+	}
+	return e, nil
+}
+
+// nvencMaxQP returns the highest quantizer of a codec, AV1's go further
+func nvencMaxQP(id ID) int {
+	if id == AV1 {
+		return 255
+	}
+	return 51
+}
+
+func (e *nvencEncoder) Codec() ID { return e.codec }
+
+func (e *nvencEncoder) Encode(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() != e.width || bounds.Dy() != e.height {
+		return nil, fmt.Errorf("frame is %dx%d, the encoder was created for %dx%d", bounds.Dx(), bounds.Dy(), e.width, e.height)
+	}
+	if rc := e.rate.settings(e.quality, e.width, e.height, nvencMaxQP(e.codec)); rc != e.rc {
+		if status := C.nv_encoder_set_rate(e.session, hardwareFrameRate, C.int(rc.mode), C.uint32_t(rc.bitrate), C.uint32_t(rc.qp)); status != 0 {
+			return nil, fmt.Errorf("failed to change NVENC rate control: status %d", int(status))
+		}
+		e.rc = rc
+	}
+
+	pix := toBGRA(img)
+	keyframe := C.int(0)
+	if e.keyframe {
+		keyframe = 1
+	}
+	status := C.nv_encode(e.session, (*C.uint8_t)(unsafe.Pointer(&pix[0])), C.uint32_t(e.width), C.uint32_t(e.height), C.uint64_t(e.frame), keyframe)
+	e.frame++
+	if status != 0 {
+		return nil, fmt.Errorf("NVENC encoding failed: status %d", int(status))
+	}
+	e.keyframe = false
+
+	// NVENC writes Annex B with the parameter sets on keyframes, and AV1
+	// temporal units with the sequence header
+	return C.GoBytes(unsafe.Pointer(e.session.out), C.int(e.session.out_len)), nil
+}
+
+func (e *nvencEncoder) SetQuality(quality int) { e.quality = quality }
+
+func (e *nvencEncoder) SetRateControl(rc RateControl) { e.rate = rc }
+
+func (e *nvencEncoder) RequestKeyframe() { e.keyframe = true }
+
+func (e *nvencEncoder) Close() error {
+	if e.session != nil {
+		C.nv_encoder_free(e.session)
+		e.session = nil
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// pngSignature starts every PNG image
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func init() {
+	RegisterEncoder(0, goBackend, PNG, always, func(width, height int) (Encoder, error) {
+		return newPNGEncoder(), nil
+	})
+	RegisterDecoder(0, goBackend, PNG, always, func() (Decoder, error) {
+		return pngDecoder{}, nil
+	})
+}
+
+// IsPNG reports whether a frame is a PNG image
+func IsPNG(frame []byte) bool {
+	return bytes.HasPrefix(frame, pngSignature)
+}
+
+// pngEncoder encodes every frame as a self-contained PNG image, which
+// keeps text sharp where JPEG rings around the glyphs. Frames with at most
+// 256 colors, as terminals and code editors mostly are, are written with
+// a palette.
+type pngEncoder struct {
+	encoder png.Encoder
+	buf     bytes.Buffer
+}
+
+func newPNGEncoder() *pngEncoder {
+	return &pngEncoder{encoder: png.Encoder{CompressionLevel: png.BestSpeed}}
+}
+
+func (e *pngEncoder) Codec() ID { return PNG }
+
+func (e *pngEncoder) Encode(img image.Image) ([]byte, error) {
+	if paletted, ok := toPaletted(img); ok {
+		img = paletted
+	}
+	e.buf.Reset()
+	if err := e.encoder.Encode(&e.buf, img); err != nil {
+		return nil, err
+	}
+	// The buffer is reused, callers keep the frame
+	return bytes.Clone(e.buf.Bytes()), nil
+}
+
+// SetQuality does nothing, PNG is lossless
+func (e *pngEncoder) SetQuality(quality int) {}
+
+// RequestKeyframe does nothing, every PNG frame is a keyframe
+func (e *pngEncoder) RequestKeyframe() {}
+
+func (e *pngEncoder) Close() error { return nil }
+
+// toPaletted converts a frame to a paletted image if it has 256 colors or
+// fewer. Screen frames are opaque, so alpha is dropped.
+func toPaletted(img image.Image) (*image.Paletted, bool) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		return nil, false
+	}
+	bounds := rgba.Bounds()
+	paletted := image.NewPaletted(bounds, nil)
+	index := make(map[[3]byte]uint8, 256)
+
+	// Runs of one color are common, they skip the map
+	var last [3]byte
+	var lastIndex uint8
+	haveLast := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		src := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):]
+		dst := paletted.Pix[paletted.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			c := [3]byte{src[x*4], src[x*4+1], src[x*4+2]}
+			if !haveLast || c != last {
+				i, ok := index[c]
+				if !ok {
+					if len(paletted.Palette) == 256 {
+						return nil, false
+					}
+					i = uint8(len(paletted.Palette))
+					index[c] = i
+					paletted.Palette = append(paletted.Palette, color.RGBA{c[0], c[1], c[2], 255})
+				}
+				last, lastIndex, haveLast = c, i, true
+			}
+			dst[x] = lastIndex
+		}
+	}
+	return paletted, true
+}
+
+// pngDecoder decodes PNG frames
+type pngDecoder struct{}
+
+func (pngDecoder) Codec() ID { return PNG }
+
+func (pngDecoder) Decode(data []byte) (image.Image, error) {
+	return png.Decode(bytes.NewReader(data))
+}
+
+func (pngDecoder) Close() error { return nil }
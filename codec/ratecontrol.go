@@ -0,0 +1,117 @@
+package codec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RateMode is how an encoder with bitrate based rate control spends bits
+type RateMode byte
+
+// Rate control modes
+const (
+	// RateDefault is the backend's choice: RateCBR, or RateCQ for
+	// VideoToolbox
+	RateDefault RateMode = 0
+
+	// RateCBR keeps every frame close to the target bitrate: steady
+	// latency, but frames with a lot of change lose quality
+	RateCBR RateMode = 1
+
+	// RateVBR keeps the average at the target bitrate, frames with a lot
+	// of change may take up to vbrPeak times as much
+	RateVBR RateMode = 2
+
+	// RateCQ encodes at a constant quantizer chosen from the quality,
+	// whatever bitrate that takes
+	RateCQ RateMode = 3
+)
+
+// vbrPeak is how far above the target bitrate VBR may go
+const vbrPeak = 2
+
+var rateModeNames = map[RateMode]string{
+	RateDefault: "default",
+	RateCBR:     "cbr",
+	RateVBR:     "vbr",
+	RateCQ:      "cq",
+}
+
+// String returns the mode's name
+func (m RateMode) String() string {
+	if name, ok := rateModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("rate(%d)", byte(m))
+}
+
+// ParseRateMode returns the rate control mode with the given name
+func ParseRateMode(name string) (RateMode, error) {
+	for m, n := range rateModeNames {
+		if strings.EqualFold(n, name) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rate control mode %q, want cbr, vbr or cq", name)
+}
+
+// RateControl is the rate control of an encoder
+type RateControl struct {
+	Mode    RateMode
+	Bitrate int // Target in bit/s, 0 to derive it from the quality
+}
+
+// RateController is implemented by encoders with bitrate based rate
+// control, the hardware encoders, libvpx and SVT-AV1. The quality set with
+// SetQuality still chooses the bitrate if the rate control has none, and
+// the quantizer of RateCQ.
+type RateController interface {
+	SetRateControl(rc RateControl)
+}
+
+// rateSettings is what a backend configures its rate control with
+type rateSettings struct {
+	mode    RateMode // Never RateDefault
+	bitrate int      // Target in bit/s for RateCBR and RateVBR
+	qp      int      // Quantizer for RateCQ
+}
+
+// settings returns the rate settings for frames of the given size at a
+// quality, for a codec whose quantizers go from 0 to maxQP
+func (rc RateControl) settings(quality, width, height, maxQP int) rateSettings {
+	s := rateSettings{mode: rc.Mode}
+	switch s.mode {
+	case RateCQ:
+		s.qp = quantizerFor(quality, maxQP)
+	case RateVBR:
+		s.bitrate = rc.bitrate(quality, width, height)
+	default:
+		s.mode = RateCBR
+		s.bitrate = rc.bitrate(quality, width, height)
+	}
+	return s
+}
+
+// changed reports whether settings differ enough from the current ones to
+// reopen an encoder that can't change them in place, see bitrateChanged
+func (s rateSettings) changed(current rateSettings) bool {
+	return s.mode != current.mode || s.qp != current.qp || bitrateChanged(current.bitrate, s.bitrate)
+}
+
+// bitrate returns the target bitrate for frames of the given size at a
+// quality
+func (rc RateControl) bitrate(quality, width, height int) int {
+	if rc.Bitrate > 0 {
+		return rc.Bitrate
+	}
+	return bitrateFor(quality, width, height)
+}
+
+// quantizerFor maps a quality (1-100) to a quantizer for codecs whose
+// quantizers go from 0 to maxQP. The top quality is a third of the range,
+// which looks lossless for screen content; lower ones add no visible
+// detail but many bits.
+func quantizerFor(quality, maxQP int) int {
+	quality = min(max(quality, 1), 100)
+	return maxQP - (maxQP-maxQP/3)*quality/100
+}
@@ -0,0 +1,369 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math/bits"
+)
+
+// Regions frames split the screen into tiles and send each changed tile
+// the way its content needs: tiles that change all the time, like video
+// or animations, as JPEG with every frame, and tiles that change rarely,
+// like text, as PNG at a lower rate. A frame is a header followed by the
+// regions, runs of tiles of the same kind:
+//
+//	byte 0      regionsKeyframe or regionsDelta
+//	bytes 1-4   width, little endian
+//	bytes 5-8   height, little endian
+//	bytes 9-10  number of regions, little endian
+//
+// and for each region its x, y, width and height as little endian uint16s,
+// then regionLossy or regionLossless, the length of the image as a little
+// endian uint32 and the image.
+const (
+	regionsKeyframe = 'R'
+	regionsDelta    = 'r'
+
+	regionLossy    = 0
+	regionLossless = 1
+	regionSkip     = 0xFF // Tiles that aren't sent
+
+	// regionLossyROI marks lossy tiles in the region of interest while
+	// merging, they go out as regionLossy at a higher quality
+	regionLossyROI = 2
+
+	regionsHeaderSize = 11
+	regionHeaderSize  = 13
+
+	// regionTileSize is the size of the tiles classified on their own
+	regionTileSize = 64
+
+	// regionVideoChanges is in how many of the last 16 frames a tile must
+	// have changed to count as video
+	regionVideoChanges = 6
+
+	// regionTextDivisor makes changed text tiles go out with every
+	// regionTextDivisor-th frame only, typing or scrolling doesn't send
+	// each intermediate state losslessly
+	regionTextDivisor = 4
+)
+
+var errRegionsFrame = errors.New("invalid regions frame")
+
+func init() {
+	RegisterEncoder(0, goBackend, Regions, always, func(width, height int) (Encoder, error) {
+		return newRegionsEncoder(), nil
+	})
+	RegisterDecoder(0, goBackend, Regions, always, func() (Decoder, error) {
+		return newRegionsDecoder(), nil
+	})
+}
+
+// DeferringEncoder is implemented by encoders that may hold back parts of
+// a frame, like the text tiles of Regions frames. Deferred reports whether
+// some are waiting; they go out with a later frame, so it must be encoded
+// even if the screen didn't change.
+type DeferringEncoder interface {
+	Deferred() bool
+}
+
+// regionTile is the classification state of a tile
+type regionTile struct {
+	history uint16 // Whether the tile changed, one bit per frame, the last in bit 0
+	video   bool
+	dirty   bool // Changed text waiting to be sent
+}
+
+// regionsEncoder classifies tiles as video or text by how often they
+// change and sends only changed tiles, each in the format that suits it
+type regionsEncoder struct {
+	quality  int
+	keyframe bool
+	frame    int // Frames encoded since the last keyframe
+	roi      image.Rectangle
+
+	width, height int
+	columns, rows int
+	previous      []byte // Pixels of the previous frame
+	tiles         []regionTile
+
+	png png.Encoder
+	buf bytes.Buffer
+}
+
+func newRegionsEncoder() *regionsEncoder {
+	return &regionsEncoder{
+		quality:  defaultJPEGQuality,
+		keyframe: true,
+		png:      png.Encoder{CompressionLevel: png.BestSpeed},
+	}
+}
+
+func (e *regionsEncoder) Codec() ID { return Regions }
+
+func (e *regionsEncoder) Encode(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > 0xFFFF || height > 0xFFFF {
+		return nil, fmt.Errorf("%dx%d frame too large for regions", width, height)
+	}
+	pix := toRGBA(img)
+	if width != e.width || height != e.height || e.previous == nil {
+		e.width, e.height = width, height
+		e.columns = (width + regionTileSize - 1) / regionTileSize
+		e.rows = (height + regionTileSize - 1) / regionTileSize
+		e.tiles = make([]regionTile, e.columns*e.rows)
+		e.keyframe = true
+	}
+	if e.keyframe {
+		e.frame = 0
+	}
+
+	// Decide per tile whether and how it is sent
+	kinds := make([]byte, len(e.tiles))
+	for i := range e.tiles {
+		tile := &e.tiles[i]
+		changed := e.keyframe || e.tileChanged(pix, i)
+		tile.history <<= 1
+		if changed {
+			tile.history |= 1
+		}
+		wasVideo := tile.video
+		tile.video = bits.OnesCount16(tile.history) >= regionVideoChanges
+
+		kinds[i] = regionSkip
+		switch {
+		case tile.video:
+			tile.dirty = false
+			if changed {
+				kinds[i] = e.lossyKind(i)
+			}
+		case e.keyframe:
+			tile.dirty = false
+			kinds[i] = regionLossless
+		default:
+			// Video that stopped is sent once more without its artifacts
+			tile.dirty = tile.dirty || changed || wasVideo
+			if tile.dirty && e.frame%regionTextDivisor == 0 {
+				tile.dirty = false
+				kinds[i] = regionLossless
+			}
+		}
+	}
+
+	frame := make([]byte, regionsHeaderSize)
+	frame[0] = regionsDelta
+	if e.keyframe {
+		frame[0] = regionsKeyframe
+	}
+	binary.LittleEndian.PutUint32(frame[1:], uint32(width))
+	binary.LittleEndian.PutUint32(frame[5:], uint32(height))
+
+	canvas := &image.RGBA{Pix: pix, Stride: width * 4, Rect: image.Rect(0, 0, width, height)}
+	regions := mergeTiles(kinds, e.columns, e.rows)
+	for _, region := range regions {
+		rect := image.Rect(region.x*regionTileSize, region.y*regionTileSize,
+			(region.x+region.w)*regionTileSize, (region.y+region.h)*regionTileSize).Intersect(canvas.Rect)
+		data, err := e.encodeRegion(canvas.SubImage(rect), region.kind)
+		if err != nil {
+			return nil, err
+		}
+		var header [regionHeaderSize]byte
+		binary.LittleEndian.PutUint16(header[0:], uint16(rect.Min.X))
+		binary.LittleEndian.PutUint16(header[2:], uint16(rect.Min.Y))
+		binary.LittleEndian.PutUint16(header[4:], uint16(rect.Dx()))
+		binary.LittleEndian.PutUint16(header[6:], uint16(rect.Dy()))
+		header[8] = region.kind
+		if region.kind == regionLossyROI {
+			header[8] = regionLossy
+		}
+		binary.LittleEndian.PutUint32(header[9:], uint32(len(data)))
+		frame = append(frame, header[:]...)
+		frame = append(frame, data...)
+	}
+	binary.LittleEndian.PutUint16(frame[9:], uint16(len(regions)))
+
+	e.previous = pix
+	e.keyframe = false
+	e.frame++
+	return frame, nil
+}
+
+// lossyKind returns the kind of the i-th tile if it is sent lossy, telling
+// tiles in the region of interest apart
+func (e *regionsEncoder) lossyKind(i int) byte {
+	x, y := i%e.columns*regionTileSize, i/e.columns*regionTileSize
+	if e.roi.Overlaps(image.Rect(x, y, x+regionTileSize, y+regionTileSize)) {
+		return regionLossyROI
+	}
+	return regionLossy
+}
+
+// tileChanged reports whether the i-th tile differs from the previous frame
+func (e *regionsEncoder) tileChanged(pix []byte, i int) bool {
+	x, y := i%e.columns*regionTileSize, i/e.columns*regionTileSize
+	rowLen := min(regionTileSize, e.width-x) * 4
+	for row := y; row < min(y+regionTileSize, e.height); row++ {
+		offset := (row*e.width + x) * 4
+		if !bytes.Equal(pix[offset:offset+rowLen], e.previous[offset:offset+rowLen]) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeRegion encodes the image of a region, JPEG if it is lossy
+func (e *regionsEncoder) encodeRegion(img image.Image, kind byte) ([]byte, error) {
+	e.buf.Reset()
+	if kind == regionLossy || kind == regionLossyROI {
+		quality := e.quality
+		if !e.roi.Empty() {
+			inside, outside := roiQualities(quality)
+			quality = outside
+			if kind == regionLossyROI {
+				quality = inside
+			}
+		}
+		if err := jpegEncode(&e.buf, img, quality); err != nil {
+			return nil, err
+		}
+		return e.buf.Bytes(), nil
+	}
+	if paletted, ok := toPaletted(img); ok {
+		img = paletted
+	}
+	if err := e.png.Encode(&e.buf, img); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// SetQuality sets the JPEG quality of video tiles, text is lossless
+func (e *regionsEncoder) SetQuality(quality int) { e.quality = quality }
+
+// SetRegionOfInterest raises the quality of video tiles in roi and lowers
+// it elsewhere
+func (e *regionsEncoder) SetRegionOfInterest(roi image.Rectangle) { e.roi = roi }
+
+func (e *regionsEncoder) RequestKeyframe() { e.keyframe = true }
+
+// Deferred reports whether changed text tiles wait for a later frame
+func (e *regionsEncoder) Deferred() bool {
+	for _, tile := range e.tiles {
+		if tile.dirty {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *regionsEncoder) Close() error { return nil }
+
+// tileRegion is a rectangle of tiles sent together
+type tileRegion struct {
+	x, y, w, h int // In tiles
+	kind       byte
+}
+
+// mergeTiles joins the tiles to send into rectangles: runs of a kind in a
+// row, extended downwards over rows with the same run
+func mergeTiles(kinds []byte, columns, rows int) []tileRegion {
+	var regions []tileRegion
+	open := make(map[[3]int]int) // x, width and kind of runs in the row above -> index in regions
+	for y := 0; y < rows; y++ {
+		next := make(map[[3]int]int)
+		for x := 0; x < columns; {
+			kind := kinds[y*columns+x]
+			w := 1
+			for x+w < columns && kinds[y*columns+x+w] == kind {
+				w++
+			}
+			if kind != regionSkip {
+				key := [3]int{x, w, int(kind)}
+				if i, ok := open[key]; ok {
+					regions[i].h++
+					next[key] = i
+				} else {
+					next[key] = len(regions)
+					regions = append(regions, tileRegion{x, y, w, 1, kind})
+				}
+			}
+			x += w
+		}
+		open = next
+	}
+	return regions
+}
+
+// regionsDecoder draws the regions of each frame over the previous picture
+type regionsDecoder struct {
+	canvas *image.RGBA // Nil before the first keyframe
+}
+
+func newRegionsDecoder() *regionsDecoder {
+	return &regionsDecoder{}
+}
+
+func (d *regionsDecoder) Codec() ID { return Regions }
+
+func (d *regionsDecoder) Decode(data []byte) (image.Image, error) {
+	if len(data) < regionsHeaderSize {
+		return nil, errRegionsFrame
+	}
+	kind := data[0]
+	width := int(binary.LittleEndian.Uint32(data[1:]))
+	height := int(binary.LittleEndian.Uint32(data[5:]))
+	count := int(binary.LittleEndian.Uint16(data[9:]))
+	if kind != regionsKeyframe && kind != regionsDelta || width <= 0 || height <= 0 || width > 0xFFFF || height > 0xFFFF {
+		return nil, errRegionsFrame
+	}
+
+	// Deltas before the first keyframe have nothing to draw on
+	size := image.Pt(width, height)
+	if kind == regionsKeyframe {
+		d.canvas = image.NewRGBA(image.Rectangle{Max: size})
+	} else if d.canvas == nil || d.canvas.Rect.Size() != size {
+		return nil, nil
+	}
+
+	data = data[regionsHeaderSize:]
+	for i := 0; i < count; i++ {
+		if len(data) < regionHeaderSize {
+			return nil, errRegionsFrame
+		}
+		x, y := int(binary.LittleEndian.Uint16(data[0:])), int(binary.LittleEndian.Uint16(data[2:]))
+		w, h := int(binary.LittleEndian.Uint16(data[4:])), int(binary.LittleEndian.Uint16(data[6:]))
+		lossy := data[8] == regionLossy
+		length := int(binary.LittleEndian.Uint32(data[9:]))
+		data = data[regionHeaderSize:]
+		if length > len(data) {
+			return nil, errRegionsFrame
+		}
+
+		var img image.Image
+		var err error
+		if lossy {
+			img, err = jpegDecode(data[:length])
+		} else {
+			img, err = png.Decode(bytes.NewReader(data[:length]))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode region: %w", err)
+		}
+		rect := image.Rect(x, y, x+w, y+h).Intersect(d.canvas.Rect)
+		draw.Draw(d.canvas, rect, img, img.Bounds().Min, draw.Src)
+		data = data[length:]
+	}
+
+	// Pictures are handed to the display, each frame gets its own
+	img := image.NewRGBA(d.canvas.Rect)
+	copy(img.Pix, d.canvas.Pix)
+	return img, nil
+}
+
+func (d *regionsDecoder) Close() error { return nil }
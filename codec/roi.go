@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"image"
+	"image/draw"
+)
+
+const (
+	// roiQualityBoost is added to the quality inside the region of
+	// interest
+	roiQualityBoost = 15
+
+	// roiQualityCut is taken from the quality outside it
+	roiQualityCut = 20
+
+	// roiMaxQuality and roiMinQuality bound the qualities of both, higher
+	// ones cost many bits for little, lower ones smear text
+	roiMaxQuality = 95
+	roiMinQuality = 10
+)
+
+// RegionEncoder is implemented by encoders that can spend more of a frame's
+// bits on a region of interest, like the area around the cursor where the
+// user is looking, and fewer elsewhere. SetRegionOfInterest sets it in the
+// frame's coordinates for the following frames; an empty rectangle encodes
+// the whole frame alike.
+type RegionEncoder interface {
+	SetRegionOfInterest(roi image.Rectangle)
+}
+
+// roiQualities returns the qualities inside and outside the region of
+// interest of a frame encoded at quality
+func roiQualities(quality int) (inside, outside int) {
+	inside = max(min(quality+roiQualityBoost, roiMaxQuality), quality)
+	outside = min(max(quality-roiQualityCut, roiMinQuality), quality)
+	return inside, outside
+}
+
+// smoothOutside copies img into dst with the detail outside roi halved:
+// every 2x2 block of pixels there is set to its average. Encoders given one
+// quality for the whole frame spend about a fifth fewer bits on screen
+// content that way. dst is reused if it has the right size.
+func smoothOutside(dst *image.RGBA, img image.Image, roi image.Rectangle) *image.RGBA {
+	bounds := img.Bounds()
+	if dst == nil || dst.Rect != bounds {
+		dst = image.NewRGBA(bounds)
+	}
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+
+	// Whole blocks inside the region keep their pixels
+	inner := image.Rect(roi.Min.X+(roi.Min.X-bounds.Min.X)&1, roi.Min.Y+(roi.Min.Y-bounds.Min.Y)&1, roi.Max.X, roi.Max.Y)
+	for y := bounds.Min.Y; y+1 < bounds.Max.Y; y += 2 {
+		top := dst.PixOffset(bounds.Min.X, y)
+		bottom := top + dst.Stride
+		for x := bounds.Min.X; x+1 < bounds.Max.X; x, top, bottom = x+2, top+8, bottom+8 {
+			if image.Rect(x, y, x+2, y+2).In(inner) {
+				continue
+			}
+			for c := 0; c < 4; c++ {
+				v := byte((int(dst.Pix[top+c]) + int(dst.Pix[top+4+c]) + int(dst.Pix[bottom+c]) + int(dst.Pix[bottom+4+c]) + 2) / 4)
+				dst.Pix[top+c], dst.Pix[top+4+c], dst.Pix[bottom+c], dst.Pix[bottom+4+c] = v, v, v, v
+			}
+		}
+	}
+	return dst
+}
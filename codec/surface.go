@@ -0,0 +1,40 @@
+package codec
+
+import (
+	"image"
+	"unsafe"
+)
+
+// Surface is a captured frame kept in GPU memory, e.g. an IOSurface backed
+// pixel buffer on macOS. It is an image too, so any encoder can take it,
+// but its pixels are copied to memory on first use; encoders implementing
+// SurfaceEncoder read it without that round trip.
+type Surface interface {
+	image.Image
+
+	// Pixels returns the frame copied to memory, once per surface
+	Pixels() *image.RGBA
+
+	// Native returns the platform's handle of the frame, a CVPixelBufferRef
+	// on macOS
+	Native() unsafe.Pointer
+
+	// Sequence changes with the frame's content, surfaces with the same
+	// sequence show the same picture
+	Sequence() uint64
+}
+
+// SurfaceEncoder is implemented by encoders that take frames in GPU memory
+// directly, like the hardware encoder the GPU shares them with
+type SurfaceEncoder interface {
+	EncodeSurface(s Surface) ([]byte, error)
+}
+
+// InMemory returns the pixels of an image in memory, copying surfaces out
+// of GPU memory
+func InMemory(img image.Image) image.Image {
+	if s, ok := img.(Surface); ok {
+		return s.Pixels()
+	}
+	return img
+}
@@ -0,0 +1,263 @@
+//go:build cgo && svtav1
+
+package codec
+
+/*
+#cgo pkg-config: SvtAv1Enc
+#include <stdlib.h>
+#include <string.h>
+#include <svt-av1/EbSvtAv1Enc.h>
+
+// svt_encoder is an SVT-AV1 encoder in its real-time low delay mode
+typedef struct {
+	EbComponentType *handle;
+	uint8_t *out;
+	uint32_t out_len;
+} svt_encoder;
+
+// Rate control modes, numbered like codec.RateMode
+#define SVT_RATE_CBR 1
+#define SVT_RATE_VBR 2
+#define SVT_RATE_CQ 3
+
+static int svt_encoder_create(svt_encoder *e, uint32_t width, uint32_t height, uint32_t fps, int mode, uint32_t bitrate, uint32_t qp) {
+	EbSvtAv1EncConfiguration config;
+#if SVT_AV1_CHECK_VERSION(3, 0, 0)
+	EbErrorType err = svt_av1_enc_init_handle(&e->handle, &config);
+#else
+	EbErrorType err = svt_av1_enc_init_handle(&e->handle, NULL, &config);
+#endif
+	if (err != EB_ErrorNone) {
+		e->handle = NULL;
+		return err;
+	}
+
+	// Fast preset, no B-frames or lookahead, keyframes on request
+	config.source_width = width;
+	config.source_height = height;
+	config.frame_rate_numerator = fps;
+	config.frame_rate_denominator = 1;
+	config.encoder_bit_depth = 8;
+	config.enc_mode = 10;
+	config.pred_structure = SVT_AV1_PRED_LOW_DELAY_B;
+	config.intra_period_length = -1;
+	config.look_ahead_distance = 0;
+	switch (mode) {
+	case SVT_RATE_CQ:
+		config.rate_control_mode = SVT_AV1_RC_MODE_CQP_OR_CRF;
+		config.qp = qp;
+		break;
+	case SVT_RATE_VBR:
+		// The low delay mode has no VBR, CBR may overshoot instead
+		config.rate_control_mode = SVT_AV1_RC_MODE_CBR;
+		config.target_bit_rate = bitrate;
+		config.over_shoot_pct = 100;
+		break;
+	default:
+		config.rate_control_mode = SVT_AV1_RC_MODE_CBR;
+		config.target_bit_rate = bitrate;
+	}
+
+	if ((err = svt_av1_enc_set_parameter(e->handle, &config)) != EB_ErrorNone) {
+		return err;
+	}
+	return svt_av1_enc_init(e->handle);
+}
+
+static void svt_encoder_free(svt_encoder *e) {
+	if (e->handle != NULL) {
+		// Drain the pipeline before tearing it down
+		EbBufferHeaderType eos = {0};
+		eos.size = sizeof(eos);
+		eos.flags = EB_BUFFERFLAG_EOS;
+		if (svt_av1_enc_send_picture(e->handle, &eos) == EB_ErrorNone) {
+			EbBufferHeaderType *packet;
+			while (svt_av1_enc_get_packet(e->handle, &packet, 1) == EB_ErrorNone) {
+				int done = packet->flags & EB_BUFFERFLAG_EOS;
+				svt_av1_enc_release_out_buffer(&packet);
+				if (done) {
+					break;
+				}
+			}
+		}
+		svt_av1_enc_deinit(e->handle);
+		svt_av1_enc_deinit_handle(e->handle);
+	}
+	free(e->out);
+	free(e);
+}
+
+// svt_send queues a frame of 4:2:0 planes, the encoder copies them
+static int svt_send(svt_encoder *e, uint8_t *y, uint8_t *cb, uint8_t *cr, uint32_t width, uint32_t height, int64_t frame, int keyframe) {
+	EbSvtIOFormat picture = {0};
+	picture.luma = y;
+	picture.cb = cb;
+	picture.cr = cr;
+	picture.y_stride = width;
+	picture.cb_stride = width / 2;
+	picture.cr_stride = width / 2;
+	picture.width = width;
+	picture.height = height;
+	picture.color_fmt = EB_YUV420;
+	picture.bit_depth = EB_EIGHT_BIT;
+
+	EbBufferHeaderType input = {0};
+	input.size = sizeof(input);
+	input.p_buffer = (uint8_t *)&picture;
+	input.n_filled_len = width * height * 3 / 2;
+	input.pts = frame;
+	input.pic_type = keyframe ? EB_AV1_KEY_PICTURE : EB_AV1_INVALID_PICTURE;
+	return svt_av1_enc_send_picture(e->handle, &input);
+}
+
+// svt_receive copies the next encoded frame to e->out. It returns 1 when
+// there was none yet.
+static int svt_receive(svt_encoder *e) {
+	EbBufferHeaderType *packet;
+	EbErrorType err = svt_av1_enc_get_packet(e->handle, &packet, 0);
+	if (err == EB_NoErrorEmptyQueue) {
+		return 1;
+	}
+	if (err != EB_ErrorNone) {
+		return err;
+	}
+	e->out = realloc(e->out, packet->n_filled_len);
+	memcpy(e->out, packet->p_buffer, packet->n_filled_len);
+	e->out_len = packet->n_filled_len;
+	svt_av1_enc_release_out_buffer(&packet);
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"time"
+	"unsafe"
+)
+
+// svtMaxWait is how long Encode waits for the encoder's pipeline to
+// deliver a frame, in low delay mode it does so within a few milliseconds
+const svtMaxWait = 100 * time.Millisecond
+
+func init() {
+	// Software encoding comes after every hardware encoder
+	RegisterEncoder(5, "svtav1", AV1, always, func(width, height int) (Encoder, error) {
+		return newSVTEncoder(width, height)
+	})
+}
+
+// svtEncoder encodes AV1 in software with SVT-AV1. It needs a few cores
+// per monitor, so it's meant for links too slow for H.264.
+type svtEncoder struct {
+	session       *C.svt_encoder
+	width, height int
+	frame         int64
+	quality       int
+	rate          RateControl
+	rc            rateSettings // Rate control the encoder runs with
+	keyframe      bool         // Force the next frame to be a keyframe
+}
+
+// svtMaxQP is the largest quantizer of SVT-AV1's 0-63 scale
+const svtMaxQP = 63
+
+func newSVTEncoder(width, height int) (*svtEncoder, error) {
+	// 4:2:0 needs even dimensions, see toYUV420
+	width, height = width&^1, height&^1
+	e := &svtEncoder{
+		width:    width,
+		height:   height,
+		quality:  defaultJPEGQuality,
+		keyframe: true,
+	}
+	e.rc = e.rate.settings(e.quality, width, height, svtMaxQP)
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// open starts an encoder with the current rate control, SVT-AV1 can't
+// change it in place
+func (e *svtEncoder) open() error {
+	e.session = (*C.svt_encoder)(C.calloc(1, C.sizeof_svt_encoder))
+	if err := C.svt_encoder_create(e.session, C.uint32_t(e.width), C.uint32_t(e.height), hardwareFrameRate,
+		C.int(e.rc.mode), C.uint32_t(e.rc.bitrate), C.uint32_t(e.rc.qp)); err != 0 {
+		e.Close()
+		return fmt.Errorf("failed to create SVT-AV1 encoder: error 0x%X", uint32(err))
+	}
+	return nil
+}
+
+func (e *svtEncoder) Codec() ID { return AV1 }
+
+func (e *svtEncoder) Encode(img image.Image) ([]byte, error) {
+	if e.session == nil {
+		return nil, errors.New("SVT-AV1 encoder failed to reopen")
+	}
+	bounds := img.Bounds()
+	if bounds.Dx()&^1 != e.width || bounds.Dy()&^1 != e.height {
+		return nil, fmt.Errorf("frame is %dx%d, the encoder was created for %dx%d", bounds.Dx(), bounds.Dy(), e.width, e.height)
+	}
+	if rc := e.rate.settings(e.quality, e.width, e.height, svtMaxQP); rc.changed(e.rc) {
+		e.Close()
+		e.rc = rc
+		if err := e.open(); err != nil {
+			return nil, err
+		}
+		e.keyframe = true
+	}
+
+	frame := toYUV420(img)
+	keyframe := C.int(0)
+	if e.keyframe {
+		keyframe = 1
+	}
+	err := C.svt_send(e.session,
+		(*C.uint8_t)(unsafe.Pointer(&frame.y[0])),
+		(*C.uint8_t)(unsafe.Pointer(&frame.cb[0])),
+		(*C.uint8_t)(unsafe.Pointer(&frame.cr[0])),
+		C.uint32_t(e.width), C.uint32_t(e.height), C.int64_t(e.frame), keyframe)
+	e.frame++
+	if err != 0 {
+		return nil, fmt.Errorf("SVT-AV1 encoding failed: error 0x%X", uint32(err))
+	}
+
+	// The encoder works on its own threads
+	deadline := time.Now().Add(svtMaxWait)
+	for {
+		status := C.svt_receive(e.session)
+		if status == 0 {
+			break
+		}
+		if status != 1 {
+			return nil, fmt.Errorf("SVT-AV1 encoding failed: error 0x%X", uint32(status))
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("SVT-AV1 encoder returned no frame")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	e.keyframe = false
+
+	// Temporal units start with a temporal delimiter, keyframes carry the
+	// sequence header
+	return C.GoBytes(unsafe.Pointer(e.session.out), C.int(e.session.out_len)), nil
+}
+
+func (e *svtEncoder) SetQuality(quality int) { e.quality = quality }
+
+func (e *svtEncoder) SetRateControl(rc RateControl) { e.rate = rc }
+
+func (e *svtEncoder) RequestKeyframe() { e.keyframe = true }
+
+func (e *svtEncoder) Close() error {
+	if e.session != nil {
+		C.svt_encoder_free(e.session)
+		e.session = nil
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package codec
+
+import (
+	"image"
+	"math"
+	"slices"
+)
+
+const (
+	// textBlockSize is the size of the blocks IsText looks at
+	textBlockSize = 16
+
+	// textSampleBlocks is about how many blocks IsText looks at, spread
+	// over the frame
+	textSampleBlocks = 1000
+)
+
+// IsText reports whether a frame is mostly text or flat user interface,
+// e.g. a terminal or code editor, as opposed to photos or video. In blocks
+// of text most pixels are the background color, antialiasing only adds a
+// few shades around the glyphs; in blocks of photos no color dominates.
+func IsText(img image.Image) bool {
+	bounds := img.Bounds()
+	columns, rows := bounds.Dx()/textBlockSize, bounds.Dy()/textBlockSize
+	if columns == 0 || rows == 0 {
+		return false
+	}
+	step := max(1, int(math.Sqrt(float64(columns*rows)/textSampleBlocks)))
+
+	var pixels [textBlockSize * textBlockSize]uint32
+	detailed, text := 0, 0
+	for row := 0; row < rows; row += step {
+		for column := 0; column < columns; column += step {
+			origin := bounds.Min.Add(image.Pt(column*textBlockSize, row*textBlockSize))
+			readBlock(img, origin, pixels[:])
+
+			slices.Sort(pixels[:])
+			if pixels[0] == pixels[len(pixels)-1] {
+				continue // Flat blocks look the same in any content
+			}
+			detailed++
+			if longestRun(pixels[:]) >= len(pixels)/2 {
+				text++
+			}
+		}
+	}
+	return text*10 >= detailed*8
+}
+
+// readBlock reads the pixels of a block as RGB values
+func readBlock(img image.Image, origin image.Point, pixels []uint32) {
+	i := 0
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < textBlockSize; y++ {
+			row := rgba.Pix[rgba.PixOffset(origin.X, origin.Y+y):]
+			for x := 0; x < textBlockSize*4; x += 4 {
+				pixels[i] = uint32(row[x])<<16 | uint32(row[x+1])<<8 | uint32(row[x+2])
+				i++
+			}
+		}
+		return
+	}
+	for y := 0; y < textBlockSize; y++ {
+		for x := 0; x < textBlockSize; x++ {
+			r, g, b, _ := img.At(origin.X+x, origin.Y+y).RGBA()
+			pixels[i] = r>>8<<16 | g>>8<<8 | b>>8
+			i++
+		}
+	}
+}
+
+// longestRun returns the length of the longest run of equal values in a
+// sorted slice
+func longestRun(values []uint32) int {
+	longest, run := 0, 0
+	for i := range values {
+		if i > 0 && values[i] == values[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	return longest
+}
@@ -0,0 +1,117 @@
+//go:build cgo && turbojpeg
+
+package codec
+
+/*
+#cgo pkg-config: libturbojpeg
+#include <stdio.h>
+#include <stdlib.h>
+#include <turbojpeg.h>
+
+// tj_encode compresses RGBX pixels to a baseline JPEG with 4:2:0 chroma
+// subsampling and the standard Huffman tables, like image/jpeg, so strips
+// encoded separately can be joined, see encodeJPEGStrips. The caller frees
+// *out with tjFree. It returns 0 on success and copies the error message
+// to err otherwise.
+static int tj_encode(const unsigned char *pix, int width, int stride, int height, int quality, unsigned char **out, unsigned long *size, char *err, size_t err_len) {
+	tjhandle handle = tjInitCompress();
+	if (handle == NULL) {
+		snprintf(err, err_len, "%s", tjGetErrorStr2(NULL));
+		return -1;
+	}
+	int status = tjCompress2(handle, pix, width, stride, height, TJPF_RGBX, out, size, TJSAMP_420, quality, TJFLAG_FASTDCT);
+	if (status != 0) {
+		snprintf(err, err_len, "%s", tjGetErrorStr2(handle));
+	}
+	tjDestroy(handle);
+	return status;
+}
+
+// tj_size reads the dimensions of a JPEG image
+static int tj_size(const unsigned char *data, unsigned long size, int *width, int *height, char *err, size_t err_len) {
+	tjhandle handle = tjInitDecompress();
+	if (handle == NULL) {
+		snprintf(err, err_len, "%s", tjGetErrorStr2(NULL));
+		return -1;
+	}
+	int subsampling, colorspace;
+	int status = tjDecompressHeader3(handle, data, size, width, height, &subsampling, &colorspace);
+	if (status != 0) {
+		snprintf(err, err_len, "%s", tjGetErrorStr2(handle));
+	}
+	tjDestroy(handle);
+	return status;
+}
+
+// tj_decode decompresses a JPEG image to RGBA pixels
+static int tj_decode(const unsigned char *data, unsigned long size, unsigned char *pix, int width, int stride, int height, char *err, size_t err_len) {
+	tjhandle handle = tjInitDecompress();
+	if (handle == NULL) {
+		snprintf(err, err_len, "%s", tjGetErrorStr2(NULL));
+		return -1;
+	}
+	int status = tjDecompress2(handle, data, size, pix, width, stride, height, TJPF_RGBA, TJFLAG_FASTDCT);
+	if (status != 0) {
+		snprintf(err, err_len, "%s", tjGetErrorStr2(handle));
+	}
+	tjDestroy(handle);
+	return status;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"unsafe"
+)
+
+// tjErrorSize is the size of the buffers libjpeg-turbo's error messages are
+// copied to
+const tjErrorSize = 200
+
+func init() {
+	jpegEncode = turboEncode
+	jpegDecode = turboDecode
+}
+
+// turboEncode encodes an image as JPEG with libjpeg-turbo, several times as
+// fast as image/jpeg
+func turboEncode(w io.Writer, img image.Image, quality int) error {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return errors.New("empty frame")
+	}
+	pix, stride := rgbaPixels(img)
+
+	var out *C.uchar
+	var size C.ulong
+	var message [tjErrorSize]C.char
+	if C.tj_encode((*C.uchar)(unsafe.Pointer(&pix[0])), C.int(bounds.Dx()), C.int(stride), C.int(bounds.Dy()), C.int(quality), &out, &size, &message[0], tjErrorSize) != 0 {
+		return fmt.Errorf("JPEG encoding failed: %s", C.GoString(&message[0]))
+	}
+	defer C.tjFree(out)
+	_, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(out)), int(size)))
+	return err
+}
+
+// turboDecode decodes a JPEG image with libjpeg-turbo
+func turboDecode(data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty JPEG frame")
+	}
+	in := (*C.uchar)(unsafe.Pointer(&data[0]))
+	var width, height C.int
+	var message [tjErrorSize]C.char
+	if C.tj_size(in, C.ulong(len(data)), &width, &height, &message[0], tjErrorSize) != 0 {
+		return nil, fmt.Errorf("invalid JPEG frame: %s", C.GoString(&message[0]))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	if C.tj_decode(in, C.ulong(len(data)), (*C.uchar)(unsafe.Pointer(&img.Pix[0])), width, C.int(img.Stride), height, &message[0], tjErrorSize) != 0 {
+		return nil, fmt.Errorf("JPEG decoding failed: %s", C.GoString(&message[0]))
+	}
+	return img, nil
+}
@@ -0,0 +1,332 @@
+//go:build cgo && vaapi
+
+package codec
+
+/*
+#cgo pkg-config: libavcodec libavutil
+#include <stdlib.h>
+#include <string.h>
+#include <libavcodec/avcodec.h>
+#include <libavutil/hwcontext.h>
+#include <libavutil/opt.h>
+
+// va_encoder is an FFmpeg VAAPI encoder: frames are uploaded from NV12 in
+// system memory to surfaces of the device and encoded there
+typedef struct {
+	AVBufferRef *device;
+	AVBufferRef *frames;
+	AVCodecContext *context;
+	AVFrame *upload;
+	AVFrame *surface;
+	AVPacket *packet;
+	uint8_t *out;
+	int out_len;
+} va_encoder;
+
+static void va_encoder_close_context(va_encoder *e) {
+	avcodec_free_context(&e->context);
+}
+
+static void va_encoder_free(va_encoder *e) {
+	va_encoder_close_context(e);
+	av_frame_free(&e->upload);
+	av_frame_free(&e->surface);
+	av_packet_free(&e->packet);
+	av_buffer_unref(&e->frames);
+	av_buffer_unref(&e->device);
+	free(e->out);
+	free(e);
+}
+
+// Rate control modes, numbered like codec.RateMode
+#define VA_RATE_CBR 1
+#define VA_RATE_VBR 2
+#define VA_RATE_CQ 3
+
+// va_encoder_open opens the codec context, again whenever the rate control
+// changes as the VAAPI encoders can't change it in place
+static int va_encoder_open(va_encoder *e, const char *codec_name, int width, int height, int fps, int mode, int64_t bitrate, int qp) {
+	va_encoder_close_context(e);
+	const AVCodec *codec = avcodec_find_encoder_by_name(codec_name);
+	if (codec == NULL) {
+		return AVERROR_ENCODER_NOT_FOUND;
+	}
+	e->context = avcodec_alloc_context3(codec);
+	if (e->context == NULL) {
+		return AVERROR(ENOMEM);
+	}
+
+	// Low latency: no B-frames, no lookahead, keyframes only on request
+	e->context->width = width;
+	e->context->height = height;
+	e->context->time_base = (AVRational){1, fps};
+	e->context->framerate = (AVRational){fps, 1};
+	e->context->pix_fmt = AV_PIX_FMT_VAAPI;
+	e->context->max_b_frames = 0;
+	e->context->gop_size = INT32_MAX;
+	e->context->flags |= AV_CODEC_FLAG_LOW_DELAY;
+	e->context->hw_frames_ctx = av_buffer_ref(e->frames);
+	av_opt_set_int(e->context->priv_data, "async_depth", 1, 0);
+	switch (mode) {
+	case VA_RATE_CQ:
+		e->context->global_quality = qp;
+		av_opt_set(e->context->priv_data, "rc_mode", "CQP", 0);
+		break;
+	case VA_RATE_VBR:
+		e->context->bit_rate = bitrate;
+		e->context->rc_max_rate = bitrate * 2;
+		e->context->rc_buffer_size = bitrate * 2 / fps;
+		av_opt_set(e->context->priv_data, "rc_mode", "VBR", 0);
+		break;
+	default:
+		e->context->bit_rate = bitrate;
+		e->context->rc_max_rate = bitrate;
+		e->context->rc_buffer_size = bitrate / fps;
+		av_opt_set(e->context->priv_data, "rc_mode", "CBR", 0);
+	}
+
+	return avcodec_open2(e->context, codec, NULL);
+}
+
+static int va_encoder_create(va_encoder *e, const char *device, const char *codec_name, int width, int height, int fps, int mode, int64_t bitrate, int qp) {
+	int err = av_hwdevice_ctx_create(&e->device, AV_HWDEVICE_TYPE_VAAPI, device, NULL, 0);
+	if (err < 0) {
+		return err;
+	}
+
+	e->frames = av_hwframe_ctx_alloc(e->device);
+	if (e->frames == NULL) {
+		return AVERROR(ENOMEM);
+	}
+	AVHWFramesContext *frames = (AVHWFramesContext *)e->frames->data;
+	frames->format = AV_PIX_FMT_VAAPI;
+	frames->sw_format = AV_PIX_FMT_NV12;
+	frames->width = width;
+	frames->height = height;
+	frames->initial_pool_size = 4;
+	if ((err = av_hwframe_ctx_init(e->frames)) < 0) {
+		return err;
+	}
+
+	e->upload = av_frame_alloc();
+	e->surface = av_frame_alloc();
+	e->packet = av_packet_alloc();
+	if (e->upload == NULL || e->surface == NULL || e->packet == NULL) {
+		return AVERROR(ENOMEM);
+	}
+	e->upload->format = AV_PIX_FMT_NV12;
+	e->upload->width = width;
+	e->upload->height = height;
+	if ((err = av_frame_get_buffer(e->upload, 0)) < 0) {
+		return err;
+	}
+
+	return va_encoder_open(e, codec_name, width, height, fps, mode, bitrate, qp);
+}
+
+// va_encode encodes a frame of tightly packed NV12
+static int va_encode(va_encoder *e, const uint8_t *nv12, int width, int height, int64_t frame, int keyframe) {
+	int err = av_frame_make_writable(e->upload);
+	if (err < 0) {
+		return err;
+	}
+	for (int y = 0; y < height; y++) {
+		memcpy(e->upload->data[0] + (size_t)y*e->upload->linesize[0], nv12 + (size_t)y*width, width);
+	}
+	const uint8_t *chroma = nv12 + (size_t)width*height;
+	for (int y = 0; y < height/2; y++) {
+		memcpy(e->upload->data[1] + (size_t)y*e->upload->linesize[1], chroma + (size_t)y*width, width);
+	}
+
+	av_frame_unref(e->surface);
+	if ((err = av_hwframe_get_buffer(e->frames, e->surface, 0)) < 0) {
+		return err;
+	}
+	if ((err = av_hwframe_transfer_data(e->surface, e->upload, 0)) < 0) {
+		return err;
+	}
+	e->surface->pts = frame;
+	// An intra picture requested by the caller is encoded as IDR
+	e->surface->pict_type = keyframe ? AV_PICTURE_TYPE_I : AV_PICTURE_TYPE_NONE;
+
+	if ((err = avcodec_send_frame(e->context, e->surface)) < 0) {
+		return err;
+	}
+	e->out_len = 0;
+	while ((err = avcodec_receive_packet(e->context, e->packet)) == 0) {
+		e->out = realloc(e->out, e->out_len + e->packet->size);
+		memcpy(e->out + e->out_len, e->packet->data, e->packet->size);
+		e->out_len += e->packet->size;
+		av_packet_unref(e->packet);
+	}
+	if (err != AVERROR(EAGAIN)) {
+		return err;
+	}
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"path/filepath"
+	"sync"
+	"unsafe"
+)
+
+// vaapiCodecs maps codecs to the FFmpeg VAAPI encoders producing them
+var vaapiCodecs = map[ID]string{
+	H264: "h264_vaapi",
+	HEVC: "hevc_vaapi",
+	AV1:  "av1_vaapi",
+}
+
+func init() {
+	for id := range vaapiCodecs {
+		RegisterEncoder(15, "vaapi", id, func() bool { return vaapiAvailable(id) }, func(width, height int) (Encoder, error) {
+			return newVAAPIEncoder(id, width, height)
+		})
+	}
+}
+
+var (
+	vaapiProbe  sync.Once
+	vaapiDevice string // Render node that encoded a probe frame, empty if none did
+)
+
+// vaapiAvailable reports whether a render node can encode a codec. Intel
+// GPUs encode through QuickSync and AMD GPUs through VCN; without a
+// working device monitors fall back to JPEG.
+func vaapiAvailable(id ID) bool {
+	vaapiProbe.Do(func() {
+		nodes, _ := filepath.Glob("/dev/dri/renderD*")
+		for _, node := range nodes {
+			vaapiDevice = node
+			if e, err := newVAAPIEncoder(H264, probeSize, probeSize); err == nil {
+				e.Close()
+				return
+			}
+		}
+		vaapiDevice = ""
+	})
+	if vaapiDevice == "" {
+		return false
+	}
+	if id == H264 {
+		return true
+	}
+	e, err := newVAAPIEncoder(id, probeSize, probeSize)
+	if err != nil {
+		return false
+	}
+	e.Close()
+	return true
+}
+
+// vaapiEncoder encodes H.264, HEVC or AV1 on a VAAPI device through FFmpeg
+type vaapiEncoder struct {
+	session       *C.va_encoder
+	codec         ID
+	name          *C.char
+	width, height int
+	frame         int64
+	quality       int
+	rate          RateControl
+	rc            rateSettings // Rate control the context was opened with
+	keyframe      bool         // Force the next frame to be a keyframe
+}
+
+func newVAAPIEncoder(id ID, width, height int) (*vaapiEncoder, error) {
+	// NV12 needs even dimensions, see toNV12
+	width, height = width&^1, height&^1
+	e := &vaapiEncoder{
+		codec:    id,
+		name:     C.CString(vaapiCodecs[id]),
+		width:    width,
+		height:   height,
+		quality:  defaultJPEGQuality,
+		keyframe: true,
+	}
+	e.rc = e.rate.settings(e.quality, width, height, vaapiMaxQP(id))
+
+	device := C.CString(vaapiDevice)
+	defer C.free(unsafe.Pointer(device))
+	e.session = (*C.va_encoder)(C.calloc(1, C.sizeof_va_encoder))
+	if err := C.va_encoder_create(e.session, device, e.name, C.int(width), C.int(height), hardwareFrameRate, C.int(e.rc.mode), C.int64_t(e.rc.bitrate), C.int(e.rc.qp)); err < 0 {
+		e.Close()
+		return nil, fmt.Errorf("failed to open VAAPI %s encoder on %s: %w", id, vaapiDevice, avError(err))
+	}
+	return e, nil
+}
+
+// avError converts an FFmpeg error code
+func avError(err C.int) error {
+	buf := make([]byte, 128)
+	C.av_strerror(err, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)))
+	return errors.New(C.GoString((*C.char)(unsafe.Pointer(&buf[0]))))
+}
+
+// vaapiMaxQP returns the highest quantizer of a codec, AV1 quantizer
+// indices go further
+func vaapiMaxQP(id ID) int {
+	if id == AV1 {
+		return 255
+	}
+	return 51
+}
+
+func (e *vaapiEncoder) Codec() ID { return e.codec }
+
+func (e *vaapiEncoder) Encode(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	if bounds.Dx()&^1 != e.width || bounds.Dy()&^1 != e.height {
+		return nil, fmt.Errorf("frame is %dx%d, the encoder was created for %dx%d", bounds.Dx(), bounds.Dy(), e.width, e.height)
+	}
+
+	if rc := e.rate.settings(e.quality, e.width, e.height, vaapiMaxQP(e.codec)); rc.changed(e.rc) {
+		if err := C.va_encoder_open(e.session, e.name, C.int(e.width), C.int(e.height), hardwareFrameRate, C.int(rc.mode), C.int64_t(rc.bitrate), C.int(rc.qp)); err < 0 {
+			return nil, fmt.Errorf("failed to change VAAPI rate control: %w", avError(err))
+		}
+		e.rc = rc
+		e.keyframe = true
+	}
+
+	nv12 := toNV12(img)
+	keyframe := C.int(0)
+	if e.keyframe {
+		keyframe = 1
+	}
+	err := C.va_encode(e.session, (*C.uint8_t)(unsafe.Pointer(&nv12[0])), C.int(e.width), C.int(e.height), C.int64_t(e.frame), keyframe)
+	e.frame++
+	if err < 0 {
+		return nil, fmt.Errorf("VAAPI encoding failed: %w", avError(err))
+	}
+	if e.session.out_len == 0 {
+		return nil, errors.New("VAAPI encoder returned no frame")
+	}
+	e.keyframe = false
+
+	// FFmpeg writes Annex B with the parameter sets on keyframes, and AV1
+	// temporal units with the sequence header
+	return C.GoBytes(unsafe.Pointer(e.session.out), e.session.out_len), nil
+}
+
+func (e *vaapiEncoder) SetQuality(quality int) { e.quality = quality }
+
+func (e *vaapiEncoder) SetRateControl(rc RateControl) { e.rate = rc }
+
+func (e *vaapiEncoder) RequestKeyframe() { e.keyframe = true }
+
+func (e *vaapiEncoder) Close() error {
+	if e.session != nil {
+		C.va_encoder_free(e.session)
+		e.session = nil
+	}
+	if e.name != nil {
+		C.free(unsafe.Pointer(e.name))
+		e.name = nil
+	}
+	return nil
+}
@@ -0,0 +1,564 @@
+//go:build darwin && cgo
+
+package codec
+
+/*
+#cgo LDFLAGS: -framework VideoToolbox -framework CoreMedia -framework CoreVideo -framework CoreFoundation
+#include <stdlib.h>
+#include <string.h>
+#include <VideoToolbox/VideoToolbox.h>
+
+static const uint8_t vt_start_code[4] = {0, 0, 0, 1};
+
+// vt_buffer is a growable output buffer
+typedef struct {
+	uint8_t *data;
+	size_t len;
+	size_t cap;
+} vt_buffer;
+
+static void vt_append(vt_buffer *b, const uint8_t *data, size_t len) {
+	if (b->len + len > b->cap) {
+		size_t cap = (b->len + len) * 2;
+		b->data = realloc(b->data, cap);
+		b->cap = cap;
+	}
+	memcpy(b->data + b->len, data, len);
+	b->len += len;
+}
+
+// vt_parameter_set returns a parameter set of an H.264 or HEVC stream, or
+// with set NULL the number of sets in count
+static OSStatus vt_parameter_set(int hevc, CMFormatDescriptionRef format, size_t index, const uint8_t **set, size_t *size, size_t *count) {
+	if (hevc) {
+		return CMVideoFormatDescriptionGetHEVCParameterSetAtIndex(format, index, set, size, count, NULL);
+	}
+	return CMVideoFormatDescriptionGetH264ParameterSetAtIndex(format, index, set, size, count, NULL);
+}
+
+// vt_encoder is an H.264 or HEVC compression session and the output of the
+// last frame, converted to Annex B
+typedef struct {
+	VTCompressionSessionRef session;
+	int hevc;
+	vt_buffer out;
+	OSStatus status;
+} vt_encoder;
+
+static void vt_encoded(void *ref, void *frameRef, OSStatus status, VTEncodeInfoFlags flags, CMSampleBufferRef sample) {
+	vt_encoder *e = ref;
+	e->status = status;
+	if (status != noErr || sample == NULL || !CMSampleBufferDataIsReady(sample)) {
+		return;
+	}
+
+	// Keyframes are prefixed with the parameter sets
+	int keyframe = 1;
+	CFArrayRef attachments = CMSampleBufferGetSampleAttachmentsArray(sample, false);
+	if (attachments != NULL && CFArrayGetCount(attachments) > 0) {
+		CFDictionaryRef attachment = CFArrayGetValueAtIndex(attachments, 0);
+		keyframe = !CFDictionaryContainsKey(attachment, kCMSampleAttachmentKey_NotSync);
+	}
+	if (keyframe) {
+		CMFormatDescriptionRef format = CMSampleBufferGetFormatDescription(sample);
+		size_t count = 0;
+		vt_parameter_set(e->hevc, format, 0, NULL, NULL, &count);
+		for (size_t i = 0; i < count; i++) {
+			const uint8_t *set;
+			size_t size;
+			if (vt_parameter_set(e->hevc, format, i, &set, &size, NULL) == noErr) {
+				vt_append(&e->out, vt_start_code, 4);
+				vt_append(&e->out, set, size);
+			}
+		}
+	}
+
+	// Replace the length prefixes of the NAL units with start codes
+	CMBlockBufferRef block = CMSampleBufferGetDataBuffer(sample);
+	size_t total = CMBlockBufferGetDataLength(block);
+	uint8_t *avcc = malloc(total);
+	if (CMBlockBufferCopyDataBytes(block, 0, total, avcc) != kCMBlockBufferNoErr) {
+		free(avcc);
+		e->status = kVTVideoEncoderMalfunctionErr;
+		return;
+	}
+	size_t offset = 0;
+	while (offset + 4 <= total) {
+		uint32_t size = (uint32_t)avcc[offset] << 24 | (uint32_t)avcc[offset+1] << 16 |
+			(uint32_t)avcc[offset+2] << 8 | (uint32_t)avcc[offset+3];
+		offset += 4;
+		if (size > total - offset) {
+			break;
+		}
+		vt_append(&e->out, vt_start_code, 4);
+		vt_append(&e->out, avcc + offset, size);
+		offset += size;
+	}
+	free(avcc);
+}
+
+static OSStatus vt_encoder_create(vt_encoder *e, int hevc, int32_t width, int32_t height) {
+	e->hevc = hevc;
+	CMVideoCodecType codec = hevc ? kCMVideoCodecType_HEVC : kCMVideoCodecType_H264;
+	CFMutableDictionaryRef spec = CFDictionaryCreateMutable(NULL, 0, &kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFDictionarySetValue(spec, kVTVideoEncoderSpecification_EnableHardwareAcceleratedVideoEncoder, kCFBooleanTrue);
+	OSStatus status = VTCompressionSessionCreate(NULL, width, height, codec, spec, NULL, NULL, vt_encoded, e, &e->session);
+	CFRelease(spec);
+	if (status != noErr) {
+		return status;
+	}
+
+	// Low latency: no B-frames, no lookahead, long GOPs with keyframes on
+	// request
+	VTSessionSetProperty(e->session, kVTCompressionPropertyKey_RealTime, kCFBooleanTrue);
+	VTSessionSetProperty(e->session, kVTCompressionPropertyKey_AllowFrameReordering, kCFBooleanFalse);
+	VTSessionSetProperty(e->session, kVTCompressionPropertyKey_ProfileLevel, hevc ? kVTProfileLevel_HEVC_Main_AutoLevel : kVTProfileLevel_H264_Main_AutoLevel);
+	int32_t interval = 600;
+	CFNumberRef number = CFNumberCreate(NULL, kCFNumberSInt32Type, &interval);
+	VTSessionSetProperty(e->session, kVTCompressionPropertyKey_MaxKeyFrameInterval, number);
+	CFRelease(number);
+
+	return VTCompressionSessionPrepareToEncodeFrames(e->session);
+}
+
+// vt_encoder_set_rate encodes at a quality (0-1) if bitrate is 0, else at
+// an average bitrate in bit/s that a second of frames may exceed peak times
+static void vt_encoder_set_rate(vt_encoder *e, float quality, int32_t bitrate, int32_t peak) {
+	CFNumberRef number = CFNumberCreate(NULL, kCFNumberFloat32Type, &quality);
+	VTSessionSetProperty(e->session, kVTCompressionPropertyKey_Quality, number);
+	CFRelease(number);
+
+	number = CFNumberCreate(NULL, kCFNumberSInt32Type, &bitrate);
+	VTSessionSetProperty(e->session, kVTCompressionPropertyKey_AverageBitRate, number);
+	CFRelease(number);
+
+	// Limits are pairs of bytes and seconds, none without a bitrate
+	int64_t bytes = (int64_t)bitrate * peak / 8;
+	double seconds = 1;
+	CFNumberRef limit[2] = {CFNumberCreate(NULL, kCFNumberSInt64Type, &bytes), CFNumberCreate(NULL, kCFNumberDoubleType, &seconds)};
+	CFArrayRef limits = CFArrayCreate(NULL, (const void **)limit, bitrate > 0 ? 2 : 0, &kCFTypeArrayCallBacks);
+	VTSessionSetProperty(e->session, kVTCompressionPropertyKey_DataRateLimits, limits);
+	CFRelease(limits);
+	CFRelease(limit[0]);
+	CFRelease(limit[1]);
+}
+
+// vt_encode_buffer encodes a pixel buffer, e.g. a captured frame still in
+// GPU memory
+static OSStatus vt_encode_buffer(vt_encoder *e, void *buffer, int64_t frame, int keyframe) {
+	e->out.len = 0;
+	e->status = noErr;
+
+	CFDictionaryRef options = NULL;
+	if (keyframe) {
+		const void *keys[] = {kVTEncodeFrameOptionKey_ForceKeyFrame};
+		const void *values[] = {kCFBooleanTrue};
+		options = CFDictionaryCreate(NULL, keys, values, 1, &kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	}
+	OSStatus status = VTCompressionSessionEncodeFrame(e->session, (CVPixelBufferRef)buffer, CMTimeMake(frame, 30), kCMTimeInvalid, options, NULL, NULL);
+	if (options != NULL) {
+		CFRelease(options);
+	}
+	if (status != noErr) {
+		return status;
+	}
+
+	// Wait for the callback so the frame is complete when we return
+	status = VTCompressionSessionCompleteFrames(e->session, kCMTimeInvalid);
+	if (status != noErr) {
+		return status;
+	}
+	return e->status;
+}
+
+static OSStatus vt_encode(vt_encoder *e, const uint8_t *bgra, int32_t width, int32_t height, int64_t frame, int keyframe) {
+	CVPixelBufferRef pixels = NULL;
+	OSStatus status = CVPixelBufferCreate(NULL, width, height, kCVPixelFormatType_32BGRA, NULL, &pixels);
+	if (status != kCVReturnSuccess) {
+		return status;
+	}
+	CVPixelBufferLockBaseAddress(pixels, 0);
+	uint8_t *dst = CVPixelBufferGetBaseAddress(pixels);
+	size_t stride = CVPixelBufferGetBytesPerRow(pixels);
+	for (int32_t y = 0; y < height; y++) {
+		memcpy(dst + y*stride, bgra + (size_t)y*width*4, (size_t)width*4);
+	}
+	CVPixelBufferUnlockBaseAddress(pixels, 0);
+
+	status = vt_encode_buffer(e, pixels, frame, keyframe);
+	CVPixelBufferRelease(pixels);
+	return status;
+}
+
+static void vt_encoder_free(vt_encoder *e) {
+	if (e->session != NULL) {
+		VTCompressionSessionInvalidate(e->session);
+		CFRelease(e->session);
+	}
+	free(e->out.data);
+	free(e);
+}
+
+// vt_decoder is an H.264 or HEVC decompression session and the last
+// picture it produced as BGRA
+typedef struct {
+	VTDecompressionSessionRef session;
+	CMVideoFormatDescriptionRef format;
+	vt_buffer pixels;
+	size_t width;
+	size_t height;
+	int have_picture;
+	OSStatus status;
+} vt_decoder;
+
+static void vt_decoded(void *ref, void *frameRef, OSStatus status, VTDecodeInfoFlags flags, CVImageBufferRef image, CMTime pts, CMTime duration) {
+	vt_decoder *d = ref;
+	d->status = status;
+	if (status != noErr || image == NULL) {
+		return;
+	}
+
+	CVPixelBufferLockBaseAddress(image, kCVPixelBufferLock_ReadOnly);
+	size_t width = CVPixelBufferGetWidth(image);
+	size_t height = CVPixelBufferGetHeight(image);
+	size_t stride = CVPixelBufferGetBytesPerRow(image);
+	uint8_t *src = CVPixelBufferGetBaseAddress(image);
+	d->pixels.len = 0;
+	for (size_t y = 0; y < height; y++) {
+		vt_append(&d->pixels, src + y*stride, width*4);
+	}
+	CVPixelBufferUnlockBaseAddress(image, kCVPixelBufferLock_ReadOnly);
+
+	d->width = width;
+	d->height = height;
+	d->have_picture = 1;
+}
+
+static void vt_decoder_release_session(vt_decoder *d) {
+	if (d->session != NULL) {
+		VTDecompressionSessionInvalidate(d->session);
+		CFRelease(d->session);
+		d->session = NULL;
+	}
+	if (d->format != NULL) {
+		CFRelease(d->format);
+		d->format = NULL;
+	}
+}
+
+// vt_decoder_configure (re)creates the session for new parameter sets. The
+// video parameter set is NULL for H.264.
+static OSStatus vt_decoder_configure(vt_decoder *d, const uint8_t *vps, size_t vps_len, const uint8_t *sps, size_t sps_len, const uint8_t *pps, size_t pps_len) {
+	CMVideoFormatDescriptionRef format = NULL;
+	OSStatus status;
+	if (vps != NULL) {
+		const uint8_t *sets[3] = {vps, sps, pps};
+		size_t sizes[3] = {vps_len, sps_len, pps_len};
+		status = CMVideoFormatDescriptionCreateFromHEVCParameterSets(NULL, 3, sets, sizes, 4, NULL, &format);
+	} else {
+		const uint8_t *sets[2] = {sps, pps};
+		size_t sizes[2] = {sps_len, pps_len};
+		status = CMVideoFormatDescriptionCreateFromH264ParameterSets(NULL, 2, sets, sizes, 4, &format);
+	}
+	if (status != noErr) {
+		return status;
+	}
+	if (d->session != NULL && VTDecompressionSessionCanAcceptFormatDescription(d->session, format)) {
+		CFRelease(d->format);
+		d->format = format;
+		return noErr;
+	}
+	vt_decoder_release_session(d);
+	d->format = format;
+
+	int32_t pixelFormat = kCVPixelFormatType_32BGRA;
+	CFNumberRef number = CFNumberCreate(NULL, kCFNumberSInt32Type, &pixelFormat);
+	const void *keys[] = {kCVPixelBufferPixelFormatTypeKey};
+	const void *values[] = {number};
+	CFDictionaryRef attributes = CFDictionaryCreate(NULL, keys, values, 1, &kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFRelease(number);
+
+	VTDecompressionOutputCallbackRecord callback = {vt_decoded, d};
+	status = VTDecompressionSessionCreate(NULL, format, NULL, attributes, &callback, &d->session);
+	CFRelease(attributes);
+	return status;
+}
+
+static OSStatus vt_decode(vt_decoder *d, const uint8_t *avcc, size_t len) {
+	d->have_picture = 0;
+	d->status = noErr;
+
+	CMBlockBufferRef block = NULL;
+	OSStatus status = CMBlockBufferCreateWithMemoryBlock(NULL, NULL, len, NULL, NULL, 0, len, 0, &block);
+	if (status != kCMBlockBufferNoErr) {
+		return status;
+	}
+	status = CMBlockBufferReplaceDataBytes(avcc, block, 0, len);
+	if (status != kCMBlockBufferNoErr) {
+		CFRelease(block);
+		return status;
+	}
+
+	CMSampleBufferRef sample = NULL;
+	size_t sizes[1] = {len};
+	status = CMSampleBufferCreateReady(NULL, block, d->format, 1, 0, NULL, 1, sizes, &sample);
+	CFRelease(block);
+	if (status != noErr) {
+		return status;
+	}
+
+	// Without flags the frame is decoded synchronously
+	status = VTDecompressionSessionDecodeFrame(d->session, sample, 0, NULL, NULL);
+	CFRelease(sample);
+	if (status != noErr) {
+		return status;
+	}
+	return d->status;
+}
+
+static void vt_decoder_free(vt_decoder *d) {
+	vt_decoder_release_session(d);
+	free(d->pixels.data);
+	free(d);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+func init() {
+	for _, id := range []ID{H264, HEVC} {
+		RegisterEncoder(10, "videotoolbox", id, func() bool { return vtEncodeSupported(id) }, func(width, height int) (Encoder, error) {
+			return newVTEncoder(id, width, height)
+		})
+		RegisterDecoder(10, "videotoolbox", id, func() bool { return vtDecodeSupported(id) }, func() (Decoder, error) {
+			return newVTDecoder(id), nil
+		})
+	}
+}
+
+// vtEncodeSupported reports whether a VideoToolbox session for a codec can
+// be created, which fails e.g. in virtual machines without a hardware
+// encoder and for HEVC on Macs without HEVC hardware
+func vtEncodeSupported(id ID) bool {
+	e, err := newVTEncoder(id, probeSize, probeSize)
+	if err != nil {
+		return false
+	}
+	e.Close()
+	return true
+}
+
+// vtDecodeSupported reports whether a video codec can be decoded.
+// VideoToolbox falls back to software decoding of H.264, HEVC is only
+// offered with hardware support as decoding it in software is too slow.
+func vtDecodeSupported(id ID) bool {
+	switch id {
+	case H264:
+		return true
+	case HEVC:
+		return C.VTIsHardwareDecodeSupported(C.kCMVideoCodecType_HEVC) != 0
+	}
+	return false
+}
+
+// vtEncoder encodes H.264 or HEVC with VideoToolbox
+type vtEncoder struct {
+	session       *C.vt_encoder
+	codec         ID
+	width, height int
+	frame         int64
+	quality       int
+	rate          RateControl
+	rc            rateSettings // Rate control the session runs with, see rateSettings
+	rcSet         bool
+	keyframe      bool // Force the next frame to be a keyframe
+}
+
+func newVTEncoder(id ID, width, height int) (Encoder, error) {
+	hevc := C.int(0)
+	if id == HEVC {
+		hevc = 1
+	}
+	session := (*C.vt_encoder)(C.calloc(1, C.sizeof_vt_encoder))
+	if status := C.vt_encoder_create(session, hevc, C.int32_t(width), C.int32_t(height)); status != 0 {
+		C.vt_encoder_free(session)
+		return nil, fmt.Errorf("failed to create VideoToolbox %s encoder: OSStatus %d", id, int(status))
+	}
+	return &vtEncoder{
+		session:  session,
+		codec:    id,
+		width:    width,
+		height:   height,
+		quality:  defaultJPEGQuality,
+		keyframe: true,
+	}, nil
+}
+
+func (e *vtEncoder) Codec() ID { return e.codec }
+
+func (e *vtEncoder) Encode(img image.Image) ([]byte, error) {
+	pix := toBGRA(img)
+	return e.encode(img.Bounds(), func(keyframe C.int) C.OSStatus {
+		return C.vt_encode(e.session, (*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int32_t(e.width), C.int32_t(e.height), C.int64_t(e.frame), keyframe)
+	})
+}
+
+// EncodeSurface encodes a captured frame in GPU memory, which the hardware
+// encoder reads without a copy
+func (e *vtEncoder) EncodeSurface(s Surface) ([]byte, error) {
+	return e.encode(s.Bounds(), func(keyframe C.int) C.OSStatus {
+		return C.vt_encode_buffer(e.session, s.Native(), C.int64_t(e.frame), keyframe)
+	})
+}
+
+// encode encodes a frame of the given bounds with submit
+func (e *vtEncoder) encode(bounds image.Rectangle, submit func(keyframe C.int) C.OSStatus) ([]byte, error) {
+	if bounds.Dx() != e.width || bounds.Dy() != e.height {
+		return nil, fmt.Errorf("frame is %dx%d, the encoder was created for %dx%d", bounds.Dx(), bounds.Dy(), e.width, e.height)
+	}
+	if rc := e.rateSettings(); !e.rcSet || rc != e.rc {
+		peak := C.int32_t(1)
+		if rc.mode == RateVBR {
+			peak = vbrPeak
+		}
+		C.vt_encoder_set_rate(e.session, C.float(float32(e.quality)/100), C.int32_t(rc.bitrate), peak)
+		e.rc, e.rcSet = rc, true
+	}
+
+	keyframe := C.int(0)
+	if e.keyframe {
+		keyframe = 1
+	}
+	status := submit(keyframe)
+	e.frame++
+	if status != 0 {
+		return nil, fmt.Errorf("VideoToolbox encoding failed: OSStatus %d", int(status))
+	}
+	e.keyframe = false
+
+	return C.GoBytes(unsafe.Pointer(e.session.out.data), C.int(e.session.out.len)), nil
+}
+
+// rateSettings returns the rate control for the next frame. VideoToolbox
+// encodes at its quality setting by default, its constant quality mode,
+// whose quality is kept in qp.
+func (e *vtEncoder) rateSettings() rateSettings {
+	if e.rate.Mode == RateDefault || e.rate.Mode == RateCQ {
+		return rateSettings{mode: RateCQ, qp: e.quality}
+	}
+	return e.rate.settings(e.quality, e.width, e.height, 0)
+}
+
+func (e *vtEncoder) SetQuality(quality int) { e.quality = quality }
+
+func (e *vtEncoder) SetRateControl(rc RateControl) { e.rate = rc }
+
+func (e *vtEncoder) RequestKeyframe() { e.keyframe = true }
+
+func (e *vtEncoder) Close() error {
+	if e.session != nil {
+		C.vt_encoder_free(e.session)
+		e.session = nil
+	}
+	return nil
+}
+
+// vtDecoder decodes H.264 or HEVC with VideoToolbox
+type vtDecoder struct {
+	session       *C.vt_decoder
+	codec         ID
+	vps, sps, pps []byte // Parameter sets the session was configured with
+	avcc          []byte
+}
+
+func newVTDecoder(id ID) *vtDecoder {
+	return &vtDecoder{
+		session: (*C.vt_decoder)(C.calloc(1, C.sizeof_vt_decoder)),
+		codec:   id,
+	}
+}
+
+func (d *vtDecoder) Codec() ID { return d.codec }
+
+// parameterSets separates the parameter sets of an access unit from the
+// NAL units to decode
+func (d *vtDecoder) parameterSets(data []byte) (vps, sps, pps []byte, nals [][]byte) {
+	for _, nal := range splitAnnexB(data) {
+		if d.codec == HEVC {
+			switch hevcNALType(nal) {
+			case hevcNALVPS:
+				vps = nal
+			case hevcNALSPS:
+				sps = nal
+			case hevcNALPPS:
+				pps = nal
+			default:
+				nals = append(nals, nal)
+			}
+			continue
+		}
+		switch nalType(nal) {
+		case nalSPS:
+			sps = nal
+		case nalPPS:
+			pps = nal
+		default:
+			nals = append(nals, nal)
+		}
+	}
+	return vps, sps, pps, nals
+}
+
+func (d *vtDecoder) Decode(data []byte) (image.Image, error) {
+	vps, sps, pps, nals := d.parameterSets(data)
+
+	// Keyframes carry the parameter sets, the session is created from them
+	complete := sps != nil && pps != nil && (d.codec != HEVC || vps != nil)
+	if complete && (!bytes.Equal(vps, d.vps) || !bytes.Equal(sps, d.sps) || !bytes.Equal(pps, d.pps)) {
+		var vpsPtr *C.uint8_t
+		if vps != nil {
+			vpsPtr = (*C.uint8_t)(unsafe.Pointer(&vps[0]))
+		}
+		status := C.vt_decoder_configure(d.session,
+			vpsPtr, C.size_t(len(vps)),
+			(*C.uint8_t)(unsafe.Pointer(&sps[0])), C.size_t(len(sps)),
+			(*C.uint8_t)(unsafe.Pointer(&pps[0])), C.size_t(len(pps)))
+		if status != 0 {
+			d.vps, d.sps, d.pps = nil, nil, nil
+			return nil, fmt.Errorf("invalid %s parameter sets: OSStatus %d", d.codec, int(status))
+		}
+		d.vps, d.sps, d.pps = bytes.Clone(vps), bytes.Clone(sps), bytes.Clone(pps)
+	}
+
+	// Frames before the first keyframe can't be decoded
+	if d.sps == nil || len(nals) == 0 {
+		return nil, nil
+	}
+
+	d.avcc = appendAVCC(d.avcc[:0], nals...)
+	status := C.vt_decode(d.session, (*C.uint8_t)(unsafe.Pointer(&d.avcc[0])), C.size_t(len(d.avcc)))
+	if status != 0 {
+		return nil, fmt.Errorf("VideoToolbox decoding failed: OSStatus %d", int(status))
+	}
+	if d.session.have_picture == 0 {
+		return nil, nil
+	}
+
+	width, height := int(d.session.width), int(d.session.height)
+	pix := unsafe.Slice((*byte)(unsafe.Pointer(d.session.pixels.data)), width*height*4)
+	return fromBGRA(pix, width, height), nil
+}
+
+func (d *vtDecoder) Close() error {
+	if d.session != nil {
+		C.vt_decoder_free(d.session)
+		d.session = nil
+	}
+	return nil
+}
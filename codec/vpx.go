@@ -0,0 +1,336 @@
+//go:build cgo && vpx
+
+package codec
+
+/*
+#cgo pkg-config: vpx
+#include <stdlib.h>
+#include <string.h>
+#include <vpx/vpx_encoder.h>
+#include <vpx/vpx_decoder.h>
+#include <vpx/vp8cx.h>
+#include <vpx/vp8dx.h>
+
+// vx_encoder is a libvpx encoder in its real-time mode
+typedef struct {
+	vpx_codec_ctx_t context;
+	vpx_codec_enc_cfg_t config;
+	unsigned int overshoot; // libvpx's default, for CBR
+	int open;
+	uint8_t *out;
+	size_t out_len;
+} vx_encoder;
+
+static vpx_codec_iface_t *vx_encoder_iface(int vp9) {
+	return vp9 ? vpx_codec_vp9_cx() : vpx_codec_vp8_cx();
+}
+
+static vpx_codec_iface_t *vx_decoder_iface(int vp9) {
+	return vp9 ? vpx_codec_vp9_dx() : vpx_codec_vp8_dx();
+}
+
+// Rate control modes, numbered like codec.RateMode
+#define VX_RATE_CBR 1
+#define VX_RATE_VBR 2
+#define VX_RATE_CQ 3
+
+// vx_rate_config sets the rate control of the encoder configuration
+static void vx_rate_config(vx_encoder *e, int mode, unsigned int kbps, unsigned int qp) {
+	e->config.rc_target_bitrate = kbps;
+	e->config.rc_min_quantizer = 4;
+	e->config.rc_max_quantizer = 56;
+	e->config.rc_overshoot_pct = e->overshoot;
+	switch (mode) {
+	case VX_RATE_CQ:
+		e->config.rc_end_usage = VPX_Q;
+		e->config.rc_min_quantizer = qp;
+		e->config.rc_max_quantizer = qp;
+		break;
+	case VX_RATE_VBR:
+		e->config.rc_end_usage = VPX_VBR;
+		e->config.rc_overshoot_pct = 100;
+		break;
+	default:
+		e->config.rc_end_usage = VPX_CBR;
+	}
+}
+
+static vpx_codec_err_t vx_encoder_create(vx_encoder *e, int vp9, unsigned int width, unsigned int height, int fps, int mode, unsigned int kbps, unsigned int qp) {
+	vpx_codec_err_t err = vpx_codec_enc_config_default(vx_encoder_iface(vp9), &e->config, 0);
+	if (err != VPX_CODEC_OK) {
+		return err;
+	}
+	e->overshoot = e->config.rc_overshoot_pct;
+
+	// No lookahead and keyframes only on request
+	e->config.g_w = width;
+	e->config.g_h = height;
+	e->config.g_timebase.num = 1;
+	e->config.g_timebase.den = fps;
+	e->config.g_lag_in_frames = 0;
+	e->config.g_error_resilient = VPX_ERROR_RESILIENT_DEFAULT;
+	vx_rate_config(e, mode, kbps, qp);
+	e->config.kf_mode = VPX_KF_DISABLED;
+
+	if ((err = vpx_codec_enc_init(&e->context, vx_encoder_iface(vp9), &e->config, 0)) != VPX_CODEC_OK) {
+		return err;
+	}
+	e->open = 1;
+
+	// The fastest real-time speeds, VP9 encodes tiles on several threads
+	if (vp9) {
+		vpx_codec_control(&e->context, VP8E_SET_CPUUSED, 8);
+		vpx_codec_control(&e->context, VP9E_SET_ROW_MT, 1);
+		vpx_codec_control(&e->context, VP9E_SET_TILE_COLUMNS, 2);
+		vpx_codec_control(&e->context, VP9E_SET_AQ_MODE, 3);
+	} else {
+		vpx_codec_control(&e->context, VP8E_SET_CPUUSED, 12);
+	}
+	if (mode == VX_RATE_CQ) {
+		vpx_codec_control(&e->context, VP8E_SET_CQ_LEVEL, qp);
+	}
+	return VPX_CODEC_OK;
+}
+
+static void vx_encoder_free(vx_encoder *e) {
+	if (e->open) {
+		vpx_codec_destroy(&e->context);
+	}
+	free(e->out);
+	free(e);
+}
+
+// vx_set_rate changes the rate control in place
+static vpx_codec_err_t vx_set_rate(vx_encoder *e, int mode, unsigned int kbps, unsigned int qp) {
+	vx_rate_config(e, mode, kbps, qp);
+	vpx_codec_err_t err = vpx_codec_enc_config_set(&e->context, &e->config);
+	if (err == VPX_CODEC_OK && mode == VX_RATE_CQ) {
+		vpx_codec_control(&e->context, VP8E_SET_CQ_LEVEL, qp);
+	}
+	return err;
+}
+
+// vx_encode encodes a frame of 4:2:0 planes into e->out
+static vpx_codec_err_t vx_encode(vx_encoder *e, uint8_t *y, uint8_t *cb, uint8_t *cr, unsigned int width, unsigned int height, int64_t frame, int keyframe) {
+	vpx_image_t image;
+	vpx_img_wrap(&image, VPX_IMG_FMT_I420, width, height, 1, y);
+	image.planes[VPX_PLANE_U] = cb;
+	image.planes[VPX_PLANE_V] = cr;
+	image.stride[VPX_PLANE_Y] = width;
+	image.stride[VPX_PLANE_U] = width / 2;
+	image.stride[VPX_PLANE_V] = width / 2;
+
+	vpx_codec_err_t err = vpx_codec_encode(&e->context, &image, frame, 1, keyframe ? VPX_EFLAG_FORCE_KF : 0, VPX_DL_REALTIME);
+	if (err != VPX_CODEC_OK) {
+		return err;
+	}
+
+	e->out_len = 0;
+	vpx_codec_iter_t iter = NULL;
+	const vpx_codec_cx_pkt_t *packet;
+	while ((packet = vpx_codec_get_cx_data(&e->context, &iter)) != NULL) {
+		if (packet->kind != VPX_CODEC_CX_FRAME_PKT) {
+			continue;
+		}
+		e->out = realloc(e->out, e->out_len + packet->data.frame.sz);
+		memcpy(e->out + e->out_len, packet->data.frame.buf, packet->data.frame.sz);
+		e->out_len += packet->data.frame.sz;
+	}
+	return VPX_CODEC_OK;
+}
+
+static vpx_codec_err_t vx_decoder_create(vpx_codec_ctx_t *context, int vp9) {
+	return vpx_codec_dec_init(context, vx_decoder_iface(vp9), NULL, 0);
+}
+
+// vx_decode decodes a frame and returns its picture, NULL if there was
+// none
+static vpx_image_t *vx_decode(vpx_codec_ctx_t *context, const uint8_t *frame, unsigned int len, vpx_codec_err_t *err) {
+	*err = vpx_codec_decode(context, frame, len, NULL, 0);
+	if (*err != VPX_CODEC_OK) {
+		return NULL;
+	}
+	vpx_codec_iter_t iter = NULL;
+	vpx_image_t *image = NULL, *next;
+	while ((next = vpx_codec_get_frame(context, &iter)) != NULL) {
+		image = next;
+	}
+	return image;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+func init() {
+	// Software encoding comes after every hardware encoder
+	for _, id := range []ID{VP8, VP9} {
+		RegisterEncoder(5, "libvpx", id, always, func(width, height int) (Encoder, error) {
+			return newVPXEncoder(id, width, height)
+		})
+		RegisterDecoder(5, "libvpx", id, always, func() (Decoder, error) {
+			return newVPXDecoder(id)
+		})
+	}
+}
+
+// vpxError converts a libvpx error code
+func vpxError(err C.vpx_codec_err_t) error {
+	return errors.New(C.GoString(C.vpx_codec_err_to_string(err)))
+}
+
+// vpxIsVP9 returns the flag the C helpers take to choose between VP8 and
+// VP9
+func vpxIsVP9(id ID) C.int {
+	if id == VP9 {
+		return 1
+	}
+	return 0
+}
+
+// vpxEncoder encodes VP8 or VP9 in software with libvpx. VP9 needs about
+// as much bandwidth as HEVC at the same quality.
+type vpxEncoder struct {
+	session       *C.vx_encoder
+	codec         ID
+	width, height int
+	frame         int64
+	quality       int
+	rate          RateControl
+	rc            rateSettings // Rate control the encoder runs with
+	keyframe      bool         // Force the next frame to be a keyframe
+}
+
+// vpxMaxQP is the largest quantizer of libvpx's 0-63 scale
+const vpxMaxQP = 63
+
+func newVPXEncoder(id ID, width, height int) (*vpxEncoder, error) {
+	// 4:2:0 needs even dimensions, see toYUV420
+	width, height = width&^1, height&^1
+	e := &vpxEncoder{
+		codec:    id,
+		width:    width,
+		height:   height,
+		quality:  defaultJPEGQuality,
+		keyframe: true,
+	}
+	e.rc = e.rate.settings(e.quality, width, height, vpxMaxQP)
+
+	e.session = (*C.vx_encoder)(C.calloc(1, C.sizeof_vx_encoder))
+	if err := C.vx_encoder_create(e.session, vpxIsVP9(id), C.uint(width), C.uint(height), hardwareFrameRate,
+		C.int(e.rc.mode), C.uint(e.rc.bitrate/1000), C.uint(e.rc.qp)); err != C.VPX_CODEC_OK {
+		e.Close()
+		return nil, fmt.Errorf("failed to create libvpx %s encoder: %w", id, vpxError(err))
+	}
+	return e, nil
+}
+
+func (e *vpxEncoder) Codec() ID { return e.codec }
+
+func (e *vpxEncoder) Encode(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	if bounds.Dx()&^1 != e.width || bounds.Dy()&^1 != e.height {
+		return nil, fmt.Errorf("frame is %dx%d, the encoder was created for %dx%d", bounds.Dx(), bounds.Dy(), e.width, e.height)
+	}
+	if rc := e.rate.settings(e.quality, e.width, e.height, vpxMaxQP); rc != e.rc {
+		if err := C.vx_set_rate(e.session, C.int(rc.mode), C.uint(rc.bitrate/1000), C.uint(rc.qp)); err != C.VPX_CODEC_OK {
+			return nil, fmt.Errorf("failed to change libvpx rate control: %w", vpxError(err))
+		}
+		e.rc = rc
+	}
+
+	frame := toYUV420(img)
+	keyframe := C.int(0)
+	if e.keyframe {
+		keyframe = 1
+	}
+	err := C.vx_encode(e.session,
+		(*C.uint8_t)(unsafe.Pointer(&frame.y[0])),
+		(*C.uint8_t)(unsafe.Pointer(&frame.cb[0])),
+		(*C.uint8_t)(unsafe.Pointer(&frame.cr[0])),
+		C.uint(e.width), C.uint(e.height), C.int64_t(e.frame), keyframe)
+	e.frame++
+	if err != C.VPX_CODEC_OK {
+		return nil, fmt.Errorf("libvpx encoding failed: %w", vpxError(err))
+	}
+	if e.session.out_len == 0 {
+		return nil, errors.New("libvpx encoder returned no frame")
+	}
+	e.keyframe = false
+
+	return C.GoBytes(unsafe.Pointer(e.session.out), C.int(e.session.out_len)), nil
+}
+
+func (e *vpxEncoder) SetQuality(quality int) { e.quality = quality }
+
+func (e *vpxEncoder) SetRateControl(rc RateControl) { e.rate = rc }
+
+func (e *vpxEncoder) RequestKeyframe() { e.keyframe = true }
+
+func (e *vpxEncoder) Close() error {
+	if e.session != nil {
+		C.vx_encoder_free(e.session)
+		e.session = nil
+	}
+	return nil
+}
+
+// vpxDecoder decodes VP8 or VP9 in software with libvpx
+type vpxDecoder struct {
+	context *C.vpx_codec_ctx_t
+	codec   ID
+}
+
+func newVPXDecoder(id ID) (*vpxDecoder, error) {
+	d := &vpxDecoder{
+		context: (*C.vpx_codec_ctx_t)(C.calloc(1, C.sizeof_vpx_codec_ctx_t)),
+		codec:   id,
+	}
+	if err := C.vx_decoder_create(d.context, vpxIsVP9(id)); err != C.VPX_CODEC_OK {
+		C.free(unsafe.Pointer(d.context))
+		return nil, fmt.Errorf("failed to create libvpx %s decoder: %w", id, vpxError(err))
+	}
+	return d, nil
+}
+
+func (d *vpxDecoder) Codec() ID { return d.codec }
+
+func (d *vpxDecoder) Decode(data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var err C.vpx_codec_err_t
+	picture := C.vx_decode(d.context, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.uint(len(data)), &err)
+	if err != C.VPX_CODEC_OK {
+		return nil, fmt.Errorf("libvpx decoding failed: %w", vpxError(err))
+	}
+
+	// Frames before the first keyframe produce no picture
+	if picture == nil {
+		return nil, nil
+	}
+	if picture.fmt != C.VPX_IMG_FMT_I420 {
+		return nil, errors.New("libvpx picture isn't 8 bit 4:2:0")
+	}
+
+	width, height := int(picture.d_w), int(picture.d_h)
+	yStride, cStride := int(picture.stride[0]), int(picture.stride[1])
+	y := unsafe.Slice((*byte)(picture.planes[0]), yStride*height)
+	cb := unsafe.Slice((*byte)(picture.planes[1]), cStride*((height+1)/2))
+	cr := unsafe.Slice((*byte)(picture.planes[2]), cStride*((height+1)/2))
+	return fromYUV420(y, cb, cr, yStride, cStride, width, height), nil
+}
+
+func (d *vpxDecoder) Close() error {
+	if d.context != nil {
+		C.vpx_codec_destroy(d.context)
+		C.free(unsafe.Pointer(d.context))
+		d.context = nil
+	}
+	return nil
+}
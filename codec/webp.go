@@ -0,0 +1,138 @@
+//go:build cgo && webp
+
+package codec
+
+/*
+#cgo pkg-config: libwebp
+#include <stdlib.h>
+#include <webp/encode.h>
+#include <webp/decode.h>
+
+// wp_encode encodes opaque RGBA pixels, lossless or at a quality (0-100),
+// with the fastest method. The caller frees *out with WebPFree. It returns
+// a WebPEncodingError, 0 on success.
+static int wp_encode(const uint8_t *rgba, int width, int height, float quality, int lossless, uint8_t **out, size_t *out_len) {
+	WebPConfig config;
+	if (!WebPConfigPreset(&config, WEBP_PRESET_DEFAULT, quality)) {
+		return VP8_ENC_ERROR_INVALID_CONFIGURATION;
+	}
+	if (lossless) {
+		WebPConfigLosslessPreset(&config, 0);
+	} else {
+		config.method = 0;
+	}
+	config.thread_level = 1;
+
+	WebPPicture picture;
+	if (!WebPPictureInit(&picture)) {
+		return VP8_ENC_ERROR_INVALID_CONFIGURATION;
+	}
+	picture.use_argb = lossless;
+	picture.width = width;
+	picture.height = height;
+	if (!WebPPictureImportRGBX(&picture, rgba, width * 4)) {
+		return VP8_ENC_ERROR_OUT_OF_MEMORY;
+	}
+
+	WebPMemoryWriter writer;
+	WebPMemoryWriterInit(&writer);
+	picture.writer = WebPMemoryWrite;
+	picture.custom_ptr = &writer;
+	int ok = WebPEncode(&config, &picture);
+	int err = picture.error_code;
+	WebPPictureFree(&picture);
+	if (!ok) {
+		WebPMemoryWriterClear(&writer);
+		return err != VP8_ENC_OK ? err : VP8_ENC_ERROR_BAD_WRITE;
+	}
+	*out = writer.mem;
+	*out_len = writer.size;
+	return VP8_ENC_OK;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+func init() {
+	for _, id := range []ID{WebP, WebPLossless} {
+		RegisterEncoder(5, "libwebp", id, always, func(width, height int) (Encoder, error) {
+			return newWebPEncoder(id), nil
+		})
+		RegisterDecoder(5, "libwebp", id, always, func() (Decoder, error) {
+			return webpDecoder{codec: id}, nil
+		})
+	}
+}
+
+// webpEncoder encodes every frame as a self-contained WebP image, lossy or
+// lossless
+type webpEncoder struct {
+	codec   ID
+	quality int
+}
+
+func newWebPEncoder(id ID) *webpEncoder {
+	return &webpEncoder{codec: id, quality: defaultJPEGQuality}
+}
+
+func (e *webpEncoder) Codec() ID { return e.codec }
+
+func (e *webpEncoder) Encode(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	pix := toRGBA(img)
+	if len(pix) == 0 {
+		return nil, errors.New("empty frame")
+	}
+	lossless := C.int(0)
+	if e.codec == WebPLossless {
+		lossless = 1
+	}
+
+	var out *C.uint8_t
+	var size C.size_t
+	if err := C.wp_encode((*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int(bounds.Dx()), C.int(bounds.Dy()), C.float(e.quality), lossless, &out, &size); err != C.VP8_ENC_OK {
+		return nil, fmt.Errorf("WebP encoding failed: error %d", int(err))
+	}
+	defer C.WebPFree(unsafe.Pointer(out))
+	return C.GoBytes(unsafe.Pointer(out), C.int(size)), nil
+}
+
+// SetQuality sets the quality of lossy frames, lossless ones ignore it
+func (e *webpEncoder) SetQuality(quality int) { e.quality = quality }
+
+// RequestKeyframe does nothing, every WebP frame is a keyframe
+func (e *webpEncoder) RequestKeyframe() {}
+
+func (e *webpEncoder) Close() error { return nil }
+
+// webpDecoder decodes WebP frames, lossy and lossless alike
+type webpDecoder struct {
+	codec ID
+}
+
+func (d webpDecoder) Codec() ID { return d.codec }
+
+func (d webpDecoder) Decode(data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty WebP frame")
+	}
+	in := (*C.uint8_t)(unsafe.Pointer(&data[0]))
+	var width, height C.int
+	if C.WebPGetInfo(in, C.size_t(len(data)), &width, &height) == 0 {
+		return nil, errors.New("invalid WebP frame")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	if C.WebPDecodeRGBAInto(in, C.size_t(len(data)), (*C.uint8_t)(unsafe.Pointer(&img.Pix[0])), C.size_t(len(img.Pix)), C.int(img.Stride)) == nil {
+		return nil, errors.New("WebP decoding failed")
+	}
+	return img, nil
+}
+
+func (webpDecoder) Close() error { return nil }
@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"image"
+	"runtime"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/codec/internal/yuvrow"
+)
+
+// yuv420 is a frame in planar 4:2:0 YCbCr: full resolution luma and half
+// resolution Cb and Cr planes, all tightly packed
+type yuv420 struct {
+	width, height int
+	y, cb, cr     []byte
+}
+
+// parallelYUVPixels is the frame size from which frames are converted on
+// several cores, a 4K frame at 60 fps doesn't fit the frame budget on one
+const parallelYUVPixels = 1920 * 1080
+
+// toYUV420 converts img to 4:2:0 for the video encoders. Odd widths and
+// heights are cropped by a pixel, as chroma is subsampled in pairs. Colors
+// use BT.601 limited range, which decoders assume without further
+// signaling. Rows are converted by package yuvrow, with SIMD where
+// available, in strips on several cores for large frames.
+func toYUV420(img image.Image) yuv420 {
+	pix, stride := rgbaPixels(img)
+	bounds := img.Bounds()
+	width, height := bounds.Dx()&^1, bounds.Dy()&^1
+	frame := yuv420{
+		width:  width,
+		height: height,
+		y:      make([]byte, width*height),
+		cb:     make([]byte, width*height/4),
+		cr:     make([]byte, width*height/4),
+	}
+
+	// Strips are whole pairs of rows, which share their chroma
+	strips := 1
+	if width*height >= parallelYUVPixels {
+		strips = min(runtime.GOMAXPROCS(0), height/2)
+	}
+	pairsPerStrip := (height/2 + strips - 1) / max(strips, 1)
+	var wg sync.WaitGroup
+	for top := 0; top < height; top += pairsPerStrip * 2 {
+		bottom := min(top+pairsPerStrip*2, height)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			frame.convertRows(pix, stride, top, bottom)
+		}()
+	}
+	wg.Wait()
+	return frame
+}
+
+// convertRows converts the rows from top to bottom, both even, of tightly
+// packed RGBA pixels with the given row stride
+func (f yuv420) convertRows(pix []byte, stride, top, bottom int) {
+	rowLen := f.width * 4
+	for y := top; y < bottom; y += 2 {
+		upper := pix[y*stride : y*stride+rowLen]
+		lower := pix[(y+1)*stride : (y+1)*stride+rowLen]
+		yuvrow.Luma(f.y[y*f.width:(y+1)*f.width], upper)
+		yuvrow.Luma(f.y[(y+1)*f.width:(y+2)*f.width], lower)
+		c := y / 2 * f.width / 2
+		yuvrow.Chroma(f.cb[c:c+f.width/2], f.cr[c:c+f.width/2], upper, lower)
+	}
+}
+
+// rgbaPixels returns the RGBA pixels of img, starting at its top left
+// corner, and their row stride. RGBA images aren't copied.
+func rgbaPixels(img image.Image) ([]byte, int) {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y):], rgba.Stride
+	}
+	return toRGBA(img), img.Bounds().Dx() * 4
+}
+
+// toNV12 converts img to NV12, the 4:2:0 layout most hardware encoders
+// take: the luma plane followed by a plane of interleaved Cb and Cr samples
+func toNV12(img image.Image) []byte {
+	frame := toYUV420(img)
+	out := make([]byte, 0, len(frame.y)*3/2)
+	out = append(out, frame.y...)
+	for i := range frame.cb {
+		out = append(out, frame.cb[i], frame.cr[i])
+	}
+	return out
+}
+
+// fromYUV420 converts 4:2:0 planes with the given row strides to an image,
+// the inverse of toYUV420
+func fromYUV420(y, cb, cr []byte, yStride, cStride, width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			c := int(y[row*yStride+col]) - 16
+			d := int(cb[row/2*cStride+col/2]) - 128
+			e := int(cr[row/2*cStride+col/2]) - 128
+			i := row*img.Stride + col*4
+			img.Pix[i] = clampByte((298*c + 409*e + 128) >> 8)
+			img.Pix[i+1] = clampByte((298*c - 100*d - 208*e + 128) >> 8)
+			img.Pix[i+2] = clampByte((298*c + 516*d + 128) >> 8)
+			img.Pix[i+3] = 255
+		}
+	}
+	return img
+}
+
+// clampByte limits v to the range of a byte
+func clampByte(v int) byte {
+	return byte(min(max(v, 0), 255))
+}
@@ -0,0 +1,99 @@
+//go:build cgo && zstd
+
+package codec
+
+/*
+#cgo pkg-config: libzstd
+#include <zstd.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// zstdLevel trades compression for speed. Screen content compresses well
+// at the fastest levels, higher ones don't keep up with the frame rate.
+const zstdLevel = 1
+
+func init() {
+	RegisterEncoder(5, "zstd", Lossless, always, func(width, height int) (Encoder, error) {
+		return newLosslessEncoder(newZstd()), nil
+	})
+	RegisterDecoder(5, "zstd", Lossless, always, func() (Decoder, error) {
+		return newLosslessDecoder(newZstd()), nil
+	})
+}
+
+// zstdCompressor compresses lossless frames with libzstd, reusing its
+// contexts between frames
+type zstdCompressor struct {
+	cctx *C.ZSTD_CCtx
+	dctx *C.ZSTD_DCtx
+}
+
+func newZstd() *zstdCompressor {
+	return &zstdCompressor{}
+}
+
+// zstdError converts a libzstd result to an error, nil if it isn't one
+func zstdError(result C.size_t) error {
+	if C.ZSTD_isError(result) == 0 {
+		return nil
+	}
+	return errors.New(C.GoString(C.ZSTD_getErrorName(result)))
+}
+
+func (z *zstdCompressor) Compress(dst, src []byte) ([]byte, error) {
+	if z.cctx == nil {
+		z.cctx = C.ZSTD_createCCtx()
+	}
+	bound := int(C.ZSTD_compressBound(C.size_t(len(src))))
+	start := len(dst)
+	if cap(dst)-start < bound {
+		grown := make([]byte, start, start+bound)
+		copy(grown, dst)
+		dst = grown
+	}
+	out := dst[start : start+bound]
+	var in unsafe.Pointer
+	if len(src) > 0 {
+		in = unsafe.Pointer(&src[0])
+	}
+	n := C.ZSTD_compressCCtx(z.cctx, unsafe.Pointer(&out[0]), C.size_t(bound), in, C.size_t(len(src)), zstdLevel)
+	if err := zstdError(n); err != nil {
+		return nil, fmt.Errorf("zstd compression failed: %w", err)
+	}
+	return dst[:start+int(n)], nil
+}
+
+func (z *zstdCompressor) Decompress(dst, src []byte) error {
+	if len(src) == 0 || len(dst) == 0 {
+		return errLosslessFrame
+	}
+	if z.dctx == nil {
+		z.dctx = C.ZSTD_createDCtx()
+	}
+	n := C.ZSTD_decompressDCtx(z.dctx, unsafe.Pointer(&dst[0]), C.size_t(len(dst)), unsafe.Pointer(&src[0]), C.size_t(len(src)))
+	if err := zstdError(n); err != nil {
+		return err
+	}
+	if int(n) != len(dst) {
+		return fmt.Errorf("decompressed %d bytes, want %d", int(n), len(dst))
+	}
+	return nil
+}
+
+func (z *zstdCompressor) Close() error {
+	if z.cctx != nil {
+		C.ZSTD_freeCCtx(z.cctx)
+		z.cctx = nil
+	}
+	if z.dctx != nil {
+		C.ZSTD_freeDCtx(z.dctx)
+		z.dctx = nil
+	}
+	return nil
+}
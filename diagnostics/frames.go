@@ -0,0 +1,126 @@
+// Package diagnostics writes frames to disk for debugging capture,
+// encoding and decoding. Dumps are off unless enabled with -debug-frames,
+// a nil *FrameDump writes nothing.
+package diagnostics
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// DefaultLimit is the default size of the frames kept in a dump directory
+const DefaultLimit = 256 << 20
+
+// FrameDump writes debug frames to a directory. Once the files in it take
+// up more than the limit, the oldest are removed.
+type FrameDump struct {
+	dir   string
+	limit int64
+
+	mutex sync.Mutex
+	files []dumpedFile // Oldest first
+	total int64        // Size of files
+}
+
+// dumpedFile is a file in the dump directory
+type dumpedFile struct {
+	path string
+	size int64
+}
+
+// OpenFrameDump creates dir if needed and returns a dump writing to it,
+// keeping at most limit bytes of files. Files left there by earlier runs
+// count toward the limit and are removed first.
+func OpenFrameDump(dir string, limit int64) (*FrameDump, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			infos = append(infos, info)
+		}
+	}
+	slices.SortFunc(infos, func(a, b os.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+
+	d := &FrameDump{dir: dir, limit: limit}
+	for _, info := range infos {
+		d.files = append(d.files, dumpedFile{filepath.Join(dir, info.Name()), info.Size()})
+		d.total += info.Size()
+	}
+	d.trim()
+	return d, nil
+}
+
+// Enabled reports whether frames are dumped. Callers check it before
+// naming or sampling frames, so nothing is done for them otherwise.
+func (d *FrameDump) Enabled() bool {
+	return d != nil
+}
+
+// WriteImage writes a frame as a PNG file named name. Failures are logged,
+// debugging goes on without the file.
+func (d *FrameDump) WriteImage(name string, img image.Image) {
+	if d == nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Printf("Failed to encode debug frame %s: %v", name, err)
+		return
+	}
+	d.WriteBytes(name, buf.Bytes())
+}
+
+// WriteBytes writes an encoded frame, such as a JPEG image, as it is to a
+// file named name
+func (d *FrameDump) WriteBytes(name string, data []byte) {
+	if d == nil {
+		return
+	}
+	path := filepath.Join(d.dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Printf("Failed to write debug frame: %v", err)
+		return
+	}
+	log.Printf("Saved debug frame to %s", path)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	// A name used before, in this run or an earlier one, was overwritten and
+	// is now the newest file
+	if i := slices.IndexFunc(d.files, func(f dumpedFile) bool { return f.path == path }); i >= 0 {
+		d.total -= d.files[i].size
+		d.files = slices.Delete(d.files, i, i+1)
+	}
+	d.files = append(d.files, dumpedFile{path, int64(len(data))})
+	d.total += int64(len(data))
+	d.trim()
+}
+
+// trim removes the oldest files until the rest fit the limit, the newest
+// is kept even if it alone doesn't. Called with mutex held or before the
+// dump is shared.
+func (d *FrameDump) trim() {
+	for d.total > d.limit && len(d.files) > 1 {
+		oldest := d.files[0]
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove old debug frame: %v", err)
+		}
+		d.files = d.files[1:]
+		d.total -= oldest.size
+	}
+}
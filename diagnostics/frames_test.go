@@ -0,0 +1,72 @@
+package diagnostics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFrameDumpRemovesOldest(t *testing.T) {
+	dir := t.TempDir()
+
+	// A file left by an earlier run is the oldest
+	old := filepath.Join(dir, "old.jpg")
+	if err := os.WriteFile(old, make([]byte, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	d, err := OpenFrameDump(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteBytes("a.jpg", make([]byte, 40))
+	d.WriteBytes("b.jpg", make([]byte, 40))
+
+	for name, want := range map[string]bool{"old.jpg": false, "a.jpg": true, "b.jpg": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+
+	// A frame larger than the limit replaces the rest
+	d.WriteBytes("c.jpg", make([]byte, 200))
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "c.jpg" {
+		t.Errorf("dump holds %v, want only c.jpg", entries)
+	}
+}
+
+func TestFrameDumpRewritesName(t *testing.T) {
+	dir := t.TempDir()
+
+	// Names repeat across runs
+	if err := os.WriteFile(filepath.Join(dir, "a.jpg"), make([]byte, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenFrameDump(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteBytes("a.jpg", make([]byte, 40))
+	d.WriteBytes("b.jpg", make([]byte, 40))
+
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s under the limit was removed: %v", name, err)
+		}
+	}
+	if d.total != 80 {
+		t.Errorf("dump counts %d bytes, want 80", d.total)
+	}
+}
+
+func TestNilFrameDump(t *testing.T) {
+	var d *FrameDump
+	if d.Enabled() {
+		t.Error("nil dump is enabled")
+	}
+	d.WriteBytes("a.jpg", []byte{1})
+}
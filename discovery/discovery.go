@@ -0,0 +1,151 @@
+// Package discovery finds UltraRDP servers on the local network. Servers
+// advertise themselves with mDNS/DNS-SD as _ultrardp._tcp, with their
+// monitor count and protocol version in TXT records.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/mdns"
+)
+
+// Service is the DNS-SD service type servers are advertised under
+const Service = "_ultrardp._tcp"
+
+// Server is a server found on the network
+type Server struct {
+	Name     string // Instance name, the server's host name by default
+	Address  string // host:port to connect to
+	Monitors int    // Number of monitors, 0 if not advertised
+	Version  int    // Protocol version, 0 if not advertised
+	TLS      bool   // Whether the server requires TLS
+}
+
+// Advertisement is a running mDNS responder for one server
+type Advertisement struct {
+	server *mdns.Server
+}
+
+// Advertise answers mDNS queries for a server listening on port until the
+// advertisement is closed
+func Advertise(instance string, port, monitors, version int, tls bool) (*Advertisement, error) {
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		instance = hostname
+	}
+
+	txt := []string{
+		"version=" + strconv.Itoa(version),
+		"monitors=" + strconv.Itoa(monitors),
+	}
+	if tls {
+		txt = append(txt, "tls=1")
+	}
+
+	service, err := mdns.NewMDNSService(instance, Service, "", "", port, localIPs(), txt)
+	if err != nil {
+		return nil, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start mDNS responder: %w", err)
+	}
+	return &Advertisement{server: server}, nil
+}
+
+// Close stops answering queries
+func (a *Advertisement) Close() error {
+	return a.server.Shutdown()
+}
+
+// Discover queries the network for servers, collecting answers for timeout
+func Discover(timeout time.Duration) ([]Server, error) {
+	entries := make(chan *mdns.ServiceEntry, 16)
+	found := make(map[string]Server)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			if server, ok := parseEntry(entry); ok {
+				found[server.Name+" "+server.Address] = server
+			}
+		}
+	}()
+
+	params := mdns.DefaultParams(Service)
+	params.Timeout = timeout
+	params.Entries = entries
+
+	err := mdns.Query(params)
+	close(entries)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]Server, 0, len(found))
+	for _, server := range found {
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Name < servers[j].Name
+	})
+	return servers, nil
+}
+
+// parseEntry converts an mDNS answer to a server
+func parseEntry(entry *mdns.ServiceEntry) (Server, bool) {
+	ip := entry.AddrV4
+	if ip == nil {
+		ip = entry.AddrV6
+	}
+	if ip == nil || entry.Port == 0 {
+		return Server{}, false
+	}
+
+	// Name is the full instance name, e.g. "host._ultrardp._tcp.local."
+	name := strings.TrimSuffix(entry.Name, "."+Service+".local.")
+	server := Server{
+		Name:    strings.ReplaceAll(name, `\ `, " "),
+		Address: net.JoinHostPort(ip.String(), strconv.Itoa(entry.Port)),
+	}
+	for _, field := range entry.InfoFields {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "version":
+			server.Version, _ = strconv.Atoi(value)
+		case "monitors":
+			server.Monitors, _ = strconv.Atoi(value)
+		case "tls":
+			server.TLS = value == "1"
+		}
+	}
+	return server, true
+}
+
+// localIPs returns the addresses other hosts can reach this one on
+func localIPs() []net.IP {
+	var ips []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		ips = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	return ips
+}
@@ -0,0 +1,28 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hashicorp/mdns"
+)
+
+func TestParseEntry(t *testing.T) {
+	server, ok := parseEntry(&mdns.ServiceEntry{
+		Name:       `office\ pc._ultrardp._tcp.local.`,
+		AddrV4:     net.IPv4(192, 168, 1, 20),
+		Port:       8000,
+		InfoFields: []string{"version=1", "monitors=3", "tls=1"},
+	})
+	if !ok {
+		t.Fatal("entry rejected")
+	}
+	want := Server{Name: "office pc", Address: "192.168.1.20:8000", Monitors: 3, Version: 1, TLS: true}
+	if server != want {
+		t.Fatalf("got %+v, want %+v", server, want)
+	}
+
+	if _, ok := parseEntry(&mdns.ServiceEntry{Name: "x", Port: 8000}); ok {
+		t.Fatal("entry without address accepted")
+	}
+}
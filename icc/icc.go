@@ -0,0 +1,284 @@
+// Package icc reads the color profiles of displays and converts pictures
+// shown on one display to the sRGB of another. Only matrix/TRC profiles are
+// supported, the kind operating systems generate for displays: three
+// primaries and a tone curve per channel.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+const (
+	headerSize   = 128
+	tagEntrySize = 12
+
+	// transformTableSize is the number of entries of the table that
+	// encodes linear light to sRGB
+	transformTableSize = 4096
+
+	// srgbTolerance is how far a profile may be from sRGB, in 8-bit steps
+	// and matrix coefficients, to be shown without conversion
+	srgbTolerance = 0.01
+)
+
+var (
+	// ErrInvalid is returned for data that isn't an ICC profile
+	ErrInvalid = errors.New("invalid ICC profile")
+
+	// ErrUnsupported is returned for profiles other than RGB matrix/TRC
+	// ones, e.g. printer or LUT based profiles
+	ErrUnsupported = errors.New("unsupported ICC profile")
+)
+
+// srgbFromXYZ converts D50 XYZ to linear sRGB, with the Bradford
+// adaptation from the D50 of ICC profiles to sRGB's D65 white
+var srgbFromXYZ = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// Profile describes a display: the D50 XYZ of its red, green and blue
+// primaries and the tone curve of each channel
+type Profile struct {
+	Primaries [3][3]float64 // XYZ of red, green and blue
+	curves    [3]curve
+}
+
+// curve is a channel's tone curve, from the encoded value to linear light
+type curve struct {
+	table  []uint16  // Sampled curve, used if not empty
+	params []float64 // Parametric curve, gamma first, see eval
+}
+
+// eval returns the linear light of an encoded value, both 0-1
+func (c curve) eval(x float64) float64 {
+	if len(c.table) > 0 {
+		pos := x * float64(len(c.table)-1)
+		i := int(pos)
+		if i >= len(c.table)-1 {
+			return float64(c.table[len(c.table)-1]) / 65535
+		}
+		frac := pos - float64(i)
+		return (float64(c.table[i])*(1-frac) + float64(c.table[i+1])*frac) / 65535
+	}
+
+	// The parametric curve types 0 to 4 of the ICC specification, the
+	// number of parameters selects the type
+	p := c.params
+	g := p[0]
+	switch len(p) {
+	case 1:
+		return math.Pow(x, g)
+	case 3:
+		if x >= -p[2]/p[1] {
+			return math.Pow(p[1]*x+p[2], g)
+		}
+		return 0
+	case 4:
+		if x >= -p[2]/p[1] {
+			return math.Pow(p[1]*x+p[2], g) + p[3]
+		}
+		return p[3]
+	case 5:
+		if x >= p[4] {
+			return math.Pow(p[1]*x+p[2], g)
+		}
+		return p[3] * x
+	default:
+		if x >= p[4] {
+			return math.Pow(p[1]*x+p[2], g) + p[5]
+		}
+		return p[3]*x + p[6]
+	}
+}
+
+// srgbCurve is the tone curve of sRGB
+var srgbCurve = curve{params: []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}}
+
+// Parse reads the primaries and tone curves of a display profile
+func Parse(data []byte) (*Profile, error) {
+	if len(data) < headerSize+4 || string(data[36:40]) != "acsp" {
+		return nil, ErrInvalid
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, ErrUnsupported
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[headerSize:]))
+	if count > (len(data)-headerSize-4)/tagEntrySize {
+		return nil, ErrInvalid
+	}
+	for i := 0; i < count; i++ {
+		entry := data[headerSize+4+i*tagEntrySize:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 0 || offset > len(data) || size > len(data)-offset {
+			return nil, ErrInvalid
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	var p Profile
+	for i, channel := range []string{"r", "g", "b"} {
+		xyz, ok := tags[channel+"XYZ"]
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		primary, err := parseXYZ(xyz)
+		if err != nil {
+			return nil, err
+		}
+		p.Primaries[i] = primary
+
+		trc, ok := tags[channel+"TRC"]
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		if p.curves[i], err = parseCurve(trc); err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// parseXYZ reads an XYZType tag
+func parseXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, ErrInvalid
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+// parseCurve reads a curveType or parametricCurveType tag
+func parseCurve(tag []byte) (curve, error) {
+	if len(tag) < 12 {
+		return curve{}, ErrInvalid
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n > (len(tag)-12)/2 {
+			return curve{}, ErrInvalid
+		}
+		switch n {
+		case 0:
+			return curve{params: []float64{1}}, nil
+		case 1:
+			return curve{params: []float64{float64(binary.BigEndian.Uint16(tag[12:])) / 256}}, nil
+		}
+		table := make([]uint16, n)
+		for i := range table {
+			table[i] = binary.BigEndian.Uint16(tag[12+i*2:])
+		}
+		return curve{table: table}, nil
+
+	case "para":
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		if kind >= len(counts) {
+			return curve{}, fmt.Errorf("%w: parametric curve type %d", ErrUnsupported, kind)
+		}
+		n := counts[kind]
+		if len(tag) < 12+n*4 {
+			return curve{}, ErrInvalid
+		}
+		params := make([]float64, n)
+		for i := range params {
+			params[i] = s15Fixed16(tag[12+i*4:])
+		}
+		if n > 1 && params[1] == 0 {
+			return curve{}, ErrInvalid
+		}
+		return curve{params: params}, nil
+	}
+	return curve{}, ErrUnsupported
+}
+
+// s15Fixed16 reads a signed 15.16 fixed point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// Transform converts the pixels of a display with a profile to sRGB
+type Transform struct {
+	linear [3][256]float32 // Tone curves as tables, by channel
+	matrix [3][3]float32   // Linear display RGB to linear sRGB
+	encode [transformTableSize]uint8
+}
+
+// ToSRGB returns the transform of pictures shown on a display with the
+// profile to sRGB, nil if the display is close enough to sRGB to show them
+// as they are
+func (p *Profile) ToSRGB() *Transform {
+	var matrix [3][3]float64
+	identity := true
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			for k := 0; k < 3; k++ {
+				matrix[row][col] += srgbFromXYZ[row][k] * p.Primaries[col][k]
+			}
+			want := 0.0
+			if row == col {
+				want = 1
+			}
+			identity = identity && math.Abs(matrix[row][col]-want) < srgbTolerance
+		}
+	}
+
+	t := &Transform{}
+	for channel, c := range p.curves {
+		for v := 0; v < 256; v++ {
+			x := float64(v) / 255
+			linear := c.eval(x)
+			identity = identity && math.Abs(linear-srgbCurve.eval(x)) < srgbTolerance
+			t.linear[channel][v] = float32(linear)
+		}
+	}
+	if identity {
+		return nil
+	}
+
+	for row := range matrix {
+		for col := range matrix[row] {
+			t.matrix[row][col] = float32(matrix[row][col])
+		}
+	}
+	for i := range t.encode {
+		t.encode[i] = uint8(math.Round(encodeSRGB(float64(i)/(transformTableSize-1)) * 255))
+	}
+	return t
+}
+
+// encodeSRGB applies the inverse of the sRGB tone curve
+func encodeSRGB(linear float64) float64 {
+	if linear <= 0.0031308 {
+		return linear * 12.92
+	}
+	return 1.055*math.Pow(linear, 1/2.4) - 0.055
+}
+
+// Apply converts the pixels of img in place
+func (t *Transform) Apply(img *image.RGBA) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for i := 0; i+3 < len(row); i += 4 {
+			r, g, b := t.linear[0][row[i]], t.linear[1][row[i+1]], t.linear[2][row[i+2]]
+			row[i] = t.encodeLinear(t.matrix[0][0]*r + t.matrix[0][1]*g + t.matrix[0][2]*b)
+			row[i+1] = t.encodeLinear(t.matrix[1][0]*r + t.matrix[1][1]*g + t.matrix[1][2]*b)
+			row[i+2] = t.encodeLinear(t.matrix[2][0]*r + t.matrix[2][1]*g + t.matrix[2][2]*b)
+		}
+	}
+}
+
+// encodeLinear encodes linear sRGB light, clipping colors out of its gamut
+func (t *Transform) encodeLinear(v float32) uint8 {
+	i := int(v*(transformTableSize-1) + 0.5)
+	return t.encode[min(max(i, 0), transformTableSize-1)]
+}
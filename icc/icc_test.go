@@ -0,0 +1,105 @@
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Primaries of sRGB and Display P3, adapted to D50
+var (
+	srgbPrimaries = [3][3]float64{{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}}
+	p3Primaries   = [3][3]float64{{0.5151, 0.2412, -0.0011}, {0.2920, 0.6922, 0.0419}, {0.1571, 0.0666, 0.7841}}
+)
+
+// buildProfile writes a display profile with the given primaries and the
+// sRGB tone curve as a parametric curve
+func buildProfile(primaries [3][3]float64) []byte {
+	fixed := func(b []byte, v float64) {
+		binary.BigEndian.PutUint32(b, uint32(int32(v*65536)))
+	}
+	var tags [][2][]byte
+	for i, channel := range []string{"r", "g", "b"} {
+		xyz := make([]byte, 20)
+		copy(xyz, "XYZ ")
+		for j, v := range primaries[i] {
+			fixed(xyz[8+j*4:], v)
+		}
+		para := make([]byte, 12+5*4)
+		copy(para, "para")
+		binary.BigEndian.PutUint16(para[8:], 3)
+		for j, v := range srgbCurve.params {
+			fixed(para[12+j*4:], v)
+		}
+		tags = append(tags, [2][]byte{[]byte(channel + "XYZ"), xyz}, [2][]byte{[]byte(channel + "TRC"), para})
+	}
+
+	data := make([]byte, headerSize+4+len(tags)*tagEntrySize)
+	copy(data[16:], "RGB XYZ ")
+	copy(data[36:], "acsp")
+	binary.BigEndian.PutUint32(data[headerSize:], uint32(len(tags)))
+	for i, tag := range tags {
+		entry := data[headerSize+4+i*tagEntrySize:]
+		copy(entry, tag[0])
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(data)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tag[1])))
+		data = append(data, tag[1]...)
+	}
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func TestSRGBNeedsNoTransform(t *testing.T) {
+	p, err := Parse(buildProfile(srgbPrimaries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ToSRGB() != nil {
+		t.Error("sRGB profile got a transform")
+	}
+}
+
+func TestWideGamutTransform(t *testing.T) {
+	p, err := Parse(buildProfile(p3Primaries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform := p.ToSRGB()
+	if transform == nil {
+		t.Fatal("Display P3 profile got no transform")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{128, 128, 128, 255})
+	img.SetRGBA(1, 0, color.RGBA{255, 0, 0, 255})
+	img.SetRGBA(2, 0, color.RGBA{200, 100, 50, 255})
+	transform.Apply(img)
+
+	// Gray keeps its level, colors gain the saturation P3 showed them with
+	if gray := img.RGBAAt(0, 0); absDiff(gray.R, 128) > 1 || absDiff(gray.G, 128) > 1 || absDiff(gray.B, 128) > 1 {
+		t.Errorf("gray became %v", gray)
+	}
+	if red := img.RGBAAt(1, 0); red != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("red became %v, want it clipped to sRGB red", red)
+	}
+	if orange := img.RGBAAt(2, 0); orange.R <= 200 || orange.B >= 50 {
+		t.Errorf("orange became %v, want it more saturated", orange)
+	}
+}
+
+func TestParseRejectsOtherData(t *testing.T) {
+	if _, err := Parse([]byte("not a profile")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("got %v, want ErrInvalid", err)
+	}
+	data := buildProfile(srgbPrimaries)
+	copy(data[16:], "CMYK")
+	if _, err := Parse(data); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
+
+func absDiff(a, b uint8) int {
+	return max(int(a)-int(b), int(b)-int(a))
+}
@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AuthChallengeSize is the size of the random challenge sent by the server
+const AuthChallengeSize = 32
+
+// AuthResponseSize is the size of a client's response, an HMAC-SHA256
+const AuthResponseSize = sha256.Size
+
+// ErrAuthRequired is returned when the server asks for authentication but no
+// password was configured locally
+var ErrAuthRequired = errors.New("server requires authentication, a password must be configured")
+
+// NewAuthChallenge creates a random challenge for the auth challenge packet
+func NewAuthChallenge() ([]byte, error) {
+	challenge := make([]byte, AuthChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ComputeAuthResponse proves knowledge of the password without sending it,
+// by returning HMAC-SHA256 of the challenge keyed with the password
+func ComputeAuthResponse(password string, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// VerifyAuthResponse checks a client's response to a challenge
+func VerifyAuthResponse(password string, challenge, response []byte) bool {
+	return hmac.Equal(ComputeAuthResponse(password, challenge), response)
+}
+
+// ReadAuthResponse reads a client's auth response packet. The client isn't
+// authenticated yet, so packets of another type or size are refused before
+// their payload is read.
+func ReadAuthResponse(r io.Reader) ([]byte, error) {
+	packet, err := DecodePacketLimit(r, AuthResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Type != PacketTypeAuthResponse {
+		return nil, fmt.Errorf("expected auth response packet, got %d", packet.Type)
+	}
+	if len(packet.Payload) != AuthResponseSize {
+		return nil, fmt.Errorf("auth response of %d bytes, want %d", len(packet.Payload), AuthResponseSize)
+	}
+	return packet.Payload, nil
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// respond returns the auth response packet a client with password sends
+func respond(t *testing.T, password string, challenge []byte) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	response := ComputeAuthResponse(password, challenge)
+	if err := EncodePacket(&buf, NewPacket(PacketTypeAuthResponse, response)); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestAuthChallengeResponse(t *testing.T) {
+	challenge, err := NewAuthChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		password string
+		want     bool
+	}{
+		{"secret", true},
+		{"wrong", false},
+	} {
+		response, err := ReadAuthResponse(respond(t, tc.password, challenge))
+		if err != nil {
+			t.Fatalf("ReadAuthResponse: %v", err)
+		}
+		if got := VerifyAuthResponse("secret", challenge, response); got != tc.want {
+			t.Errorf("password %q verified %v, want %v", tc.password, got, tc.want)
+		}
+	}
+}
+
+func TestAuthResponseOversized(t *testing.T) {
+	// Only the header is sent, the length must be refused before a payload
+	// of that size is allocated or read
+	header := make([]byte, HeaderSize)
+	header[0] = PacketTypeAuthResponse
+	binary.LittleEndian.PutUint32(header[9:], 0xFFFFFFF0)
+	if _, err := ReadAuthResponse(bytes.NewReader(header)); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("ReadAuthResponse returned %v, want %v", err, ErrPacketTooLarge)
+	}
+
+	// A short response is refused too
+	var buf bytes.Buffer
+	EncodePacket(&buf, NewPacket(PacketTypeAuthResponse, []byte{1, 2, 3}))
+	if _, err := ReadAuthResponse(&buf); err == nil {
+		t.Fatal("short auth response accepted")
+	}
+}
+
+func TestDecodePacketLimit(t *testing.T) {
+	header := make([]byte, HeaderSize)
+	binary.LittleEndian.PutUint32(header[9:], MaxPayloadSize+1)
+	if _, err := DecodePacket(bytes.NewReader(header)); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("DecodePacket returned %v, want %v", err, ErrPacketTooLarge)
+	}
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// StreamBulk is the ID of the stream carrying bulk transfers, such as large
+// clipboard updates, on multi-stream transports, so they never hold up a
+// monitor's frames
+const StreamBulk = 0xFFFFFFFD
+
+// BulkState is how the server schedules its bulk transfers to a client,
+// sent while they run for the client's stats
+type BulkState struct {
+	CapacityKbps uint32 // Estimated bandwidth to the client
+	LimitKbps    uint32 // Share of it bulk transfers may use
+	Transfers    uint16 // Transfers running
+	Throttled    bool   // A transfer waited for budget in the last second
+	Progress     uint8  // Percent of the running transfers' bytes sent
+}
+
+// BulkChunk is a piece of a packet sent as a bulk transfer over a single
+// connection. The packet is split so frames and input can be sent between
+// its pieces; the receiver joins them and handles the packet.
+type BulkChunk struct {
+	Type   byte   // Type of the packet being sent
+	Total  uint32 // Payload size of the whole packet
+	Offset uint32 // Where Data goes in the payload
+	Data   []byte
+}
+
+const (
+	// bulkStateSize is the size of an encoded BulkState
+	bulkStateSize = 12
+
+	// bulkChunkHeaderSize is the size of a BulkChunk before its data
+	bulkChunkHeaderSize = 9
+)
+
+var (
+	// ErrInvalidBulkState is returned for bulk state payloads that are too short
+	ErrInvalidBulkState = errors.New("invalid bulk state packet")
+
+	// ErrInvalidBulkChunk is returned for bulk chunks that are too short or
+	// reach beyond their packet
+	ErrInvalidBulkChunk = errors.New("invalid bulk chunk packet")
+)
+
+// EncodeBulkState encodes a bulk state
+func EncodeBulkState(state BulkState) []byte {
+	data := make([]byte, bulkStateSize)
+	binary.LittleEndian.PutUint32(data[0:4], state.CapacityKbps)
+	binary.LittleEndian.PutUint32(data[4:8], state.LimitKbps)
+	binary.LittleEndian.PutUint16(data[8:10], state.Transfers)
+	if state.Throttled {
+		data[10] = 1
+	}
+	data[11] = state.Progress
+	return data
+}
+
+// DecodeBulkState decodes a bulk state payload
+func DecodeBulkState(data []byte) (BulkState, error) {
+	if len(data) < bulkStateSize {
+		return BulkState{}, ErrInvalidBulkState
+	}
+	return BulkState{
+		CapacityKbps: binary.LittleEndian.Uint32(data[0:4]),
+		LimitKbps:    binary.LittleEndian.Uint32(data[4:8]),
+		Transfers:    binary.LittleEndian.Uint16(data[8:10]),
+		Throttled:    data[10] != 0,
+		Progress:     data[11],
+	}, nil
+}
+
+// EncodeBulkChunk encodes a bulk chunk
+func EncodeBulkChunk(chunk BulkChunk) []byte {
+	data := make([]byte, bulkChunkHeaderSize+len(chunk.Data))
+	data[0] = chunk.Type
+	binary.LittleEndian.PutUint32(data[1:5], chunk.Total)
+	binary.LittleEndian.PutUint32(data[5:9], chunk.Offset)
+	copy(data[bulkChunkHeaderSize:], chunk.Data)
+	return data
+}
+
+// DecodeBulkChunk decodes a bulk chunk payload. Data refers to data.
+func DecodeBulkChunk(data []byte) (BulkChunk, error) {
+	if len(data) < bulkChunkHeaderSize {
+		return BulkChunk{}, ErrInvalidBulkChunk
+	}
+	chunk := BulkChunk{
+		Type:   data[0],
+		Total:  binary.LittleEndian.Uint32(data[1:5]),
+		Offset: binary.LittleEndian.Uint32(data[5:9]),
+		Data:   data[bulkChunkHeaderSize:],
+	}
+	if chunk.Total > MaxPayloadSize || uint64(chunk.Offset)+uint64(len(chunk.Data)) > uint64(chunk.Total) {
+		return BulkChunk{}, ErrInvalidBulkChunk
+	}
+	return chunk, nil
+}
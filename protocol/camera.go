@@ -0,0 +1,13 @@
+package protocol
+
+// A client asks for the server's webcam with a PacketTypeCameraRequest
+// packet whose payload is a single byte, CameraOn or CameraOff. Webcam
+// frames are sent in PacketTypeCameraFrame packets, in either direction,
+// each payload a JPEG image.
+
+const (
+	// CameraOff stops the server's webcam stream
+	CameraOff = 0
+	// CameraOn starts the server's webcam stream
+	CameraOn = 1
+)
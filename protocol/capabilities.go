@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The client describes its display in a PacketTypeCapabilities packet, a
+// little endian uint32 of Capability flags. Servers treat clients that
+// don't send one as having none of them, and ignore unknown flags.
+
+// Capability is something a client's display can show
+type Capability uint32
+
+const (
+	// CapabilityHDR is set by clients showing frames in high dynamic
+	// range. Servers tone map HDR screens for clients without it.
+	CapabilityHDR Capability = 1 << iota
+)
+
+// capabilitiesSize is the size of a capabilities payload
+const capabilitiesSize = 4
+
+// ErrInvalidCapabilities is returned for capabilities payloads that can't
+// be parsed
+var ErrInvalidCapabilities = errors.New("invalid capabilities packet")
+
+// EncodeCapabilities encodes a client's capabilities
+func EncodeCapabilities(capabilities Capability) []byte {
+	return binary.LittleEndian.AppendUint32(nil, uint32(capabilities))
+}
+
+// DecodeCapabilities decodes a capabilities payload
+func DecodeCapabilities(data []byte) (Capability, error) {
+	if len(data) < capabilitiesSize {
+		return 0, ErrInvalidCapabilities
+	}
+	return Capability(binary.LittleEndian.Uint32(data)), nil
+}
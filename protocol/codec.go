@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The client announces the codecs it can decode in a PacketTypeCodecs
+// packet, one byte per codec ID in order of preference. The server answers
+// with a PacketTypeCodecSelect packet per monitor naming the codec of that
+// monitor's following frames. Monitors may use different codecs and the
+// server selects again when a monitor can't be encoded with its codec, e.g.
+// because it's too large for the hardware encoder. Codec IDs are defined by
+// the codec package.
+
+// ErrInvalidCodecSelect is returned for codec select payloads that can't be
+// parsed
+var ErrInvalidCodecSelect = errors.New("invalid codec select packet")
+
+// EncodeCodecSelect encodes the codec chosen for a server monitor
+func EncodeCodecSelect(monitorID uint32, codec byte) []byte {
+	buf := make([]byte, 5)
+	binary.LittleEndian.PutUint32(buf, monitorID)
+	buf[4] = codec
+	return buf
+}
+
+// DecodeCodecSelect decodes a codec select payload
+func DecodeCodecSelect(data []byte) (monitorID uint32, codec byte, err error) {
+	if len(data) != 5 {
+		return 0, 0, ErrInvalidCodecSelect
+	}
+	return binary.LittleEndian.Uint32(data), data[4], nil
+}
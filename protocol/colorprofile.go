@@ -0,0 +1,26 @@
+package protocol
+
+import "errors"
+
+// The server sends the ICC color profile of each monitor that has one in a
+// PacketTypeColorProfile packet right after the handshake: the server
+// monitor ID as a little endian uint32 followed by the profile. Clients
+// convert the monitor's frames to sRGB with it, so a wide-gamut server
+// display doesn't look washed out on an sRGB one.
+
+// ErrInvalidColorProfile is returned for color profile payloads that can't
+// be parsed
+var ErrInvalidColorProfile = errors.New("invalid color profile packet")
+
+// EncodeColorProfile encodes the color profile of a server monitor
+func EncodeColorProfile(monitorID uint32, profile []byte) []byte {
+	return append(Uint32ToBytes(monitorID), profile...)
+}
+
+// DecodeColorProfile decodes a color profile payload
+func DecodeColorProfile(data []byte) (monitorID uint32, profile []byte, err error) {
+	if len(data) < 5 {
+		return 0, nil, ErrInvalidColorProfile
+	}
+	return BytesToUint32(data), data[4:], nil
+}
@@ -0,0 +1,229 @@
+package protocol
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encryption modes offered in the key exchange packet
+const (
+	EncryptionNone             = 0x00
+	EncryptionChaCha20Poly1305 = 0x01
+)
+
+// maxRecordSize is the largest plaintext carried by a single encrypted record
+const maxRecordSize = 64 * 1024
+
+// ErrEncryptionRequired is returned when the server requires encryption but
+// no pre-shared key was configured locally
+var ErrEncryptionRequired = errors.New("server requires encryption, a pre-shared key must be configured")
+
+// ErrEncryptionUnsupported is returned when a pre-shared key was configured
+// but the server did not offer encryption
+var ErrEncryptionUnsupported = errors.New("server does not offer encryption, refusing to continue in cleartext")
+
+// SecureConn wraps a connection and encrypts everything written to it with
+// ChaCha20-Poly1305. Each Write is split into length-prefixed records which
+// are sealed with a per-direction key and a counter nonce, so packets written
+// with EncodePacket get confidentiality and integrity without any change to
+// the packet codec.
+type SecureConn struct {
+	net.Conn
+
+	sendAEAD  cipher.AEAD
+	recvAEAD  cipher.AEAD
+	sendNonce uint64
+	recvNonce uint64
+
+	writeMutex sync.Mutex
+	readMutex  sync.Mutex
+	readBuf    []byte // Decrypted bytes not yet returned by Read
+}
+
+// ServerKeyExchange performs the server side of the key exchange on a freshly
+// accepted connection and returns the encrypted connection
+func ServerKeyExchange(conn net.Conn, psk []byte) (*SecureConn, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Offer our public key to the client
+	if err := EncodePacket(conn, NewPacket(PacketTypeKeyExchange, encodeKeyExchange(private.PublicKey()))); err != nil {
+		return nil, fmt.Errorf("failed to send key exchange: %w", err)
+	}
+
+	packet, err := DecodePacket(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive key exchange: %w", err)
+	}
+	if packet.Type != PacketTypeKeyExchange {
+		return nil, fmt.Errorf("expected key exchange packet, got %d", packet.Type)
+	}
+
+	peer, err := decodeKeyExchange(packet.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecureConn(conn, psk, private, private.PublicKey(), peer, true)
+}
+
+// ClientKeyExchange completes the key exchange started by the server. The
+// packet is the server's key exchange packet, already read by the caller.
+func ClientKeyExchange(conn net.Conn, psk []byte, packet *Packet) (*SecureConn, error) {
+	if packet.Type != PacketTypeKeyExchange {
+		return nil, fmt.Errorf("expected key exchange packet, got %d", packet.Type)
+	}
+
+	peer, err := decodeKeyExchange(packet.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := EncodePacket(conn, NewPacket(PacketTypeKeyExchange, encodeKeyExchange(private.PublicKey()))); err != nil {
+		return nil, fmt.Errorf("failed to send key exchange: %w", err)
+	}
+
+	return newSecureConn(conn, psk, private, peer, private.PublicKey(), false)
+}
+
+// encodeKeyExchange builds a key exchange payload: mode byte + X25519 public key
+func encodeKeyExchange(public *ecdh.PublicKey) []byte {
+	return append([]byte{EncryptionChaCha20Poly1305}, public.Bytes()...)
+}
+
+// decodeKeyExchange parses a key exchange payload
+func decodeKeyExchange(data []byte) (*ecdh.PublicKey, error) {
+	if len(data) != 33 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if data[0] != EncryptionChaCha20Poly1305 {
+		return nil, fmt.Errorf("unsupported encryption mode %d", data[0])
+	}
+	return ecdh.X25519().NewPublicKey(data[1:])
+}
+
+// newSecureConn derives the session keys and wraps the connection. The
+// pre-shared key is mixed into the derivation, so a peer with the wrong key
+// fails on the first record it tries to decrypt.
+func newSecureConn(conn net.Conn, psk []byte, private *ecdh.PrivateKey, serverPublic, clientPublic *ecdh.PublicKey, isServer bool) (*SecureConn, error) {
+	peer := clientPublic
+	if !isServer {
+		peer = serverPublic
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	info := append([]byte("ultrardp v1"), serverPublic.Bytes()...)
+	info = append(info, clientPublic.Bytes()...)
+
+	keys := make([]byte, 2*chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, psk, info), keys); err != nil {
+		return nil, err
+	}
+
+	serverToClient, err := chacha20poly1305.New(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, err
+	}
+	clientToServer, err := chacha20poly1305.New(keys[chacha20poly1305.KeySize:])
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &SecureConn{Conn: conn}
+	if isServer {
+		sc.sendAEAD, sc.recvAEAD = serverToClient, clientToServer
+	} else {
+		sc.sendAEAD, sc.recvAEAD = clientToServer, serverToClient
+	}
+	return sc, nil
+}
+
+// nonce builds the AEAD nonce for a record counter
+func nonce(counter uint64) []byte {
+	n := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(n, counter)
+	return n
+}
+
+// Write encrypts p and writes it as one or more records
+func (sc *SecureConn) Write(p []byte) (int, error) {
+	sc.writeMutex.Lock()
+	defer sc.writeMutex.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxRecordSize {
+			chunk = chunk[:maxRecordSize]
+		}
+
+		record := make([]byte, 4, 4+len(chunk)+sc.sendAEAD.Overhead())
+		record = sc.sendAEAD.Seal(record, nonce(sc.sendNonce), chunk, nil)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(len(record)-4))
+		sc.sendNonce++
+
+		if _, err := sc.Conn.Write(record); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Read returns decrypted bytes, reading a new record when the buffer is empty
+func (sc *SecureConn) Read(p []byte) (int, error) {
+	sc.readMutex.Lock()
+	defer sc.readMutex.Unlock()
+
+	if len(sc.readBuf) == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(sc.Conn, header[:]); err != nil {
+			return 0, err
+		}
+
+		length := binary.LittleEndian.Uint32(header[:])
+		if length > maxRecordSize+uint32(sc.recvAEAD.Overhead()) {
+			return 0, fmt.Errorf("encrypted record too large: %d bytes", length)
+		}
+
+		record := make([]byte, length)
+		if _, err := io.ReadFull(sc.Conn, record); err != nil {
+			return 0, err
+		}
+
+		plaintext, err := sc.recvAEAD.Open(record[:0], nonce(sc.recvNonce), record, nil)
+		if err != nil {
+			return 0, errors.New("failed to decrypt record (wrong pre-shared key?)")
+		}
+		sc.recvNonce++
+		sc.readBuf = plaintext
+	}
+
+	n := copy(p, sc.readBuf)
+	sc.readBuf = sc.readBuf[n:]
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// keyExchange runs both sides of the key exchange over an in-memory pipe
+func keyExchange(t *testing.T, serverPSK, clientPSK []byte) (*SecureConn, *SecureConn) {
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	type result struct {
+		conn *SecureConn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := ServerKeyExchange(serverConn, serverPSK)
+		done <- result{conn, err}
+	}()
+
+	packet, err := DecodePacket(clientConn)
+	if err != nil {
+		t.Fatalf("Failed to read key exchange: %v", err)
+	}
+	client, err := ClientKeyExchange(clientConn, clientPSK, packet)
+	if err != nil {
+		t.Fatalf("Client key exchange failed: %v", err)
+	}
+
+	server := <-done
+	if server.err != nil {
+		t.Fatalf("Server key exchange failed: %v", server.err)
+	}
+	return server.conn, client
+}
+
+func TestSecureConnRoundTrip(t *testing.T) {
+	psk := []byte("correct horse battery staple")
+	server, client := keyExchange(t, psk, psk)
+
+	// Larger than a single record to exercise splitting
+	payload := bytes.Repeat([]byte{0xAB}, maxRecordSize*2+123)
+
+	go EncodePacket(server, NewPacket(PacketTypeVideoFrame, payload))
+
+	packet, err := DecodePacket(client)
+	if err != nil {
+		t.Fatalf("Failed to decode packet: %v", err)
+	}
+	if packet.Type != PacketTypeVideoFrame || !bytes.Equal(packet.Payload, payload) {
+		t.Fatalf("Packet corrupted in transit")
+	}
+}
+
+func TestSecureConnWrongKey(t *testing.T) {
+	server, client := keyExchange(t, []byte("server key"), []byte("client key"))
+
+	go EncodePacket(server, NewPacket(PacketTypePing, []byte("hello")))
+
+	if _, err := DecodePacket(client); err == nil {
+		t.Fatalf("Expected decryption to fail with mismatched keys")
+	}
+}
@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Servers that forward the cursor instead of drawing it into the frames
+// send its shape in a PacketTypeCursorShape packet whenever it changes:
+// the shape ID, the hotspot's x and y, the width and the height as little
+// endian uint32, uint16, uint16, uint16 and uint16, followed by the pixels
+// as RGBA with straight alpha, row by row. Where the cursor is follows in
+// PacketTypeCursorPosition packets: the server monitor ID, the hotspot's x
+// and y in monitor pixels as little endian int32, a visibility byte and
+// the ID of the shape. Clients draw the shape over the monitor's frames.
+
+// MaxCursorSize is the largest cursor width and height sent
+const MaxCursorSize = 256
+
+// CursorShape is the image of a cursor
+type CursorShape struct {
+	ID            uint32 // Identifies the shape in CursorPosition
+	HotspotX      int
+	HotspotY      int
+	Width, Height int
+	Pixels        []byte // RGBA, straight alpha
+}
+
+// CursorPosition is where the cursor is
+type CursorPosition struct {
+	MonitorID uint32 // Server monitor the cursor is on, 0 for none
+	X, Y      int32  // Of the hotspot, from the monitor's top left corner
+	Visible   bool
+	ShapeID   uint32
+}
+
+const (
+	cursorShapeHeaderSize = 12
+	cursorPositionSize    = 17
+)
+
+// ErrInvalidCursor is returned for cursor payloads that can't be parsed
+var ErrInvalidCursor = errors.New("invalid cursor packet")
+
+// EncodeCursorShape encodes a cursor shape
+func EncodeCursorShape(shape CursorShape) []byte {
+	buf := make([]byte, cursorShapeHeaderSize, cursorShapeHeaderSize+len(shape.Pixels))
+	binary.LittleEndian.PutUint32(buf[0:], shape.ID)
+	binary.LittleEndian.PutUint16(buf[4:], uint16(shape.HotspotX))
+	binary.LittleEndian.PutUint16(buf[6:], uint16(shape.HotspotY))
+	binary.LittleEndian.PutUint16(buf[8:], uint16(shape.Width))
+	binary.LittleEndian.PutUint16(buf[10:], uint16(shape.Height))
+	return append(buf, shape.Pixels...)
+}
+
+// DecodeCursorShape decodes a cursor shape payload
+func DecodeCursorShape(data []byte) (CursorShape, error) {
+	if len(data) < cursorShapeHeaderSize {
+		return CursorShape{}, ErrInvalidCursor
+	}
+	shape := CursorShape{
+		ID:       binary.LittleEndian.Uint32(data[0:]),
+		HotspotX: int(binary.LittleEndian.Uint16(data[4:])),
+		HotspotY: int(binary.LittleEndian.Uint16(data[6:])),
+		Width:    int(binary.LittleEndian.Uint16(data[8:])),
+		Height:   int(binary.LittleEndian.Uint16(data[10:])),
+		Pixels:   data[cursorShapeHeaderSize:],
+	}
+	if shape.Width > MaxCursorSize || shape.Height > MaxCursorSize || len(shape.Pixels) != shape.Width*shape.Height*4 {
+		return CursorShape{}, ErrInvalidCursor
+	}
+	return shape, nil
+}
+
+// EncodeCursorPosition encodes a cursor position
+func EncodeCursorPosition(position CursorPosition) []byte {
+	buf := make([]byte, cursorPositionSize)
+	binary.LittleEndian.PutUint32(buf[0:], position.MonitorID)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(position.X))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(position.Y))
+	if position.Visible {
+		buf[12] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[13:], position.ShapeID)
+	return buf
+}
+
+// DecodeCursorPosition decodes a cursor position payload
+func DecodeCursorPosition(data []byte) (CursorPosition, error) {
+	if len(data) < cursorPositionSize {
+		return CursorPosition{}, ErrInvalidCursor
+	}
+	return CursorPosition{
+		MonitorID: binary.LittleEndian.Uint32(data[0:]),
+		X:         int32(binary.LittleEndian.Uint32(data[4:])),
+		Y:         int32(binary.LittleEndian.Uint32(data[8:])),
+		Visible:   data[12] == 1,
+		ShapeID:   binary.LittleEndian.Uint32(data[13:]),
+	}, nil
+}
@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	shape := CursorShape{ID: 7, HotspotX: 3, HotspotY: 1, Width: 2, Height: 2, Pixels: bytes.Repeat([]byte{0xFF, 0, 0, 0x80}, 4)}
+	decoded, err := DecodeCursorShape(EncodeCursorShape(shape))
+	if err != nil || decoded.ID != shape.ID || decoded.HotspotX != 3 || decoded.HotspotY != 1 ||
+		decoded.Width != 2 || decoded.Height != 2 || !bytes.Equal(decoded.Pixels, shape.Pixels) {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+	if _, err := DecodeCursorShape(EncodeCursorShape(CursorShape{Width: 2, Height: 2})); err == nil {
+		t.Fatal("shape without pixels decoded")
+	}
+
+	position := CursorPosition{MonitorID: 2, X: -4, Y: 600, Visible: true, ShapeID: 7}
+	if decoded, err := DecodeCursorPosition(EncodeCursorPosition(position)); err != nil || decoded != position {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A client asks for a monitor's frame rate in a PacketTypeFrameRate packet:
+// the server monitor ID, 0 for every monitor, and the frames per second,
+// 0 for the server's default, as little endian uint32s. Frames are captured
+// once for all clients, so a monitor runs at the highest rate asked for.
+
+// ErrInvalidFrameRate is returned for frame rate payloads that can't be
+// parsed
+var ErrInvalidFrameRate = errors.New("invalid frame rate packet")
+
+// EncodeFrameRate encodes a frame rate request
+func EncodeFrameRate(monitorID uint32, fps int) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, monitorID)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(fps))
+	return buf
+}
+
+// DecodeFrameRate decodes a frame rate request
+func DecodeFrameRate(data []byte) (monitorID uint32, fps int, err error) {
+	if len(data) != 8 {
+		return 0, 0, ErrInvalidFrameRate
+	}
+	return binary.LittleEndian.Uint32(data), int(binary.LittleEndian.Uint32(data[4:])), nil
+}
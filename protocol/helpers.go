@@ -15,4 +15,19 @@ func Uint32ToBytes(v uint32) []byte {
 	b := make([]byte, 4)
 	binary.LittleEndian.PutUint32(b, v)
 	return b
-}
\ No newline at end of file
+}
+
+// BytesToUint64 converts a byte slice to a uint64
+func BytesToUint64(b []byte) uint64 {
+	if len(b) < 8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// Uint64ToBytes converts a uint64 to a byte slice
+func Uint64ToBytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,343 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// KeyCode identifies a key independently of the keyboard layout and the
+// platform. It is the USB HID usage of the key: the usage page in the upper
+// 16 bits (0x07 keyboard, 0x0C consumer/media) and the usage in the lower
+// 16 bits.
+type KeyCode uint32
+
+// Keyboard page keys
+const (
+	KeyA KeyCode = 0x070004 + iota
+	KeyB
+	KeyC
+	KeyD
+	KeyE
+	KeyF
+	KeyG
+	KeyH
+	KeyI
+	KeyJ
+	KeyK
+	KeyL
+	KeyM
+	KeyN
+	KeyO
+	KeyP
+	KeyQ
+	KeyR
+	KeyS
+	KeyT
+	KeyU
+	KeyV
+	KeyW
+	KeyX
+	KeyY
+	KeyZ
+	Key1
+	Key2
+	Key3
+	Key4
+	Key5
+	Key6
+	Key7
+	Key8
+	Key9
+	Key0
+	KeyEnter
+	KeyEscape
+	KeyBackspace
+	KeyTab
+	KeySpace
+	KeyMinus
+	KeyEqual
+	KeyLeftBracket
+	KeyRightBracket
+	KeyBackslash
+)
+
+const (
+	KeySemicolon KeyCode = 0x070033 + iota
+	KeyApostrophe
+	KeyGraveAccent
+	KeyComma
+	KeyPeriod
+	KeySlash
+	KeyCapsLock
+	KeyF1
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+	KeyPrintScreen
+	KeyScrollLock
+	KeyPause
+	KeyInsert
+	KeyHome
+	KeyPageUp
+	KeyDelete
+	KeyEnd
+	KeyPageDown
+	KeyRight
+	KeyLeft
+	KeyDown
+	KeyUp
+	KeyNumLock
+	KeyKPDivide
+	KeyKPMultiply
+	KeyKPSubtract
+	KeyKPAdd
+	KeyKPEnter
+	KeyKP1
+	KeyKP2
+	KeyKP3
+	KeyKP4
+	KeyKP5
+	KeyKP6
+	KeyKP7
+	KeyKP8
+	KeyKP9
+	KeyKP0
+	KeyKPDecimal
+	KeyNonUSBackslash
+	KeyMenu
+)
+
+const (
+	KeyF13 KeyCode = 0x070068 + iota
+	KeyF14
+	KeyF15
+	KeyF16
+	KeyF17
+	KeyF18
+	KeyF19
+	KeyF20
+	KeyF21
+	KeyF22
+	KeyF23
+	KeyF24
+)
+
+const (
+	KeyLeftControl KeyCode = 0x0700E0 + iota
+	KeyLeftShift
+	KeyLeftAlt
+	KeyLeftSuper // Windows/Command key
+	KeyRightControl
+	KeyRightShift
+	KeyRightAlt
+	KeyRightSuper
+)
+
+// Consumer page (media and browser) keys
+const (
+	KeyMediaNext      KeyCode = 0x0C00B5
+	KeyMediaPrevious  KeyCode = 0x0C00B6
+	KeyMediaStop      KeyCode = 0x0C00B7
+	KeyMediaPlayPause KeyCode = 0x0C00CD
+	KeyVolumeMute     KeyCode = 0x0C00E2
+	KeyVolumeUp       KeyCode = 0x0C00E9
+	KeyVolumeDown     KeyCode = 0x0C00EA
+	KeyLaunchMail     KeyCode = 0x0C018A
+	KeyLaunchCalc     KeyCode = 0x0C0192
+	KeyBrowserSearch  KeyCode = 0x0C0221
+	KeyBrowserHome    KeyCode = 0x0C0223
+	KeyBrowserBack    KeyCode = 0x0C0224
+	KeyBrowserForward KeyCode = 0x0C0225
+	KeyBrowserRefresh KeyCode = 0x0C0227
+)
+
+// WindowsKey describes how a key is injected and reported on Windows
+type WindowsKey struct {
+	// Scancode is the set 1 scancode without the 0xE0 prefix
+	Scancode uint16
+	// Extended is set for keys sent with an 0xE0 prefix, such as the arrow
+	// keys, the right modifiers and the media keys
+	Extended bool
+	// VirtualKey is set for keys Windows only handles reliably by virtual
+	// key: media keys, Pause and Num Lock. Zero means inject by scancode.
+	VirtualKey uint16
+}
+
+// keyInfo is an entry of the key table shared by client capture and server
+// injection
+type keyInfo struct {
+	name    string
+	windows WindowsKey
+}
+
+// scan and extended build the Windows part of a key table entry
+func scan(code uint16) WindowsKey     { return WindowsKey{Scancode: code} }
+func extended(code uint16) WindowsKey { return WindowsKey{Scancode: code, Extended: true} }
+
+var keyTable = map[KeyCode]keyInfo{
+	KeyA: {"A", scan(0x1E)}, KeyB: {"B", scan(0x30)}, KeyC: {"C", scan(0x2E)},
+	KeyD: {"D", scan(0x20)}, KeyE: {"E", scan(0x12)}, KeyF: {"F", scan(0x21)},
+	KeyG: {"G", scan(0x22)}, KeyH: {"H", scan(0x23)}, KeyI: {"I", scan(0x17)},
+	KeyJ: {"J", scan(0x24)}, KeyK: {"K", scan(0x25)}, KeyL: {"L", scan(0x26)},
+	KeyM: {"M", scan(0x32)}, KeyN: {"N", scan(0x31)}, KeyO: {"O", scan(0x18)},
+	KeyP: {"P", scan(0x19)}, KeyQ: {"Q", scan(0x10)}, KeyR: {"R", scan(0x13)},
+	KeyS: {"S", scan(0x1F)}, KeyT: {"T", scan(0x14)}, KeyU: {"U", scan(0x16)},
+	KeyV: {"V", scan(0x2F)}, KeyW: {"W", scan(0x11)}, KeyX: {"X", scan(0x2D)},
+	KeyY: {"Y", scan(0x15)}, KeyZ: {"Z", scan(0x2C)},
+
+	Key1: {"1", scan(0x02)}, Key2: {"2", scan(0x03)}, Key3: {"3", scan(0x04)},
+	Key4: {"4", scan(0x05)}, Key5: {"5", scan(0x06)}, Key6: {"6", scan(0x07)},
+	Key7: {"7", scan(0x08)}, Key8: {"8", scan(0x09)}, Key9: {"9", scan(0x0A)},
+	Key0: {"0", scan(0x0B)},
+
+	KeyEnter: {"Enter", scan(0x1C)}, KeyEscape: {"Escape", scan(0x01)},
+	KeyBackspace: {"Backspace", scan(0x0E)}, KeyTab: {"Tab", scan(0x0F)},
+	KeySpace: {"Space", scan(0x39)}, KeyMinus: {"-", scan(0x0C)},
+	KeyEqual: {"=", scan(0x0D)}, KeyLeftBracket: {"[", scan(0x1A)},
+	KeyRightBracket: {"]", scan(0x1B)}, KeyBackslash: {"\\", scan(0x2B)},
+	KeySemicolon: {";", scan(0x27)}, KeyApostrophe: {"'", scan(0x28)},
+	KeyGraveAccent: {"`", scan(0x29)}, KeyComma: {",", scan(0x33)},
+	KeyPeriod: {".", scan(0x34)}, KeySlash: {"/", scan(0x35)},
+	KeyCapsLock: {"CapsLock", scan(0x3A)}, KeyNonUSBackslash: {"NonUSBackslash", scan(0x56)},
+
+	KeyF1: {"F1", scan(0x3B)}, KeyF2: {"F2", scan(0x3C)}, KeyF3: {"F3", scan(0x3D)},
+	KeyF4: {"F4", scan(0x3E)}, KeyF5: {"F5", scan(0x3F)}, KeyF6: {"F6", scan(0x40)},
+	KeyF7: {"F7", scan(0x41)}, KeyF8: {"F8", scan(0x42)}, KeyF9: {"F9", scan(0x43)},
+	KeyF10: {"F10", scan(0x44)}, KeyF11: {"F11", scan(0x57)}, KeyF12: {"F12", scan(0x58)},
+	KeyF13: {"F13", scan(0x64)}, KeyF14: {"F14", scan(0x65)}, KeyF15: {"F15", scan(0x66)},
+	KeyF16: {"F16", scan(0x67)}, KeyF17: {"F17", scan(0x68)}, KeyF18: {"F18", scan(0x69)},
+	KeyF19: {"F19", scan(0x6A)}, KeyF20: {"F20", scan(0x6B)}, KeyF21: {"F21", scan(0x6C)},
+	KeyF22: {"F22", scan(0x6D)}, KeyF23: {"F23", scan(0x6E)}, KeyF24: {"F24", scan(0x76)},
+
+	KeyPrintScreen: {"PrintScreen", extended(0x37)},
+	KeyScrollLock:  {"ScrollLock", scan(0x46)},
+	KeyPause:       {"Pause", WindowsKey{Scancode: 0x45, VirtualKey: 0x13}},
+	KeyInsert:      {"Insert", extended(0x52)},
+	KeyHome:        {"Home", extended(0x47)},
+	KeyPageUp:      {"PageUp", extended(0x49)},
+	KeyDelete:      {"Delete", extended(0x53)},
+	KeyEnd:         {"End", extended(0x4F)},
+	KeyPageDown:    {"PageDown", extended(0x51)},
+	KeyRight:       {"Right", extended(0x4D)},
+	KeyLeft:        {"Left", extended(0x4B)},
+	KeyDown:        {"Down", extended(0x50)},
+	KeyUp:          {"Up", extended(0x48)},
+	KeyMenu:        {"Menu", extended(0x5D)},
+
+	KeyNumLock:    {"NumLock", WindowsKey{Scancode: 0x45, Extended: true, VirtualKey: 0x90}},
+	KeyKPDivide:   {"KP/", extended(0x35)},
+	KeyKPMultiply: {"KP*", scan(0x37)},
+	KeyKPSubtract: {"KP-", scan(0x4A)},
+	KeyKPAdd:      {"KP+", scan(0x4E)},
+	KeyKPEnter:    {"KPEnter", extended(0x1C)},
+	KeyKPDecimal:  {"KP.", scan(0x53)},
+	KeyKP0:        {"KP0", scan(0x52)}, KeyKP1: {"KP1", scan(0x4F)}, KeyKP2: {"KP2", scan(0x50)},
+	KeyKP3: {"KP3", scan(0x51)}, KeyKP4: {"KP4", scan(0x4B)}, KeyKP5: {"KP5", scan(0x4C)},
+	KeyKP6: {"KP6", scan(0x4D)}, KeyKP7: {"KP7", scan(0x47)}, KeyKP8: {"KP8", scan(0x48)},
+	KeyKP9: {"KP9", scan(0x49)},
+
+	KeyLeftControl:  {"LeftControl", scan(0x1D)},
+	KeyLeftShift:    {"LeftShift", scan(0x2A)},
+	KeyLeftAlt:      {"LeftAlt", scan(0x38)},
+	KeyLeftSuper:    {"LeftSuper", WindowsKey{Scancode: 0x5B, Extended: true, VirtualKey: 0x5B}},
+	KeyRightControl: {"RightControl", extended(0x1D)},
+	KeyRightShift:   {"RightShift", scan(0x36)},
+	KeyRightAlt:     {"RightAlt", extended(0x38)},
+	KeyRightSuper:   {"RightSuper", WindowsKey{Scancode: 0x5C, Extended: true, VirtualKey: 0x5C}},
+
+	KeyMediaNext:      {"MediaNext", WindowsKey{Scancode: 0x19, Extended: true, VirtualKey: 0xB0}},
+	KeyMediaPrevious:  {"MediaPrevious", WindowsKey{Scancode: 0x10, Extended: true, VirtualKey: 0xB1}},
+	KeyMediaStop:      {"MediaStop", WindowsKey{Scancode: 0x24, Extended: true, VirtualKey: 0xB2}},
+	KeyMediaPlayPause: {"MediaPlayPause", WindowsKey{Scancode: 0x22, Extended: true, VirtualKey: 0xB3}},
+	KeyVolumeMute:     {"VolumeMute", WindowsKey{Scancode: 0x20, Extended: true, VirtualKey: 0xAD}},
+	KeyVolumeDown:     {"VolumeDown", WindowsKey{Scancode: 0x2E, Extended: true, VirtualKey: 0xAE}},
+	KeyVolumeUp:       {"VolumeUp", WindowsKey{Scancode: 0x30, Extended: true, VirtualKey: 0xAF}},
+	KeyLaunchMail:     {"LaunchMail", WindowsKey{Scancode: 0x6C, Extended: true, VirtualKey: 0xB4}},
+	KeyLaunchCalc:     {"LaunchCalc", WindowsKey{Scancode: 0x21, Extended: true, VirtualKey: 0xB7}},
+	KeyBrowserSearch:  {"BrowserSearch", WindowsKey{Scancode: 0x65, Extended: true, VirtualKey: 0xAA}},
+	KeyBrowserHome:    {"BrowserHome", WindowsKey{Scancode: 0x32, Extended: true, VirtualKey: 0xAC}},
+	KeyBrowserBack:    {"BrowserBack", WindowsKey{Scancode: 0x6A, Extended: true, VirtualKey: 0xA6}},
+	KeyBrowserForward: {"BrowserForward", WindowsKey{Scancode: 0x69, Extended: true, VirtualKey: 0xA7}},
+	KeyBrowserRefresh: {"BrowserRefresh", WindowsKey{Scancode: 0x67, Extended: true, VirtualKey: 0xA8}},
+}
+
+// windowsScancodes maps Windows scancodes, with 0x100 set for extended
+// keys, back to key codes
+var windowsScancodes = func() map[uint16]KeyCode {
+	m := make(map[uint16]KeyCode, len(keyTable))
+	for code, info := range keyTable {
+		scancode := info.windows.Scancode
+		if info.windows.Extended {
+			scancode |= 0x100
+		}
+		m[scancode] = code
+	}
+	return m
+}()
+
+// String returns the key name
+func (k KeyCode) String() string {
+	if info, ok := keyTable[k]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("Key(%#06x)", uint32(k))
+}
+
+// Windows returns how the key is injected on Windows
+func (k KeyCode) Windows() (WindowsKey, bool) {
+	info, ok := keyTable[k]
+	return info.windows, ok
+}
+
+// KeyFromWindowsScancode returns the key for a Windows scancode as found in
+// bits 16-24 of WM_KEYDOWN's lParam: the set 1 scancode with 0x100 set for
+// extended keys. Clients use it for keys their toolkit doesn't name, such
+// as the media keys.
+func KeyFromWindowsScancode(scancode uint16) (KeyCode, bool) {
+	code, ok := windowsScancodes[scancode]
+	return code, ok
+}
+
+// keyEventSize is key code(4) + down(1)
+const keyEventSize = 5
+
+// ErrInvalidKeyEvent is returned for keyboard payloads that can't be parsed
+var ErrInvalidKeyEvent = errors.New("invalid key event")
+
+// KeyEvent is the payload of a keyboard packet. Modifiers are sent as key
+// events of their own, so combinations like Win+R arrive as the same
+// sequence of presses and releases as on the client.
+type KeyEvent struct {
+	Code KeyCode
+	Down bool // Pressed or auto-repeated, released otherwise
+}
+
+// EncodeKeyEvent encodes a keyboard packet payload
+func EncodeKeyEvent(event KeyEvent) []byte {
+	buf := make([]byte, keyEventSize)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(event.Code))
+	if event.Down {
+		buf[4] = 1
+	}
+	return buf
+}
+
+// DecodeKeyEvent decodes a keyboard packet payload
+func DecodeKeyEvent(data []byte) (KeyEvent, error) {
+	if len(data) != keyEventSize {
+		return KeyEvent{}, ErrInvalidKeyEvent
+	}
+	return KeyEvent{
+		Code: KeyCode(binary.LittleEndian.Uint32(data[0:4])),
+		Down: data[4] != 0,
+	}, nil
+}
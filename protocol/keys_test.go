@@ -0,0 +1,52 @@
+package protocol
+
+import "testing"
+
+func TestWindowsScancodesUnique(t *testing.T) {
+	if len(windowsScancodes) != len(keyTable) {
+		t.Fatalf("%d keys share a Windows scancode", len(keyTable)-len(windowsScancodes))
+	}
+
+	// Extended keys must not be confused with their non-extended twins
+	if code, _ := KeyFromWindowsScancode(0x11D); code != KeyRightControl {
+		t.Fatalf("scancode 0x11D mapped to %v, want RightControl", code)
+	}
+	if code, _ := KeyFromWindowsScancode(0x122); code != KeyMediaPlayPause {
+		t.Fatalf("scancode 0x122 mapped to %v, want MediaPlayPause", code)
+	}
+}
+
+func TestKeyEventRoundTrip(t *testing.T) {
+	event := KeyEvent{Code: KeyVolumeUp, Down: true}
+	decoded, err := DecodeKeyEvent(EncodeKeyEvent(event))
+	if err != nil || decoded != event {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+}
+
+func TestMacKeysUnique(t *testing.T) {
+	// Only the PC keys macOS treats as F13 to F15 share a key code
+	seen := make(map[MacKey]KeyCode)
+	for code, key := range macKeys {
+		if code == KeyPrintScreen || code == KeyScrollLock || code == KeyPause {
+			continue
+		}
+		if other, ok := seen[key]; ok {
+			t.Errorf("%v and %v share the macOS key %+v", code, other, key)
+		}
+		seen[key] = code
+	}
+}
+
+func TestLinuxKeysUnique(t *testing.T) {
+	seen := make(map[uint16]KeyCode)
+	for code, key := range linuxKeys {
+		if other, ok := seen[key]; ok {
+			t.Errorf("%v and %v share the Linux key %d", code, other, key)
+		}
+		seen[key] = code
+	}
+	if len(linuxKeys) != len(keyTable) {
+		t.Errorf("%d keys have a Linux code, %d are known", len(linuxKeys), len(keyTable))
+	}
+}
@@ -0,0 +1,45 @@
+package protocol
+
+// linuxKeys maps key codes to Linux input event codes, KEY_* in
+// linux/input-event-codes.h. Print Screen is SysRq, as on PC keyboards.
+var linuxKeys = map[KeyCode]uint16{
+	KeyEscape: 1, Key1: 2, Key2: 3, Key3: 4, Key4: 5, Key5: 6, Key6: 7, Key7: 8, Key8: 9,
+	Key9: 10, Key0: 11, KeyMinus: 12, KeyEqual: 13, KeyBackspace: 14, KeyTab: 15,
+
+	KeyQ: 16, KeyW: 17, KeyE: 18, KeyR: 19, KeyT: 20, KeyY: 21, KeyU: 22, KeyI: 23,
+	KeyO: 24, KeyP: 25, KeyLeftBracket: 26, KeyRightBracket: 27, KeyEnter: 28,
+	KeyA: 30, KeyS: 31, KeyD: 32, KeyF: 33, KeyG: 34, KeyH: 35, KeyJ: 36, KeyK: 37,
+	KeyL: 38, KeySemicolon: 39, KeyApostrophe: 40, KeyGraveAccent: 41, KeyBackslash: 43,
+	KeyZ: 44, KeyX: 45, KeyC: 46, KeyV: 47, KeyB: 48, KeyN: 49, KeyM: 50,
+	KeyComma: 51, KeyPeriod: 52, KeySlash: 53, KeySpace: 57, KeyCapsLock: 58,
+	KeyNonUSBackslash: 86,
+
+	KeyF1: 59, KeyF2: 60, KeyF3: 61, KeyF4: 62, KeyF5: 63, KeyF6: 64, KeyF7: 65,
+	KeyF8: 66, KeyF9: 67, KeyF10: 68, KeyF11: 87, KeyF12: 88,
+	KeyF13: 183, KeyF14: 184, KeyF15: 185, KeyF16: 186, KeyF17: 187, KeyF18: 188,
+	KeyF19: 189, KeyF20: 190, KeyF21: 191, KeyF22: 192, KeyF23: 193, KeyF24: 194,
+
+	KeyPrintScreen: 99, KeyScrollLock: 70, KeyPause: 119,
+	KeyInsert: 110, KeyHome: 102, KeyPageUp: 104, KeyDelete: 111, KeyEnd: 107,
+	KeyPageDown: 109, KeyRight: 106, KeyLeft: 105, KeyDown: 108, KeyUp: 103,
+	KeyMenu: 127,
+
+	KeyNumLock: 69, KeyKPDivide: 98, KeyKPMultiply: 55, KeyKPSubtract: 74, KeyKPAdd: 78,
+	KeyKPEnter: 96, KeyKPDecimal: 83, KeyKP0: 82, KeyKP1: 79, KeyKP2: 80, KeyKP3: 81,
+	KeyKP4: 75, KeyKP5: 76, KeyKP6: 77, KeyKP7: 71, KeyKP8: 72, KeyKP9: 73,
+
+	KeyLeftControl: 29, KeyLeftShift: 42, KeyLeftAlt: 56, KeyLeftSuper: 125,
+	KeyRightControl: 97, KeyRightShift: 54, KeyRightAlt: 100, KeyRightSuper: 126,
+
+	KeyMediaNext: 163, KeyMediaPrevious: 165, KeyMediaStop: 166, KeyMediaPlayPause: 164,
+	KeyVolumeMute: 113, KeyVolumeUp: 115, KeyVolumeDown: 114,
+	KeyLaunchMail: 155, KeyLaunchCalc: 140, KeyBrowserSearch: 217, KeyBrowserHome: 172,
+	KeyBrowserBack: 158, KeyBrowserForward: 159, KeyBrowserRefresh: 173,
+}
+
+// Linux returns the key's Linux input event code. X servers number keys
+// the same, plus 8.
+func (k KeyCode) Linux() (uint16, bool) {
+	code, ok := linuxKeys[k]
+	return code, ok
+}
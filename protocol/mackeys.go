@@ -0,0 +1,72 @@
+package protocol
+
+// MacKey describes how a key is injected on macOS
+type MacKey struct {
+	// KeyCode is the key's virtual key code, kVK_* in Carbon's Events.h,
+	// or for media keys its NX_KEYTYPE_* in IOKit's ev_keymap.h
+	KeyCode uint16
+	// Media is set for media keys, which macOS takes as system defined
+	// events rather than key events
+	Media bool
+}
+
+// macKeys maps key codes to macOS keys. PC keys without a Mac twin follow
+// what macOS does with PC keyboards: Insert is Help, Num Lock is Clear and
+// Print Screen, Scroll Lock and Pause are F13 to F15.
+var macKeys = map[KeyCode]MacKey{
+	KeyA: {KeyCode: 0x00}, KeyS: {KeyCode: 0x01}, KeyD: {KeyCode: 0x02}, KeyF: {KeyCode: 0x03},
+	KeyH: {KeyCode: 0x04}, KeyG: {KeyCode: 0x05}, KeyZ: {KeyCode: 0x06}, KeyX: {KeyCode: 0x07},
+	KeyC: {KeyCode: 0x08}, KeyV: {KeyCode: 0x09}, KeyB: {KeyCode: 0x0B}, KeyQ: {KeyCode: 0x0C},
+	KeyW: {KeyCode: 0x0D}, KeyE: {KeyCode: 0x0E}, KeyR: {KeyCode: 0x0F}, KeyY: {KeyCode: 0x10},
+	KeyT: {KeyCode: 0x11}, KeyO: {KeyCode: 0x1F}, KeyU: {KeyCode: 0x20}, KeyI: {KeyCode: 0x22},
+	KeyP: {KeyCode: 0x23}, KeyL: {KeyCode: 0x25}, KeyJ: {KeyCode: 0x26}, KeyK: {KeyCode: 0x28},
+	KeyN: {KeyCode: 0x2D}, KeyM: {KeyCode: 0x2E},
+
+	Key1: {KeyCode: 0x12}, Key2: {KeyCode: 0x13}, Key3: {KeyCode: 0x14}, Key4: {KeyCode: 0x15},
+	Key5: {KeyCode: 0x17}, Key6: {KeyCode: 0x16}, Key7: {KeyCode: 0x1A}, Key8: {KeyCode: 0x1C},
+	Key9: {KeyCode: 0x19}, Key0: {KeyCode: 0x1D},
+
+	KeyEnter: {KeyCode: 0x24}, KeyEscape: {KeyCode: 0x35}, KeyBackspace: {KeyCode: 0x33},
+	KeyTab: {KeyCode: 0x30}, KeySpace: {KeyCode: 0x31}, KeyMinus: {KeyCode: 0x1B},
+	KeyEqual: {KeyCode: 0x18}, KeyLeftBracket: {KeyCode: 0x21}, KeyRightBracket: {KeyCode: 0x1E},
+	KeyBackslash: {KeyCode: 0x2A}, KeySemicolon: {KeyCode: 0x29}, KeyApostrophe: {KeyCode: 0x27},
+	KeyGraveAccent: {KeyCode: 0x32}, KeyComma: {KeyCode: 0x2B}, KeyPeriod: {KeyCode: 0x2F},
+	KeySlash: {KeyCode: 0x2C}, KeyCapsLock: {KeyCode: 0x39}, KeyNonUSBackslash: {KeyCode: 0x0A},
+
+	KeyF1: {KeyCode: 0x7A}, KeyF2: {KeyCode: 0x78}, KeyF3: {KeyCode: 0x63}, KeyF4: {KeyCode: 0x76},
+	KeyF5: {KeyCode: 0x60}, KeyF6: {KeyCode: 0x61}, KeyF7: {KeyCode: 0x62}, KeyF8: {KeyCode: 0x64},
+	KeyF9: {KeyCode: 0x65}, KeyF10: {KeyCode: 0x6D}, KeyF11: {KeyCode: 0x67}, KeyF12: {KeyCode: 0x6F},
+	KeyF13: {KeyCode: 0x69}, KeyF14: {KeyCode: 0x6B}, KeyF15: {KeyCode: 0x71}, KeyF16: {KeyCode: 0x6A},
+	KeyF17: {KeyCode: 0x40}, KeyF18: {KeyCode: 0x4F}, KeyF19: {KeyCode: 0x50}, KeyF20: {KeyCode: 0x5A},
+
+	KeyPrintScreen: {KeyCode: 0x69}, KeyScrollLock: {KeyCode: 0x6B}, KeyPause: {KeyCode: 0x71},
+	KeyInsert: {KeyCode: 0x72}, KeyHome: {KeyCode: 0x73}, KeyPageUp: {KeyCode: 0x74},
+	KeyDelete: {KeyCode: 0x75}, KeyEnd: {KeyCode: 0x77}, KeyPageDown: {KeyCode: 0x79},
+	KeyRight: {KeyCode: 0x7C}, KeyLeft: {KeyCode: 0x7B}, KeyDown: {KeyCode: 0x7D},
+	KeyUp: {KeyCode: 0x7E}, KeyMenu: {KeyCode: 0x6E},
+
+	KeyNumLock: {KeyCode: 0x47}, KeyKPDivide: {KeyCode: 0x4B}, KeyKPMultiply: {KeyCode: 0x43},
+	KeyKPSubtract: {KeyCode: 0x4E}, KeyKPAdd: {KeyCode: 0x45}, KeyKPEnter: {KeyCode: 0x4C},
+	KeyKPDecimal: {KeyCode: 0x41}, KeyKP0: {KeyCode: 0x52}, KeyKP1: {KeyCode: 0x53},
+	KeyKP2: {KeyCode: 0x54}, KeyKP3: {KeyCode: 0x55}, KeyKP4: {KeyCode: 0x56},
+	KeyKP5: {KeyCode: 0x57}, KeyKP6: {KeyCode: 0x58}, KeyKP7: {KeyCode: 0x59},
+	KeyKP8: {KeyCode: 0x5B}, KeyKP9: {KeyCode: 0x5C},
+
+	KeyLeftControl: {KeyCode: 0x3B}, KeyLeftShift: {KeyCode: 0x38},
+	KeyLeftAlt: {KeyCode: 0x3A}, KeyLeftSuper: {KeyCode: 0x37},
+	KeyRightControl: {KeyCode: 0x3E}, KeyRightShift: {KeyCode: 0x3C},
+	KeyRightAlt: {KeyCode: 0x3D}, KeyRightSuper: {KeyCode: 0x36},
+
+	KeyVolumeUp:       {KeyCode: 0, Media: true},
+	KeyVolumeDown:     {KeyCode: 1, Media: true},
+	KeyVolumeMute:     {KeyCode: 7, Media: true},
+	KeyMediaPlayPause: {KeyCode: 16, Media: true},
+	KeyMediaNext:      {KeyCode: 17, Media: true},
+	KeyMediaPrevious:  {KeyCode: 18, Media: true},
+}
+
+// Mac returns how the key is injected on macOS
+func (k KeyCode) Mac() (MacKey, bool) {
+	key, ok := macKeys[k]
+	return key, ok
+}
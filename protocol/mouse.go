@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A PacketTypeMouseMove packet carries the server monitor ID the pointer
+// is on and its x and y position in the monitor's pixels, as little endian
+// uint32s.
+
+// ErrInvalidMouseMove is returned for mouse move payloads that can't be
+// parsed
+var ErrInvalidMouseMove = errors.New("invalid mouse move packet")
+
+// EncodeMouseMove encodes a pointer position
+func EncodeMouseMove(monitorID uint32, x, y int) []byte {
+	buf := make([]byte, 12)
+	binary.LittleEndian.PutUint32(buf, monitorID)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(max(x, 0)))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(max(y, 0)))
+	return buf
+}
+
+// DecodeMouseMove decodes a pointer position
+func DecodeMouseMove(data []byte) (monitorID uint32, x, y int, err error) {
+	if len(data) != 12 {
+		return 0, 0, 0, ErrInvalidMouseMove
+	}
+	return binary.LittleEndian.Uint32(data), int(binary.LittleEndian.Uint32(data[4:])), int(binary.LittleEndian.Uint32(data[8:])), nil
+}
+
+// MouseButton identifies a mouse button
+type MouseButton uint8
+
+const (
+	MouseLeft MouseButton = iota
+	MouseRight
+	MouseMiddle
+	MouseBack    // First side button
+	MouseForward // Second side button
+)
+
+// A PacketTypeMouseButton packet carries a MouseButtonEvent: the position
+// like a mouse move, then the button and 1 for a press or 0 for a release.
+// A PacketTypeMouseWheel packet carries a MouseWheelEvent: the position
+// like a mouse move, then the horizontal and vertical scroll as little
+// endian int32s.
+
+// ErrInvalidMouseEvent is returned for mouse button and wheel payloads that
+// can't be parsed
+var ErrInvalidMouseEvent = errors.New("invalid mouse event")
+
+// MouseButtonEvent is a mouse button pressed or released at a position on
+// a server monitor, in the monitor's pixels
+type MouseButtonEvent struct {
+	MonitorID uint32
+	X, Y      int
+	Button    MouseButton
+	Down      bool
+}
+
+// EncodeMouseButton encodes a mouse button packet payload
+func EncodeMouseButton(event MouseButtonEvent) []byte {
+	buf := append(EncodeMouseMove(event.MonitorID, event.X, event.Y), byte(event.Button), 0)
+	if event.Down {
+		buf[13] = 1
+	}
+	return buf
+}
+
+// DecodeMouseButton decodes a mouse button packet payload
+func DecodeMouseButton(data []byte) (MouseButtonEvent, error) {
+	if len(data) != 14 {
+		return MouseButtonEvent{}, ErrInvalidMouseEvent
+	}
+	monitorID, x, y, _ := DecodeMouseMove(data[:12])
+	return MouseButtonEvent{MonitorID: monitorID, X: x, Y: y, Button: MouseButton(data[12]), Down: data[13] != 0}, nil
+}
+
+// MouseWheelEvent is a scroll at a position on a server monitor, in the
+// monitor's pixels. DX and DY are in 120ths of a wheel notch like on
+// Windows, positive to the right and up.
+type MouseWheelEvent struct {
+	MonitorID uint32
+	X, Y      int
+	DX, DY    int
+}
+
+// EncodeMouseWheel encodes a mouse wheel packet payload
+func EncodeMouseWheel(event MouseWheelEvent) []byte {
+	buf := binary.LittleEndian.AppendUint32(EncodeMouseMove(event.MonitorID, event.X, event.Y), uint32(int32(event.DX)))
+	return binary.LittleEndian.AppendUint32(buf, uint32(int32(event.DY)))
+}
+
+// DecodeMouseWheel decodes a mouse wheel packet payload
+func DecodeMouseWheel(data []byte) (MouseWheelEvent, error) {
+	if len(data) != 20 {
+		return MouseWheelEvent{}, ErrInvalidMouseEvent
+	}
+	monitorID, x, y, _ := DecodeMouseMove(data[:12])
+	return MouseWheelEvent{
+		MonitorID: monitorID,
+		X:         x,
+		Y:         y,
+		DX:        int(int32(binary.LittleEndian.Uint32(data[12:]))),
+		DY:        int(int32(binary.LittleEndian.Uint32(data[16:]))),
+	}, nil
+}
@@ -0,0 +1,19 @@
+package protocol
+
+import "testing"
+
+func TestMouseButtonRoundTrip(t *testing.T) {
+	event := MouseButtonEvent{MonitorID: 2, X: 1920, Y: 1080, Button: MouseRight, Down: true}
+	decoded, err := DecodeMouseButton(EncodeMouseButton(event))
+	if err != nil || decoded != event {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+}
+
+func TestMouseWheelRoundTrip(t *testing.T) {
+	event := MouseWheelEvent{MonitorID: 1, X: 10, Y: 20, DX: -120, DY: 360}
+	decoded, err := DecodeMouseWheel(EncodeMouseWheel(event))
+	if err != nil || decoded != event {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// StreamInput is the ID of the stream carrying client input on multi-stream
+// transports, video streams are identified by their server monitor ID
+const StreamInput = 0xFFFFFFFF
+
+// StreamCamera is the ID of the stream carrying webcam frames on
+// multi-stream transports, in either direction
+const StreamCamera = 0xFFFFFFFE
+
+// StreamTokenSize is the size of the token binding an extra per-monitor
+// connection to a session
+const StreamTokenSize = 16
+
+// ErrInvalidStreamPacket is returned for stream setup or attach payloads
+// that can't be parsed
+var ErrInvalidStreamPacket = errors.New("invalid stream packet")
+
+// EncodeStreamSetup encodes the server's reply to a parallel connections
+// request: the session token and the server monitors that get their own
+// connection
+func EncodeStreamSetup(token []byte, monitorIDs []uint32) []byte {
+	buf := make([]byte, len(token)+4*len(monitorIDs))
+	copy(buf, token)
+	for i, id := range monitorIDs {
+		binary.LittleEndian.PutUint32(buf[len(token)+4*i:], id)
+	}
+	return buf
+}
+
+// DecodeStreamSetup decodes the server's reply to a parallel connections request
+func DecodeStreamSetup(data []byte) (token []byte, monitorIDs []uint32, err error) {
+	if len(data) < StreamTokenSize || (len(data)-StreamTokenSize)%4 != 0 {
+		return nil, nil, ErrInvalidStreamPacket
+	}
+	for i := StreamTokenSize; i < len(data); i += 4 {
+		monitorIDs = append(monitorIDs, binary.LittleEndian.Uint32(data[i:i+4]))
+	}
+	return data[:StreamTokenSize], monitorIDs, nil
+}
+
+// EncodeStreamAttach encodes the packet a client sends on a new connection,
+// in place of its monitor configuration, to receive one monitor's video on it
+func EncodeStreamAttach(token []byte, monitorID uint32) []byte {
+	buf := make([]byte, len(token)+4)
+	copy(buf, token)
+	binary.LittleEndian.PutUint32(buf[len(token):], monitorID)
+	return buf
+}
+
+// DecodeStreamAttach decodes a stream attach payload
+func DecodeStreamAttach(data []byte) (token []byte, monitorID uint32, err error) {
+	if len(data) != StreamTokenSize+4 {
+		return nil, 0, ErrInvalidStreamPacket
+	}
+	return data[:StreamTokenSize], binary.LittleEndian.Uint32(data[StreamTokenSize:]), nil
+}
@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStreamSetupRoundTrip(t *testing.T) {
+	token := bytes.Repeat([]byte{7}, StreamTokenSize)
+	decodedToken, monitorIDs, err := DecodeStreamSetup(EncodeStreamSetup(token, []uint32{1, 2, 5}))
+	if err != nil {
+		t.Fatalf("Failed to decode stream setup: %v", err)
+	}
+	if !bytes.Equal(decodedToken, token) || len(monitorIDs) != 3 || monitorIDs[2] != 5 {
+		t.Fatalf("Unexpected stream setup %x %v", decodedToken, monitorIDs)
+	}
+
+	decodedToken, monitorID, err := DecodeStreamAttach(EncodeStreamAttach(token, 2))
+	if err != nil || !bytes.Equal(decodedToken, token) || monitorID != 2 {
+		t.Fatalf("Unexpected stream attach %x %d %v", decodedToken, monitorID, err)
+	}
+
+	if _, _, err := DecodeStreamAttach(token); err != ErrInvalidStreamPacket {
+		t.Fatalf("Expected ErrInvalidStreamPacket for a short payload, got %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package protocol
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"time"
 )
@@ -12,17 +14,55 @@ const (
 	// Protocol version
 	ProtocolVersion = 1
 
+	// HeaderSize is the size of the encoded packet header: type, timestamp and length
+	HeaderSize = 13
+
+	// MaxPayloadSize bounds the payload of a packet, above the largest
+	// frame, an uncompressed 8K one, so a peer can't make the other side
+	// allocate gigabytes with a made up length
+	MaxPayloadSize = 256 << 20
+
 	// Packet types
-	PacketTypeHandshake      = 0x01
-	PacketTypeVideoFrame     = 0x02
-	PacketTypeAudioFrame     = 0x03
-	PacketTypeMouseMove      = 0x04
-	PacketTypeMouseButton    = 0x05
-	PacketTypeKeyboard       = 0x06
-	PacketTypeMonitorConfig  = 0x07
-	PacketTypePing           = 0x08
-	PacketTypePong           = 0x09
-	PacketTypeQualityControl = 0x0A
+	PacketTypeHandshake       = 0x01
+	PacketTypeVideoFrame      = 0x02
+	PacketTypeAudioFrame      = 0x03
+	PacketTypeMouseMove       = 0x04
+	PacketTypeMouseButton     = 0x05
+	PacketTypeKeyboard        = 0x06
+	PacketTypeMonitorConfig   = 0x07
+	PacketTypePing            = 0x08
+	PacketTypePong            = 0x09
+	PacketTypeQualityControl  = 0x0A
+	PacketTypeKeyExchange     = 0x0B
+	PacketTypeAuthChallenge   = 0x0C
+	PacketTypeAuthResponse    = 0x0D
+	PacketTypeLockState       = 0x0E
+	PacketTypeUDPRequest      = 0x0F
+	PacketTypeUDPSetup        = 0x10
+	PacketTypeUDPLoss         = 0x11
+	PacketTypeClipboard       = 0x12
+	PacketTypeStreamRequest   = 0x13
+	PacketTypeStreamSetup     = 0x14
+	PacketTypeStreamAttach    = 0x15
+	PacketTypeFrameAck        = 0x16
+	PacketTypeBandwidthLimit  = 0x17
+	PacketTypeCodecs          = 0x18
+	PacketTypeCodecSelect     = 0x19
+	PacketTypeFrameUnchanged  = 0x1A
+	PacketTypeClientReport    = 0x1B
+	PacketTypeFrameRate       = 0x1C
+	PacketTypeColorProfile    = 0x1D
+	PacketTypeKeyframeRequest = 0x1E
+	PacketTypeCursorShape     = 0x1F
+	PacketTypeCursorPosition  = 0x20
+	PacketTypeServerError     = 0x21
+	PacketTypeCapabilities    = 0x22
+	PacketTypeCameraRequest   = 0x23
+	PacketTypeCameraFrame     = 0x24
+	PacketTypeMonitorDetach   = 0x25
+	PacketTypeMouseWheel      = 0x26
+	PacketTypeBulkState       = 0x27
+	PacketTypeBulkChunk       = 0x28
 )
 
 // Packet represents a basic protocol packet
@@ -35,18 +75,20 @@ type Packet struct {
 
 // EncodePacket writes a packet to the given writer
 func EncodePacket(w io.Writer, packet *Packet) error {
-	// Write packet type
-	if err := binary.Write(w, binary.LittleEndian, packet.Type); err != nil {
-		return err
-	}
+	// Write the header in a single call so wrapped writers (e.g. SecureConn)
+	// don't have to handle it in pieces
+	header := make([]byte, HeaderSize)
 
-	// Write timestamp
-	if err := binary.Write(w, binary.LittleEndian, packet.Timestamp); err != nil {
-		return err
-	}
+	// Packet type
+	header[0] = packet.Type
+
+	// Timestamp
+	binary.LittleEndian.PutUint64(header[1:9], uint64(packet.Timestamp))
+
+	// Payload length
+	binary.LittleEndian.PutUint32(header[9:13], packet.Length)
 
-	// Write payload length
-	if err := binary.Write(w, binary.LittleEndian, packet.Length); err != nil {
+	if _, err := w.Write(header); err != nil {
 		return err
 	}
 
@@ -60,8 +102,18 @@ func EncodePacket(w io.Writer, packet *Packet) error {
 	return nil
 }
 
+// ErrPacketTooLarge is returned for packets whose length is above the
+// limit, before their payload is read
+var ErrPacketTooLarge = errors.New("packet too large")
+
 // DecodePacket reads a packet from the given reader
 func DecodePacket(r io.Reader) (*Packet, error) {
+	return DecodePacketLimit(r, MaxPayloadSize)
+}
+
+// DecodePacketLimit reads a packet whose payload is at most maxPayload
+// bytes from the given reader
+func DecodePacketLimit(r io.Reader, maxPayload uint32) (*Packet, error) {
 	packet := &Packet{}
 
 	// Read packet type
@@ -79,6 +131,10 @@ func DecodePacket(r io.Reader) (*Packet, error) {
 		return nil, err
 	}
 
+	if packet.Length > maxPayload {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrPacketTooLarge, packet.Length, maxPayload)
+	}
+
 	// Read payload
 	if packet.Length > 0 {
 		packet.Payload = make([]byte, packet.Length)
@@ -108,6 +164,7 @@ type MonitorInfo struct {
 	PositionX uint32
 	PositionY uint32
 	Primary   bool
+	Window    bool // An application window the server shares rather than a whole monitor
 }
 
 // MonitorConfig represents the configuration of all monitors
@@ -139,12 +196,15 @@ func EncodeMonitorConfig(config *MonitorConfig) []byte {
 		binary.LittleEndian.PutUint32(buf[offset:offset+4], uint32(monitor.PositionY))
 		offset += 4
 
-		// Encode boolean as a byte
+		// Encode booleans as bytes
 		if monitor.Primary {
 			buf[offset] = 1
 		} else {
 			buf[offset] = 0
 		}
+		if monitor.Window {
+			buf[offset+1] = 1
+		}
 		offset += 4 // Using 4 bytes for alignment
 	}
 
@@ -186,10 +246,11 @@ func DecodeMonitorConfig(data []byte) (*MonitorConfig, error) {
 		monitor.PositionY = binary.LittleEndian.Uint32(data[offset : offset+4])
 		offset += 4
 
-		// Decode boolean from byte
+		// Decode booleans from bytes
 		monitor.Primary = data[offset] == 1
+		monitor.Window = data[offset+1] == 1
 		offset += 4 // Using 4 bytes for alignment
 	}
 
 	return config, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMonitorConfigRoundTrip(t *testing.T) {
+	config := &MonitorConfig{MonitorCount: 2, Monitors: []MonitorInfo{
+		{ID: 1, Width: 1920, Height: 1080, Primary: true},
+		{ID: 2, Width: 800, Height: 600, PositionX: 1920, PositionY: 40, Window: true},
+	}}
+	decoded, err := DecodeMonitorConfig(EncodeMonitorConfig(config))
+	if err != nil || decoded.MonitorCount != 2 {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+	for i, monitor := range config.Monitors {
+		if decoded.Monitors[i] != monitor {
+			t.Errorf("monitor %d decoded as %+v, want %+v", i, decoded.Monitors[i], monitor)
+		}
+	}
+}
+
+func TestServerErrorRoundTrip(t *testing.T) {
+	e := ServerError{Code: ErrorScreenRecordingPermission, Message: "allow screen recording"}
+	if decoded, err := DecodeServerError(EncodeServerError(e)); err != nil || decoded != e {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+	if _, err := DecodeServerError(nil); err == nil {
+		t.Fatal("empty payload decoded")
+	}
+}
+
+func TestQualityControlRoundTrip(t *testing.T) {
+	for _, qc := range []QualityControl{
+		{Quality: 80},
+		{Quality: 60, RateMode: 2, Bitrate: 8000},
+		{Quality: 100, Scales: []CaptureScale{{MonitorID: 0, Percent: 75}, {MonitorID: 2, Percent: 50}}},
+	} {
+		decoded, err := DecodeQualityControl(EncodeQualityControl(qc))
+		if err != nil || decoded.Quality != qc.Quality || decoded.RateMode != qc.RateMode ||
+			decoded.Bitrate != qc.Bitrate || !slices.Equal(decoded.Scales, qc.Scales) {
+			t.Errorf("%+v decoded as %+v, %v", qc, decoded, err)
+		}
+	}
+	if _, err := DecodeQualityControl([]byte{100, 0, 0, 0, 0, 0, 2, 1, 0, 0, 0}); err == nil {
+		t.Error("truncated capture scales decoded")
+	}
+}
+
+func TestCapabilitiesRoundTrip(t *testing.T) {
+	if decoded, err := DecodeCapabilities(EncodeCapabilities(CapabilityHDR)); err != nil || decoded != CapabilityHDR {
+		t.Fatalf("decoded %v, %v", decoded, err)
+	}
+	if _, err := DecodeCapabilities([]byte{1}); err == nil {
+		t.Fatal("short payload decoded")
+	}
+}
+
+func TestBulkStateRoundTrip(t *testing.T) {
+	state := BulkState{CapacityKbps: 20000, LimitKbps: 6000, Transfers: 2, Throttled: true, Progress: 45}
+	if decoded, err := DecodeBulkState(EncodeBulkState(state)); err != nil || decoded != state {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+	if _, err := DecodeBulkState([]byte{1, 2, 3}); err == nil {
+		t.Fatal("short payload decoded")
+	}
+}
+
+func TestBulkChunkRoundTrip(t *testing.T) {
+	chunk := BulkChunk{Type: PacketTypeClipboard, Total: 10, Offset: 4, Data: []byte("hello")}
+	decoded, err := DecodeBulkChunk(EncodeBulkChunk(chunk))
+	if err != nil || decoded.Type != chunk.Type || decoded.Total != chunk.Total ||
+		decoded.Offset != chunk.Offset || string(decoded.Data) != "hello" {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+
+	// Data past the end of the packet
+	chunk.Offset = 6
+	if _, err := DecodeBulkChunk(EncodeBulkChunk(chunk)); err == nil {
+		t.Fatal("chunk beyond its packet decoded")
+	}
+	if _, err := DecodeBulkChunk([]byte{1, 2, 3}); err == nil {
+		t.Fatal("short payload decoded")
+	}
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A client asks for a video quality in a PacketTypeQualityControl packet:
+// the quality (1-100) as a byte, optionally followed by a rate control mode
+// byte, see codec.RateMode, and a target bitrate in kbit/s as a little
+// endian uint32, 0 for one derived from the quality. Capture scales of
+// monitors may follow: a count byte, then per monitor its ID as a little
+// endian uint32, 0 for every monitor, and the scale in percent as a byte,
+// 0 for the server's. Servers that only know the quality read the first
+// byte.
+
+// QualityControl is a client's video quality request
+type QualityControl struct {
+	Quality  int
+	RateMode byte   // codec.RateMode, 0 for the server's
+	Bitrate  uint32 // Target in kbit/s, 0 for the server's
+	Scales   []CaptureScale
+}
+
+// CaptureScale is the size a monitor's frames are scaled to before encoding
+type CaptureScale struct {
+	MonitorID uint32 // Server monitor, 0 for every monitor
+	Percent   int    // Of the monitor's size, 0 for the server's scale
+}
+
+const (
+	// qualityControlSize is the size of a payload with rate control
+	qualityControlSize = 6
+
+	// captureScaleSize is the size of a capture scale in a payload
+	captureScaleSize = 5
+
+	// MaxCaptureScales is how many capture scales a payload holds at most
+	MaxCaptureScales = 255
+)
+
+// ErrInvalidQualityControl is returned for quality control payloads that
+// can't be parsed
+var ErrInvalidQualityControl = errors.New("invalid quality control packet")
+
+// EncodeQualityControl encodes a quality request, with its rate control and
+// capture scales if it has any
+func EncodeQualityControl(qc QualityControl) []byte {
+	quality := byte(min(max(qc.Quality, 0), 100))
+	if qc.RateMode == 0 && qc.Bitrate == 0 && len(qc.Scales) == 0 {
+		return []byte{quality}
+	}
+	buf := make([]byte, qualityControlSize)
+	buf[0] = quality
+	buf[1] = qc.RateMode
+	binary.LittleEndian.PutUint32(buf[2:], qc.Bitrate)
+	if len(qc.Scales) == 0 {
+		return buf
+	}
+
+	scales := qc.Scales[:min(len(qc.Scales), MaxCaptureScales)]
+	buf = append(buf, byte(len(scales)))
+	for _, scale := range scales {
+		buf = binary.LittleEndian.AppendUint32(buf, scale.MonitorID)
+		buf = append(buf, byte(min(max(scale.Percent, 0), 100)))
+	}
+	return buf
+}
+
+// DecodeQualityControl decodes a quality request with or without rate
+// control and capture scales
+func DecodeQualityControl(data []byte) (QualityControl, error) {
+	if len(data) == 1 {
+		return QualityControl{Quality: int(data[0])}, nil
+	}
+	if len(data) < qualityControlSize {
+		return QualityControl{}, ErrInvalidQualityControl
+	}
+	qc := QualityControl{
+		Quality:  int(data[0]),
+		RateMode: data[1],
+		Bitrate:  binary.LittleEndian.Uint32(data[2:]),
+	}
+	if len(data) == qualityControlSize {
+		return qc, nil
+	}
+
+	count := int(data[qualityControlSize])
+	scales := data[qualityControlSize+1:]
+	if len(scales) != count*captureScaleSize {
+		return QualityControl{}, ErrInvalidQualityControl
+	}
+	qc.Scales = make([]CaptureScale, count)
+	for i := range qc.Scales {
+		entry := scales[i*captureScaleSize:]
+		qc.Scales[i] = CaptureScale{
+			MonitorID: binary.LittleEndian.Uint32(entry),
+			Percent:   int(entry[4]),
+		}
+	}
+	return qc, nil
+}
@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The client reports how video arrives in a PacketTypeClientReport packet
+// every second: the frames it received and the frames it lost in transit
+// since connecting, as little endian uint32s. The server's adaptive
+// quality controller counts losses as congestion.
+
+// ClientReport is a client's video statistics
+type ClientReport struct {
+	FramesReceived uint32
+	FramesDropped  uint32
+}
+
+// ErrInvalidClientReport is returned for report payloads that can't be
+// parsed
+var ErrInvalidClientReport = errors.New("invalid client report packet")
+
+// EncodeClientReport encodes a client report
+func EncodeClientReport(report ClientReport) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, report.FramesReceived)
+	binary.LittleEndian.PutUint32(buf[4:], report.FramesDropped)
+	return buf
+}
+
+// DecodeClientReport decodes a client report
+func DecodeClientReport(data []byte) (ClientReport, error) {
+	if len(data) < 8 {
+		return ClientReport{}, ErrInvalidClientReport
+	}
+	return ClientReport{
+		FramesReceived: binary.LittleEndian.Uint32(data),
+		FramesDropped:  binary.LittleEndian.Uint32(data[4:]),
+	}, nil
+}
@@ -0,0 +1,48 @@
+package protocol
+
+import "errors"
+
+// Servers that can't stream tell clients why in a PacketTypeServerError
+// packet: an error code byte followed by a message for the user, UTF-8,
+// saying what to do about it.
+
+// ErrorCode identifies a problem of the server
+type ErrorCode byte
+
+const (
+	// ErrorScreenRecordingPermission is sent when the OS doesn't allow the
+	// server to record the screen, so frames are black
+	ErrorScreenRecordingPermission ErrorCode = 1
+	// ErrorCameraUnavailable is sent when a client asks for the server's
+	// camera and there is none to share
+	ErrorCameraUnavailable ErrorCode = 2
+	// ErrorVirtualCameraUnavailable is sent when a client sends its camera
+	// and the server can't show it on a virtual camera
+	ErrorVirtualCameraUnavailable ErrorCode = 3
+	// ErrorAccessibilityPermission is sent to a client whose input the OS
+	// doesn't allow the server to inject
+	ErrorAccessibilityPermission ErrorCode = 4
+)
+
+// ServerError is a problem of the server clients are told about
+type ServerError struct {
+	Code    ErrorCode
+	Message string
+}
+
+// ErrInvalidServerError is returned for server error payloads that can't
+// be parsed
+var ErrInvalidServerError = errors.New("invalid server error packet")
+
+// EncodeServerError encodes a server error
+func EncodeServerError(e ServerError) []byte {
+	return append([]byte{byte(e.Code)}, e.Message...)
+}
+
+// DecodeServerError decodes a server error payload
+func DecodeServerError(data []byte) (ServerError, error) {
+	if len(data) < 1 {
+		return ServerError{}, ErrInvalidServerError
+	}
+	return ServerError{Code: ErrorCode(data[0]), Message: string(data[1:])}, nil
+}
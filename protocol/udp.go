@@ -0,0 +1,228 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Datagram kinds used on the UDP video channel
+const (
+	DatagramHello    = 0x01 // Client -> server, carries the session token
+	DatagramFragment = 0x02 // Server -> client, carries part of a video frame
+)
+
+const (
+	// MaxDatagramPayload keeps datagrams below common path MTUs (incl. VPNs)
+	MaxDatagramPayload = 1200
+
+	// datagramHeaderSize is kind(1) + seq(4) + monitor(4) + index(2) + count(2)
+	datagramHeaderSize = 13
+
+	// UDPTokenSize is the size of the token binding a UDP flow to a session
+	UDPTokenSize = 16
+
+	// maxPendingFrames bounds the number of partially received frames
+	maxPendingFrames = 16
+
+	// pendingFrameTimeout drops partial frames that stopped receiving fragments
+	pendingFrameTimeout = 500 * time.Millisecond
+)
+
+// ErrInvalidDatagram is returned for datagrams that can't be parsed
+var ErrInvalidDatagram = errors.New("invalid datagram")
+
+// Datagram is a parsed UDP datagram
+type Datagram struct {
+	Kind      byte
+	Sequence  uint32 // Frame sequence number, increasing per session
+	MonitorID uint32
+	Index     uint16 // Fragment index within the frame
+	Count     uint16 // Number of fragments in the frame
+	Payload   []byte
+}
+
+// EncodeUDPSetup encodes the server's reply to a UDP request
+func EncodeUDPSetup(port uint16, token []byte) []byte {
+	buf := make([]byte, 2+len(token))
+	binary.LittleEndian.PutUint16(buf[0:2], port)
+	copy(buf[2:], token)
+	return buf
+}
+
+// DecodeUDPSetup decodes the server's reply to a UDP request
+func DecodeUDPSetup(data []byte) (port uint16, token []byte, err error) {
+	if len(data) != 2+UDPTokenSize {
+		return 0, nil, ErrInvalidDatagram
+	}
+	return binary.LittleEndian.Uint16(data[0:2]), data[2:], nil
+}
+
+// EncodeHelloDatagram builds the datagram a client sends to register its
+// UDP address with the server
+func EncodeHelloDatagram(token []byte) []byte {
+	return append([]byte{DatagramHello}, token...)
+}
+
+// FragmentFrame splits a video frame payload into datagrams. Fragments are
+// never retransmitted, a frame with a lost fragment is dropped by the receiver.
+func FragmentFrame(sequence, monitorID uint32, payload []byte) [][]byte {
+	count := (len(payload) + MaxDatagramPayload - 1) / MaxDatagramPayload
+	if count == 0 {
+		count = 1
+	}
+
+	datagrams := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		start := i * MaxDatagramPayload
+		end := start + MaxDatagramPayload
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		datagram := make([]byte, datagramHeaderSize+end-start)
+		datagram[0] = DatagramFragment
+		binary.LittleEndian.PutUint32(datagram[1:5], sequence)
+		binary.LittleEndian.PutUint32(datagram[5:9], monitorID)
+		binary.LittleEndian.PutUint16(datagram[9:11], uint16(i))
+		binary.LittleEndian.PutUint16(datagram[11:13], uint16(count))
+		copy(datagram[datagramHeaderSize:], payload[start:end])
+		datagrams = append(datagrams, datagram)
+	}
+
+	return datagrams
+}
+
+// DecodeDatagram parses a datagram received on the UDP channel
+func DecodeDatagram(data []byte) (*Datagram, error) {
+	if len(data) < 1 {
+		return nil, ErrInvalidDatagram
+	}
+
+	switch data[0] {
+	case DatagramHello:
+		if len(data) != 1+UDPTokenSize {
+			return nil, ErrInvalidDatagram
+		}
+		return &Datagram{Kind: DatagramHello, Payload: data[1:]}, nil
+
+	case DatagramFragment:
+		if len(data) < datagramHeaderSize {
+			return nil, ErrInvalidDatagram
+		}
+		d := &Datagram{
+			Kind:      DatagramFragment,
+			Sequence:  binary.LittleEndian.Uint32(data[1:5]),
+			MonitorID: binary.LittleEndian.Uint32(data[5:9]),
+			Index:     binary.LittleEndian.Uint16(data[9:11]),
+			Count:     binary.LittleEndian.Uint16(data[11:13]),
+			Payload:   data[datagramHeaderSize:],
+		}
+		if d.Count == 0 || d.Index >= d.Count {
+			return nil, ErrInvalidDatagram
+		}
+		return d, nil
+	}
+
+	return nil, ErrInvalidDatagram
+}
+
+// pendingFrame is a frame whose fragments are still arriving
+type pendingFrame struct {
+	monitorID uint32
+	fragments [][]byte
+	received  int
+	started   time.Time
+}
+
+// Reassembler rebuilds video frames from fragments. Frames are delivered
+// only when complete; frames overtaken by a newer frame of the same monitor
+// are dropped instead of waiting for lost fragments.
+type Reassembler struct {
+	pending   map[uint32]*pendingFrame
+	delivered map[uint32]uint32 // Monitor ID -> sequence of last delivered frame
+	lastSeq   uint32            // Highest sequence seen so far
+	seenAny   bool
+	Dropped   int                    // Number of frames dropped because of loss
+	OnDrop    func(monitorID uint32) // Called for every dropped frame, if set
+}
+
+// NewReassembler creates an empty reassembler
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		pending:   make(map[uint32]*pendingFrame),
+		delivered: make(map[uint32]uint32),
+	}
+}
+
+// Add adds a fragment and returns the complete frame payload if the frame
+// is now complete, or nil otherwise
+func (r *Reassembler) Add(d *Datagram) []byte {
+	if !r.seenAny || d.Sequence > r.lastSeq {
+		r.lastSeq = d.Sequence
+		r.seenAny = true
+	}
+
+	// Ignore fragments of frames older than what was already shown
+	if last, ok := r.delivered[d.MonitorID]; ok && d.Sequence <= last {
+		return nil
+	}
+
+	frame, ok := r.pending[d.Sequence]
+	if !ok {
+		r.expire()
+		frame = &pendingFrame{
+			monitorID: d.MonitorID,
+			fragments: make([][]byte, d.Count),
+			started:   time.Now(),
+		}
+		r.pending[d.Sequence] = frame
+	}
+	if int(d.Index) >= len(frame.fragments) || frame.fragments[d.Index] != nil {
+		return nil
+	}
+
+	frame.fragments[d.Index] = append([]byte(nil), d.Payload...)
+	frame.received++
+	if frame.received < len(frame.fragments) {
+		return nil
+	}
+
+	// Frame complete: older partial frames of this monitor can never be shown
+	delete(r.pending, d.Sequence)
+	for seq, other := range r.pending {
+		if other.monitorID == d.MonitorID && seq < d.Sequence {
+			delete(r.pending, seq)
+			r.drop(other.monitorID)
+		}
+	}
+	r.delivered[d.MonitorID] = d.Sequence
+
+	size := 0
+	for _, fragment := range frame.fragments {
+		size += len(fragment)
+	}
+	payload := make([]byte, 0, size)
+	for _, fragment := range frame.fragments {
+		payload = append(payload, fragment...)
+	}
+	return payload
+}
+
+// expire drops partial frames that timed out or exceed the pending limit
+func (r *Reassembler) expire() {
+	for seq, frame := range r.pending {
+		if time.Since(frame.started) > pendingFrameTimeout || r.lastSeq-seq > maxPendingFrames {
+			delete(r.pending, seq)
+			r.drop(frame.monitorID)
+		}
+	}
+}
+
+// drop counts a frame of a monitor as dropped
+func (r *Reassembler) drop(monitorID uint32) {
+	r.Dropped++
+	if r.OnDrop != nil {
+		r.OnDrop(monitorID)
+	}
+}
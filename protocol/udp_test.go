@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReassembleOutOfOrder(t *testing.T) {
+	payload := bytes.Repeat([]byte("frame"), MaxDatagramPayload)
+	datagrams := FragmentFrame(1, 2, payload)
+	if len(datagrams) < 3 {
+		t.Fatalf("Expected several fragments, got %d", len(datagrams))
+	}
+
+	r := NewReassembler()
+	var frame []byte
+	for i := len(datagrams) - 1; i >= 0; i-- {
+		d, err := DecodeDatagram(datagrams[i])
+		if err != nil {
+			t.Fatalf("Failed to decode datagram: %v", err)
+		}
+		frame = r.Add(d)
+	}
+
+	if !bytes.Equal(frame, payload) {
+		t.Fatalf("Reassembled frame does not match")
+	}
+}
+
+func TestReassembleDropsOvertakenFrame(t *testing.T) {
+	r := NewReassembler()
+	var droppedMonitors []uint32
+	r.OnDrop = func(monitorID uint32) { droppedMonitors = append(droppedMonitors, monitorID) }
+
+	// Frame 1 loses its last fragment
+	lossy := FragmentFrame(1, 1, bytes.Repeat([]byte{1}, MaxDatagramPayload*2))
+	d, _ := DecodeDatagram(lossy[0])
+	r.Add(d)
+
+	// Frame 2 of the same monitor arrives complete and overtakes it
+	for _, datagram := range FragmentFrame(2, 1, []byte{2}) {
+		d, _ := DecodeDatagram(datagram)
+		if frame := r.Add(d); !bytes.Equal(frame, []byte{2}) {
+			t.Fatalf("Expected frame 2 to be delivered")
+		}
+	}
+
+	// The late fragment of frame 1 must not resurrect it
+	d, _ = DecodeDatagram(lossy[1])
+	if frame := r.Add(d); frame != nil {
+		t.Fatalf("Overtaken frame was delivered")
+	}
+	if r.Dropped != 1 {
+		t.Fatalf("Expected 1 dropped frame, got %d", r.Dropped)
+	}
+	if len(droppedMonitors) != 1 || droppedMonitors[0] != 1 {
+		t.Fatalf("Expected a dropped frame of monitor 1, got %v", droppedMonitors)
+	}
+}
@@ -0,0 +1,197 @@
+package recording
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// ExportOptions selects which stills Export extracts from a recording
+type ExportOptions struct {
+	Monitor   uint32          // Server monitor ID to export, 0 for all monitors
+	Every     time.Duration   // Interval between stills, used when At is empty
+	At        []time.Duration // Offsets from the start of the recording
+	OutputDir string
+	Format    string // "jpg" or "png", frames in that format are written as received
+}
+
+// exportState tracks the frame currently shown for one monitor
+type exportState struct {
+	frame   []byte
+	next    int // Index of the next target in At, or of the next interval
+	written int
+}
+
+// Export extracts the frame shown at each requested time and writes it to
+// OutputDir as mon<ID>_<index>.<format>. It returns the number of stills
+// written.
+func Export(path string, opts ExportOptions) (int, error) {
+	if len(opts.At) == 0 && opts.Every <= 0 {
+		return 0, fmt.Errorf("either an interval or timestamps must be given")
+	}
+	if opts.Format != "jpg" && opts.Format != "png" {
+		return 0, fmt.Errorf("unsupported format %q", opts.Format)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return 0, err
+	}
+
+	at := append([]time.Duration(nil), opts.At...)
+	sort.Slice(at, func(i, j int) bool { return at[i] < at[j] })
+
+	// target returns the offset of a monitor's n-th still
+	target := func(n int) (time.Duration, bool) {
+		if len(at) > 0 {
+			if n >= len(at) {
+				return 0, false
+			}
+			return at[n], true
+		}
+		return time.Duration(n) * opts.Every, true
+	}
+
+	monitors := make(map[uint32]*exportState)
+	total := 0
+
+	// codecs tracks the codec the server selected for each monitor
+	codecs := make(map[uint32]codec.ID)
+
+	// flush writes the current frame of each monitor for every target up to offset
+	flush := func(offset time.Duration, inclusive bool) error {
+		for id, state := range monitors {
+			for {
+				t, ok := target(state.next)
+				if !ok || t > offset || (t == offset && !inclusive) {
+					break
+				}
+				if err := writeStill(opts, id, state.written, state.frame); err != nil {
+					return err
+				}
+				state.next++
+				state.written++
+				total++
+			}
+		}
+		return nil
+	}
+
+	var start, offset time.Duration
+	first := true
+	for {
+		packet, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+
+		if first {
+			start = time.Duration(packet.Timestamp)
+			first = false
+		}
+		offset = time.Duration(packet.Timestamp) - start
+
+		// Frames shown until now are the ones visible at earlier targets
+		if err := flush(offset, false); err != nil {
+			return total, err
+		}
+
+		if packet.Type == protocol.PacketTypeCodecSelect {
+			if id, c, err := protocol.DecodeCodecSelect(packet.Payload); err == nil {
+				codecs[id] = codec.ID(c)
+			}
+			continue
+		}
+		if packet.Type != protocol.PacketTypeVideoFrame || len(packet.Payload) < 4 {
+			continue
+		}
+		id := protocol.BytesToUint32(packet.Payload[0:4])
+		if opts.Monitor != 0 && id != opts.Monitor {
+			continue
+		}
+
+		// Frames of video codecs depend on earlier frames and can't be
+		// exported as stills. Not every codec's frames can be told apart
+		// from JPEG, but JPEG and PNG frames may arrive whatever codec was
+		// selected.
+		frame := packet.Payload[4:]
+		still := codec.IsJPEG(frame) || codec.IsPNG(frame)
+		if codec.IsVideo(frame) || codecs[id] != codec.JPEG && !still {
+			continue
+		}
+
+		state, ok := monitors[id]
+		if !ok {
+			state = &exportState{}
+			monitors[id] = state
+		}
+		state.frame = frame
+
+		// Targets before a monitor's first frame have nothing to show
+		for {
+			t, ok := target(state.next)
+			if !ok || t >= offset {
+				break
+			}
+			state.next++
+		}
+	}
+
+	// The last frames stay visible until the recording ends
+	return total, flush(offset, true)
+}
+
+// writeStill writes one exported frame
+func writeStill(opts ExportOptions, monitorID uint32, index int, frame []byte) error {
+	path := filepath.Join(opts.OutputDir, fmt.Sprintf("mon%d_%06d.%s", monitorID, index, opts.Format))
+
+	// Frames already in the requested format are written as received
+	isPNG := codec.IsPNG(frame)
+	if isPNG == (opts.Format == "png") {
+		return os.WriteFile(path, frame, 0644)
+	}
+
+	var img image.Image
+	var err error
+	if isPNG {
+		img, err = png.Decode(bytes.NewReader(frame))
+	} else {
+		img, err = jpeg.Decode(bytes.NewReader(frame))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode frame for monitor %d: %w", monitorID, err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if isPNG {
+		err = jpeg.Encode(file, img, nil)
+	} else {
+		err = png.Encode(file, img)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,131 @@
+package recording
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// writeTestRecording writes video frames for monitor 1 at the given offsets,
+// each frame's payload being its index
+func writeTestRecording(t *testing.T, path string, offsets []time.Duration) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	file.WriteString(Magic)
+	file.Write([]byte{Version})
+	for i, offset := range offsets {
+		payload := append(protocol.Uint32ToBytes(1), byte(i))
+		packet := protocol.NewPacket(protocol.PacketTypeVideoFrame, payload)
+		packet.Timestamp = int64(time.Hour + offset)
+		if err := protocol.EncodePacket(file, packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExportEvery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.urdp")
+	writeTestRecording(t, path, []time.Duration{0, 2 * time.Second, 6 * time.Second, 11 * time.Second})
+
+	out := filepath.Join(dir, "frames")
+	n, err := Export(path, ExportOptions{Every: 5 * time.Second, OutputDir: out, Format: "jpg"})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	// Frames shown at 0s, 5s and 10s
+	want := []byte{0, 1, 2}
+	if n != len(want) {
+		t.Fatalf("exported %d stills, want %d", n, len(want))
+	}
+	for i, frame := range want {
+		data, err := os.ReadFile(filepath.Join(out, fmt.Sprintf("mon1_%06d.jpg", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 1 || data[0] != frame {
+			t.Errorf("still %d = %v, want frame %d", i, data, frame)
+		}
+	}
+}
+
+func TestExportAt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.urdp")
+	writeTestRecording(t, path, []time.Duration{0, 2 * time.Second, 6 * time.Second, 8 * time.Second})
+
+	out := filepath.Join(dir, "frames")
+	n, err := Export(path, ExportOptions{
+		At:        []time.Duration{7 * time.Second, time.Second, time.Minute},
+		OutputDir: out,
+		Format:    "jpg",
+	})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	// The target past the end of the recording is skipped
+	if n != 2 {
+		t.Fatalf("exported %d stills, want 2", n)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "mon1_000001.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 2 {
+		t.Errorf("still at 7s = frame %d, want 2", data[0])
+	}
+}
+
+func TestExportSkipsVideo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.urdp")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(Magic)
+	file.Write([]byte{Version})
+
+	// VP8 frames can't be told from JPEG by their content, only by the
+	// codec the server selected. JPEG frames are exported either way.
+	jpegFrame := []byte{0xFF, 0xD8, 0xFF}
+	packets := []*protocol.Packet{
+		protocol.NewPacket(protocol.PacketTypeCodecSelect, protocol.EncodeCodecSelect(1, byte(codec.VP8))),
+		protocol.NewPacket(protocol.PacketTypeVideoFrame, append(protocol.Uint32ToBytes(1), 0x50, 0x42, 0x00)),
+		protocol.NewPacket(protocol.PacketTypeVideoFrame, append(protocol.Uint32ToBytes(1), jpegFrame...)),
+	}
+	for i, packet := range packets {
+		packet.Timestamp = int64(time.Hour + time.Duration(i)*time.Second)
+		if err := protocol.EncodePacket(file, packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+
+	out := filepath.Join(dir, "frames")
+	n, err := Export(path, ExportOptions{At: []time.Duration{time.Second, 2 * time.Second}, OutputDir: out, Format: "jpg"})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("exported %d stills, want only the JPEG frame", n)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "mon1_000000.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(jpegFrame) {
+		t.Errorf("still = %v, want the JPEG frame", data)
+	}
+}
@@ -0,0 +1,126 @@
+// Package recording reads and writes UltraRDP session recordings (.urdp).
+// A recording is a short file header followed by the packets received from
+// the server, encoded exactly as on the wire, so recordings are read back
+// with the regular protocol decoder.
+package recording
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// Recording file format
+const (
+	Magic   = "URDP"
+	Version = 1
+)
+
+// ErrNotRecording is returned when opening a file that isn't a recording
+var ErrNotRecording = errors.New("not an UltraRDP recording")
+
+// Writer appends packets to a recording file
+type Writer struct {
+	file   *os.File
+	buf    *bufio.Writer
+	mutex  sync.Mutex
+	closed bool
+}
+
+// Create creates a recording file, truncating it if it already exists
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{file: file, buf: bufio.NewWriter(file)}
+	if _, err := w.buf.WriteString(Magic); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := w.buf.WriteByte(Version); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// WritePacket appends a packet. The packet's timestamp is replaced by the
+// local receive time so playback timing doesn't depend on the server clock.
+func (w *Writer) WritePacket(packet *protocol.Packet) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	recorded := *packet
+	recorded.Timestamp = time.Now().UnixNano()
+	return protocol.EncodePacket(w.buf, &recorded)
+}
+
+// Close flushes buffered packets and closes the file
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Reader reads packets back from a recording file
+type Reader struct {
+	file *os.File
+	buf  *bufio.Reader
+}
+
+// Open opens a recording file and checks its header
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{file: file, buf: bufio.NewReader(file)}
+	header := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(r.buf, header); err != nil || string(header[:len(Magic)]) != Magic {
+		file.Close()
+		return nil, ErrNotRecording
+	}
+	if header[len(Magic)] != Version {
+		file.Close()
+		return nil, fmt.Errorf("unsupported recording version %d", header[len(Magic)])
+	}
+	return r, nil
+}
+
+// Next returns the next packet, or io.EOF at the end of the recording. A
+// packet cut short by an interrupted recording is also reported as io.EOF.
+func (r *Reader) Next() (*protocol.Packet, error) {
+	packet, err := protocol.DecodePacket(r.buf)
+	if err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	}
+	return packet, err
+}
+
+// Close closes the recording file
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,110 @@
+package recording
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// IsVideoPath reports whether a recording path names a video file, .mp4 or
+// .mkv, which CreateVideo writes, rather than a .urdp recording
+func IsVideoPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".mkv":
+		return true
+	}
+	return false
+}
+
+// Video encodes frames to an H.264 video in an MP4 or Matroska file
+// through ffmpeg. Frames are timed by when they are written, so the video
+// plays back at the pace the session was shown.
+type Video struct {
+	cmd    *exec.Cmd
+	input  io.WriteCloser
+	buf    *bufio.Writer
+	mutex  sync.Mutex
+	closed bool
+}
+
+// CreateVideo starts encoding a video to path, truncating it if it already
+// exists. The container follows the extension, see IsVideoPath. The video
+// is width by height, rounded down to even sizes, and frames of other
+// sizes are scaled to fit it. MP4 files are fragmented, so a session that
+// ends without Close still plays up to its last keyframe.
+func CreateVideo(path string, width, height int) (*Video, error) {
+	width, height = max(width&^1, 2), max(height&^1, 2)
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("video recordings need ffmpeg: %w", err)
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y",
+		"-use_wallclock_as_timestamps", "1", "-f", "image2pipe", "-c:v", "pam", "-i", "-",
+		"-vf", fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2", width, height, width, height),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p", "-vsync", "vfr"}
+	if strings.ToLower(filepath.Ext(path)) == ".mp4" {
+		args = append(args, "-movflags", "+frag_keyframe+empty_moov+default_base_moof")
+	}
+	cmd := exec.Command(ffmpeg, append(args, path)...)
+	cmd.Stderr = os.Stderr
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	return &Video{cmd: cmd, input: input, buf: bufio.NewWriterSize(input, 1<<20)}, nil
+}
+
+// WriteFrame appends a frame to the video. It blocks while ffmpeg catches
+// up.
+func (v *Video) WriteFrame(frame *image.RGBA) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.closed {
+		return os.ErrClosed
+	}
+	if err := writePAM(v.buf, frame); err != nil {
+		return err
+	}
+	return v.buf.Flush()
+}
+
+// Close finishes the video and waits for ffmpeg to write it
+func (v *Video) Close() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.closed {
+		return nil
+	}
+	v.closed = true
+
+	v.buf.Flush()
+	v.input.Close()
+	return v.cmd.Wait()
+}
+
+// writePAM writes a frame as a PAM image, which ffmpeg reads from a pipe
+// with its alpha channel and without compression
+func writePAM(w io.Writer, frame *image.RGBA) error {
+	size := frame.Rect.Size()
+	if _, err := fmt.Fprintf(w, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", size.X, size.Y); err != nil {
+		return err
+	}
+	for y := 0; y < size.Y; y++ {
+		if _, err := w.Write(frame.Pix[y*frame.Stride : y*frame.Stride+size.X*4]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
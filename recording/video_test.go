@@ -0,0 +1,36 @@
+package recording
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestIsVideoPath(t *testing.T) {
+	for path, want := range map[string]bool{
+		"session.mp4":  true,
+		"session.MKV":  true,
+		"session.urdp": false,
+		"session":      false,
+	} {
+		if got := IsVideoPath(path); got != want {
+			t.Errorf("IsVideoPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestWritePAM(t *testing.T) {
+	// A 2x1 region of a wider frame, rows are written without the rest
+	// of the stride
+	frame := image.NewRGBA(image.Rect(0, 0, 3, 2)).SubImage(image.Rect(1, 1, 3, 2)).(*image.RGBA)
+	copy(frame.Pix, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	var buf bytes.Buffer
+	if err := writePAM(&buf, frame); err != nil {
+		t.Fatal(err)
+	}
+	want := "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n\x01\x02\x03\x04\x05\x06\x07\x08"
+	if buf.String() != want {
+		t.Errorf("writePAM wrote %q, want %q", buf.String(), want)
+	}
+}
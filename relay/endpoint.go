@@ -0,0 +1,209 @@
+package relay
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/transport"
+)
+
+// Addr is the address of a connection made through a relay
+type Addr struct {
+	Code string // Session code
+	Peer string // Address the relay saw the peer connect from
+}
+
+func (a Addr) Network() string { return "relay" }
+func (a Addr) String() string  { return a.Peer + " via relay " + a.Code }
+
+// relayedConn is a connection through the relay with the peer's address
+type relayedConn struct {
+	net.Conn
+	remote Addr
+}
+
+func (c *relayedConn) RemoteAddr() net.Addr { return c.remote }
+
+// listener accepts the clients the relay forwards to a registered session
+type listener struct {
+	relayAddress string
+	code         string
+	token        string
+	control      net.Conn
+	tlsConfig    *tls.Config
+
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen registers a session with the relay and returns a listener
+// accepting the clients that join it. With a TLS config, accepted
+// connections are served over TLS end to end.
+func Listen(relayAddress, code string, tlsConfig *tls.Config) (net.Listener, error) {
+	conn, reply, err := open(relayAddress, "host "+code)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &listener{
+		relayAddress: relayAddress,
+		code:         code,
+		token:        reply,
+		control:      conn,
+		tlsConfig:    tlsConfig,
+		conns:        make(chan net.Conn),
+		closed:       make(chan struct{}),
+	}
+	go l.readControl()
+	return l, nil
+}
+
+// readControl answers the relay's connect requests until the control
+// connection closes
+func (l *listener) readControl() {
+	defer l.Close()
+
+	reader := bufio.NewReader(l.control)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "CONNECT" {
+			go l.acceptPeer(fields[1])
+		}
+	}
+}
+
+// acceptPeer opens the connection the relay pairs with a joining client
+func (l *listener) acceptPeer(peer string) {
+	conn, _, err := open(l.relayAddress, "accept "+l.code+" "+l.token)
+	if err != nil {
+		log.Printf("Failed to accept %s through relay: %v", peer, err)
+		return
+	}
+
+	var accepted net.Conn = &relayedConn{Conn: conn, remote: Addr{Code: l.code, Peer: peer}}
+	if l.tlsConfig != nil {
+		accepted = tls.Server(accepted, l.tlsConfig)
+	}
+
+	select {
+	case l.conns <- accepted:
+	case <-l.closed:
+		accepted.Close()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.control.Close()
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return Addr{Code: l.code, Peer: l.relayAddress}
+}
+
+// ErrRelayTLSNeedsCA is returned by Dial for TLS configs that would accept
+// any publicly trusted certificate: without a host name to check, whoever
+// runs the relay or registers the code first could present one
+var ErrRelayTLSNeedsCA = errors.New("TLS through a relay needs the server's certificate or CA (-ca), or a server name to verify")
+
+// Dial joins a session on the relay. With a TLS config the server is
+// verified end to end. Behind a relay the server has no host name of its
+// own, so unless the config names one, the certificate chain is verified
+// without checking a name, against the config's roots which must be set.
+func Dial(relayAddress, code string, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig != nil && tlsConfig.ServerName == "" && tlsConfig.RootCAs == nil {
+		return nil, ErrRelayTLSNeedsCA
+	}
+	conn, _, err := open(relayAddress, "join "+code)
+	if err != nil {
+		return nil, err
+	}
+
+	var joined net.Conn = &relayedConn{Conn: conn, remote: Addr{Code: code, Peer: relayAddress}}
+	if tlsConfig == nil {
+		return joined, nil
+	}
+
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = verifyChain(tlsConfig.RootCAs)
+	}
+	tlsConn := tls.Client(joined, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// verifyChain verifies the peer certificate against roots without checking
+// the host name
+func verifyChain(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
+
+// open connects to the relay, sends a header and returns the connection
+// and the text after "OK" in the reply
+func open(relayAddress, header string) (net.Conn, string, error) {
+	conn, err := transport.DialFastest("tcp", relayAddress)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := fmt.Fprintf(conn, "%s %s\n", headerPrefix, header); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+
+	reply, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("no reply from relay: %w", err)
+	}
+	if reason, ok := strings.CutPrefix(reply, "ERR "); ok {
+		conn.Close()
+		return nil, "", fmt.Errorf("relay refused: %s", reason)
+	}
+	if reply != "OK" && !strings.HasPrefix(reply, "OK ") {
+		conn.Close()
+		return nil, "", fmt.Errorf("unexpected relay reply %q", reply)
+	}
+	return conn, strings.TrimSpace(strings.TrimPrefix(reply, "OK")), nil
+}
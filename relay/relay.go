@@ -0,0 +1,354 @@
+// Package relay connects servers and clients that can't reach each other
+// directly. Both sides dial out to a relay and name a session code, the
+// relay pairs them and copies bytes between the two connections. The relay
+// never looks inside the stream, so pre-shared key encryption and TLS stay
+// end to end.
+//
+// Every connection to the relay starts with a single header line:
+//
+//	ULTRARDP-RELAY 1 host <code>            a server registering a session
+//	ULTRARDP-RELAY 1 join <code>            a client joining a session
+//	ULTRARDP-RELAY 1 accept <code> <token>  a server answering a join
+//
+// The relay replies with "OK ..." or "ERR <reason>". The host connection
+// stays open as a control channel: the relay writes "CONNECT" on it for
+// every joining client, and the server dials a new accept connection which
+// the relay pairs with the waiting client.
+package relay
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// headerPrefix starts every header line
+	headerPrefix = "ULTRARDP-RELAY 1"
+
+	// maxLineLength bounds header and reply lines
+	maxLineLength = 256
+
+	// headerTimeout is how long a new connection may take to send its header
+	headerTimeout = 10 * time.Second
+
+	// joinTimeout is how long a client waits for the server to accept it
+	joinTimeout = 15 * time.Second
+)
+
+// Relay pairs server and client connections by session code
+type Relay struct {
+	address  string
+	listener net.Listener
+
+	mutex    sync.Mutex
+	sessions map[string]*session
+	piped    map[net.Conn]bool // Connections being relayed
+	stopped  bool
+}
+
+// session is a registered server
+type session struct {
+	control      net.Conn
+	token        string // Secret the server proves accept connections with
+	controlMutex sync.Mutex
+	waiting      []*waiter // Joined clients waiting for an accept connection
+}
+
+// waiter is a joined client waiting for the server's accept connection.
+// Both fields are guarded by the relay's mutex.
+type waiter struct {
+	paired chan net.Conn
+	gone   bool // The client stopped waiting, accept closes the connection
+}
+
+// NewRelay creates a relay listening on address once started
+func NewRelay(address string) *Relay {
+	return &Relay{
+		address:  address,
+		sessions: make(map[string]*session),
+		piped:    make(map[net.Conn]bool),
+	}
+}
+
+// Start accepts connections until the relay is stopped
+func (r *Relay) Start() error {
+	listener, err := net.Listen("tcp", r.address)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	r.listener = listener
+	r.mutex.Unlock()
+	log.Printf("Relay listening on %s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			r.mutex.Lock()
+			stopped := r.stopped
+			r.mutex.Unlock()
+			if stopped {
+				return nil
+			}
+			log.Printf("Error accepting relay connection: %v", err)
+			continue
+		}
+		go r.handleConn(conn)
+	}
+}
+
+// Addr returns the address the relay listens on, nil before Start
+func (r *Relay) Addr() net.Addr {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.listener == nil {
+		return nil
+	}
+	return r.listener.Addr()
+}
+
+// Stop closes the listener, every session's control connection and the
+// connections being relayed
+func (r *Relay) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.stopped = true
+	if r.listener != nil {
+		r.listener.Close()
+	}
+	for _, s := range r.sessions {
+		s.control.Close()
+	}
+	for conn := range r.piped {
+		conn.Close()
+	}
+}
+
+// handleConn reads a connection's header and dispatches it by role
+func (r *Relay) handleConn(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(headerTimeout))
+	line, err := readLine(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, headerPrefix))
+	if !strings.HasPrefix(line, headerPrefix+" ") || len(fields) < 2 {
+		reject(conn, "invalid header")
+		return
+	}
+
+	role, code := fields[0], fields[1]
+	switch {
+	case role == "host" && len(fields) == 2:
+		r.host(conn, code)
+	case role == "join" && len(fields) == 2:
+		r.join(conn, code)
+	case role == "accept" && len(fields) == 3:
+		r.accept(conn, code, fields[2])
+	default:
+		reject(conn, "invalid header")
+	}
+}
+
+// host registers a session and serves its control connection until the
+// server goes away
+func (r *Relay) host(conn net.Conn, code string) {
+	token, err := randomCode(16)
+	if err != nil {
+		reject(conn, "internal error")
+		return
+	}
+
+	s := &session{control: conn, token: token}
+	r.mutex.Lock()
+	if _, ok := r.sessions[code]; ok || r.stopped {
+		r.mutex.Unlock()
+		reject(conn, "session code in use")
+		return
+	}
+	r.sessions[code] = s
+	r.mutex.Unlock()
+
+	log.Printf("Session %s registered by %s", code, conn.RemoteAddr())
+	s.writeControl("OK " + token)
+
+	// The server sends nothing on the control connection, reading only
+	// detects when it goes away
+	io.Copy(io.Discard, conn)
+	conn.Close()
+
+	r.mutex.Lock()
+	if r.sessions[code] == s {
+		delete(r.sessions, code)
+	}
+	r.mutex.Unlock()
+	log.Printf("Session %s closed", code)
+}
+
+// join asks the session's server for a connection and pipes the client to
+// it once it arrives
+func (r *Relay) join(conn net.Conn, code string) {
+	r.mutex.Lock()
+	s, ok := r.sessions[code]
+	if !ok {
+		r.mutex.Unlock()
+		reject(conn, "unknown session")
+		return
+	}
+	w := &waiter{paired: make(chan net.Conn, 1)}
+	s.waiting = append(s.waiting, w)
+	r.mutex.Unlock()
+
+	if err := s.writeControl("CONNECT " + conn.RemoteAddr().String()); err != nil {
+		r.forget(s, w)
+		reject(conn, "server unavailable")
+		return
+	}
+
+	select {
+	case server := <-w.paired:
+		log.Printf("Relaying %s to session %s", conn.RemoteAddr(), code)
+		fmt.Fprintf(conn, "OK\n")
+		r.pipe(conn, server)
+	case <-time.After(joinTimeout):
+		r.forget(s, w)
+		// The server may have answered while we gave up
+		select {
+		case server := <-w.paired:
+			server.Close()
+		default:
+		}
+		reject(conn, "server did not answer")
+	}
+}
+
+// accept hands a server's accept connection to the oldest waiting client
+func (r *Relay) accept(conn net.Conn, code, token string) {
+	r.mutex.Lock()
+	s, ok := r.sessions[code]
+	if !ok || s.token != token || len(s.waiting) == 0 {
+		r.mutex.Unlock()
+		reject(conn, "nothing to accept")
+		return
+	}
+	w := s.waiting[0]
+	s.waiting = s.waiting[1:]
+	r.mutex.Unlock()
+
+	fmt.Fprintf(conn, "OK\n")
+
+	// The client may have timed out since it was taken from the queue,
+	// nothing would read the connection then
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if w.gone {
+		conn.Close()
+		return
+	}
+	w.paired <- conn
+}
+
+// forget removes a client that stopped waiting
+func (r *Relay) forget(s *session, w *waiter) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	w.gone = true
+	for i, other := range s.waiting {
+		if other == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// writeControl writes a line to the server's control connection
+func (s *session) writeControl(line string) error {
+	s.controlMutex.Lock()
+	defer s.controlMutex.Unlock()
+	_, err := fmt.Fprintf(s.control, "%s\n", line)
+	return err
+}
+
+// pipe copies between two connections until either side closes or the
+// relay stops
+func (r *Relay) pipe(a, b net.Conn) {
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		a.Close()
+		b.Close()
+		return
+	}
+	r.piped[a], r.piped[b] = true, true
+	r.mutex.Unlock()
+	defer func() {
+		r.mutex.Lock()
+		delete(r.piped, a)
+		delete(r.piped, b)
+		r.mutex.Unlock()
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// reject replies with an error and closes the connection
+func reject(conn net.Conn, reason string) {
+	fmt.Fprintf(conn, "ERR %s\n", reason)
+	conn.Close()
+}
+
+// readLine reads a newline terminated line one byte at a time, so nothing
+// after the line is consumed from the connection
+func readLine(conn io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxLineLength {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("relay line too long")
+}
+
+// randomCode returns a random code of n base32 characters
+func randomCode(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(buf)[:n], nil
+}
+
+// NewSessionCode returns a random session code for servers that weren't
+// given one
+func NewSessionCode() (string, error) {
+	return randomCode(8)
+}
@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startRelay(t *testing.T) string {
+	t.Helper()
+	return runRelay(t).Addr().String()
+}
+
+func runRelay(t *testing.T) *Relay {
+	t.Helper()
+	r := NewRelay("127.0.0.1:0")
+	go r.Start()
+	t.Cleanup(r.Stop)
+
+	for i := 0; i < 100 && r.Addr() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.Addr() == nil {
+		t.Fatal("relay did not start")
+	}
+	return r
+}
+
+func TestRelayPairsServerAndClient(t *testing.T) {
+	address := startRelay(t)
+
+	listener, err := Listen(address, "ABCD1234", nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		defer conn.Close()
+		if _, ok := conn.RemoteAddr().(Addr); !ok {
+			t.Errorf("remote address %T is not a relay address", conn.RemoteAddr())
+		}
+		_, err = io.Copy(conn, io.LimitReader(conn, 5)) // Echo
+		accepted <- err
+	}()
+
+	conn, err := Dial(address, "ABCD1234", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "hello" {
+		t.Fatalf("echo returned %q, %v", reply, err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatalf("server side: %v", err)
+	}
+}
+
+func TestRelayRejectsUnknownSession(t *testing.T) {
+	address := startRelay(t)
+
+	_, err := Dial(address, "NOPE", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown session") {
+		t.Fatalf("Dial returned %v, want unknown session", err)
+	}
+}
+
+func TestRelayDialRefusesUnpinnedTLS(t *testing.T) {
+	// Checked before connecting, no relay is needed
+	_, err := Dial("127.0.0.1:1", "ABCD1234", &tls.Config{})
+	if !errors.Is(err, ErrRelayTLSNeedsCA) {
+		t.Fatalf("Dial returned %v, want %v", err, ErrRelayTLSNeedsCA)
+	}
+}
+
+func TestRelayStopClosesRelayedConnections(t *testing.T) {
+	r := runRelay(t)
+	address := r.Addr().String()
+
+	listener, err := Listen(address, "ABCD1234", nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := Dial(address, "ABCD1234", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	server := <-accepted
+	defer server.Close()
+
+	// Once both ends are paired, Stop cuts them off
+	if _, err := conn.Write([]byte("x")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := io.ReadFull(server, make([]byte, 1)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	r.Stop()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read after Stop returned %v, want the connection closed", err)
+	}
+}
@@ -0,0 +1,460 @@
+// Package scenario runs end-to-end scenarios described in YAML against an
+// in-process server and headless clients. The server captures synthetic
+// frames and everything is connected over the in-memory transport, so
+// scenarios run anywhere without a display or network.
+//
+// A scenario file looks like this:
+//
+//	name: two clients
+//	server:
+//	  monitors: [320x240, 320x240]
+//	  password: secret
+//	steps:
+//	  - connect: alice
+//	    password: secret
+//	  - expect_clients: 1
+//	  - expect_frames: 5
+//	    client: alice
+//	    monitor: 2
+//	  - quality: 40
+//	    client: alice
+//	  - expect_smaller_frames: true
+//	    client: alice
+//	  - drop_link: true
+//	  - expect_clients: 0
+//
+// Each step does exactly one thing, see Step for the available steps.
+// Expectations are polled until they hold or their timeout ("within",
+// default 5s) passes.
+package scenario
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/moderniselife/ultrardp/client"
+	"github.com/moderniselife/ultrardp/server"
+	"github.com/moderniselife/ultrardp/stats"
+	"github.com/moderniselife/ultrardp/transport"
+)
+
+// defaultWithin is how long expectations wait when no timeout is given
+const defaultWithin = 5 * time.Second
+
+// pollInterval is how often expectations are checked
+const pollInterval = 50 * time.Millisecond
+
+// Scenario is a parsed scenario file
+type Scenario struct {
+	Name   string       `yaml:"name"`
+	Server ServerConfig `yaml:"server"`
+	Steps  []Step       `yaml:"steps"`
+}
+
+// ServerConfig configures the server a scenario runs against
+type ServerConfig struct {
+	Monitors []string `yaml:"monitors"` // Monitor sizes like "640x480", one 320x240 monitor if empty
+	Password string   `yaml:"password"`
+	PSK      string   `yaml:"psk"`
+}
+
+// Step is a single action or expectation. Exactly one of the action fields
+// (connect, disconnect, quality, drop_link, wait, expect_clients,
+// expect_frames, expect_keyframes, expect_smaller_frames) must be set, the
+// others qualify it.
+type Step struct {
+	// connect: start a headless client with this name
+	Connect string `yaml:"connect"`
+	// disconnect: stop the named client
+	Disconnect string `yaml:"disconnect"`
+	// quality: send a quality control packet from client
+	Quality *int `yaml:"quality"`
+	// drop_link: sever every connection as if the network failed
+	DropLink bool `yaml:"drop_link"`
+	// wait: sleep for a duration like "500ms"
+	Wait string `yaml:"wait"`
+	// expect_clients: the server has exactly this many clients
+	ExpectClients *int `yaml:"expect_clients"`
+	// expect_frames: client received at least this many frames of monitor
+	ExpectFrames int `yaml:"expect_frames"`
+	// expect_keyframes: client received at least this many keyframes of
+	// monitor since it connected or the link last dropped
+	ExpectKeyframes int `yaml:"expect_keyframes"`
+	// expect_smaller_frames: frames of monitor client received since the
+	// last quality step are smaller on average than the ones before
+	ExpectSmallerFrames bool `yaml:"expect_smaller_frames"`
+
+	Client      string   `yaml:"client"`       // Client a step applies to
+	Monitor     uint32   `yaml:"monitor"`      // Server monitor ID, default 1
+	Monitors    []string `yaml:"monitors"`     // Local monitors of a connecting client, the server's if empty
+	Password    string   `yaml:"password"`     // Password of a connecting client
+	PSK         string   `yaml:"psk"`          // Pre-shared key of a connecting client
+	ExpectError bool     `yaml:"expect_error"` // Connecting must fail
+	Reconnect   bool     `yaml:"reconnect"`    // A connecting client reconnects when its link drops
+	Within      string   `yaml:"within"`       // Timeout of an expectation
+}
+
+// Load reads a scenario file
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return &s, nil
+}
+
+// runner holds the state of a running scenario
+type runner struct {
+	scenario *Scenario
+	address  string
+	server   *server.Server
+	clients  map[string]*client.Client
+	logf     func(format string, args ...any)
+
+	// Stats of every client when the link last dropped and at the last
+	// quality step, for the expectations relative to them
+	droppedAt map[string]stats.Snapshot
+	qualityAt map[string]stats.Snapshot
+}
+
+// Run executes the scenario and returns the first failed step. logf
+// receives progress messages, e.g. testing.T.Logf.
+func (s *Scenario) Run(logf func(format string, args ...any)) error {
+	r := &runner{
+		scenario: s,
+		address:  fmt.Sprintf("scenario-%s-%d", s.Name, time.Now().UnixNano()),
+		clients:  make(map[string]*client.Client),
+		logf:     logf,
+
+		droppedAt: make(map[string]stats.Snapshot),
+		qualityAt: make(map[string]stats.Snapshot),
+	}
+	defer r.stop()
+
+	if err := r.startServer(); err != nil {
+		return fmt.Errorf("starting server: %w", err)
+	}
+	for i, step := range s.Steps {
+		if err := r.run(step); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// startServer starts the server with synthetic capture
+func (r *runner) startServer() error {
+	source, err := newSyntheticSource(r.scenario.Server.Monitors)
+	if err != nil {
+		return err
+	}
+
+	opts := []server.Option{
+		server.WithTransport(transport.Memory),
+		server.WithCaptureSource(source),
+		server.WithInputIndicator(false),
+	}
+	if r.scenario.Server.Password != "" {
+		opts = append(opts, server.WithPassword(r.scenario.Server.Password))
+	}
+	if r.scenario.Server.PSK != "" {
+		opts = append(opts, server.WithPreSharedKey([]byte(r.scenario.Server.PSK)))
+	}
+
+	s, err := server.NewServer(r.address, opts...)
+	if err != nil {
+		return err
+	}
+	r.server = s
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+
+	// Start only returns early if listening failed
+	select {
+	case err := <-started:
+		return err
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+// stop shuts down all clients and the server
+func (r *runner) stop() {
+	for name := range r.clients {
+		r.stopClient(name)
+	}
+	if r.server != nil {
+		r.server.Stop()
+	}
+}
+
+// stopClient stops a client and forgets it
+func (r *runner) stopClient(name string) {
+	if c, ok := r.clients[name]; ok {
+		c.Stop()
+		delete(r.clients, name)
+		delete(r.droppedAt, name)
+		delete(r.qualityAt, name)
+	}
+}
+
+// run executes one step
+func (r *runner) run(step Step) error {
+	switch {
+	case step.Connect != "":
+		r.logf("connect %s", step.Connect)
+		return r.connect(step)
+
+	case step.Disconnect != "":
+		r.logf("disconnect %s", step.Disconnect)
+		if _, ok := r.clients[step.Disconnect]; !ok {
+			return fmt.Errorf("unknown client %q", step.Disconnect)
+		}
+		r.stopClient(step.Disconnect)
+		return nil
+
+	case step.Quality != nil:
+		r.logf("quality %d from %s", *step.Quality, step.Client)
+		c, err := r.client(step.Client)
+		if err != nil {
+			return err
+		}
+		r.markClients(r.qualityAt)
+		return c.SendQualityControl(*step.Quality)
+
+	case step.DropLink:
+		r.logf("drop link")
+		r.markClients(r.droppedAt)
+		transport.DropMemoryLinks(r.address)
+		return nil
+
+	case step.Wait != "":
+		d, err := time.ParseDuration(step.Wait)
+		if err != nil {
+			return err
+		}
+		r.logf("wait %v", d)
+		time.Sleep(d)
+		return nil
+
+	case step.ExpectClients != nil:
+		want := *step.ExpectClients
+		r.logf("expect %d clients", want)
+		return r.expect(step, func() error {
+			if got := r.server.Stats().Clients; got != want {
+				return fmt.Errorf("server has %d clients, want %d", got, want)
+			}
+			return nil
+		})
+
+	case step.ExpectFrames > 0:
+		c, err := r.client(step.Client)
+		if err != nil {
+			return err
+		}
+		monitor := step.monitor()
+		r.logf("expect %d frames of monitor %d at %s", step.ExpectFrames, monitor, step.Client)
+		return r.expect(step, func() error {
+			frames := monitorStats(c.Stats(), monitor).Frames
+			if frames < uint64(step.ExpectFrames) {
+				return fmt.Errorf("%s received %d frames of monitor %d, want %d", step.Client, frames, monitor, step.ExpectFrames)
+			}
+			return nil
+		})
+
+	case step.ExpectKeyframes > 0:
+		c, err := r.client(step.Client)
+		if err != nil {
+			return err
+		}
+		monitor := step.monitor()
+		before := monitorStats(r.droppedAt[step.Client], monitor).Keyframes
+		r.logf("expect %d keyframes of monitor %d at %s", step.ExpectKeyframes, monitor, step.Client)
+		return r.expect(step, func() error {
+			keyframes := monitorStats(c.Stats(), monitor).Keyframes - before
+			if keyframes < uint64(step.ExpectKeyframes) {
+				return fmt.Errorf("%s received %d keyframes of monitor %d, want %d", step.Client, keyframes, monitor, step.ExpectKeyframes)
+			}
+			return nil
+		})
+
+	case step.ExpectSmallerFrames:
+		c, err := r.client(step.Client)
+		if err != nil {
+			return err
+		}
+		marked, ok := r.qualityAt[step.Client]
+		if !ok {
+			return fmt.Errorf("no quality step since %s connected", step.Client)
+		}
+		monitor := step.monitor()
+		before := monitorStats(marked, monitor)
+		if before.Frames == 0 {
+			return fmt.Errorf("%s received no frames of monitor %d before the quality step", step.Client, monitor)
+		}
+		r.logf("expect smaller frames of monitor %d at %s", monitor, step.Client)
+		return r.expect(step, func() error {
+			now := monitorStats(c.Stats(), monitor)
+			if now.Frames == before.Frames {
+				return fmt.Errorf("%s received no frames of monitor %d since the quality step", step.Client, monitor)
+			}
+			sizeBefore := before.Bytes / before.Frames
+			sizeAfter := (now.Bytes - before.Bytes) / (now.Frames - before.Frames)
+			if sizeAfter >= sizeBefore {
+				return fmt.Errorf("%s received frames of monitor %d of %d bytes on average, %d before the quality step",
+					step.Client, monitor, sizeAfter, sizeBefore)
+			}
+			return nil
+		})
+	}
+
+	return errors.New("step has no action")
+}
+
+// connect starts a headless client, which must fail if expect_error is set
+func (r *runner) connect(step Step) error {
+	if _, ok := r.clients[step.Connect]; ok {
+		return fmt.Errorf("client %q is already connected", step.Connect)
+	}
+
+	sizes := step.Monitors
+	if len(sizes) == 0 {
+		sizes = r.scenario.Server.Monitors
+	}
+	monitors, err := parseMonitors(sizes)
+	if err != nil {
+		return err
+	}
+
+	opts := []client.Option{
+		client.WithTransport(transport.Memory),
+		client.WithHeadless(),
+		client.WithLocalMonitors(monitors),
+	}
+	if step.Password != "" {
+		opts = append(opts, client.WithPassword(step.Password))
+	}
+	if step.PSK != "" {
+		opts = append(opts, client.WithPreSharedKey([]byte(step.PSK)))
+	}
+	if step.Reconnect {
+		opts = append(opts, client.WithReconnect(time.Minute))
+	}
+
+	c, err := client.NewClient(r.address, opts...)
+	if err != nil {
+		if step.ExpectError {
+			return nil
+		}
+		return err
+	}
+	r.clients[step.Connect] = c
+
+	// Start blocks for the whole session, it only returns early on errors
+	started := make(chan error, 1)
+	go func() { started <- c.Start() }()
+
+	if step.ExpectError {
+		within, err := step.within()
+		if err != nil {
+			return err
+		}
+		select {
+		case err := <-started:
+			if err == nil {
+				return errors.New("connect succeeded, expected an error")
+			}
+			r.logf("connect failed as expected: %v", err)
+			r.stopClient(step.Connect)
+			return nil
+		case <-time.After(within):
+			return fmt.Errorf("connect did not fail within %v", within)
+		}
+	}
+
+	select {
+	case err := <-started:
+		if err != nil {
+			return fmt.Errorf("connect failed: %w", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+	}
+	return nil
+}
+
+// client returns a connected client by name
+func (r *runner) client(name string) (*client.Client, error) {
+	c, ok := r.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown client %q", name)
+	}
+	return c, nil
+}
+
+// markClients records the stats of every client in marks
+func (r *runner) markClients(marks map[string]stats.Snapshot) {
+	for name, c := range r.clients {
+		marks[name] = c.Stats()
+	}
+}
+
+// monitorStats returns the stats of a server monitor in a snapshot, zero
+// if it has none yet
+func monitorStats(snapshot stats.Snapshot, monitorID uint32) stats.MonitorSnapshot {
+	for _, m := range snapshot.Monitors {
+		if m.ID == monitorID {
+			return m
+		}
+	}
+	return stats.MonitorSnapshot{ID: monitorID}
+}
+
+// expect polls check until it succeeds or the step's timeout passes
+func (r *runner) expect(step Step, check func() error) error {
+	within, err := step.within()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(within)
+	for {
+		err := check()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// within returns the step's timeout
+func (step Step) within() (time.Duration, error) {
+	if step.Within == "" {
+		return defaultWithin, nil
+	}
+	return time.ParseDuration(step.Within)
+}
+
+// monitor returns the server monitor the step applies to
+func (step Step) monitor() uint32 {
+	if step.Monitor == 0 {
+		return 1
+	}
+	return step.Monitor
+}
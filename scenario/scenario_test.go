@@ -0,0 +1,34 @@
+package scenario
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestScenarios runs every scenario in testdata
+func TestScenarios(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no scenarios found")
+	}
+
+	scenarios := make([]*Scenario, 0, len(files))
+	for _, file := range files {
+		s, err := Load(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scenarios = append(scenarios, s)
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.Name, func(t *testing.T) {
+			if err := s.Run(t.Logf); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
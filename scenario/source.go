@@ -0,0 +1,82 @@
+package scenario
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// syntheticSource is a capture source producing a patterned image per
+// monitor whose colors change with every capture, so consecutive frames
+// differ and their size depends on the quality they are encoded at
+type syntheticSource struct {
+	monitors *protocol.MonitorConfig
+
+	mutex  sync.Mutex
+	frames map[uint32]int
+}
+
+// newSyntheticSource creates a source with the given monitor sizes
+func newSyntheticSource(sizes []string) (*syntheticSource, error) {
+	monitors, err := parseMonitors(sizes)
+	if err != nil {
+		return nil, err
+	}
+	return &syntheticSource{monitors: monitors, frames: make(map[uint32]int)}, nil
+}
+
+func (s *syntheticSource) Monitors() (*protocol.MonitorConfig, error) {
+	return s.monitors, nil
+}
+
+func (s *syntheticSource) Capture(monitor protocol.MonitorInfo) (image.Image, error) {
+	s.mutex.Lock()
+	s.frames[monitor.ID]++
+	frame := s.frames[monitor.ID]
+	s.mutex.Unlock()
+
+	img := image.NewRGBA(image.Rect(0, 0, int(monitor.Width), int(monitor.Height)))
+	fill := color.RGBA{R: uint8(frame * 7), G: uint8(monitor.ID * 60), B: 128, A: 255}
+	draw.Draw(img, img.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+
+	// Fine detail costs bytes at high quality only
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if (x^y)&4 != 0 {
+				img.SetRGBA(x, y, color.RGBA{R: fill.R, G: fill.G, B: uint8(x * y), A: 255})
+			}
+		}
+	}
+	return img, nil
+}
+
+// parseMonitors builds a side by side monitor layout from sizes like
+// "640x480", the first monitor is primary
+func parseMonitors(sizes []string) (*protocol.MonitorConfig, error) {
+	if len(sizes) == 0 {
+		sizes = []string{"320x240"}
+	}
+
+	config := &protocol.MonitorConfig{MonitorCount: uint32(len(sizes))}
+	x := uint32(0)
+	for i, size := range sizes {
+		var width, height uint32
+		if _, err := fmt.Sscanf(size, "%dx%d", &width, &height); err != nil || width == 0 || height == 0 {
+			return nil, fmt.Errorf("invalid monitor size %q, expected WIDTHxHEIGHT", size)
+		}
+		config.Monitors = append(config.Monitors, protocol.MonitorInfo{
+			ID:        uint32(i + 1),
+			Width:     width,
+			Height:    height,
+			PositionX: x,
+			Primary:   i == 0,
+		})
+		x += width
+	}
+	return config, nil
+}
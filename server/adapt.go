@@ -0,0 +1,18 @@
+package server
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// handleClientReport passes the frames a client lost in transit to its
+// adaptive quality controller
+func (s *Server) handleClientReport(client *Client, payload []byte) {
+	report, err := protocol.DecodeClientReport(payload)
+	if err != nil {
+		log.Printf("Invalid client report from client %s: %v", client.id, err)
+		return
+	}
+	client.adapter.Report(uint64(report.FramesDropped))
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit event names
+const (
+	AuditClientConnected    = "client_connected"
+	AuditClientDisconnected = "client_disconnected"
+	AuditAuthFailed         = "auth_failed"
+	AuditInputStarted       = "remote_input_started"
+	AuditInputStopped       = "remote_input_stopped"
+)
+
+// AuditEvent is a single entry in the audit log
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Client string    `json:"client,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// auditLog appends security relevant events as JSON lines to a file
+type auditLog struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// WithAuditLog records connections, authentication failures and remote input
+// activity as JSON lines in the given file
+func WithAuditLog(path string) Option {
+	return func(s *Server) {
+		s.auditPath = path
+	}
+}
+
+// openAuditLog opens (or creates) the audit log for appending
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// record writes an event to the audit log. It is safe to call on a nil log.
+func (a *auditLog) record(event, client, detail string) {
+	if a == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entry := AuditEvent{Time: time.Now(), Event: event, Client: client, Detail: detail}
+	if err := a.encoder.Encode(entry); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// close closes the audit log file
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.file.Close()
+}
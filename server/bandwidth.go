@@ -0,0 +1,216 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/bandwidth"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// bulkStateInterval is how often a client hears how its bulk transfers are
+// scheduled while they run
+const bulkStateInterval = time.Second
+
+// WithBulkShare sets the share (0-1) of each client's estimated bandwidth
+// that bulk transfers may use together, the rest is kept for video and
+// input so their latency stays on target. Clipboard updates are bulk data.
+func WithBulkShare(share float64) Option {
+	return func(s *Server) {
+		s.bulkShare = share
+	}
+}
+
+// WithMaxBandwidth caps the video bandwidth in kbit/s for metered or shared
+// links. The capture loops lower JPEG quality and then frame rate to stay
+// under it. Clients that may control the server can ask for a lower cap of
+// their own; 0 means no cap.
+func WithMaxBandwidth(kbps int) Option {
+	return func(s *Server) {
+		s.maxBandwidth = kbps
+	}
+}
+
+// handleBandwidthLimit records the video bandwidth cap a client asked for
+func (s *Server) handleBandwidthLimit(client *Client, payload []byte) {
+	if len(payload) < 4 {
+		log.Printf("Invalid bandwidth limit packet from client %s", client.id)
+		return
+	}
+	kbps := protocol.BytesToUint32(payload)
+	client.maxBandwidth.Store(int64(kbps))
+	log.Printf("Client %s limited video to %d kbit/s", client.id, kbps)
+}
+
+// videoLimit returns the byte rate each monitor's video must stay under:
+// the strictest of the server's and the controlling clients' caps, split
+// between the monitors. Video is encoded once for all clients, so one
+// client's cap lowers the quality for everyone. 0 means unlimited.
+func (s *Server) videoLimit() float64 {
+	kbps := int64(s.maxBandwidth)
+
+	s.clientsMutex.Lock()
+	for _, client := range s.clients {
+		if limit := client.maxBandwidth.Load(); limit > 0 && (kbps == 0 || limit < kbps) {
+			kbps = limit
+		}
+	}
+	s.clientsMutex.Unlock()
+
+	monitors := s.monitorConfig()
+	if kbps <= 0 || len(monitors.Monitors) == 0 {
+		return 0
+	}
+	return float64(kbps) * 1000 / 8 / float64(len(monitors.Monitors))
+}
+
+// broadcastBulk queues a packet of bulk data for every active client but
+// one, see queueBulk
+func (s *Server) broadcastBulk(except *Client, packet *protocol.Packet) {
+	for _, client := range s.activeClients() {
+		if client != except {
+			s.queueBulk(client, packet)
+		}
+	}
+}
+
+// queueBulk queues a packet of bulk data, such as a clipboard update, for a
+// client. Each client's bulk packets are sent one after another in the
+// order they were queued, see sendBulk.
+func (s *Server) queueBulk(client *Client, packet *protocol.Packet) {
+	client.bulkMutex.Lock()
+	defer client.bulkMutex.Unlock()
+
+	client.bulkQueue = append(client.bulkQueue, packet)
+	if !client.bulkSending {
+		client.bulkSending = true
+		go s.sendBulkQueue(client)
+	}
+}
+
+// sendBulkQueue sends a client's queued bulk packets until none are left
+func (s *Server) sendBulkQueue(client *Client) {
+	for {
+		client.bulkMutex.Lock()
+		if len(client.bulkQueue) == 0 {
+			client.bulkSending = false
+			client.bulkMutex.Unlock()
+			return
+		}
+		packet := client.bulkQueue[0]
+		client.bulkQueue = client.bulkQueue[1:]
+		client.bulkMutex.Unlock()
+
+		s.sendBulk(client, packet)
+	}
+}
+
+// sendBulk sends a packet of bulk data within the client's bulk share of its
+// bandwidth. Multi-stream transports carry it on a stream of its own, on
+// others it is split into chunks with frames and input sent between them.
+// The client is told how the transfer is scheduled while it runs.
+func (s *Server) sendBulk(client *Client, packet *protocol.Packet) error {
+	stream, err := client.bulkStream()
+	if err != nil {
+		log.Printf("Error opening bulk stream to client %s: %v", client.id, err)
+		return err
+	}
+
+	var transfer *bandwidth.Transfer
+	if stream != nil {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		transfer = client.bandwidth.StartTransfer(stream.writer, int64(protocol.HeaderSize+len(packet.Payload)))
+	} else {
+		chunks := &bulkChunks{client: client, packetType: packet.Type, total: uint32(len(packet.Payload))}
+		transfer = client.bandwidth.StartTransfer(chunks, int64(len(packet.Payload)))
+	}
+	done := make(chan struct{})
+	go s.reportBulk(client, done)
+
+	switch {
+	case stream != nil:
+		s.stats.PacketSent(protocol.HeaderSize + len(packet.Payload))
+		err = protocol.EncodePacket(transfer, packet)
+	case len(packet.Payload) == 0:
+		err = client.send(packet)
+	default:
+		_, err = transfer.Write(packet.Payload)
+	}
+	transfer.Close()
+	close(done)
+	if err != nil {
+		log.Printf("Error sending bulk data to client %s: %v", client.id, err)
+	}
+	return err
+}
+
+// bulkStream returns the stream bulk data goes on, opened on first use, or
+// nil if the transport has no streams
+func (c *Client) bulkStream() (*videoStream, error) {
+	if c.streams == nil {
+		return nil, nil
+	}
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	stream, ok := c.videoStreams[protocol.StreamBulk]
+	if !ok {
+		w, err := c.streams.OpenStream(protocol.StreamBulk)
+		if err != nil {
+			return nil, err
+		}
+		stream = &videoStream{writer: w}
+		c.videoStreams[protocol.StreamBulk] = stream
+	}
+	return stream, nil
+}
+
+// bulkChunks sends what is written to it as the chunks of a packet on the
+// client's connection, locking it for one chunk at a time
+type bulkChunks struct {
+	client     *Client
+	packetType byte
+	total      uint32 // Payload size of the packet
+	offset     uint32 // Payload bytes sent so far
+}
+
+func (w *bulkChunks) Write(p []byte) (int, error) {
+	chunk := protocol.EncodeBulkChunk(protocol.BulkChunk{Type: w.packetType, Total: w.total, Offset: w.offset, Data: p})
+	if err := w.client.send(protocol.NewPacket(protocol.PacketTypeBulkChunk, chunk)); err != nil {
+		return 0, err
+	}
+	w.offset += uint32(len(p))
+	return len(p), nil
+}
+
+// reportBulk sends the client its bulk state until done is closed, and
+// once more after
+func (s *Server) reportBulk(client *Client, done <-chan struct{}) {
+	ticker := time.NewTicker(bulkStateInterval)
+	defer ticker.Stop()
+	for {
+		s.sendBulkState(client)
+		select {
+		case <-done:
+			s.sendBulkState(client)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendBulkState sends the client how its bulk transfers are scheduled
+func (s *Server) sendBulkState(client *Client) {
+	state := client.bandwidth.State()
+	payload := protocol.EncodeBulkState(protocol.BulkState{
+		CapacityKbps: uint32(state.CapacityKbps),
+		LimitKbps:    uint32(state.BulkLimitKbps),
+		Transfers:    uint16(min(state.Transfers, 0xFFFF)),
+		Throttled:    state.Throttled,
+		Progress:     uint8(state.Progress * 100),
+	})
+	if err := client.send(protocol.NewPacket(protocol.PacketTypeBulkState, payload)); err != nil {
+		log.Printf("Error sending bulk state to client %s: %v", client.id, err)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/moderniselife/ultrardp/bandwidth"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+func TestFrameSentDuringBulkTransfer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	reader, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer reader.Close()
+	conn, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+	defer conn.Close()
+
+	s := &Server{}
+	client := &Client{id: "test", conn: conn, bandwidth: bandwidth.NewScheduler(0)}
+
+	// A clipboard update that takes tens of seconds at the bulk share of the
+	// minimum capacity
+	s.queueBulk(client, protocol.NewPacket(protocol.PacketTypeClipboard, make([]byte, 1<<20)))
+
+	reader.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		packet, err := protocol.DecodePacket(reader)
+		if err != nil {
+			t.Fatalf("Waiting for the first bulk chunk: %v", err)
+		}
+		if packet.Type == protocol.PacketTypeBulkChunk {
+			break
+		}
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- client.send(protocol.NewPacket(protocol.PacketTypeVideoFrame, []byte("frame")))
+	}()
+
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		packet, err := protocol.DecodePacket(reader)
+		if err != nil {
+			t.Fatalf("Frame held up by the bulk transfer: %v", err)
+		}
+		if packet.Type == protocol.PacketTypeVideoFrame {
+			break
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/camera"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// cameraShare streams the server's webcam to the clients asking for it and
+// shows the webcam of one client on a virtual camera, so apps in a call on
+// the server use the camera of whoever is connected
+type cameraShare struct {
+	mutex   sync.Mutex
+	enabled bool   // Whether the webcam is shared, see WithCamera
+	device  string // ffmpeg name of the webcam, empty for the default one
+	capture *camera.Capture
+	viewers map[*Client]bool
+
+	virtualDevice string // v4l2loopback device, empty if disabled
+	virtual       *camera.Virtual
+	sender        *Client // Client feeding the virtual camera
+	refused       map[*Client]bool
+}
+
+// WithCamera shares a webcam of the server with clients that ask for it,
+// device is the camera as ffmpeg names it, empty for the default one
+func WithCamera(device string) Option {
+	return func(s *Server) {
+		s.camera.enabled = true
+		s.camera.device = device
+	}
+}
+
+// WithVirtualCamera shows the webcam a client sends on a v4l2loopback
+// device, e.g. /dev/video10, which apps on the server use as a camera
+func WithVirtualCamera(device string) Option {
+	return func(s *Server) {
+		s.camera.virtualDevice = device
+	}
+}
+
+// handleCameraRequest starts or stops streaming the server's webcam to a
+// client
+func (s *Server) handleCameraRequest(client *Client, payload []byte) {
+	on := len(payload) > 0 && payload[0] == protocol.CameraOn
+	c := &s.camera
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !on {
+		delete(c.viewers, client)
+		return
+	}
+	if !c.enabled {
+		s.sendCameraError(client, protocol.ErrorCameraUnavailable, "The server doesn't share a camera, start it with -camera")
+		return
+	}
+
+	if c.viewers == nil {
+		c.viewers = make(map[*Client]bool)
+	}
+	c.viewers[client] = true
+	if c.capture != nil {
+		return
+	}
+	capture, err := camera.Open(c.device)
+	if err != nil {
+		log.Printf("Failed to open camera: %v", err)
+		s.sendCameraError(client, protocol.ErrorCameraUnavailable, fmt.Sprintf("The server's camera can't be opened: %v", err))
+		delete(c.viewers, client)
+		return
+	}
+	log.Printf("Sharing camera with client %s", client.id)
+	c.capture = capture
+	go s.shareCamera(capture)
+}
+
+// shareCamera sends the frames of the server's webcam to its viewers until
+// none is left
+func (s *Server) shareCamera(capture *camera.Capture) {
+	defer capture.Close()
+
+	for {
+		frame, err := capture.Next()
+		if err != nil {
+			log.Printf("Camera capture ended: %v", err)
+			s.camera.mutex.Lock()
+			if s.camera.capture == capture {
+				s.camera.capture = nil
+				clear(s.camera.viewers)
+			}
+			s.camera.mutex.Unlock()
+			return
+		}
+
+		viewers := s.cameraViewers(capture)
+		if len(viewers) == 0 {
+			log.Println("Stopped sharing camera, no client watches it")
+			return
+		}
+		for _, client := range viewers {
+			if err := s.sendCameraFrame(client, frame); err != nil {
+				log.Printf("Error sending camera frame to client %s: %v", client.id, err)
+			}
+		}
+	}
+}
+
+// cameraViewers returns the clients watching the server's webcam. Without
+// any the capture is dropped, so a new viewer opens the camera again.
+func (s *Server) cameraViewers(capture *camera.Capture) []*Client {
+	c := &s.camera
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var viewers []*Client
+	for client := range c.viewers {
+		if client.active {
+			viewers = append(viewers, client)
+		}
+	}
+	if len(viewers) == 0 && c.capture == capture {
+		c.capture = nil
+	}
+	return viewers
+}
+
+// sendCameraFrame sends a webcam frame to a client, on the camera stream if
+// the transport supports streams. Frames are dropped while the congestion
+// window is full, like video frames.
+func (s *Server) sendCameraFrame(client *Client, frame []byte) error {
+	if !client.congestion.CanSend() {
+		return nil
+	}
+	packet := protocol.NewPacket(protocol.PacketTypeCameraFrame, frame)
+	if client.streams == nil {
+		return client.sendPaced(packet)
+	}
+
+	client.sendMutex.Lock()
+	stream, ok := client.videoStreams[protocol.StreamCamera]
+	if !ok {
+		writer, err := client.streams.OpenStream(protocol.StreamCamera)
+		if err != nil {
+			client.sendMutex.Unlock()
+			return err
+		}
+		stream = &videoStream{writer: writer}
+		client.videoStreams[protocol.StreamCamera] = stream
+	}
+	client.sendMutex.Unlock()
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	s.stats.PacketSent(protocol.HeaderSize + len(frame))
+	return protocol.EncodePacket(client.congestion.Pace(stream.writer), packet)
+}
+
+// handleCameraFrame shows a frame of a client's webcam on the virtual
+// camera. One client feeds it at a time, the first to send a frame.
+func (s *Server) handleCameraFrame(client *Client, frame []byte) {
+	c := &s.camera
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.refused[client] {
+		return
+	}
+	var reason string
+	switch {
+	case c.virtualDevice == "":
+		reason = "The server has no virtual camera, start it with -virtual-camera"
+	case c.sender != nil && c.sender != client:
+		reason = fmt.Sprintf("The server's virtual camera shows the camera of %s", c.sender.id)
+	case c.virtual == nil:
+		virtual, err := camera.OpenVirtual(c.virtualDevice)
+		if err != nil {
+			log.Printf("Failed to open virtual camera: %v", err)
+			reason = fmt.Sprintf("The server's virtual camera can't be opened: %v", err)
+			break
+		}
+		log.Printf("Showing the camera of client %s on %s", client.id, c.virtualDevice)
+		c.virtual, c.sender = virtual, client
+	}
+	if reason != "" {
+		if c.refused == nil {
+			c.refused = make(map[*Client]bool)
+		}
+		c.refused[client] = true
+		s.sendCameraError(client, protocol.ErrorVirtualCameraUnavailable, reason)
+		return
+	}
+
+	if err := c.virtual.Write(frame); err != nil {
+		log.Printf("Error writing to virtual camera: %v", err)
+		c.virtual.Close()
+		c.virtual, c.sender = nil, nil
+	}
+}
+
+// cameraClientGone stops streaming the webcam to a disconnected client and
+// frees the virtual camera if it fed it
+func (s *Server) cameraClientGone(client *Client) {
+	c := &s.camera
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.viewers, client)
+	delete(c.refused, client)
+	if c.sender == client {
+		log.Printf("Client %s stopped sending its camera", client.id)
+		c.virtual.Close()
+		c.virtual, c.sender = nil, nil
+	}
+}
+
+// stopCamera closes the webcam and the virtual camera
+func (s *Server) stopCamera() {
+	c := &s.camera
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	clear(c.viewers)
+	if c.capture != nil {
+		c.capture.Close()
+		c.capture = nil
+	}
+	if c.virtual != nil {
+		c.virtual.Close()
+		c.virtual, c.sender = nil, nil
+	}
+}
+
+// sendCameraError tells a client why it can't use a camera
+func (s *Server) sendCameraError(client *Client, code protocol.ErrorCode, message string) {
+	packet := protocol.NewPacket(protocol.PacketTypeServerError,
+		protocol.EncodeServerError(protocol.ServerError{Code: code, Message: message}))
+	if err := client.send(packet); err != nil {
+		log.Printf("Error sending camera error to client %s: %v", client.id, err)
+	}
+}
@@ -0,0 +1,481 @@
+package server
+
+import (
+	"log"
+	"github.com/kbinani/screenshot"
+	"image"
+	"fmt"
+	"time"
+	"github.com/moderniselife/ultrardp/bandwidth"
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// startScreenCapture begins capturing and encoding screen content
+func (s *Server) startScreenCapture() {
+	s.openFrameDump()
+
+	// Without the permission to record the screen frames are black
+	s.checkScreenRecording()
+
+	// Probe which capture APIs work here once for all monitors
+	s.probeScreenCapture()
+
+	// Create a capture routine for each monitor
+	s.monitorsMutex.Lock()
+	for _, monitor := range s.monitors.Monitors {
+		s.startCapture(monitor)
+	}
+	s.monitorsMutex.Unlock()
+	go s.forwardCursor()
+	go s.watchMonitors()
+}
+
+// startCapture starts the capture routine of a monitor, with monitorsMutex
+// held
+func (s *Server) startCapture(monitor protocol.MonitorInfo) {
+	if s.captures == nil {
+		s.captures = make(map[uint32]chan struct{})
+	}
+	stop := make(chan struct{})
+	s.captures[monitor.ID] = stop
+	go s.captureMonitor(monitor, stop)
+}
+
+// stopCapture stops the capture routine of a monitor, with monitorsMutex
+// held
+func (s *Server) stopCapture(monitorID uint32) {
+	if stop, ok := s.captures[monitorID]; ok {
+		close(stop)
+		delete(s.captures, monitorID)
+	}
+}
+
+// captureMonitor captures and encodes frames from a single monitor until
+// stop is closed
+func (s *Server) captureMonitor(monitor protocol.MonitorInfo, stop <-chan struct{}) {
+	log.Printf("Started capture for monitor %d (%dx%d) at position (%d,%d)", 
+		monitor.ID, monitor.Width, monitor.Height, monitor.PositionX, monitor.PositionY)
+
+	// Encoders for the codecs the clients of this monitor receive
+	encoders := newMonitorEncoders(monitor.ID)
+	defer encoders.close()
+	
+	// Capture frame counter for this monitor
+	frameCount := 0
+
+	// Check if monitor coordinates look valid
+	isValidCoords := true
+	if monitor.PositionX > 10000 || monitor.PositionY > 10000 {
+		log.Printf("WARNING: Invalid monitor coordinates detected for monitor %d: (%d,%d)",
+			monitor.ID, monitor.PositionX, monitor.PositionY)
+		isValidCoords = false
+	}
+
+	// Give server time to initialize and accept client connections
+	time.Sleep(1 * time.Second)
+
+	framesSent := 0
+	lastClientCountLog := time.Now()
+
+	// Trades quality and frame rate for staying under a bandwidth limit
+	limiter := bandwidth.NewLimiter(s.frameInterval(monitor.ID))
+
+	// Starts frames at the target frame rate, slower while idle
+	var clock frameClock
+	idle := idleThrottle{monitorID: monitor.ID, changed: time.Now()}
+
+	// Detects frames identical to the last one sent
+	var unchanged unchangedFrames
+	var lastROI image.Rectangle // Region of interest of the last frame encoded
+
+	// Frames stay in GPU memory on the GPU pipeline
+	gpu := s.openGPUCapture(monitor)
+	defer func() {
+		if gpu != nil {
+			gpu.close()
+		}
+	}()
+
+	// Otherwise a platform capture API is faster than the screenshot
+	// package where there is one
+	var screen screenCapturer
+	screenCursor := false // Whether its frames include the cursor
+	captured, crop, capturable := s.capturedMonitor(monitor)
+	var region regionFrame
+	if gpu == nil && capturable {
+		screen, screenCursor = s.openScreenCapture(captured)
+	}
+	defer func() {
+		if screen != nil {
+			screen.close()
+		}
+	}()
+
+	// Draws the cursor into frames captured without it
+	var overlay cursorOverlay
+	origin := image.Pt(int(int32(monitor.PositionX)), int(int32(monitor.PositionY)))
+
+	for !s.stopped {
+		select {
+		case <-stop:
+			log.Printf("Stopped capture for monitor %d", monitor.ID)
+			return
+		default:
+		}
+
+		var img image.Image
+		var err error
+		changed := true // Whether the frame may differ from the last one captured
+
+		// Capture APIs update the last frame, without the cursor
+		overlay.restore()
+		
+		// Wait for at least one client to connect before starting to capture
+		s.clientsMutex.Lock()
+		clientCount := len(s.clients)
+		s.clientsMutex.Unlock()
+		
+		if clientCount == 0 {
+			if time.Since(lastClientCountLog) > 5*time.Second {
+				log.Printf("No clients connected, waiting for connection before capturing monitor %d...", 
+					monitor.ID)
+				lastClientCountLog = time.Now()
+			}
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		
+		// Don't stream the lock screen, clients were sent a placeholder
+		if s.isPaused() {
+			unchanged.reset()
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		
+		// Log client count occasionally
+		if time.Since(lastClientCountLog) > 10*time.Second {
+			log.Printf("Currently serving %d clients for monitor %d", clientCount, monitor.ID)
+			lastClientCountLog = time.Now()
+		}
+		
+		// Use different capture methods based on the monitor
+		displayIndex := int(monitor.ID) - 1 // Convert 1-based ID to 0-based index
+		
+		if gpu != nil {
+			surface, gpuErr := gpu.capture()
+			if gpuErr != nil {
+				log.Printf("GPU capture of monitor %d failed, capturing it to memory: %v", monitor.ID, gpuErr)
+				gpu.close()
+				gpu = nil
+				screen, screenCursor = s.openScreenCapture(monitor)
+				continue
+			}
+			if surface == nil {
+				// No frame yet
+				clock.wait(limiter.Interval(), nil)
+				continue
+			}
+			img = surface
+		} else if s.captureSource != nil {
+			img, err = s.captureSource.Capture(monitor)
+		} else if screen != nil {
+			if t, ok := screen.(toneMappingCapturer); ok {
+				t.setToneMapping(s.toneMapping(monitor.ID))
+			}
+			var screenErr error
+			img, changed, screenErr = screen.capture()
+			if screenErr != nil {
+				if s.screenshots {
+					log.Printf("Capture of monitor %d failed, capturing it with screenshots: %v", monitor.ID, screenErr)
+				} else {
+					log.Printf("Capture of monitor %d failed: %v", monitor.ID, screenErr)
+				}
+				screen.close()
+				screen, screenCursor = nil, false
+				continue
+			}
+			if img == nil {
+				// No frame yet
+				clock.wait(limiter.Interval(), nil)
+				continue
+			}
+			if !crop.Empty() {
+				img = region.crop(img, crop)
+			}
+		} else if !s.screenshots && capturable {
+			// Screenshots weren't asked for, try the capture APIs again
+			time.Sleep(screenCaptureRetry)
+			screen, screenCursor = s.openScreenCapture(captured)
+			continue
+		} else if isValidCoords {
+			// Try with coordinates first if they seem valid
+			bound := image.Rect(int(monitor.PositionX), int(monitor.PositionY),
+				int(monitor.PositionX)+int(monitor.Width), int(monitor.PositionY)+int(monitor.Height))
+			
+			if frameCount % 30 == 0 {
+				log.Printf("Capturing monitor %d with bounds: %v", monitor.ID, bound)
+			}
+			img, err = screenshot.CaptureRect(bound)
+		} else {
+			// For monitors with suspect coordinates, use display index directly
+			if displayIndex >= 0 && displayIndex < screenshot.NumActiveDisplays() {
+				if frameCount % 30 == 0 {
+					log.Printf("Capturing monitor %d using display index %d", monitor.ID, displayIndex)
+				}
+				img, err = screenshot.CaptureDisplay(displayIndex)
+			} else {
+				log.Printf("Invalid display index %d (num displays: %d)", 
+					displayIndex, screenshot.NumActiveDisplays())
+				time.Sleep(1 * time.Second)
+				continue
+			}
+		}
+		
+		if err != nil {
+			log.Printf("Error capturing screen: %v", err)
+			
+			// Try fallback if primary method fails
+			if s.captureSource == nil && isValidCoords && displayIndex >= 0 && displayIndex < screenshot.NumActiveDisplays() {
+				log.Printf("Trying fallback capture for display %d", displayIndex)
+				img, err = screenshot.CaptureDisplay(displayIndex)
+				if err != nil {
+					log.Printf("Fallback capture also failed: %v", err)
+					time.Sleep(1 * time.Second) // Wait longer after error
+					continue
+				}
+			} else {
+				time.Sleep(1 * time.Second)
+				continue
+			}
+		}
+		
+		// Draw the cursor where the capture API left it out
+		if s.cursorMode == CursorComposite && gpu == nil && !screenCursor && s.captureSource == nil {
+			if cursor, ok := s.currentCursor(); ok && overlay.draw(img, cursor, origin) {
+				changed = true
+			}
+		}
+
+		// Save a debug capture occasionally, unless the frame is in GPU
+		// memory where it must not be copied
+		frameCount++
+		if s.frameDump.Enabled() && frameCount % 30 == 0 {
+			if _, onGPU := img.(codec.Surface); !onGPU {
+				s.frameDump.WriteImage(fmt.Sprintf("capture_mon%d_%d.png", monitor.ID, frameCount), img)
+			}
+		}
+
+		// Check if the image is valid and not empty
+		bounds := img.Bounds()
+		if bounds.Empty() {
+			log.Printf("Warning: Empty image captured for monitor %d", monitor.ID)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		
+		// Frames that may have changed are hashed, the hash skips unchanged
+		// frames below and tells black ones apart
+		unchanged.captured(changed)
+		if unchanged.black(img) {
+			log.Printf("Warning: Black image captured for monitor %d", monitor.ID)
+			s.reportScreenPermission()
+			// Try the direct method if we're still getting black images
+			if s.captureSource == nil && isValidCoords && frameCount % 10 == 0 {
+				log.Printf("Trying alternative capture method for monitor %d", monitor.ID)
+				if displayIndex >= 0 && displayIndex < screenshot.NumActiveDisplays() {
+					altImg, altErr := screenshot.CaptureDisplay(displayIndex)
+					if altErr == nil {
+						img = altImg
+						unchanged.captured(true)
+						if unchanged.black(img) {
+							log.Printf("Alternative method also produced black image for monitor %d", monitor.ID)
+						} else {
+							log.Printf("Alternative method succeeded for monitor %d", monitor.ID)
+						}
+					}
+				}
+			}
+			
+			// Save black images for debugging
+			if s.frameDump.Enabled() && frameCount % 5 == 0 {
+				s.frameDump.WriteImage(fmt.Sprintf("black_mon%d_%d.png", monitor.ID, frameCount), img)
+			}
+		}
+
+		// Encode once per stream the clients receive, at the quality of
+		// each client's ladder level, lower if a bandwidth limit or a
+		// client requires it
+		limiter.SetLimit(s.videoLimit())
+		limiter.SetMinInterval(s.frameInterval(monitor.ID))
+		quality := s.videoQuality(limiter.Quality())
+		streams, refresh := s.streamsNeeded(encoders, frameCount)
+		encoders.keyframeInterval = int(s.keyframeInterval.Load())
+		encoders.rateControl = s.rateControlFor(monitor.ID)
+		if scale := s.captureScale(monitor.ID); scale != encoders.captureScale {
+			// Frames at the new size are sent even if nothing changed
+			log.Printf("Encoding monitor %d at %.0f%% of its size", monitor.ID, scale*100)
+			encoders.captureScale = scale
+			unchanged.reset()
+		}
+		if len(streams) == 0 {
+			clock.wait(s.idleInterval(&idle, limiter.Interval()))
+			continue
+		}
+
+		// An idle screen isn't sent again, its clients only hear that
+		// nothing changed, unless an encoder still has parts to send or
+		// the cursor moved to parts encoded at a lower quality
+		roi := s.regionOfInterest(monitor.ID)
+		if unchanged.skip(img, quality, refresh || encoders.deferred() || roi != lastROI && encoders.focused()) {
+			s.sendUnchanged(monitor.ID, &unchanged)
+			clock.wait(s.idleInterval(&idle, limiter.Interval()))
+			continue
+		}
+
+		idle.change()
+		lastROI = roi
+
+		// Text is sent as PNG to the JPEG clients that can decode it
+		needed := make(map[stream]bool, len(streams))
+		var jpegLevels []int
+		for _, st := range streams {
+			needed[st] = true
+			if st.codec == codec.JPEG {
+				jpegLevels = append(jpegLevels, st.level)
+			}
+		}
+		text := s.textPNG && len(jpegLevels) > 0 && codec.IsText(codec.InMemory(img))
+		if text {
+			for _, level := range jpegLevels {
+				needed[stream{codec.PNG, level}] = true
+			}
+		}
+
+		encoded := encoders.encode(img, quality, roi, needed)
+		if len(encoded) == 0 {
+			continue
+		}
+		largest := 0
+		for _, frame := range encoded {
+			largest = max(largest, len(frame))
+		}
+		limiter.Observe(largest)
+		
+		// Save JPEG occasionally to verify encoding
+		if s.frameDump.Enabled() && frameCount % 30 == 0 {
+			if jpegFrame, ok := encoded[stream{codec.JPEG, 0}]; ok {
+				s.frameDump.WriteBytes(fmt.Sprintf("encoded_mon%d_%d.jpg", monitor.ID, frameCount), jpegFrame)
+			}
+		}
+
+		// Prepare frame packets: the monitor ID followed by the frame
+		frames := make(map[stream][]byte, len(encoded))
+		for st, frame := range encoded {
+			frameData := make([]byte, 4+len(frame))
+			copy(frameData[0:4], protocol.Uint32ToBytes(monitor.ID))
+			copy(frameData[4:], frame)
+			frames[st] = frameData
+		}
+
+		// The screen may have been locked while this frame was captured
+		if s.isPaused() {
+			continue
+		}
+
+		// Remember the frame for clients that connect later, the best
+		// JPEG one as only JPEG frames can be shown on their own
+		for level := range bandwidth.Ladder {
+			if frameData, ok := frames[stream{codec.JPEG, level}]; ok {
+				s.cacheFrame(monitor.ID, frameData)
+				break
+			}
+		}
+
+		// Pick the frame of every client under the lock and send after
+		// releasing it, so a slow write to one client delays neither the
+		// other monitors' capture loops nor anything else taking the lock.
+		// Writes are serialized per client.
+		type delivery struct {
+			client    *Client
+			frameData []byte
+		}
+		var deliveries []delivery
+		s.clientsMutex.Lock()
+		for _, client := range s.clients {
+			if !client.active {
+				continue
+			}
+
+			// Check if this monitor is mapped for this client
+			clientMonitorID, ok := client.monitorMap[monitor.ID]
+			if !ok {
+				// This is a common case if monitor counts don't match, so no need to log every time
+				continue
+			}
+
+			// Log monitor mapping occasionally
+			if frameCount % 30 == 0 {
+				log.Printf("Sending frame %d for server monitor %d to client %s (mapped to client monitor %d)",
+					frameCount, monitor.ID, client.id, clientMonitorID)
+			}
+
+			// The client may have switched codecs since encoding, or
+			// connected, or its level may skip this frame
+			st, ok := streams[client]
+			if !ok {
+				continue
+			}
+			st.codec = frameCodec(client, monitor.ID, text)
+			if frameData, ok := frameFor(frames, st); ok {
+				deliveries = append(deliveries, delivery{client, frameData})
+			}
+		}
+		s.clientsMutex.Unlock()
+
+		// Track clients that received the frame
+		clientsReceived := 0
+		for _, d := range deliveries {
+			client, frameData := d.client, d.frameData
+			sent, err := s.sendFrame(client, monitor.ID, frameData)
+			if err != nil {
+				log.Printf("Error sending frame to client %s: %v", client.id, err)
+			} else if sent {
+				clientsReceived++
+				if frameCount % 30 == 0 {
+					log.Printf("Successfully sent frame %d for monitor %d to client %s (size: %d bytes)",
+						frameCount, monitor.ID, client.id, len(frameData))
+				}
+				continue
+			}
+
+			s.clientsMutex.Lock()
+			if err != nil {
+				client.active = false
+			} else {
+				// Video codec frames depend on earlier ones, after a
+				// skipped frame the client can only resume at a
+				// keyframe. JPEG clients need the next frame too, even
+				// if the screen doesn't change until then.
+				client.keyframeNeeded[monitor.ID] = true
+			}
+			s.clientsMutex.Unlock()
+		}
+		
+		// Update sent counter if any clients received the frame
+		if clientsReceived > 0 {
+			framesSent++
+			if framesSent % 30 == 0 {
+				log.Printf("Monitor %d: Sent %d frames to %d clients", 
+					monitor.ID, framesSent, clientsReceived)
+			}
+		} else if clientCount > 0 && frameCount % 10 == 0 {
+			// This suggests a mapping issue
+			log.Printf("Warning: No clients received frame for monitor %d despite %d clients being connected",
+				monitor.ID, clientCount)
+		}
+
+		// Wait for the next frame at the target frame rate, less if limited
+		clock.wait(s.idleInterval(&idle, limiter.Interval()))
+	}
+}
\ No newline at end of file
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/clipboard"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// clipboardPollInterval is how often the local clipboard is checked for changes
+const clipboardPollInterval = 500 * time.Millisecond
+
+// WithClipboardSync enables text clipboard synchronization with clients
+func WithClipboardSync(enabled bool) Option {
+	return func(s *Server) {
+		if enabled {
+			s.clipboard = clipboard.NewSync(clipboard.OwnerServer, clipboard.DefaultHistorySize)
+		} else {
+			s.clipboard = nil
+		}
+	}
+}
+
+// pollClipboard sends local clipboard changes to all clients
+func (s *Server) pollClipboard() {
+	ticker := time.NewTicker(clipboardPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		text, err := clipboard.Read()
+		if err != nil {
+			log.Printf("Clipboard unavailable, disabling clipboard sync: %v", err)
+			return
+		}
+
+		update, changed := s.clipboard.LocalChange(text)
+		if !changed {
+			continue
+		}
+		s.broadcastBulk(nil, protocol.NewPacket(protocol.PacketTypeClipboard, clipboard.EncodeUpdate(update)))
+	}
+}
+
+// handleClipboard applies a clipboard update from a client and forwards it
+// to the other clients
+func (s *Server) handleClipboard(client *Client, payload []byte) {
+	if s.clipboard == nil {
+		return
+	}
+
+	update, err := clipboard.DecodeUpdate(payload)
+	if err != nil {
+		log.Printf("Invalid clipboard packet from client %s: %v", client.id, err)
+		return
+	}
+
+	if !s.clipboard.RemoteUpdate(update) {
+		return
+	}
+
+	if err := clipboard.Write(update.Text); err != nil {
+		log.Printf("Failed to write clipboard: %v", err)
+	}
+	s.broadcastBulk(client, protocol.NewPacket(protocol.PacketTypeClipboard, payload))
+}
+
+// sendClipboard sends the current clipboard to a newly connected client
+func (s *Server) sendClipboard(client *Client) {
+	if s.clipboard == nil {
+		return
+	}
+
+	if update, ok := s.clipboard.Current(); ok {
+		s.queueBulk(client, protocol.NewPacket(protocol.PacketTypeClipboard, clipboard.EncodeUpdate(update)))
+	}
+}
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"image"
+	"log"
+
+	"github.com/moderniselife/ultrardp/bandwidth"
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithCodecs sets the codecs the server may encode video with, most
+// preferred first. Each client gets the first of the codecs it can decode
+// that is on the list; clients that don't negotiate get JPEG.
+func WithCodecs(codecs []codec.ID) Option {
+	return func(s *Server) {
+		s.codecs = codecs
+	}
+}
+
+// handleCodecs selects the codec of each monitor for a client from the
+// codecs it can decode and tells it which ones were chosen
+func (s *Server) handleCodecs(client *Client, payload []byte) {
+	clientCodecs := make([]codec.ID, len(payload))
+	for i, id := range payload {
+		clientCodecs[i] = codec.ID(id)
+	}
+	selected := codec.Select(clientCodecs, s.codecs)
+
+	// The capture loops read the codecs under the clients lock, and must
+	// not send frames of a new codec before the client knows about it
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	client.offered = clientCodecs
+	for monitorID := range client.monitorMap {
+		s.setCodec(client, monitorID, selected)
+	}
+}
+
+// setCodec switches the codec of a monitor for a client. The clients lock
+// must be held.
+func (s *Server) setCodec(client *Client, monitorID uint32, id codec.ID) {
+	if client.codecs[monitorID] == id {
+		return
+	}
+	if err := s.sendCodec(client, monitorID, id); err != nil {
+		log.Printf("Error sending codec selection to client %s: %v", client.id, err)
+		return
+	}
+	// A video codec's stream can only be joined at a keyframe
+	client.keyframeNeeded[monitorID] = true
+	client.codecs[monitorID] = id
+}
+
+// sendCodec tells a client the codec a monitor is sent in
+func (s *Server) sendCodec(client *Client, monitorID uint32, id codec.ID) error {
+	packet := protocol.NewPacket(protocol.PacketTypeCodecSelect, protocol.EncodeCodecSelect(monitorID, byte(id)))
+	if err := client.send(packet); err != nil {
+		return err
+	}
+	log.Printf("Client %s receives monitor %d as %s", client.id, monitorID, id)
+	return nil
+}
+
+// handleQualityControl records the video quality a client asked for, and
+// the rate control if the packet has one. Video encoders turn the quality
+// into a bitrate for the monitor's size unless a bitrate is set.
+func (s *Server) handleQualityControl(client *Client, payload []byte) {
+	qc, err := protocol.DecodeQualityControl(payload)
+	if err != nil {
+		log.Printf("Invalid quality control packet from client %s", client.id)
+		return
+	}
+	quality := min(max(qc.Quality, 1), 100)
+	client.quality.Store(int32(quality))
+	if len(payload) == 1 {
+		log.Printf("Client %s requested quality %d", client.id, quality)
+		return
+	}
+
+	rc := codec.RateControl{Mode: codec.RateMode(qc.RateMode), Bitrate: int(qc.Bitrate) * 1000}
+	if rc.Mode > codec.RateCQ {
+		log.Printf("Client %s requested unknown rate control mode %d", client.id, qc.RateMode)
+		rc.Mode = codec.RateDefault
+	}
+	s.clientsMutex.Lock()
+	client.rateControl = rc
+	s.clientsMutex.Unlock()
+	log.Printf("Client %s requested quality %d, %s rate control at %d kbit/s", client.id, quality, rc.Mode, qc.Bitrate)
+	s.setClientCaptureScales(client, qc.Scales)
+}
+
+// videoQuality returns the quality to encode the next frame with: the
+// bandwidth limiter's unless a client asked for less. Clients share
+// streams, so the lowest request wins. Ladder levels lower it further.
+func (s *Server) videoQuality(limited int) int {
+	quality := limited
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	for _, client := range s.clients {
+		if requested := int(client.quality.Load()); requested > 0 && requested < quality {
+			quality = requested
+		}
+	}
+	return quality
+}
+
+// stream is a version of a monitor's video: a codec at a level of the
+// adaptive quality ladder, see bandwidth.Ladder
+type stream struct {
+	codec codec.ID
+	level int
+}
+
+// monitorEncoders holds the encoders of one monitor, one per stream the
+// monitor's clients receive. Each frame is encoded once per stream.
+type monitorEncoders struct {
+	monitorID uint32
+	encoders  map[stream]sizedEncoder
+	failed    map[codec.ID]bool // Codecs whose encoder couldn't be created
+
+	keyframeInterval int               // Frames between keyframes, 0 for keyframes only on request
+	sinceKeyframe    map[stream]int    // Frames each encoder produced since its last keyframe
+	rateControl      codec.RateControl // Rate control of the video encoders, see Server.rateControlFor
+	captureScale     float64           // Scale of frames before the ladder level's, see Server.captureScale
+}
+
+// sizedEncoder is an encoder and the frame size it was created for
+type sizedEncoder struct {
+	codec.Encoder
+	size image.Point
+}
+
+func newMonitorEncoders(monitorID uint32) *monitorEncoders {
+	return &monitorEncoders{
+		monitorID: monitorID,
+		encoders:  make(map[stream]sizedEncoder),
+		failed:    make(map[codec.ID]bool),
+
+		sinceKeyframe: make(map[stream]int),
+		captureScale:  1,
+	}
+}
+
+// streamsNeeded returns the stream each active client of a monitor
+// receives the frameCount-th frame in, requesting keyframes for clients
+// that need one, and whether any did. Clients receive the simulcast tier
+// of their codec that fits their ladder level, see simulcastTiers, and
+// are left out if the tier drops the frame. Clients of a codec this
+// monitor failed to encode are moved to the next codec they support.
+func (s *Server) streamsNeeded(e *monitorEncoders, frameCount int) (streams map[*Client]stream, refresh bool) {
+	streams = make(map[*Client]stream)
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	wanted := make(map[*Client]stream)
+	levels := make(map[codec.ID][]int)
+	for _, client := range s.clients {
+		if _, ok := client.monitorMap[e.monitorID]; !ok || !client.active {
+			continue
+		}
+		id := client.codecs[e.monitorID]
+		if e.failed[id] {
+			id = codec.Select(client.offered, e.working(s.codecs))
+			s.setCodec(client, e.monitorID, id)
+		}
+		level := client.adapter.Level()
+		wanted[client] = stream{id, level}
+		levels[id] = append(levels[id], level)
+	}
+	tiers := make(map[codec.ID][]int, len(levels))
+	for id, l := range levels {
+		tiers[id] = simulcastTiers(l, s.simulcastTiers)
+	}
+
+	for client, want := range wanted {
+		id := want.codec
+
+		// A new tier is a new stream, joined at a keyframe
+		level := tierFor(tiers[id], want.level)
+		if client.levels[e.monitorID] != level {
+			client.levels[e.monitorID] = level
+			client.keyframeNeeded[e.monitorID] = true
+		}
+		if frameCount%bandwidth.Ladder[level].Divisor != 0 {
+			continue
+		}
+
+		st := stream{id, level}
+		streams[client] = st
+		if client.keyframeNeeded[e.monitorID] {
+			delete(client.keyframeNeeded, e.monitorID)
+			refresh = true
+			if encoder, ok := e.encoders[st]; ok {
+				encoder.RequestKeyframe()
+				e.sinceKeyframe[st] = 0
+			}
+		}
+	}
+	return streams, refresh
+}
+
+// working returns the codecs of a list this monitor didn't fail to encode
+func (e *monitorEncoders) working(ids []codec.ID) []codec.ID {
+	var working []codec.ID
+	for _, id := range ids {
+		if !e.failed[id] {
+			working = append(working, id)
+		}
+	}
+	return working
+}
+
+// encode compresses a frame for every needed stream, scaled and at the
+// quality of the stream's level but no higher than quality, favoring the
+// region of interest roi given in monitor pixels. If a codec fails the
+// frame is encoded as JPEG at that level too, which every client can show,
+// see frameFor.
+func (e *monitorEncoders) encode(img image.Image, quality int, roi image.Rectangle, needed map[stream]bool) map[stream][]byte {
+	frames := make(map[stream][]byte, len(needed))
+	scaled := make(map[float64]image.Image)
+	encode := func(st stream) error {
+		level := bandwidth.Ladder[st.level]
+		scale := level.Scale * e.captureScale
+		frame, ok := scaled[scale]
+		if !ok {
+			frame = scaleFrame(img, scale)
+			scaled[scale] = frame
+		}
+		q := min(quality, level.Quality)
+		encoded, err := e.encodeWith(st, frame, q, e.rateFor(q, scale), scaleRect(roi, scale, frame.Bounds().Min))
+		if err != nil {
+			return err
+		}
+		frames[st] = encoded
+		return nil
+	}
+
+	jpegNeeded := make(map[stream]bool)
+	for st := range needed {
+		if st.codec == codec.JPEG {
+			jpegNeeded[st] = true
+		} else if err := encode(st); err != nil {
+			jpegNeeded[stream{codec.JPEG, st.level}] = true
+		}
+	}
+	for st := range jpegNeeded {
+		encode(st)
+	}
+
+	// Release encoders no client uses anymore
+	for st, encoder := range e.encoders {
+		if !needed[st] && !jpegNeeded[st] {
+			encoder.Close()
+			delete(e.encoders, st)
+			delete(e.sinceKeyframe, st)
+		}
+	}
+	return frames
+}
+
+// encodeWith compresses a frame for one stream. Encoders are created on
+// first use and recreated when the frame size changes.
+func (e *monitorEncoders) encodeWith(st stream, img image.Image, quality int, rc codec.RateControl, roi image.Rectangle) ([]byte, error) {
+	id := st.codec
+	if e.failed[id] {
+		return nil, codec.ErrUnsupported
+	}
+
+	size := img.Bounds().Size()
+	encoder, ok := e.encoders[st]
+	if ok && encoder.size != size {
+		encoder.Close()
+		delete(e.encoders, st)
+		ok = false
+	}
+	if !ok {
+		created, err := codec.NewEncoder(id, size.X, size.Y)
+		if err != nil {
+			log.Printf("Failed to create %s encoder for monitor %d, switching its clients to another codec: %v", id, e.monitorID, err)
+			e.failed[id] = true
+			return nil, err
+		}
+		encoder = sizedEncoder{created, size}
+		e.encoders[st] = encoder
+		e.sinceKeyframe[st] = 0
+	}
+	if e.keyframeInterval > 0 && e.sinceKeyframe[st] >= e.keyframeInterval {
+		encoder.RequestKeyframe()
+		e.sinceKeyframe[st] = 0
+	}
+
+	encoder.SetQuality(quality)
+	if r, ok := encoder.Encoder.(codec.RateController); ok {
+		r.SetRateControl(rc)
+	}
+	if r, ok := encoder.Encoder.(codec.RegionEncoder); ok {
+		r.SetRegionOfInterest(roi)
+	}
+
+	// Frames in GPU memory go to the encoders that take them as they are,
+	// the others get a copy in memory
+	var frame []byte
+	var err error
+	if surface, ok := img.(codec.Surface); ok {
+		if s, ok := encoder.Encoder.(codec.SurfaceEncoder); ok {
+			frame, err = s.EncodeSurface(surface)
+		} else {
+			frame, err = encoder.Encode(surface.Pixels())
+		}
+	} else {
+		frame, err = encoder.Encode(img)
+	}
+	if err != nil {
+		log.Printf("Error encoding %s frame for monitor %d: %v", id, e.monitorID, err)
+		return nil, err
+	}
+	e.sinceKeyframe[st]++
+	return frame, nil
+}
+
+// deferred reports whether an encoder holds back parts of the last frame,
+// see codec.DeferringEncoder
+func (e *monitorEncoders) deferred() bool {
+	for _, encoder := range e.encoders {
+		if d, ok := encoder.Encoder.(codec.DeferringEncoder); ok && d.Deferred() {
+			return true
+		}
+	}
+	return false
+}
+
+// focused reports whether an encoder favors the region of interest, see
+// codec.RegionEncoder
+func (e *monitorEncoders) focused() bool {
+	for _, encoder := range e.encoders {
+		if _, ok := encoder.Encoder.(codec.RegionEncoder); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// frameFor returns the frame to send a client, falling back to JPEG at the
+// same level if its codec couldn't be encoded
+func frameFor(frames map[stream][]byte, st stream) ([]byte, bool) {
+	if frame, ok := frames[st]; ok {
+		return frame, true
+	}
+	frame, ok := frames[stream{codec.JPEG, st.level}]
+	return frame, ok
+}
+
+// close releases every encoder
+func (e *monitorEncoders) close() {
+	for st, encoder := range e.encoders {
+		encoder.Close()
+		delete(e.encoders, st)
+	}
+}
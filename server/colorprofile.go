@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// errNoColorProfile is returned for displays without a color profile
+var errNoColorProfile = errors.New("display has no color profile")
+
+// WithColorProfiles sends clients the ICC color profile of each monitor
+// after the handshake, so they can show colors as they look here
+func WithColorProfiles(enabled bool) Option {
+	return func(s *Server) {
+		s.sendProfiles = enabled
+	}
+}
+
+// loadColorProfiles reads the color profiles of the monitors. Monitors of
+// a capture source have none.
+func (s *Server) loadColorProfiles() {
+	profiles := make(map[uint32][]byte)
+	defer func() {
+		s.monitorsMutex.Lock()
+		s.colorProfiles = profiles
+		s.monitorsMutex.Unlock()
+	}()
+	if !s.sendProfiles || s.captureSource != nil {
+		return
+	}
+	for i, monitor := range s.monitorConfig().Monitors {
+		profile, err := displayColorProfile(i)
+		if err != nil {
+			if !errors.Is(err, errNoColorProfile) {
+				log.Printf("Failed to read the color profile of monitor %d: %v", monitor.ID, err)
+			}
+			continue
+		}
+		profiles[monitor.ID] = profile
+		log.Printf("Monitor %d has a %d byte color profile", monitor.ID, len(profile))
+	}
+}
+
+// sendColorProfiles sends a client the color profiles of the monitors in
+// its monitor map
+func (s *Server) sendColorProfiles(client *Client, monitorMap map[uint32]uint32) {
+	s.monitorsMutex.Lock()
+	profiles := s.colorProfiles
+	s.monitorsMutex.Unlock()
+	for monitorID, profile := range profiles {
+		if _, ok := monitorMap[monitorID]; !ok {
+			continue
+		}
+		packet := protocol.NewPacket(protocol.PacketTypeColorProfile, protocol.EncodeColorProfile(monitorID, profile))
+		if err := client.send(packet); err != nil {
+			log.Printf("Error sending color profile to client %s: %v", client.id, err)
+			return
+		}
+	}
+}
+
+// displayColorProfile asks the operating system for the ICC profile of the
+// display at an index, in the order of the monitors
+func displayColorProfile(index int) ([]byte, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf(`ObjC.import("AppKit");
+var screen = $.NSScreen.screens.js[%d];
+screen ? screen.colorSpace.ICCProfileData.base64EncodedStringWithOptions(0).js : ""`, index)
+		out, err := exec.Command("osascript", "-l", "JavaScript", "-e", script).Output()
+		if err != nil {
+			return nil, err
+		}
+		encoded := strings.TrimSpace(string(out))
+		if encoded == "" {
+			return nil, errNoColorProfile
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+
+	case "linux":
+		// Color managers publish the profiles on the X root window, the
+		// first monitor's without a suffix
+		atom := "_ICC_PROFILE"
+		if index > 0 {
+			atom += "_" + strconv.Itoa(index)
+		}
+		out, err := exec.Command("xprop", "-root", "-notype", atom).Output()
+		if err != nil {
+			return nil, err
+		}
+		_, values, ok := strings.Cut(string(out), "=")
+		if !ok {
+			return nil, errNoColorProfile
+		}
+		var profile []byte
+		for _, value := range strings.Split(values, ",") {
+			b, err := strconv.ParseUint(strings.TrimSpace(value), 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected xprop output: %w", err)
+			}
+			profile = append(profile, byte(b))
+		}
+		return profile, nil
+
+	case "windows":
+		return windowsColorProfile(index)
+	}
+
+	return nil, errNoColorProfile
+}
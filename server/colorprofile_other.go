@@ -0,0 +1,8 @@
+//go:build !windows
+
+package server
+
+// windowsColorProfile is only available on Windows
+func windowsColorProfile(index int) ([]byte, error) {
+	return nil, errNoColorProfile
+}
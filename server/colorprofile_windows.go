@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	gdi32              = syscall.NewLazyDLL("gdi32.dll")
+	procCreateDCW      = gdi32.NewProc("CreateDCW")
+	procDeleteDC       = gdi32.NewProc("DeleteDC")
+	procGetICMProfileW = gdi32.NewProc("GetICMProfileW")
+)
+
+// windowsColorProfile reads the profile file Windows associates with the
+// display device at an index
+func windowsColorProfile(index int) ([]byte, error) {
+	driver, err := syscall.UTF16PtrFromString("DISPLAY")
+	if err != nil {
+		return nil, err
+	}
+	device, err := syscall.UTF16PtrFromString(fmt.Sprintf(`\\.\DISPLAY%d`, index+1))
+	if err != nil {
+		return nil, err
+	}
+	hdc, _, err := procCreateDCW.Call(uintptr(unsafe.Pointer(driver)), uintptr(unsafe.Pointer(device)), 0, 0)
+	if hdc == 0 {
+		return nil, fmt.Errorf("CreateDC failed: %w", err)
+	}
+	defer procDeleteDC.Call(hdc)
+
+	var path [syscall.MAX_PATH]uint16
+	size := uint32(len(path))
+	if ok, _, err := procGetICMProfileW.Call(hdc, uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&path[0]))); ok == 0 {
+		return nil, fmt.Errorf("GetICMProfile failed: %w", err)
+	}
+	return os.ReadFile(syscall.UTF16ToString(path[:]))
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/draw"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// CursorMode selects how clients see the server's cursor
+type CursorMode string
+
+const (
+	// CursorComposite draws the cursor into the frames, where the capture
+	// API doesn't already
+	CursorComposite CursorMode = "composite"
+
+	// CursorForward leaves the cursor out of the frames and sends its
+	// shape and position in cursor packets for clients to draw on top.
+	// It moves at the rate the cursor is read rather than the frame rate,
+	// and isn't blurred by the codec.
+	CursorForward CursorMode = "forward"
+
+	// CursorNone shows no cursor where the capture API can leave it out
+	CursorNone CursorMode = "none"
+)
+
+// ParseCursorMode returns the cursor mode with the given name
+func ParseCursorMode(name string) (CursorMode, error) {
+	switch m := CursorMode(name); m {
+	case CursorComposite, CursorForward, CursorNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown cursor mode %q, must be %s, %s or %s", name, CursorComposite, CursorForward, CursorNone)
+}
+
+// WithCursor selects how clients see the cursor, CursorComposite by
+// default
+func WithCursor(mode CursorMode) Option {
+	return func(s *Server) {
+		s.cursorMode = mode
+	}
+}
+
+// cursorPollInterval is how often the cursor is read, capture loops in
+// between share the last read
+const cursorPollInterval = 8 * time.Millisecond
+
+// cursorShape is the image of the cursor
+type cursorShape struct {
+	id      uint32      // Hash of the image and hotspot
+	image   *image.RGBA // From 0, 0
+	hotspot image.Point
+}
+
+// newCursorShape returns a shape identified by its pixels
+func newCursorShape(img *image.RGBA, hotspot image.Point) *cursorShape {
+	h := fnv.New32a()
+	h.Write(img.Pix)
+	size := img.Bounds().Size()
+	h.Write([]byte{byte(size.X), byte(size.X >> 8), byte(size.Y), byte(size.Y >> 8), byte(hotspot.X), byte(hotspot.Y)})
+	return &cursorShape{id: h.Sum32(), image: img, hotspot: hotspot}
+}
+
+// cursorState is the system cursor
+type cursorState struct {
+	position image.Point // Of the hotspot, in desktop coordinates
+	visible  bool
+	shape    *cursorShape
+}
+
+// readCursor reads the system cursor, returning last for its shape if that
+// didn't change; nil on platforms where the cursor can't be read
+var readCursor func(last *cursorShape) (cursorState, error)
+
+// systemCursor is the last read of the system cursor, shared by the
+// capture loops and the forwarding
+type systemCursor struct {
+	mutex  sync.Mutex
+	state  cursorState
+	read   time.Time
+	failed bool // The last read failed, logged once
+}
+
+// currentCursor returns the system cursor, false if it can't be read
+func (s *Server) currentCursor() (cursorState, bool) {
+	if readCursor == nil {
+		return cursorState{}, false
+	}
+	c := &s.systemCursor
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if time.Since(c.read) >= cursorPollInterval {
+		state, err := readCursor(c.state.shape)
+		c.read = time.Now()
+		if err != nil {
+			if !c.failed {
+				log.Printf("Can't read the cursor: %v", err)
+			}
+			c.failed = true
+			c.state = cursorState{}
+			return c.state, false
+		}
+		c.failed = false
+		c.state = state
+	}
+	return c.state, !c.failed
+}
+
+// captureCursor reports whether capture APIs should include the cursor in
+// frames, where they can
+func (s *Server) captureCursor() bool {
+	return s.cursorMode == CursorComposite
+}
+
+// cursorOverlay draws the cursor into the frames of a monitor whose capture
+// API leaves it out. Capture APIs update the same image frame after frame,
+// the pixels under the cursor are put back before the next capture.
+type cursorOverlay struct {
+	img   *image.RGBA     // Frame drawn into, nil if none
+	rect  image.Rectangle // Part of img drawn over
+	saved []byte          // Pixels of rect before drawing
+	last  cursorState     // Drawn into the last frame, relative to the monitor
+}
+
+// restore puts back the pixels the cursor was drawn over
+func (o *cursorOverlay) restore() {
+	if o.img == nil {
+		return
+	}
+	row := o.rect.Dx() * 4
+	for y := o.rect.Min.Y; y < o.rect.Max.Y; y++ {
+		offset := o.img.PixOffset(o.rect.Min.X, y)
+		copy(o.img.Pix[offset:offset+row], o.saved[(y-o.rect.Min.Y)*row:])
+	}
+	o.img = nil
+}
+
+// draw draws the cursor into a frame of the monitor whose top left corner
+// is at origin on the desktop. It returns whether the cursor looks
+// different than in the last frame.
+func (o *cursorOverlay) draw(img image.Image, cursor cursorState, origin image.Point) bool {
+	rgba, ok := img.(*image.RGBA)
+	var rect image.Rectangle
+	if ok && cursor.visible && cursor.shape != nil {
+		cursor.position = cursor.position.Sub(origin)
+		topLeft := rgba.Rect.Min.Add(cursor.position).Sub(cursor.shape.hotspot)
+		rect = cursor.shape.image.Rect.Add(topLeft).Intersect(rgba.Rect)
+		if !rect.Empty() {
+			row := rect.Dx() * 4
+			o.saved = o.saved[:0]
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				offset := rgba.PixOffset(rect.Min.X, y)
+				o.saved = append(o.saved, rgba.Pix[offset:offset+row]...)
+			}
+			draw.Draw(rgba, rect, cursor.shape.image, rect.Min.Sub(topLeft), draw.Over)
+			o.img, o.rect = rgba, rect
+		}
+	}
+	if rect.Empty() {
+		cursor = cursorState{}
+	}
+	changed := cursor != o.last
+	o.last = cursor
+	return changed
+}
+
+// cursorPosition returns where the cursor is relative to the monitor it is
+// on
+func (s *Server) cursorPosition(cursor cursorState) protocol.CursorPosition {
+	position := protocol.CursorPosition{Visible: cursor.visible && cursor.shape != nil}
+	if cursor.shape != nil {
+		position.ShapeID = cursor.shape.id
+	}
+	for _, monitor := range s.monitorConfig().Monitors {
+		origin := image.Pt(int(int32(monitor.PositionX)), int(int32(monitor.PositionY)))
+		bounds := image.Rect(0, 0, int(monitor.Width), int(monitor.Height)).Add(origin)
+		if cursor.position.In(bounds) {
+			position.MonitorID = monitor.ID
+			position.X = int32(cursor.position.X - origin.X)
+			position.Y = int32(cursor.position.Y - origin.Y)
+			return position
+		}
+	}
+	position.Visible = false
+	return position
+}
+
+// forwardCursor sends clients the cursor's shape and position whenever
+// they change, with CursorForward
+func (s *Server) forwardCursor() {
+	if s.cursorMode != CursorForward || s.captureSource != nil {
+		return
+	}
+	if readCursor == nil {
+		log.Printf("The cursor can't be read on this platform, it isn't forwarded")
+		return
+	}
+	ticker := time.NewTicker(cursorPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.stopped {
+			return
+		}
+		cursor, ok := s.currentCursor()
+		if !ok {
+			continue
+		}
+		position := s.cursorPosition(cursor)
+
+		for _, client := range s.activeClients() {
+			s.sendCursor(client, cursor.shape, position)
+		}
+	}
+}
+
+// sendCursor sends a client the cursor's shape and position, those it
+// didn't receive yet
+func (s *Server) sendCursor(client *Client, shape *cursorShape, position protocol.CursorPosition) {
+	if shape != nil && position.Visible && client.cursorShape != shape.id {
+		size := shape.image.Rect.Size()
+		if size.X > protocol.MaxCursorSize || size.Y > protocol.MaxCursorSize {
+			return
+		}
+		pixels := image.NewNRGBA(shape.image.Rect)
+		draw.Draw(pixels, pixels.Rect, shape.image, image.Point{}, draw.Src)
+		payload := protocol.EncodeCursorShape(protocol.CursorShape{
+			ID:       shape.id,
+			HotspotX: shape.hotspot.X,
+			HotspotY: shape.hotspot.Y,
+			Width:    size.X,
+			Height:   size.Y,
+			Pixels:   pixels.Pix,
+		})
+		if err := client.send(protocol.NewPacket(protocol.PacketTypeCursorShape, payload)); err != nil {
+			log.Printf("Error sending cursor to client %s: %v", client.id, err)
+			return
+		}
+		client.cursorShape = shape.id
+	}
+	if client.cursorSent && position == client.cursorPosition {
+		return
+	}
+	if err := client.send(protocol.NewPacket(protocol.PacketTypeCursorPosition, protocol.EncodeCursorPosition(position))); err != nil {
+		log.Printf("Error sending cursor to client %s: %v", client.id, err)
+		return
+	}
+	client.cursorPosition, client.cursorSent = position, true
+}
@@ -0,0 +1,105 @@
+//go:build darwin && cgo
+
+package server
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework AppKit -framework CoreGraphics
+#include <stdlib.h>
+#include <stdint.h>
+#import <AppKit/AppKit.h>
+#import <CoreGraphics/CoreGraphics.h>
+
+// cursor_position reads where the cursor is on the desktop, in points
+static int cursor_position(double *x, double *y) {
+	CGEventRef event = CGEventCreate(NULL);
+	if (event == NULL) {
+		return 0;
+	}
+	CGPoint location = CGEventGetLocation(event);
+	CFRelease(event);
+	*x = location.x;
+	*y = location.y;
+	return 1;
+}
+
+// cursor_image draws the system cursor into premultiplied RGBA pixels,
+// one per point, freed by the caller. It returns 0 if there is no cursor.
+static int cursor_image(uint8_t **pixels, int *width, int *height, int *hot_x, int *hot_y) {
+	@autoreleasepool {
+		NSCursor *cursor = [NSCursor currentSystemCursor];
+		if (cursor == nil || cursor.image == nil) {
+			return 0;
+		}
+		NSSize size = cursor.image.size;
+		int w = (int)size.width, h = (int)size.height;
+		if (w <= 0 || h <= 0) {
+			return 0;
+		}
+		NSRect rect = NSMakeRect(0, 0, w, h);
+		CGImageRef image = [cursor.image CGImageForProposedRect:&rect context:nil hints:nil];
+		if (image == NULL) {
+			return 0;
+		}
+		uint8_t *data = calloc((size_t)w * h, 4);
+		if (data == NULL) {
+			return 0;
+		}
+		CGColorSpaceRef space = CGColorSpaceCreateDeviceRGB();
+		CGContextRef context = CGBitmapContextCreate(data, w, h, 8, (size_t)w * 4, space,
+			kCGImageAlphaPremultipliedLast | kCGBitmapByteOrder32Big);
+		CGColorSpaceRelease(space);
+		if (context == NULL) {
+			free(data);
+			return 0;
+		}
+		CGContextDrawImage(context, CGRectMake(0, 0, w, h), image);
+		CGContextRelease(context);
+
+		*pixels = data;
+		*width = w;
+		*height = h;
+		*hot_x = (int)cursor.hotSpot.x;
+		*hot_y = (int)cursor.hotSpot.y;
+		return 1;
+	}
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+)
+
+func init() {
+	readCursor = readDarwinCursor
+}
+
+// readDarwinCursor reads the system cursor, for the capture APIs that
+// can't include it. macOS doesn't tell when its shape changes, it is
+// drawn again with every read.
+func readDarwinCursor(last *cursorShape) (cursorState, error) {
+	var x, y C.double
+	if C.cursor_position(&x, &y) == 0 {
+		return cursorState{}, errors.New("can't read the cursor position")
+	}
+	state := cursorState{position: image.Pt(int(x), int(y))}
+
+	var pixels *C.uint8_t
+	var width, height, hotX, hotY C.int
+	if C.cursor_image(&pixels, &width, &height, &hotX, &hotY) == 0 {
+		return state, nil
+	}
+	defer C.free(unsafe.Pointer(pixels))
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	copy(img.Pix, unsafe.Slice((*byte)(unsafe.Pointer(pixels)), len(img.Pix)))
+
+	state.visible = true
+	state.shape = newCursorShape(img, image.Pt(int(hotX), int(hotY)))
+	if last != nil && last.id == state.shape.id {
+		state.shape = last
+	}
+	return state, nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+)
+
+var (
+	procGetCursorInfo = user32.NewProc("GetCursorInfo")
+	procGetIconInfo   = user32.NewProc("GetIconInfo")
+	procGetDC         = user32.NewProc("GetDC")
+	procReleaseDC     = user32.NewProc("ReleaseDC")
+	procGetObjectW    = gdi32.NewProc("GetObjectW")
+	procGetDIBits     = gdi32.NewProc("GetDIBits")
+	procDeleteObject  = gdi32.NewProc("DeleteObject")
+)
+
+// Constants from winuser.h and wingdi.h
+const (
+	cursorShowing = 0x00000001
+	dibRGBColors  = 0
+)
+
+// cursorInfo is a CURSORINFO
+type cursorInfo struct {
+	size   uint32
+	flags  uint32
+	cursor uintptr
+	x, y   int32
+}
+
+// iconInfo is an ICONINFO
+type iconInfo struct {
+	icon               int32
+	xHotspot, yHotspot uint32
+	mask, color        uintptr
+}
+
+// bitmap is a BITMAP
+type bitmap struct {
+	kind, width, height, widthBytes int32
+	planes, bitsPixel               uint16
+	bits                            uintptr
+}
+
+// bitmapInfo is a BITMAPINFO, the color table is unused for 32 bits per
+// pixel
+type bitmapInfo struct {
+	size                   uint32
+	width, height          int32
+	planes, bitCount       uint16
+	compression, sizeImage uint32
+	xPelsPerMeter          int32
+	yPelsPerMeter          int32
+	clrUsed, clrImportant  uint32
+	colors                 [4]uint32
+}
+
+func init() {
+	readCursor = readWindowsCursor
+}
+
+// windowsCursor is the handle of the last shape read, Windows reuses the
+// handles of shapes
+var windowsCursor uintptr
+
+// readWindowsCursor reads the cursor with GetCursorInfo. Desktop
+// Duplication and GDI screenshots leave it out.
+func readWindowsCursor(last *cursorShape) (cursorState, error) {
+	info := cursorInfo{size: uint32(unsafe.Sizeof(cursorInfo{}))}
+	if r, _, err := procGetCursorInfo.Call(uintptr(unsafe.Pointer(&info))); r == 0 {
+		return cursorState{}, err
+	}
+	state := cursorState{position: image.Pt(int(info.x), int(info.y)), visible: info.flags&cursorShowing != 0}
+	if !state.visible || info.cursor == 0 {
+		return state, nil
+	}
+	if last != nil && info.cursor == windowsCursor {
+		state.shape = last
+		return state, nil
+	}
+	shape, err := windowsCursorShape(info.cursor)
+	if err != nil {
+		return cursorState{}, err
+	}
+	if last != nil && last.id == shape.id {
+		shape = last
+	}
+	windowsCursor = info.cursor
+	state.shape = shape
+	return state, nil
+}
+
+// windowsCursorShape reads the image of a cursor. Color cursors have a
+// color bitmap, with alpha or a mask for transparency; monochrome ones
+// only a mask twice their height, the AND mask above the XOR mask.
+func windowsCursorShape(cursor uintptr) (*cursorShape, error) {
+	var icon iconInfo
+	if r, _, err := procGetIconInfo.Call(cursor, uintptr(unsafe.Pointer(&icon))); r == 0 {
+		return nil, err
+	}
+	defer procDeleteObject.Call(icon.mask)
+	if icon.color != 0 {
+		defer procDeleteObject.Call(icon.color)
+	}
+
+	var bm bitmap
+	if r, _, _ := procGetObjectW.Call(icon.mask, unsafe.Sizeof(bm), uintptr(unsafe.Pointer(&bm))); r == 0 {
+		return nil, errors.New("can't read cursor mask")
+	}
+	width, height := int(bm.width), int(bm.height)
+	if icon.color == 0 {
+		height /= 2
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("empty cursor")
+	}
+
+	dc, _, _ := procGetDC.Call(0)
+	defer procReleaseDC.Call(0, dc)
+	mask, err := dibits(dc, icon.mask, width, int(bm.height))
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if icon.color != 0 {
+		color, err := dibits(dc, icon.color, width, height)
+		if err != nil {
+			return nil, err
+		}
+		hasAlpha := false
+		for i := 3; i < len(color); i += 4 {
+			if color[i] != 0 {
+				hasAlpha = true
+				break
+			}
+		}
+		for i := 0; i < len(img.Pix); i += 4 {
+			a := uint32(color[i+3])
+			if !hasAlpha {
+				a = 0xFF
+				if mask[i] != 0 {
+					a = 0
+				}
+			}
+			img.Pix[i] = uint8(uint32(color[i+2]) * a / 0xFF)
+			img.Pix[i+1] = uint8(uint32(color[i+1]) * a / 0xFF)
+			img.Pix[i+2] = uint8(uint32(color[i]) * a / 0xFF)
+			img.Pix[i+3] = uint8(a)
+		}
+	} else {
+		// Pixels both masks set invert the screen, they are drawn black
+		xor := mask[len(img.Pix):]
+		for i := 0; i < len(img.Pix); i += 4 {
+			switch {
+			case mask[i] == 0 && xor[i] != 0:
+				copy(img.Pix[i:i+4], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+			case mask[i] == 0 || xor[i] != 0:
+				img.Pix[i+3] = 0xFF
+			}
+		}
+	}
+	return newCursorShape(img, image.Pt(int(icon.xHotspot), int(icon.yHotspot))), nil
+}
+
+// dibits returns the pixels of a bitmap as top-down BGRA
+func dibits(dc, bm uintptr, width, height int) ([]byte, error) {
+	info := bitmapInfo{width: int32(width), height: -int32(height), planes: 1, bitCount: 32}
+	info.size = uint32(unsafe.Offsetof(info.colors))
+	pixels := make([]byte, width*height*4)
+	r, _, _ := procGetDIBits.Call(dc, bm, 0, uintptr(height), uintptr(unsafe.Pointer(&pixels[0])),
+		uintptr(unsafe.Pointer(&info)), dibRGBColors)
+	if r == 0 {
+		return nil, errors.New("can't read cursor bitmap")
+	}
+	return pixels, nil
+}
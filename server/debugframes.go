@@ -0,0 +1,34 @@
+package server
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/diagnostics"
+)
+
+// debugCaptureDir is where captured and encoded frames are dumped
+const debugCaptureDir = "debug_captures"
+
+// WithDebugFrames saves every 30th captured frame of each monitor, its
+// JPEG encoding and black captures to debug_captures, keeping at most
+// limit bytes of them
+func WithDebugFrames(limit int64) Option {
+	return func(s *Server) {
+		s.debugFramesLimit = limit
+	}
+}
+
+// openFrameDump opens the debug frame dump if enabled. Without it frames
+// aren't dumped.
+func (s *Server) openFrameDump() {
+	if s.debugFramesLimit <= 0 {
+		return
+	}
+	dump, err := diagnostics.OpenFrameDump(debugCaptureDir, s.debugFramesLimit)
+	if err != nil {
+		log.Printf("Warning: Could not open debug frame directory, frames aren't saved: %v", err)
+		return
+	}
+	log.Printf("Saving debug frames to %s, up to %d MB", debugCaptureDir, s.debugFramesLimit>>20)
+	s.frameDump = dump
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// handleMonitorDetach stops sending a monitor to a client that closed its
+// window, for the rest of the connection. The payload is the server
+// monitor ID. The monitor's frame rate and capture scale requests go with
+// it, so they no longer hold the monitor up for other clients.
+func (s *Server) handleMonitorDetach(client *Client, payload []byte) {
+	if len(payload) < 4 {
+		log.Printf("Invalid monitor detach packet from client %s", client.id)
+		return
+	}
+	monitorID := protocol.BytesToUint32(payload)
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if _, ok := client.monitorMap[monitorID]; !ok {
+		return
+	}
+	delete(client.monitorMap, monitorID)
+	delete(client.frameRates, monitorID)
+	delete(client.captureScales, monitorID)
+	client.detached[monitorID] = true
+	log.Printf("Client %s detached monitor %d", client.id, monitorID)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/moderniselife/ultrardp/discovery"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithDiscovery advertises the server on the local network with mDNS, so
+// clients can find it with discovery.Discover. Relayed servers are never
+// advertised.
+func WithDiscovery(enabled bool) Option {
+	return func(s *Server) {
+		s.discoveryEnabled = enabled
+	}
+}
+
+// startDiscovery advertises the listener's port. Failing to advertise
+// isn't fatal, clients can still connect by address.
+func (s *Server) startDiscovery() {
+	_, portText, err := net.SplitHostPort(s.listener.Addr().String())
+	if err != nil {
+		log.Printf("Not advertising on the local network: %v", err)
+		return
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		log.Printf("Not advertising on the local network: invalid port %q", portText)
+		return
+	}
+
+	advertisement, err := discovery.Advertise("", port, len(s.monitors.Monitors), protocol.ProtocolVersion, s.tlsEnabled)
+	if err != nil {
+		log.Printf("Not advertising on the local network: %v", err)
+		return
+	}
+	s.advertisement = advertisement
+	log.Printf("Advertising as %s on port %d", discovery.Service, port)
+}
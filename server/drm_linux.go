@@ -0,0 +1,408 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// The DRM ioctls of include/uapi/drm, their structs are laid out for
+// 64-bit kernels
+const (
+	drmIoctlBase = 'd'
+
+	drmGEMClose         = 0x09
+	drmPrimeHandleToFD  = 0x2d
+	drmModeGetResources = 0xA0
+	drmModeGetCRTC      = 0xA1
+	drmModeMapDumb      = 0xB3
+	drmModeGetFB2       = 0xCE
+
+	drmModeFBModifiers = 1 << 1
+	drmFormatModLinear = 0
+
+	dmaBufIoctlSync = 0x40086200 // _IOW('b', 0, struct dma_buf_sync)
+	dmaBufSyncRead  = 1 << 0
+	dmaBufSyncEnd   = 1 << 2
+)
+
+// DRM fourcc pixel formats, little endian
+const (
+	drmFormatXRGB8888 = 'X' | 'R'<<8 | '2'<<16 | '4'<<24
+	drmFormatARGB8888 = 'A' | 'R'<<8 | '2'<<16 | '4'<<24
+	drmFormatXBGR8888 = 'X' | 'B'<<8 | '2'<<16 | '4'<<24
+	drmFormatABGR8888 = 'A' | 'B'<<8 | '2'<<16 | '4'<<24
+)
+
+type drmModeCardRes struct {
+	FbIDPtr, CrtcIDPtr, ConnectorIDPtr, EncoderIDPtr     uint64
+	CountFbs, CountCrtcs, CountConnectors, CountEncoders uint32
+	MinWidth, MaxWidth, MinHeight, MaxHeight             uint32
+}
+
+type drmModeModeInfo struct {
+	Clock                                         uint32
+	Hdisplay, HsyncStart, HsyncEnd, Htotal, Hskew uint16
+	Vdisplay, VsyncStart, VsyncEnd, Vtotal, Vscan uint16
+	Vrefresh, Flags, Type                         uint32
+	Name                                          [32]byte
+}
+
+type drmModeCRTC struct {
+	SetConnectorsPtr uint64
+	CountConnectors  uint32
+	CrtcID           uint32
+	FbID             uint32
+	X, Y             uint32 // Of the scanned out part of the framebuffer
+	GammaSize        uint32
+	ModeValid        uint32
+	Mode             drmModeModeInfo
+}
+
+type drmModeFBCmd2 struct {
+	FbID          uint32
+	Width, Height uint32
+	PixelFormat   uint32
+	Flags         uint32
+	Handles       [4]uint32
+	Pitches       [4]uint32
+	Offsets       [4]uint32
+	_             uint32
+	Modifier      [4]uint64
+}
+
+type drmPrimeHandle struct {
+	Handle uint32
+	Flags  uint32
+	Fd     int32
+}
+
+type drmModeMapDumbArgs struct {
+	Handle uint32
+	_      uint32
+	Offset uint64
+}
+
+type drmGEMCloseArgs struct {
+	Handle uint32
+	_      uint32
+}
+
+// drmIOWR returns the request number of a read/write DRM ioctl
+func drmIOWR(nr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | drmIoctlBase<<8 | nr
+}
+
+// drmIOW returns the request number of a write only DRM ioctl
+func drmIOW(nr, size uintptr) uintptr {
+	return 1<<30 | size<<16 | drmIoctlBase<<8 | nr
+}
+
+func drmIoctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	for {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR, syscall.EAGAIN:
+			continue
+		default:
+			return errno
+		}
+	}
+}
+
+// drmOutput is a CRTC scanning out a framebuffer, a monitor of a console
+type drmOutput struct {
+	card string
+	crtc uint32
+	rect image.Rectangle // In its framebuffer
+}
+
+// drmOutputs are the outputs found by drmMonitors, in monitor order
+var drmOutputs []drmOutput
+
+func init() {
+	consoleMonitors = drmMonitors
+	screenBackends = append(screenBackends, screenBackend{name: "DRM/KMS", key: "drm", available: drmAvailable, open: newDRMCapturer})
+}
+
+// drmAvailable reports whether this is a console, without a display
+// server whose own capture APIs know better, with outputs to capture
+func drmAvailable() bool {
+	return os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" && len(drmOutputs) > 0
+}
+
+// drmMonitors returns the active outputs of the machine's graphics cards
+func drmMonitors() (*protocol.MonitorConfig, error) {
+	cards, _ := filepath.Glob("/dev/dri/card*")
+	drmOutputs = nil
+	for _, card := range cards {
+		fd, err := syscall.Open(card, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			continue
+		}
+		crtcs, err := drmCRTCs(fd)
+		if err != nil {
+			syscall.Close(fd)
+			continue
+		}
+		for _, id := range crtcs {
+			crtc, err := drmGetCRTC(fd, id)
+			if err != nil || crtc.ModeValid == 0 || crtc.FbID == 0 {
+				continue
+			}
+			drmOutputs = append(drmOutputs, drmOutput{
+				card: card,
+				crtc: id,
+				rect: image.Rect(0, 0, int(crtc.Mode.Hdisplay), int(crtc.Mode.Vdisplay)).Add(image.Pt(int(crtc.X), int(crtc.Y))),
+			})
+		}
+		syscall.Close(fd)
+	}
+	if len(drmOutputs) == 0 {
+		return nil, errors.New("no active DRM outputs")
+	}
+
+	config := &protocol.MonitorConfig{MonitorCount: uint32(len(drmOutputs))}
+	for i, output := range drmOutputs {
+		config.Monitors = append(config.Monitors, protocol.MonitorInfo{
+			ID:        uint32(i + 1),
+			Width:     uint32(output.rect.Dx()),
+			Height:    uint32(output.rect.Dy()),
+			PositionX: uint32(output.rect.Min.X),
+			PositionY: uint32(output.rect.Min.Y),
+			Primary:   i == 0,
+		})
+	}
+	return config, nil
+}
+
+// drmCRTCs returns the CRTC ids of a card
+func drmCRTCs(fd int) ([]uint32, error) {
+	var res drmModeCardRes
+	if err := drmIoctl(fd, drmIOWR(drmModeGetResources, unsafe.Sizeof(res)), unsafe.Pointer(&res)); err != nil {
+		return nil, err
+	}
+	if res.CountCrtcs == 0 {
+		return nil, nil
+	}
+	crtcs := make([]uint32, res.CountCrtcs)
+	res = drmModeCardRes{CrtcIDPtr: uint64(uintptr(unsafe.Pointer(&crtcs[0]))), CountCrtcs: uint32(len(crtcs))}
+	err := drmIoctl(fd, drmIOWR(drmModeGetResources, unsafe.Sizeof(res)), unsafe.Pointer(&res))
+	runtime.KeepAlive(crtcs)
+	return crtcs[:min(int(res.CountCrtcs), len(crtcs))], err
+}
+
+func drmGetCRTC(fd int, id uint32) (drmModeCRTC, error) {
+	crtc := drmModeCRTC{CrtcID: id}
+	err := drmIoctl(fd, drmIOWR(drmModeGetCRTC, unsafe.Sizeof(crtc)), unsafe.Pointer(&crtc))
+	return crtc, err
+}
+
+// drmMapping is a framebuffer mapped for reading
+type drmMapping struct {
+	data   []byte
+	dmabuf int // -1 for dumb buffers mapped through the card
+	offset int
+	pitch  int
+	width  int
+	height int
+	bgr    bool // Red first in memory, XBGR8888
+}
+
+func (m *drmMapping) unmap() {
+	syscall.Munmap(m.data)
+	if m.dmabuf >= 0 {
+		syscall.Close(m.dmabuf)
+	}
+}
+
+// drmMaxMappings is how many framebuffers stay mapped, compositors flip
+// between two or three
+const drmMaxMappings = 4
+
+// drmCapturer captures a CRTC by mapping the framebuffer it scans out.
+// Reading another process's framebuffer needs root (CAP_SYS_ADMIN), and it
+// only works for linear buffers, e.g. those of the text console and of
+// kiosk apps drawing to dumb buffers. DRM doesn't say what changed, every
+// frame is read.
+type drmCapturer struct {
+	fd       int
+	crtc     uint32
+	frame    *image.RGBA
+	mappings map[uint32]*drmMapping // By framebuffer id
+}
+
+func newDRMCapturer(monitor protocol.MonitorInfo, _ bool) (screenCapturer, error) {
+	output, ok := drmOutputFor(monitor)
+	if !ok {
+		return nil, errors.New("no DRM output at the monitor's position")
+	}
+	fd, err := syscall.Open(output.card, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	c := &drmCapturer{
+		fd:       fd,
+		crtc:     output.crtc,
+		frame:    image.NewRGBA(image.Rect(0, 0, int(monitor.Width), int(monitor.Height))),
+		mappings: make(map[uint32]*drmMapping),
+	}
+	// Fail now rather than with every frame, e.g. without root
+	if _, _, err := c.capture(); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// drmOutputFor returns the output of a monitor, by position or else by
+// order
+func drmOutputFor(monitor protocol.MonitorInfo) (drmOutput, bool) {
+	rect := image.Rect(0, 0, int(monitor.Width), int(monitor.Height)).Add(image.Pt(int(monitor.PositionX), int(monitor.PositionY)))
+	for _, output := range drmOutputs {
+		if output.rect == rect {
+			return output, true
+		}
+	}
+	if i := int(monitor.ID) - 1; i >= 0 && i < len(drmOutputs) {
+		return drmOutputs[i], true
+	}
+	return drmOutput{}, false
+}
+
+func (c *drmCapturer) capture() (image.Image, bool, error) {
+	crtc, err := drmGetCRTC(c.fd, c.crtc)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get CRTC: %w", err)
+	}
+	if crtc.ModeValid == 0 || crtc.FbID == 0 {
+		// The output is off, keep the last picture
+		return c.frame, false, nil
+	}
+	m, err := c.mapping(crtc.FbID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if m.dmabuf >= 0 {
+		drmSyncDMABuf(m.dmabuf, dmaBufSyncRead)
+		defer drmSyncDMABuf(m.dmabuf, dmaBufSyncRead|dmaBufSyncEnd)
+	}
+	columns := min(c.frame.Rect.Dx(), m.width-int(crtc.X))
+	rows := min(c.frame.Rect.Dy(), m.height-int(crtc.Y))
+	for y := 0; y < rows; y++ {
+		src := m.data[m.offset+(int(crtc.Y)+y)*m.pitch+int(crtc.X)*4:]
+		dst := c.frame.Pix[y*c.frame.Stride:]
+		for x := 0; x < columns; x++ {
+			p := src[x*4 : x*4+4 : x*4+4]
+			if m.bgr {
+				dst[x*4], dst[x*4+1], dst[x*4+2] = p[0], p[1], p[2]
+			} else {
+				dst[x*4], dst[x*4+1], dst[x*4+2] = p[2], p[1], p[0]
+			}
+			dst[x*4+3] = 0xFF
+		}
+	}
+	return c.frame, true, nil
+}
+
+// mapping maps a framebuffer, or returns it if it already is
+func (c *drmCapturer) mapping(id uint32) (*drmMapping, error) {
+	if m, ok := c.mappings[id]; ok {
+		return m, nil
+	}
+	if len(c.mappings) >= drmMaxMappings {
+		c.unmapAll()
+	}
+
+	fb := drmModeFBCmd2{FbID: id}
+	if err := drmIoctl(c.fd, drmIOWR(drmModeGetFB2, unsafe.Sizeof(fb)), unsafe.Pointer(&fb)); err != nil {
+		return nil, fmt.Errorf("failed to get framebuffer: %w", err)
+	}
+	if fb.Handles[0] == 0 {
+		return nil, errors.New("framebuffer handles need root (CAP_SYS_ADMIN)")
+	}
+	defer drmCloseHandles(c.fd, fb.Handles)
+
+	m := &drmMapping{
+		dmabuf: -1,
+		offset: int(fb.Offsets[0]),
+		pitch:  int(fb.Pitches[0]),
+		width:  int(fb.Width),
+		height: int(fb.Height),
+	}
+	switch fb.PixelFormat {
+	case drmFormatXRGB8888, drmFormatARGB8888:
+	case drmFormatXBGR8888, drmFormatABGR8888:
+		m.bgr = true
+	default:
+		return nil, fmt.Errorf("unsupported framebuffer format %08x", fb.PixelFormat)
+	}
+	if fb.Flags&drmModeFBModifiers != 0 && fb.Modifier[0] != drmFormatModLinear {
+		return nil, errors.New("framebuffer is tiled or compressed")
+	}
+
+	// Export it as a DMA-BUF, which works for buffers of any driver, or
+	// map it as a dumb buffer through the card
+	size := m.offset + m.pitch*m.height
+	prime := drmPrimeHandle{Handle: fb.Handles[0], Flags: syscall.O_CLOEXEC}
+	if err := drmIoctl(c.fd, drmIOWR(drmPrimeHandleToFD, unsafe.Sizeof(prime)), unsafe.Pointer(&prime)); err == nil {
+		data, err := syscall.Mmap(int(prime.Fd), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+		if err == nil {
+			m.data, m.dmabuf = data, int(prime.Fd)
+		} else {
+			syscall.Close(int(prime.Fd))
+		}
+	}
+	if m.data == nil {
+		dumb := drmModeMapDumbArgs{Handle: fb.Handles[0]}
+		if err := drmIoctl(c.fd, drmIOWR(drmModeMapDumb, unsafe.Sizeof(dumb)), unsafe.Pointer(&dumb)); err != nil {
+			return nil, fmt.Errorf("failed to map framebuffer: %w", err)
+		}
+		data, err := syscall.Mmap(c.fd, int64(dumb.Offset), size, syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map framebuffer: %w", err)
+		}
+		m.data = data
+	}
+	c.mappings[id] = m
+	return m, nil
+}
+
+// drmCloseHandles closes the GEM handles GETFB2 opened, mappings keep
+// their buffers alive
+func drmCloseHandles(fd int, handles [4]uint32) {
+	for i, handle := range handles {
+		if handle == 0 || (i > 0 && handle == handles[0]) {
+			continue
+		}
+		args := drmGEMCloseArgs{Handle: handle}
+		drmIoctl(fd, drmIOW(drmGEMClose, unsafe.Sizeof(args)), unsafe.Pointer(&args))
+	}
+}
+
+// drmSyncDMABuf brackets CPU reads of a DMA-BUF
+func drmSyncDMABuf(fd int, flags uint64) {
+	drmIoctl(fd, dmaBufIoctlSync, unsafe.Pointer(&flags))
+}
+
+func (c *drmCapturer) unmapAll() {
+	for id, m := range c.mappings {
+		m.unmap()
+		delete(c.mappings, id)
+	}
+}
+
+func (c *drmCapturer) close() {
+	c.unmapAll()
+	syscall.Close(c.fd)
+}
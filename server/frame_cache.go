@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// cacheFrame stores the most recently encoded frame for a monitor so that
+// newly connecting clients can be shown the last known screen immediately.
+// Every JPEG frame is self-contained, so the latest frame is always a valid
+// keyframe to start from.
+func (s *Server) cacheFrame(monitorID uint32, frameData []byte) {
+	s.frameCacheMutex.Lock()
+	defer s.frameCacheMutex.Unlock()
+
+	s.frameCache[monitorID] = frameData
+}
+
+// sendCachedFrames sends the cached frame of every mapped monitor to a client
+// that has just completed its handshake. It must be called before the client
+// is added to the server's client list so it cannot race the capture loops.
+func (s *Server) sendCachedFrames(client *Client) {
+	s.frameCacheMutex.Lock()
+	defer s.frameCacheMutex.Unlock()
+
+	sent := 0
+	for monitorID, frameData := range s.frameCache {
+		if _, ok := client.monitorMap[monitorID]; !ok {
+			continue
+		}
+
+		// Always use TCP, the client hasn't set up UDP yet
+		packet := protocol.NewPacket(protocol.PacketTypeVideoFrame, frameData)
+		if err := client.send(packet); err != nil {
+			log.Printf("Error sending cached frame for monitor %d to client %s: %v", monitorID, client.id, err)
+			return
+		}
+		client.congestion.OnSent(len(frameData))
+		sent++
+	}
+
+	if sent > 0 {
+		log.Printf("Sent %d cached frames to client %s", sent, client.id)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+const (
+	// DefaultFrameRate is the target frames per second of monitors without
+	// a rate of their own
+	DefaultFrameRate = 30
+
+	// MaxFrameRate is the highest frame rate a monitor can be set to
+	MaxFrameRate = 240
+
+	// spinBelow is the frame interval below which frameClock spins
+	// through the last spinWindow before a deadline instead of sleeping.
+	// Timers oversleep by up to a millisecond or two, a large share of a
+	// 240 fps frame's 4ms, while at lower rates it doesn't matter.
+	spinBelow  = 20 * time.Millisecond
+	spinWindow = 2 * time.Millisecond
+)
+
+// WithFrameRate sets the target frames per second of every monitor.
+// Bandwidth limits and the adaptive quality controller may send fewer.
+func WithFrameRate(fps int) Option {
+	return func(s *Server) {
+		s.frameRate = fps
+	}
+}
+
+// WithMonitorFrameRates sets the target frames per second of individual
+// monitors by ID, overriding WithFrameRate
+func WithMonitorFrameRates(rates map[uint32]int) Option {
+	return func(s *Server) {
+		s.monitorFrameRates = rates
+	}
+}
+
+// ParseFrameRates parses a comma separated list of monitor frame rates
+// like "1=60,2=15" for WithMonitorFrameRates
+func ParseFrameRates(list string) (map[uint32]int, error) {
+	rates := make(map[uint32]int)
+	for _, entry := range strings.Split(list, ",") {
+		id, fps, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not monitor=fps", entry)
+		}
+		monitorID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil || monitorID == 0 {
+			return nil, fmt.Errorf("invalid monitor ID %q", id)
+		}
+		rate, err := strconv.Atoi(fps)
+		if err != nil || rate < 1 || rate > MaxFrameRate {
+			return nil, fmt.Errorf("invalid frame rate %q, must be 1-%d", fps, MaxFrameRate)
+		}
+		rates[uint32(monitorID)] = rate
+	}
+	return rates, nil
+}
+
+// handleFrameRate records the frame rate a client asked for, for one
+// monitor or all of them
+func (s *Server) handleFrameRate(client *Client, payload []byte) {
+	monitorID, fps, err := protocol.DecodeFrameRate(payload)
+	if err != nil {
+		log.Printf("Invalid frame rate packet from client %s", client.id)
+		return
+	}
+	fps = min(fps, MaxFrameRate)
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	for id := range client.monitorMap {
+		if monitorID != 0 && id != monitorID {
+			continue
+		}
+		if fps > 0 {
+			client.frameRates[id] = fps
+		} else {
+			delete(client.frameRates, id)
+		}
+	}
+	log.Printf("Client %s requested %d fps for monitor %d", client.id, fps, monitorID)
+}
+
+// frameInterval returns the time between frames of a monitor at its target
+// frame rate: the highest a client asked for up to the server's setting,
+// else the server's setting
+func (s *Server) frameInterval(monitorID uint32) time.Duration {
+	fps := s.frameRate
+	if rate, ok := s.monitorFrameRates[monitorID]; ok {
+		fps = rate
+	}
+
+	s.clientsMutex.Lock()
+	requested := 0
+	for _, client := range s.clients {
+		requested = max(requested, client.frameRates[monitorID])
+	}
+	s.clientsMutex.Unlock()
+	if requested > 0 {
+		fps = min(fps, requested)
+	}
+
+	fps = min(max(fps, 1), MaxFrameRate)
+	return time.Second / time.Duration(fps)
+}
+
+// frameClock paces a capture loop. Frames start an interval apart however
+// long capturing and encoding took, as long as they fit in it. Deadlines
+// are absolute, so the rate doesn't drift with the time spent waking up.
+type frameClock struct {
+	next time.Time // When the next frame starts
+}
+
+// wait sleeps until the next frame is due or wake is closed. After falling
+// behind, e.g. when a frame took longer than the interval, the next one
+// starts right away instead of several in a burst to catch up.
+func (c *frameClock) wait(interval time.Duration, wake <-chan struct{}) {
+	now := time.Now()
+	c.next = c.next.Add(interval)
+	if c.next.Before(now) {
+		c.next = now
+		return
+	}
+
+	sleep := c.next.Sub(now)
+	if interval < spinBelow {
+		sleep -= spinWindow
+	}
+	if sleep > 0 {
+		timer := time.NewTimer(sleep)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-wake:
+			c.next = time.Now()
+			return
+		}
+	}
+
+	for time.Now().Before(c.next) {
+		select {
+		case <-wake:
+			c.next = time.Now()
+			return
+		default:
+			runtime.Gosched()
+		}
+	}
+}
@@ -0,0 +1,331 @@
+//go:build darwin && cgo
+
+package server
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework CoreGraphics -framework CoreMedia -framework CoreVideo -weak_framework ScreenCaptureKit
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+#include <libproc.h>
+#import <Foundation/Foundation.h>
+#import <CoreVideo/CoreVideo.h>
+#import <ScreenCaptureKit/ScreenCaptureKit.h>
+
+// URDPFrameOutput receives the frames of a ScreenCaptureKit stream and
+// keeps the last complete one
+API_AVAILABLE(macos(12.3))
+@interface URDPFrameOutput : NSObject <SCStreamOutput, SCStreamDelegate> {
+@public
+	SCStream *stream;
+	CVPixelBufferRef frame;
+	uint64_t sequence;
+	BOOL stopped;
+}
+@end
+
+@implementation URDPFrameOutput
+- (void)stream:(SCStream *)s didOutputSampleBuffer:(CMSampleBufferRef)sample ofType:(SCStreamOutputType)type {
+	if (type != SCStreamOutputTypeScreen || !CMSampleBufferIsValid(sample)) {
+		return;
+	}
+
+	// Idle frames repeat the last complete one
+	CFArrayRef attachments = CMSampleBufferGetSampleAttachmentsArray(sample, false);
+	if (attachments == NULL || CFArrayGetCount(attachments) == 0) {
+		return;
+	}
+	NSDictionary *info = (__bridge NSDictionary *)CFArrayGetValueAtIndex(attachments, 0);
+	NSNumber *status = info[SCStreamFrameInfoStatus];
+	if (status == nil || status.integerValue != SCFrameStatusComplete) {
+		return;
+	}
+	CVPixelBufferRef buffer = CMSampleBufferGetImageBuffer(sample);
+	if (buffer == NULL) {
+		return;
+	}
+	@synchronized (self) {
+		CVPixelBufferRetain(buffer);
+		CVPixelBufferRelease(frame);
+		frame = buffer;
+		sequence++;
+	}
+}
+
+- (void)stream:(SCStream *)s didStopWithError:(NSError *)error {
+	@synchronized (self) {
+		stopped = YES;
+	}
+}
+
+- (void)dealloc {
+	CVPixelBufferRelease(frame);
+}
+@end
+
+static int sck_available(void) {
+	if (@available(macOS 12.3, *)) {
+		return 1;
+	}
+	return 0;
+}
+
+// sck_error returns a copy of an error's description for Go to free
+static char *sck_error(NSError *error, const char *fallback) {
+	const char *message = error != nil ? error.localizedDescription.UTF8String : NULL;
+	return strdup(message != NULL ? message : fallback);
+}
+
+// sck_own_apps returns the running instances of this executable, e.g. a
+// client on the same machine, so their windows aren't captured and shown
+// inside themselves
+API_AVAILABLE(macos(12.3))
+static NSArray<SCRunningApplication *> *sck_own_apps(SCShareableContent *content) {
+	char own[PROC_PIDPATHINFO_MAXSIZE], path[PROC_PIDPATHINFO_MAXSIZE];
+	NSMutableArray<SCRunningApplication *> *apps = [NSMutableArray array];
+	if (proc_pidpath(getpid(), own, sizeof(own)) <= 0) {
+		return apps;
+	}
+	for (SCRunningApplication *app in content.applications) {
+		if (proc_pidpath(app.processID, path, sizeof(path)) > 0 && strcmp(path, own) == 0) {
+			[apps addObject:app];
+		}
+	}
+	return apps;
+}
+
+// sck_start starts capturing the index-th active display at width x height
+// pixels into BGRA pixel buffers, with the cursor if cursor is set. It
+// returns NULL and an error message to free in *err on failure, e.g.
+// without the screen recording permission.
+static void *sck_start(int index, int width, int height, int cursor, char **err) {
+	if (@available(macOS 12.3, *)) {
+		CGDirectDisplayID ids[32];
+		uint32_t count = 0;
+		if (CGGetActiveDisplayList(32, ids, &count) != kCGErrorSuccess || index < 0 || (uint32_t)index >= count) {
+			*err = strdup("no such display");
+			return NULL;
+		}
+
+		dispatch_semaphore_t done = dispatch_semaphore_create(0);
+		__block SCShareableContent *content = nil;
+		__block NSError *failure = nil;
+		[SCShareableContent getShareableContentWithCompletionHandler:^(SCShareableContent *c, NSError *e) {
+			content = c;
+			failure = e;
+			dispatch_semaphore_signal(done);
+		}];
+		dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+		if (content == nil) {
+			*err = sck_error(failure, "no shareable content");
+			return NULL;
+		}
+		SCDisplay *display = nil;
+		for (SCDisplay *d in content.displays) {
+			if (d.displayID == ids[index]) {
+				display = d;
+			}
+		}
+		if (display == nil) {
+			*err = strdup("display isn't shareable");
+			return NULL;
+		}
+
+		// Frames as fast as the display refreshes, the capture loop takes
+		// the latest one at its own pace. UltraRDP's own windows are left
+		// out.
+		SCContentFilter *filter = [[SCContentFilter alloc] initWithDisplay:display
+			excludingApplications:sck_own_apps(content) exceptingWindows:@[]];
+		SCStreamConfiguration *config = [[SCStreamConfiguration alloc] init];
+		config.width = width;
+		config.height = height;
+		config.pixelFormat = kCVPixelFormatType_32BGRA;
+		config.minimumFrameInterval = kCMTimeZero;
+		config.queueDepth = 5;
+		config.showsCursor = cursor != 0;
+
+		URDPFrameOutput *output = [[URDPFrameOutput alloc] init];
+		SCStream *stream = [[SCStream alloc] initWithFilter:filter configuration:config delegate:output];
+		NSError *error = nil;
+		dispatch_queue_t queue = dispatch_queue_create("ultrardp.capture", DISPATCH_QUEUE_SERIAL);
+		if (![stream addStreamOutput:output type:SCStreamOutputTypeScreen sampleHandlerQueue:queue error:&error]) {
+			*err = sck_error(error, "can't add stream output");
+			return NULL;
+		}
+		[stream startCaptureWithCompletionHandler:^(NSError *e) {
+			failure = e;
+			dispatch_semaphore_signal(done);
+		}];
+		dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+		if (failure != nil) {
+			*err = sck_error(failure, "can't start capture");
+			return NULL;
+		}
+		output->stream = stream;
+		return (__bridge_retained void *)output;
+	}
+	*err = strdup("ScreenCaptureKit needs macOS 12.3 or newer");
+	return NULL;
+}
+
+// sck_frame returns the last complete frame, retained, and its sequence
+// number. It returns NULL before the first frame and sets *stopped when
+// the stream ended.
+static void *sck_frame(void *capture, uint64_t *sequence, int *stopped) {
+	if (@available(macOS 12.3, *)) {
+		URDPFrameOutput *output = (__bridge URDPFrameOutput *)capture;
+		@synchronized (output) {
+			*stopped = output->stopped;
+			*sequence = output->sequence;
+			if (output->frame == NULL) {
+				return NULL;
+			}
+			return (void *)CVPixelBufferRetain(output->frame);
+		}
+	}
+	return NULL;
+}
+
+static void sck_release(void *frame) {
+	CVPixelBufferRelease((CVPixelBufferRef)frame);
+}
+
+// sck_copy copies a BGRA frame into RGBA pixels
+static void sck_copy(void *frame, uint8_t *rgba, int width, int height) {
+	CVPixelBufferRef buffer = (CVPixelBufferRef)frame;
+	CVPixelBufferLockBaseAddress(buffer, kCVPixelBufferLock_ReadOnly);
+	const uint8_t *src = CVPixelBufferGetBaseAddress(buffer);
+	size_t stride = CVPixelBufferGetBytesPerRow(buffer);
+	int rows = (int)CVPixelBufferGetHeight(buffer) < height ? (int)CVPixelBufferGetHeight(buffer) : height;
+	int columns = (int)CVPixelBufferGetWidth(buffer) < width ? (int)CVPixelBufferGetWidth(buffer) : width;
+	for (int y = 0; y < rows; y++) {
+		const uint8_t *row = src + y*stride;
+		uint8_t *dst = rgba + (size_t)y*width*4;
+		for (int x = 0; x < columns; x++) {
+			dst[x*4] = row[x*4+2];
+			dst[x*4+1] = row[x*4+1];
+			dst[x*4+2] = row[x*4];
+			dst[x*4+3] = 255;
+		}
+	}
+	CVPixelBufferUnlockBaseAddress(buffer, kCVPixelBufferLock_ReadOnly);
+}
+
+static void sck_stop(void *capture) {
+	if (@available(macOS 12.3, *)) {
+		URDPFrameOutput *output = (__bridge_transfer URDPFrameOutput *)capture;
+		dispatch_semaphore_t done = dispatch_semaphore_create(0);
+		[output->stream stopCaptureWithCompletionHandler:^(NSError *e) {
+			dispatch_semaphore_signal(done);
+		}];
+		dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+	}
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"unsafe"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+func init() {
+	newGPUCapturer = newScreenCaptureKit
+}
+
+// screenCaptureKitAvailable reports whether the OS has ScreenCaptureKit,
+// macOS 12.3 or newer
+func screenCaptureKitAvailable() bool {
+	return C.sck_available() != 0
+}
+
+// screenCaptureKit captures a display with ScreenCaptureKit, whose frames
+// are IOSurface backed pixel buffers VideoToolbox encodes in place
+type screenCaptureKit struct {
+	stream unsafe.Pointer
+	rect   image.Rectangle
+	last   *pixelBufferSurface // Frame returned by the last capture
+}
+
+func newScreenCaptureKit(monitor protocol.MonitorInfo, cursor bool) (gpuCapturer, error) {
+	showCursor := C.int(0)
+	if cursor {
+		showCursor = 1
+	}
+	var message *C.char
+	stream := C.sck_start(C.int(monitor.ID-1), C.int(monitor.Width), C.int(monitor.Height), showCursor, &message)
+	if stream == nil {
+		defer C.free(unsafe.Pointer(message))
+		return nil, errors.New(C.GoString(message))
+	}
+	return &screenCaptureKit{
+		stream: stream,
+		rect:   image.Rect(0, 0, int(monitor.Width), int(monitor.Height)),
+	}, nil
+}
+
+func (c *screenCaptureKit) capture() (codec.Surface, error) {
+	var sequence C.uint64_t
+	var stopped C.int
+	frame := C.sck_frame(c.stream, &sequence, &stopped)
+	if stopped != 0 {
+		if frame != nil {
+			C.sck_release(frame)
+		}
+		return nil, errors.New("ScreenCaptureKit stream stopped")
+	}
+	if frame == nil {
+		return nil, nil
+	}
+
+	// An unchanged frame keeps its copy in memory
+	if c.last != nil && c.last.sequence == uint64(sequence) {
+		C.sck_release(frame)
+		return c.last, nil
+	}
+	if c.last != nil {
+		C.sck_release(c.last.buffer)
+	}
+	c.last = &pixelBufferSurface{buffer: frame, sequence: uint64(sequence), rect: c.rect}
+	return c.last, nil
+}
+
+func (c *screenCaptureKit) close() {
+	if c.last != nil {
+		C.sck_release(c.last.buffer)
+		c.last = nil
+	}
+	C.sck_stop(c.stream)
+}
+
+// pixelBufferSurface is a captured frame in a CVPixelBuffer
+type pixelBufferSurface struct {
+	buffer   unsafe.Pointer
+	sequence uint64
+	rect     image.Rectangle
+	pixels   *image.RGBA // Copy in memory, nil until needed
+}
+
+func (s *pixelBufferSurface) ColorModel() color.Model { return color.RGBAModel }
+
+func (s *pixelBufferSurface) Bounds() image.Rectangle { return s.rect }
+
+func (s *pixelBufferSurface) At(x, y int) color.Color { return s.Pixels().At(x, y) }
+
+func (s *pixelBufferSurface) Pixels() *image.RGBA {
+	if s.pixels == nil {
+		s.pixels = image.NewRGBA(s.rect)
+		C.sck_copy(s.buffer, (*C.uint8_t)(unsafe.Pointer(&s.pixels.Pix[0])), C.int(s.rect.Dx()), C.int(s.rect.Dy()))
+	}
+	return s.pixels
+}
+
+func (s *pixelBufferSurface) Native() unsafe.Pointer { return s.buffer }
+
+func (s *pixelBufferSurface) Sequence() uint64 { return s.sequence }
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// toneMappingCapturer is a screen capturer that can tone map HDR screens
+// to SDR, which it does until told otherwise. Capture APIs without one
+// deliver SDR frames, tone mapped by the OS.
+type toneMappingCapturer interface {
+	setToneMapping(enabled bool)
+}
+
+// handleCapabilities records what a client's display can show
+func (s *Server) handleCapabilities(client *Client, payload []byte) {
+	capabilities, err := protocol.DecodeCapabilities(payload)
+	if err != nil {
+		log.Printf("Invalid capabilities packet from client %s", client.id)
+		return
+	}
+	s.clientsMutex.Lock()
+	client.capabilities = capabilities
+	s.clientsMutex.Unlock()
+	log.Printf("Client %s shows HDR: %t", client.id, capabilities&protocol.CapabilityHDR != 0)
+}
+
+// toneMapping reports whether HDR frames of a monitor are tone mapped:
+// unless every client of it shows HDR, as frames are encoded once for
+// everyone
+func (s *Server) toneMapping(monitorID uint32) bool {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	watched := false
+	for _, client := range s.clients {
+		if _, ok := client.monitorMap[monitorID]; !ok {
+			continue
+		}
+		if client.capabilities&protocol.CapabilityHDR == 0 {
+			return true
+		}
+		watched = true
+	}
+	return !watched
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// HeadlessConfig describes the virtual display a headless server runs
+type HeadlessConfig struct {
+	Width, Height int
+	App           string // Shell command started on the display, empty for none
+}
+
+// ParseHeadlessSize parses a virtual display size like "1920x1080"
+func ParseHeadlessSize(size string) (width, height int, err error) {
+	if _, err := fmt.Sscanf(size, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", size)
+	}
+	return width, height, nil
+}
+
+// WithHeadless runs the server on a virtual display it starts, for
+// streaming GUI apps from machines without a screen
+func WithHeadless(config HeadlessConfig) Option {
+	return func(s *Server) {
+		s.headlessConfig = &config
+	}
+}
+
+// headlessSession is a running virtual display
+type headlessSession interface {
+	// close stops the display and the app on it
+	close()
+}
+
+// startHeadless starts a virtual display and points the process at it; nil
+// on platforms without one. exited is called if the display stops on its
+// own.
+var startHeadless func(config HeadlessConfig, exited func(error)) (headlessSession, error)
+
+// startHeadlessDisplay starts the virtual display before the monitors are
+// detected
+func (s *Server) startHeadlessDisplay() error {
+	if startHeadless == nil {
+		return errors.New("headless mode needs Linux and Xvfb")
+	}
+	session, err := startHeadless(*s.headlessConfig, func(err error) {
+		if !s.stopped {
+			log.Printf("Virtual display stopped, stopping the server: %v", err)
+			s.Stop()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	s.headless = session
+	return nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// xvfbStartTimeout is how long Xvfb may take to accept connections
+const xvfbStartTimeout = 10 * time.Second
+
+func init() {
+	startHeadless = startXvfb
+}
+
+// xvfbSession is an Xvfb server the process started, and the app on it
+type xvfbSession struct {
+	xvfb     *exec.Cmd
+	app      *exec.Cmd // nil if no app was asked for
+	display  string
+	stopping chan struct{}
+	once     sync.Once
+}
+
+// startXvfb starts Xvfb on a free display number at the configured size.
+// Xvfb has the MIT-SHM, DAMAGE and XFIXES extensions the X11 capture
+// needs. The process's DISPLAY points at it afterwards, Wayland is
+// ignored.
+func startXvfb(config HeadlessConfig, exited func(error)) (headlessSession, error) {
+	path, err := exec.LookPath("Xvfb")
+	if err != nil {
+		return nil, errors.New("Xvfb isn't installed, e.g. install the xvfb package")
+	}
+
+	// Xvfb picks a free display number and writes it to -displayfd once it
+	// accepts connections
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	xvfb := exec.Command(path, "-displayfd", "3",
+		"-screen", "0", fmt.Sprintf("%dx%dx24", config.Width, config.Height),
+		"-nolisten", "tcp", "+extension", "MIT-SHM", "+extension", "DAMAGE")
+	xvfb.ExtraFiles = []*os.File{writer}
+	xvfb.Stderr = os.Stderr
+	xvfb.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM} // Gone with the server
+	if err := xvfb.Start(); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("failed to start Xvfb: %w", err)
+	}
+	writer.Close()
+
+	number := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(reader).ReadString('\n')
+		number <- strings.TrimSpace(line)
+	}()
+	var display string
+	select {
+	case n := <-number:
+		display = ":" + n
+	case <-time.After(xvfbStartTimeout):
+	}
+	if display == ":" || display == "" {
+		xvfb.Process.Kill()
+		xvfb.Wait()
+		return nil, errors.New("Xvfb didn't start")
+	}
+
+	os.Setenv("DISPLAY", display)
+	os.Unsetenv("WAYLAND_DISPLAY")
+	log.Printf("Started Xvfb on display %s at %dx%d", display, config.Width, config.Height)
+
+	session := &xvfbSession{xvfb: xvfb, display: display, stopping: make(chan struct{})}
+	go func() {
+		err := xvfb.Wait()
+		select {
+		case <-session.stopping:
+		default:
+			if err == nil {
+				err = errors.New("Xvfb exited")
+			}
+			session.close()
+			exited(err)
+		}
+	}()
+
+	if config.App != "" {
+		app := exec.Command("/bin/sh", "-c", config.App)
+		app.Stdout = os.Stdout
+		app.Stderr = os.Stderr
+		app.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM, Setpgid: true}
+		if err := app.Start(); err != nil {
+			session.close()
+			return nil, fmt.Errorf("failed to start %q: %w", config.App, err)
+		}
+		session.app = app
+		log.Printf("Started %q on display %s", config.App, display)
+		go func() {
+			err := app.Wait()
+			select {
+			case <-session.stopping:
+			default:
+				log.Printf("%q exited: %v", config.App, err)
+			}
+		}()
+	}
+	return session, nil
+}
+
+// close stops the app, with its child processes, and Xvfb
+func (x *xvfbSession) close() {
+	x.once.Do(func() {
+		close(x.stopping)
+		if x.app != nil && x.app.Process != nil {
+			syscall.Kill(-x.app.Process.Pid, syscall.SIGTERM)
+		}
+		x.xvfb.Process.Signal(syscall.SIGTERM)
+		log.Printf("Stopped Xvfb on display %s", x.display)
+	})
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// monitorPollInterval is how often the monitor layout is checked for
+// monitors plugged in, unplugged or rearranged
+const monitorPollInterval = 2 * time.Second
+
+// watchMonitors follows changes of the monitor layout, restarting the
+// capture of changed monitors and sending clients the new layout. Capture
+// sources and the portal have a fixed layout.
+func (s *Server) watchMonitors() {
+	if s.captureSource != nil {
+		return
+	}
+	ticker := time.NewTicker(monitorPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+		desktop, monitors, err := s.layoutMonitors()
+		if err != nil {
+			// e.g. every monitor unplugged, the last layout stays until
+			// one is back
+			continue
+		}
+		s.updateMonitors(desktop, monitors)
+	}
+}
+
+// updateMonitors switches to a new monitor layout if it changed
+func (s *Server) updateMonitors(desktop, monitors *protocol.MonitorConfig) {
+	s.monitorsMutex.Lock()
+	if sameMonitors(desktop, s.desktop) && sameMonitors(monitors, s.monitors) {
+		s.monitorsMutex.Unlock()
+		return
+	}
+	log.Printf("Monitor layout changed from %d to %d monitors", len(s.monitors.Monitors), len(monitors.Monitors))
+
+	// A region is cropped from whichever monitor it is on, its capture
+	// restarts whenever the desktop changes
+	regionMoved := !s.captureRegion.Empty() && !sameMonitors(desktop, s.desktop)
+	old := make(map[uint32]protocol.MonitorInfo)
+	for _, monitor := range s.monitors.Monitors {
+		old[monitor.ID] = monitor
+	}
+	var changed []uint32
+	for _, monitor := range monitors.Monitors {
+		previous, ok := old[monitor.ID]
+		delete(old, monitor.ID)
+		if ok && previous == monitor && !regionMoved {
+			continue
+		}
+		log.Printf("Monitor %d is now %dx%d at (%d,%d)", monitor.ID, monitor.Width, monitor.Height,
+			int32(monitor.PositionX), int32(monitor.PositionY))
+		changed = append(changed, monitor.ID)
+	}
+	for id := range old {
+		log.Printf("Monitor %d was removed", id)
+		changed = append(changed, id)
+	}
+
+	s.desktop, s.monitors = desktop, monitors
+	for _, id := range changed {
+		s.stopCapture(id)
+	}
+	for _, monitor := range monitors.Monitors {
+		if slices.Contains(changed, monitor.ID) {
+			s.startCapture(monitor)
+		}
+	}
+	s.monitorsMutex.Unlock()
+
+	// Frames cached for the old layout would show at the wrong size
+	s.frameCacheMutex.Lock()
+	for _, id := range changed {
+		delete(s.frameCache, id)
+	}
+	s.frameCacheMutex.Unlock()
+	s.loadColorProfiles()
+
+	// Clients map the new layout again, as after the handshake. Added
+	// monitors are sent in the codec the others are.
+	type remapped struct {
+		client     *Client
+		monitorMap map[uint32]uint32
+		codecs     map[uint32]codec.ID // Codecs of the added monitors
+	}
+	var updates []remapped
+	s.clientsMutex.Lock()
+	for _, client := range s.clients {
+		s.mapMonitors(client, monitors)
+		update := remapped{client: client, monitorMap: maps.Clone(client.monitorMap), codecs: make(map[uint32]codec.ID)}
+		if client.offered != nil {
+			selected := codec.Select(client.offered, s.codecs)
+			for id := range client.monitorMap {
+				if _, ok := client.codecs[id]; !ok {
+					client.codecs[id] = selected
+					update.codecs[id] = selected
+				}
+			}
+		}
+		updates = append(updates, update)
+	}
+	s.clientsMutex.Unlock()
+
+	packet := protocol.NewPacket(protocol.PacketTypeMonitorConfig, protocol.EncodeMonitorConfig(monitors))
+	for _, update := range updates {
+		client := update.client
+		if err := client.send(packet); err != nil {
+			log.Printf("Error sending monitor layout to client %s: %v", client.id, err)
+			continue
+		}
+		s.sendColorProfiles(client, update.monitorMap)
+		for id, selected := range update.codecs {
+			if err := s.sendCodec(client, id, selected); err != nil {
+				log.Printf("Error sending codec selection to client %s: %v", client.id, err)
+				break
+			}
+		}
+	}
+
+	// Frames sent before the client had the layout and codecs were dropped,
+	// the next ones must be keyframes again
+	s.clientsMutex.Lock()
+	for _, update := range updates {
+		for id := range update.monitorMap {
+			update.client.keyframeNeeded[id] = true
+		}
+	}
+	s.clientsMutex.Unlock()
+}
+
+// sameMonitors reports whether two monitor layouts are the same
+func sameMonitors(a, b *protocol.MonitorConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return slices.Equal(a.Monitors, b.Monitors)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIdleAfter is how long a monitor's screen and the clients'
+	// input must be idle before the monitor is captured at idleInterval
+	DefaultIdleAfter = 5 * time.Second
+
+	// idleInterval is the time between captures of an idle monitor
+	idleInterval = time.Second
+)
+
+// WithIdleThrottle captures monitors whose screen didn't change and that
+// got no input for the given time at one frame per second, saving CPU and
+// bandwidth while the machine is idle. 0 captures at the full frame rate
+// all the time.
+func WithIdleThrottle(after time.Duration) Option {
+	return func(s *Server) {
+		s.idleAfter = after
+	}
+}
+
+// inputActivity wakes idle capture loops when a client sends input, which
+// is usually followed by a change on screen
+type inputActivity struct {
+	mutex sync.Mutex
+	last  time.Time
+	wake  chan struct{} // Closed on the next input
+}
+
+// activity records input from a client
+func (a *inputActivity) activity() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.last = time.Now()
+	if a.wake != nil {
+		close(a.wake)
+		a.wake = nil
+	}
+}
+
+// since returns the time since the last input and a channel closed on the
+// next one
+func (a *inputActivity) since() (time.Duration, <-chan struct{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.wake == nil {
+		a.wake = make(chan struct{})
+	}
+	return time.Since(a.last), a.wake
+}
+
+// idleThrottle tracks whether a monitor is idle
+type idleThrottle struct {
+	monitorID uint32
+	changed   time.Time // When a frame last differed from the one before
+	idle      bool
+}
+
+// change records a frame that differs from the one before
+func (t *idleThrottle) change() {
+	t.changed = time.Now()
+}
+
+// idleInterval returns the time until a monitor's next capture: the frame
+// interval, or idleInterval while the monitor is idle. The channel is
+// closed on input, which ends the idle wait right away.
+func (s *Server) idleInterval(t *idleThrottle, interval time.Duration) (time.Duration, <-chan struct{}) {
+	if s.idleAfter <= 0 {
+		return interval, nil
+	}
+	sinceInput, wake := s.input.since()
+	idle := time.Since(t.changed) >= s.idleAfter && sinceInput >= s.idleAfter
+	if idle != t.idle {
+		t.idle = idle
+		if idle {
+			log.Printf("Monitor %d is idle, capturing it every %v", t.monitorID, idleInterval)
+		} else {
+			log.Printf("Monitor %d is active again", t.monitorID)
+		}
+	}
+	if !idle {
+		return interval, nil
+	}
+	return max(interval, idleInterval), wake
+}
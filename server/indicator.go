@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// inputIdleTimeout is how long a client must stop sending input before the
+// remote control session is considered over
+const inputIdleTimeout = 5 * time.Second
+
+// inputIndicator tells the person sitting at the server when it is being
+// controlled remotely. It shows a desktop notification when a client starts
+// sending input and records the start and end of every burst in the audit log.
+type inputIndicator struct {
+	mutex     sync.Mutex
+	lastInput map[string]time.Time // Client ID -> time of last input
+	notify    bool
+	audit     *auditLog
+}
+
+// WithInputIndicator enables or disables the desktop notification shown when
+// remote input is injected. Audit log events are recorded either way.
+func WithInputIndicator(enabled bool) Option {
+	return func(s *Server) {
+		s.inputIndicator.notify = enabled
+	}
+}
+
+// newInputIndicator creates an indicator with notifications enabled
+func newInputIndicator() *inputIndicator {
+	return &inputIndicator{
+		lastInput: make(map[string]time.Time),
+		notify:    true,
+	}
+}
+
+// activity records input from a client, announcing it if the client was idle
+func (ind *inputIndicator) activity(clientID string) {
+	ind.mutex.Lock()
+	_, active := ind.lastInput[clientID]
+	ind.lastInput[clientID] = time.Now()
+	ind.mutex.Unlock()
+
+	if active {
+		return
+	}
+
+	log.Printf("Remote input from %s started", clientID)
+	ind.audit.record(AuditInputStarted, clientID, "")
+
+	if ind.notify {
+		go func() {
+			message := fmt.Sprintf("%s is controlling this computer", clientID)
+			if err := showNotification("UltraRDP", message); err != nil {
+				log.Printf("Failed to show input notification: %v", err)
+			}
+		}()
+	}
+}
+
+// clientGone ends any active input burst for a disconnected client
+func (ind *inputIndicator) clientGone(clientID string) {
+	ind.mutex.Lock()
+	_, active := ind.lastInput[clientID]
+	delete(ind.lastInput, clientID)
+	ind.mutex.Unlock()
+
+	if active {
+		ind.audit.record(AuditInputStopped, clientID, "client disconnected")
+	}
+}
+
+// run expires idle input bursts until stop is closed
+func (ind *inputIndicator) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ind.mutex.Lock()
+		for clientID, last := range ind.lastInput {
+			if time.Since(last) < inputIdleTimeout {
+				continue
+			}
+			delete(ind.lastInput, clientID)
+			log.Printf("Remote input from %s stopped", clientID)
+			ind.audit.record(AuditInputStopped, clientID, "")
+		}
+		ind.mutex.Unlock()
+	}
+}
+
+// showNotification displays a desktop notification using the platform's
+// standard notification tool
+func showNotification(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux":
+		cmd = exec.Command("notify-send", "--urgency=low", "--icon=dialog-information", title, message)
+	case "windows":
+		script := fmt.Sprintf(`[reflection.assembly]::loadwithpartialname('System.Windows.Forms') | Out-Null;`+
+			`$n = New-Object System.Windows.Forms.NotifyIcon;`+
+			`$n.Icon = [System.Drawing.SystemIcons]::Information;`+
+			`$n.Visible = $true;`+
+			`$n.ShowBalloonTip(5000, '%s', '%s', 'Info');`+
+			`Start-Sleep -Seconds 6; $n.Dispose()`, title, message)
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		return fmt.Errorf("notifications not supported on %s", runtime.GOOS)
+	}
+	return cmd.Run()
+}
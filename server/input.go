@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"image"
+	"log"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// errInputUnsupported is returned by the injection functions on platforms
+// without an input injection backend
+var errInputUnsupported = errors.New("input injection is not supported on this platform")
+
+// inputWarning makes sure failing injection is only logged once per reason
+var inputWarning sync.Map
+
+// handleKeyboard injects a key event received from a client
+func (s *Server) handleKeyboard(client *Client, payload []byte) {
+	event, err := protocol.DecodeKeyEvent(payload)
+	if err != nil {
+		log.Printf("Invalid keyboard packet from client %s: %v", client.id, err)
+		return
+	}
+
+	client.heldKeysMutex.Lock()
+	if event.Down {
+		if client.heldKeys == nil {
+			client.heldKeys = make(map[protocol.KeyCode]bool)
+		}
+		client.heldKeys[event.Code] = true
+	} else {
+		delete(client.heldKeys, event.Code)
+	}
+	client.heldKeysMutex.Unlock()
+
+	if err := injectKey(event); err != nil {
+		s.reportInputPermission(client, err)
+		if _, logged := inputWarning.LoadOrStore(err.Error(), true); !logged {
+			log.Printf("Failed to inject key %v: %v", event.Code, err)
+		}
+	}
+}
+
+// releaseKeys releases the keys a client still held when its connection
+// dropped, they would stay stuck down otherwise
+func (s *Server) releaseKeys(client *Client) {
+	client.heldKeysMutex.Lock()
+	defer client.heldKeysMutex.Unlock()
+
+	for code := range client.heldKeys {
+		injectKey(protocol.KeyEvent{Code: code})
+	}
+	client.heldKeys = nil
+}
+
+// handleMouseMove moves the pointer where a client moved it, and records
+// the position for the region of interest
+func (s *Server) handleMouseMove(client *Client, payload []byte) {
+	monitorID, x, y, err := protocol.DecodeMouseMove(payload)
+	if err != nil {
+		log.Printf("Invalid mouse move from client %s: %v", client.id, err)
+		return
+	}
+	s.cursor.set(monitorID, image.Pt(x, y))
+
+	position, ok := s.desktopPosition(monitorID, x, y)
+	if !ok {
+		return
+	}
+	if err := injectMouseMove(position); err != nil {
+		s.reportInputPermission(client, err)
+		if _, logged := inputWarning.LoadOrStore(err.Error(), true); !logged {
+			log.Printf("Failed to inject mouse move: %v", err)
+		}
+	}
+}
+
+// handleMouseButton injects a mouse button press or release received from
+// a client, at the position it was pressed at
+func (s *Server) handleMouseButton(client *Client, payload []byte) {
+	event, err := protocol.DecodeMouseButton(payload)
+	if err != nil {
+		log.Printf("Invalid mouse button packet from client %s: %v", client.id, err)
+		return
+	}
+	position, ok := s.desktopPosition(event.MonitorID, event.X, event.Y)
+	if !ok {
+		return
+	}
+	s.cursor.set(event.MonitorID, image.Pt(event.X, event.Y))
+
+	if err := injectMouseButton(position, event.Button, event.Down); err != nil {
+		s.reportInputPermission(client, err)
+		if _, logged := inputWarning.LoadOrStore(err.Error(), true); !logged {
+			log.Printf("Failed to inject mouse button %d: %v", event.Button, err)
+		}
+	}
+}
+
+// handleMouseWheel injects a scroll received from a client, at the
+// position it scrolled at
+func (s *Server) handleMouseWheel(client *Client, payload []byte) {
+	event, err := protocol.DecodeMouseWheel(payload)
+	if err != nil {
+		log.Printf("Invalid mouse wheel packet from client %s: %v", client.id, err)
+		return
+	}
+	position, ok := s.desktopPosition(event.MonitorID, event.X, event.Y)
+	if !ok {
+		return
+	}
+
+	if err := injectMouseWheel(position, event.DX, event.DY); err != nil {
+		s.reportInputPermission(client, err)
+		if _, logged := inputWarning.LoadOrStore(err.Error(), true); !logged {
+			log.Printf("Failed to inject mouse wheel: %v", err)
+		}
+	}
+}
+
+// desktopPosition returns where a position in a monitor's pixels is on the
+// desktop, false for an unknown monitor
+func (s *Server) desktopPosition(monitorID uint32, x, y int) (image.Point, bool) {
+	for _, monitor := range s.monitorConfig().Monitors {
+		if monitor.ID == monitorID {
+			x, y = min(x, int(monitor.Width)-1), min(y, int(monitor.Height)-1)
+			return image.Pt(int(int32(monitor.PositionX))+x, int(int32(monitor.PositionY))+y), true
+		}
+	}
+	return image.Point{}, false
+}
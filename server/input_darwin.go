@@ -0,0 +1,325 @@
+//go:build darwin && cgo
+
+package server
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework AppKit -framework ApplicationServices
+#include <stdint.h>
+#import <AppKit/AppKit.h>
+#import <ApplicationServices/ApplicationServices.h>
+
+// input_trusted returns 1 if the process may post input events, without
+// the Accessibility permission macOS silently drops them
+static int input_trusted(void) {
+	return AXIsProcessTrusted() ? 1 : 0;
+}
+
+// input_request shows the system prompt for the Accessibility permission
+static void input_request(void) {
+	const void *keys[] = {kAXTrustedCheckOptionPrompt};
+	const void *values[] = {kCFBooleanTrue};
+	CFDictionaryRef options = CFDictionaryCreate(NULL, keys, values, 1,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	AXIsProcessTrustedWithOptions(options);
+	CFRelease(options);
+}
+
+// Events are posted as if from the hardware, so the system keeps track of
+// the pressed keys and buttons
+static CGEventSourceRef input_source;
+
+static void input_open(void) {
+	input_source = CGEventSourceCreate(kCGEventSourceStateHIDSystemState);
+}
+
+static int post_event(CGEventRef event, uint64_t flags) {
+	if (event == NULL) {
+		return 0;
+	}
+	CGEventSetFlags(event, (CGEventFlags)flags);
+	CGEventPost(kCGHIDEventTap, event);
+	CFRelease(event);
+	return 1;
+}
+
+static int post_key(uint16_t code, int down, uint64_t flags) {
+	return post_event(CGEventCreateKeyboardEvent(input_source, (CGKeyCode)code, down != 0), flags);
+}
+
+// post_media_key posts a media key the way the keyboard does, as a system
+// defined event of subtype 8 carrying its NX_KEYTYPE
+static int post_media_key(int key, int down) {
+	@autoreleasepool {
+		int state = down ? 0xA : 0xB;
+		NSEvent *event = [NSEvent otherEventWithType:NSEventTypeSystemDefined
+			location:NSZeroPoint
+			modifierFlags:(NSEventModifierFlags)(state << 8)
+			timestamp:0
+			windowNumber:0
+			context:nil
+			subtype:8
+			data1:(key << 16) | (state << 8)
+			data2:-1];
+		if (event == nil) {
+			return 0;
+		}
+		CGEventPost(kCGHIDEventTap, [event CGEvent]);
+		return 1;
+	}
+}
+
+static int post_mouse(int type, double x, double y, int button, int64_t clicks, uint64_t flags) {
+	CGEventRef event = CGEventCreateMouseEvent(input_source, (CGEventType)type, CGPointMake(x, y), (CGMouseButton)button);
+	if (event != NULL && clicks > 0) {
+		CGEventSetIntegerValueField(event, kCGMouseEventClickState, clicks);
+	}
+	return post_event(event, flags);
+}
+
+static int post_scroll(int pixels, int32_t dy, int32_t dx, uint64_t flags) {
+	CGScrollEventUnit unit = pixels ? kCGScrollEventUnitPixel : kCGScrollEventUnitLine;
+	return post_event(CGEventCreateScrollWheelEvent2(input_source, unit, 2, dy, dx, 0), flags);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// CGEventType values from CGEventTypes.h
+const (
+	cgLeftMouseDown     = 1
+	cgLeftMouseUp       = 2
+	cgRightMouseDown    = 3
+	cgRightMouseUp      = 4
+	cgMouseMoved        = 5
+	cgLeftMouseDragged  = 6
+	cgRightMouseDragged = 7
+	cgOtherMouseDown    = 25
+	cgOtherMouseUp      = 26
+	cgOtherMouseDragged = 27
+)
+
+// macDoubleClick is how soon and how close a press follows the last one to
+// count as a double click, macOS leaves counting clicks to the sender
+const (
+	macDoubleClick         = 500 * time.Millisecond
+	macDoubleClickDistance = 4
+)
+
+// macModifierFlags are the CGEventFlags of the modifier keys
+var macModifierFlags = map[protocol.KeyCode]uint64{
+	protocol.KeyLeftShift:    0x20000,
+	protocol.KeyRightShift:   0x20000,
+	protocol.KeyLeftControl:  0x40000,
+	protocol.KeyRightControl: 0x40000,
+	protocol.KeyLeftAlt:      0x80000,
+	protocol.KeyRightAlt:     0x80000,
+	protocol.KeyLeftSuper:    0x100000,
+	protocol.KeyRightSuper:   0x100000,
+}
+
+// macInput is the state of the injected input. Events carry the modifiers
+// and click counts themselves, and moves with a button held are drags.
+var macInput struct {
+	sync.Mutex
+	open      sync.Once
+	modifiers map[protocol.KeyCode]bool // Modifier keys held
+	buttons   map[protocol.MouseButton]bool
+	lastPress time.Time
+	lastAt    image.Point
+	lastOf    protocol.MouseButton
+	clicks    int64
+}
+
+func init() {
+	checkInputPermission = darwinInputPermission
+	requestInputPermission = func() { C.input_request() }
+}
+
+// darwinInputPermission checks the Accessibility permission, without which
+// injected input is dropped. Like Screen Recording macOS grants it to the
+// app the server runs in.
+func darwinInputPermission() *PermissionError {
+	if C.input_trusted() != 0 {
+		return nil
+	}
+	name := "the server"
+	if path, err := os.Executable(); err == nil {
+		name = filepath.Base(path)
+	}
+	return &PermissionError{
+		Permission: "Accessibility",
+		Guidance: fmt.Sprintf("open System Settings > Privacy & Security > Accessibility, "+
+			"allow %s or the terminal running it, then restart it", name),
+	}
+}
+
+// beginInput locks the injection state, returning the missing permission
+// if events would be dropped
+func beginInput() error {
+	if err := darwinInputPermission(); err != nil {
+		return err
+	}
+	macInput.Lock()
+	macInput.open.Do(func() {
+		C.input_open()
+		macInput.modifiers = make(map[protocol.KeyCode]bool)
+		macInput.buttons = make(map[protocol.MouseButton]bool)
+	})
+	return nil
+}
+
+// modifierFlags returns the CGEventFlags of the held modifiers
+func modifierFlags() C.uint64_t {
+	var flags uint64
+	for code := range macInput.modifiers {
+		flags |= macModifierFlags[code]
+	}
+	return C.uint64_t(flags)
+}
+
+// injectKey posts a key press or release with CGEvent, or the system event
+// of a media key
+func injectKey(event protocol.KeyEvent) error {
+	key, ok := event.Code.Mac()
+	if !ok {
+		return fmt.Errorf("no macOS mapping for key %v", event.Code)
+	}
+	if err := beginInput(); err != nil {
+		return err
+	}
+	defer macInput.Unlock()
+
+	down := C.int(0)
+	if event.Down {
+		down = 1
+	}
+	if key.Media {
+		if C.post_media_key(C.int(key.KeyCode), down) == 0 {
+			return errors.New("can't create media key event")
+		}
+		return nil
+	}
+	if _, ok := macModifierFlags[event.Code]; ok {
+		if event.Down {
+			macInput.modifiers[event.Code] = true
+		} else {
+			delete(macInput.modifiers, event.Code)
+		}
+	}
+	if C.post_key(C.uint16_t(key.KeyCode), down, modifierFlags()) == 0 {
+		return errors.New("can't create key event")
+	}
+	return nil
+}
+
+// injectMouseMove moves the pointer to a desktop position, in points, with
+// CGEvent, dragging if a button is held
+func injectMouseMove(position image.Point) error {
+	if err := beginInput(); err != nil {
+		return err
+	}
+	defer macInput.Unlock()
+	return postMouseMove(position)
+}
+
+func postMouseMove(position image.Point) error {
+	eventType, button := cgMouseMoved, protocol.MouseLeft
+	switch {
+	case macInput.buttons[protocol.MouseLeft]:
+		eventType = cgLeftMouseDragged
+	case macInput.buttons[protocol.MouseRight]:
+		eventType, button = cgRightMouseDragged, protocol.MouseRight
+	case len(macInput.buttons) > 0:
+		for held := range macInput.buttons {
+			eventType, button = cgOtherMouseDragged, held
+		}
+	}
+	if C.post_mouse(C.int(eventType), C.double(position.X), C.double(position.Y), C.int(button), 0, modifierFlags()) == 0 {
+		return errors.New("can't create mouse move event")
+	}
+	return nil
+}
+
+// injectMouseButton presses or releases a button at a desktop position with
+// CGEvent, counting presses in quick succession as double and triple
+// clicks
+func injectMouseButton(position image.Point, button protocol.MouseButton, down bool) error {
+	if button > protocol.MouseForward {
+		return fmt.Errorf("unknown mouse button %d", button)
+	}
+	if err := beginInput(); err != nil {
+		return err
+	}
+	defer macInput.Unlock()
+
+	if down {
+		now := time.Now()
+		near := position.Sub(macInput.lastAt)
+		if button == macInput.lastOf && now.Sub(macInput.lastPress) < macDoubleClick &&
+			max(near.X, -near.X, near.Y, -near.Y) <= macDoubleClickDistance {
+			macInput.clicks++
+		} else {
+			macInput.clicks = 1
+		}
+		macInput.lastPress, macInput.lastAt, macInput.lastOf = now, position, button
+		macInput.buttons[button] = true
+	} else {
+		delete(macInput.buttons, button)
+	}
+
+	var eventType int
+	switch {
+	case button == protocol.MouseLeft && down:
+		eventType = cgLeftMouseDown
+	case button == protocol.MouseLeft:
+		eventType = cgLeftMouseUp
+	case button == protocol.MouseRight && down:
+		eventType = cgRightMouseDown
+	case button == protocol.MouseRight:
+		eventType = cgRightMouseUp
+	case down:
+		eventType = cgOtherMouseDown
+	default:
+		eventType = cgOtherMouseUp
+	}
+	// CGMouseButton numbers the buttons like the protocol
+	if C.post_mouse(C.int(eventType), C.double(position.X), C.double(position.Y), C.int(button), C.int64_t(macInput.clicks), modifierFlags()) == 0 {
+		return errors.New("can't create mouse button event")
+	}
+	return nil
+}
+
+// injectMouseWheel moves the pointer to a desktop position and scrolls
+// there with CGEvent, by lines for whole wheel notches and by pixels for
+// finer scrolls
+func injectMouseWheel(position image.Point, dx, dy int) error {
+	if err := beginInput(); err != nil {
+		return err
+	}
+	defer macInput.Unlock()
+
+	if err := postMouseMove(position); err != nil {
+		return err
+	}
+	// Positive horizontal scrolls go left on macOS
+	pixels, x, y := C.int(0), -dx/120, dy/120
+	if dx%120 != 0 || dy%120 != 0 {
+		pixels, x, y = 1, -dx/3, dy/3
+	}
+	if C.post_scroll(pixels, C.int32_t(y), C.int32_t(x), modifierFlags()) == 0 {
+		return errors.New("can't create scroll event")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// inputBackend injects input into the session of a display server
+type inputBackend interface {
+	key(code uint16, down bool) error // Linux input event code
+	mouseMove(position image.Point) error
+	mouseButton(position image.Point, button protocol.MouseButton, down bool) error
+	mouseWheel(position image.Point, dx, dy int) error
+}
+
+// openInputBackend opens the input backend of the session the server runs
+// in. Set by the backends built in, it returns errInputUnsupported without
+// one.
+var openInputBackend = func() (inputBackend, error) { return nil, errInputUnsupported }
+
+// linuxInput is the input backend, opened with the first input
+var linuxInput struct {
+	once    sync.Once
+	backend inputBackend
+	err     error
+}
+
+func inputSession() (inputBackend, error) {
+	linuxInput.once.Do(func() {
+		linuxInput.backend, linuxInput.err = openInputBackend()
+	})
+	return linuxInput.backend, linuxInput.err
+}
+
+// injectKey injects a key press or release through the session's backend
+func injectKey(event protocol.KeyEvent) error {
+	code, ok := event.Code.Linux()
+	if !ok {
+		return fmt.Errorf("no Linux mapping for key %v", event.Code)
+	}
+	backend, err := inputSession()
+	if err != nil {
+		return err
+	}
+	return backend.key(code, event.Down)
+}
+
+// injectMouseMove moves the pointer to a desktop position through the
+// session's backend
+func injectMouseMove(position image.Point) error {
+	backend, err := inputSession()
+	if err != nil {
+		return err
+	}
+	return backend.mouseMove(position)
+}
+
+// injectMouseButton presses or releases a button at a desktop position
+// through the session's backend
+func injectMouseButton(position image.Point, button protocol.MouseButton, down bool) error {
+	backend, err := inputSession()
+	if err != nil {
+		return err
+	}
+	return backend.mouseButton(position, button, down)
+}
+
+// injectMouseWheel scrolls at a desktop position through the session's
+// backend
+func injectMouseWheel(position image.Point, dx, dy int) error {
+	backend, err := inputSession()
+	if err != nil {
+		return err
+	}
+	return backend.mouseWheel(position, dx, dy)
+}
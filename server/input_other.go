@@ -0,0 +1,29 @@
+//go:build !windows && !linux && !(darwin && cgo)
+
+package server
+
+import (
+	"image"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// injectKey is not implemented on this platform yet
+func injectKey(event protocol.KeyEvent) error {
+	return errInputUnsupported
+}
+
+// injectMouseMove is not implemented on this platform yet
+func injectMouseMove(position image.Point) error {
+	return errInputUnsupported
+}
+
+// injectMouseButton is not implemented on this platform yet
+func injectMouseButton(position image.Point, button protocol.MouseButton, down bool) error {
+	return errInputUnsupported
+}
+
+// injectMouseWheel is not implemented on this platform yet
+func injectMouseWheel(position image.Point, dx, dy int) error {
+	return errInputUnsupported
+}
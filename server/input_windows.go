@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"image"
+	"syscall"
+	"unsafe"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+var (
+	user32               = syscall.NewLazyDLL("user32.dll")
+	procSendInput        = user32.NewProc("SendInput")
+	procGetSystemMetrics = user32.NewProc("GetSystemMetrics")
+)
+
+// SendInput constants from winuser.h
+const (
+	inputMouse    = 0
+	inputKeyboard = 1
+
+	mouseeventfMove        = 0x0001
+	mouseeventfLeftDown    = 0x0002
+	mouseeventfLeftUp      = 0x0004
+	mouseeventfRightDown   = 0x0008
+	mouseeventfRightUp     = 0x0010
+	mouseeventfMiddleDown  = 0x0020
+	mouseeventfMiddleUp    = 0x0040
+	mouseeventfXDown       = 0x0080
+	mouseeventfXUp         = 0x0100
+	mouseeventfWheel       = 0x0800
+	mouseeventfHWheel      = 0x1000
+	mouseeventfVirtualDesk = 0x4000
+	mouseeventfAbsolute    = 0x8000
+
+	smXVirtualScreen  = 76
+	smYVirtualScreen  = 77
+	smCXVirtualScreen = 78
+	smCYVirtualScreen = 79
+
+	xbutton1 = 0x0001
+	xbutton2 = 0x0002
+
+	keyeventfExtendedKey = 0x0001
+	keyeventfKeyUp       = 0x0002
+	keyeventfScancode    = 0x0008
+)
+
+// keyboardInput is an INPUT structure holding a KEYBDINPUT. The padding
+// makes it as large as the union's biggest member, MOUSEINPUT, which
+// SendInput checks through its cbSize argument.
+type keyboardInput struct {
+	inputType uint32
+	ki        keybdInput
+	padding   [8]byte
+}
+
+// mouseInput is an INPUT structure holding a MOUSEINPUT
+type mouseInput struct {
+	inputType uint32
+	mi        msInput
+}
+
+type msInput struct {
+	dx, dy    int32
+	mouseData uint32
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+type keybdInput struct {
+	vk        uint16
+	scan      uint16
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+// injectKey injects a key press or release with SendInput. Regular keys
+// are sent by scancode so the server's keyboard layout applies like for a
+// local keyboard; media keys, Pause and Num Lock are sent by virtual key
+// since Windows ignores their scancodes. Extended keys (arrows, right
+// modifiers, Win keys, media keys) carry KEYEVENTF_EXTENDEDKEY, without it
+// e.g. Right Ctrl turns into Left Ctrl and the arrows into keypad digits.
+//
+// Windows never lets injected input trigger Ctrl+Alt+Del or Win+L.
+func injectKey(event protocol.KeyEvent) error {
+	key, ok := event.Code.Windows()
+	if !ok {
+		return fmt.Errorf("no Windows mapping for key %v", event.Code)
+	}
+
+	input := keyboardInput{
+		inputType: inputKeyboard,
+		ki:        keybdInput{scan: key.Scancode},
+	}
+	if key.VirtualKey != 0 {
+		input.ki.vk = key.VirtualKey
+	} else {
+		input.ki.flags |= keyeventfScancode
+	}
+	if key.Extended {
+		input.ki.flags |= keyeventfExtendedKey
+	}
+	if !event.Down {
+		input.ki.flags |= keyeventfKeyUp
+	}
+
+	sent, _, err := procSendInput.Call(1, uintptr(unsafe.Pointer(&input)), unsafe.Sizeof(input))
+	if sent != 1 {
+		// Input is blocked by UIPI when the foreground window runs elevated
+		return fmt.Errorf("SendInput failed: %v", err)
+	}
+	return nil
+}
+
+// mouseButtonFlags are the MOUSEEVENTF flags pressing and releasing each
+// button, and the mouse data telling which side button it is
+var mouseButtonFlags = map[protocol.MouseButton][3]uint32{
+	protocol.MouseLeft:    {mouseeventfLeftDown, mouseeventfLeftUp, 0},
+	protocol.MouseRight:   {mouseeventfRightDown, mouseeventfRightUp, 0},
+	protocol.MouseMiddle:  {mouseeventfMiddleDown, mouseeventfMiddleUp, 0},
+	protocol.MouseBack:    {mouseeventfXDown, mouseeventfXUp, xbutton1},
+	protocol.MouseForward: {mouseeventfXDown, mouseeventfXUp, xbutton2},
+}
+
+// injectMouseMove moves the pointer to a desktop position with SendInput
+func injectMouseMove(position image.Point) error {
+	return sendMouseInputs(mouseMoveTo(position))
+}
+
+// injectMouseButton moves the pointer to a desktop position and presses or
+// releases a button there with SendInput
+func injectMouseButton(position image.Point, button protocol.MouseButton, down bool) error {
+	flags, ok := mouseButtonFlags[button]
+	if !ok {
+		return fmt.Errorf("unknown mouse button %d", button)
+	}
+	event := mouseInput{inputType: inputMouse, mi: msInput{mouseData: flags[2], flags: flags[1]}}
+	if down {
+		event.mi.flags = flags[0]
+	}
+	return sendMouseInputs(mouseMoveTo(position), event)
+}
+
+// injectMouseWheel moves the pointer to a desktop position and scrolls
+// there with SendInput
+func injectMouseWheel(position image.Point, dx, dy int) error {
+	inputs := []mouseInput{mouseMoveTo(position)}
+	if dy != 0 {
+		inputs = append(inputs, mouseInput{inputType: inputMouse, mi: msInput{mouseData: uint32(int32(dy)), flags: mouseeventfWheel}})
+	}
+	if dx != 0 {
+		inputs = append(inputs, mouseInput{inputType: inputMouse, mi: msInput{mouseData: uint32(int32(dx)), flags: mouseeventfHWheel}})
+	}
+	return sendMouseInputs(inputs...)
+}
+
+// mouseMoveTo returns the input moving the pointer to a desktop position,
+// given to SendInput from 0 to 65535 across the virtual screen
+func mouseMoveTo(position image.Point) mouseInput {
+	left, _, _ := procGetSystemMetrics.Call(smXVirtualScreen)
+	top, _, _ := procGetSystemMetrics.Call(smYVirtualScreen)
+	width, _, _ := procGetSystemMetrics.Call(smCXVirtualScreen)
+	height, _, _ := procGetSystemMetrics.Call(smCYVirtualScreen)
+	x := (position.X - int(int32(left))) * 65535 / max(int(width)-1, 1)
+	y := (position.Y - int(int32(top))) * 65535 / max(int(height)-1, 1)
+	return mouseInput{
+		inputType: inputMouse,
+		mi:        msInput{dx: int32(x), dy: int32(y), flags: mouseeventfMove | mouseeventfAbsolute | mouseeventfVirtualDesk},
+	}
+}
+
+// sendMouseInputs injects mouse inputs at once with SendInput
+func sendMouseInputs(inputs ...mouseInput) error {
+	sent, _, err := procSendInput.Call(uintptr(len(inputs)), uintptr(unsafe.Pointer(&inputs[0])), unsafe.Sizeof(inputs[0]))
+	if int(sent) != len(inputs) {
+		return fmt.Errorf("SendInput failed: %v", err)
+	}
+	return nil
+}
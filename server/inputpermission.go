@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// checkInputPermission returns the missing permission to inject input,
+// nil if it was granted. Set by platforms asking for one.
+var checkInputPermission = func() *PermissionError { return nil }
+
+// requestInputPermission has the OS ask the user for the permission to
+// inject input
+var requestInputPermission = func() {}
+
+// checkInputInjection asks the user for the permission to inject input at
+// startup if it is missing
+func (s *Server) checkInputInjection() {
+	if err := checkInputPermission(); err != nil {
+		log.Printf("Warning: %v", err)
+		requestInputPermission()
+	}
+}
+
+// reportInputPermission tells a client once if its input failed to be
+// injected for a missing permission
+func (s *Server) reportInputPermission(client *Client, err error) {
+	var permission *PermissionError
+	if !errors.As(err, &permission) || client.inputPermissionReported.Swap(true) {
+		return
+	}
+	client.send(permissionPacket(protocol.ErrorAccessibilityPermission, permission))
+}
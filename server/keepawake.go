@@ -0,0 +1,58 @@
+package server
+
+import (
+	"log"
+	"sync"
+)
+
+// WithKeepAwake keeps the server's display from sleeping while clients are
+// connected, so it isn't streamed black mid-session
+func WithKeepAwake(enabled bool) Option {
+	return func(s *Server) {
+		s.keepAwake.enabled = enabled
+	}
+}
+
+// wakeLock keeps the display on until released
+type wakeLock interface {
+	release()
+}
+
+// acquireWakeLock asks the OS to keep the display on. Set by platforms
+// supporting it.
+var acquireWakeLock func(reason string) (wakeLock, error)
+
+// keepAwake holds a wake lock while clients are connected
+type keepAwake struct {
+	mutex   sync.Mutex
+	enabled bool
+	lock    wakeLock // nil while released
+	failed  bool     // Whether acquiring failed, it isn't retried
+}
+
+// updateKeepAwake holds the wake lock while clients are connected, until
+// the server stops
+func (s *Server) updateKeepAwake() {
+	k := &s.keepAwake
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	s.clientsMutex.Lock()
+	wanted := len(s.clients) > 0 && !s.stopped
+	s.clientsMutex.Unlock()
+
+	switch {
+	case wanted && k.lock == nil && k.enabled && !k.failed && acquireWakeLock != nil:
+		lock, err := acquireWakeLock("UltraRDP clients are connected")
+		if err != nil {
+			log.Printf("Failed to keep the display awake: %v", err)
+			k.failed = true
+			return
+		}
+		k.lock = lock
+		log.Println("Keeping the display awake while clients are connected")
+	case !wanted && k.lock != nil:
+		k.lock.release()
+		k.lock = nil
+		log.Println("Display may sleep again")
+	}
+}
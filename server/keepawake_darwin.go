@@ -0,0 +1,50 @@
+//go:build darwin && cgo
+
+package server
+
+/*
+#cgo LDFLAGS: -framework IOKit -framework CoreFoundation
+#include <stdlib.h>
+#include <IOKit/pwr_mgt/IOPMLib.h>
+
+// awake_acquire creates an assertion keeping the display on, returning 0
+// on failure
+static IOPMAssertionID awake_acquire(const char *reason) {
+	CFStringRef name = CFStringCreateWithCString(NULL, reason, kCFStringEncodingUTF8);
+	IOPMAssertionID id = 0;
+	IOReturn result = IOPMAssertionCreateWithName(kIOPMAssertionTypePreventUserIdleDisplaySleep,
+		kIOPMAssertionLevelOn, name, &id);
+	CFRelease(name);
+	return result == kIOReturnSuccess ? id : 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+func init() {
+	acquireWakeLock = acquirePowerAssertion
+}
+
+// powerAssertion is an IOKit power assertion preventing display sleep,
+// which also keeps the system awake
+type powerAssertion struct {
+	id C.IOPMAssertionID
+}
+
+func acquirePowerAssertion(reason string) (wakeLock, error) {
+	name := C.CString(reason)
+	defer C.free(unsafe.Pointer(name))
+	id := C.awake_acquire(name)
+	if id == 0 {
+		return nil, errors.New("can't create power assertion")
+	}
+	return &powerAssertion{id: id}, nil
+}
+
+func (p *powerAssertion) release() {
+	C.IOPMAssertionRelease(p.id)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func init() {
+	acquireWakeLock = acquireInhibitors
+}
+
+// inhibitors keeps a Linux desktop awake over D-Bus: the screen saver
+// doesn't blank the display, and logind, as systemd-inhibit does, neither
+// suspends on idle nor lets the desktop's idle handling through
+type inhibitors struct {
+	session *dbus.Conn // Holding the screen saver inhibition, nil without one
+	cookie  uint32
+	logind  *os.File // Inhibitor lock, nil without one
+}
+
+func acquireInhibitors(reason string) (wakeLock, error) {
+	in := &inhibitors{}
+	var errs []error
+	if conn, err := dbus.ConnectSessionBus(); err != nil {
+		errs = append(errs, fmt.Errorf("session bus: %w", err))
+	} else if err := conn.Object("org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver").
+		Call("org.freedesktop.ScreenSaver.Inhibit", 0, "UltraRDP", reason).Store(&in.cookie); err != nil {
+		conn.Close()
+		errs = append(errs, fmt.Errorf("screen saver: %w", err))
+	} else {
+		in.session = conn
+	}
+
+	// The lock is held until the descriptor is closed
+	if conn, err := dbus.ConnectSystemBus(); err != nil {
+		errs = append(errs, fmt.Errorf("system bus: %w", err))
+	} else {
+		var fd dbus.UnixFD
+		err := conn.Object("org.freedesktop.login1", "/org/freedesktop/login1").
+			Call("org.freedesktop.login1.Manager.Inhibit", 0, "idle:sleep", "UltraRDP", reason, "block").Store(&fd)
+		conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("logind: %w", err))
+		} else {
+			in.logind = os.NewFile(uintptr(fd), "inhibitor")
+		}
+	}
+
+	if in.session == nil && in.logind == nil {
+		return nil, errors.Join(errs...)
+	}
+	return in, nil
+}
+
+func (in *inhibitors) release() {
+	if in.session != nil {
+		in.session.Object("org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver").
+			Call("org.freedesktop.ScreenSaver.UnInhibit", 0, in.cookie)
+		in.session.Close()
+	}
+	if in.logind != nil {
+		in.logind.Close()
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+var (
+	kernel32                    = syscall.NewLazyDLL("kernel32.dll")
+	procSetThreadExecutionState = kernel32.NewProc("SetThreadExecutionState")
+)
+
+const (
+	esSystemRequired  = 0x00000001
+	esDisplayRequired = 0x00000002
+	esContinuous      = 0x80000000
+)
+
+func init() {
+	acquireWakeLock = acquireExecutionState
+}
+
+// executionState keeps the display on with SetThreadExecutionState, whose
+// state belongs to a thread. A thread of its own holds it until released.
+type executionState struct {
+	done chan struct{}
+}
+
+func acquireExecutionState(reason string) (wakeLock, error) {
+	state := &executionState{done: make(chan struct{})}
+	failed := make(chan error, 1)
+	go func() {
+		// The thread exits with the goroutine, ending the state if
+		// clearing it fails
+		runtime.LockOSThread()
+		if r, _, err := procSetThreadExecutionState.Call(esContinuous | esSystemRequired | esDisplayRequired); r == 0 {
+			failed <- fmt.Errorf("SetThreadExecutionState failed: %v", err)
+			return
+		}
+		failed <- nil
+		<-state.done
+		procSetThreadExecutionState.Call(esContinuous)
+	}()
+	if err := <-failed; err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (e *executionState) release() {
+	close(e.done)
+}
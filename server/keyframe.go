@@ -0,0 +1,63 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// keyframeRequestInterval is the shortest time between two keyframe
+// requests of a client that are honored. A keyframe takes a round trip to
+// arrive and requests for the same damage keep coming until then.
+const keyframeRequestInterval = 500 * time.Millisecond
+
+// WithKeyframeInterval makes video codecs send a keyframe every frames
+// frames, on top of those clients need to join a stream or recover from
+// loss. Short intervals heal damaged pictures sooner but cost bandwidth,
+// keyframes are several times larger than other frames. 0, the default,
+// sends keyframes only when needed.
+func WithKeyframeInterval(frames int) Option {
+	return func(s *Server) {
+		s.keyframeInterval.Store(int32(max(frames, 0)))
+	}
+}
+
+// SetKeyframeInterval changes the keyframe interval of a running server,
+// see WithKeyframeInterval
+func (s *Server) SetKeyframeInterval(frames int) {
+	s.keyframeInterval.Store(int32(max(frames, 0)))
+	log.Printf("Keyframe interval set to %d frames", max(frames, 0))
+}
+
+// RequestKeyframe makes the next frame of a monitor a keyframe for every
+// client, of every monitor if monitorID is 0
+func (s *Server) RequestKeyframe(monitorID uint32) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	for _, client := range s.clients {
+		for id := range client.monitorMap {
+			if monitorID == 0 || id == monitorID {
+				client.keyframeNeeded[id] = true
+			}
+		}
+	}
+}
+
+// handleKeyframeRequest makes the next frame of a monitor a keyframe for
+// a client whose picture is damaged, e.g. after losing frames over UDP
+func (s *Server) handleKeyframeRequest(client *Client, payload []byte) {
+	if len(payload) < 4 {
+		log.Printf("Invalid keyframe request packet from client %s", client.id)
+		return
+	}
+	monitorID := protocol.BytesToUint32(payload)
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if _, ok := client.monitorMap[monitorID]; !ok || time.Since(client.keyframeAsked[monitorID]) < keyframeRequestInterval {
+		return
+	}
+	client.keyframeAsked[monitorID] = time.Now()
+	client.keyframeNeeded[monitorID] = true
+}
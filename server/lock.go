@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// lockPollInterval is how often the OS lock state is checked
+const lockPollInterval = 2 * time.Second
+
+// WithPauseOnLock pauses video streaming while the server's screen is locked.
+// Clients are sent a placeholder frame and a lock state packet instead.
+func WithPauseOnLock(enabled bool) Option {
+	return func(s *Server) {
+		s.pauseOnLock = enabled
+	}
+}
+
+// isPaused reports whether capture is currently paused because of the lock screen
+func (s *Server) isPaused() bool {
+	return s.pauseOnLock && s.screenLocked.Load()
+}
+
+// watchLockState polls the OS lock state and notifies clients of changes
+func (s *Server) watchLockState() {
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		locked, err := isScreenLocked()
+		if err != nil {
+			log.Printf("Lock state detection unavailable, disabling pause on lock: %v", err)
+			return
+		}
+
+		if locked == s.screenLocked.Load() {
+			continue
+		}
+		s.screenLocked.Store(locked)
+
+		if locked {
+			log.Println("Screen locked, pausing video streaming")
+			s.sendLockedPlaceholders()
+		} else {
+			log.Println("Screen unlocked, resuming video streaming")
+		}
+		s.broadcast(lockStatePacket(locked))
+	}
+}
+
+// lockStatePacket builds a packet informing clients of the lock state
+func lockStatePacket(locked bool) *protocol.Packet {
+	state := byte(0)
+	if locked {
+		state = 1
+	}
+	return protocol.NewPacket(protocol.PacketTypeLockState, []byte{state})
+}
+
+// sendLockedPlaceholders replaces every monitor's picture with a placeholder
+// frame, caching it so clients connecting while locked see it too
+func (s *Server) sendLockedPlaceholders() {
+	for _, monitor := range s.monitorConfig().Monitors {
+		placeholder, err := lockedPlaceholder(int(monitor.Width), int(monitor.Height))
+		if err != nil {
+			log.Printf("Failed to create locked placeholder for monitor %d: %v", monitor.ID, err)
+			continue
+		}
+
+		frameData := make([]byte, 4+len(placeholder))
+		copy(frameData[0:4], protocol.Uint32ToBytes(monitor.ID))
+		copy(frameData[4:], placeholder)
+
+		s.cacheFrame(monitor.ID, frameData)
+
+		for _, client := range s.clientsShowing(monitor.ID) {
+			if _, err := s.sendFrame(client, monitor.ID, frameData); err != nil {
+				log.Printf("Error sending placeholder to client %s: %v", client.id, err)
+			}
+		}
+	}
+}
+
+// lockedPlaceholder renders a dark frame with a simple padlock in the middle
+func lockedPlaceholder(width, height int) ([]byte, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	background := color.RGBA{0x20, 0x20, 0x28, 0xFF}
+	foreground := color.RGBA{0x90, 0x90, 0xA0, 0xFF}
+	fill := func(r image.Rectangle, c color.RGBA) {
+		r = r.Intersect(img.Bounds())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	fill(img.Bounds(), background)
+
+	// Padlock body and shackle, scaled to the frame
+	unit := height / 40
+	if unit < 2 {
+		unit = 2
+	}
+	cx, cy := width/2, height/2
+	fill(image.Rect(cx-4*unit, cy-unit, cx+4*unit, cy+5*unit), foreground)
+	fill(image.Rect(cx-3*unit, cy-5*unit, cx-2*unit, cy-unit), foreground)
+	fill(image.Rect(cx+2*unit, cy-5*unit, cx+3*unit, cy-unit), foreground)
+	fill(image.Rect(cx-3*unit, cy-6*unit, cx+3*unit, cy-5*unit), foreground)
+	fill(image.Rect(cx-unit/2, cy+unit, cx+unit/2, cy+3*unit), background)
+
+	buf := new(bytes.Buffer)
+	if err := codec.EncodeJPEG(buf, img, 75); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isScreenLocked asks the operating system whether the session is locked
+func isScreenLocked() (bool, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		// The window server publishes the lock state in the IORegistry
+		out, err := exec.Command("ioreg", "-n", "Root", "-d1").Output()
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(string(out), `"CGSSessionScreenIsLocked"=Yes`), nil
+
+	case "linux":
+		session := os.Getenv("XDG_SESSION_ID")
+		if session == "" {
+			session = "auto"
+		}
+		out, err := exec.Command("loginctl", "show-session", session, "-p", "LockedHint", "--value").Output()
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(out)) == "yes", nil
+
+	case "windows":
+		// LogonUI only runs while the lock or login screen is shown
+		out, err := exec.Command("tasklist", "/FI", "IMAGENAME eq LogonUI.exe", "/NH").Output()
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(string(out), "LogonUI.exe"), nil
+	}
+
+	return false, fmt.Errorf("lock detection not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// send writes a packet to the client. Packets may be sent from several
+// goroutines (capture loops, replies to client requests), so writes are
+// serialized per client.
+func (c *Client) send(packet *protocol.Packet) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	c.stats.PacketSent(protocol.HeaderSize + len(packet.Payload))
+	return protocol.EncodePacket(c.conn, packet)
+}
+
+// sendPaced is send for video frames, which are written at the pacing rate
+// of the congestion controller instead of in one burst
+func (c *Client) sendPaced(packet *protocol.Packet) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	c.stats.PacketSent(protocol.HeaderSize + len(packet.Payload))
+	return protocol.EncodePacket(c.congestion.Pace(c.conn), packet)
+}
+
+// sendFrame sends a video frame to the client: on the monitor's own stream
+// if the transport supports streams or the client opened a connection for
+// the monitor, over UDP if the client has set up the
+// UDP channel and over the control connection otherwise. The frame is
+// skipped while the congestion controller's window is full, the next
+// capture replaces it anyway; sent reports whether it was.
+func (s *Server) sendFrame(client *Client, monitorID uint32, frameData []byte) (sent bool, err error) {
+	if !client.congestion.CanSend() {
+		return false, nil
+	}
+	s.stats.Frame(monitorID, len(frameData))
+	defer s.updateBandwidthStats(client)
+
+	if err := s.writeFrame(client, monitorID, frameData); err != nil {
+		return false, err
+	}
+	client.congestion.OnSent(len(frameData))
+	return true, nil
+}
+
+// writeFrame writes a video frame on the best path available
+func (s *Server) writeFrame(client *Client, monitorID uint32, frameData []byte) error {
+	if client.streams != nil || client.hasVideoStream(monitorID) {
+		return s.sendFrameStream(client, monitorID, frameData)
+	}
+	if s.udp != nil {
+		if sent, err := s.sendFrameUDP(client, monitorID, frameData); sent {
+			return err
+		}
+	}
+
+	return client.sendPaced(protocol.NewPacket(protocol.PacketTypeVideoFrame, frameData))
+}
+
+// updateBandwidthStats publishes the client's bandwidth scheduler and
+// congestion controller state
+func (s *Server) updateBandwidthStats(client *Client) {
+	state := client.bandwidth.State()
+	congestion := client.congestion.State()
+	state.Congestion = &congestion
+	s.stats.SetBandwidth(client.id, state)
+}
+
+// broadcast sends a packet to every active client
+func (s *Server) broadcast(packet *protocol.Packet) {
+	s.broadcastExcept(nil, packet)
+}
+
+// broadcastExcept sends a packet to every active client but one
+func (s *Server) broadcastExcept(except *Client, packet *protocol.Packet) {
+	for _, client := range s.activeClients() {
+		if client == except {
+			continue
+		}
+		if err := client.send(packet); err != nil {
+			log.Printf("Error sending packet to client %s: %v", client.id, err)
+		}
+	}
+}
+
+// activeClients returns the active clients. Packets are sent to them after
+// clientsMutex is released, a client slow to read would hold it otherwise.
+func (s *Server) activeClients() []*Client {
+	return s.clientsShowing(0)
+}
+
+// clientsShowing returns the active clients a server monitor is mapped
+// for, or all active clients for monitor 0, see activeClients
+func (s *Server) clientsShowing(monitorID uint32) []*Client {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		if !client.active {
+			continue
+		}
+		if _, ok := client.monitorMap[monitorID]; monitorID != 0 && !ok {
+			continue
+		}
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// receivePackets reads packets from a client until the connection fails,
+// then removes the client from the server
+func (s *Server) receivePackets(client *Client) {
+	for !s.stopped {
+		packet, err := protocol.DecodePacket(client.conn)
+		if err != nil {
+			if !s.stopped {
+				log.Printf("Client %s disconnected: %v", client.id, err)
+			}
+			break
+		}
+		s.stats.PacketReceived(protocol.HeaderSize + len(packet.Payload))
+		s.handlePacket(client, packet)
+	}
+
+	s.removeClient(client)
+}
+
+// removeClient drops a client from the server and closes its connection
+func (s *Server) removeClient(client *Client) {
+	s.clientsMutex.Lock()
+	if s.clients[client.id] == client {
+		delete(s.clients, client.id)
+	}
+	s.stats.SetClients(len(s.clients))
+	s.clientsMutex.Unlock()
+	s.updateKeepAwake()
+	s.stats.ClearBandwidth(client.id)
+
+	client.active = false
+	client.conn.Close()
+	s.forgetUDP(client)
+	s.forgetStreams(client)
+	s.cameraClientGone(client)
+
+	s.releaseKeys(client)
+	s.inputIndicator.clientGone(client.id)
+	s.audit.record(AuditClientDisconnected, client.id, "")
+}
+
+// handlePacket processes a packet received from a client
+func (s *Server) handlePacket(client *Client, packet *protocol.Packet) {
+	switch packet.Type {
+	case protocol.PacketTypeMouseMove, protocol.PacketTypeMouseButton, protocol.PacketTypeMouseWheel:
+		if !client.canControl() {
+			return
+		}
+		s.inputIndicator.activity(client.id)
+		s.input.activity()
+		switch packet.Type {
+		case protocol.PacketTypeMouseMove:
+			s.handleMouseMove(client, packet.Payload)
+		case protocol.PacketTypeMouseButton:
+			s.handleMouseButton(client, packet.Payload)
+		case protocol.PacketTypeMouseWheel:
+			s.handleMouseWheel(client, packet.Payload)
+		}
+
+	case protocol.PacketTypeKeyboard:
+		if !client.canControl() {
+			return
+		}
+		s.inputIndicator.activity(client.id)
+		s.input.activity()
+		s.handleKeyboard(client, packet.Payload)
+
+	case protocol.PacketTypePing:
+		// Echo the ping timestamp back so the client can measure latency
+		pong := &protocol.Packet{
+			Type:      protocol.PacketTypePong,
+			Timestamp: packet.Timestamp,
+		}
+		if err := client.send(pong); err != nil {
+			log.Printf("Error sending pong to client %s: %v", client.id, err)
+		}
+
+	case protocol.PacketTypeQualityControl:
+		s.handleQualityControl(client, packet.Payload)
+
+	case protocol.PacketTypeClipboard:
+		if !client.canControl() {
+			return
+		}
+		s.handleClipboard(client, packet.Payload)
+
+	case protocol.PacketTypeUDPRequest:
+		s.handleUDPRequest(client)
+
+	case protocol.PacketTypeStreamRequest:
+		s.handleStreamRequest(client)
+
+	case protocol.PacketTypeFrameAck:
+		// The client acknowledges the video bytes it received so far
+		if len(packet.Payload) < 8 {
+			return
+		}
+		client.congestion.OnAck(protocol.BytesToUint64(packet.Payload))
+		client.bandwidth.SetCapacity(client.congestion.Bandwidth())
+		client.adapter.Update(client.congestion.Signals())
+
+	case protocol.PacketTypeClientReport:
+		s.handleClientReport(client, packet.Payload)
+
+	case protocol.PacketTypeFrameRate:
+		// The rate applies to every client
+		if !client.canControl() {
+			return
+		}
+		s.handleFrameRate(client, packet.Payload)
+
+	case protocol.PacketTypeBandwidthLimit:
+		// The cap lowers the quality for every client
+		if !client.canControl() {
+			return
+		}
+		s.handleBandwidthLimit(client, packet.Payload)
+
+	case protocol.PacketTypeCodecs:
+		s.handleCodecs(client, packet.Payload)
+
+	case protocol.PacketTypeCapabilities:
+		s.handleCapabilities(client, packet.Payload)
+
+	case protocol.PacketTypeCameraRequest:
+		s.handleCameraRequest(client, packet.Payload)
+
+	case protocol.PacketTypeCameraFrame:
+		if !client.canControl() {
+			return
+		}
+		s.handleCameraFrame(client, packet.Payload)
+
+	case protocol.PacketTypeKeyframeRequest:
+		s.handleKeyframeRequest(client, packet.Payload)
+
+	case protocol.PacketTypeMonitorDetach:
+		s.handleMonitorDetach(client, packet.Payload)
+
+	case protocol.PacketTypeUDPLoss:
+		if len(packet.Payload) < 4 {
+			return
+		}
+		client.congestion.OnLoss()
+		log.Printf("Client %s dropped %d UDP frames due to packet loss",
+			client.id, protocol.BytesToUint32(packet.Payload))
+
+	default:
+		log.Printf("Unhandled packet type %d from client %s", packet.Type, client.id)
+	}
+}
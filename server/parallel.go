@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WithParallelConnections lets clients open one extra connection per
+// mapped monitor for video, so a large frame of one monitor never queues
+// behind another monitor's frames in the same socket
+func WithParallelConnections(enabled bool) Option {
+	return func(s *Server) {
+		s.parallelEnabled = enabled
+	}
+}
+
+// handleStreamRequest answers a client's request for per-monitor
+// connections with a session token and the monitors to connect for
+func (s *Server) handleStreamRequest(client *Client) {
+	if !s.parallelEnabled {
+		log.Printf("Client %s requested parallel connections, but they are disabled", client.id)
+		return
+	}
+	// Multi-stream transports already give each monitor its own stream
+	if client.streams != nil {
+		return
+	}
+
+	token := make([]byte, protocol.StreamTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("Failed to create stream token: %v", err)
+		return
+	}
+
+	var monitorIDs []uint32
+	for serverMonitor := range client.monitorMap {
+		monitorIDs = append(monitorIDs, serverMonitor)
+	}
+
+	s.streamTokensMutex.Lock()
+	s.streamTokens[string(token)] = client
+	s.streamTokensMutex.Unlock()
+	client.streamToken = token
+
+	if err := client.send(protocol.NewPacket(protocol.PacketTypeStreamSetup, protocol.EncodeStreamSetup(token, monitorIDs))); err != nil {
+		log.Printf("Error sending stream setup to client %s: %v", client.id, err)
+	}
+}
+
+// attachStream turns a freshly handshaken connection into the video
+// connection of one monitor of an existing session. It blocks until the
+// connection closes.
+func (s *Server) attachStream(conn net.Conn, payload []byte) error {
+	token, monitorID, err := protocol.DecodeStreamAttach(payload)
+	if err != nil {
+		return err
+	}
+
+	s.streamTokensMutex.Lock()
+	client, ok := s.streamTokens[string(token)]
+	s.streamTokensMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown stream token")
+	}
+	if _, ok := client.monitorMap[monitorID]; !ok {
+		return fmt.Errorf("monitor %d is not mapped", monitorID)
+	}
+
+	stream := &videoStream{writer: conn}
+	client.sendMutex.Lock()
+	if old, ok := client.videoStreams[monitorID]; ok {
+		old.writer.Close()
+	}
+	client.videoStreams[monitorID] = stream
+	client.sendMutex.Unlock()
+	log.Printf("Client %s receiving monitor %d on its own connection from %s", client.id, monitorID, conn.RemoteAddr())
+
+	// Nothing is expected from the client, reading detects the close
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			break
+		}
+	}
+
+	client.sendMutex.Lock()
+	if client.videoStreams[monitorID] == stream {
+		delete(client.videoStreams, monitorID)
+	}
+	client.sendMutex.Unlock()
+	conn.Close()
+	return nil
+}
+
+// forgetStreams closes a disconnected client's per-monitor connections
+func (s *Server) forgetStreams(client *Client) {
+	if client.streamToken != nil {
+		s.streamTokensMutex.Lock()
+		delete(s.streamTokens, string(client.streamToken))
+		s.streamTokensMutex.Unlock()
+	}
+
+	client.sendMutex.Lock()
+	for _, stream := range client.videoStreams {
+		stream.writer.Close()
+	}
+	client.sendMutex.Unlock()
+}
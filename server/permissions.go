@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Permission is what an authenticated client is allowed to do
+type Permission string
+
+const (
+	// PermissionView lets a client watch the screens but not send input
+	PermissionView Permission = "view"
+	// PermissionControl lets a client send mouse, keyboard and clipboard input
+	PermissionControl Permission = "control"
+)
+
+// WithClientCertificates requires TLS clients to present a certificate
+// signed by caFile, an empty caFile selects the server certificate which
+// signs certificates issued with "ultrardp enroll". The certificate's
+// common name is the client's identity. If permissionsFile is set only the
+// identities listed there may connect, with the listed permission;
+// otherwise every enrolled client gets full control.
+func WithClientCertificates(caFile, permissionsFile string) Option {
+	return func(s *Server) {
+		s.clientCA = caFile
+		s.clientCertsRequired = true
+		s.permissionsPath = permissionsFile
+	}
+}
+
+// loadPermissions reads a permissions file. Each line holds an identity
+// and its permission separated by whitespace, # starts a comment:
+//
+//	laptop   control
+//	tablet   view
+func loadPermissions(path string) (map[string]Permission, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	permissions := make(map[string]Permission)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<identity> <view|control>\"", path, line)
+		}
+
+		permission := Permission(fields[1])
+		if permission != PermissionView && permission != PermissionControl {
+			return nil, fmt.Errorf("%s:%d: unknown permission %q", path, line, fields[1])
+		}
+		permissions[fields[0]] = permission
+	}
+	return permissions, scanner.Err()
+}
+
+// permissionFor returns the permission of a client identity, or false if
+// the identity isn't allowed to connect
+func (s *Server) permissionFor(identity string) (Permission, bool) {
+	if s.permissions == nil {
+		return PermissionControl, true
+	}
+	permission, ok := s.permissions[identity]
+	return permission, ok
+}
+
+// canControl reports whether the client may send input
+func (c *Client) canControl() bool {
+	return c.permission == PermissionControl
+}
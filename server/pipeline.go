@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// Pipeline selects how captured frames reach the encoders
+type Pipeline string
+
+const (
+	// PipelineCPU copies captured frames to memory, every codec and
+	// capture backend works with them
+	PipelineCPU Pipeline = "cpu"
+
+	// PipelineGPU keeps captured frames in GPU memory and hands them to
+	// the hardware encoder without a round trip through the CPU, see
+	// codec.Surface. Monitors fall back to the CPU pipeline on platforms
+	// without GPU capture.
+	PipelineGPU Pipeline = "gpu"
+)
+
+// ParsePipeline returns the pipeline with the given name
+func ParsePipeline(name string) (Pipeline, error) {
+	switch p := Pipeline(name); p {
+	case PipelineCPU, PipelineGPU:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown pipeline %q, must be %s or %s", name, PipelineCPU, PipelineGPU)
+}
+
+// WithPipeline selects how captured frames reach the encoders, the CPU
+// pipeline by default
+func WithPipeline(pipeline Pipeline) Option {
+	return func(s *Server) {
+		s.pipeline = pipeline
+	}
+}
+
+// gpuCapturer captures a monitor into GPU memory
+type gpuCapturer interface {
+	// capture returns the latest frame of the monitor, nil before the
+	// first one. It stays valid until the next call.
+	capture() (codec.Surface, error)
+
+	// close stops capturing
+	close()
+}
+
+// newGPUCapturer starts capturing a monitor into GPU memory, with the
+// cursor if cursor is set; nil on platforms without GPU capture
+var newGPUCapturer func(monitor protocol.MonitorInfo, cursor bool) (gpuCapturer, error)
+
+// openGPUCapture starts the GPU capture of a monitor on the GPU pipeline,
+// it returns nil if the monitor is captured to memory
+func (s *Server) openGPUCapture(monitor protocol.MonitorInfo) gpuCapturer {
+	if s.pipeline != PipelineGPU || s.captureSource != nil {
+		return nil
+	}
+	if !s.captureRegion.Empty() {
+		log.Printf("Regions aren't captured on the GPU, capturing monitor %d to memory", monitor.ID)
+		return nil
+	}
+	if newGPUCapturer == nil {
+		log.Printf("GPU capture isn't supported on this platform, capturing monitor %d to memory", monitor.ID)
+		return nil
+	}
+	capturer, err := newGPUCapturer(monitor, s.captureCursor())
+	if err != nil {
+		log.Printf("GPU capture of monitor %d failed, capturing it to memory: %v", monitor.ID, err)
+		return nil
+	}
+	log.Printf("Capturing monitor %d into GPU memory", monitor.ID)
+	return capturer
+}
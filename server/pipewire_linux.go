@@ -0,0 +1,296 @@
+//go:build cgo && pipewire
+
+package server
+
+/*
+#cgo pkg-config: libpipewire-0.3
+#include <stdlib.h>
+#include <stdint.h>
+#include <string.h>
+#include <fcntl.h>
+#include <pipewire/pipewire.h>
+#include <spa/param/video/format-utils.h>
+#include <spa/param/buffers.h>
+
+// pwc_capture receives the frames of a portal stream on PipeWire's thread,
+// keeping a copy of the last one
+typedef struct {
+	struct pw_thread_loop *loop;
+	struct pw_context *context;
+	struct pw_core *core;
+	struct pw_stream *stream;
+	struct spa_hook listener;
+	struct spa_video_info_raw format;
+	uint8_t *frame;
+	size_t size;
+	int width, height, stride;
+	int bgr; // Blue first, as most compositors send
+	uint64_t sequence, copied; // Of the last frame received and copied
+	int failed;
+} pwc_capture;
+
+static void pwc_state_changed(void *data, enum pw_stream_state old, enum pw_stream_state state, const char *error) {
+	pwc_capture *c = data;
+	if (state == PW_STREAM_STATE_ERROR || state == PW_STREAM_STATE_UNCONNECTED) {
+		c->failed = 1;
+	}
+}
+
+static void pwc_param_changed(void *data, uint32_t id, const struct spa_pod *param) {
+	pwc_capture *c = data;
+	if (param == NULL || id != SPA_PARAM_Format) {
+		return;
+	}
+	uint32_t media_type, media_subtype;
+	if (spa_format_parse(param, &media_type, &media_subtype) < 0 ||
+		media_type != SPA_MEDIA_TYPE_video || media_subtype != SPA_MEDIA_SUBTYPE_raw) {
+		return;
+	}
+	if (spa_format_video_raw_parse(param, &c->format) < 0) {
+		return;
+	}
+
+	// Frames are read by the CPU, ask for them in memory rather than as
+	// DMA-BUFs
+	uint8_t buffer[256];
+	struct spa_pod_builder b = SPA_POD_BUILDER_INIT(buffer, sizeof(buffer));
+	const struct spa_pod *params[1];
+	params[0] = spa_pod_builder_add_object(&b,
+		SPA_TYPE_OBJECT_ParamBuffers, SPA_PARAM_Buffers,
+		SPA_PARAM_BUFFERS_dataType, SPA_POD_Int((1 << SPA_DATA_MemFd) | (1 << SPA_DATA_MemPtr)));
+	pw_stream_update_params(c->stream, params, 1);
+}
+
+static void pwc_process(void *data) {
+	pwc_capture *c = data;
+
+	// Only the newest frame matters
+	struct pw_buffer *buffer = NULL, *next;
+	while ((next = pw_stream_dequeue_buffer(c->stream)) != NULL) {
+		if (buffer != NULL) {
+			pw_stream_queue_buffer(c->stream, buffer);
+		}
+		buffer = next;
+	}
+	if (buffer == NULL) {
+		return;
+	}
+
+	// Buffers without data only move the cursor
+	struct spa_data *d = &buffer->buffer->datas[0];
+	int width = c->format.size.width, height = c->format.size.height;
+	int stride = d->chunk->stride > 0 ? d->chunk->stride : width * 4;
+	size_t size = (size_t)stride * height;
+	size_t offset = d->chunk->offset % d->maxsize;
+	if (d->data != NULL && d->chunk->size > 0 && width > 0 && offset + size <= d->maxsize) {
+		if (c->size < size) {
+			free(c->frame);
+			c->frame = malloc(size);
+			c->size = c->frame != NULL ? size : 0;
+		}
+		if (c->frame != NULL) {
+			memcpy(c->frame, (uint8_t *)d->data + offset, size);
+			c->width = width;
+			c->height = height;
+			c->stride = stride;
+			c->bgr = c->format.format == SPA_VIDEO_FORMAT_BGRx || c->format.format == SPA_VIDEO_FORMAT_BGRA;
+			c->sequence++;
+		}
+	}
+	pw_stream_queue_buffer(c->stream, buffer);
+}
+
+static const struct pw_stream_events pwc_stream_events = {
+	PW_VERSION_STREAM_EVENTS,
+	.state_changed = pwc_state_changed,
+	.param_changed = pwc_param_changed,
+	.process = pwc_process,
+};
+
+static void pwc_close(pwc_capture *c) {
+	if (c->loop != NULL) {
+		pw_thread_loop_stop(c->loop);
+	}
+	if (c->stream != NULL) {
+		pw_stream_destroy(c->stream);
+	}
+	if (c->core != NULL) {
+		pw_core_disconnect(c->core);
+	}
+	if (c->context != NULL) {
+		pw_context_destroy(c->context);
+	}
+	if (c->loop != NULL) {
+		pw_thread_loop_destroy(c->loop);
+	}
+	free(c->frame);
+	free(c);
+}
+
+// pwc_open connects to a node of the portal's PipeWire remote, asking for
+// frames of width x height. It returns an error message on failure.
+static const char *pwc_open(pwc_capture *c, int remote, uint32_t node, int width, int height) {
+	pw_init(NULL, NULL);
+	c->loop = pw_thread_loop_new("ultrardp-capture", NULL);
+	if (c->loop == NULL) {
+		return "can't create PipeWire loop";
+	}
+	c->context = pw_context_new(pw_thread_loop_get_loop(c->loop), NULL, 0);
+	if (c->context == NULL) {
+		return "can't create PipeWire context";
+	}
+	if (pw_thread_loop_start(c->loop) < 0) {
+		return "can't start PipeWire loop";
+	}
+
+	pw_thread_loop_lock(c->loop);
+	const char *err = NULL;
+	// Every stream has its own connection, which takes its fd
+	c->core = pw_context_connect_fd(c->context, fcntl(remote, F_DUPFD_CLOEXEC, 3), NULL, 0);
+	if (c->core == NULL) {
+		err = "can't connect to PipeWire";
+		goto out;
+	}
+	c->stream = pw_stream_new(c->core, "ultrardp", pw_properties_new(
+		PW_KEY_MEDIA_TYPE, "Video",
+		PW_KEY_MEDIA_CATEGORY, "Capture",
+		PW_KEY_MEDIA_ROLE, "Screen",
+		NULL));
+	if (c->stream == NULL) {
+		err = "can't create PipeWire stream";
+		goto out;
+	}
+	pw_stream_add_listener(c->stream, &c->listener, &pwc_stream_events, c);
+
+	uint8_t buffer[1024];
+	struct spa_pod_builder b = SPA_POD_BUILDER_INIT(buffer, sizeof(buffer));
+	const struct spa_pod *params[1];
+	params[0] = spa_pod_builder_add_object(&b,
+		SPA_TYPE_OBJECT_Format, SPA_PARAM_EnumFormat,
+		SPA_FORMAT_mediaType, SPA_POD_Id(SPA_MEDIA_TYPE_video),
+		SPA_FORMAT_mediaSubtype, SPA_POD_Id(SPA_MEDIA_SUBTYPE_raw),
+		SPA_FORMAT_VIDEO_format, SPA_POD_CHOICE_ENUM_Id(5,
+			SPA_VIDEO_FORMAT_BGRx, SPA_VIDEO_FORMAT_BGRx, SPA_VIDEO_FORMAT_BGRA,
+			SPA_VIDEO_FORMAT_RGBx, SPA_VIDEO_FORMAT_RGBA),
+		SPA_FORMAT_VIDEO_size, SPA_POD_CHOICE_RANGE_Rectangle(
+			&SPA_RECTANGLE(width, height), &SPA_RECTANGLE(1, 1), &SPA_RECTANGLE(16384, 16384)),
+		SPA_FORMAT_VIDEO_framerate, SPA_POD_CHOICE_RANGE_Fraction(
+			&SPA_FRACTION(60, 1), &SPA_FRACTION(0, 1), &SPA_FRACTION(240, 1)));
+	if (pw_stream_connect(c->stream, PW_DIRECTION_INPUT, node,
+		PW_STREAM_FLAG_AUTOCONNECT | PW_STREAM_FLAG_MAP_BUFFERS, params, 1) < 0) {
+		err = "can't connect PipeWire stream";
+	}
+out:
+	pw_thread_loop_unlock(c->loop);
+	return err;
+}
+
+// pwc_copy copies the last frame, if it is new, into RGBA pixels of
+// width x height. It returns 1 if it did, 0 if not and -1 if the stream
+// failed, e.g. because the user stopped sharing.
+static int pwc_copy(pwc_capture *c, uint8_t *rgba, int width, int height) {
+	pw_thread_loop_lock(c->loop);
+	int status = 0;
+	if (c->failed) {
+		status = -1;
+	} else if (c->frame != NULL && c->sequence != c->copied) {
+		int columns = c->width < width ? c->width : width;
+		int rows = c->height < height ? c->height : height;
+		for (int y = 0; y < rows; y++) {
+			const uint8_t *src = c->frame + (size_t)y * c->stride;
+			uint8_t *dst = rgba + (size_t)y * width * 4;
+			for (int x = 0; x < columns; x++, src += 4, dst += 4) {
+				dst[0] = c->bgr ? src[2] : src[0];
+				dst[1] = src[1];
+				dst[2] = c->bgr ? src[0] : src[2];
+				dst[3] = 0xFF;
+			}
+		}
+		c->copied = c->sequence;
+		status = 1;
+	}
+	pw_thread_loop_unlock(c->loop);
+	return status;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"os"
+	"unsafe"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+func init() {
+	screenBackends = append(screenBackends, screenBackend{name: "PipeWire", key: "pipewire", available: pipeWireAvailable, open: newPipeWireCapturer, cursor: true})
+}
+
+// pipeWireAvailable reports whether this is a Wayland session whose
+// ScreenCast portal shares monitors. It asks the user for permission the
+// first time.
+func pipeWireAvailable() bool {
+	if os.Getenv("WAYLAND_DISPLAY") == "" {
+		return false
+	}
+	_, err := startScreenCast()
+	return err == nil
+}
+
+// pipeWireCapturer captures a monitor shared through the ScreenCast
+// portal. Compositors only send frames when something changed. The portal
+// session embeds the cursor, Wayland has no other way to see it.
+type pipeWireCapturer struct {
+	session *C.pwc_capture
+	frame   *image.RGBA // nil until the first frame arrived
+	rect    image.Rectangle
+}
+
+func newPipeWireCapturer(monitor protocol.MonitorInfo, _ bool) (screenCapturer, error) {
+	session, err := startScreenCast()
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := session.stream(monitor)
+	if !ok {
+		return nil, errors.New("monitor wasn't shared")
+	}
+	c := &pipeWireCapturer{
+		session: (*C.pwc_capture)(C.calloc(1, C.sizeof_pwc_capture)),
+		rect:    image.Rect(0, 0, int(monitor.Width), int(monitor.Height)),
+	}
+	if message := C.pwc_open(c.session, C.int(session.remote), C.uint32_t(stream.node),
+		C.int(monitor.Width), C.int(monitor.Height)); message != nil {
+		c.close()
+		return nil, errors.New(C.GoString(message))
+	}
+	return c, nil
+}
+
+func (c *pipeWireCapturer) capture() (image.Image, bool, error) {
+	frame := c.frame
+	if frame == nil {
+		frame = image.NewRGBA(c.rect)
+	}
+	switch status := C.pwc_copy(c.session, (*C.uint8_t)(unsafe.Pointer(&frame.Pix[0])), C.int(c.rect.Dx()), C.int(c.rect.Dy())); status {
+	case -1:
+		return nil, false, errors.New("PipeWire stream stopped")
+	case 0:
+		if c.frame == nil {
+			// No frame yet
+			return nil, false, nil
+		}
+		return c.frame, false, nil
+	}
+	c.frame = frame
+	return c.frame, true, nil
+}
+
+func (c *pipeWireCapturer) close() {
+	if c.session != nil {
+		C.pwc_close(c.session)
+		c.session = nil
+	}
+}
@@ -0,0 +1,278 @@
+//go:build cgo && pipewire
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// The ScreenCast portal of xdg-desktop-portal
+const (
+	portalService   = "org.freedesktop.portal.Desktop"
+	portalPath      = "/org/freedesktop/portal/desktop"
+	screenCastIface = "org.freedesktop.portal.ScreenCast"
+)
+
+// Portal source types and cursor modes
+const (
+	portalSourceMonitor  = 1
+	portalCursorEmbedded = 2
+	portalPersistAlways  = 2 // Until the user revokes the permission
+)
+
+// portalStream is a monitor the user shared through the portal
+type portalStream struct {
+	node   uint32 // PipeWire node
+	x, y   int32
+	width  int32
+	height int32
+}
+
+// screenCastSession is the process's ScreenCast portal session. The
+// compositor asks the user once which monitors to share, the streams of
+// all monitors are then read from one PipeWire remote.
+type screenCastSession struct {
+	conn    *dbus.Conn // The session lives as long as the connection
+	desktop dbus.BusObject
+	handle  dbus.ObjectPath
+	streams []portalStream
+	remote  int // PipeWire remote fd
+	tokens  int
+}
+
+var screenCast struct {
+	once    sync.Once
+	session *screenCastSession
+	err     error
+}
+
+func init() {
+	portalMonitors = screenCastMonitors
+}
+
+// startScreenCast starts the ScreenCast session once, asking the user
+// for permission unless a previous run saved it
+func startScreenCast() (*screenCastSession, error) {
+	screenCast.once.Do(func() {
+		screenCast.session, screenCast.err = newScreenCastSession()
+		if screenCast.err != nil {
+			log.Printf("ScreenCast portal failed: %v", screenCast.err)
+		}
+	})
+	return screenCast.session, screenCast.err
+}
+
+// screenCastMonitors returns the monitors the user shared
+func screenCastMonitors() (*protocol.MonitorConfig, error) {
+	session, err := startScreenCast()
+	if err != nil {
+		return nil, err
+	}
+	config := &protocol.MonitorConfig{MonitorCount: uint32(len(session.streams))}
+	for i, stream := range session.streams {
+		config.Monitors = append(config.Monitors, protocol.MonitorInfo{
+			ID:        uint32(i + 1),
+			Width:     uint32(stream.width),
+			Height:    uint32(stream.height),
+			PositionX: uint32(stream.x),
+			PositionY: uint32(stream.y),
+			Primary:   i == 0,
+		})
+	}
+	return config, nil
+}
+
+func newScreenCastSession() (*screenCastSession, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the session bus: %w", err)
+	}
+	s := &screenCastSession{conn: conn, desktop: conn.Object(portalService, portalPath), remote: -1}
+	if err := s.start(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *screenCastSession) start() error {
+	var version uint32
+	if v, err := s.desktop.GetProperty(screenCastIface + ".version"); err != nil {
+		return fmt.Errorf("no ScreenCast portal: %w", err)
+	} else if err := v.Store(&version); err != nil {
+		return err
+	}
+
+	results, err := s.request("CreateSession", map[string]dbus.Variant{
+		"session_handle_token": dbus.MakeVariant("ultrardp"),
+	})
+	if err != nil {
+		return err
+	}
+	switch handle := results["session_handle"].Value().(type) {
+	case string:
+		s.handle = dbus.ObjectPath(handle)
+	case dbus.ObjectPath:
+		s.handle = handle
+	default:
+		return errors.New("portal returned no session")
+	}
+
+	options := map[string]dbus.Variant{
+		"types":    dbus.MakeVariant(uint32(portalSourceMonitor)),
+		"multiple": dbus.MakeVariant(true),
+	}
+	if v, err := s.desktop.GetProperty(screenCastIface + ".AvailableCursorModes"); err == nil {
+		if modes, ok := v.Value().(uint32); ok && modes&portalCursorEmbedded != 0 {
+			options["cursor_mode"] = dbus.MakeVariant(uint32(portalCursorEmbedded))
+		}
+	}
+	// Version 4 remembers the permission, it is only asked for once
+	if version >= 4 {
+		options["persist_mode"] = dbus.MakeVariant(uint32(portalPersistAlways))
+		if token, err := os.ReadFile(screenCastTokenFile()); err == nil && len(token) > 0 {
+			options["restore_token"] = dbus.MakeVariant(strings.TrimSpace(string(token)))
+		}
+	}
+	if _, err := s.request("SelectSources", options, s.handle); err != nil {
+		return err
+	}
+
+	log.Printf("Waiting for permission to share the screen")
+	results, err = s.request("Start", map[string]dbus.Variant{}, s.handle, "")
+	if err != nil {
+		return err
+	}
+	if err := s.parseStreams(results["streams"].Value()); err != nil {
+		return err
+	}
+	if token, ok := results["restore_token"].Value().(string); ok && token != "" {
+		saveScreenCastToken(token)
+	}
+
+	var fd dbus.UnixFD
+	if err := s.desktop.Call(screenCastIface+".OpenPipeWireRemote", 0, s.handle, map[string]dbus.Variant{}).Store(&fd); err != nil {
+		return fmt.Errorf("failed to open PipeWire remote: %w", err)
+	}
+	s.remote = int(fd)
+	log.Printf("Sharing %d monitors through the ScreenCast portal", len(s.streams))
+	return nil
+}
+
+// request calls a portal method that answers with a Request object's
+// Response signal, and waits for it; the user may be asked first
+func (s *screenCastSession) request(method string, options map[string]dbus.Variant, args ...interface{}) (map[string]dbus.Variant, error) {
+	s.tokens++
+	token := fmt.Sprintf("ultrardp%d", s.tokens)
+	options["handle_token"] = dbus.MakeVariant(token)
+
+	// The request's path is known up front, subscribing before the call
+	// can't miss a quick response
+	sender := strings.ReplaceAll(strings.TrimPrefix(s.conn.Names()[0], ":"), ".", "_")
+	path := dbus.ObjectPath(portalPath + "/request/" + sender + "/" + token)
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface("org.freedesktop.portal.Request"),
+		dbus.WithMatchMember("Response"),
+	}
+	if err := s.conn.AddMatchSignal(match...); err != nil {
+		return nil, err
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 4)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	if call := s.desktop.Call(screenCastIface+"."+method, 0, append(args, options)...); call.Err != nil {
+		return nil, fmt.Errorf("%s failed: %w", method, call.Err)
+	}
+	for signal := range signals {
+		if signal.Path != path || len(signal.Body) < 2 {
+			continue
+		}
+		response, _ := signal.Body[0].(uint32)
+		results, _ := signal.Body[1].(map[string]dbus.Variant)
+		switch response {
+		case 0:
+			return results, nil
+		case 1:
+			return nil, errors.New("screen sharing was declined")
+		default:
+			return nil, fmt.Errorf("%s failed", method)
+		}
+	}
+	return nil, errors.New("session bus closed")
+}
+
+// parseStreams reads the streams of Start's results, a(ua{sv})
+func (s *screenCastSession) parseStreams(value interface{}) error {
+	streams, _ := value.([][]interface{})
+	for _, fields := range streams {
+		if len(fields) < 2 {
+			continue
+		}
+		node, _ := fields[0].(uint32)
+		properties, _ := fields[1].(map[string]dbus.Variant)
+		stream := portalStream{node: node}
+		if position, ok := properties["position"].Value().([]interface{}); ok && len(position) == 2 {
+			stream.x, _ = position[0].(int32)
+			stream.y, _ = position[1].(int32)
+		}
+		if size, ok := properties["size"].Value().([]interface{}); ok && len(size) == 2 {
+			stream.width, _ = size[0].(int32)
+			stream.height, _ = size[1].(int32)
+		}
+		if stream.width <= 0 || stream.height <= 0 {
+			continue
+		}
+		s.streams = append(s.streams, stream)
+	}
+	if len(s.streams) == 0 {
+		return errors.New("no monitors were shared")
+	}
+	return nil
+}
+
+// stream returns the stream of a monitor, by position or else by order
+func (s *screenCastSession) stream(monitor protocol.MonitorInfo) (portalStream, bool) {
+	for _, stream := range s.streams {
+		if uint32(stream.x) == monitor.PositionX && uint32(stream.y) == monitor.PositionY &&
+			uint32(stream.width) == monitor.Width && uint32(stream.height) == monitor.Height {
+			return stream, true
+		}
+	}
+	if i := int(monitor.ID) - 1; i >= 0 && i < len(s.streams) {
+		return s.streams[i], true
+	}
+	return portalStream{}, false
+}
+
+// screenCastTokenFile is where the portal's restore token is kept, so
+// later runs share the same monitors without asking
+func screenCastTokenFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "ultrardp", "screencast-token")
+}
+
+func saveScreenCastToken(token string) {
+	path := screenCastTokenFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Printf("Failed to save screen sharing permission: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+		log.Printf("Failed to save screen sharing permission: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moderniselife/ultrardp/bandwidth"
+	"github.com/moderniselife/ultrardp/codec"
+)
+
+// WithRateControl sets the rate control of every monitor's video encoders,
+// see codec.RateMode. A bitrate of 0 derives it from the quality. JPEG and
+// the other still image codecs have no rate control and ignore it.
+func WithRateControl(rc codec.RateControl) Option {
+	return func(s *Server) {
+		s.rateControl = rc
+	}
+}
+
+// WithMonitorRateControls sets the rate control of individual monitors by
+// ID, overriding WithRateControl
+func WithMonitorRateControls(rcs map[uint32]codec.RateControl) Option {
+	return func(s *Server) {
+		s.monitorRateControls = rcs
+	}
+}
+
+// ParseRateControl parses a rate control like "vbr" or "cbr:8000", the
+// mode and optionally the target bitrate in kbit/s
+func ParseRateControl(spec string) (codec.RateControl, error) {
+	name, kbps, hasBitrate := strings.Cut(strings.TrimSpace(spec), ":")
+	mode, err := codec.ParseRateMode(name)
+	if err != nil {
+		return codec.RateControl{}, err
+	}
+	rc := codec.RateControl{Mode: mode}
+	if hasBitrate {
+		bitrate, err := strconv.Atoi(kbps)
+		if err != nil || bitrate < 1 {
+			return codec.RateControl{}, fmt.Errorf("invalid bitrate %q, must be kbit/s", kbps)
+		}
+		rc.Bitrate = bitrate * 1000
+	}
+	return rc, nil
+}
+
+// ParseRateControls parses a comma separated list of monitor rate controls
+// like "1=cbr:8000,2=cq" for WithMonitorRateControls
+func ParseRateControls(list string) (map[uint32]codec.RateControl, error) {
+	rcs := make(map[uint32]codec.RateControl)
+	for _, entry := range strings.Split(list, ",") {
+		id, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not monitor=mode[:kbps]", entry)
+		}
+		monitorID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil || monitorID == 0 {
+			return nil, fmt.Errorf("invalid monitor ID %q", id)
+		}
+		rc, err := ParseRateControl(spec)
+		if err != nil {
+			return nil, err
+		}
+		rcs[uint32(monitorID)] = rc
+	}
+	return rcs, nil
+}
+
+// rateControlFor returns the rate control of a monitor's video: what its
+// clients asked for, else the monitor's setting, else the server's. Clients
+// share streams, so the lowest bitrate asked for wins, and CBR if they ask
+// for different modes.
+func (s *Server) rateControlFor(monitorID uint32) codec.RateControl {
+	rc := s.rateControl
+	if monitor, ok := s.monitorRateControls[monitorID]; ok {
+		rc = monitor
+	}
+
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	mode, bitrate := codec.RateDefault, 0
+	for _, client := range s.clients {
+		if _, ok := client.monitorMap[monitorID]; !ok {
+			continue
+		}
+		requested := client.rateControl
+		switch {
+		case requested.Mode == codec.RateDefault:
+		case mode == codec.RateDefault:
+			mode = requested.Mode
+		case mode != requested.Mode:
+			mode = codec.RateCBR
+		}
+		if requested.Bitrate > 0 && (bitrate == 0 || requested.Bitrate < bitrate) {
+			bitrate = requested.Bitrate
+		}
+	}
+	if mode != codec.RateDefault {
+		rc.Mode = mode
+	}
+	if bitrate > 0 {
+		rc.Bitrate = bitrate
+	}
+	return rc
+}
+
+// rateFor returns the rate control of a stream encoded at a quality and
+// scale. An explicit bitrate shrinks with them, so the lower levels of
+// bandwidth.Ladder and the bandwidth limiter still save bandwidth.
+func (e *monitorEncoders) rateFor(quality int, scale float64) codec.RateControl {
+	rc := e.rateControl
+	if rc.Bitrate > 0 {
+		share := min(float64(quality)/bandwidth.MaxQuality, 1) * scale * scale
+		rc.Bitrate = int(float64(rc.Bitrate) * share)
+	}
+	return rc
+}
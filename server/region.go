@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// ParseRegion parses a rectangle of the desktop given as x,y,width,height
+func ParseRegion(value string) (image.Rectangle, error) {
+	var x, y, width, height int
+	if _, err := fmt.Sscanf(value, "%d,%d,%d,%d", &x, &y, &width, &height); err != nil || width <= 0 || height <= 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid region %q, expected X,Y,WIDTH,HEIGHT", value)
+	}
+	return image.Rect(x, y, x+width, y+height), nil
+}
+
+// WithCaptureRegion streams a rectangle of the desktop instead of the
+// monitors, as a single monitor of its size, e.g. part of an ultrawide
+// monitor
+func WithCaptureRegion(region image.Rectangle) Option {
+	return func(s *Server) {
+		s.captureRegion = region
+	}
+}
+
+// regionMonitors returns the monitor configuration announcing a region of
+// the desktop
+func regionMonitors(desktop *protocol.MonitorConfig, region image.Rectangle) (*protocol.MonitorConfig, error) {
+	for _, monitor := range desktop.Monitors {
+		if region.Overlaps(monitorBounds(monitor)) {
+			return &protocol.MonitorConfig{
+				MonitorCount: 1,
+				Monitors: []protocol.MonitorInfo{{
+					ID:        1,
+					Width:     uint32(region.Dx()),
+					Height:    uint32(region.Dy()),
+					PositionX: uint32(region.Min.X),
+					PositionY: uint32(region.Min.Y),
+					Primary:   true,
+				}},
+			}, nil
+		}
+	}
+	return nil, errors.New("the region is outside the monitors")
+}
+
+// monitorBounds returns where a monitor is on the desktop
+func monitorBounds(monitor protocol.MonitorInfo) image.Rectangle {
+	origin := image.Pt(int(int32(monitor.PositionX)), int(int32(monitor.PositionY)))
+	return image.Rect(0, 0, int(monitor.Width), int(monitor.Height)).Add(origin)
+}
+
+// capturedMonitor returns the monitor capture APIs capture for a monitor
+// clients see, and the part of its frames shown to them; all of it if
+// crop is empty. ok is false for a region spanning monitors, which only
+// screenshots capture.
+func (s *Server) capturedMonitor(monitor protocol.MonitorInfo) (captured protocol.MonitorInfo, crop image.Rectangle, ok bool) {
+	if s.captureRegion.Empty() || s.captureSource != nil {
+		return monitor, image.Rectangle{}, true
+	}
+	s.monitorsMutex.Lock()
+	desktop := s.desktop
+	s.monitorsMutex.Unlock()
+	for _, m := range desktop.Monitors {
+		bounds := monitorBounds(m)
+		if s.captureRegion.In(bounds) {
+			return m, s.captureRegion.Sub(bounds.Min), true
+		}
+	}
+	return monitor, image.Rectangle{}, false
+}
+
+// regionFrame holds the part of a monitor's frames a region shows
+type regionFrame struct {
+	frame *image.RGBA
+}
+
+// crop copies the part of img in rect, capture APIs reuse their images
+func (r *regionFrame) crop(img image.Image, rect image.Rectangle) image.Image {
+	size := image.Rect(0, 0, rect.Dx(), rect.Dy())
+	if r.frame == nil || r.frame.Rect != size {
+		r.frame = image.NewRGBA(size)
+	}
+	draw.Draw(r.frame, size, img, img.Bounds().Min.Add(rect.Min), draw.Src)
+	return r.frame
+}
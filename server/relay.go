@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/moderniselife/ultrardp/relay"
+	"github.com/moderniselife/ultrardp/transport"
+)
+
+// WithRelay registers with the relay at relayAddress under a session code
+// instead of listening, for servers clients can't reach directly. A random
+// code is generated and logged if code is empty. Only the TCP transport can
+// be relayed and UDP video is not offered.
+func WithRelay(relayAddress, code string) Option {
+	return func(s *Server) {
+		s.relayAddress = relayAddress
+		s.relayCode = code
+	}
+}
+
+// listen starts the listener clients connect through, on the relay if one
+// is configured
+func (s *Server) listen(tlsConfig *tls.Config) (net.Listener, error) {
+	if s.relayAddress == "" {
+		return transport.Listen(s.transport, s.address, tlsConfig)
+	}
+
+	if s.transport != "" && s.transport != transport.TCP {
+		return nil, fmt.Errorf("the %s transport can't be relayed, use tcp", s.transport)
+	}
+	if s.relayCode == "" {
+		code, err := relay.NewSessionCode()
+		if err != nil {
+			return nil, err
+		}
+		s.relayCode = code
+	}
+
+	listener, err := relay.Listen(s.relayAddress, s.relayCode, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register with relay %s: %w", s.relayAddress, err)
+	}
+	log.Printf("Registered with relay %s, session code %s", s.relayAddress, s.relayCode)
+	return listener, nil
+}
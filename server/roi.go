@@ -0,0 +1,64 @@
+package server
+
+import (
+	"image"
+	"sync"
+)
+
+// DefaultROIRadius is how far around the cursor, in monitor pixels, frames
+// are encoded at a higher quality than the rest of the screen
+const DefaultROIRadius = 256
+
+// WithRegionOfInterest encodes the area within radius pixels of the cursor
+// of the controlling client at a higher quality and the rest of the screen
+// at a lower one, keeping what the user looks at sharp for less bandwidth.
+// Only codecs that support it, JPEG and Regions, do so. 0 encodes frames
+// alike everywhere.
+func WithRegionOfInterest(radius int) Option {
+	return func(s *Server) {
+		s.roiRadius = radius
+	}
+}
+
+// cursorPosition is where the cursor of the last client that moved it is
+type cursorPosition struct {
+	mutex     sync.Mutex
+	monitorID uint32 // 0 before the first move
+	position  image.Point
+}
+
+// set records a cursor move
+func (c *cursorPosition) set(monitorID uint32, position image.Point) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.monitorID, c.position = monitorID, position
+}
+
+// on returns the cursor position on a monitor, false if it isn't there
+func (c *cursorPosition) on(monitorID uint32) (image.Point, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.position, monitorID != 0 && c.monitorID == monitorID
+}
+
+// regionOfInterest returns the area of a monitor around the cursor, in
+// pixels from the monitor's top left corner. It is empty if the cursor
+// isn't on the monitor or the region of interest is disabled.
+func (s *Server) regionOfInterest(monitorID uint32) image.Rectangle {
+	position, ok := s.cursor.on(monitorID)
+	if !ok || s.roiRadius <= 0 {
+		return image.Rectangle{}
+	}
+	r := s.roiRadius
+	return image.Rect(position.X-r, position.Y-r, position.X+r, position.Y+r)
+}
+
+// scaleRect scales a rectangle in monitor pixels to a frame scaled by
+// scale whose top left corner is at origin
+func scaleRect(r image.Rectangle, scale float64, origin image.Point) image.Rectangle {
+	if r.Empty() {
+		return r
+	}
+	return image.Rect(int(float64(r.Min.X)*scale), int(float64(r.Min.Y)*scale),
+		int(float64(r.Max.X)*scale), int(float64(r.Max.Y)*scale)).Add(origin)
+}
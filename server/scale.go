@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// MinCaptureScale is the smallest capture scale in percent
+const MinCaptureScale = 10
+
+// WithCaptureScales encodes monitors at a percentage of their size, by
+// monitor ID or 0 for every monitor, e.g. a 5K display at 50% to save
+// bandwidth and encoding time. Clients may ask for other scales.
+func WithCaptureScales(scales map[uint32]int) Option {
+	return func(s *Server) {
+		s.captureScales = scales
+	}
+}
+
+// ParseCaptureScales parses a comma separated list of monitor capture
+// scales in percent like "1=50,2=75" for WithCaptureScales. A scale
+// without a monitor applies to every monitor.
+func ParseCaptureScales(list string) (map[uint32]int, error) {
+	scales := make(map[uint32]int)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		id, percent, ok := strings.Cut(entry, "=")
+		monitorID := uint64(0)
+		if ok {
+			var err error
+			if monitorID, err = strconv.ParseUint(id, 10, 32); err != nil || monitorID == 0 {
+				return nil, fmt.Errorf("invalid monitor ID %q", id)
+			}
+		} else {
+			percent = entry
+		}
+		scale, err := strconv.Atoi(strings.TrimSuffix(percent, "%"))
+		if err != nil || scale < MinCaptureScale || scale > 100 {
+			return nil, fmt.Errorf("invalid scale %q, must be %d-100", percent, MinCaptureScale)
+		}
+		scales[uint32(monitorID)] = scale
+	}
+	return scales, nil
+}
+
+// setClientCaptureScales records the capture scales a client asked for,
+// replacing earlier ones
+func (s *Server) setClientCaptureScales(client *Client, scales []protocol.CaptureScale) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	clear(client.captureScales)
+	for _, scale := range scales {
+		if scale.Percent == 0 {
+			continue
+		}
+		percent := min(max(scale.Percent, MinCaptureScale), 100)
+		for id := range client.monitorMap {
+			if scale.MonitorID == 0 || id == scale.MonitorID {
+				client.captureScales[id] = percent
+			}
+		}
+		log.Printf("Client %s requested monitor %d at %d%% of its size", client.id, scale.MonitorID, percent)
+	}
+}
+
+// captureScale returns the scale a monitor's frames are encoded at before
+// the ladder level's: the largest a client asked for, else the server's
+// setting
+func (s *Server) captureScale(monitorID uint32) float64 {
+	percent, ok := s.captureScales[monitorID]
+	if !ok {
+		percent, ok = s.captureScales[0]
+	}
+	if !ok {
+		percent = 100
+	}
+
+	s.clientsMutex.Lock()
+	requested := 0
+	for _, client := range s.clients {
+		requested = max(requested, client.captureScales[monitorID])
+	}
+	s.clientsMutex.Unlock()
+	if requested > 0 {
+		percent = requested
+	}
+	return float64(percent) / 100
+}
+
+// scaleFrame shrinks a captured frame to scale times its size, averaging
+// the pixels each output pixel covers so text stays legible. Frames that
+// aren't RGBA are sampled instead, frames in GPU memory are copied to
+// memory first.
+func scaleFrame(img image.Image, scale float64) image.Image {
+	bounds := img.Bounds()
+	width := max(int(float64(bounds.Dx())*scale), 1)
+	height := max(int(float64(bounds.Dy())*scale), 1)
+	if scale >= 1 || width == bounds.Dx() && height == bounds.Dy() {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	img = codec.InMemory(img)
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				dst.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+			}
+		}
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		top := y * bounds.Dy() / height
+		bottom := max((y+1)*bounds.Dy()/height, top+1)
+		for x := 0; x < width; x++ {
+			left := x * bounds.Dx() / width
+			right := max((x+1)*bounds.Dx()/width, left+1)
+
+			var r, g, b, a, n int
+			for sy := top; sy < bottom; sy++ {
+				offset := src.PixOffset(bounds.Min.X+left, bounds.Min.Y+sy)
+				for sx := left; sx < right; sx++ {
+					p := src.Pix[offset : offset+4 : offset+4]
+					r, g, b, a = r+int(p[0]), g+int(p[1]), b+int(p[2]), a+int(p[3])
+					offset += 4
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = byte(r/n), byte(g/n), byte(b/n), byte(a/n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// screenCaptureRetry is how long a monitor no capture API captures waits
+// before trying them again, when screenshots weren't asked for
+const screenCaptureRetry = 5 * time.Second
+
+// screenCapturer captures a monitor to memory with a platform capture API,
+// faster than the screenshot package and aware of what changed
+type screenCapturer interface {
+	// capture returns the current image of the monitor, nil before the
+	// first one, and whether it changed since the previous call. The image
+	// stays valid until the next call.
+	capture() (img image.Image, changed bool, err error)
+
+	// close stops capturing
+	close()
+}
+
+// screenBackend is a platform capture API
+type screenBackend struct {
+	name string
+	key  string // Name in CaptureBackends
+
+	// available probes whether the API works on this machine, e.g. on
+	// this OS version; nil if it always does
+	available func() bool
+
+	// open starts capturing a monitor, with the cursor in the frames if
+	// cursor is set and the API draws it
+	open func(monitor protocol.MonitorInfo, cursor bool) (screenCapturer, error)
+
+	// cursor is whether the API draws the cursor into frames, the server
+	// draws it otherwise
+	cursor bool
+}
+
+// screenBackends are the capture APIs of the platform, the best first.
+// Monitors none of them can capture are captured with the screenshot
+// package, unless WithCaptureBackends leaves it out.
+var screenBackends []screenBackend
+
+// portalMonitors returns the monitors the user shares through the desktop
+// portal, which Wayland compositors only capture through; nil in builds
+// without it
+var portalMonitors func() (*protocol.MonitorConfig, error)
+
+// consoleMonitors returns the monitors of a machine without a display
+// server, from its graphics cards; nil where there is no such API
+var consoleMonitors func() (*protocol.MonitorConfig, error)
+
+// CaptureBackends are the names WithCaptureBackends takes: "auto" for
+// every capture API of the platform, the best first, then screenshots,
+// and "screenshot" for the screenshot package
+var CaptureBackends = []string{"auto", "sck", "cgstream", "dda", "x11", "pipewire", "drm", "screenshot"}
+
+// WithCaptureBackends sets the capture APIs tried for each monitor, in
+// order, see CaptureBackends. APIs that don't work on the machine are
+// skipped. Monitors are only captured with screenshots if "screenshot" or
+// "auto" is listed, or if none of the APIs works.
+func WithCaptureBackends(names []string) Option {
+	return func(s *Server) {
+		s.captureBackends = slices.Clone(names)
+	}
+}
+
+// ParseCaptureBackends parses a comma separated list of capture backends,
+// e.g. "sck,cgstream,screenshot"
+func ParseCaptureBackends(list string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(CaptureBackends, name) {
+			return nil, fmt.Errorf("unknown capture backend %q, expected one of %s", name, strings.Join(CaptureBackends, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// probeScreenCapture picks the capture APIs that work on this machine
+// among the ones asked for, once at startup
+func (s *Server) probeScreenCapture() {
+	s.screenBackends, s.screenshots = nil, true
+	if s.captureSource != nil {
+		return
+	}
+	names := s.captureBackends
+	if len(names) == 0 {
+		names = []string{"auto"}
+	}
+
+	s.screenshots = false
+	probed := make(map[string]bool)
+	add := func(backend screenBackend) {
+		if _, ok := probed[backend.key]; !ok {
+			probed[backend.key] = backend.available == nil || backend.available()
+			if probed[backend.key] {
+				s.screenBackends = append(s.screenBackends, backend)
+			}
+		}
+	}
+	for _, name := range names {
+		switch name {
+		case "auto":
+			for _, backend := range screenBackends {
+				add(backend)
+			}
+			s.screenshots = true
+		case "screenshot":
+			s.screenshots = true
+		default:
+			i := slices.IndexFunc(screenBackends, func(b screenBackend) bool { return b.key == name })
+			if i < 0 {
+				log.Printf("Capture backend %s isn't supported by this build", name)
+				continue
+			}
+			add(screenBackends[i])
+			if !probed[name] {
+				log.Printf("Capture backend %s isn't available on this machine", name)
+			}
+		}
+		// Screenshots always work, the backends after them are never tried
+		if s.screenshots {
+			break
+		}
+	}
+
+	var active []string
+	for _, backend := range s.screenBackends {
+		active = append(active, backend.name)
+	}
+	switch {
+	case len(active) == 0 && !s.screenshots:
+		log.Printf("None of the capture backends asked for works, capturing monitors with screenshots")
+		s.screenshots = true
+	case len(active) == 0:
+		log.Printf("Capturing monitors with screenshots")
+	case s.screenshots:
+		log.Printf("Screen capture backends: %s, then screenshots", strings.Join(active, ", "))
+	default:
+		log.Printf("Screen capture backends: %s", strings.Join(active, ", "))
+	}
+}
+
+// openScreenCapture starts capturing a monitor with the first capture API
+// that works for it, it returns nil if the monitor is captured with the
+// screenshot package or, if screenshots weren't asked for, can't be
+// captured. cursor reports whether its frames include the cursor.
+func (s *Server) openScreenCapture(monitor protocol.MonitorInfo) (capturer screenCapturer, cursor bool) {
+	if s.captureSource != nil {
+		return nil, false
+	}
+	for _, backend := range s.screenBackends {
+		capturer, err := backend.open(monitor, s.captureCursor())
+		if err != nil {
+			log.Printf("%s capture of monitor %d failed: %v", backend.name, monitor.ID, err)
+			continue
+		}
+		log.Printf("Capturing monitor %d with %s", monitor.ID, backend.name)
+		return capturer, backend.cursor
+	}
+	if s.screenshots {
+		log.Printf("Capturing monitor %d with screenshots", monitor.ID)
+	} else {
+		log.Printf("No capture backend asked for can capture monitor %d", monitor.ID)
+	}
+	return nil, false
+}
@@ -0,0 +1,277 @@
+//go:build darwin && cgo
+
+package server
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework CoreGraphics -framework IOSurface
+#include <stdlib.h>
+#include <string.h>
+#import <Foundation/Foundation.h>
+#import <CoreGraphics/CoreGraphics.h>
+#import <IOSurface/IOSurface.h>
+
+// CGDisplayStream is deprecated in favor of ScreenCaptureKit, it is only
+// used where that is missing
+#pragma clang diagnostic ignored "-Wdeprecated-declarations"
+
+// cgds_max_dirty is how many changed rectangles are kept between two
+// copies, beyond it the whole frame is copied
+#define cgds_max_dirty 256
+
+// URDPDisplayStream receives the frames of a CGDisplayStream, keeping the
+// last one and the rectangles changed since the last copy
+@interface URDPDisplayStream : NSObject {
+@public
+	CGDisplayStreamRef stream;
+	IOSurfaceRef frame;
+	CGRect dirty[cgds_max_dirty];
+	size_t dirtyCount;
+	BOOL everything; // More changed than dirty holds
+	BOOL stopped;
+}
+@end
+
+@implementation URDPDisplayStream
+- (void)update:(IOSurfaceRef)surface rects:(const CGRect *)rects count:(size_t)count {
+	@synchronized (self) {
+		CFRetain(surface);
+		IOSurfaceIncrementUseCount(surface);
+		if (frame != NULL) {
+			IOSurfaceDecrementUseCount(frame);
+			CFRelease(frame);
+		}
+		frame = surface;
+
+		if (rects == NULL || dirtyCount + count > cgds_max_dirty) {
+			everything = YES;
+			dirtyCount = 0;
+		} else if (!everything) {
+			memcpy(dirty + dirtyCount, rects, count * sizeof(CGRect));
+			dirtyCount += count;
+		}
+	}
+}
+
+- (void)stop {
+	@synchronized (self) {
+		stopped = YES;
+	}
+}
+
+- (void)dealloc {
+	if (frame != NULL) {
+		IOSurfaceDecrementUseCount(frame);
+		CFRelease(frame);
+	}
+	if (stream != NULL) {
+		CFRelease(stream);
+	}
+}
+@end
+
+static int cgds_available(void) {
+	if (@available(macOS 15.0, *)) {
+		return 0;
+	}
+	return 1;
+}
+
+// cgds_start streams the index-th active display at width x height pixels,
+// with the cursor if cursor is set. It returns NULL and an error message to
+// free in *err on failure, e.g. without the screen recording permission.
+static void *cgds_start(int index, int width, int height, int cursor, char **err) {
+	CGDirectDisplayID ids[32];
+	uint32_t count = 0;
+	if (CGGetActiveDisplayList(32, ids, &count) != kCGErrorSuccess || index < 0 || (uint32_t)index >= count) {
+		*err = strdup("no such display");
+		return NULL;
+	}
+
+	URDPDisplayStream *output = [[URDPDisplayStream alloc] init];
+	__weak URDPDisplayStream *weak = output;
+	NSDictionary *options = @{(__bridge NSString *)kCGDisplayStreamShowCursor: cursor != 0 ? @YES : @NO};
+	dispatch_queue_t queue = dispatch_queue_create("ultrardp.displaystream", DISPATCH_QUEUE_SERIAL);
+	output->stream = CGDisplayStreamCreateWithDispatchQueue(ids[index], width, height, 'BGRA',
+		(__bridge CFDictionaryRef)options, queue,
+		^(CGDisplayStreamFrameStatus status, uint64_t time, IOSurfaceRef surface, CGDisplayStreamUpdateRef update) {
+			URDPDisplayStream *strong = weak;
+			if (strong == nil) {
+				return;
+			}
+			if (status == kCGDisplayStreamFrameStatusStopped) {
+				[strong stop];
+				return;
+			}
+			if (status != kCGDisplayStreamFrameStatusFrameComplete || surface == NULL) {
+				return;
+			}
+			size_t rects = 0;
+			const CGRect *dirty = NULL;
+			if (update != NULL) {
+				dirty = CGDisplayStreamUpdateGetRects(update, kCGDisplayStreamUpdateDirtyRects, &rects);
+			}
+			[strong update:surface rects:dirty count:rects];
+		});
+	if (output->stream == NULL) {
+		*err = strdup("can't create display stream");
+		return NULL;
+	}
+	if (CGDisplayStreamStart(output->stream) != kCGErrorSuccess) {
+		*err = strdup("can't start display stream, is screen recording allowed?");
+		return NULL;
+	}
+	return (__bridge_retained void *)output;
+}
+
+// cgds_copy copies the parts of the last frame changed since the previous
+// copy, everything if full is set, into RGBA pixels of width x height. It
+// returns 1 if it copied anything, 0 if not and -1 if the stream stopped.
+static int cgds_copy(void *capture, uint8_t *rgba, int width, int height, int full) {
+	URDPDisplayStream *output = (__bridge URDPDisplayStream *)capture;
+	@synchronized (output) {
+		if (output->stopped) {
+			return -1;
+		}
+		if (output->frame == NULL || (output->dirtyCount == 0 && !output->everything && !full)) {
+			return 0;
+		}
+		full = full || output->everything;
+
+		IOSurfaceRef frame = output->frame;
+		IOSurfaceLock(frame, kIOSurfaceLockReadOnly, NULL);
+		const uint8_t *src = IOSurfaceGetBaseAddress(frame);
+		size_t stride = IOSurfaceGetBytesPerRow(frame);
+		int columns = MIN((int)IOSurfaceGetWidth(frame), width);
+		int rows = MIN((int)IOSurfaceGetHeight(frame), height);
+		CGRect all = CGRectMake(0, 0, columns, rows);
+		size_t count = full ? 1 : output->dirtyCount;
+		for (size_t i = 0; i < count; i++) {
+			CGRect r = CGRectIntegral(CGRectIntersection(full ? all : output->dirty[i], all));
+			if (CGRectIsEmpty(r)) {
+				continue;
+			}
+			for (int y = (int)CGRectGetMinY(r); y < (int)CGRectGetMaxY(r); y++) {
+				const uint8_t *row = src + y*stride;
+				uint8_t *dst = rgba + (size_t)y*width*4;
+				for (int x = (int)CGRectGetMinX(r); x < (int)CGRectGetMaxX(r); x++) {
+					dst[x*4] = row[x*4+2];
+					dst[x*4+1] = row[x*4+1];
+					dst[x*4+2] = row[x*4];
+					dst[x*4+3] = 255;
+				}
+			}
+		}
+		IOSurfaceUnlock(frame, kIOSurfaceLockReadOnly, NULL);
+		output->dirtyCount = 0;
+		output->everything = NO;
+		return 1;
+	}
+}
+
+static void cgds_stop(void *capture) {
+	URDPDisplayStream *output = (__bridge_transfer URDPDisplayStream *)capture;
+	CGDisplayStreamStop(output->stream);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+func init() {
+	// ScreenCaptureKit replaced CGDisplayStream in macOS 12.3. macOS 15
+	// keeps asking for permission to use CGDisplayStream, so it is only
+	// used before that.
+	screenBackends = append(screenBackends,
+		screenBackend{name: "ScreenCaptureKit", key: "sck", available: screenCaptureKitAvailable, open: newScreenCaptureKitScreen, cursor: true},
+		screenBackend{name: "CGDisplayStream", key: "cgstream", available: displayStreamAvailable, open: newDisplayStream, cursor: true},
+	)
+}
+
+// screenCaptureKitScreen captures a display with ScreenCaptureKit to
+// memory, for the CPU pipeline
+type screenCaptureKitScreen struct {
+	stream   *screenCaptureKit
+	sequence uint64 // Of the last frame returned
+}
+
+func newScreenCaptureKitScreen(monitor protocol.MonitorInfo, cursor bool) (screenCapturer, error) {
+	stream, err := newScreenCaptureKit(monitor, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &screenCaptureKitScreen{stream: stream.(*screenCaptureKit)}, nil
+}
+
+func (c *screenCaptureKitScreen) capture() (image.Image, bool, error) {
+	surface, err := c.stream.capture()
+	if err != nil || surface == nil {
+		return nil, false, err
+	}
+	changed := surface.Sequence() != c.sequence
+	c.sequence = surface.Sequence()
+	return surface.Pixels(), changed, nil
+}
+
+func (c *screenCaptureKitScreen) close() { c.stream.close() }
+
+// displayStreamAvailable reports whether CGDisplayStream may be used,
+// before macOS 15
+func displayStreamAvailable() bool {
+	return C.cgds_available() != 0
+}
+
+// displayStream captures a display with CGDisplayStream, on macOS versions
+// without ScreenCaptureKit. Only the rectangles the display stream reports
+// as changed are copied to memory.
+type displayStream struct {
+	stream unsafe.Pointer
+	rect   image.Rectangle
+	frame  *image.RGBA // nil until the first frame arrived
+}
+
+func newDisplayStream(monitor protocol.MonitorInfo, cursor bool) (screenCapturer, error) {
+	showCursor := C.int(0)
+	if cursor {
+		showCursor = 1
+	}
+	var message *C.char
+	stream := C.cgds_start(C.int(monitor.ID-1), C.int(monitor.Width), C.int(monitor.Height), showCursor, &message)
+	if stream == nil {
+		defer C.free(unsafe.Pointer(message))
+		return nil, errors.New(C.GoString(message))
+	}
+	return &displayStream{
+		stream: stream,
+		rect:   image.Rect(0, 0, int(monitor.Width), int(monitor.Height)),
+	}, nil
+}
+
+func (c *displayStream) capture() (image.Image, bool, error) {
+	full := C.int(0)
+	frame := c.frame
+	if frame == nil {
+		frame = image.NewRGBA(c.rect)
+		full = 1
+	}
+	switch C.cgds_copy(c.stream, (*C.uint8_t)(unsafe.Pointer(&frame.Pix[0])), C.int(c.rect.Dx()), C.int(c.rect.Dy()), full) {
+	case -1:
+		return nil, false, errors.New("CGDisplayStream stopped")
+	case 0:
+		if c.frame == nil {
+			// No frame yet
+			return nil, false, nil
+		}
+		return c.frame, false, nil
+	}
+	c.frame = frame
+	return c.frame, true, nil
+}
+
+func (c *displayStream) close() { C.cgds_stop(c.stream) }
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// PermissionError is a permission the OS hasn't granted the server, with
+// what the user must do to grant it
+type PermissionError struct {
+	Permission string // As the OS settings call it
+	Guidance   string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("missing %s permission: %s", e.Permission, e.Guidance)
+}
+
+// checkScreenPermission returns the missing permission to record the
+// screen, nil if it was granted. Set by platforms asking for one.
+var checkScreenPermission = func() *PermissionError { return nil }
+
+// requestScreenPermission has the OS ask the user for the permission to
+// record the screen
+var requestScreenPermission = func() {}
+
+// CheckPermissions probes the OS permissions the server needs, returning a
+// *PermissionError for a missing one
+func CheckPermissions() error {
+	if err := checkScreenPermission(); err != nil {
+		return err
+	}
+	if err := checkInputPermission(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkScreenRecording asks the user for the permission to record the
+// screen at startup if it is missing
+func (s *Server) checkScreenRecording() {
+	if err := checkScreenPermission(); err != nil {
+		log.Printf("Warning: %v", err)
+		requestScreenPermission()
+	}
+}
+
+// reportScreenPermission tells the clients once if black frames are down
+// to the missing permission to record the screen
+func (s *Server) reportScreenPermission() {
+	err := checkScreenPermission()
+	if err == nil || s.permissionReported.Swap(true) {
+		return
+	}
+	log.Printf("Black frames are captured because of the %v", err)
+	s.broadcast(permissionPacket(protocol.ErrorScreenRecordingPermission, err))
+}
+
+// sendScreenPermission tells a connecting client if the server can't
+// record the screen
+func (s *Server) sendScreenPermission(client *Client) {
+	if err := checkScreenPermission(); err != nil {
+		client.send(permissionPacket(protocol.ErrorScreenRecordingPermission, err))
+	}
+}
+
+// permissionPacket builds a packet telling clients what to grant
+func permissionPacket(code protocol.ErrorCode, err *PermissionError) *protocol.Packet {
+	payload := protocol.EncodeServerError(protocol.ServerError{
+		Code:    code,
+		Message: err.Error(),
+	})
+	return protocol.NewPacket(protocol.PacketTypeServerError, payload)
+}
@@ -0,0 +1,54 @@
+//go:build darwin && cgo
+
+package server
+
+/*
+#cgo LDFLAGS: -framework CoreGraphics
+#include <CoreGraphics/CoreGraphics.h>
+
+// screen_permission returns 1 if the process may record the screen
+static int screen_permission(void) {
+	if (__builtin_available(macOS 10.15, *)) {
+		return CGPreflightScreenCaptureAccess() ? 1 : 0;
+	}
+	return 1;
+}
+
+// screen_permission_request shows the system prompt, once per app
+static void screen_permission_request(void) {
+	if (__builtin_available(macOS 10.15, *)) {
+		CGRequestScreenCaptureAccess();
+	}
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	checkScreenPermission = darwinScreenPermission
+	requestScreenPermission = func() { C.screen_permission_request() }
+}
+
+// darwinScreenPermission checks the Screen Recording permission, without
+// which capture APIs return black frames or fail. macOS grants it to the
+// app the server runs in, e.g. the terminal, and it only applies after
+// restarting that app.
+func darwinScreenPermission() *PermissionError {
+	if C.screen_permission() != 0 {
+		return nil
+	}
+	name := "the server"
+	if path, err := os.Executable(); err == nil {
+		name = filepath.Base(path)
+	}
+	return &PermissionError{
+		Permission: "Screen Recording",
+		Guidance: fmt.Sprintf("open System Settings > Privacy & Security > Screen & System Audio Recording, "+
+			"allow %s or the terminal running it, then restart it", name),
+	}
+}
@@ -1,12 +1,24 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
+	"image"
 	"log"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 	"github.com/kbinani/screenshot"
+	"github.com/moderniselife/ultrardp/bandwidth"
+	"github.com/moderniselife/ultrardp/clipboard"
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/diagnostics"
+	"github.com/moderniselife/ultrardp/discovery"
 	"github.com/moderniselife/ultrardp/protocol"
+	"github.com/moderniselife/ultrardp/stats"
+	"github.com/moderniselife/ultrardp/transport"
 )
 
 // Server represents an UltraRDP server instance
@@ -15,8 +27,114 @@ type Server struct {
 	listener     net.Listener
 	clients      map[string]*Client
 	clientsMutex sync.Mutex
-	monitors     *protocol.MonitorConfig
 	stopped      bool
+
+	monitors      *protocol.MonitorConfig          // Monitors clients see, see monitorConfig
+	desktop       *protocol.MonitorConfig          // Monitors of the machine, which monitors may be regions of
+	captures      map[uint32]chan struct{}         // Closed to stop the capture of a monitor, by ID
+	monitorsMutex sync.Mutex                       // Guards the above and colorProfiles, which change when monitors are plugged in or out
+
+	frameCache      map[uint32][]byte // Last encoded frame per server monitor
+	frameCacheMutex sync.Mutex
+
+	psk      []byte // Pre-shared key for protocol encryption, nil if disabled
+	password string // Password clients must prove knowledge of, empty if disabled
+
+	tlsEnabled bool
+	tlsCert    string // Certificate file, generated on first run if missing
+	tlsKey     string
+
+	clientCertsRequired bool
+	clientCA            string                // CA for client certificates, the server certificate if empty
+	permissionsPath     string                // Identity permissions file, empty allows every enrolled client
+	permissions         map[string]Permission // Loaded from permissionsPath, nil if unset
+
+	stopChan       chan struct{}
+	auditPath      string
+	audit          *auditLog
+	inputIndicator *inputIndicator
+
+	pauseOnLock  bool
+	screenLocked atomic.Bool
+
+	permissionReported atomic.Bool // Whether clients were told the screen can't be recorded
+
+	keepAwake keepAwake
+	camera    cameraShare
+
+	udpEnabled bool
+	udp        *udpChannel
+
+	relayAddress string // Relay to register with instead of listening, empty to listen
+	relayCode    string // Session code clients join on the relay
+
+	discoveryEnabled bool                     // Advertise with mDNS
+	advertisement    *discovery.Advertisement // Running advertisement, nil if not advertising
+
+	parallelEnabled   bool
+	streamTokens      map[string]*Client // Session token -> client with per-monitor connections
+	streamTokensMutex sync.Mutex
+
+	clipboard *clipboard.Sync // Clipboard state, nil if sync is disabled
+	transport string          // Transport clients connect with
+
+	captureSource  CaptureSource   // Replaces screen capture if set
+	captureWindow  *WindowSelector // Application window shared instead of the monitors, nil for none
+	captureRegion  image.Rectangle // Part of the desktop streamed instead of the monitors, empty for none
+	headlessConfig *HeadlessConfig // Virtual display to start, nil to capture the machine's
+	headless       headlessSession // Running virtual display, nil if none
+	screenBackends []screenBackend // Capture APIs that work on this machine, see probeScreenCapture
+
+	captureBackends []string // Capture backends asked for, see WithCaptureBackends; nil for auto
+	screenshots     bool     // Whether monitors no capture API captures fall back to screenshots
+
+	bulkShare    float64 // Share of each client's bandwidth bulk transfers may use
+	maxBandwidth int     // Upper bound on the video bandwidth in kbit/s, 0 for none
+
+	codecs   []codec.ID // Codecs video may be encoded with, JPEG only if empty
+	pipeline Pipeline   // How captured frames reach the encoders
+	textPNG  bool       // Send frames of text as PNG to clients that can decode it
+
+	sendProfiles  bool              // Send clients the monitors' color profiles
+	colorProfiles map[uint32][]byte // ICC profiles by monitor ID, see loadColorProfiles
+
+	idleAfter time.Duration // Idle time after which monitors are captured slowly, 0 never
+	input     inputActivity // Wakes idle capture loops
+
+	roiRadius int            // Distance from the cursor encoded at a higher quality, 0 for none
+	cursor    cursorPosition // Cursor of the last client that moved it
+
+	cursorMode   CursorMode   // How clients see the server's cursor
+	systemCursor systemCursor // Last read of the cursor, see currentCursor
+
+	keyframeInterval atomic.Int32 // Frames between keyframes of video codecs, 0 for keyframes only when needed
+
+	rateControl         codec.RateControl            // Rate control of video encoders
+	monitorRateControls map[uint32]codec.RateControl // Rate control overriding rateControl, by monitor ID
+
+	simulcastTiers int // Renditions of a monitor encoded per codec at most, 0 for one per ladder level
+
+	frameRate         int            // Target frames per second of every monitor
+	monitorFrameRates map[uint32]int // Target frames per second overriding frameRate, by monitor ID
+	captureScales     map[uint32]int // Percent of their size monitors are encoded at, 0 for all monitors; see WithCaptureScales
+
+	stats         *stats.Collector
+	statsPath     string        // Destination for JSON stats, empty if disabled
+	statsInterval time.Duration // Interval between JSON stats snapshots
+
+	debugFramesLimit int64                  // Size of the debug frames kept, 0 if disabled, see WithDebugFrames
+	frameDump        *diagnostics.FrameDump // nil unless debug frames are saved
+}
+
+// Option configures optional server behaviour
+type Option func(*Server)
+
+// WithPreSharedKey enables protocol encryption. Clients must be configured
+// with the same key or the key exchange fails.
+func WithPreSharedKey(psk []byte) Option {
+	return func(s *Server) {
+		s.psk = psk
+	}
 }
 
 // Client represents a connected client
@@ -26,33 +144,238 @@ type Client struct {
 	active     bool
 	monitorMap map[uint32]uint32
 	monitors   *protocol.MonitorConfig
+	sendMutex  sync.Mutex
+	udp        *udpState // UDP video channel, nil when using TCP only
+	stats      *stats.Collector
+	bandwidth  *bandwidth.Scheduler  // Shares the connection between video and bulk transfers
+	congestion *bandwidth.Controller // Paces video to the bandwidth the client acknowledges
+
+	maxBandwidth atomic.Int64 // Video bandwidth limit the client asked for in kbit/s, 0 for none
+
+	quality        atomic.Int32         // Video quality (1-100) the client asked for, 0 for the default
+	offered        []codec.ID           // Codecs the client can decode, most preferred first
+	codecs         map[uint32]codec.ID  // Codec the client receives each monitor in, JPEG if missing; see handleCodecs
+	keyframeNeeded map[uint32]bool      // Monitors whose next frame must be a keyframe for this client
+	keyframeAsked  map[uint32]time.Time // Last keyframe request honored by monitor, see handleKeyframeRequest
+	frameRates     map[uint32]int       // Frame rate the client asked for by monitor, see handleFrameRate
+	rateControl    codec.RateControl    // Rate control the client asked for, see handleQualityControl
+	captureScales  map[uint32]int       // Capture scale in percent the client asked for by monitor, see handleQualityControl
+	capabilities   protocol.Capability  // What the client's display can show, see handleCapabilities
+	adapter        *bandwidth.Adapter   // Picks the client's level on bandwidth.Ladder
+	levels         map[uint32]int       // Ladder level of the tier each monitor was last encoded at for this client
+	detached       map[uint32]bool      // Monitors whose windows the client closed, never mapped again; see handleMonitorDetach
+
+	cursorShape    uint32                  // ID of the last cursor shape sent, see sendCursor
+	cursorPosition protocol.CursorPosition // Last cursor position sent
+	cursorSent     bool                    // Whether cursorPosition was sent
+
+	identity   string     // Client certificate common name, empty without mutual TLS
+	permission Permission // What the client may do
+
+	inputPermissionReported atomic.Bool // Whether the client was told its input can't be injected
+
+	heldKeys      map[protocol.KeyCode]bool // Keys the client pressed and didn't release, see releaseKeys
+	heldKeysMutex sync.Mutex
+
+	bulkQueue   []*protocol.Packet // Bulk packets waiting to be sent, see queueBulk
+	bulkSending bool               // Whether bulkQueue is being sent
+	bulkMutex   sync.Mutex
+
+	streams      transport.MultiStreamConn // Set if the transport supports per-monitor streams
+	videoStreams map[uint32]*videoStream   // Open video streams or connections by server monitor ID
+	streamToken  []byte                    // Token for per-monitor connections, nil if not requested
+}
+
+// WithPassword requires clients to answer a challenge keyed with the given
+// password before any monitor data is sent to them
+func WithPassword(password string) Option {
+	return func(s *Server) {
+		s.password = password
+	}
+}
+
+// WithTLS serves connections over TLS. If the certificate and key files
+// don't exist a self-signed certificate is generated; empty paths select
+// the default location from transport.DefaultCertFiles.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		defaultCert, defaultKey := transport.DefaultCertFiles()
+		if certFile == "" {
+			certFile = defaultCert
+		}
+		if keyFile == "" {
+			keyFile = defaultKey
+		}
+		s.tlsEnabled = true
+		s.tlsCert = certFile
+		s.tlsKey = keyFile
+	}
 }
 
 // NewServer creates a new UltraRDP server
-func NewServer(address string) (*Server, error) {
-	// Detect monitors
-	monitors, err := detectMonitors()
-	if err != nil {
+func NewServer(address string, opts ...Option) (*Server, error) {
+	s := &Server{
+		address: address,
+		clients: make(map[string]*Client),
+		stopped: false,
+
+		frameCache:   make(map[uint32][]byte),
+		streamTokens: make(map[string]*Client),
+
+		stopChan:       make(chan struct{}),
+		inputIndicator: newInputIndicator(),
+		keepAwake:      keepAwake{enabled: true},
+
+		bulkShare:      bandwidth.DefaultBulkShare,
+		frameRate:      DefaultFrameRate,
+		idleAfter:      DefaultIdleAfter,
+		roiRadius:      DefaultROIRadius,
+		simulcastTiers: DefaultSimulcastTiers,
+		cursorMode:     CursorComposite,
+		sendProfiles:   true,
+		stats:          stats.New("server"),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// A headless server captures the virtual display it starts
+	if s.headlessConfig != nil {
+		if err := s.startHeadlessDisplay(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.initMonitors(); err != nil {
+		if s.headless != nil {
+			s.headless.close()
+		}
 		return nil, err
 	}
+	s.loadColorProfiles()
+
+	return s, nil
+}
+
+// initMonitors sets up the monitors clients see
+func (s *Server) initMonitors() error {
+	// A shared window replaces the monitors
+	if s.captureWindow != nil && s.captureSource == nil {
+		source, err := newWindowSource(*s.captureWindow)
+		if err != nil {
+			return err
+		}
+		s.captureSource = source
+	}
 
-	return &Server{
-		address:  address,
-		clients:  make(map[string]*Client),
-		monitors: monitors,
-		stopped:  false,
-	}, nil
+	desktop, monitors, err := s.layoutMonitors()
+	if err != nil {
+		return err
+	}
+	s.desktop, s.monitors = desktop, monitors
+	return nil
+}
+
+// layoutMonitors detects the monitors of the machine, and returns them
+// with the monitors clients see
+func (s *Server) layoutMonitors() (desktop, monitors *protocol.MonitorConfig, err error) {
+	if s.captureSource != nil {
+		monitors, err = s.captureSource.Monitors()
+		return monitors, monitors, err
+	}
+	desktop, err = detectMonitors()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A region is streamed as a monitor of its own
+	monitors = desktop
+	if !s.captureRegion.Empty() {
+		monitors, err = regionMonitors(desktop, s.captureRegion)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return desktop, monitors, nil
+}
+
+// monitorConfig returns the monitors clients see. Plugging monitors in or
+// out replaces the configuration, it is never modified.
+func (s *Server) monitorConfig() *protocol.MonitorConfig {
+	s.monitorsMutex.Lock()
+	defer s.monitorsMutex.Unlock()
+	return s.monitors
 }
 
 // Start begins the server's main loop
 func (s *Server) Start() error {
-	// Create TCP listener
-	listener, err := net.Listen("tcp", s.address)
+	// Create listener
+	var tlsConfig *tls.Config
+	if s.tlsEnabled {
+		config, err := transport.LoadServerTLS(s.tlsCert, s.tlsKey)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig = config
+		log.Printf("Serving over TLS with certificate %s", s.tlsCert)
+	}
+	if s.clientCertsRequired {
+		if err := s.loadClientAuth(tlsConfig); err != nil {
+			return err
+		}
+	}
+	listener, err := s.listen(tlsConfig)
 	if err != nil {
 		return err
 	}
 	s.listener = listener
 
+	// Open the audit log
+	if s.auditPath != "" {
+		audit, err := openAuditLog(s.auditPath)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		s.audit = audit
+		s.inputIndicator.audit = audit
+	}
+	go s.inputIndicator.run(s.stopChan)
+
+	// Emit stats snapshots for external consumers
+	if s.statsPath != "" {
+		if err := s.startStats(); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to open stats output: %w", err)
+		}
+	}
+
+	// Let clients on the local network find us
+	if s.discoveryEnabled && s.relayAddress == "" {
+		s.startDiscovery()
+	}
+
+	// Offer UDP for video frames, a relay only forwards the TCP connection
+	if s.udpEnabled && s.relayAddress == "" {
+		if err := s.startUDP(); err != nil {
+			log.Printf("Failed to start UDP transport, using TCP only: %v", err)
+		}
+	}
+
+	// Keep clipboards in sync
+	if s.clipboard != nil {
+		go s.pollClipboard()
+	}
+
+	// Pause streaming while the screen is locked
+	if s.pauseOnLock {
+		go s.watchLockState()
+	}
+
+	// Without the permission to inject input clients can only watch
+	s.checkInputInjection()
+
 	// Start screen capture
 	s.startScreenCapture()
 
@@ -76,10 +399,20 @@ func (s *Server) Start() error {
 
 // Stop shuts down the server
 func (s *Server) Stop() {
+	if s.stopped {
+		return
+	}
 	s.stopped = true
+	close(s.stopChan)
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	if s.udp != nil {
+		s.udp.conn.Close()
+	}
+	if s.advertisement != nil {
+		s.advertisement.Close()
+	}
 
 	// Close all client connections
 	s.clientsMutex.Lock()
@@ -87,12 +420,70 @@ func (s *Server) Stop() {
 		client.conn.Close()
 	}
 	s.clientsMutex.Unlock()
+
+	s.audit.close()
+	s.updateKeepAwake()
+	s.stopCamera()
+	if s.headless != nil {
+		s.headless.close()
+	}
 }
 
 // handleClient processes a client connection
 func (s *Server) handleClient(conn net.Conn) {
+	// Keep the raw connection for opening per-monitor streams. Streams
+	// bypass the pre-shared key encryption of the control stream, so they
+	// are only used if the transport encrypts them.
+	streams, _ := conn.(transport.MultiStreamConn)
+	if s.psk != nil && !transport.EncryptsStreams(s.transport, s.tlsEnabled) {
+		streams = nil
+	}
+	
+	// Identify the client by its certificate when mutual TLS is enabled
+	identity, permission := "", PermissionControl
+	if s.clientCertsRequired {
+		var err error
+		identity, err = transport.PeerIdentity(conn)
+		if err == nil {
+			var allowed bool
+			if permission, allowed = s.permissionFor(identity); !allowed {
+				err = fmt.Errorf("identity %q is not enrolled", identity)
+			}
+		}
+		if err != nil {
+			log.Printf("Client certificate of %s rejected: %v", conn.RemoteAddr(), err)
+			s.audit.record(AuditAuthFailed, conn.RemoteAddr().String(), err.Error())
+			conn.Close()
+			return
+		}
+		log.Printf("Client %s identified as %s (%s)", conn.RemoteAddr(), identity, permission)
+	}
+	
+	// Negotiate encryption before anything else is sent
+	if s.psk != nil {
+		secureConn, err := protocol.ServerKeyExchange(conn, s.psk)
+		if err != nil {
+			log.Printf("Key exchange with %s failed: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn = secureConn
+	}
+	
+	// Authenticate the client before it learns anything about this machine
+	if s.password != "" {
+		if err := s.authenticate(conn); err != nil {
+			log.Printf("Authentication of %s failed: %v", conn.RemoteAddr(), err)
+			s.audit.record(AuditAuthFailed, conn.RemoteAddr().String(), err.Error())
+			conn.Close()
+			return
+		}
+		log.Printf("Client %s authenticated", conn.RemoteAddr())
+	}
+	
 	// Send our monitor configuration to the client
-	monitorData := protocol.EncodeMonitorConfig(s.monitors)
+	monitors := s.monitorConfig()
+	monitorData := protocol.EncodeMonitorConfig(monitors)
 	handshakePacket := protocol.NewPacket(protocol.PacketTypeHandshake, monitorData)
 	
 	if err := protocol.EncodePacket(conn, handshakePacket); err != nil {
@@ -109,6 +500,15 @@ func (s *Server) handleClient(conn net.Conn) {
 		return
 	}
 	
+	// Extra connections of a session carry a single monitor's video
+	if packet.Type == protocol.PacketTypeStreamAttach {
+		if err := s.attachStream(conn, packet.Payload); err != nil {
+			log.Printf("Failed to attach stream from %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+		}
+		return
+	}
+	
 	if packet.Type != protocol.PacketTypeMonitorConfig {
 		log.Printf("Expected monitor config packet, got %d", packet.Type)
 		conn.Close()
@@ -130,31 +530,146 @@ func (s *Server) handleClient(conn net.Conn) {
 		active:     true,
 		id:         conn.RemoteAddr().String(),
 		monitorMap: make(map[uint32]uint32),
+		stats:      s.stats,
+		bandwidth:  bandwidth.NewScheduler(s.bulkShare),
+		congestion: bandwidth.NewController(),
+		identity:   identity,
+		permission: permission,
+
+		streams:      streams,
+		videoStreams: make(map[uint32]*videoStream),
+
+		codecs:         make(map[uint32]codec.ID),
+		keyframeNeeded: make(map[uint32]bool),
+		keyframeAsked:  make(map[uint32]time.Time),
+		frameRates:     make(map[uint32]int),
+		captureScales:  make(map[uint32]int),
+		adapter:        bandwidth.NewAdapter(),
+		levels:         make(map[uint32]int),
+		detached:       make(map[uint32]bool),
 	}
+	// Paced writes that block on the network feed the capacity estimate
+	// bulk transfers are scheduled with until acknowledgements arrive
+	client.congestion.ObserveWrites(client.bandwidth.ObserveWrite)
 	
 	// Create monitor mapping
-	for i := uint32(0); i < s.monitors.MonitorCount && i < clientMonitors.MonitorCount; i++ {
-		serverMonitor := s.monitors.Monitors[i]
-		clientMonitor := clientMonitors.Monitors[i]
-		client.monitorMap[serverMonitor.ID] = clientMonitor.ID
-		log.Printf("Mapped server monitor %d to client monitor %d", serverMonitor.ID, clientMonitor.ID)
+	s.mapMonitors(client, monitors)
+	
+	// Show the client the last known screen while live frames resume, in
+	// the colors of this machine
+	s.sendColorProfiles(client, client.monitorMap)
+	s.sendCachedFrames(client)
+	if s.isPaused() {
+		client.send(lockStatePacket(true))
 	}
+	s.sendScreenPermission(client)
+	s.sendClipboard(client)
 	
 	// Add client to server's client list
 	s.clientsMutex.Lock()
 	s.clients[conn.RemoteAddr().String()] = client
+	s.stats.SetClients(len(s.clients))
 	s.clientsMutex.Unlock()
+	s.updateKeepAwake()
 	
 	log.Printf("Client connected from %s with %d monitors", conn.RemoteAddr(), clientMonitors.MonitorCount)
+	s.audit.record(AuditClientConnected, client.id, fmt.Sprintf("%d monitors", clientMonitors.MonitorCount))
+	
+	// Input arrives on its own stream if the transport supports streams
+	if client.streams != nil {
+		go s.acceptStreams(client)
+	}
+	
+	// Handle packets from the client until it disconnects
+	s.receivePackets(client)
+}
+
+// loadClientAuth makes the TLS config require client certificates and
+// loads the identity permissions
+func (s *Server) loadClientAuth(tlsConfig *tls.Config) error {
+	if tlsConfig == nil {
+		return fmt.Errorf("client certificates require TLS")
+	}
+	caFile := s.clientCA
+	if caFile == "" {
+		caFile = s.tlsCert
+	}
+	if err := transport.RequireClientCertificates(tlsConfig, caFile); err != nil {
+		return fmt.Errorf("failed to load client CA: %w", err)
+	}
+	if s.permissionsPath != "" {
+		permissions, err := loadPermissions(s.permissionsPath)
+		if err != nil {
+			return fmt.Errorf("failed to load permissions: %w", err)
+		}
+		s.permissions = permissions
+	}
+	log.Printf("Requiring client certificates signed by %s", caFile)
+	return nil
+}
+
+// authenticate runs the challenge-response exchange with a new client
+func (s *Server) authenticate(conn net.Conn) error {
+	challenge, err := protocol.NewAuthChallenge()
+	if err != nil {
+		return err
+	}
+	
+	if err := protocol.EncodePacket(conn, protocol.NewPacket(protocol.PacketTypeAuthChallenge, challenge)); err != nil {
+		return fmt.Errorf("failed to send challenge: %w", err)
+	}
 	
-	// TODO: Start handling client communication (streaming, input, etc.)
+	// Don't let an unauthenticated client hold the connection open forever
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	
+	response, err := protocol.ReadAuthResponse(conn)
+	if err != nil {
+		return fmt.Errorf("failed to receive response: %w", err)
+	}
+	if !protocol.VerifyAuthResponse(s.password, challenge, response) {
+		return fmt.Errorf("invalid response")
+	}
+	
+	return nil
+}
+
+// mapMonitors maps the server's monitors to a client's, one to one in
+// order as the client does
+func (s *Server) mapMonitors(client *Client, monitors *protocol.MonitorConfig) {
+	client.monitorMap = make(map[uint32]uint32)
+	for i := uint32(0); i < monitors.MonitorCount && i < client.monitors.MonitorCount; i++ {
+		serverMonitor := monitors.Monitors[i]
+		clientMonitor := client.monitors.Monitors[i]
+		if client.detached[serverMonitor.ID] {
+			continue
+		}
+		client.monitorMap[serverMonitor.ID] = clientMonitor.ID
+		// The cached frame may be older than the screen, which isn't sent
+		// again until it changes
+		client.keyframeNeeded[serverMonitor.ID] = true
+		log.Printf("Mapped server monitor %d to client monitor %d", serverMonitor.ID, clientMonitor.ID)
+	}
 }
 
 // detectMonitors identifies the available monitors on the system
 func detectMonitors() (*protocol.MonitorConfig, error) {
+	// Wayland sessions share monitors through the portal
+	if portalMonitors != nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+		if config, err := portalMonitors(); err == nil {
+			return config, nil
+		}
+	}
+
 	// Get all active displays using screenshot package
 	displays := screenshot.NumActiveDisplays()
 	if displays < 1 {
+		// Consoles are captured from the graphics card
+		if consoleMonitors != nil {
+			if config, err := consoleMonitors(); err == nil {
+				return config, nil
+			}
+		}
 		return nil, fmt.Errorf("no active displays found")
 	}
 
@@ -0,0 +1,50 @@
+package server
+
+import "slices"
+
+// DefaultSimulcastTiers is how many renditions of a monitor are encoded at
+// most for clients on connections of different speeds
+const DefaultSimulcastTiers = 3
+
+// WithSimulcast sets how many renditions of each monitor, levels of
+// bandwidth.Ladder, are encoded at most per codec. Clients on connections
+// of very different speeds each receive the best rendition their own level
+// allows. More tiers fit each client better but cost an encode each, 1
+// sends everyone the rendition of the slowest client and 0 encodes every
+// level a client is at.
+func WithSimulcast(tiers int) Option {
+	return func(s *Server) {
+		s.simulcastTiers = max(tiers, 0)
+	}
+}
+
+// simulcastTiers returns the ladder levels to encode for clients at the
+// given levels, at most n of them: the best and the worst level and ones
+// spread evenly between. Every level is a tier if n is 0.
+func simulcastTiers(levels []int, n int) []int {
+	tiers := slices.Clone(levels)
+	slices.Sort(tiers)
+	tiers = slices.Compact(tiers)
+	if n <= 0 || len(tiers) <= n {
+		return tiers
+	}
+	if n == 1 {
+		return tiers[len(tiers)-1:]
+	}
+	spread := make([]int, n)
+	for i := range spread {
+		spread[i] = tiers[i*(len(tiers)-1)/(n-1)]
+	}
+	return spread
+}
+
+// tierFor returns the tier a client at a level receives, the best one that
+// doesn't ask more of its connection than the level does
+func tierFor(tiers []int, level int) int {
+	for _, tier := range tiers {
+		if tier >= level {
+			return tier
+		}
+	}
+	return level
+}
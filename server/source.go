@@ -0,0 +1,30 @@
+package server
+
+import (
+	"image"
+
+	"github.com/moderniselife/ultrardp/protocol"
+	"github.com/moderniselife/ultrardp/stats"
+)
+
+// CaptureSource provides the monitors and their images instead of the
+// screen, e.g. synthetic frames for tests
+type CaptureSource interface {
+	// Monitors returns the monitor layout announced to clients
+	Monitors() (*protocol.MonitorConfig, error)
+
+	// Capture returns the current image of a monitor
+	Capture(monitor protocol.MonitorInfo) (image.Image, error)
+}
+
+// WithCaptureSource captures from source instead of the screen
+func WithCaptureSource(source CaptureSource) Option {
+	return func(s *Server) {
+		s.captureSource = source
+	}
+}
+
+// Stats returns the server's current statistics
+func (s *Server) Stats() stats.Snapshot {
+	return s.stats.Snapshot()
+}
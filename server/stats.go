@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/stats"
+)
+
+// WithStatsJSON periodically writes the stats snapshot as JSON lines to
+// path, which is either stats.Stdout or a file that is appended to
+func WithStatsJSON(path string, interval time.Duration) Option {
+	return func(s *Server) {
+		s.statsPath = path
+		s.statsInterval = interval
+	}
+}
+
+// startStats opens the stats output and starts emitting snapshots
+func (s *Server) startStats() error {
+	if s.statsInterval <= 0 {
+		return fmt.Errorf("invalid stats interval %v", s.statsInterval)
+	}
+
+	output, err := stats.OpenJSON(s.statsPath)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Writing stats to %s every %v", s.statsPath, s.statsInterval)
+	go s.stats.EmitJSON(output, s.statsInterval, s.stopChan)
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"io"
+	"log"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// videoStream is a per-monitor stream on a multi-stream connection
+type videoStream struct {
+	writer io.WriteCloser
+	mutex  sync.Mutex
+}
+
+// WithTransport selects the transport clients connect with, see the
+// transport package for the names
+func WithTransport(name string) Option {
+	return func(s *Server) {
+		s.transport = name
+	}
+}
+
+// acceptStreams receives the streams opened by a client: the input stream
+// and the camera stream
+func (s *Server) acceptStreams(client *Client) {
+	for client.active {
+		id, stream, err := client.streams.AcceptStream()
+		if err != nil {
+			return
+		}
+		if id != protocol.StreamInput && id != protocol.StreamCamera {
+			log.Printf("Client %s opened unknown stream %d", client.id, id)
+			stream.Close()
+			continue
+		}
+		go s.receiveStream(client, stream)
+	}
+}
+
+// receiveStream handles the packets arriving on a stream opened by a client
+func (s *Server) receiveStream(client *Client, stream io.ReadCloser) {
+	defer stream.Close()
+
+	for client.active {
+		packet, err := protocol.DecodePacket(stream)
+		if err != nil {
+			return
+		}
+		s.stats.PacketReceived(protocol.HeaderSize + len(packet.Payload))
+		s.handlePacket(client, packet)
+	}
+}
+
+// hasVideoStream reports whether a monitor's frames have their own stream
+// or connection
+func (c *Client) hasVideoStream(monitorID uint32) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	_, ok := c.videoStreams[monitorID]
+	return ok
+}
+
+// sendFrameStream sends a video frame on the monitor's own stream, opening
+// it on first use. Each monitor gets an independent stream so a large frame
+// of one monitor never delays the frames of another.
+func (s *Server) sendFrameStream(client *Client, monitorID uint32, frameData []byte) error {
+	client.sendMutex.Lock()
+	stream, ok := client.videoStreams[monitorID]
+	if !ok && client.streams == nil {
+		// The monitor's connection closed, use the control connection
+		client.sendMutex.Unlock()
+		return client.send(protocol.NewPacket(protocol.PacketTypeVideoFrame, frameData))
+	}
+	if !ok {
+		writer, err := client.streams.OpenStream(monitorID)
+		if err != nil {
+			client.sendMutex.Unlock()
+			return err
+		}
+		stream = &videoStream{writer: writer}
+		client.videoStreams[monitorID] = stream
+		log.Printf("Opened video stream for monitor %d to client %s", monitorID, client.id)
+	}
+	client.sendMutex.Unlock()
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	s.stats.PacketSent(protocol.HeaderSize + len(frameData))
+	return protocol.EncodePacket(client.congestion.Pace(stream.writer), protocol.NewPacket(protocol.PacketTypeVideoFrame, frameData))
+}
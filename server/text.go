@@ -0,0 +1,26 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/moderniselife/ultrardp/codec"
+)
+
+// WithTextPNG sends frames that are mostly text, e.g. of a terminal or code
+// editor, as PNG instead of JPEG to clients that can decode PNG. JPEG rings
+// around glyphs, PNG keeps them sharp and compresses text well.
+func WithTextPNG(enabled bool) Option {
+	return func(s *Server) {
+		s.textPNG = enabled
+	}
+}
+
+// frameCodec returns the codec of the frame to send a client: its codec
+// for the monitor, or PNG for text if it receives JPEG and offered PNG
+func frameCodec(client *Client, monitorID uint32, text bool) codec.ID {
+	id := client.codecs[monitorID]
+	if text && id == codec.JPEG && slices.Contains(client.offered, codec.PNG) {
+		return codec.PNG
+	}
+	return id
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/rand"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// udpChannel is the optional UDP channel used for video frames. Control
+// packets keep using the TCP connection, which retransmits them reliably,
+// while video fragments are sent once and dropped by the client on loss so
+// a lost packet never stalls the frames behind it.
+type udpChannel struct {
+	conn   *net.UDPConn
+	mutex  sync.Mutex
+	tokens map[string]*Client // Session token -> client awaiting its hello
+}
+
+// udpState is the per-client UDP state
+type udpState struct {
+	token    []byte
+	addr     *net.UDPAddr
+	sequence uint32
+}
+
+// WithUDP allows clients to receive video frames over UDP
+func WithUDP(enabled bool) Option {
+	return func(s *Server) {
+		s.udpEnabled = enabled
+	}
+}
+
+// startUDP listens for UDP on the same address as the TCP listener
+func (s *Server) startUDP() error {
+	addr, err := net.ResolveUDPAddr("udp", s.address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.udp = &udpChannel{conn: conn, tokens: make(map[string]*Client)}
+	go s.receiveDatagrams()
+
+	log.Printf("UDP video transport listening on %s", conn.LocalAddr())
+	return nil
+}
+
+// receiveDatagrams registers the UDP address of clients that sent a hello
+func (s *Server) receiveDatagrams() {
+	buf := make([]byte, 2048)
+	for !s.stopped {
+		n, addr, err := s.udp.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !s.stopped {
+				log.Printf("Error reading UDP datagram: %v", err)
+			}
+			return
+		}
+
+		datagram, err := protocol.DecodeDatagram(buf[:n])
+		if err != nil || datagram.Kind != protocol.DatagramHello {
+			continue
+		}
+
+		s.udp.mutex.Lock()
+		client, ok := s.udp.tokens[string(datagram.Payload)]
+		s.udp.mutex.Unlock()
+		if !ok {
+			continue
+		}
+
+		client.sendMutex.Lock()
+		if client.udp.addr == nil || client.udp.addr.String() != addr.String() {
+			log.Printf("Client %s receiving video over UDP at %s", client.id, addr)
+		}
+		client.udp.addr = addr
+		client.sendMutex.Unlock()
+	}
+}
+
+// handleUDPRequest answers a client's request to receive video over UDP
+func (s *Server) handleUDPRequest(client *Client) {
+	if s.udp == nil {
+		log.Printf("Client %s requested UDP transport, but it is disabled", client.id)
+		return
+	}
+	// Datagrams are not covered by the encrypted TCP stream
+	if s.psk != nil || s.tlsEnabled {
+		log.Printf("Client %s requested UDP transport, but it is not available with encryption", client.id)
+		return
+	}
+
+	token := make([]byte, protocol.UDPTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("Failed to create UDP token: %v", err)
+		return
+	}
+
+	client.sendMutex.Lock()
+	client.udp = &udpState{token: token}
+	client.sendMutex.Unlock()
+
+	s.udp.mutex.Lock()
+	s.udp.tokens[string(token)] = client
+	s.udp.mutex.Unlock()
+
+	port := uint16(s.udp.conn.LocalAddr().(*net.UDPAddr).Port)
+	if err := client.send(protocol.NewPacket(protocol.PacketTypeUDPSetup, protocol.EncodeUDPSetup(port, token))); err != nil {
+		log.Printf("Error sending UDP setup to client %s: %v", client.id, err)
+	}
+}
+
+// forgetUDP removes a disconnected client's UDP registration
+func (s *Server) forgetUDP(client *Client) {
+	if s.udp == nil || client.udp == nil {
+		return
+	}
+
+	s.udp.mutex.Lock()
+	delete(s.udp.tokens, string(client.udp.token))
+	s.udp.mutex.Unlock()
+}
+
+// sendFrameUDP sends a video frame to the client as UDP fragments. It
+// returns false if the client has no UDP address yet.
+func (s *Server) sendFrameUDP(client *Client, monitorID uint32, frameData []byte) (bool, error) {
+	client.sendMutex.Lock()
+	if client.udp == nil || client.udp.addr == nil {
+		client.sendMutex.Unlock()
+		return false, nil
+	}
+	client.udp.sequence++
+	sequence, addr := client.udp.sequence, client.udp.addr
+	client.sendMutex.Unlock()
+
+	// Datagrams are paced, a burst of them overflows router queues
+	for _, datagram := range protocol.FragmentFrame(sequence, monitorID, frameData) {
+		client.congestion.Wait(len(datagram))
+		if _, err := s.udp.conn.WriteToUDP(datagram, addr); err != nil {
+			return true, err
+		}
+		s.stats.PacketSent(len(datagram))
+	}
+	return true, nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"hash/maphash"
+	"image"
+	"image/draw"
+	"log"
+	"time"
+
+	"github.com/moderniselife/ultrardp/codec"
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// unchangedHeartbeat is how often the clients of a monitor whose screen
+// doesn't change are told so, instead of being sent the same frame again
+const unchangedHeartbeat = time.Second
+
+// frameHashSeed seeds the frame hashes, which are only compared within one
+// process
+var frameHashSeed = maphash.MakeSeed()
+
+// frameHash hashes the pixels of a captured frame. Frames in GPU memory
+// aren't read, their sequence number tells them apart.
+func frameHash(img image.Image) uint64 {
+	if surface, ok := img.(codec.Surface); ok {
+		return surface.Sequence()
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+
+	var h maphash.Hash
+	h.SetSeed(frameHashSeed)
+	bounds := rgba.Bounds()
+	rowLen := bounds.Dx() * 4
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := rgba.PixOffset(bounds.Min.X, y)
+		h.Write(rgba.Pix[offset : offset+rowLen])
+	}
+	return h.Sum64()
+}
+
+// unchangedFrames detects frames of a monitor identical to the last one
+// sent, so idle screens cost neither encoding nor bandwidth
+type unchangedFrames struct {
+	hash      uint64
+	size      image.Point
+	quality   int // Quality the last frame was encoded with
+	valid     bool
+	heartbeat time.Time // When the clients last heard about the monitor
+
+	// Whether a frame captured since the last one compared may differ from
+	// it, see captured
+	changed bool
+
+	next      uint64      // Hash of the frame to compare, if hashed
+	hashed    bool        // Whether next is set, see black
+	blackSize image.Point // Size blackHash is the hash of a black frame of
+	blackHash uint64
+}
+
+// captured records whether a captured frame may differ from the one
+// captured before it. Capture APIs that report changes spare hashing
+// frames nothing changed in.
+func (u *unchangedFrames) captured(changed bool) {
+	u.changed = u.changed || changed
+}
+
+// skip reports whether a frame is the same as the last one sent. A frame
+// is sent anyway when a client needs a fresh one, e.g. a keyframe after
+// switching codecs, or when the quality went up so an idle screen isn't
+// left at the quality of the last change.
+func (u *unchangedFrames) skip(img image.Image, quality int, refresh bool) bool {
+	hash, size := u.hash, img.Bounds().Size()
+	if u.hashed {
+		hash = u.next
+	} else if u.changed || !u.valid {
+		hash = frameHash(img)
+	}
+	u.changed, u.hashed = false, false
+	if u.valid && !refresh && hash == u.hash && size == u.size && quality <= u.quality {
+		return true
+	}
+	u.hash, u.size, u.quality, u.valid = hash, size, quality, true
+	u.heartbeat = time.Now()
+	return false
+}
+
+// black reports whether a frame that may have changed is all black, by
+// comparing its hash, which skip then reuses, with that of a black frame.
+// Frames nothing changed in and frames in GPU memory aren't checked.
+func (u *unchangedFrames) black(img image.Image) bool {
+	u.hashed = false
+	if _, ok := img.(codec.Surface); ok || !u.changed && u.valid {
+		return false
+	}
+	u.next, u.hashed = frameHash(img), true
+	if size := img.Bounds().Size(); size != u.blackSize {
+		black := image.NewRGBA(image.Rectangle{Max: size})
+		for i := 3; i < len(black.Pix); i += 4 {
+			black.Pix[i] = 0xFF
+		}
+		u.blackSize, u.blackHash = size, frameHash(black)
+	}
+	return u.next == u.blackHash
+}
+
+// reset makes the next frame be sent whatever it shows, e.g. after clients
+// were sent the lock screen placeholder
+func (u *unchangedFrames) reset() {
+	u.valid = false
+}
+
+// sendUnchanged tells the clients of a monitor that its screen hasn't
+// changed since the last frame, at most once per unchangedHeartbeat. The
+// packet's payload is the monitor ID.
+func (s *Server) sendUnchanged(monitorID uint32, u *unchangedFrames) {
+	if time.Since(u.heartbeat) < unchangedHeartbeat {
+		return
+	}
+	u.heartbeat = time.Now()
+
+	packet := protocol.NewPacket(protocol.PacketTypeFrameUnchanged, protocol.Uint32ToBytes(monitorID))
+	for _, client := range s.clientsShowing(monitorID) {
+		if err := client.send(packet); err != nil {
+			log.Printf("Error sending unchanged frame notice to client %s: %v", client.id, err)
+			s.clientsMutex.Lock()
+			client.active = false
+			s.clientsMutex.Unlock()
+		}
+	}
+}
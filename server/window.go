@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// WindowSelector picks the application window to share instead of the
+// monitors
+type WindowSelector struct {
+	PID   int    // Process owning the window, 0 for any
+	Title string // Part of the window's title, case insensitive; empty for any
+}
+
+// ParseWindowSelector parses a window given as "pid:1234" or part of its
+// title
+func ParseWindowSelector(value string) (WindowSelector, error) {
+	if pid, ok := strings.CutPrefix(value, "pid:"); ok {
+		n, err := strconv.Atoi(pid)
+		if err != nil || n <= 0 {
+			return WindowSelector{}, fmt.Errorf("invalid process ID %q", pid)
+		}
+		return WindowSelector{PID: n}, nil
+	}
+	if value == "" {
+		return WindowSelector{}, errors.New("no window title given")
+	}
+	return WindowSelector{Title: value}, nil
+}
+
+func (w WindowSelector) String() string {
+	if w.PID != 0 {
+		return fmt.Sprintf("pid:%d", w.PID)
+	}
+	return fmt.Sprintf("%q", w.Title)
+}
+
+// matches reports whether a window is the one selected
+func (w WindowSelector) matches(window appWindow) bool {
+	if w.PID != 0 && window.pid != w.PID {
+		return false
+	}
+	return strings.Contains(strings.ToLower(window.title), strings.ToLower(w.Title))
+}
+
+// WithCaptureWindow shares one application window instead of the
+// monitors, as a single monitor of the window's size
+func WithCaptureWindow(window WindowSelector) Option {
+	return func(s *Server) {
+		s.captureWindow = &window
+	}
+}
+
+// appWindow is a top level window on the desktop
+type appWindow struct {
+	id     uintptr // Platform handle
+	title  string
+	pid    int
+	bounds image.Rectangle // On the desktop
+}
+
+// windowCapturer captures an application window, including the parts
+// other windows cover where the platform allows
+type windowCapturer interface {
+	// capture returns the window's content at its current size
+	capture() (*image.RGBA, error)
+	close()
+}
+
+// listWindows returns the visible top level windows, frontmost first, and
+// openWindow starts capturing one of them; nil on platforms where windows
+// can't be captured
+var (
+	listWindows func() ([]appWindow, error)
+	openWindow  func(window appWindow) (windowCapturer, error)
+)
+
+// windowSource is a capture source sharing one application window. The
+// window is announced at the size it had when the server started, frames
+// are cropped or padded to it if the window is resized.
+type windowSource struct {
+	window   appWindow
+	capturer windowCapturer
+	mutex    sync.Mutex
+	frame    *image.RGBA
+}
+
+// newWindowSource finds the selected window, the frontmost if several
+// match
+func newWindowSource(selector WindowSelector) (*windowSource, error) {
+	if listWindows == nil || openWindow == nil {
+		return nil, errors.New("windows can't be captured on this platform")
+	}
+	windows, err := listWindows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list windows: %w", err)
+	}
+	for _, window := range windows {
+		if !selector.matches(window) || window.bounds.Empty() {
+			continue
+		}
+		capturer, err := openWindow(window)
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture window %q: %w", window.title, err)
+		}
+		log.Printf("Sharing window %q of process %d, %dx%d", window.title, window.pid, window.bounds.Dx(), window.bounds.Dy())
+		return &windowSource{window: window, capturer: capturer}, nil
+	}
+	return nil, fmt.Errorf("no window matches %s", selector)
+}
+
+func (w *windowSource) Monitors() (*protocol.MonitorConfig, error) {
+	return &protocol.MonitorConfig{
+		MonitorCount: 1,
+		Monitors: []protocol.MonitorInfo{{
+			ID:        1,
+			Width:     uint32(w.window.bounds.Dx()),
+			Height:    uint32(w.window.bounds.Dy()),
+			PositionX: uint32(w.window.bounds.Min.X),
+			PositionY: uint32(w.window.bounds.Min.Y),
+			Primary:   true,
+			Window:    true,
+		}},
+	}, nil
+}
+
+func (w *windowSource) Capture(monitor protocol.MonitorInfo) (image.Image, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	img, err := w.capturer.capture()
+	if err != nil {
+		return nil, err
+	}
+	rect := image.Rect(0, 0, int(monitor.Width), int(monitor.Height))
+	if img.Rect == rect {
+		return img, nil
+	}
+	if w.frame == nil || w.frame.Rect != rect {
+		w.frame = image.NewRGBA(rect)
+	}
+	draw.Draw(w.frame, rect, image.Black, image.Point{}, draw.Src)
+	draw.Draw(w.frame, rect, img, img.Rect.Min, draw.Src)
+	return w.frame, nil
+}
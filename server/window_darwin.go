@@ -0,0 +1,241 @@
+//go:build darwin && cgo
+
+package server
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework CoreGraphics -weak_framework ScreenCaptureKit
+#include <stdlib.h>
+#include <stdint.h>
+#include <string.h>
+#import <Foundation/Foundation.h>
+#import <CoreGraphics/CoreGraphics.h>
+#import <ScreenCaptureKit/ScreenCaptureKit.h>
+
+// wnd_info is a window on screen, in points
+typedef struct {
+	uint32_t id;
+	int pid;
+	int x, y, width, height;
+	char title[256];
+} wnd_info;
+
+// wnd_list lists up to max application windows on screen, frontmost first.
+// Titles need the screen recording permission, the owning app's name is
+// used without it.
+static int wnd_list(wnd_info *windows, int max) {
+	@autoreleasepool {
+		CFArrayRef list = CGWindowListCopyWindowInfo(kCGWindowListOptionOnScreenOnly | kCGWindowListExcludeDesktopElements, kCGNullWindowID);
+		if (list == NULL) {
+			return -1;
+		}
+		int count = 0;
+		for (NSDictionary *info in (__bridge NSArray *)list) {
+			if (count == max) {
+				break;
+			}
+			if ([info[(__bridge NSString *)kCGWindowLayer] intValue] != 0) {
+				continue;
+			}
+			CGRect bounds;
+			if (!CGRectMakeWithDictionaryRepresentation((__bridge CFDictionaryRef)info[(__bridge NSString *)kCGWindowBounds], &bounds)) {
+				continue;
+			}
+			wnd_info *w = &windows[count++];
+			w->id = [info[(__bridge NSString *)kCGWindowNumber] unsignedIntValue];
+			w->pid = [info[(__bridge NSString *)kCGWindowOwnerPID] intValue];
+			w->x = (int)bounds.origin.x;
+			w->y = (int)bounds.origin.y;
+			w->width = (int)bounds.size.width;
+			w->height = (int)bounds.size.height;
+			NSString *title = info[(__bridge NSString *)kCGWindowName];
+			if (title.length == 0) {
+				title = info[(__bridge NSString *)kCGWindowOwnerName];
+			}
+			strlcpy(w->title, title != nil ? title.UTF8String : "", sizeof(w->title));
+		}
+		CFRelease(list);
+		return count;
+	}
+}
+
+// wnd_size returns the size of a window in points, 0 if it is gone
+static int wnd_size(uint32_t id, int *width, int *height) {
+	@autoreleasepool {
+		// The array holds window IDs as pointers
+		const void *value = (const void *)(uintptr_t)id;
+		CFArrayRef ids = CFArrayCreate(NULL, &value, 1, NULL);
+		CFArrayRef list = CGWindowListCreateDescriptionFromArray(ids);
+		CFRelease(ids);
+		if (list == NULL) {
+			return 0;
+		}
+		int ok = 0;
+		NSArray *infos = (__bridge NSArray *)list;
+		CGRect bounds;
+		if (infos.count > 0 && [infos[0][(__bridge NSString *)kCGWindowIsOnscreen] boolValue] &&
+			CGRectMakeWithDictionaryRepresentation((__bridge CFDictionaryRef)infos[0][(__bridge NSString *)kCGWindowBounds], &bounds)) {
+			*width = (int)bounds.size.width;
+			*height = (int)bounds.size.height;
+			ok = 1;
+		}
+		CFRelease(list);
+		return ok;
+	}
+}
+
+static char *wnd_error(NSError *error, const char *fallback) {
+	const char *message = error != nil ? error.localizedDescription.UTF8String : NULL;
+	return strdup(message != NULL ? message : fallback);
+}
+
+// wnd_open returns a ScreenCaptureKit filter for a window, retained, or
+// NULL and an error message to free in *err
+static void *wnd_open(uint32_t id, char **err) {
+	if (@available(macOS 14.0, *)) {
+		dispatch_semaphore_t done = dispatch_semaphore_create(0);
+		__block SCShareableContent *content = nil;
+		__block NSError *failure = nil;
+		[SCShareableContent getShareableContentWithCompletionHandler:^(SCShareableContent *c, NSError *e) {
+			content = c;
+			failure = e;
+			dispatch_semaphore_signal(done);
+		}];
+		dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+		if (content == nil) {
+			*err = wnd_error(failure, "no shareable content");
+			return NULL;
+		}
+		for (SCWindow *window in content.windows) {
+			if (window.windowID == id) {
+				SCContentFilter *filter = [[SCContentFilter alloc] initWithDesktopIndependentWindow:window];
+				return (__bridge_retained void *)filter;
+			}
+		}
+		*err = strdup("window isn't shareable");
+		return NULL;
+	}
+	*err = strdup("capturing windows needs macOS 14 or newer");
+	return NULL;
+}
+
+// wnd_capture captures a window into RGBA pixels of width x height. It
+// returns NULL on success, else an error message to free.
+static char *wnd_capture(void *handle, uint8_t *rgba, int width, int height) {
+	if (@available(macOS 14.0, *)) {
+		SCContentFilter *filter = (__bridge SCContentFilter *)handle;
+		SCStreamConfiguration *config = [[SCStreamConfiguration alloc] init];
+		config.width = width;
+		config.height = height;
+		config.showsCursor = NO;
+		config.ignoreShadowsSingleWindow = YES;
+
+		dispatch_semaphore_t done = dispatch_semaphore_create(0);
+		__block CGImageRef image = NULL;
+		__block NSError *failure = nil;
+		[SCScreenshotManager captureImageWithFilter:filter configuration:config completionHandler:^(CGImageRef i, NSError *e) {
+			if (i != NULL) {
+				image = CGImageRetain(i);
+			}
+			failure = e;
+			dispatch_semaphore_signal(done);
+		}];
+		dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+		if (image == NULL) {
+			return wnd_error(failure, "window can't be captured");
+		}
+
+		CGColorSpaceRef space = CGColorSpaceCreateDeviceRGB();
+		CGContextRef context = CGBitmapContextCreate(rgba, width, height, 8, (size_t)width * 4, space,
+			kCGImageAlphaNoneSkipLast | kCGBitmapByteOrder32Big);
+		CGColorSpaceRelease(space);
+		if (context != NULL) {
+			CGContextDrawImage(context, CGRectMake(0, 0, width, height), image);
+			CGContextRelease(context);
+		}
+		CGImageRelease(image);
+		for (size_t i = 3; i < (size_t)width * height * 4; i += 4) {
+			rgba[i] = 0xFF;
+		}
+		return context != NULL ? NULL : strdup("can't create bitmap");
+	}
+	return strdup("capturing windows needs macOS 14 or newer");
+}
+
+static void wnd_close(void *handle) {
+	CFRelease(handle);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+)
+
+// maxWindows is how many windows are listed
+const maxWindows = 512
+
+func init() {
+	listWindows = listDarwinWindows
+	openWindow = openDarwinWindow
+}
+
+// listDarwinWindows lists the windows on screen with Quartz Window
+// Services
+func listDarwinWindows() ([]appWindow, error) {
+	infos := make([]C.wnd_info, maxWindows)
+	count := int(C.wnd_list(&infos[0], C.int(len(infos))))
+	if count < 0 {
+		return nil, errors.New("can't list windows")
+	}
+	windows := make([]appWindow, 0, count)
+	for _, info := range infos[:count] {
+		windows = append(windows, appWindow{
+			id:     uintptr(info.id),
+			title:  C.GoString(&info.title[0]),
+			pid:    int(info.pid),
+			bounds: image.Rect(int(info.x), int(info.y), int(info.x+info.width), int(info.y+info.height)),
+		})
+	}
+	return windows, nil
+}
+
+// darwinWindow captures a window with ScreenCaptureKit's screenshots,
+// which include the parts other windows cover
+type darwinWindow struct {
+	id     C.uint32_t
+	filter unsafe.Pointer
+	frame  *image.RGBA
+}
+
+func openDarwinWindow(window appWindow) (windowCapturer, error) {
+	var message *C.char
+	filter := C.wnd_open(C.uint32_t(window.id), &message)
+	if filter == nil {
+		defer C.free(unsafe.Pointer(message))
+		return nil, errors.New(C.GoString(message))
+	}
+	return &darwinWindow{id: C.uint32_t(window.id), filter: filter}, nil
+}
+
+func (w *darwinWindow) capture() (*image.RGBA, error) {
+	var width, height C.int
+	if C.wnd_size(w.id, &width, &height) == 0 || width <= 0 || height <= 0 {
+		return nil, errors.New("window was closed or hidden")
+	}
+	rect := image.Rect(0, 0, int(width), int(height))
+	if w.frame == nil || w.frame.Rect != rect {
+		w.frame = image.NewRGBA(rect)
+	}
+	if message := C.wnd_capture(w.filter, (*C.uint8_t)(unsafe.Pointer(&w.frame.Pix[0])), width, height); message != nil {
+		defer C.free(unsafe.Pointer(message))
+		return nil, errors.New(C.GoString(message))
+	}
+	return w.frame, nil
+}
+
+func (w *darwinWindow) close() {
+	C.wnd_close(w.filter)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"image"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	dwmapi = syscall.NewLazyDLL("dwmapi.dll")
+
+	procEnumWindows              = user32.NewProc("EnumWindows")
+	procIsWindow                 = user32.NewProc("IsWindow")
+	procIsWindowVisible          = user32.NewProc("IsWindowVisible")
+	procGetWindowTextW           = user32.NewProc("GetWindowTextW")
+	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
+	procGetWindowRect            = user32.NewProc("GetWindowRect")
+	procPrintWindow              = user32.NewProc("PrintWindow")
+	procCreateCompatibleDC       = gdi32.NewProc("CreateCompatibleDC")
+	procCreateCompatibleBitmap   = gdi32.NewProc("CreateCompatibleBitmap")
+	procSelectObject             = gdi32.NewProc("SelectObject")
+	procDwmGetWindowAttribute    = dwmapi.NewProc("DwmGetWindowAttribute")
+)
+
+// Constants from winuser.h and dwmapi.h
+const (
+	pwRenderFullContent      = 0x00000002
+	dwmwaExtendedFrameBounds = 9
+	dwmwaCloaked             = 14
+)
+
+// rect is a RECT
+type rect struct {
+	left, top, right, bottom int32
+}
+
+func (r rect) image() image.Rectangle {
+	return image.Rect(int(r.left), int(r.top), int(r.right), int(r.bottom))
+}
+
+func init() {
+	listWindows = listWindowsWindows
+	openWindow = openWindowsWindow
+}
+
+// listWindowsWindows enumerates the visible top level windows with a
+// title, EnumWindows goes from front to back
+func listWindowsWindows() ([]appWindow, error) {
+	var windows []appWindow
+	callback := syscall.NewCallback(func(hwnd, _ uintptr) uintptr {
+		if visible, _, _ := procIsWindowVisible.Call(hwnd); visible == 0 {
+			return 1
+		}
+		var cloaked uint32
+		if r, _, _ := procDwmGetWindowAttribute.Call(hwnd, dwmwaCloaked, uintptr(unsafe.Pointer(&cloaked)), 4); r == 0 && cloaked != 0 {
+			return 1
+		}
+		title := make([]uint16, 512)
+		n, _, _ := procGetWindowTextW.Call(hwnd, uintptr(unsafe.Pointer(&title[0])), uintptr(len(title)))
+		if n == 0 {
+			return 1
+		}
+		var pid uint32
+		procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
+		windows = append(windows, appWindow{
+			id:     hwnd,
+			title:  syscall.UTF16ToString(title[:n]),
+			pid:    int(pid),
+			bounds: windowFrame(hwnd),
+		})
+		return 1
+	})
+	if r, _, err := procEnumWindows.Call(callback, 0); r == 0 {
+		return nil, err
+	}
+	return windows, nil
+}
+
+// windowFrame returns the visible bounds of a window, without the
+// invisible borders Windows 10 and newer add for resizing
+func windowFrame(hwnd uintptr) image.Rectangle {
+	var r rect
+	if ok, _, _ := procDwmGetWindowAttribute.Call(hwnd, dwmwaExtendedFrameBounds, uintptr(unsafe.Pointer(&r)), unsafe.Sizeof(r)); ok == 0 {
+		return r.image()
+	}
+	procGetWindowRect.Call(hwnd, uintptr(unsafe.Pointer(&r)))
+	return r.image()
+}
+
+// windowsWindow captures a window with PrintWindow, which asks the window
+// to draw itself; covered and off-screen parts are captured too
+type windowsWindow struct {
+	hwnd uintptr
+}
+
+func openWindowsWindow(window appWindow) (windowCapturer, error) {
+	return &windowsWindow{hwnd: window.id}, nil
+}
+
+func (w *windowsWindow) capture() (*image.RGBA, error) {
+	if ok, _, _ := procIsWindow.Call(w.hwnd); ok == 0 {
+		return nil, errors.New("window was closed")
+	}
+	var r rect
+	if ok, _, err := procGetWindowRect.Call(w.hwnd, uintptr(unsafe.Pointer(&r))); ok == 0 {
+		return nil, err
+	}
+	full := r.image()
+	frame := windowFrame(w.hwnd).Intersect(full)
+	if frame.Empty() {
+		return nil, errors.New("window is minimized")
+	}
+
+	screen, _, _ := procGetDC.Call(0)
+	defer procReleaseDC.Call(0, screen)
+	dc, _, _ := procCreateCompatibleDC.Call(screen)
+	if dc == 0 {
+		return nil, errors.New("can't create device context")
+	}
+	defer procDeleteDC.Call(dc)
+	bm, _, _ := procCreateCompatibleBitmap.Call(screen, uintptr(full.Dx()), uintptr(full.Dy()))
+	if bm == 0 {
+		return nil, errors.New("can't create bitmap")
+	}
+	defer procDeleteObject.Call(bm)
+
+	old, _, _ := procSelectObject.Call(dc, bm)
+	ok, _, _ := procPrintWindow.Call(w.hwnd, dc, pwRenderFullContent)
+	procSelectObject.Call(dc, old)
+	if ok == 0 {
+		return nil, errors.New("window can't be drawn")
+	}
+	pixels, err := dibits(screen, bm, full.Dx(), full.Dy())
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the visible frame, BGRA to RGBA
+	img := image.NewRGBA(image.Rect(0, 0, frame.Dx(), frame.Dy()))
+	offset := frame.Min.Sub(full.Min)
+	for y := 0; y < frame.Dy(); y++ {
+		src := pixels[((offset.Y+y)*full.Dx()+offset.X)*4:]
+		dst := img.Pix[y*img.Stride:]
+		for x := 0; x < frame.Dx()*4; x += 4 {
+			dst[x] = src[x+2]
+			dst[x+1] = src[x+1]
+			dst[x+2] = src[x]
+			dst[x+3] = 0xFF
+		}
+	}
+	return img, nil
+}
+
+func (w *windowsWindow) close() {}
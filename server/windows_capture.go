@@ -0,0 +1,497 @@
+//go:build windows && cgo
+
+package server
+
+/*
+#cgo LDFLAGS: -ld3d11 -ldxgi -luuid -luser32
+#define COBJMACROS
+#include <initguid.h>
+#include <math.h>
+#include <stdlib.h>
+#include <string.h>
+#include <windows.h>
+#include <d3d11.h>
+#include <dxgi1_6.h>
+
+// dda_first_frame_ms is how long the first frame of a duplication is
+// waited for, later ones aren't waited for as the capture loop sets the pace
+#define dda_first_frame_ms 500
+
+// dda_capture duplicates one output with the Desktop Duplication API. The
+// staging texture keeps the desktop between frames, only the parts a frame
+// changed are copied into it and on to memory.
+typedef struct {
+	ID3D11Device *device;
+	ID3D11DeviceContext *context;
+	IDXGIOutput1 *output;
+	IDXGIOutputDuplication *duplication;
+	ID3D11Texture2D *staging;
+	int width;
+	int height;
+	int full; // Copy the whole desktop with the next frame
+
+	// HDR desktops are duplicated as linear scRGB and tone mapped to sRGB
+	// if tone_map is set, else Windows clips them to 8 bits
+	int tone_map;
+	int hdr;     // The duplication is scRGB
+	float white; // scRGB value of SDR white
+	float peak;  // Brightest value the display shows, relative to white
+
+	// Move and dirty rectangles of the last frame
+	uint8_t *metadata;
+	UINT metadata_size;
+	RECT *rects;
+	UINT rects_size;
+} dda_capture;
+
+static void dda_release_duplication(dda_capture *c) {
+	if (c->staging != NULL) {
+		ID3D11Texture2D_Release(c->staging);
+		c->staging = NULL;
+	}
+	if (c->duplication != NULL) {
+		IDXGIOutputDuplication_Release(c->duplication);
+		c->duplication = NULL;
+	}
+}
+
+// dda_half converts a half precision float
+static float dda_half(uint16_t h) {
+	uint32_t sign = (uint32_t)(h & 0x8000) << 16, exponent = (h >> 10) & 0x1F, mantissa = h & 0x3FF;
+	if (exponent == 0) {
+		float f = mantissa / 16777216.0f; // Subnormal, mantissa * 2^-24
+		return sign ? -f : f;
+	}
+	uint32_t bits = exponent == 31 ? sign | 0x7F800000 | mantissa << 13 : sign | (exponent + 112) << 23 | mantissa << 13;
+	float f;
+	memcpy(&f, &bits, sizeof(f));
+	return f;
+}
+
+// dda_tone_map_knee is where highlights start being compressed, below it
+// SDR content keeps its brightness
+#define dda_tone_map_knee 0.8f
+
+// dda_tone_map maps a linear value relative to SDR white to [0, 1]. Above
+// the knee values up to the display's peak are rolled off with the
+// extended Reinhard curve, reaching 1 at the peak.
+static float dda_tone_map(float x, float peak) {
+	if (x <= dda_tone_map_knee) {
+		return x;
+	}
+	float range = 1 - dda_tone_map_knee;
+	float t = (x - dda_tone_map_knee) / range, m = fmaxf((peak - dda_tone_map_knee) / range, 1);
+	return fminf(dda_tone_map_knee + range * t * (1 + t / (m * m)) / (1 + t), 1);
+}
+
+// dda_srgb encodes linear values in [0, 1] in 4096 steps as sRGB
+static uint8_t dda_srgb[4096];
+
+static void dda_srgb_init(void) {
+	for (int i = 0; i < 4096; i++) {
+		float v = i / 4095.0f;
+		v = v <= 0.0031308f ? v * 12.92f : 1.055f * powf(v, 1 / 2.4f) - 0.055f;
+		dda_srgb[i] = (uint8_t)(v * 255 + 0.5f);
+	}
+}
+
+// dda_copy_hdr tone maps a row of scRGB pixels to RGBA. The brightest
+// channel is mapped and the others scaled with it, keeping hues, and
+// colors outside sRGB are clipped.
+static void dda_copy_hdr(dda_capture *c, const uint16_t *src, uint8_t *dst, LONG count) {
+	for (LONG x = 0; x < count; x++, src += 4, dst += 4) {
+		float r = fmaxf(dda_half(src[0]) / c->white, 0);
+		float g = fmaxf(dda_half(src[1]) / c->white, 0);
+		float b = fmaxf(dda_half(src[2]) / c->white, 0);
+		float brightest = fmaxf(r, fmaxf(g, b));
+		if (brightest > dda_tone_map_knee) {
+			float scale = dda_tone_map(brightest, c->peak) / brightest;
+			r *= scale;
+			g *= scale;
+			b *= scale;
+		}
+		dst[0] = dda_srgb[(int)(fminf(r, 1) * 4095 + 0.5f)];
+		dst[1] = dda_srgb[(int)(fminf(g, 1) * 4095 + 0.5f)];
+		dst[2] = dda_srgb[(int)(fminf(b, 1) * 4095 + 0.5f)];
+		dst[3] = 0xFF;
+	}
+}
+
+// dda_sdr_white returns the scRGB value a display shows SDR white at, as
+// set in the HDR settings, or 1 (80 nits) if unknown
+static float dda_sdr_white(const WCHAR *device) {
+	UINT32 path_count = 0, mode_count = 0;
+	if (GetDisplayConfigBufferSizes(QDC_ONLY_ACTIVE_PATHS, &path_count, &mode_count) != ERROR_SUCCESS) {
+		return 1;
+	}
+	DISPLAYCONFIG_PATH_INFO *paths = calloc(path_count, sizeof(*paths));
+	DISPLAYCONFIG_MODE_INFO *modes = calloc(mode_count, sizeof(*modes));
+	float white = 1;
+	if (paths != NULL && modes != NULL &&
+		QueryDisplayConfig(QDC_ONLY_ACTIVE_PATHS, &path_count, paths, &mode_count, modes, NULL) == ERROR_SUCCESS) {
+		for (UINT32 i = 0; i < path_count; i++) {
+			DISPLAYCONFIG_SOURCE_DEVICE_NAME source = {0};
+			source.header.type = DISPLAYCONFIG_DEVICE_INFO_GET_SOURCE_NAME;
+			source.header.size = sizeof(source);
+			source.header.adapterId = paths[i].sourceInfo.adapterId;
+			source.header.id = paths[i].sourceInfo.id;
+			if (DisplayConfigGetDeviceInfo(&source.header) != ERROR_SUCCESS || wcscmp(source.viewGdiDeviceName, device) != 0) {
+				continue;
+			}
+			DISPLAYCONFIG_SDR_WHITE_LEVEL level = {0};
+			level.header.type = DISPLAYCONFIG_DEVICE_INFO_GET_SDR_WHITE_LEVEL;
+			level.header.size = sizeof(level);
+			level.header.adapterId = paths[i].targetInfo.adapterId;
+			level.header.id = paths[i].targetInfo.id;
+			if (DisplayConfigGetDeviceInfo(&level.header) == ERROR_SUCCESS && level.SDRWhiteLevel > 0) {
+				white = level.SDRWhiteLevel / 1000.0f;
+			}
+			break;
+		}
+	}
+	free(paths);
+	free(modes);
+	return white;
+}
+
+// dda_duplicate_hdr duplicates the output as scRGB if it is in HDR mode
+static HRESULT dda_duplicate_hdr(dda_capture *c) {
+	IDXGIOutput6 *output;
+	HRESULT hr = IDXGIOutput1_QueryInterface(c->output, &IID_IDXGIOutput6, (void **)&output);
+	if (FAILED(hr)) {
+		return hr;
+	}
+	DXGI_OUTPUT_DESC1 desc;
+	if (SUCCEEDED(hr = IDXGIOutput6_GetDesc1(output, &desc))) {
+		if (desc.ColorSpace != DXGI_COLOR_SPACE_RGB_FULL_G2084_NONE_P2020) {
+			hr = E_NOTIMPL;
+		} else {
+			DXGI_FORMAT formats[] = {DXGI_FORMAT_R16G16B16A16_FLOAT};
+			hr = IDXGIOutput6_DuplicateOutput1(output, (IUnknown *)c->device, 0, 1, formats, &c->duplication);
+		}
+	}
+	if (SUCCEEDED(hr)) {
+		c->hdr = 1;
+		c->white = dda_sdr_white(desc.DeviceName);
+		c->peak = fmaxf(desc.MaxLuminance / 80.0f / c->white, 1);
+	}
+	IDXGIOutput6_Release(output);
+	return hr;
+}
+
+// dda_duplicate starts duplicating the output, again after the duplication
+// was lost, e.g. to a mode change, a switch to the secure desktop or HDR
+// being turned on or off
+static HRESULT dda_duplicate(dda_capture *c) {
+	dda_release_duplication(c);
+	c->hdr = 0;
+	HRESULT hr = S_OK;
+	if (!c->tone_map || FAILED(dda_duplicate_hdr(c))) {
+		hr = IDXGIOutput1_DuplicateOutput(c->output, (IUnknown *)c->device, &c->duplication);
+	}
+	if (FAILED(hr)) {
+		return hr;
+	}
+
+	// Rotated desktops arrive unrotated, the screenshot package handles them
+	DXGI_OUTDUPL_DESC desc;
+	IDXGIOutputDuplication_GetDesc(c->duplication, &desc);
+	if (desc.Rotation != DXGI_MODE_ROTATION_IDENTITY && desc.Rotation != DXGI_MODE_ROTATION_UNSPECIFIED) {
+		dda_release_duplication(c);
+		return E_NOTIMPL;
+	}
+	c->width = desc.ModeDesc.Width;
+	c->height = desc.ModeDesc.Height;
+
+	D3D11_TEXTURE2D_DESC texture = {0};
+	texture.Width = c->width;
+	texture.Height = c->height;
+	texture.MipLevels = 1;
+	texture.ArraySize = 1;
+	texture.Format = c->hdr ? DXGI_FORMAT_R16G16B16A16_FLOAT : DXGI_FORMAT_B8G8R8A8_UNORM;
+	texture.SampleDesc.Count = 1;
+	texture.Usage = D3D11_USAGE_STAGING;
+	texture.CPUAccessFlags = D3D11_CPU_ACCESS_READ;
+	if (FAILED(hr = ID3D11Device_CreateTexture2D(c->device, &texture, NULL, &c->staging))) {
+		dda_release_duplication(c);
+		return hr;
+	}
+	c->full = 1;
+	return S_OK;
+}
+
+// dda_open starts duplicating the output whose desktop starts at x, y
+static HRESULT dda_open(dda_capture *c, int x, int y) {
+	dda_srgb_init();
+	IDXGIFactory1 *factory;
+	HRESULT hr = CreateDXGIFactory1(&IID_IDXGIFactory1, (void **)&factory);
+	if (FAILED(hr)) {
+		return hr;
+	}
+
+	IDXGIAdapter1 *adapter = NULL;
+	IDXGIOutput *output = NULL;
+	for (UINT i = 0; output == NULL && IDXGIFactory1_EnumAdapters1(factory, i, &adapter) == S_OK; i++) {
+		IDXGIOutput *candidate;
+		for (UINT j = 0; IDXGIAdapter1_EnumOutputs(adapter, j, &candidate) == S_OK; j++) {
+			DXGI_OUTPUT_DESC desc;
+			if (SUCCEEDED(IDXGIOutput_GetDesc(candidate, &desc)) && desc.AttachedToDesktop &&
+				desc.DesktopCoordinates.left == x && desc.DesktopCoordinates.top == y) {
+				output = candidate;
+				break;
+			}
+			IDXGIOutput_Release(candidate);
+		}
+		if (output == NULL) {
+			IDXGIAdapter1_Release(adapter);
+		}
+	}
+	IDXGIFactory1_Release(factory);
+	if (output == NULL) {
+		return DXGI_ERROR_NOT_FOUND;
+	}
+
+	// The device must be on the adapter the output is connected to
+	hr = D3D11CreateDevice((IDXGIAdapter *)adapter, D3D_DRIVER_TYPE_UNKNOWN, NULL, 0, NULL, 0,
+		D3D11_SDK_VERSION, &c->device, NULL, &c->context);
+	IDXGIAdapter1_Release(adapter);
+	if (SUCCEEDED(hr)) {
+		hr = IDXGIOutput_QueryInterface(output, &IID_IDXGIOutput1, (void **)&c->output);
+	}
+	IDXGIOutput_Release(output);
+	if (FAILED(hr)) {
+		return hr;
+	}
+	return dda_duplicate(c);
+}
+
+static void dda_close(dda_capture *c) {
+	dda_release_duplication(c);
+	if (c->output != NULL) {
+		IDXGIOutput1_Release(c->output);
+	}
+	if (c->context != NULL) {
+		ID3D11DeviceContext_Release(c->context);
+	}
+	if (c->device != NULL) {
+		ID3D11Device_Release(c->device);
+	}
+	free(c->metadata);
+	free(c->rects);
+	free(c);
+}
+
+// dda_changed_rects collects the rectangles a frame changed into c->rects:
+// the destinations of moved parts, whose pixels are already in place in
+// the desktop image, and the dirty ones. It returns how many there are.
+static UINT dda_changed_rects(dda_capture *c, DXGI_OUTDUPL_FRAME_INFO *info, HRESULT *hr) {
+	*hr = S_OK;
+	if (info->TotalMetadataBufferSize == 0) {
+		return 0;
+	}
+	if (info->TotalMetadataBufferSize > c->metadata_size) {
+		uint8_t *metadata = realloc(c->metadata, info->TotalMetadataBufferSize);
+		if (metadata == NULL) {
+			*hr = E_OUTOFMEMORY;
+			return 0;
+		}
+		c->metadata = metadata;
+		c->metadata_size = info->TotalMetadataBufferSize;
+	}
+
+	UINT move_size = 0, dirty_size = 0;
+	DXGI_OUTDUPL_MOVE_RECT *moves = (DXGI_OUTDUPL_MOVE_RECT *)c->metadata;
+	if (FAILED(*hr = IDXGIOutputDuplication_GetFrameMoveRects(c->duplication, c->metadata_size, moves, &move_size))) {
+		return 0;
+	}
+	RECT *dirty = (RECT *)(c->metadata + move_size);
+	if (FAILED(*hr = IDXGIOutputDuplication_GetFrameDirtyRects(c->duplication, c->metadata_size - move_size, dirty, &dirty_size))) {
+		return 0;
+	}
+
+	UINT move_count = move_size / sizeof(DXGI_OUTDUPL_MOVE_RECT);
+	UINT dirty_count = dirty_size / sizeof(RECT);
+	if (move_count + dirty_count > c->rects_size) {
+		RECT *rects = realloc(c->rects, (move_count + dirty_count) * sizeof(RECT));
+		if (rects == NULL) {
+			*hr = E_OUTOFMEMORY;
+			return 0;
+		}
+		c->rects = rects;
+		c->rects_size = move_count + dirty_count;
+	}
+	for (UINT i = 0; i < move_count; i++) {
+		c->rects[i] = moves[i].DestinationRect;
+	}
+	memcpy(c->rects + move_count, dirty, dirty_count * sizeof(RECT));
+	return move_count + dirty_count;
+}
+
+// dda_copy copies a rectangle of the mapped staging texture to an RGBA
+// buffer of the desktop's size
+static void dda_copy(dda_capture *c, D3D11_MAPPED_SUBRESOURCE *mapped, RECT r, uint8_t *pix) {
+	r.left = max(r.left, 0);
+	r.top = max(r.top, 0);
+	r.right = min(r.right, c->width);
+	r.bottom = min(r.bottom, c->height);
+	if (c->hdr) {
+		for (LONG y = r.top; y < r.bottom && r.left < r.right; y++) {
+			const uint8_t *src = (const uint8_t *)mapped->pData + y * mapped->RowPitch + r.left * 8;
+			dda_copy_hdr(c, (const uint16_t *)src, pix + ((size_t)y * c->width + r.left) * 4, r.right - r.left);
+		}
+		return;
+	}
+	for (LONG y = r.top; y < r.bottom; y++) {
+		const uint8_t *src = (const uint8_t *)mapped->pData + y * mapped->RowPitch + r.left * 4;
+		uint8_t *dst = pix + ((size_t)y * c->width + r.left) * 4;
+		for (LONG x = r.left; x < r.right; x++, src += 4, dst += 4) {
+			dst[0] = src[2];
+			dst[1] = src[1];
+			dst[2] = src[0];
+			dst[3] = 0xFF;
+		}
+	}
+}
+
+// dda_capture_frame copies what changed since the last call into pix, an
+// RGBA buffer of the desktop's size, and sets *changed if anything did. A
+// lost duplication is started again with the next call.
+static HRESULT dda_capture_frame(dda_capture *c, uint8_t *pix, int *changed) {
+	*changed = 0;
+	if (c->duplication == NULL) {
+		// The secure desktop, e.g. a UAC prompt, can't be duplicated until
+		// it is left, the last frame stays. The desktop may come back at
+		// another size, so the caller checks it before the next frame.
+		HRESULT hr = dda_duplicate(c);
+		return hr == E_ACCESSDENIED ? S_OK : hr;
+	}
+	DXGI_OUTDUPL_FRAME_INFO info;
+	IDXGIResource *resource;
+	HRESULT hr = IDXGIOutputDuplication_AcquireNextFrame(c->duplication, c->full ? dda_first_frame_ms : 0, &info, &resource);
+	if (hr == DXGI_ERROR_WAIT_TIMEOUT) {
+		return S_OK;
+	}
+	if (hr == DXGI_ERROR_ACCESS_LOST) {
+		dda_release_duplication(c);
+		return S_OK;
+	}
+	if (FAILED(hr)) {
+		return hr;
+	}
+
+	// Frames that only moved the pointer change nothing
+	UINT count = 0;
+	if (info.LastPresentTime.QuadPart != 0 && !c->full) {
+		count = dda_changed_rects(c, &info, &hr);
+	}
+	if (SUCCEEDED(hr) && (count > 0 || c->full)) {
+		ID3D11Texture2D *texture;
+		hr = IDXGIResource_QueryInterface(resource, &IID_ID3D11Texture2D, (void **)&texture);
+		if (SUCCEEDED(hr)) {
+			if (c->full) {
+				ID3D11DeviceContext_CopyResource(c->context, (ID3D11Resource *)c->staging, (ID3D11Resource *)texture);
+			} else {
+				for (UINT i = 0; i < count; i++) {
+					D3D11_BOX box = {c->rects[i].left, c->rects[i].top, 0, c->rects[i].right, c->rects[i].bottom, 1};
+					ID3D11DeviceContext_CopySubresourceRegion(c->context, (ID3D11Resource *)c->staging, 0,
+						c->rects[i].left, c->rects[i].top, 0, (ID3D11Resource *)texture, 0, &box);
+				}
+			}
+			ID3D11Texture2D_Release(texture);
+
+			D3D11_MAPPED_SUBRESOURCE mapped;
+			hr = ID3D11DeviceContext_Map(c->context, (ID3D11Resource *)c->staging, 0, D3D11_MAP_READ, 0, &mapped);
+			if (SUCCEEDED(hr)) {
+				if (c->full) {
+					RECT all = {0, 0, c->width, c->height};
+					dda_copy(c, &mapped, all, pix);
+				} else {
+					for (UINT i = 0; i < count; i++) {
+						dda_copy(c, &mapped, c->rects[i], pix);
+					}
+				}
+				ID3D11DeviceContext_Unmap(c->context, (ID3D11Resource *)c->staging, 0);
+				c->full = 0;
+				*changed = 1;
+			}
+		}
+	}
+	IDXGIResource_Release(resource);
+	IDXGIOutputDuplication_ReleaseFrame(c->duplication);
+	return hr;
+}
+
+// dda_set_tone_map switches tone mapping on or off, duplicating the output
+// again with the next frame if it changed
+static void dda_set_tone_map(dda_capture *c, int enabled) {
+	if (c->tone_map != enabled) {
+		c->tone_map = enabled;
+		dda_release_duplication(c);
+	}
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+func init() {
+	screenBackends = append(screenBackends, screenBackend{name: "Desktop Duplication", key: "dda", open: newDuplicationCapturer})
+}
+
+// duplicationCapturer captures a monitor with the DXGI Desktop Duplication
+// API, which reports the parts of the desktop each frame moved or redrew.
+// Only those are copied from the GPU, an idle desktop costs nothing.
+type duplicationCapturer struct {
+	session *C.dda_capture
+	frame   *image.RGBA
+}
+
+func newDuplicationCapturer(monitor protocol.MonitorInfo, _ bool) (screenCapturer, error) {
+	c := &duplicationCapturer{session: (*C.dda_capture)(C.calloc(1, C.sizeof_dda_capture))}
+	c.session.tone_map = 1
+	if hr := C.dda_open(c.session, C.int(int32(monitor.PositionX)), C.int(int32(monitor.PositionY))); hr < 0 {
+		c.close()
+		return nil, fmt.Errorf("HRESULT 0x%08X", uint32(hr))
+	}
+	return c, nil
+}
+
+func (c *duplicationCapturer) capture() (image.Image, bool, error) {
+	// The desktop is copied whole after a mode change
+	size := image.Pt(int(c.session.width), int(c.session.height))
+	if c.frame == nil || c.frame.Rect.Size() != size {
+		c.frame = image.NewRGBA(image.Rectangle{Max: size})
+		c.session.full = 1
+	}
+
+	var changed C.int
+	if hr := C.dda_capture_frame(c.session, (*C.uint8_t)(unsafe.Pointer(&c.frame.Pix[0])), &changed); hr < 0 {
+		return nil, false, fmt.Errorf("Desktop Duplication failed: HRESULT 0x%08X", uint32(hr))
+	}
+	if c.frame.Rect.Size() != image.Pt(int(c.session.width), int(c.session.height)) {
+		// The duplication was started again at another size
+		return c.capture()
+	}
+	return c.frame, changed != 0, nil
+}
+
+func (c *duplicationCapturer) setToneMapping(enabled bool) {
+	on := C.int(0)
+	if enabled {
+		on = 1
+	}
+	C.dda_set_tone_map(c.session, on)
+}
+
+func (c *duplicationCapturer) close() {
+	if c.session != nil {
+		C.dda_close(c.session)
+		c.session = nil
+	}
+}
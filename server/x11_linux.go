@@ -0,0 +1,588 @@
+//go:build cgo && x11
+
+package server
+
+/*
+#cgo pkg-config: x11 xext xdamage xfixes
+#include <stdlib.h>
+#include <stdint.h>
+#include <sys/ipc.h>
+#include <sys/shm.h>
+#include <X11/Xlib.h>
+#include <X11/Xutil.h>
+#include <X11/Xatom.h>
+#include <X11/extensions/XShm.h>
+#include <X11/extensions/Xdamage.h>
+#include <X11/extensions/Xfixes.h>
+
+// x11_max_rects is how many damaged rectangles are fetched one by one,
+// beyond it the whole monitor is
+#define x11_max_rects 32
+
+// x11_error is the last X error of the calling thread. Xlib's default
+// handler exits the process.
+static __thread int x11_error;
+
+static int x11_error_handler(Display *display, XErrorEvent *event) {
+	x11_error = event->error_code;
+	return 0;
+}
+
+// x11_capture captures a monitor, a rectangle of the root window, through
+// a shared memory image. Damage events tell which parts changed.
+typedef struct {
+	Display *display;
+	Window root;
+	Visual *visual;
+	int depth;
+	XImage *image;
+	XShmSegmentInfo shm;
+	int attached;
+	Damage damage;
+	int damage_event;
+	int x, y, width, height;
+	int full; // Fetch the whole monitor with the next frame
+} x11_capture;
+
+// x11_init makes Xlib usable from several threads and keeps X errors from
+// exiting the process. Not static, XTEST input calls it too.
+void x11_init(void) {
+	XInitThreads();
+	XSetErrorHandler(x11_error_handler);
+}
+
+static int x11_available(void) {
+	x11_init();
+	Display *display = XOpenDisplay(NULL);
+	if (display == NULL) {
+		return 0;
+	}
+	int event, error;
+	int ok = XShmQueryExtension(display) && XDamageQueryExtension(display, &event, &error) &&
+		XFixesQueryExtension(display, &event, &error);
+	XCloseDisplay(display);
+	return ok;
+}
+
+static void x11_close(x11_capture *c) {
+	if (c->damage != None) {
+		XDamageDestroy(c->display, c->damage);
+	}
+	if (c->attached) {
+		XShmDetach(c->display, &c->shm);
+	}
+	if (c->image != NULL) {
+		c->image->data = NULL;
+		XDestroyImage(c->image);
+	}
+	if (c->shm.shmaddr != NULL) {
+		shmdt(c->shm.shmaddr);
+	}
+	if (c->display != NULL) {
+		XCloseDisplay(c->display);
+	}
+	free(c);
+}
+
+// x11_open starts capturing the rectangle of the root window at x, y. It
+// returns an error message on failure.
+static const char *x11_open(x11_capture *c, int x, int y, int width, int height) {
+	c->display = XOpenDisplay(NULL);
+	if (c->display == NULL) {
+		return "can't open display";
+	}
+	int error, major = 2, minor = 0;
+	if (!XShmQueryExtension(c->display)) {
+		return "no MIT-SHM extension";
+	}
+	if (!XDamageQueryExtension(c->display, &c->damage_event, &error) || !XDamageQueryVersion(c->display, &major, &minor)) {
+		return "no DAMAGE extension";
+	}
+	major = 2;
+	if (!XFixesQueryExtension(c->display, &error, &error) || !XFixesQueryVersion(c->display, &major, &minor) || major < 2) {
+		return "no XFIXES 2 extension";
+	}
+
+	int screen = DefaultScreen(c->display);
+	c->root = RootWindow(c->display, screen);
+	c->visual = DefaultVisual(c->display, screen);
+	c->depth = DefaultDepth(c->display, screen);
+	c->image = XShmCreateImage(c->display, c->visual, c->depth, ZPixmap, NULL, &c->shm, width, height);
+	if (c->image == NULL) {
+		return "can't create shared image";
+	}
+	if (c->image->bits_per_pixel != 32 || c->image->red_mask != 0xFF0000 || c->image->blue_mask != 0xFF) {
+		return "unsupported pixel format";
+	}
+
+	c->shm.shmid = shmget(IPC_PRIVATE, (size_t)c->image->bytes_per_line * height, IPC_CREAT | 0600);
+	if (c->shm.shmid < 0) {
+		return "can't allocate shared memory";
+	}
+	void *addr = shmat(c->shm.shmid, NULL, 0);
+	if (addr == (void *)-1) {
+		shmctl(c->shm.shmid, IPC_RMID, NULL);
+		return "can't attach shared memory";
+	}
+	c->shm.shmaddr = c->image->data = addr;
+	c->shm.readOnly = False;
+	x11_error = 0;
+	c->attached = XShmAttach(c->display, &c->shm);
+	XSync(c->display, False);
+
+	// The segment goes away once both sides detached it
+	shmctl(c->shm.shmid, IPC_RMID, NULL);
+	if (!c->attached || x11_error != 0) {
+		c->attached = 0;
+		return "the X server can't attach shared memory, is it remote?";
+	}
+
+	c->damage = XDamageCreate(c->display, c->root, XDamageReportNonEmpty);
+	c->x = x;
+	c->y = y;
+	c->width = width;
+	c->height = height;
+	c->full = 1;
+	return NULL;
+}
+
+// x11_grab copies a rectangle of the monitor into RGBA pixels of the
+// monitor's size, through the start of the shared memory segment
+static int x11_grab(x11_capture *c, int x, int y, int width, int height, uint8_t *rgba) {
+	XImage *part = c->image;
+	if (width != c->width || height != c->height) {
+		part = XShmCreateImage(c->display, c->visual, c->depth, ZPixmap, c->shm.shmaddr, &c->shm, width, height);
+		if (part == NULL) {
+			return 0;
+		}
+	}
+	int ok = XShmGetImage(c->display, c->root, part, c->x + x, c->y + y, AllPlanes);
+	if (ok) {
+		for (int row = 0; row < height; row++) {
+			const uint8_t *src = (const uint8_t *)part->data + (size_t)row * part->bytes_per_line;
+			uint8_t *dst = rgba + ((size_t)(y + row) * c->width + x) * 4;
+			for (int col = 0; col < width; col++, src += 4, dst += 4) {
+				dst[0] = src[2];
+				dst[1] = src[1];
+				dst[2] = src[0];
+				dst[3] = 0xFF;
+			}
+		}
+	}
+	if (part != c->image) {
+		part->data = NULL;
+		XDestroyImage(part);
+	}
+	return ok;
+}
+
+// x11_capture_frame copies the parts of the monitor damaged since the last
+// call into RGBA pixels of its size. It returns 1 if any were, 0 if not and
+// the X error code negated on failure.
+static int x11_capture_frame(x11_capture *c, uint8_t *rgba) {
+	x11_error = 0;
+	int damaged = c->full;
+	while (XPending(c->display) > 0) {
+		XEvent event;
+		XNextEvent(c->display, &event);
+		if (event.type == c->damage_event + XDamageNotify) {
+			damaged = 1;
+		}
+	}
+	if (!damaged) {
+		return 0;
+	}
+
+	// Take the damage, new damage sends a new event
+	XserverRegion region = XFixesCreateRegion(c->display, NULL, 0);
+	XDamageSubtract(c->display, c->damage, None, region);
+	int count = 0;
+	XRectangle *rects = XFixesFetchRegion(c->display, region, &count);
+	XFixesDestroyRegion(c->display, region);
+
+	int changed = 0;
+	if (c->full || count > x11_max_rects) {
+		changed = x11_grab(c, 0, 0, c->width, c->height, rgba);
+		c->full = !changed;
+	} else {
+		for (int i = 0; i < count; i++) {
+			int x0 = rects[i].x > c->x ? rects[i].x : c->x;
+			int y0 = rects[i].y > c->y ? rects[i].y : c->y;
+			int x1 = rects[i].x + rects[i].width < c->x + c->width ? rects[i].x + rects[i].width : c->x + c->width;
+			int y1 = rects[i].y + rects[i].height < c->y + c->height ? rects[i].y + rects[i].height : c->y + c->height;
+			if (x0 >= x1 || y0 >= y1) {
+				continue;
+			}
+			changed |= x11_grab(c, x0 - c->x, y0 - c->y, x1 - x0, y1 - y0, rgba);
+		}
+	}
+	if (rects != NULL) {
+		XFree(rects);
+	}
+	return x11_error != 0 ? -x11_error : changed;
+}
+
+// x11_cursor reads the cursor, on its own connection. XFIXES reports when
+// its shape changes.
+typedef struct {
+	Display *display;
+	Window root;
+	int event_base;
+	int changed; // The shape changed since it was last fetched
+} x11_cursor;
+
+static const char *x11_cursor_open(x11_cursor *c) {
+	x11_init();
+	c->display = XOpenDisplay(NULL);
+	if (c->display == NULL) {
+		return "can't open display";
+	}
+	int error;
+	if (!XFixesQueryExtension(c->display, &c->event_base, &error)) {
+		return "no XFIXES extension";
+	}
+	c->root = DefaultRootWindow(c->display);
+	XFixesSelectCursorInput(c->display, c->root, XFixesDisplayCursorNotifyMask);
+	c->changed = 1;
+	return NULL;
+}
+
+static void x11_cursor_close(x11_cursor *c) {
+	if (c->display != NULL) {
+		XCloseDisplay(c->display);
+	}
+	free(c);
+}
+
+// x11_cursor_position reads where the cursor is on the root window. It
+// returns 1 if its shape changed since the last fetch, 0 if not and -1 on
+// failure.
+static int x11_cursor_position(x11_cursor *c, int *x, int *y) {
+	while (XPending(c->display) > 0) {
+		XEvent event;
+		XNextEvent(c->display, &event);
+		if (event.type == c->event_base + XFixesCursorNotify) {
+			c->changed = 1;
+		}
+	}
+	Window root, child;
+	int wx, wy;
+	unsigned int mask;
+	if (!XQueryPointer(c->display, c->root, &root, &child, x, y, &wx, &wy, &mask)) {
+		return -1; // On another screen
+	}
+	return c->changed;
+}
+
+// x11_cursor_image fetches the cursor's shape, freed with XFree
+static XFixesCursorImage *x11_cursor_image(x11_cursor *c) {
+	c->changed = 0;
+	return XFixesGetCursorImage(c->display);
+}
+// x11_client_windows returns the application windows the window manager
+// lists, frontmost last; freed with XFree
+static Window *x11_client_windows(Display *display, unsigned long *count) {
+	Atom property = XInternAtom(display, "_NET_CLIENT_LIST_STACKING", False), type;
+	int format;
+	unsigned long remaining;
+	unsigned char *data = NULL;
+	*count = 0;
+	if (XGetWindowProperty(display, DefaultRootWindow(display), property, 0, 4096, False, XA_WINDOW,
+		&type, &format, count, &remaining, &data) != Success || type != XA_WINDOW) {
+		*count = 0;
+		return NULL;
+	}
+	return (Window *)data;
+}
+
+// x11_window_title returns a window's title, freed with XFree
+static char *x11_window_title(Display *display, Window window) {
+	Atom name = XInternAtom(display, "_NET_WM_NAME", False), utf8 = XInternAtom(display, "UTF8_STRING", False), type;
+	int format;
+	unsigned long count, remaining;
+	unsigned char *data = NULL;
+	if (XGetWindowProperty(display, window, name, 0, 1024, False, utf8, &type, &format, &count, &remaining, &data) == Success && data != NULL) {
+		if (type == utf8 && format == 8) {
+			return (char *)data;
+		}
+		XFree(data);
+	}
+	char *title = NULL;
+	XFetchName(display, window, &title);
+	return title;
+}
+
+static int x11_window_pid(Display *display, Window window) {
+	Atom property = XInternAtom(display, "_NET_WM_PID", False), type;
+	int format, pid = 0;
+	unsigned long count, remaining;
+	unsigned char *data = NULL;
+	if (XGetWindowProperty(display, window, property, 0, 1, False, XA_CARDINAL, &type, &format, &count, &remaining, &data) == Success && data != NULL) {
+		if (type == XA_CARDINAL && format == 32 && count == 1) {
+			pid = (int)*(unsigned long *)data;
+		}
+		XFree(data);
+	}
+	return pid;
+}
+
+// x11_window_bounds returns where a window is on the root window, 0 if it
+// isn't shown
+static int x11_window_bounds(Display *display, Window window, int *x, int *y, int *width, int *height) {
+	x11_error = 0;
+	XWindowAttributes attributes;
+	Window child;
+	if (!XGetWindowAttributes(display, window, &attributes) || attributes.map_state != IsViewable) {
+		return 0;
+	}
+	if (!XTranslateCoordinates(display, window, attributes.root, 0, 0, x, y, &child)) {
+		return 0;
+	}
+	*width = attributes.width;
+	*height = attributes.height;
+	return x11_error == 0;
+}
+
+// x11_window_grab copies the part of a window on the screen into RGBA
+// pixels of width x height. Parts covered by other windows show those
+// unless a compositor keeps the window's content. It returns 0 if the
+// window is gone or hidden.
+static int x11_window_grab(Display *display, Window window, uint8_t *rgba, int width, int height) {
+	int x, y, w, h;
+	if (!x11_window_bounds(display, window, &x, &y, &w, &h)) {
+		return 0;
+	}
+	Screen *screen = DefaultScreenOfDisplay(display);
+	int x0 = x < 0 ? -x : 0, y0 = y < 0 ? -y : 0;
+	int x1 = x + w > WidthOfScreen(screen) ? WidthOfScreen(screen) - x : w;
+	int y1 = y + h > HeightOfScreen(screen) ? HeightOfScreen(screen) - y : h;
+	if (x1 > width) {
+		x1 = width;
+	}
+	if (y1 > height) {
+		y1 = height;
+	}
+	if (x0 >= x1 || y0 >= y1) {
+		return 1;
+	}
+	XImage *image = XGetImage(display, window, x0, y0, x1 - x0, y1 - y0, AllPlanes, ZPixmap);
+	if (image == NULL) {
+		return 0;
+	}
+	if (image->bits_per_pixel == 32) {
+		for (int row = 0; row < y1 - y0; row++) {
+			const uint8_t *src = (const uint8_t *)image->data + (size_t)row * image->bytes_per_line;
+			uint8_t *dst = rgba + ((size_t)(y0 + row) * width + x0) * 4;
+			for (int col = 0; col < x1 - x0; col++, src += 4, dst += 4) {
+				dst[0] = src[2];
+				dst[1] = src[1];
+				dst[2] = src[0];
+				dst[3] = 0xFF;
+			}
+		}
+	}
+	XDestroyImage(image);
+	return 1;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+func init() {
+	screenBackends = append(screenBackends, screenBackend{name: "X11 MIT-SHM", key: "x11", available: x11Available, open: newX11Capturer})
+	readCursor = readX11Cursor
+	listWindows = listX11Windows
+	openWindow = openX11Window
+}
+
+// x11Available reports whether the X server supports shared memory images
+// and damage tracking. Under Wayland an X server only shows X11 windows,
+// those sessions need the ScreenCast portal.
+func x11Available() bool {
+	if os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("DISPLAY") == "" {
+		return false
+	}
+	return C.x11_available() != 0
+}
+
+// x11Capturer captures a monitor from the X server through shared memory.
+// The DAMAGE extension reports which parts of the screen were drawn to,
+// only those are fetched; an idle monitor costs a check for events.
+type x11Capturer struct {
+	session *C.x11_capture
+	frame   *image.RGBA
+}
+
+func newX11Capturer(monitor protocol.MonitorInfo, _ bool) (screenCapturer, error) {
+	c := &x11Capturer{
+		session: (*C.x11_capture)(C.calloc(1, C.sizeof_x11_capture)),
+		frame:   image.NewRGBA(image.Rect(0, 0, int(monitor.Width), int(monitor.Height))),
+	}
+	if message := C.x11_open(c.session, C.int(int32(monitor.PositionX)), C.int(int32(monitor.PositionY)),
+		C.int(monitor.Width), C.int(monitor.Height)); message != nil {
+		c.close()
+		return nil, errors.New(C.GoString(message))
+	}
+	return c, nil
+}
+
+func (c *x11Capturer) capture() (image.Image, bool, error) {
+	status := C.x11_capture_frame(c.session, (*C.uint8_t)(unsafe.Pointer(&c.frame.Pix[0])))
+	if status < 0 {
+		return nil, false, fmt.Errorf("X error %d", -status)
+	}
+	return c.frame, status > 0, nil
+}
+
+func (c *x11Capturer) close() {
+	if c.session != nil {
+		C.x11_close(c.session)
+		c.session = nil
+	}
+}
+
+var x11CursorSession struct {
+	once    sync.Once
+	session *C.x11_cursor
+	err     error
+}
+
+// readX11Cursor reads the cursor, which X servers leave out of images of
+// the screen
+func readX11Cursor(last *cursorShape) (cursorState, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("DISPLAY") == "" {
+		return cursorState{}, errors.New("not an X11 session")
+	}
+	x11CursorSession.once.Do(func() {
+		session := (*C.x11_cursor)(C.calloc(1, C.sizeof_x11_cursor))
+		if message := C.x11_cursor_open(session); message != nil {
+			C.x11_cursor_close(session)
+			x11CursorSession.err = errors.New(C.GoString(message))
+			return
+		}
+		x11CursorSession.session = session
+	})
+	if x11CursorSession.err != nil {
+		return cursorState{}, x11CursorSession.err
+	}
+	session := x11CursorSession.session
+
+	var x, y C.int
+	status := C.x11_cursor_position(session, &x, &y)
+	if status < 0 {
+		return cursorState{}, nil
+	}
+	state := cursorState{position: image.Pt(int(x), int(y)), visible: true, shape: last}
+	if status == 0 && last != nil {
+		return state, nil
+	}
+	cursor := C.x11_cursor_image(session)
+	if cursor == nil {
+		return cursorState{}, errors.New("can't fetch the cursor image")
+	}
+	defer C.XFree(unsafe.Pointer(cursor))
+
+	// Pixels are premultiplied ARGB, in the low 32 bits of longs
+	width, height := int(cursor.width), int(cursor.height)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width > 0 && height > 0 {
+		pixels := unsafe.Slice((*C.ulong)(unsafe.Pointer(cursor.pixels)), width*height)
+		for i, p := range pixels {
+			img.Pix[i*4] = uint8(p >> 16)
+			img.Pix[i*4+1] = uint8(p >> 8)
+			img.Pix[i*4+2] = uint8(p)
+			img.Pix[i*4+3] = uint8(p >> 24)
+		}
+	}
+	state.shape = newCursorShape(img, image.Pt(int(cursor.xhot), int(cursor.yhot)))
+	if last != nil && last.id == state.shape.id {
+		state.shape = last
+	}
+	return state, nil
+}
+
+// listX11Windows lists the windows of an X11 session through the window
+// manager's client list
+func listX11Windows() ([]appWindow, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("DISPLAY") == "" {
+		return nil, errors.New("not an X11 session")
+	}
+	C.x11_init()
+	display := C.XOpenDisplay(nil)
+	if display == nil {
+		return nil, errors.New("can't open display")
+	}
+	defer C.XCloseDisplay(display)
+
+	var count C.ulong
+	list := C.x11_client_windows(display, &count)
+	if list == nil {
+		return nil, errors.New("the window manager doesn't list windows")
+	}
+	defer C.XFree(unsafe.Pointer(list))
+	ids := unsafe.Slice(list, int(count))
+
+	var windows []appWindow
+	for i := len(ids) - 1; i >= 0; i-- {
+		var x, y, width, height C.int
+		if C.x11_window_bounds(display, ids[i], &x, &y, &width, &height) == 0 {
+			continue
+		}
+		window := appWindow{
+			id:     uintptr(ids[i]),
+			pid:    int(C.x11_window_pid(display, ids[i])),
+			bounds: image.Rect(int(x), int(y), int(x+width), int(y+height)),
+		}
+		if title := C.x11_window_title(display, ids[i]); title != nil {
+			window.title = C.GoString(title)
+			C.XFree(unsafe.Pointer(title))
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// x11Window captures a window with XGetImage, on its own connection
+type x11Window struct {
+	display *C.Display
+	window  C.Window
+	frame   *image.RGBA
+}
+
+func openX11Window(window appWindow) (windowCapturer, error) {
+	C.x11_init()
+	display := C.XOpenDisplay(nil)
+	if display == nil {
+		return nil, errors.New("can't open display")
+	}
+	return &x11Window{display: display, window: C.Window(window.id)}, nil
+}
+
+func (w *x11Window) capture() (*image.RGBA, error) {
+	var x, y, width, height C.int
+	if C.x11_window_bounds(w.display, w.window, &x, &y, &width, &height) == 0 {
+		return nil, errors.New("window was closed or hidden")
+	}
+	rect := image.Rect(0, 0, int(width), int(height))
+	if w.frame == nil || w.frame.Rect != rect {
+		w.frame = image.NewRGBA(rect)
+	}
+	if C.x11_window_grab(w.display, w.window, (*C.uint8_t)(unsafe.Pointer(&w.frame.Pix[0])), width, height) == 0 {
+		return nil, errors.New("window was closed or hidden")
+	}
+	return w.frame, nil
+}
+
+func (w *x11Window) close() {
+	C.XCloseDisplay(w.display)
+}
@@ -0,0 +1,168 @@
+//go:build cgo && x11
+
+package server
+
+/*
+#cgo pkg-config: x11 xtst
+#include <X11/Xlib.h>
+#include <X11/extensions/XTest.h>
+
+void x11_init(void);
+
+// xtest_open opens a connection to inject input with. It returns NULL if
+// the X server lacks the XTEST extension.
+static Display *xtest_open(void) {
+	x11_init();
+	Display *display = XOpenDisplay(NULL);
+	if (display == NULL) {
+		return NULL;
+	}
+	int event, error, major, minor;
+	if (!XTestQueryExtension(display, &event, &error, &major, &minor)) {
+		XCloseDisplay(display);
+		return NULL;
+	}
+	// Injected input isn't held back by grabs of other clients
+	XTestGrabControl(display, True);
+	return display;
+}
+
+static int xtest_key(Display *display, unsigned int keycode, int down) {
+	int ok = XTestFakeKeyEvent(display, keycode, down, CurrentTime);
+	XFlush(display);
+	return ok;
+}
+
+// xtest_move moves the pointer on the root window of the default screen
+static int xtest_move(Display *display, int x, int y) {
+	int ok = XTestFakeMotionEvent(display, DefaultScreen(display), x, y, CurrentTime);
+	XFlush(display);
+	return ok;
+}
+
+// xtest_button moves the pointer, then presses or releases a button, or
+// clicks it clicks times for the wheel's buttons
+static int xtest_button(Display *display, int x, int y, unsigned int button, int down, int clicks) {
+	int ok = XTestFakeMotionEvent(display, DefaultScreen(display), x, y, CurrentTime);
+	if (clicks == 0) {
+		ok = ok && XTestFakeButtonEvent(display, button, down, CurrentTime);
+	}
+	for (int i = 0; i < clicks && ok; i++) {
+		ok = XTestFakeButtonEvent(display, button, True, CurrentTime) &&
+			XTestFakeButtonEvent(display, button, False, CurrentTime);
+	}
+	XFlush(display);
+	return ok;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"sync"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+// X11 pointer buttons, 4 to 7 scroll up, down, left and right a notch
+var xtestButtons = map[protocol.MouseButton]C.uint{
+	protocol.MouseLeft:    1,
+	protocol.MouseMiddle:  2,
+	protocol.MouseRight:   3,
+	protocol.MouseBack:    8,
+	protocol.MouseForward: 9,
+}
+
+func init() {
+	openInputBackend = openXTest
+}
+
+// xtestInput injects input into an X11 session with the XTEST extension,
+// on its own connection
+type xtestInput struct {
+	mutex   sync.Mutex
+	display *C.Display
+	// Scrolls smaller than a notch add up until they make one, X11 only
+	// knows whole notches
+	wheelX, wheelY int
+}
+
+func openXTest() (inputBackend, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" || os.Getenv("DISPLAY") == "" {
+		return nil, errors.New("not an X11 session, input is only injected into X11 sessions")
+	}
+	display := C.xtest_open()
+	if display == nil {
+		return nil, errors.New("can't open display or no XTEST extension")
+	}
+	return &xtestInput{display: display}, nil
+}
+
+// key presses or releases a key. X servers using the evdev keymap, the
+// default on Linux, number keys by their input event code plus 8.
+func (x *xtestInput) key(code uint16, down bool) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if C.xtest_key(x.display, C.uint(code)+8, cBool(down)) == 0 {
+		return fmt.Errorf("can't inject key %d", code)
+	}
+	return nil
+}
+
+func (x *xtestInput) mouseMove(position image.Point) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if C.xtest_move(x.display, C.int(position.X), C.int(position.Y)) == 0 {
+		return errors.New("can't inject mouse move")
+	}
+	return nil
+}
+
+func (x *xtestInput) mouseButton(position image.Point, button protocol.MouseButton, down bool) error {
+	code, ok := xtestButtons[button]
+	if !ok {
+		return fmt.Errorf("unknown mouse button %d", button)
+	}
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if C.xtest_button(x.display, C.int(position.X), C.int(position.Y), code, cBool(down), 0) == 0 {
+		return fmt.Errorf("can't inject mouse button %d", button)
+	}
+	return nil
+}
+
+// mouseWheel scrolls by clicking the wheel's buttons once per notch
+func (x *xtestInput) mouseWheel(position image.Point, dx, dy int) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.wheelX += dx
+	x.wheelY += dy
+	for _, axis := range []struct {
+		notches            *int
+		positive, negative C.uint
+	}{{&x.wheelY, 4, 5}, {&x.wheelX, 7, 6}} {
+		clicks := *axis.notches / 120
+		if clicks == 0 {
+			continue
+		}
+		*axis.notches -= clicks * 120
+		button := axis.positive
+		if clicks < 0 {
+			button, clicks = axis.negative, -clicks
+		}
+		if C.xtest_button(x.display, C.int(position.X), C.int(position.Y), button, 0, C.int(clicks)) == 0 {
+			return errors.New("can't inject mouse wheel")
+		}
+	}
+	return nil
+}
+
+func cBool(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Stdout is the JSON output path that writes to standard output
+const Stdout = "stdout"
+
+// stdout is the standard output JSON snapshots are written to, see
+// ReserveStdout
+var stdout = os.Stdout
+
+// ReserveStdout keeps standard output for JSON snapshots, so it carries
+// nothing else: logs and messages written to os.Stdout from then on go to
+// standard error. It must be called before the output is used.
+func ReserveStdout() {
+	stdout = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// OpenJSON opens the destination for JSON snapshots: Stdout or a file path,
+// which is appended to
+func OpenJSON(path string) (io.WriteCloser, error) {
+	if path == Stdout {
+		return nopCloser{stdout}, nil
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// EmitJSON writes a snapshot as one JSON line every interval until stop is
+// closed, then writes a final snapshot and closes w
+func (c *Collector) EmitJSON(w io.WriteCloser, interval time.Duration, stop <-chan struct{}) {
+	defer w.Close()
+
+	encoder := json.NewEncoder(w)
+	previous := c.Snapshot()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stopping := false
+		select {
+		case <-stop:
+			stopping = true
+		case <-ticker.C:
+		}
+
+		snapshot := c.Snapshot()
+		snapshot.SetRates(&previous)
+		if err := encoder.Encode(snapshot); err != nil {
+			log.Printf("Error writing stats: %v", err)
+			return
+		}
+		previous = snapshot
+
+		if stopping {
+			return
+		}
+	}
+}
+
+// nopCloser keeps standard output open when the emitter finishes
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,242 @@
+// Package stats collects protocol statistics for the client and server and
+// exports them as JSON snapshots
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/moderniselife/ultrardp/bandwidth"
+)
+
+// Collector accumulates protocol statistics. All methods are safe for
+// concurrent use and do nothing on a nil collector.
+type Collector struct {
+	mutex   sync.Mutex
+	role    string
+	started time.Time
+
+	packetsSent     uint64
+	bytesSent       uint64
+	packetsReceived uint64
+	bytesReceived   uint64
+	framesDropped   uint64
+	clients         int
+	rtt             time.Duration
+	monitors        map[uint32]*monitorCounters
+	bandwidth       map[string]bandwidth.State // Peer -> scheduler state
+}
+
+// monitorCounters counts the video frames of one server monitor
+type monitorCounters struct {
+	frames    uint64
+	bytes     uint64
+	keyframes uint64
+}
+
+// Snapshot is the state of a collector at one point in time
+type Snapshot struct {
+	Time            time.Time         `json:"time"`
+	Role            string            `json:"role"`
+	UptimeSeconds   float64           `json:"uptime_seconds"`
+	PacketsSent     uint64            `json:"packets_sent"`
+	BytesSent       uint64            `json:"bytes_sent"`
+	PacketsReceived uint64            `json:"packets_received"`
+	BytesReceived   uint64            `json:"bytes_received"`
+	FramesDropped   uint64            `json:"frames_dropped"`
+	Clients         int               `json:"clients,omitempty"`
+	RTTMillis       float64           `json:"rtt_ms,omitempty"`
+	Monitors        []MonitorSnapshot `json:"monitors"`
+
+	Bandwidth map[string]bandwidth.State `json:"bandwidth,omitempty"` // Per peer
+}
+
+// MonitorSnapshot holds the video statistics of one server monitor. Rates
+// are computed over the interval since the previous snapshot.
+type MonitorSnapshot struct {
+	ID          uint32  `json:"id"`
+	Frames      uint64  `json:"frames"`
+	Bytes       uint64  `json:"bytes"`
+	Keyframes   uint64  `json:"keyframes"` // Frames that need no earlier ones, see Keyframe
+	FPS         float64 `json:"fps"`
+	BitrateKbps float64 `json:"bitrate_kbps"`
+}
+
+// New creates a collector for the given role ("client" or "server")
+func New(role string) *Collector {
+	return &Collector{
+		role:      role,
+		started:   time.Now(),
+		monitors:  make(map[uint32]*monitorCounters),
+		bandwidth: make(map[string]bandwidth.State),
+	}
+}
+
+// PacketSent counts size bytes written to the peer
+func (c *Collector) PacketSent(size int) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.packetsSent++
+	c.bytesSent += uint64(size)
+	c.mutex.Unlock()
+}
+
+// PacketReceived counts size bytes read from the peer
+func (c *Collector) PacketReceived(size int) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.packetsReceived++
+	c.bytesReceived += uint64(size)
+	c.mutex.Unlock()
+}
+
+// Frame counts a video frame sent or received for a server monitor
+func (c *Collector) Frame(monitorID uint32, size int) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	counters := c.monitor(monitorID)
+	counters.frames++
+	counters.bytes += uint64(size)
+	c.mutex.Unlock()
+}
+
+// Keyframe counts a frame of a server monitor, also counted with Frame,
+// that can be shown without earlier ones
+func (c *Collector) Keyframe(monitorID uint32) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.monitor(monitorID).keyframes++
+	c.mutex.Unlock()
+}
+
+// monitor returns the counters of a server monitor. The mutex must be held.
+func (c *Collector) monitor(monitorID uint32) *monitorCounters {
+	counters, ok := c.monitors[monitorID]
+	if !ok {
+		counters = &monitorCounters{}
+		c.monitors[monitorID] = counters
+	}
+	return counters
+}
+
+// FramesDropped counts video frames lost in transit
+func (c *Collector) FramesDropped(n int) {
+	if c == nil || n <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	c.framesDropped += uint64(n)
+	c.mutex.Unlock()
+}
+
+// SetClients records the number of connected clients
+func (c *Collector) SetClients(n int) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.clients = n
+	c.mutex.Unlock()
+}
+
+// SetRTT records the latest measured round trip time
+func (c *Collector) SetRTT(rtt time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.rtt = rtt
+	c.mutex.Unlock()
+}
+
+// SetBandwidth records the bandwidth scheduler state of a peer
+func (c *Collector) SetBandwidth(peer string, state bandwidth.State) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	c.bandwidth[peer] = state
+	c.mutex.Unlock()
+}
+
+// ClearBandwidth forgets the scheduler state of a disconnected peer
+func (c *Collector) ClearBandwidth(peer string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	delete(c.bandwidth, peer)
+	c.mutex.Unlock()
+}
+
+// Snapshot returns the current totals. Rates are left at zero, see SetRates.
+func (c *Collector) Snapshot() Snapshot {
+	if c == nil {
+		return Snapshot{Time: time.Now()}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	snapshot := Snapshot{
+		Time:            now,
+		Role:            c.role,
+		UptimeSeconds:   now.Sub(c.started).Seconds(),
+		PacketsSent:     c.packetsSent,
+		BytesSent:       c.bytesSent,
+		PacketsReceived: c.packetsReceived,
+		BytesReceived:   c.bytesReceived,
+		FramesDropped:   c.framesDropped,
+		Clients:         c.clients,
+		RTTMillis:       float64(c.rtt) / float64(time.Millisecond),
+		Monitors:        make([]MonitorSnapshot, 0, len(c.monitors)),
+	}
+	for id, counters := range c.monitors {
+		snapshot.Monitors = append(snapshot.Monitors, MonitorSnapshot{
+			ID:        id,
+			Frames:    counters.frames,
+			Bytes:     counters.bytes,
+			Keyframes: counters.keyframes,
+		})
+	}
+	sort.Slice(snapshot.Monitors, func(i, j int) bool { return snapshot.Monitors[i].ID < snapshot.Monitors[j].ID })
+	if len(c.bandwidth) > 0 {
+		snapshot.Bandwidth = make(map[string]bandwidth.State, len(c.bandwidth))
+		for peer, state := range c.bandwidth {
+			snapshot.Bandwidth[peer] = state
+		}
+	}
+
+	return snapshot
+}
+
+// SetRates fills in the per-monitor rates relative to an earlier snapshot
+func (s *Snapshot) SetRates(previous *Snapshot) {
+	elapsed := s.Time.Sub(previous.Time).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	for i := range s.Monitors {
+		monitor := &s.Monitors[i]
+		var frames, bytes uint64
+		for _, old := range previous.Monitors {
+			if old.ID == monitor.ID {
+				frames, bytes = old.Frames, old.Bytes
+				break
+			}
+		}
+		monitor.FPS = float64(monitor.Frames-frames) / elapsed
+		monitor.BitrateKbps = float64(monitor.Bytes-bytes) * 8 / 1000 / elapsed
+	}
+}
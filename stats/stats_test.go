@@ -0,0 +1,94 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRates(t *testing.T) {
+	c := New("server")
+	c.Frame(1, 1000)
+	previous := c.Snapshot()
+
+	for i := 0; i < 10; i++ {
+		c.Frame(1, 1000)
+	}
+	c.Frame(2, 500)
+	c.Keyframe(2)
+
+	snapshot := c.Snapshot()
+	snapshot.Time = previous.Time.Add(2 * time.Second)
+	snapshot.SetRates(&previous)
+
+	if len(snapshot.Monitors) != 2 {
+		t.Fatalf("got %d monitors, want 2", len(snapshot.Monitors))
+	}
+	if m := snapshot.Monitors[0]; m.ID != 1 || m.Frames != 11 || m.FPS != 5 || m.BitrateKbps != 40 {
+		t.Errorf("monitor 1 = %+v, want 11 frames at 5 fps and 40 kbps", m)
+	}
+	if m := snapshot.Monitors[1]; m.ID != 2 || m.FPS != 0.5 || m.Keyframes != 1 {
+		t.Errorf("monitor 2 = %+v, want 0.5 fps and a keyframe", m)
+	}
+}
+
+func TestNilCollector(t *testing.T) {
+	var c *Collector
+	c.PacketSent(10)
+	c.Frame(1, 10)
+	if snapshot := c.Snapshot(); snapshot.PacketsSent != 0 {
+		t.Errorf("nil collector counted packets")
+	}
+}
+
+func TestReserveStdoutCarriesOnlyJSON(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer r.Close()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	savedStdout, savedStderr, savedJSON := os.Stdout, os.Stderr, stdout
+	defer func() {
+		os.Stdout, os.Stderr, stdout = savedStdout, savedStderr, savedJSON
+	}()
+	os.Stdout, os.Stderr = w, devNull
+
+	ReserveStdout()
+	logger := log.New(os.Stdout, "UltraRDP: ", log.LstdFlags)
+	logger.Println("Starting")
+	fmt.Println("Starting display loop")
+
+	output, err := OpenJSON(Stdout)
+	if err != nil {
+		t.Fatalf("OpenJSON: %v", err)
+	}
+	c := New("client")
+	c.Frame(1, 1000)
+	stop := make(chan struct{})
+	close(stop)
+	c.EmitJSON(output, time.Hour, stop)
+	logger.Println("Stopped")
+	w.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if !json.Valid(scanner.Bytes()) {
+			t.Errorf("Standard output has a line that isn't JSON: %q", scanner.Text())
+		}
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("Standard output has %d lines, want the 1 snapshot", lines)
+	}
+}
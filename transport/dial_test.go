@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialAddressesSkipsUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// 192.0.2.1 is reserved for documentation and never answers
+	start := time.Now()
+	conn, err := dialAddresses("tcp", []string{"192.0.2.1:9", listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if conn.RemoteAddr().String() != listener.Addr().String() {
+		t.Errorf("connected to %s, want %s", conn.RemoteAddr(), listener.Addr())
+	}
+	if elapsed := time.Since(start); elapsed >= attemptTimeout {
+		t.Errorf("dial took %v, the unreachable address wasn't raced", elapsed)
+	}
+}
+
+func TestDialAddressesAllFail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	if _, err := dialAddresses("tcp", []string{address, address}); err == nil {
+		t.Error("dial to closed ports succeeded")
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("fd00::1")},
+	}
+	want := []string{"fd00::1", "10.0.0.1", "10.0.0.2"}
+
+	got := interleaveFamilies(ips)
+	if len(got) != len(want) {
+		t.Fatalf("got %d addresses, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].IP.String() != want[i] {
+			t.Errorf("address %d = %s, want %s", i, got[i].IP, want[i])
+		}
+	}
+}
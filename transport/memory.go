@@ -0,0 +1,254 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memoryBufferSize bounds the bytes in flight per direction, like a socket
+// buffer, so a peer that stops reading eventually blocks the writer
+const memoryBufferSize = 4 << 20
+
+var (
+	memoryListeners      = make(map[string]*memoryListener)
+	memoryListenersMutex sync.Mutex
+	memoryDials          atomic.Uint64
+)
+
+// memoryListener accepts in-process connections, used to run clients and
+// servers against each other in tests without touching the network
+type memoryListener struct {
+	address string
+	conns   chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+
+	mutex  sync.Mutex
+	active map[*memoryConn]struct{} // Server side of open connections
+}
+
+// memoryAddr is the address of an in-process endpoint
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return Memory }
+func (a memoryAddr) String() string  { return string(a) }
+
+// listenMemory registers an in-process listener under address
+func listenMemory(address string) (net.Listener, error) {
+	memoryListenersMutex.Lock()
+	defer memoryListenersMutex.Unlock()
+
+	if _, ok := memoryListeners[address]; ok {
+		return nil, fmt.Errorf("memory address %q already in use", address)
+	}
+	l := &memoryListener{
+		address: address,
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+		active:  make(map[*memoryConn]struct{}),
+	}
+	memoryListeners[address] = l
+	return l, nil
+}
+
+// dialMemory connects to an in-process listener
+func dialMemory(address string) (net.Conn, error) {
+	memoryListenersMutex.Lock()
+	l, ok := memoryListeners[address]
+	memoryListenersMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial memory %s: connection refused", address)
+	}
+
+	// Every client gets its own address, servers identify clients by it
+	name := memoryAddr(fmt.Sprintf("%s-client-%d", address, memoryDials.Add(1)))
+	toServer, toClient := newMemoryPipe(), newMemoryPipe()
+	client := &memoryConn{read: toClient, write: toServer, local: name, remote: memoryAddr(address)}
+	server := &memoryConn{read: toServer, write: toClient, local: memoryAddr(address), remote: name}
+	server.listener = l
+
+	select {
+	case l.conns <- server:
+	case <-l.closed:
+		return nil, fmt.Errorf("dial memory %s: connection refused", address)
+	}
+	l.mutex.Lock()
+	l.active[server] = struct{}{}
+	l.mutex.Unlock()
+	return client, nil
+}
+
+// DropMemoryLinks closes every open connection of the in-process listener
+// at address, as if the network between the peers had failed. It returns
+// the number of connections closed.
+func DropMemoryLinks(address string) int {
+	memoryListenersMutex.Lock()
+	l, ok := memoryListeners[address]
+	memoryListenersMutex.Unlock()
+	if !ok {
+		return 0
+	}
+
+	l.mutex.Lock()
+	conns := make([]*memoryConn, 0, len(l.active))
+	for conn := range l.active {
+		conns = append(conns, conn)
+	}
+	l.mutex.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close unregisters the listener, established connections stay open
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		memoryListenersMutex.Lock()
+		delete(memoryListeners, l.address)
+		memoryListenersMutex.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr { return memoryAddr(l.address) }
+
+// memoryPipe is one direction of an in-process connection
+type memoryPipe struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	closed   bool
+	deadline time.Time // Read deadline
+	timer    *time.Timer
+}
+
+func newMemoryPipe() *memoryPipe {
+	p := &memoryPipe{}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *memoryPipe) read(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for p.buf.Len() == 0 {
+		if p.closed {
+			return 0, net.ErrClosed
+		}
+		if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.cond.Wait()
+	}
+	n, _ := p.buf.Read(b)
+	p.cond.Broadcast()
+	return n, nil
+}
+
+func (p *memoryPipe) write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		for p.buf.Len() >= memoryBufferSize && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			return written, net.ErrClosed
+		}
+		n := memoryBufferSize - p.buf.Len()
+		if n > len(b) {
+			n = len(b)
+		}
+		p.buf.Write(b[:n])
+		written += n
+		b = b[n:]
+		p.cond.Broadcast()
+	}
+	return written, nil
+}
+
+func (p *memoryPipe) close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+// setDeadline wakes blocked readers when the deadline passes
+func (p *memoryPipe) setDeadline(t time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.deadline = t
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if !t.IsZero() {
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.mutex.Lock()
+			p.cond.Broadcast()
+			p.mutex.Unlock()
+		})
+	}
+	p.cond.Broadcast()
+}
+
+// memoryConn is one end of an in-process connection
+type memoryConn struct {
+	read, write   *memoryPipe
+	local, remote memoryAddr
+	listener      *memoryListener // Set on the server end
+	once          sync.Once
+}
+
+func (c *memoryConn) Read(b []byte) (int, error)  { return c.read.read(b) }
+func (c *memoryConn) Write(b []byte) (int, error) { return c.write.write(b) }
+
+// Close closes both directions, the peer sees the connection fail
+func (c *memoryConn) Close() error {
+	c.once.Do(func() {
+		c.read.close()
+		c.write.close()
+		if c.listener != nil {
+			c.listener.mutex.Lock()
+			delete(c.listener.active, c)
+			c.listener.mutex.Unlock()
+		}
+	})
+	return nil
+}
+
+func (c *memoryConn) LocalAddr() net.Addr  { return c.local }
+func (c *memoryConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.read.setDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline is not supported, writes only block on a full buffer
+func (c *memoryConn) SetWriteDeadline(t time.Time) error { return nil }
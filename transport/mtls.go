@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ClientCertValidity is how long issued client certificates are valid
+const ClientCertValidity = 2 * 365 * 24 * time.Hour
+
+// ErrNoClientCertificate is returned for connections that did not present a
+// verified client certificate
+var ErrNoClientCertificate = errors.New("no client certificate presented")
+
+// RequireClientCertificates makes a server configuration reject clients
+// without a certificate signed by one of the CAs in caFile
+func RequireClientCertificates(config *tls.Config, caFile string) error {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// LoadClientCertificate adds the certificate a client presents to servers
+// that require mutual TLS
+func LoadClientCertificate(config *tls.Config, certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	config.Certificates = []tls.Certificate{cert}
+	return nil
+}
+
+// PeerIdentity returns the common name of the verified client certificate
+// of a connection accepted by Listen. The TLS handshake is completed first
+// if it hasn't happened yet.
+func PeerIdentity(conn net.Conn) (string, error) {
+	var state tls.ConnectionState
+	switch c := conn.(type) {
+	case *tls.Conn:
+		if err := c.Handshake(); err != nil {
+			return "", err
+		}
+		state = c.ConnectionState()
+	case *muxConn:
+		return PeerIdentity(c.raw)
+	case *quicConn:
+		state = c.conn.ConnectionState().TLS
+	case *wsConn:
+		if c.Request().TLS == nil {
+			return "", ErrNoClientCertificate
+		}
+		state = *c.Request().TLS
+	case *webrtcConn:
+		if c.tlsState == nil {
+			return "", ErrNoClientCertificate
+		}
+		state = *c.tlsState
+	default:
+		return "", ErrNoClientCertificate
+	}
+
+	// Certificates are only verified if the config requires them
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return "", ErrNoClientCertificate
+	}
+	return state.PeerCertificates[0].Subject.CommonName, nil
+}
+
+// IssueClientCertificate enrolls a device: it creates a key and a client
+// certificate for name signed with the CA certificate and key, and writes
+// them as PEM files
+func IssueClientCertificate(caCertFile, caKeyFile, name, certFile, keyFile string) error {
+	ca, err := tls.LoadX509KeyPair(caCertFile, caKeyFile)
+	if err != nil {
+		return err
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if !caCert.IsCA {
+		return fmt.Errorf("%s is not a CA certificate", caCertFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(ClientCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -0,0 +1,180 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/yamux"
+)
+
+// muxSetupTimeout bounds how long a new connection may take to open its
+// control stream
+const muxSetupTimeout = 10 * time.Second
+
+// muxConfig gives every stream a 1MB window, enough for a full frame, so a
+// monitor whose reader falls behind only stalls its own stream
+func muxConfig() *yamux.Config {
+	config := yamux.DefaultConfig()
+	config.MaxStreamWindowSize = 1 << 20
+	config.KeepAliveInterval = 5 * time.Second
+	config.LogOutput = nil
+	config.Logger = log.Default()
+	return config
+}
+
+// muxListener accepts TCP connections multiplexed with yamux and returns
+// their control streams
+type muxListener struct {
+	listener net.Listener
+	conns    chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// muxConn is a yamux session whose first stream, opened by the client, is
+// the control stream. Further streams carry per-monitor video and input,
+// each with its own flow control window.
+type muxConn struct {
+	*yamux.Stream
+	session *yamux.Session
+	raw     net.Conn // Underlying connection, for the TLS state
+}
+
+// listenMux starts a TCP listener, served over TLS if a config is given,
+// whose connections are multiplexed
+func listenMux(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	l := &muxListener{
+		listener: listener,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// acceptLoop sets up sessions in the background, so a slow client can't
+// hold up the others
+func (l *muxListener) acceptLoop() {
+	for {
+		raw, err := l.listener.Accept()
+		if err != nil {
+			l.once.Do(func() { close(l.closed) })
+			return
+		}
+		go l.setup(raw)
+	}
+}
+
+// setup starts the server side of a session and waits for its control stream
+func (l *muxListener) setup(raw net.Conn) {
+	session, err := yamux.Server(raw, muxConfig())
+	if err != nil {
+		raw.Close()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), muxSetupTimeout)
+	stream, err := session.AcceptStreamWithContext(ctx)
+	cancel()
+	if err != nil {
+		session.Close()
+		return
+	}
+
+	select {
+	case l.conns <- &muxConn{Stream: stream, session: session, raw: raw}:
+	case <-l.closed:
+		session.Close()
+	}
+}
+
+// Accept waits for the next multiplexed connection
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections, established sessions stay open
+func (l *muxListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.listener.Close()
+}
+
+func (l *muxListener) Addr() net.Addr { return l.listener.Addr() }
+
+// dialMux connects over TCP, or TLS if a config is given, and opens the
+// control stream
+func dialMux(dialer Dialer, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	raw, err := dialTCP(dialer, address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := yamux.Client(raw, muxConfig())
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	stream, err := session.OpenStream()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &muxConn{Stream: stream, session: session, raw: raw}, nil
+}
+
+// Close closes the whole session, including all other streams
+func (c *muxConn) Close() error {
+	c.Stream.Close()
+	return c.session.Close()
+}
+
+// OpenStream opens a stream, prefixed with its ID
+func (c *muxConn) OpenStream(id uint32) (io.WriteCloser, error) {
+	stream, err := c.session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], id)
+	if _, err := stream.Write(header[:]); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// AcceptStream waits for a stream opened by the peer
+func (c *muxConn) AcceptStream() (uint32, io.ReadCloser, error) {
+	stream, err := c.session.AcceptStream()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(stream, header[:]); err != nil {
+		stream.Close()
+		return 0, nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	return binary.LittleEndian.Uint32(header[:]), stream, nil
+}
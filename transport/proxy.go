@@ -0,0 +1,180 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// NoProxy disables the proxy from the environment when given to ProxyDialer
+const NoProxy = "none"
+
+// RunsOverTCP reports whether a transport runs over a TCP connection that
+// can be tunneled or proxied, see DialVia
+func RunsOverTCP(transport string) bool {
+	switch transport {
+	case "", TCP, Mux, WebSocket:
+		return true
+	}
+	return false
+}
+
+// ProxyDialer returns a dialer connecting to address through a proxy, or
+// nil to connect directly. proxyURL is a socks5:// or http(s):// URL,
+// optionally with user:password, or NoProxy. If it is empty the proxy is
+// taken from HTTPS_PROXY, ALL_PROXY or HTTP_PROXY, honoring NO_PROXY.
+func ProxyDialer(proxyURL, address string) (Dialer, error) {
+	if proxyURL == NoProxy {
+		return nil, nil
+	}
+
+	var u *url.URL
+	if proxyURL != "" {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		u = parsed
+	} else {
+		parsed, err := proxyFromEnvironment(address)
+		if err != nil {
+			return nil, err
+		}
+		u = parsed
+	}
+	if u == nil {
+		return nil, nil
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", u.Host, auth, directDialer{})
+		if err != nil {
+			return nil, err
+		}
+		return dialer.Dial, nil
+
+	case "http", "https":
+		return (&connectDialer{proxy: u}).Dial, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q, use socks5, http or https", u.Scheme)
+}
+
+// proxyFromEnvironment returns the proxy the environment configures for
+// address, nil if none or if NO_PROXY excludes it
+func proxyFromEnvironment(address string) (*url.URL, error) {
+	config := httpproxy.FromEnvironment()
+	if config.HTTPSProxy == "" {
+		config.HTTPSProxy = getenvAny("ALL_PROXY", "all_proxy")
+	}
+	if config.HTTPSProxy == "" {
+		config.HTTPSProxy = config.HTTPProxy
+	}
+	if config.HTTPSProxy == "" {
+		return nil, nil
+	}
+
+	// The session is tunneled like HTTPS, so ask for the HTTPS proxy
+	return config.ProxyFunc()(&url.URL{Scheme: "https", Host: address})
+}
+
+// getenvAny returns the first non-empty environment variable of names
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// connectDialer tunnels connections through an HTTP proxy with CONNECT
+type connectDialer struct {
+	proxy *url.URL
+}
+
+// Dial connects to the proxy and asks it to open a tunnel to address
+func (d *connectDialer) Dial(network, address string) (net.Conn, error) {
+	host := d.proxy.Host
+	if d.proxy.Port() == "" {
+		port := "80"
+		if d.proxy.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(d.proxy.Hostname(), port)
+	}
+
+	conn, err := DialFastest(network, host)
+	if err != nil {
+		return nil, err
+	}
+	if d.proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.proxy.User != nil {
+		password, _ := d.proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.proxy.User.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid response from proxy: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused tunnel to %s: %s", address, response.Status)
+	}
+
+	// The server may already have sent data the reader buffered
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// directDialer connects to a SOCKS proxy with DialFastest
+type directDialer struct{}
+
+func (directDialer) Dial(network, address string) (net.Conn, error) {
+	return DialFastest(network, address)
+}
+
+// bufferedConn is a connection whose first bytes were read into a buffer
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPConnectProxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// A proxy that checks the credentials, then greets and echoes instead
+	// of connecting anywhere. The greeting arrives with the response, so
+	// it must not be lost in the dialer's buffer.
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		if request.Method != http.MethodConnect || request.Host != "server:8000" ||
+			request.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\nhi")
+		io.Copy(conn, conn)
+	}()
+
+	dialer, err := ProxyDialer("http://user:pass@"+listener.Addr().String(), "server:8000")
+	if err != nil || dialer == nil {
+		t.Fatalf("ProxyDialer: %v", err)
+	}
+	conn, err := dialer("tcp", "server:8000")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 6)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "hiping" {
+		t.Fatalf("read %q, %v", reply, err)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("ALL_PROXY", "socks5://proxy.example.com:1080")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	if dialer, err := ProxyDialer("", "remote.example.com:8000"); err != nil || dialer == nil {
+		t.Fatalf("ALL_PROXY not used: %v", err)
+	}
+	if dialer, err := ProxyDialer("", "internal.example.com:8000"); err != nil || dialer != nil {
+		t.Fatalf("NO_PROXY not honored: %v", err)
+	}
+	if dialer, err := ProxyDialer(NoProxy, "remote.example.com:8000"); err != nil || dialer != nil {
+		t.Fatalf("NoProxy not honored: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN identifies the UltraRDP protocol during the QUIC/TLS handshake
+const quicALPN = "ultrardp"
+
+// quicDialTimeout bounds how long establishing a QUIC connection may take
+const quicDialTimeout = 10 * time.Second
+
+// quicConfig keeps idle sessions alive, the server may not send anything for
+// a while when the screen doesn't change
+var quicConfig = &quic.Config{
+	KeepAlivePeriod: 5 * time.Second,
+	MaxIdleTimeout:  30 * time.Second,
+}
+
+// quicListener accepts QUIC connections and returns their control streams
+type quicListener struct {
+	listener *quic.Listener
+	conns    chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// quicConn is a QUIC connection whose first bidirectional stream is the
+// control stream. Additional unidirectional streams carry per-monitor video.
+type quicConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+// listenQUIC starts a QUIC listener, with a throwaway self-signed
+// certificate unless a TLS config is given
+func listenQUIC(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	if tlsConfig == nil {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{quicALPN}
+
+	listener, err := quic.ListenAddr(address, tlsConfig, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &quicListener{
+		listener: listener,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// acceptLoop waits for control streams in the background, so a slow client
+// can't hold up the others
+func (l *quicListener) acceptLoop() {
+	for {
+		conn, err := l.listener.Accept(context.Background())
+		if err != nil {
+			l.once.Do(func() { close(l.closed) })
+			return
+		}
+		go l.setup(conn)
+	}
+}
+
+// setup waits for a connection's control stream, which the client announces
+// by writing a marker byte
+func (l *quicListener) setup(conn quic.Connection) {
+	ctx, cancel := context.WithTimeout(context.Background(), quicDialTimeout)
+	stream, err := conn.AcceptStream(ctx)
+	cancel()
+	if err == nil {
+		stream.SetReadDeadline(time.Now().Add(quicDialTimeout))
+		var marker [1]byte
+		_, err = io.ReadFull(stream, marker[:])
+		stream.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.CloseWithError(0, "no control stream")
+		return
+	}
+
+	select {
+	case l.conns <- &quicConn{Stream: stream, conn: conn}:
+	case <-l.closed:
+		conn.CloseWithError(0, "")
+	}
+}
+
+// Accept waits for the next client and its control stream
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *quicListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.listener.Close()
+}
+
+func (l *quicListener) Addr() net.Addr { return l.listener.Addr() }
+
+// dialQUIC connects to a QUIC server and opens the control stream. Without
+// a TLS config the server's certificate is assumed to be a throwaway one and
+// is not verified.
+func dialQUIC(address string, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{quicALPN}
+
+	ctx, cancel := context.WithTimeout(context.Background(), quicDialTimeout)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, address, tlsConfig, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	// Streams are only announced to the peer once data is sent on them
+	if _, err := stream.Write([]byte{0}); err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	return &quicConn{Stream: stream, conn: conn}, nil
+}
+
+func (c *quicConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// Close closes the whole QUIC connection, including all other streams
+func (c *quicConn) Close() error {
+	c.Stream.Close()
+	return c.conn.CloseWithError(0, "")
+}
+
+// OpenStream opens a unidirectional stream, prefixed with its ID
+func (c *quicConn) OpenStream(id uint32) (io.WriteCloser, error) {
+	stream, err := c.conn.OpenUniStream()
+	if err != nil {
+		return nil, err
+	}
+
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], id)
+	if _, err := stream.Write(header[:]); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// AcceptStream waits for a unidirectional stream opened by the peer
+func (c *quicConn) AcceptStream() (uint32, io.ReadCloser, error) {
+	stream, err := c.conn.AcceptUniStream(context.Background())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(stream, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	return binary.LittleEndian.Uint32(header[:]), io.NopCloser(stream), nil
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestQUICAcceptNotHeldUpBySlowClient(t *testing.T) {
+	listener, err := Listen(QUIC, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	// A client that connects but never opens its control stream
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	slow, err := quic.DialAddr(ctx, listener.Addr().String(),
+		&tls.Config{InsecureSkipVerify: true, NextProtos: []string{quicALPN}}, quicConfig)
+	if err != nil {
+		t.Fatalf("DialAddr: %v", err)
+	}
+	defer slow.CloseWithError(0, "")
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	conn, err := Dial(QUIC, listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+	case <-time.After(quicDialTimeout / 2):
+		t.Fatal("Accept waited for the client without a control stream")
+	}
+}
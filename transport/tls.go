@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// generatedCertValidity is how long a generated server certificate is valid
+const generatedCertValidity = 5 * 365 * 24 * time.Hour
+
+// DefaultCertFiles returns where the server keeps its certificate and key
+// when no paths are configured
+func DefaultCertFiles() (certFile, keyFile string) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	dir = filepath.Join(dir, "ultrardp")
+	return filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+}
+
+// LoadServerTLS loads the server certificate and key. If neither file
+// exists yet, a self-signed certificate for this machine is generated and
+// saved, so clients can pin it with -ca on later runs.
+func LoadServerTLS(certFile, keyFile string) (*tls.Config, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		if err := writeSelfSignedCertificate(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("failed to generate certificate: %w", err)
+		}
+		log.Printf("Generated self-signed certificate %s, clients can trust it with -ca", certFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// ClientTLS returns the client configuration. The server certificate is
+// verified against caFile if given and against the system roots otherwise.
+func ClientTLS(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// writeSelfSignedCertificate generates a certificate valid for the local
+// host names and addresses and writes it with its key as PEM files
+func writeSelfSignedCertificate(certFile, keyFile string) error {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+
+	der, key, err := generateCertificate(hosts, generatedCertValidity)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeneratedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "certs", "server.crt")
+	keyFile := filepath.Join(dir, "certs", "server.key")
+
+	serverConfig, err := LoadServerTLS(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadServerTLS: %v", err)
+	}
+
+	// The generated certificate is reused on the next run
+	if _, err := LoadServerTLS(certFile, keyFile); err != nil {
+		t.Fatalf("reloading generated certificate: %v", err)
+	}
+
+	listener, err := Listen(TCP, "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}
+	}()
+
+	// Clients trust the self-signed certificate as their CA
+	clientConfig, err := ClientTLS(certFile)
+	if err != nil {
+		t.Fatalf("ClientTLS: %v", err)
+	}
+	conn, err := Dial(TCP, listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ok" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+
+	// Without the CA the certificate is rejected
+	untrusted, _ := ClientTLS("")
+	if conn, err := Dial(TCP, listener.Addr().String(), untrusted); err == nil {
+		conn.Close()
+		t.Error("self-signed certificate accepted without CA")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	serverConfig, err := LoadServerTLS(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadServerTLS: %v", err)
+	}
+	if err := RequireClientCertificates(serverConfig, certFile); err != nil {
+		t.Fatalf("RequireClientCertificates: %v", err)
+	}
+
+	// Enroll a device with the server certificate as CA
+	clientCert := filepath.Join(dir, "laptop.crt")
+	clientKey := filepath.Join(dir, "laptop.key")
+	if err := IssueClientCertificate(certFile, keyFile, "laptop", clientCert, clientKey); err != nil {
+		t.Fatalf("IssueClientCertificate: %v", err)
+	}
+
+	listener, err := Listen(TCP, "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	identities := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			identity, err := PeerIdentity(conn)
+			if err != nil {
+				identity = "error: " + err.Error()
+			} else {
+				conn.Write([]byte("ok"))
+			}
+			identities <- identity
+			conn.Close()
+		}
+	}()
+
+	clientConfig, err := ClientTLS(certFile)
+	if err != nil {
+		t.Fatalf("ClientTLS: %v", err)
+	}
+	if err := LoadClientCertificate(clientConfig, clientCert, clientKey); err != nil {
+		t.Fatalf("LoadClientCertificate: %v", err)
+	}
+	conn, err := Dial(TCP, listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	buf := make([]byte, 2)
+	io.ReadFull(conn, buf)
+	conn.Close()
+	if identity := <-identities; identity != "laptop" {
+		t.Fatalf("identity %q, want laptop", identity)
+	}
+
+	// A client without a certificate fails the handshake
+	anonymous, _ := ClientTLS(certFile)
+	if conn, err := Dial(TCP, listener.Addr().String(), anonymous); err == nil {
+		io.ReadFull(conn, buf)
+		conn.Close()
+	}
+	if identity := <-identities; identity == "laptop" {
+		t.Fatal("client without certificate was identified")
+	}
+}
@@ -0,0 +1,131 @@
+// Package transport provides the network transports UltraRDP can run over.
+// Every transport yields a net.Conn for the control stream so the protocol
+// code is the same regardless of how packets travel.
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Transport names accepted by Listen and Dial
+const (
+	TCP       = "tcp"
+	QUIC      = "quic"
+	WebSocket = "ws"
+	WebRTC    = "webrtc"
+	Mux       = "mux" // TCP with yamux stream multiplexing
+	Memory    = "mem" // In-process connections for tests, TLS is ignored
+)
+
+// MultiStreamConn is a connection that can carry independent streams next
+// to its control stream, so a large frame of one monitor never delays the
+// frames of another
+type MultiStreamConn interface {
+	net.Conn
+
+	// OpenStream opens a new outgoing stream tagged with an ID
+	OpenStream(id uint32) (io.WriteCloser, error)
+
+	// AcceptStream waits for the peer to open a stream
+	AcceptStream() (id uint32, stream io.ReadCloser, err error)
+}
+
+// EncryptsStreams reports whether the extra streams of a multi-stream
+// transport are protected by the transport itself. QUIC and WebRTC always
+// encrypt, but only a verified TLS certificate, of QUIC or of WebRTC's
+// signaling, keeps someone in the middle out, so like multiplexed TCP they
+// need TLS.
+func EncryptsStreams(transport string, tlsEnabled bool) bool {
+	switch transport {
+	case QUIC, WebRTC, Mux:
+		return tlsEnabled
+	}
+	return false
+}
+
+// Listen starts a listener for the named transport. With a TLS config, TCP,
+// multiplexed TCP and WebSocket connections and WebRTC signaling are served over TLS, and
+// QUIC uses its certificate instead of a throwaway one.
+func Listen(transport, address string, tlsConfig *tls.Config) (net.Listener, error) {
+	switch transport {
+	case "", TCP:
+		if tlsConfig != nil {
+			return tls.Listen("tcp", address, tlsConfig)
+		}
+		return net.Listen("tcp", address)
+	case QUIC:
+		return listenQUIC(address, tlsConfig)
+	case Mux:
+		return listenMux(address, tlsConfig)
+	case Memory:
+		return listenMemory(address)
+	case WebSocket:
+		return listenWebSocket(address, tlsConfig)
+	case WebRTC:
+		return listenWebRTC(address, tlsConfig)
+	}
+	return nil, fmt.Errorf("unknown transport %q", transport)
+}
+
+// Dial connects to a server using the named transport. With a TLS config
+// the server certificate is verified, see Listen.
+func Dial(transport, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	switch transport {
+	case QUIC:
+		return dialQUIC(address, tlsConfig)
+	case Memory:
+		return dialMemory(address)
+	case WebRTC:
+		return dialWebRTC(address, tlsConfig)
+	}
+	return DialVia(DialFastest, transport, address, tlsConfig)
+}
+
+// Dialer opens the TCP connection under a TCP based transport, e.g.
+// through an SSH tunnel or a proxy
+type Dialer func(network, address string) (net.Conn, error)
+
+// DialVia is Dial with the TCP connection opened by dialer. Only the TCP
+// based transports (tcp, mux and ws) can be dialed this way.
+func DialVia(dialer Dialer, transport, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	switch transport {
+	case "", TCP:
+		return dialTCP(dialer, address, tlsConfig)
+	case Mux:
+		return dialMux(dialer, address, tlsConfig)
+	case WebSocket:
+		return dialWebSocket(dialer, address, tlsConfig)
+	case QUIC, Memory, WebRTC:
+		return nil, fmt.Errorf("the %s transport doesn't run over TCP and can't be tunneled or proxied", transport)
+	}
+	return nil, fmt.Errorf("unknown transport %q", transport)
+}
+
+// dialTCP opens a TCP connection with dialer, over TLS if configured
+func dialTCP(dialer Dialer, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	conn, err := dialer("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return conn, nil
+	}
+
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
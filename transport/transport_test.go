@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestMultiStreamTransports(t *testing.T) {
+	for _, name := range []string{QUIC, WebRTC, Mux} {
+		t.Run(name, func(t *testing.T) {
+			testControlAndStreams(t, name)
+		})
+	}
+}
+
+func TestEncryptsStreamsNeedsTLS(t *testing.T) {
+	// Without a verified certificate nothing keeps someone in the middle
+	// from reading streams, whatever the transport encrypts
+	for _, name := range []string{TCP, QUIC, WebRTC, Mux, WebSocket} {
+		if EncryptsStreams(name, false) {
+			t.Errorf("EncryptsStreams(%q, false) = true", name)
+		}
+	}
+	for _, name := range []string{QUIC, WebRTC, Mux} {
+		if !EncryptsStreams(name, true) {
+			t.Errorf("EncryptsStreams(%q, true) = false", name)
+		}
+	}
+}
+
+func TestSetICEServers(t *testing.T) {
+	defer func(servers []webrtc.ICEServer) { webrtcICEServers = servers }(webrtcICEServers)
+
+	if err := SetICEServers("stun:stun.example.com:3478, turn:alice:secret@turn.example.com:3478"); err != nil {
+		t.Fatalf("SetICEServers: %v", err)
+	}
+	if len(webrtcICEServers) != 2 {
+		t.Fatalf("got %d ICE servers, want 2", len(webrtcICEServers))
+	}
+	if urls := webrtcICEServers[0].URLs; len(urls) != 1 || urls[0] != "stun:stun.example.com:3478" {
+		t.Errorf("STUN URLs = %v", urls)
+	}
+	turn := webrtcICEServers[1]
+	if turn.URLs[0] != "turn:turn.example.com:3478" || turn.Username != "alice" || turn.Credential != "secret" {
+		t.Errorf("TURN server = %+v", turn)
+	}
+
+	if err := SetICEServers("https://stun.example.com"); err == nil {
+		t.Error("SetICEServers accepted a URL that isn't stun: or turn:")
+	}
+}
+
+// testControlAndStreams exchanges data on the control stream and on a
+// server-opened stream
+func testControlAndStreams(t *testing.T, name string) {
+	listener, err := Listen(name, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan MultiStreamConn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+			close(accepted)
+			return
+		}
+		accepted <- conn.(MultiStreamConn)
+	}()
+
+	client, err := Dial(name, listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	server := <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	defer server.Close()
+
+	// Control stream
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("control stream got %q, %v", buf, err)
+	}
+
+	// Per-monitor stream
+	stream, err := server.OpenStream(2)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	frame := bytes.Repeat([]byte{0xAB}, 100000)
+	if _, err := stream.Write(frame); err != nil {
+		t.Fatalf("stream Write: %v", err)
+	}
+	stream.Close()
+
+	id, reader, err := client.(MultiStreamConn).AcceptStream()
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	if id != 2 {
+		t.Errorf("stream ID = %d, want 2", id)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(got, frame) {
+		t.Fatalf("stream got %d bytes, %v", len(got), err)
+	}
+}
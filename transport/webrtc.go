@@ -0,0 +1,502 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/datachannel"
+	"github.com/pion/webrtc/v4"
+)
+
+// WebRTCSignalingPath is the HTTP path offers are posted to
+const WebRTCSignalingPath = "/ultrardp/webrtc"
+
+const (
+	// webrtcConnectTimeout bounds signaling, ICE and the control channel opening
+	webrtcConnectTimeout = 15 * time.Second
+
+	// webrtcMessageSize is the largest data channel message sent, messages
+	// beyond 16KB are not reliably supported by browsers
+	webrtcMessageSize = 16 * 1024
+
+	// webrtcReadBufferSize must hold the largest message a peer may send
+	webrtcReadBufferSize = 256 * 1024
+
+	webrtcControlLabel = "control"
+	webrtcStreamPrefix = "stream-"
+)
+
+// webrtcICEServers are used to discover public addresses for NAT traversal,
+// see SetICEServers. There are none by default, so no third party learns of
+// connections.
+var webrtcICEServers []webrtc.ICEServer
+
+// SetICEServers sets the STUN and TURN servers WebRTC peers traverse NATs
+// with, from a comma separated list of URLs like
+// "stun:stun.example.com:3478,turn:alice:secret@turn.example.com:3478".
+// Without any, peers only connect over the addresses they see locally. It
+// must be called before listening or dialing.
+func SetICEServers(list string) error {
+	var servers []webrtc.ICEServer
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		scheme, rest, _ := strings.Cut(entry, ":")
+		switch scheme {
+		case "stun", "stuns", "turn", "turns":
+		default:
+			return fmt.Errorf("%q is not a stun: or turn: URL", entry)
+		}
+
+		server := webrtc.ICEServer{URLs: []string{entry}}
+		if userinfo, host, ok := strings.Cut(rest, "@"); ok {
+			username, credential, _ := strings.Cut(userinfo, ":")
+			server.URLs = []string{scheme + ":" + host}
+			server.Username = username
+			server.Credential = credential
+		}
+		servers = append(servers, server)
+	}
+	webrtcICEServers = servers
+	return nil
+}
+
+// webrtcAPI creates peer connections with detached data channels, so they
+// can be used as plain readers and writers
+var webrtcAPI = func() *webrtc.API {
+	settings := webrtc.SettingEngine{}
+	settings.DetachDataChannels()
+	return webrtc.NewAPI(webrtc.WithSettingEngine(settings))
+}()
+
+// webrtcListener answers offers posted to the signaling endpoint and
+// returns a connection once the client's control channel is open
+type webrtcListener struct {
+	listener net.Listener
+	server   *http.Server
+	conns    chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// webrtcConn is a peer connection whose "control" data channel carries the
+// packet stream. Additional data channels carry per-monitor video. Video
+// doesn't go over media tracks: frames are packets of codecs the peers
+// negotiate themselves, with their own keyframe requests, acknowledgements
+// and congestion control that RTP payloading and WebRTC's jitter buffer
+// and rate control would duplicate or fight, and every transport stays a
+// plain byte stream.
+type webrtcConn struct {
+	pc      *webrtc.PeerConnection
+	control *channelStream
+	remote  net.Addr
+	streams chan acceptedStream
+	closed  chan struct{}
+	once    sync.Once
+
+	tlsState *tls.ConnectionState // Signaling request's TLS state, for client certificates
+}
+
+// acceptedStream is a data channel opened by the peer
+type acceptedStream struct {
+	id     uint32
+	stream *channelStream
+}
+
+// channelStream turns a detached data channel into a byte stream. Writes
+// are split into messages of at most webrtcMessageSize bytes.
+type channelStream struct {
+	channel datachannel.ReadWriteCloser
+	buf     []byte
+	pending []byte // Received bytes not yet returned by Read
+	mutex   sync.Mutex
+}
+
+func newChannelStream(channel datachannel.ReadWriteCloser) *channelStream {
+	return &channelStream{channel: channel, buf: make([]byte, webrtcReadBufferSize)}
+}
+
+func (s *channelStream) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		n, err := s.channel.Read(s.buf)
+		if err != nil {
+			return 0, err
+		}
+		s.pending = s.buf[:n]
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *channelStream) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > webrtcMessageSize {
+			chunk = chunk[:webrtcMessageSize]
+		}
+		if _, err := s.channel.Write(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (s *channelStream) Close() error { return s.channel.Close() }
+
+// listenWebRTC starts the HTTP signaling endpoint, served over TLS if a
+// config is given
+func listenWebRTC(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	l := &webrtcListener{
+		listener: listener,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(WebRTCSignalingPath, l.handleOffer)
+	l.server = &http.Server{Handler: mux}
+
+	go l.server.Serve(listener)
+	return l, nil
+}
+
+// handleOffer answers a client's offer. ICE candidates are gathered before
+// answering so signaling is a single request.
+func (l *webrtcListener) handleOffer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "offer must be posted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var offer webrtc.SessionDescription
+	if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
+		http.Error(w, "invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	pc, err := webrtcAPI.NewPeerConnection(webrtc.Configuration{ICEServers: webrtcICEServers})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn := newWebRTCConn(pc)
+	if remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		conn.remote = remote
+	}
+	conn.tlsState = r.TLS
+
+	// Hand the connection to Accept once the control channel is open
+	ready := make(chan *channelStream, 1)
+	conn.handleChannels(ready)
+	go func() {
+		select {
+		case control := <-ready:
+			conn.control = control
+			select {
+			case l.conns <- conn:
+			case <-l.closed:
+				conn.Close()
+			}
+		case <-time.After(webrtcConnectTimeout):
+			conn.Close()
+		}
+	}()
+
+	answer, err := answerOffer(pc, offer)
+	if err != nil {
+		conn.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(answer)
+}
+
+// answerOffer applies an offer and returns the answer with all candidates
+func answerOffer(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return nil, err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return nil, err
+	}
+	<-gathered
+
+	return pc.LocalDescription(), nil
+}
+
+// Accept waits for the next client whose control channel is open
+func (l *webrtcListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops signaling, established connections stay open
+func (l *webrtcListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.server.Close()
+}
+
+func (l *webrtcListener) Addr() net.Addr { return l.listener.Addr() }
+
+// dialWebRTC posts an offer to the server's signaling endpoint and waits for
+// the control channel to open. The address is host:port or a full http(s)
+// URL of the signaling endpoint.
+func dialWebRTC(address string, tlsConfig *tls.Config) (net.Conn, error) {
+	endpoint := address
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		scheme := "http://"
+		if tlsConfig != nil {
+			scheme = "https://"
+		}
+		endpoint = scheme + address + WebRTCSignalingPath
+	} else if tlsConfig != nil && strings.HasPrefix(endpoint, "http://") {
+		return nil, errors.New("TLS is enabled but the signaling URL is http://")
+	}
+
+	pc, err := webrtcAPI.NewPeerConnection(webrtc.Configuration{ICEServers: webrtcICEServers})
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newWebRTCConn(pc)
+	if remote, err := net.ResolveTCPAddr("tcp", address); err == nil {
+		conn.remote = remote
+	}
+	conn.handleChannels(nil)
+
+	channel, err := pc.CreateDataChannel(webrtcControlLabel, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ready := make(chan *channelStream, 1)
+	detachOnOpen(channel, func(stream *channelStream) { ready <- stream })
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	<-gathered
+
+	answer, err := postOffer(endpoint, pc.LocalDescription(), tlsConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := pc.SetRemoteDescription(*answer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case conn.control = <-ready:
+		return conn, nil
+	case <-time.After(webrtcConnectTimeout):
+		conn.Close()
+		return nil, errors.New("timed out waiting for the WebRTC connection")
+	}
+}
+
+// postOffer sends the offer to the signaling endpoint and returns the answer
+func postOffer(endpoint string, offer *webrtc.SessionDescription, tlsConfig *tls.Config) (*webrtc.SessionDescription, error) {
+	body, err := json.Marshal(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout:   webrtcConnectTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("signaling failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var answer webrtc.SessionDescription
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid answer: %w", err)
+	}
+	return &answer, nil
+}
+
+func newWebRTCConn(pc *webrtc.PeerConnection) *webrtcConn {
+	conn := &webrtcConn{
+		pc:      pc,
+		streams: make(chan acceptedStream, 16),
+		closed:  make(chan struct{}),
+	}
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			conn.Close()
+		}
+	})
+	return conn
+}
+
+// handleChannels dispatches data channels opened by the peer: the control
+// channel is sent to control, streams are queued for AcceptStream
+func (c *webrtcConn) handleChannels(control chan<- *channelStream) {
+	c.pc.OnDataChannel(func(channel *webrtc.DataChannel) {
+		label := channel.Label()
+		switch {
+		case label == webrtcControlLabel && control != nil:
+			detachOnOpen(channel, func(stream *channelStream) { control <- stream })
+
+		case strings.HasPrefix(label, webrtcStreamPrefix):
+			id, err := strconv.ParseUint(strings.TrimPrefix(label, webrtcStreamPrefix), 10, 32)
+			if err != nil {
+				channel.Close()
+				return
+			}
+			detachOnOpen(channel, func(stream *channelStream) {
+				select {
+				case c.streams <- acceptedStream{id: uint32(id), stream: stream}:
+				case <-c.closed:
+					stream.Close()
+				}
+			})
+
+		default:
+			channel.Close()
+		}
+	})
+}
+
+// detachOnOpen detaches a data channel once it is open
+func detachOnOpen(channel *webrtc.DataChannel, opened func(*channelStream)) {
+	channel.OnOpen(func() {
+		raw, err := channel.Detach()
+		if err != nil {
+			channel.Close()
+			return
+		}
+		opened(newChannelStream(raw))
+	})
+}
+
+func (c *webrtcConn) Read(p []byte) (int, error)  { return c.control.Read(p) }
+func (c *webrtcConn) Write(p []byte) (int, error) { return c.control.Write(p) }
+
+// Close closes the peer connection, including all data channels
+func (c *webrtcConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.pc.Close()
+	})
+	return err
+}
+
+func (c *webrtcConn) LocalAddr() net.Addr { return webrtcAddr("local") }
+
+func (c *webrtcConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return webrtcAddr("remote")
+}
+
+// SetDeadline only applies to reads, data channel writes never block on the peer
+func (c *webrtcConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *webrtcConn) SetReadDeadline(t time.Time) error {
+	if deadliner, ok := c.control.channel.(datachannel.ReadDeadliner); ok {
+		return deadliner.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *webrtcConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// OpenStream opens an ordered data channel for a stream
+func (c *webrtcConn) OpenStream(id uint32) (io.WriteCloser, error) {
+	channel, err := c.pc.CreateDataChannel(webrtcStreamPrefix+strconv.FormatUint(uint64(id), 10), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make(chan *channelStream, 1)
+	detachOnOpen(channel, func(stream *channelStream) { opened <- stream })
+
+	select {
+	case stream := <-opened:
+		return stream, nil
+	case <-c.closed:
+		return nil, net.ErrClosed
+	case <-time.After(webrtcConnectTimeout):
+		channel.Close()
+		return nil, errors.New("timed out opening data channel")
+	}
+}
+
+// AcceptStream waits for a data channel opened by the peer
+func (c *webrtcConn) AcceptStream() (uint32, io.ReadCloser, error) {
+	select {
+	case accepted := <-c.streams:
+		return accepted.id, accepted.stream, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// webrtcAddr is the address of a peer connection endpoint without a known
+// transport address
+type webrtcAddr string
+
+func (a webrtcAddr) Network() string { return "webrtc" }
+func (a webrtcAddr) String() string  { return string(a) }
@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketPath is the HTTP path the WebSocket listener serves
+const WebSocketPath = "/ultrardp"
+
+// wsListener accepts WebSocket connections on an HTTP server
+type wsListener struct {
+	listener net.Listener
+	server   *http.Server
+	conns    chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// wsConn is a WebSocket connection carrying the packet stream. Every Write
+// is sent as one binary message and the receiver reads messages back as a
+// byte stream, so packets go through the same codec as on TCP.
+type wsConn struct {
+	*websocket.Conn
+	remote net.Addr      // Peer address, the Origin is reported otherwise
+	done   chan struct{} // Closed when the connection is closed
+	once   sync.Once
+}
+
+// listenWebSocket starts an HTTP server accepting WebSocket upgrades,
+// served over TLS if a config is given
+func listenWebSocket(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	l := &wsListener{
+		listener: listener,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+
+	// Accept any origin, clients other than browsers don't send one
+	mux := http.NewServeMux()
+	mux.Handle(WebSocketPath, websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   l.handle,
+	})
+	l.server = &http.Server{Handler: mux}
+
+	go l.server.Serve(listener)
+	return l, nil
+}
+
+// handle hands an upgraded connection to Accept and keeps it open until it
+// is closed, the HTTP server closes it when the handler returns
+func (l *wsListener) handle(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+
+	conn := &wsConn{Conn: ws, done: make(chan struct{})}
+	if remote, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr); err == nil {
+		conn.remote = remote
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		return
+	}
+	<-conn.done
+}
+
+// Accept waits for the next WebSocket connection
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections, established connections stay open
+func (l *wsListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.server.Close()
+}
+
+func (l *wsListener) Addr() net.Addr { return l.listener.Addr() }
+
+// dialWebSocket connects to a WebSocket listener. The address is either
+// host:port or a full ws:// or wss:// URL, e.g. when going through a proxy
+// that routes on the path.
+func dialWebSocket(dialer Dialer, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	location := address
+	if !strings.HasPrefix(location, "ws://") && !strings.HasPrefix(location, "wss://") {
+		scheme := "ws://"
+		if tlsConfig != nil {
+			scheme = "wss://"
+		}
+		location = scheme + address + WebSocketPath
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	config, err := websocket.NewConfig(location, "http://"+parsed.Host+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the connection ourselves rather than with websocket.DialConfig,
+	// so it can be tunneled
+	host := parsed.Host
+	if parsed.Port() == "" {
+		port := "80"
+		if parsed.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(parsed.Hostname(), port)
+	}
+	var wsTLS *tls.Config
+	if parsed.Scheme == "wss" {
+		wsTLS = tlsConfig
+		if wsTLS == nil {
+			wsTLS = &tls.Config{}
+		}
+	}
+
+	conn, err := dialTCP(dialer, host, wsTLS)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	return &wsConn{Conn: ws, done: make(chan struct{})}, nil
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Close closes the WebSocket and releases the server-side handler
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.done) })
+	return err
+}
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/moderniselife/ultrardp/protocol"
+)
+
+func TestWebSocketPackets(t *testing.T) {
+	listener, err := Listen(WebSocket, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		defer conn.Close()
+
+		// Echo one packet back
+		packet, err := protocol.DecodePacket(conn)
+		if err == nil {
+			err = protocol.EncodePacket(conn, packet)
+		}
+		accepted <- err
+	}()
+
+	conn, err := Dial(WebSocket, listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	payload := bytes.Repeat([]byte{0x42}, 200000)
+	if err := protocol.EncodePacket(conn, protocol.NewPacket(protocol.PacketTypeVideoFrame, payload)); err != nil {
+		t.Fatalf("EncodePacket: %v", err)
+	}
+	packet, err := protocol.DecodePacket(conn)
+	if err != nil {
+		t.Fatalf("DecodePacket: %v", err)
+	}
+	if packet.Type != protocol.PacketTypeVideoFrame || !bytes.Equal(packet.Payload, payload) {
+		t.Errorf("echoed packet type %d with %d bytes, want video frame with %d bytes", packet.Type, len(packet.Payload), len(payload))
+	}
+	if err := <-accepted; err != nil {
+		t.Errorf("server: %v", err)
+	}
+}